@@ -4,15 +4,24 @@ package boot
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/square/finch"
+	"github.com/square/finch/builtin"
 	"github.com/square/finch/compute"
 	"github.com/square/finch/config"
+	"github.com/square/finch/data"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/lint"
+	"github.com/square/finch/schema"
+	"github.com/square/finch/stats"
 )
 
 func init() {
@@ -56,8 +65,39 @@ func Up(env Env) error {
 		return nil
 	}
 
+	// Set --quiet and --output before logging anything: with JSON output,
+	// STDOUT is only JSON, so log output goes to STDERR.
+	stats.Quiet = cmdline.Options.Quiet
+	switch cmdline.Options.Output {
+	case "", stats.OUTPUT_TEXT:
+	case stats.OUTPUT_JSON:
+		log.SetOutput(os.Stderr)
+	default:
+		return fmt.Errorf("invalid --output %s: valid values: %s, %s", cmdline.Options.Output, stats.OUTPUT_TEXT, stats.OUTPUT_JSON)
+	}
+	stats.Output = cmdline.Options.Output
+
 	log.Println(finch.SystemParams)
 
+	// Set --seed before data generators are made
+	if cmdline.Options.Seed != nil {
+		data.Seed = *cmdline.Options.Seed
+		data.Seeded = true
+	}
+
+	// Set --run-id and --tag before anything makes stats reporters
+	stats.RunId = cmdline.Options.RunId
+	if len(cmdline.Options.Tags) > 0 {
+		stats.Tags = map[string]string{}
+		for _, kv := range cmdline.Options.Tags {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return fmt.Errorf("invalid --tag %s: must be KEY=VAL", kv)
+			}
+			stats.Tags[k] = v
+		}
+	}
+
 	// Catch CTRL-C and cancel the main context, which should cause a clean shutdown
 	ctxFinch, cancelFinch := context.WithCancel(context.Background())
 	go func() {
@@ -82,33 +122,256 @@ func Up(env Env) error {
 		finch.CPUProfile = f
 	}
 
+	// --mem-profile and --block-profile are written after each stage, but create
+	// the files now to fail early if they can't be written
+	for _, file := range []string{cmdline.Options.MemProfile, cmdline.Options.BlockProfile} {
+		if file == "" {
+			continue
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+	finch.MemProfile = cmdline.Options.MemProfile
+	finch.BlockProfile = cmdline.Options.BlockProfile
+	finch.ResourceSummary = cmdline.Options.ResourceSummary
+
+	// --explain writes EXPLAIN plans before each stage (see Stage.Prepare)
+	switch cmdline.Options.Explain {
+	case "":
+		if cmdline.Options.ExplainAnalyze {
+			return fmt.Errorf("--explain-analyze requires --explain")
+		}
+	case "-":
+		finch.Explain = os.Stdout
+	default:
+		f, err := os.Create(cmdline.Options.Explain)
+		if err != nil {
+			return fmt.Errorf("--explain: %s", err)
+		}
+		defer f.Close()
+		finch.Explain = f
+	}
+	finch.ExplainAnalyze = cmdline.Options.ExplainAnalyze
+
 	//  If --client specified, run in client mode connected to a Finch server.
 	// In client mode, we don't need a config file because everything is fetched
 	// from the server.
 	if serverAddr := cmdline.Options.Client; serverAddr != "" {
-		clientName, _ := os.Hostname()
-		client := compute.NewClient(clientName, finch.WithPort(serverAddr, finch.DEFAULT_SERVER_PORT))
+		clientName := cmdline.Options.Name
+		if clientName == "" {
+			clientName, _ = os.Hostname()
+		}
+		tls := config.TLS{
+			CA:   cmdline.Options.TLSCA,
+			Cert: cmdline.Options.TLSCert,
+			Key:  cmdline.Options.TLSKey,
+		}
+		addr, err := compute.Auth(finch.WithPort(serverAddr, finch.DEFAULT_SERVER_PORT), tls, cmdline.Options.Token)
+		if err != nil {
+			return err
+		}
+		client := compute.NewClient(clientName, addr)
 		return client.Run(ctxFinch)
 	}
 
+	// --init DB[.TABLE,...] [DIR] writes stage and trx files for existing tables
+	if cmdline.Options.Init != "" {
+		dir := "."
+		if len(cmdline.Args) > 1 {
+			dir = cmdline.Args[1]
+		}
+		return initFiles(ctxFinch, cmdline.Options, dir)
+	}
+
+	// --capture DB [DIR] writes a stage and trx files for statement digests
+	if cmdline.Options.Capture != "" {
+		dir := "."
+		if len(cmdline.Args) > 1 {
+			dir = cmdline.Args[1]
+		}
+		return capture(ctxFinch, cmdline.Options, dir)
+	}
+
 	// ----------------------------------------------------------------------
 	// Server mode (default)
 
+	// --builtin stages run before stage files on the command line, if any
+	if cmdline.Options.Builtin != "" {
+		tmpdir, files, err := builtin.Extract(strings.Split(cmdline.Options.Builtin, ","))
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+		cmdline.Args = append(cmdline.Args[:1], append(files, cmdline.Args[1:]...)...)
+	}
+
 	// Load and validate all stage config files specified on the command line
 	if len(cmdline.Args) == 1 {
 		log.Fatal("No stage file specified. Run finch --help for usage. See https://square.github.io/finch/ for documentation.")
 	}
+
+	// --matrix params override --param. Options other than running the stages,
+	// like --lint and --list-params, use the first combination.
+	matrix, err := parseMatrix(cmdline.Options.Matrix)
+	if err != nil {
+		return err
+	}
+	params := append(append([]string{}, cmdline.Options.Params...), matrixRuns(matrix)[0]...)
+
+	// --lint checks the stage files and exits; it doesn't connect to MySQL
+	if cmdline.Options.Lint {
+		return printLint(lint.Files(cmdline.Args[1:], params))
+	}
 	stages, err := config.Load(
 		cmdline.Args[1:],
-		cmdline.Options.Params,
+		params,
 		cmdline.Options.DSN,
 		cmdline.Options.Database,
+		cmdline.Options.Profile,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// --list-params prints the params of each stage after --param and exits
+	if cmdline.Options.ListParams {
+		printParams(stages)
+		return nil
+	}
+
+	// --cleanup drops databases and tables created by the stages
+	if cmdline.Options.Cleanup {
+		return cleanup(ctxFinch, stages)
+	}
+
+	// --dry-run prints statements without connecting to MySQL or running the
+	// compute API (no remotes)
+	if cmdline.Options.DryRun > 0 {
+		return compute.NewServer("local", "", config.Compute{}, false).DryRun(stages, cmdline.Options.DryRun)
+	}
+
 	// Boot and run each stage specified on the command line
-	server := compute.NewServer("local", cmdline.Options.Server, cmdline.Options.Test)
+	// The compute API serves all stages, so it uses compute.tls and compute.token
+	// from the first stage
+	server := compute.NewServer("local", cmdline.Options.Server, stages[0].Compute, cmdline.Options.Test)
+	markOnSignal(server.Mark) // SIGUSR1 records a marker in stats
+	if len(matrix) > 0 && !cmdline.Options.Test {
+		return runMatrix(ctxFinch, server, matrix, cmdline.Args[1:], cmdline.Options)
+	}
 	return server.Run(ctxFinch, stages)
 }
+
+func printLint(problems []lint.Problem) error {
+	errors := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Error {
+			errors++
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d errors, %d warnings", errors, len(problems)-errors)
+	}
+	fmt.Printf("%d warnings\n", len(problems))
+	return nil
+}
+
+func printParams(stages []config.Stage) {
+	for i, s := range stages {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s)\n", s.Name, s.File)
+		if len(s.Params) == 0 {
+			fmt.Println("  no params")
+			continue
+		}
+		// Params set only by --param don't have a spec, so list all values
+		specs := map[string]config.ParamSpec{}
+		for k := range s.Params {
+			specs[k] = s.ParamSpecs[k]
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tTYPE\tDEFAULT\tVALUE\tHELP")
+		for _, k := range config.ParamNames(specs) {
+			p := specs[k]
+			if p.Type == "" {
+				p.Type = "string"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", k, p.Type, p.Default, s.Params[k], p.Help)
+		}
+		w.Flush()
+	}
+}
+
+func initFiles(ctx context.Context, opts Options, dir string) error {
+	db, tables, _ := strings.Cut(opts.Init, ".")
+	var tableList []string
+	if tables != "" {
+		tableList = strings.Split(tables, ",")
+	}
+
+	conn, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	all, err := schema.Inspect(ctx, conn, db, tableList)
+	if err != nil {
+		return err
+	}
+	files, err := schema.Write(dir, all)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	return err
+}
+
+func capture(ctx context.Context, opts Options, dir string) error {
+	sample := 10 * time.Second
+	if opts.CaptureTime != "" {
+		d, err := time.ParseDuration(opts.CaptureTime)
+		if err != nil {
+			return fmt.Errorf("invalid --capture-time: %s", err)
+		}
+		sample = d
+	}
+
+	conn, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if sample > 0 {
+		log.Printf("Sampling statement digests for %s...", sample)
+	}
+	all, err := schema.Digests(ctx, conn, opts.Capture, sample)
+	if err != nil {
+		return err
+	}
+	files, err := schema.WriteDigests(dir, all, schema.DefaultTop)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	return err
+}
+
+// connect connects to MySQL using only --dsn (no stage file) for --init and
+// --capture.
+func connect(ctx context.Context, opts Options) (*sql.DB, error) {
+	dbconn.SetConfig(config.MySQL{DSN: opts.DSN})
+	conn, dsnRedacted, err := dbconn.Make()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to MySQL failed: %s: %s", dsnRedacted, err)
+	}
+	return conn, nil
+}
@@ -124,3 +124,38 @@ func TestColumns(t *testing.T) {
 		t.Errorf("coltest1 row = '%s', expected '1,0x75'", t3)
 	}
 }
+
+func TestMatrix(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	defer os.Chdir(cwd)
+
+	dsn, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	env := boot.Env{
+		Args: []string{
+			"./finch", // fake like it was run from cmd line (required)
+			"--dsn", dsn,
+			"--matrix", "clients=1,2", "runtime=500ms",
+			"../test/run/matrix/test.yaml",
+		},
+	}
+
+	// 2 runs (clients=1 and clients=2) of 500ms each
+	t0 := time.Now()
+	err = boot.Up(env)
+	if err != nil {
+		t.Error(err)
+	}
+
+	d := time.Now().Sub(t0)
+	if d.Seconds() < 0.8 || d.Seconds() > 1.5 {
+		t.Errorf("ran for %.1f seconds, expected about 1.0 (2 runs of 0.5s)", d.Seconds())
+	}
+}
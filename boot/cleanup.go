@@ -0,0 +1,97 @@
+// Copyright 2024 Block, Inc.
+
+package boot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/square/finch/config"
+	"github.com/square/finch/data"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/trx"
+)
+
+// cleanup drops the databases and tables created by CREATE statements in the
+// trx files of all stages (--cleanup), so repeated benchmark runs don't need
+// hand-written cleanup stages. Stages are cleaned up in reverse order, each on
+// its own MySQL config, and each statement is printed before it's executed.
+func cleanup(ctx context.Context, stages []config.Stage) error {
+	// Databases created by any stage, so tables in them aren't dropped separately
+	objs := make([][]trx.Object, len(stages))
+	dbs := map[string]bool{}
+	for i, cfg := range stages {
+		if err := os.Chdir(filepath.Dir(cfg.File)); err != nil {
+			return err
+		}
+		set, err := trx.Load(cfg.Trx, data.NewScope(), cfg.Params)
+		if err != nil {
+			return fmt.Errorf("%s: %s", cfg.Name, err)
+		}
+		objs[i] = set.Created(cfg.MySQL.Db)
+		for _, o := range objs[i] {
+			if o.Type == "DATABASE" && !o.IfNotExists {
+				dbs[o.Name] = true
+			}
+		}
+	}
+
+	done := map[string]bool{}
+	n := 0
+	skipped := []trx.Object{}
+	for i := len(stages) - 1; i >= 0; i-- {
+		keep := []trx.Object{}
+		for _, o := range objs[i] {
+			if o.IfNotExists {
+				skipped = append(skipped, o)
+				continue
+			}
+			if o.Type == "TABLE" && dbs[o.Db] {
+				continue // dropped with database
+			}
+			keep = append(keep, o)
+		}
+		drops := []string{}
+		for _, q := range trx.DropStatements(keep) {
+			if !done[q] {
+				drops = append(drops, q)
+				done[q] = true
+			}
+		}
+		if len(drops) == 0 {
+			continue
+		}
+
+		dbconn.SetConfig(stages[i].MySQL)
+		db, dsnRedacted, err := dbconn.Make()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("-- %s (%s)\n", stages[i].Name, dsnRedacted)
+		for _, q := range drops {
+			fmt.Println(q)
+			if _, err := db.ExecContext(ctx, q); err != nil {
+				db.Close()
+				return fmt.Errorf("%s: %s", q, err)
+			}
+		}
+		db.Close()
+		n += len(drops)
+	}
+	fmt.Printf("-- %d objects dropped\n", n)
+	seen := map[trx.Object]bool{}
+	for _, o := range skipped {
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+		name := o.Name
+		if o.Type == "TABLE" && o.Db != "" {
+			name = o.Db + "." + name
+		}
+		fmt.Printf("-- skipped %s %s: created with IF NOT EXISTS, drop manually if needed\n", o.Type, name)
+	}
+	return nil
+}
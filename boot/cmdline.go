@@ -4,24 +4,51 @@ package boot
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/alexflint/go-arg"
 
 	"github.com/square/finch"
+	"github.com/square/finch/builtin"
 )
 
 // Options represents the command line options
 type Options struct {
-	Client     string `arg:"env:FINCH_CLIENT"`
-	CPUProfile string `arg:"--cpu-profile,env:FINCH_CPU_PROFILE"`
-	Database   string `arg:"-D,--database,env:FINCH_DB"`
-	Debug      bool   `arg:"env:FINCH_DEBUG"`
-	DSN        string `arg:"env:FINCH_DSN"`
-	Help       bool
-	Params     []string `arg:"-p,--param,separate"`
-	Server     string   `arg:"env:FINCH_SERVER"`
-	Test       bool     `arg:"env:FINCH_TEST"`
-	Version    bool
+	BlockProfile    string `arg:"--block-profile,env:FINCH_BLOCK_PROFILE"`
+	Builtin         string `arg:"env:FINCH_BUILTIN"`
+	Capture         string `arg:"env:FINCH_CAPTURE"`
+	CaptureTime     string `arg:"--capture-time,env:FINCH_CAPTURE_TIME"`
+	Cleanup         bool   `arg:"env:FINCH_CLEANUP"`
+	Client          string `arg:"env:FINCH_CLIENT"`
+	CPUProfile      string `arg:"--cpu-profile,env:FINCH_CPU_PROFILE"`
+	Database        string `arg:"-D,--database,env:FINCH_DB"`
+	Debug           bool   `arg:"env:FINCH_DEBUG"`
+	DryRun          uint   `arg:"--dry-run,env:FINCH_DRY_RUN"`
+	DSN             string `arg:"env:FINCH_DSN"`
+	Explain         string `arg:"env:FINCH_EXPLAIN"`
+	ExplainAnalyze  bool   `arg:"--explain-analyze,env:FINCH_EXPLAIN_ANALYZE"`
+	Help            bool
+	Init            string   `arg:"env:FINCH_INIT"`
+	Lint            bool     `arg:"env:FINCH_LINT"`
+	ListParams      bool     `arg:"--list-params,env:FINCH_LIST_PARAMS"`
+	Matrix          []string `arg:"--matrix,separate"`
+	MemProfile      string   `arg:"--mem-profile,env:FINCH_MEM_PROFILE"`
+	Name            string   `arg:"env:FINCH_NAME"`
+	Output          string   `arg:"env:FINCH_OUTPUT"`
+	Params          []string `arg:"-p,--param,separate"`
+	Profile         string   `arg:"env:FINCH_PROFILE"`
+	Quiet           bool     `arg:"env:FINCH_QUIET"`
+	ResourceSummary bool     `arg:"--resource-summary,env:FINCH_RESOURCE_SUMMARY"`
+	RunId           string   `arg:"--run-id,env:FINCH_RUN_ID"`
+	Seed            *int64   `arg:"env:FINCH_SEED"`
+	Server          string   `arg:"env:FINCH_SERVER"`
+	Tags            []string `arg:"--tag,separate"`
+	Test            bool     `arg:"env:FINCH_TEST"`
+	TLSCA           string   `arg:"--tls-ca,env:FINCH_TLS_CA"`
+	TLSCert         string   `arg:"--tls-cert,env:FINCH_TLS_CERT"`
+	TLSKey          string   `arg:"--tls-key,env:FINCH_TLS_KEY"`
+	Token           string   `arg:"env:FINCH_TOKEN"`
+	Version         bool
 }
 
 type CommandLine struct {
@@ -35,7 +62,7 @@ func ParseCommandLine(args []string) (CommandLine, error) {
 	if err != nil {
 		return c, err
 	}
-	if err := p.Parse(args); err != nil {
+	if err := p.Parse(matrixArgs(args)); err != nil {
 		switch err {
 		case arg.ErrHelp:
 			c.Help = true
@@ -48,24 +75,70 @@ func ParseCommandLine(args []string) (CommandLine, error) {
 	return c, nil
 }
 
+// matrixArgs returns args with --matrix before every KEY=VALS that follows a
+// --matrix, so "--matrix a=1,2 b=x" is the same as "--matrix a=1,2 --matrix b=x".
+// Stage files after --matrix KEY=VALS are not changed because they don't have =.
+func matrixArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	matrix := false // previous arg is a --matrix value
+	for i, a := range args {
+		if matrix && !strings.HasPrefix(a, "-") && strings.Contains(a, "=") {
+			out = append(out, "--matrix", a)
+			continue
+		}
+		matrix = i > 0 && args[i-1] == "--matrix"
+		out = append(out, a)
+	}
+	return out
+}
+
 func printHelp() {
 	fmt.Printf("Usage:\n"+
 		"  finch [options] STAGE_1_FILE [STAGE_N_FILE...]\n\n"+
 		"Options:\n"+
+		"  --block-profile FILE  Save goroutine blocking profile of stage execution to FILE\n"+
+		"  --builtin NAME[,NAME] Run built-in stages (see below)\n"+
+		"  --capture DB          Write stage and trx files for statement digests in DB to dir and exit\n"+
+		"  --capture-time D      Sample statement digests for duration D (default: 10s)\n"+
+		"  --cleanup             Drop databases and tables created by stage trx files and exit\n"+
 		"  --client ADDR[:PORT]  Run as client of server at ADDR\n"+
 		"  --cpu-profile FILE    Save CPU profile of stage execution to FILE\n"+
 		"  --database (-D) DB    Default database on connect\n"+
 		"  --debug               Print debug output to stderr\n"+
+		"  --dry-run N           Print N iterations of statements per client and exit\n"+
 		"  --dsn DSN             MySQL DSN (overrides stage files)\n"+
+		"  --explain FILE        EXPLAIN statements before each stage, write plans to FILE (- for stdout)\n"+
+		"  --explain-analyze     Use EXPLAIN ANALYZE for SELECT statements with --explain\n"+
 		"  --help                Print help and exit\n"+
-		"  --param (-p) KEY=VAL  Set param key=value (override stage files)\n"+
+		"  --init DB[.TABLE]     Write stage and trx files for tables in DB to dir and exit\n"+
+		"  --lint                Check stage files for problems and exit\n"+
+		"  --list-params         Print stage params (type, default, value, help) and exit\n"+
+		"  --matrix KEY=V1,V2    Run stages once per combination of param values and compare\n"+
+		"  --mem-profile FILE    Save memory allocation profile of stage execution to FILE\n"+
+		"  --name NAME           Client name (default: hostname)\n"+
+		"  --output FORMAT       Stats output format: text (default) or json (final summary)\n"+
+		"  --param (-p) KEY=VAL  Set param key=value, or @FILE of JSON/YAML params (override stage files)\n"+
+		"  --profile NAME        Apply profile NAME from _all.yaml\n"+
+		"  --quiet               Print only the final stats summary, not every interval\n"+
+		"  --resource-summary    Print Finch CPU, memory, and GC usage after each stage\n"+
+		"  --run-id ID           Run ID in all stats reports\n"+
+		"  --seed N              Seed random data generators for reproducible values\n"+
 		"  --server ADDR[:PORT]  Run as server on ADDR\n"+
+		"  --tag KEY=VAL         Tag key=value in all stats reports\n"+
 		"  --test                Validate stages, test connections, and exit\n"+
+		"  --tls-ca FILE         CA to verify server (client only)\n"+
+		"  --tls-cert FILE       Client TLS cert for mTLS (client only)\n"+
+		"  --tls-key FILE        Client TLS key for mTLS (client only)\n"+
+		"  --token TOKEN         Shared token for server (client only)\n"+
 		"  --version             Print version and exit\n"+
 		"\n"+
+		"Built-in stages:\n"+
+		"  %s\n"+
+		"\n"+
 		"Docs:\n"+
 		"  https://square.github.io/finch/\n\n"+
 		"finch %s\n",
+		strings.Join(builtin.Names(), ", "),
 		finch.VERSION,
 	)
 }
@@ -0,0 +1,175 @@
+// Copyright 2024 Block, Inc.
+
+package boot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	h "github.com/dustin/go-humanize"
+
+	"github.com/square/finch/compute"
+	"github.com/square/finch/config"
+	"github.com/square/finch/stats"
+)
+
+// matrixDim is one --matrix KEY=V1,V2,... dimension.
+type matrixDim struct {
+	key  string
+	vals []string
+}
+
+// parseMatrix parses --matrix options in command line order.
+func parseMatrix(opts []string) ([]matrixDim, error) {
+	dims := make([]matrixDim, 0, len(opts))
+	for _, kv := range opts {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid --matrix %s: must be KEY=VAL[,VAL...]", kv)
+		}
+		dims = append(dims, matrixDim{key: k, vals: strings.Split(v, ",")})
+	}
+	return dims, nil
+}
+
+// matrixRuns returns every combination of matrix values as key=val params. The
+// last dimension changes fastest, so the runs for --matrix a=1,2 --matrix b=x,y
+// are a=1 b=x, a=1 b=y, a=2 b=x, a=2 b=y.
+func matrixRuns(dims []matrixDim) [][]string {
+	runs := [][]string{{}}
+	for _, d := range dims {
+		next := make([][]string, 0, len(runs)*len(d.vals))
+		for _, r := range runs {
+			for _, v := range d.vals {
+				run := append(append([]string{}, r...), d.key+"="+v)
+				next = append(next, run)
+			}
+		}
+		runs = next
+	}
+	return runs
+}
+
+// matrixResult is the summary of one stage in one matrix run for the comparison.
+type matrixResult struct {
+	params []string // key=val
+	stage  string
+	in     stats.Instance
+	ok     bool // stats reported
+}
+
+// runMatrix runs the stages once for each combination of matrix values, which
+// are params that override --param. Each run is tagged (like --tag) with its
+// matrix values. After all runs, it prints a comparison of the summary of each
+// stage in each run, except stages with stats disabled (config.stats.disable).
+func runMatrix(ctxFinch context.Context, server *compute.Server, dims []matrixDim, files []string, opts Options) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	runs := matrixRuns(dims)
+	baseTags := stats.Tags
+	results := make([]matrixResult, 0, len(runs))
+	nRuns := 0
+	for i, params := range runs {
+		// Server.Run changes dir to each stage file dir, and stage files are
+		// relative to the original dir
+		if err := os.Chdir(cwd); err != nil {
+			return err
+		}
+		stages, err := config.Load(files, append(append([]string{}, opts.Params...), params...), opts.DSN, opts.Database, opts.Profile)
+		if err != nil {
+			return fmt.Errorf("matrix run %d (%s): %s", i+1, strings.Join(params, " "), err)
+		}
+
+		stats.Tags = map[string]string{}
+		for k, v := range baseTags {
+			stats.Tags[k] = v
+		}
+		for _, kv := range params {
+			k, v, _ := strings.Cut(kv, "=")
+			stats.Tags[k] = v
+		}
+		log.Printf("Matrix run %d of %d: %s", i+1, len(runs), strings.Join(params, " "))
+		nRuns++
+		for _, s := range stages {
+			// Summarize each stage separately because stages usually differ,
+			// like a setup stage then a benchmark stage
+			sum := stats.NewSummary() // after setting tags
+			server.AddReporter(sum)
+			err = server.Run(ctxFinch, []config.Stage{s})
+			server.RemoveReporter(sum)
+			if err != nil {
+				return err
+			}
+			if !config.True(s.Stats.Disable) {
+				in, ok := sum.Instance()
+				results = append(results, matrixResult{params: params, stage: s.Name, in: in, ok: ok})
+			}
+			if ctxFinch.Err() != nil {
+				break
+			}
+		}
+		if ctxFinch.Err() != nil {
+			break
+		}
+	}
+	stats.Tags = baseTags
+	if stats.Output != stats.OUTPUT_JSON { // STDOUT is only JSON; each run summary has its tags
+		printMatrix(dims, nRuns, results)
+	}
+	return nil
+}
+
+// printMatrix prints one line per stage per matrix run to compare the summaries.
+func printMatrix(dims []matrixDim, runs int, results []matrixResult) {
+	sP, p, _ := stats.ParsePercentiles("") // default
+	fmt.Printf("#\n# Matrix (%d runs)\n#\n", runs)
+	w := tabwriter.NewWriter(os.Stdout, 1, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	header := []string{}
+	for _, d := range dims {
+		header = append(header, d.key)
+	}
+	header = append(header, "stage", "runtime", "clients", "QPS", "min")
+	header = append(header, sP...)
+	header = append(header, "max", "TPS", "errors")
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+	for _, r := range results {
+		line := []string{}
+		for _, kv := range r.params {
+			_, v, _ := strings.Cut(kv, "=")
+			line = append(line, v)
+		}
+		line = append(line, r.stage)
+		if !r.ok || r.in.Seconds == 0 {
+			line = append(line, "(no stats)")
+			fmt.Fprintln(w, strings.Join(line, "\t")+"\t")
+			continue
+		}
+		s := r.in.Total
+		var errorCount uint64
+		for _, v := range s.Errors {
+			errorCount += v
+		}
+		line = append(line,
+			fmt.Sprintf("%.1f", r.in.Seconds),
+			fmt.Sprintf("%d", r.in.Clients),
+			h.Comma(int64(float64(s.N[stats.TOTAL])/r.in.Seconds)),
+			h.Comma(s.Min[stats.TOTAL]),
+		)
+		for _, v := range s.Percentiles(stats.TOTAL, p) {
+			line = append(line, h.Comma(int64(v)))
+		}
+		line = append(line,
+			h.Comma(s.Max[stats.TOTAL]),
+			h.Comma(int64(float64(s.N[stats.COMMIT])/r.in.Seconds)),
+			h.Comma(int64(errorCount)),
+		)
+		fmt.Fprintln(w, strings.Join(line, "\t")+"\t")
+	}
+	w.Flush()
+}
@@ -0,0 +1,8 @@
+// Copyright 2024 Block, Inc.
+
+//go:build !unix
+
+package boot
+
+// markOnSignal is a no-op because SIGUSR1 is not supported. Use POST /mark.
+func markOnSignal(mark func(string) error) {}
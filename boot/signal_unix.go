@@ -0,0 +1,30 @@
+// Copyright 2024 Block, Inc.
+
+//go:build unix
+
+package boot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// markOnSignal calls mark on SIGUSR1 with a numbered marker name ("SIGUSR1 1",
+// "SIGUSR1 2", and so on), so external tools can record markers in the stats
+// without the compute API.
+func markOnSignal(mark func(string) error) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		n := 0
+		for range c {
+			n++
+			if err := mark(fmt.Sprintf("SIGUSR1 %d", n)); err != nil {
+				log.Printf("Ignoring SIGUSR1: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Block, Inc.
+
+// Package builtin provides benchmarks built into the binary (--builtin), like
+// the standard sysbench OLTP workloads, so they can run without local files.
+package builtin
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// files are the built-in files. The all: prefix is required to embed _all.yaml.
+//
+//go:embed all:sysbench
+var files embed.FS
+
+// dir is the embedded dir with the built-in stage files and their trx files.
+const dir = "sysbench"
+
+// Names returns the names of the built-in stages, sorted.
+func Names() []string {
+	entries, _ := fs.ReadDir(files, dir)
+	names := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".yaml") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract writes all built-in files to a new temp dir and returns the stage
+// files for names, in order. The files are extracted because stages and trx
+// are loaded from files. The caller should remove dir when done.
+func Extract(names []string) (tmpdir string, stageFiles []string, err error) {
+	valid := map[string]bool{}
+	for _, name := range Names() {
+		valid[name] = true
+	}
+	for _, name := range names {
+		if !valid[name] {
+			return "", nil, fmt.Errorf("unknown built-in stage: %s (valid: %s)", name, strings.Join(Names(), ", "))
+		}
+	}
+
+	tmpdir, err = os.MkdirTemp("", "finch-builtin-")
+	if err != nil {
+		return "", nil, err
+	}
+	err = fs.WalkDir(files, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(tmpdir, strings.TrimPrefix(path, dir))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		bytes, err := files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, bytes, 0644)
+	})
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", nil, err
+	}
+
+	stageFiles = make([]string, len(names))
+	for i, name := range names {
+		stageFiles[i] = filepath.Join(tmpdir, name+".yaml")
+	}
+	return tmpdir, stageFiles, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 Block, Inc.
+
+package builtin_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/square/finch/builtin"
+	"github.com/square/finch/lint"
+)
+
+func TestExtract(t *testing.T) {
+	names := builtin.Names()
+	if len(names) == 0 {
+		t.Fatal("no built-in stages")
+	}
+
+	tmpdir, files, err := builtin.Extract(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if len(files) != len(names) {
+		t.Fatalf("got %d files, expected %d", len(files), len(names))
+	}
+
+	// All built-in stages must be valid and lint clean
+	for _, p := range lint.Files(files, nil) {
+		t.Error(p)
+	}
+
+	if _, _, err := builtin.Extract([]string{"oltp_nope"}); err == nil {
+		t.Error("no error for unknown built-in stage")
+	}
+}
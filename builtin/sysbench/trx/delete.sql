@@ -0,0 +1,9 @@
+BEGIN
+
+-- prepare
+DELETE FROM sbtest.sbtest1 WHERE id=@del_id
+
+-- prepare
+INSERT INTO sbtest.sbtest1 (id, k, c, pad) VALUES (@del_id, @k, @c, @pad)
+
+COMMIT
@@ -0,0 +1,21 @@
+BEGIN
+
+-- if @p % 100 < $params.hot-pct
+SELECT c FROM sbtest.sbtest1 WHERE id=@hot1 FOR UPDATE
+
+-- if @p % 100 < $params.hot-pct
+SELECT c FROM sbtest.sbtest1 WHERE id=@hot2 FOR UPDATE
+
+-- if @p % 100 < $params.hot-pct
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@hot1
+
+-- if @p % 100 < $params.hot-pct
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@hot2
+
+-- if @p % 100 >= $params.hot-pct
+SELECT c FROM sbtest.sbtest1 WHERE id=@id FOR UPDATE
+
+-- if @p % 100 >= $params.hot-pct
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@id
+
+COMMIT
@@ -0,0 +1,3 @@
+-- prepare
+-- rows: ${params.rows}
+INSERT INTO sbtest.sbtest1 VALUES /*!csv 1000 (NULL, @k, @c, @pad) */
@@ -0,0 +1,2 @@
+-- prepare
+INSERT INTO sbtest.sbtest1 (id, k, c, pad) VALUES (NULL, @k, @c, @pad)
@@ -0,0 +1,2 @@
+-- prepare
+SELECT c FROM sbtest.sbtest1 WHERE id=@id
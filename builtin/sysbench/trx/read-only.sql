@@ -0,0 +1,19 @@
+BEGIN
+
+-- prepare
+-- copies: 10
+SELECT c FROM sbtest.sbtest1 WHERE id=@id
+
+-- prepare
+SELECT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV
+
+-- prepare
+SELECT SUM(k) FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV
+
+-- prepare
+SELECT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV ORDER BY c
+
+-- prepare
+SELECT DISTINCT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV ORDER BY c
+
+COMMIT
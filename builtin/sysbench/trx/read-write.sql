@@ -0,0 +1,31 @@
+BEGIN
+
+-- prepare
+-- copies: 10
+SELECT c FROM sbtest.sbtest1 WHERE id=@id
+
+-- prepare
+SELECT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV
+
+-- prepare
+SELECT SUM(k) FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV
+
+-- prepare
+SELECT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV ORDER BY c
+
+-- prepare
+SELECT DISTINCT c FROM sbtest.sbtest1 WHERE id BETWEEN @id_100 AND @PREV ORDER BY c
+
+-- prepare
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@id
+
+-- prepare
+UPDATE sbtest.sbtest1 SET c=@c WHERE id=@id
+
+-- prepare
+DELETE FROM sbtest.sbtest1 WHERE id=@del_id
+
+-- prepare
+INSERT INTO sbtest.sbtest1 (id, k, c, pad) VALUES (@del_id, @k, @c, @pad)
+
+COMMIT
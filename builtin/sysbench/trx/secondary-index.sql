@@ -0,0 +1,3 @@
+ALTER TABLE sbtest.sbtest1 ADD INDEX `k_1` (`k`)
+
+ANALYZE TABLE sbtest.sbtest1
@@ -0,0 +1,2 @@
+-- prepare
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@id
@@ -0,0 +1,2 @@
+-- prepare
+UPDATE sbtest.sbtest1 SET c=@c WHERE id=@id
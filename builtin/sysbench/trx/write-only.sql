@@ -0,0 +1,15 @@
+BEGIN
+
+-- prepare
+UPDATE sbtest.sbtest1 SET k=k+1 WHERE id=@id
+
+-- prepare
+UPDATE sbtest.sbtest1 SET c=@c WHERE id=@id
+
+-- prepare
+DELETE FROM sbtest.sbtest1 WHERE id=@del_id
+
+-- prepare
+INSERT INTO sbtest.sbtest1 (id, k, c, pad) VALUES (@del_id, @k, @c, @pad)
+
+COMMIT
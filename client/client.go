@@ -7,8 +7,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math/rand"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -16,6 +20,8 @@ import (
 
 	"github.com/square/finch"
 	"github.com/square/finch/data"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/limit"
 	"github.com/square/finch/stats"
 	"github.com/square/finch/trx"
 )
@@ -35,16 +41,38 @@ type Client struct {
 	RunLevel   finch.RunLevel
 	Statements []*trx.Statement
 	Stats      []*stats.Trx `deep:"-"`
+	Labels     []*stats.Trx `deep:"-"` // per statement: -- label stats, or nil
 
 	// Optional, usually from stage config
+	Reader           *sql.DB `deep:"-"` // mysql.reader for -- reader statements
 	DefaultDb        string
+	Warnings         bool // SHOW WARNINGS after every statement (config.mysql.warnings), else only -- warnings
 	IterExecGroup    uint32
 	IterExecGroupPtr *uint32
 	IterClients      uint32
 	IterClientsPtr   *uint32
+	IterGlobal       *limit.SharedIter
+	IterProgress     *limit.Iter `deep:"-"` // exec group iterations for stats progress, if set
 	Iter             uint
+	IterDelay        time.Duration // think time between iterations
+	IterJitter       time.Duration // IterDelay +/- random jitter
 	QPS              <-chan bool
+	Arrivals         *limit.Arrivals // open-loop arrivals; if set, Iter* limits still apply
 	TPS              <-chan bool
+	TPSRefund        func()            // refund TPS allowance if MySQL trx doesn't commit (limit.Rate.Refund)
+	Throttle         *limit.Throttle   // config.stage.throttle
+	Pattern          []*limit.Throttle // config.stage.pattern and config.stage.workload.pattern on/off (limit.Pattern)
+	QueryLog         *QueryLog
+	Tracer           *Tracer
+	Weights          []uint           // per trx; if set, each iter executes 1 trx chosen by weight
+	Retry            []uint           // per trx; if set, retry trx N times on error
+	Clock            func() time.Time // response time clock (default time.Now)
+	Nanoseconds      bool             // record response times in ns instead of μs
+	Measure          byte             // MeasureAll (default), MeasureSampled, or MeasureNone
+	MeasureSample    uint             // measure 1 in MeasureSample queries if MeasureSampled
+	Interval         int64            // expected interval between measured queries for coordinated omission correction
+	Session          string           // SET SESSION statement executed after connecting
+	InFlight         *atomic.Int32    // set to 1 while executing a query if stats enabled (stats.Collector.InFlight)
 
 	// Retrun value to DoneChane
 	Error Error
@@ -52,9 +80,34 @@ type Client struct {
 	// --
 	ps     []*sql.Stmt
 	values [][]interface{}
+	tmpl   []*Template // per statement: compiled query if not prepared
+	qbuf   []byte      // Template.Format buffer
 	conn   *sql.Conn
+	rconn  *sql.Conn       // Reader conn
+	sconn  []*sql.Conn     // per statement: conn or rconn (-- reader)
+	qlogN  uint            // queries since last logged (QueryLog.Sample)
+	traceN uint            // queries since last traced (Tracer.Sample)
+	measN  uint            // queries since last measured (MeasureSample)
+	clock  []bool          // per statement: time even if not measured (query log, trace, verify)
+	warned map[uint16]bool // MySQL warning codes logged (SHOW WARNINGS)
+	// Weights and Retry
+	trxStart  []int // statement index where each trx starts, plus len(Statements)
+	weightSum uint
+	rand      *rand.Rand
+	// Script
+	Script      *Script // config.stage.workload.script; if set, Statements are not used
+	scriptQuery string  // last query executed by the script
 }
 
+// Response time measurement (config.stage.workload.measure). Queries are always
+// counted, but only measured queries are timed and recorded in the response time
+// stats (min, max, percentiles).
+const (
+	MeasureAll     byte = iota // time every query (default)
+	MeasureSampled             // time 1 in MeasureSample queries
+	MeasureNone                // don't time queries
+)
+
 type Error struct {
 	Err         error
 	StatementNo int
@@ -62,24 +115,149 @@ type Error struct {
 
 type StatementData struct {
 	Inputs      []data.ValueFunc `deep:"-"` // input to query
+	Previews    []data.ValueFunc `deep:"-"` // like Inputs but don't change generator state (Explain)
 	Outputs     []interface{}    `deep:"-"` // output from query; values are data.Generator
 	InsertId    data.Generator   `deep:"-"`
+	If          data.ValueFunc   `deep:"-"` // value for trx.Statement.If
 	TrxBoundary byte
 }
 
 func (c *Client) Init() error {
 	c.ps = make([]*sql.Stmt, len(c.Statements))
 	c.values = make([][]interface{}, len(c.Statements))
+	c.sconn = make([]*sql.Conn, len(c.Statements))
+	c.tmpl = make([]*Template, len(c.Statements))
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	c.clock = make([]bool, len(c.Statements))
 	for i, s := range c.Statements {
+		c.clock[i] = c.QueryLog != nil || c.Tracer != nil || s.Verify != ""
 		if len(s.Inputs) > 0 {
 			c.values[i] = make([]interface{}, len(s.Inputs))
 		}
+		if !s.Prepare {
+			var t *Template
+			if s.CSV != nil {
+				t = NewBatchTemplate(s.CSV)
+			} else {
+				t = NewTemplate(s.Query)
+			}
+			if t != nil && t.Values() == len(s.Inputs) {
+				c.tmpl[i] = t
+			}
+		}
+		if s.Reader && c.Reader == nil {
+			return fmt.Errorf("statement %d uses reader but mysql.reader is not set", i+1)
+		}
 	}
 	c.Error = Error{}
+
+	if c.Weights != nil || c.Retry != nil {
+		c.trxStart = make([]int, 0, len(c.Stats)+1)
+		for i := range c.Data {
+			if c.Data[i].TrxBoundary&trx.BEGIN != 0 {
+				c.trxStart = append(c.trxStart, i)
+			}
+		}
+		c.trxStart = append(c.trxStart, len(c.Statements))
+	}
+	if c.Retry != nil && len(c.trxStart) != len(c.Retry)+1 {
+		return fmt.Errorf("%d retries for %d trx", len(c.Retry), len(c.trxStart)-1)
+	}
+	if c.Weights != nil {
+		if len(c.trxStart) != len(c.Weights)+1 {
+			return fmt.Errorf("%d weights for %d trx", len(c.Weights), len(c.trxStart)-1)
+		}
+		c.weightSum = 0
+		for _, w := range c.Weights {
+			c.weightSum += w
+		}
+		if c.weightSum == 0 {
+			return fmt.Errorf("all trx weights are zero")
+		}
+	}
+	if c.Weights != nil || c.IterJitter > 0 {
+		seed := time.Now().UnixNano()
+		if data.Seeded { // --seed: same trx sequence every run
+			h := fnv.New64a()
+			h.Write([]byte(c.RunLevel.ClientId()))
+			seed = data.Seed ^ int64(h.Sum64())
+		}
+		c.rand = rand.New(rand.NewSource(seed))
+	}
 	return nil
 }
 
-func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive bool) error {
+// nextIter starts the next iteration. It returns false if an iteration limit is
+// reached or ctx is done (runtime elapsed or CTRL-C), else it increments
+// rc[data.ITER] and returns the intended start time of the iteration if
+// open-loop (Arrivals).
+func (c *Client) nextIter(ctx context.Context, rc *data.RunCount) (arrival time.Time, ok bool) {
+	// Check limits from most local to most global so a client that's done
+	// doesn't consume a shared iteration, especially a global one that's
+	// requested from the server
+	if c.Iter > 0 && rc[data.ITER] == c.Iter {
+		return arrival, false
+	}
+	if c.IterExecGroup > 0 && atomic.AddUint32(c.IterExecGroupPtr, 1) > c.IterExecGroup {
+		return arrival, false
+	}
+	if c.IterClients > 0 && atomic.AddUint32(c.IterClientsPtr, 1) > c.IterClients {
+		return arrival, false
+	}
+	if c.IterGlobal != nil && !c.IterGlobal.More(ctx) {
+		return arrival, false
+	}
+	if c.IterDelay > 0 && rc[data.ITER] > 0 && !c.delay(ctx) {
+		return arrival, false // think time between iterations, not before the first
+	}
+	if c.Arrivals != nil {
+		select {
+		case arrival = <-c.Arrivals.Next():
+		case <-ctx.Done():
+			return arrival, false
+		}
+	}
+	rc[data.ITER] += 1
+	if c.IterProgress != nil {
+		c.IterProgress.Inc()
+	}
+	return arrival, true
+}
+
+// delay sleeps IterDelay +/- random IterJitter. It returns false if ctx is
+// done (runtime elapsed or CTRL-C) before the delay elapses.
+func (c *Client) delay(ctx context.Context) bool {
+	d := c.IterDelay
+	if c.IterJitter > 0 {
+		d += time.Duration(c.rand.Int63n(2*int64(c.IterJitter)+1)) - c.IterJitter
+	}
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
+
+// pick returns the trx number (index into Weights) chosen randomly by weight.
+func (c *Client) pick() int {
+	r := uint(c.rand.Int63n(int64(c.weightSum)))
+	for i, w := range c.Weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(c.Weights) - 1 // not reached
+}
+
+// Connect connects to MySQL, or reconnects or recovers on query error (cerr).
+// If inTrx is true, a MySQL trx is active (BEGIN without COMMIT or ROLLBACK).
+func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, inTrx bool) error {
 	if ctx.Err() != nil { // finch terminated (CTRL-C)?
 		return ctx.Err()
 	}
@@ -91,17 +269,17 @@ func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive
 	// Connect called due to error on query execution?
 	if cerr != nil {
 		errFlags, handled := finch.MySQLErrorHandling[myerr.MySQLErrorCode(cerr)]
-		if c.Statements[stmtNo].DDL && !handled {
+		if stmtNo >= 0 && c.Statements[stmtNo].DDL && !handled {
 			return fmt.Errorf("DDL: %s", cerr)
 		}
 		if handled {
 			if errFlags&finch.Eabort != 0 {
 				return cerr // stop client
 			}
-			if errFlags&finch.Erollback != 0 && trxActive {
+			if errFlags&finch.Erollback != 0 && inTrx {
 				finch.Debug("%s: rollback", c.RunLevel.ClientId())
 				if _, err := c.conn.ExecContext(ctx, "ROLLBACK"); err != nil {
-					return fmt.Errorf("ROLLBACK failed: %s (on err: %s) (query: %s)", err, cerr, c.Statements[stmtNo].Query)
+					return fmt.Errorf("ROLLBACK failed: %s (on err: %s) (query: %s)", err, cerr, c.queryText(stmtNo))
 				}
 			}
 			if errFlags&finch.Econtinue != 0 {
@@ -109,26 +287,30 @@ func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive
 			}
 		}
 		silent = (errFlags&finch.Esilent != 0) // log the error (here and below)? uhandled errors are logged
+		if errors.Is(cerr, context.DeadlineExceeded) {
+			// Client-side statement timeout (-- timeout): the driver closed
+			// the conn, so reconnect, but it's expected so don't log it
+			silent = true
+		}
 		if !silent {
-			log.Printf("Client %s reconnect on error: %s (%s)", c.RunLevel.ClientId(), cerr, c.Statements[stmtNo].Query)
+			log.Printf("Client %s reconnect on error: %s (%s)", c.RunLevel.ClientId(), cerr, c.queryText(stmtNo))
 		}
 	}
 
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
+		if c.rconn != nil {
+			c.rconn.Close()
+			c.rconn = nil
+		}
 		time.Sleep(ConnectRetryWait)
 	}
 
 	t0 := time.Now()
-	for ctx.Err() == nil {
-		ctxConn, cancel := context.WithTimeout(ctx, ConnectTimeout)
-		c.conn, _ = c.DB.Conn(ctxConn)
-		cancel()
-		if c.conn != nil {
-			break // success
-		}
-		time.Sleep(ConnectRetryWait)
+	c.conn = connect(ctx, c.DB)
+	if c.Reader != nil {
+		c.rconn = connect(ctx, c.Reader)
 	}
 
 	if ctx.Err() != nil { // finch terminated (CTRL-C)?
@@ -140,10 +322,34 @@ func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive
 	}
 
 	if c.DefaultDb != "" {
-		_, err := c.conn.ExecContext(ctx, "USE `"+c.DefaultDb+"`")
+		_, err := c.conn.ExecContext(ctx, "USE "+dbconn.QuoteIdent(c.DefaultDb))
 		if err != nil {
 			return err
 		}
+		if c.rconn != nil {
+			if _, err := c.rconn.ExecContext(ctx, "USE "+dbconn.QuoteIdent(c.DefaultDb)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.Session != "" {
+		if _, err := c.conn.ExecContext(ctx, c.Session); err != nil {
+			return fmt.Errorf("session: %s: %s", c.Session, err)
+		}
+		if c.rconn != nil {
+			if _, err := c.rconn.ExecContext(ctx, c.Session); err != nil {
+				return fmt.Errorf("session: %s: %s", c.Session, err)
+			}
+		}
+	}
+
+	for i, s := range c.Statements {
+		if s.Reader {
+			c.sconn[i] = c.rconn
+		} else {
+			c.sconn[i] = c.conn
+		}
 	}
 
 	var err error
@@ -154,7 +360,7 @@ func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive
 		if c.ps[i] != nil {
 			continue // prepare multi
 		}
-		c.ps[i], err = c.conn.PrepareContext(ctx, s.Query)
+		c.ps[i], err = c.sconn[i].PrepareContext(ctx, s.Query)
 		if err != nil {
 			c.Error.StatementNo = i
 			return fmt.Errorf("prepare: %s", err)
@@ -170,14 +376,98 @@ func (c *Client) Connect(ctx context.Context, cerr error, stmtNo int, trxActive
 	return nil
 }
 
+// showWarnings executes SHOW WARNINGS after statement stmtNo and counts the
+// warnings (Stats.MySQLWarnings). The first warning of each code is logged, so
+// a statement that always warns, like truncating data, doesn't flood the log.
+// Notes are ignored.
+func (c *Client) showWarnings(ctx context.Context, stmtNo, trxNo int) error {
+	rows, err := c.sconn[stmtNo].QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var level, msg string
+	var code uint16
+	var n uint64
+	for rows.Next() {
+		if err := rows.Scan(&level, &code, &msg); err != nil {
+			return err
+		}
+		if level == "Note" {
+			continue
+		}
+		n++
+		if c.warned == nil {
+			c.warned = map[uint16]bool{}
+		}
+		if !c.warned[code] {
+			c.warned[code] = true
+			log.Printf("Client %s MySQL warning %d: %s (query: %s) (first warning of this code; others counted, not logged)",
+				c.RunLevel.ClientId(), code, msg, c.queryText(stmtNo))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n > 0 {
+		if c.Stats[trxNo] != nil {
+			c.Stats[trxNo].MySQLWarnings(n)
+		}
+		if c.Labels != nil && c.Labels[stmtNo] != nil {
+			c.Labels[stmtNo].MySQLWarnings(n)
+		}
+	}
+	return nil
+}
+
+// ErrorQuery returns the query of the statement that caused Error: the last
+// script query if the client runs a script, or "" if not known.
+func (c *Client) ErrorQuery() string {
+	if c.Script != nil {
+		return c.scriptQuery
+	}
+	if n := c.Error.StatementNo; n >= 0 && n < len(c.Statements) {
+		return c.Statements[n].Query
+	}
+	return ""
+}
+
+// queryText returns the query of statement stmtNo, or the last script query if
+// stmtNo is -1 (Script).
+func (c *Client) queryText(stmtNo int) string {
+	if stmtNo < 0 {
+		return c.scriptQuery
+	}
+	return c.Statements[stmtNo].Query
+}
+
+// connect returns a connection from db, retrying until successful or ctx is
+// done, in which case it returns nil.
+func connect(ctx context.Context, db *sql.DB) *sql.Conn {
+	for ctx.Err() == nil {
+		ctxConn, cancel := context.WithTimeout(ctx, ConnectTimeout)
+		conn, _ := db.Conn(ctxConn)
+		cancel()
+		if conn != nil {
+			return conn // success
+		}
+		time.Sleep(ConnectRetryWait)
+	}
+	return nil
+}
+
 func (c *Client) Run(ctxExec context.Context) {
 	finch.Debug("run client %s: %d stmts, iter %d/%d/%d", c.RunLevel.ClientId(), len(c.Statements), c.IterExecGroup, c.IterClients, c.Iter)
 	var err error
 	defer func() {
 		if r := recover(); r != nil {
-			b := make([]byte, 4096)
-			n := runtime.Stack(b, false)
-			err = fmt.Errorf("PANIC: %v\n%s", r, string(b[0:n]))
+			if verr, ok := r.(data.ValueError); ok {
+				err = verr.Err // data generator failed, not a bug
+			} else {
+				b := make([]byte, 4096)
+				n := runtime.Stack(b, false)
+				err = fmt.Errorf("PANIC: %v\n%s", r, string(b[0:n]))
+			}
 		}
 		for i := range c.ps {
 			if c.ps[i] == nil {
@@ -188,6 +478,9 @@ func (c *Client) Run(ctxExec context.Context) {
 		if c.conn != nil {
 			c.conn.Close()
 		}
+		if c.rconn != nil {
+			c.rconn.Close()
+		}
 		// Context cancellation is not an error it's runtime elapsing or CTRL-C
 		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
 			c.Error.Err = err
@@ -195,6 +488,16 @@ func (c *Client) Run(ctxExec context.Context) {
 		c.DoneChan <- c
 	}()
 
+	// Open-loop clients connect only when arrivals are queued, so the number of
+	// connections grows as needed
+	if c.Arrivals != nil {
+		select {
+		case <-c.Arrivals.Spawn():
+		case <-ctxExec.Done():
+			return
+		}
+	}
+
 	if err = c.Connect(ctxExec, nil, -1, false); err != nil {
 		return
 	}
@@ -208,39 +511,79 @@ func (c *Client) Run(ctxExec context.Context) {
 	rc[data.EXEC_GROUP] = c.RunLevel.ExecGroup
 	rc[data.STAGE] = c.RunLevel.Stage
 
+	if c.Script != nil {
+		err = c.runScript(ctxExec, &rc)
+		return
+	}
+
 	var rows *sql.Rows
 	var res sql.Result
 	var t time.Time
+	var timed bool         // measure response time (c.Measure)
+	var nRows uint64       // rows read by SELECT
+	var visible bool       // -- verify read a row (not counted in nRows)
+	var arrival time.Time  // intended start time of iteration (c.Arrivals)
+	var trxT time.Time     // start time of trx file (stats.TRX_FILE)
+	var idle time.Duration // idle time in trx file, excluded from trx file time
+
+	// ctx is ctxExec or, for a statement with -- timeout, a child context with
+	// a deadline; cancel is non-nil only for the latter
+	var ctx context.Context
+	var cancel context.CancelFunc
 
 	// trxNo indexes into c.Stats and resets to 0 on each iteration. Remember:
 	// these are finch trx (files), not MySQL trx, so trx boundaries mark the
 	// beginning and end of a finch trx (file). User is expected to make finch
 	// trx boundaries meaningful.
 	trxNo := -1
-	trxActive := false
+
+	// MySQL trx state: inTrx is true after BEGIN until COMMIT or ROLLBACK, and
+	// savepoint is the index of the statement that set the last savepoint in
+	// the MySQL trx (-- savepoint), else -1. Only an error on that statement
+	// rolls back to the savepoint. On error, Connect rolls back the
+	// MySQL trx so the next BEGIN doesn't implicitly commit a partial trx.
+	inTrx := false
+	savepoint := -1
+
+	// refund is true after BEGIN took a TPS allowance until the MySQL trx
+	// commits, if TPSRefund is set: if the trx rolls back (explicitly or on
+	// error), the allowance is refunded because it didn't produce a commit
+	refund := false
+
+	// retries of the current trx (c.Retry), and retry is true when restarting
+	// the trx after an error
+	var retries uint
+	retry := false
 
 	//
 	// CRITICAL LOOP: no debug or superfluous function calls
 	//
 ITER:
 	for {
-		if c.IterExecGroup > 0 && atomic.AddUint32(c.IterExecGroupPtr, 1) > c.IterExecGroup {
+		var ok bool
+		if arrival, ok = c.nextIter(ctxExec, &rc); !ok {
 			return
 		}
-		if c.IterClients > 0 && atomic.AddUint32(c.IterClientsPtr, 1) > c.IterClients {
-			return
+		trxNo = -1
+
+		// All trx, or 1 trx chosen by weight
+		first, last := 0, len(c.Statements)
+		if c.Weights != nil {
+			t := c.pick()
+			first, last = c.trxStart[t], c.trxStart[t+1]
+			trxNo = t - 1 // += 1 on trx.BEGIN
 		}
-		if c.Iter > 0 && rc[data.ITER] == c.Iter {
-			return
+
+		// Queueing delay is recorded in stats of the first trx in the iteration
+		if c.Arrivals != nil && c.Stats[trxNo+1] != nil {
+			c.Stats[trxNo+1].Queue(time.Since(arrival).Microseconds())
 		}
-		rc[data.ITER] += 1
-		trxNo = -1
-		trxActive = false
 
-		for i := range c.Statements {
+		for i := first; i < last; i++ {
 			// Idle time
 			if c.Statements[i].Idle != 0 {
 				time.Sleep(c.Statements[i].Idle)
+				idle += c.Statements[i].Idle
 				continue
 			}
 
@@ -250,14 +593,40 @@ ITER:
 			if c.Data[i].TrxBoundary&trx.BEGIN != 0 {
 				rc[data.TRX] += 1
 				trxNo += 1
-				trxActive = true
-			} else if c.Data[i].TrxBoundary&trx.END != 0 {
-				trxActive = false
+				if retry {
+					retry = false // trx file time includes failed attempts
+				} else {
+					retries = 0
+					trxT = c.Clock()
+					idle = 0
+				}
+			}
+
+			// Skip statement if its condition (-- if) is false. Count the
+			// statement first so a statement-scoped @d in the condition has
+			// the same value as in the statement.
+			rc[data.STATEMENT] += 1
+			if c.Data[i].If != nil && !c.Statements[i].If.True(c.Data[i].If(rc)[0]) {
+				if c.Data[i].TrxBoundary&trx.END != 0 && c.Stats[trxNo] != nil {
+					c.recordTrx(trxNo, trxT, idle)
+				}
+				continue
 			}
 
 			// If BEGIN, check TPS rate limiter
 			if c.TPS != nil && c.Statements[i].Begin {
 				<-c.TPS
+				refund = c.TPSRefund != nil
+			}
+
+			// If throttled (config.stage.throttle), wait
+			if c.Throttle != nil {
+				c.Throttle.Wait()
+			}
+
+			// If paused by load pattern (config.stage.pattern or workload.pattern), wait
+			for _, p := range c.Pattern {
+				p.Wait()
 			}
 
 			// If query, check QPS
@@ -268,71 +637,146 @@ ITER:
 			// Generate new data values for this query. A single data generator
 			// can return multiple values, so d makes copy() append, else copy()
 			// would start at [0:] each time
-			rc[data.STATEMENT] += 1
 			d := 0
 			for _, f := range c.Data[i].Inputs {
 				d += copy(c.values[i][d:], f(rc))
 			}
 
+			if c.Statements[i].Savepoint != "" && inTrx {
+				if _, err = c.conn.ExecContext(ctxExec, "SAVEPOINT "+c.Statements[i].Savepoint); err != nil {
+					goto ERROR
+				}
+				savepoint = i
+			}
+
+			ctx = ctxExec
+			if c.Statements[i].Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctxExec, c.Statements[i].Timeout)
+			}
+
 			if c.Statements[i].ResultSet {
 				//
 				// SELECT
 				//
-				t = time.Now()
+				if timed = c.measure(); timed || c.clock[i] {
+					t = c.Clock()
+				}
+				if c.InFlight != nil {
+					c.InFlight.Store(1) // until rows closed
+				}
 				if c.ps[i] != nil {
-					rows, err = c.ps[i].QueryContext(ctxExec, c.values[i]...)
+					rows, err = c.ps[i].QueryContext(ctx, c.values[i]...)
 				} else {
-					rows, err = c.conn.QueryContext(ctxExec, fmt.Sprintf(c.Statements[i].Query, c.values[i]...))
+					rows, err = c.sconn[i].QueryContext(ctx, c.query(i))
 				}
 				if c.Stats[trxNo] != nil {
-					c.Stats[trxNo].Record(stats.READ, time.Now().Sub(t).Microseconds())
+					c.record(trxNo, i, stats.READ, timed, t)
+				}
+				if c.QueryLog != nil {
+					c.logQuery(i, t, err)
+				}
+				if c.Tracer != nil {
+					c.trace(i, t, err)
 				}
 				if err != nil {
 					goto ERROR
 				}
+				nRows = 0       // rows read (client-side): only counted with outputs or fetch-all
+				visible = false // -- verify without outputs or fetch-all
 				if c.Data[i].Outputs != nil {
 					// @todo what if no row match? This loop won't happen,
 					// and the column generator won't be called, which will
 					// make it return nil later when used as input to another
 					// query.
 					for rows.Next() {
+						nRows++
 						if err = rows.Scan(c.Data[i].Outputs...); err != nil {
 							rows.Close()
 							goto ERROR
 						}
 					}
+				} else if c.Statements[i].FetchAll {
+					for rows.Next() {
+						nRows++
+					}
+					if err = rows.Err(); err != nil {
+						rows.Close()
+						goto ERROR
+					}
+				} else if c.Statements[i].Verify != "" {
+					visible = rows.Next() // first row only, not counted
 				}
 				rows.Close()
+				if c.InFlight != nil {
+					c.InFlight.Store(0)
+				}
+				if cancel != nil {
+					cancel()
+					cancel = nil
+				}
+				if nRows > 0 && c.Stats[trxNo] != nil {
+					c.Stats[trxNo].RowsRead(nRows)
+				}
+				if c.Statements[i].Verify != "" && nRows == 0 && !visible { // -- verify
+					if err = c.waitVisible(ctxExec, i, t, trxNo); err != nil {
+						goto ERROR
+					}
+				}
 			} else {
 				//
 				// Write or query without result set (e.g. BEGIN, SET, etc.)
 				//
 				if c.Statements[i].Limit != nil { // limit rows -------------
-					if !c.Statements[i].Limit.More(c.conn) {
+					if !c.Statements[i].Limit.More(ctxExec, c.conn) {
+						if cancel != nil {
+							cancel()
+						}
 						return // chan closed = no more writes
 					}
 				}
-				t = time.Now()
+				if timed = c.measure(); timed || c.clock[i] {
+					t = c.Clock()
+				}
+				if c.InFlight != nil {
+					c.InFlight.Store(1)
+				}
 				if c.ps[i] != nil { // exec ---------------------------------
-					res, err = c.ps[i].ExecContext(ctxExec, c.values[i]...)
+					res, err = c.ps[i].ExecContext(ctx, c.values[i]...)
 				} else {
-					res, err = c.conn.ExecContext(ctxExec, fmt.Sprintf(c.Statements[i].Query, c.values[i]...))
+					res, err = c.sconn[i].ExecContext(ctx, c.query(i))
+				}
+				if c.InFlight != nil {
+					c.InFlight.Store(0)
+				}
+				if cancel != nil {
+					cancel()
+					cancel = nil
 				}
 				if c.Stats[trxNo] != nil { // record stats ------------------
 					switch {
 					case c.Statements[i].Write:
-						c.Stats[trxNo].Record(stats.WRITE, time.Now().Sub(t).Microseconds())
+						c.record(trxNo, i, stats.WRITE, timed, t)
 					case c.Statements[i].Commit:
-						c.Stats[trxNo].Record(stats.COMMIT, time.Now().Sub(t).Microseconds())
+						c.record(trxNo, i, stats.COMMIT, timed, t)
 					default:
 						// BEGIN, SET, and other statements that aren't reads or writes
 						// but count and response time will be included in total
-						c.Stats[trxNo].Record(stats.TOTAL, time.Now().Sub(t).Microseconds())
+						c.record(trxNo, i, stats.TOTAL, timed, t)
 					}
 				}
+				if c.QueryLog != nil { // query log (sampled) ---------------
+					c.logQuery(i, t, err)
+				}
+				if c.Tracer != nil { // trace span (sampled) ----------------
+					c.trace(i, t, err)
+				}
 				if err != nil { // handle err, if any -----------------------
 					goto ERROR
 				}
+				if c.Statements[i].Write && c.Stats[trxNo] != nil { // rows affected
+					n, _ := res.RowsAffected()
+					c.Stats[trxNo].RowsAffected(uint64(n))
+				}
 				if c.Statements[i].Limit != nil { // limit rows -------------
 					n, _ := res.RowsAffected()
 					c.Statements[i].Limit.Affected(n)
@@ -342,18 +786,433 @@ ITER:
 					c.Data[i].InsertId.Scan(id)
 				}
 			} // execute
+
+			if c.Warnings || c.Statements[i].Warnings { // -- warnings
+				if err = c.showWarnings(ctxExec, i, trxNo); err != nil {
+					goto ERROR
+				}
+			}
+
+			// Track MySQL trx state (not finch trx boundaries)
+			if c.Statements[i].Begin {
+				inTrx = true
+				savepoint = -1
+			} else if c.Statements[i].Commit || c.Statements[i].Rollback || c.Statements[i].DDL {
+				if refund && c.Statements[i].Rollback {
+					c.refundTPS(trxNo)
+				}
+				refund = false
+				inTrx = false
+				savepoint = -1
+			}
+
+			// End of finch trx file: record its time (stats.TRX_FILE)
+			if c.Data[i].TrxBoundary&trx.END != 0 && c.Stats[trxNo] != nil {
+				c.recordTrx(trxNo, trxT, idle)
+			}
 			continue // next query
 
 		ERROR:
+			if c.InFlight != nil {
+				c.InFlight.Store(0) // SELECT error before rows closed
+			}
+			if cancel != nil {
+				cancel()
+				cancel = nil
+			}
 			if c.Stats[trxNo] != nil && ctxExec.Err() == nil {
-				c.Stats[trxNo].Error(myerr.MySQLErrorCode(err))
+				if isTimeout(err) {
+					c.Stats[trxNo].Timeout()
+				} else {
+					c.Stats[trxNo].Error(myerr.MySQLErrorCode(err))
+				}
+				if c.Labels != nil && c.Labels[i] != nil {
+					if isTimeout(err) {
+						c.Labels[i].Timeout()
+					} else {
+						c.Labels[i].Error(myerr.MySQLErrorCode(err))
+					}
+				}
 			}
-			if err = c.Connect(ctxExec, err, i, trxActive); err != nil {
+			if savepoint == i {
+				// Keep work before the savepoint: roll back to it and commit
+				if commit := c.commitAfter(i); commit > 0 && c.rollbackToSavepoint(ctxExec, err, i) {
+					if skipped := commit - i - 1; skipped > 0 {
+						log.Printf("Client %s rolled back to savepoint %s on error: %s, skipped %d statements before COMMIT (query: %s)",
+							c.RunLevel.ClientId(), c.Statements[i].Savepoint, err, skipped, c.queryText(i))
+					}
+					savepoint = -1
+					i = commit - 1 // i++ by loop
+					continue
+				}
+			}
+			if refund {
+				c.refundTPS(trxNo)
+				refund = false
+			}
+			if err = c.Connect(ctxExec, err, i, inTrx); err != nil {
 				c.Error.StatementNo = i
 				return // unrecoverable error or runtime elapsed (context timeout/cancel)
 			}
+			inTrx = false // rolled back or disconnected
+			savepoint = -1
 			rc[data.CONN] += 1 // reconnected or recovered after query error
+			if c.Retry != nil && retries < c.Retry[trxNo] {
+				// Restart the trx with the same trx-scoped data values
+				retries += 1
+				retry = true
+				rc[data.TRX] -= 1
+				i = c.trxStart[trxNo] - 1 // i++ by loop
+				trxNo -= 1                // += 1 on trx.BEGIN
+				continue
+			}
 			continue ITER
 		} // statements
 	} // iterations
 }
+
+// isTimeout returns true if err is a statement timeout (-- timeout): the client
+// context deadline or MySQL MAX_EXECUTION_TIME. The caller must check that
+// the exec context (runtime) didn't cause the error.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || myerr.MySQLErrorCode(err) == stats.ER_QUERY_TIMEOUT
+}
+
+// VerifyRetryWait is how long a client waits between reads when waiting for a
+// row to become visible (-- verify).
+var VerifyRetryWait = time.Millisecond
+
+// waitVisible re-executes SELECT statement i until it returns a row or the
+// verify timeout, then records the staleness (stats.Trx.Stale): the time since the
+// first read (t0). If the row isn't visible before the timeout, it records a
+// timeout instead (stats.Trx.StaleTimeout). It's called only when the first read
+// didn't return a row, which is a read-your-writes violation.
+func (c *Client) waitVisible(ctx context.Context, i int, t0 time.Time, trxNo int) error {
+	var rows *sql.Rows
+	var err error
+	visible := false
+	for c.Clock().Sub(t0) < c.Statements[i].VerifyTimeout {
+		time.Sleep(VerifyRetryWait)
+		if c.ps[i] != nil {
+			rows, err = c.ps[i].QueryContext(ctx, c.values[i]...)
+		} else {
+			rows, err = c.sconn[i].QueryContext(ctx, c.query(i))
+		}
+		if err != nil {
+			return err
+		}
+		visible = rows.Next()
+		rows.Close()
+		if visible {
+			break
+		}
+	}
+	if c.Stats[trxNo] != nil {
+		if visible {
+			c.Stats[trxNo].Stale(c.Clock().Sub(t0).Microseconds())
+		} else {
+			c.Stats[trxNo].StaleTimeout() // waited until timeout, not a wait time
+		}
+	}
+	return nil
+}
+
+// commitAfter returns the index of the first COMMIT after statement i in the
+// same finch trx, or -1 if there isn't one.
+func (c *Client) commitAfter(i int) int {
+	for j := i + 1; j < len(c.Statements); j++ {
+		if c.Data[j].TrxBoundary&trx.BEGIN != 0 {
+			break // next finch trx
+		}
+		if c.Statements[j].Commit {
+			return j
+		}
+	}
+	return -1
+}
+
+// rollbackToSavepoint rolls back to the savepoint set by statement sp if the
+// error is handled and leaves the MySQL trx active (Erollback), like a lock
+// wait timeout, or the query was killed (1317), which only applies to savepoint
+// statements. It returns false if the error isn't handled this way or the
+// rollback fails, in which case Connect handles the error.
+func (c *Client) rollbackToSavepoint(ctx context.Context, cerr error, sp int) bool {
+	code := myerr.MySQLErrorCode(cerr)
+	errFlags, handled := finch.MySQLErrorHandling[code]
+	if !handled || (errFlags&finch.Erollback == 0 && code != 1317) || errFlags&finch.Econtinue == 0 {
+		return false
+	}
+	if _, err := c.conn.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+c.Statements[sp].Savepoint); err != nil {
+		finch.Debug("%s: rollback to savepoint: %s", c.RunLevel.ClientId(), err)
+		return false
+	}
+	return true
+}
+
+// query returns non-prepared statement i with its current values. With a
+// template, the query references c.qbuf, so it's valid only until the next call.
+func (c *Client) query(i int) string {
+	if c.tmpl[i] == nil {
+		return fmt.Sprintf(c.Statements[i].Query, c.values[i]...)
+	}
+	var q string
+	q, c.qbuf = c.tmpl[i].Format(c.qbuf, c.values[i])
+	return q
+}
+
+// measure returns true if the next query should be timed (c.Measure).
+func (c *Client) measure() bool {
+	switch c.Measure {
+	case MeasureAll:
+		return true
+	case MeasureNone:
+		return false
+	}
+	c.measN += 1
+	if c.measN < c.MeasureSample {
+		return false
+	}
+	c.measN = 0
+	return true
+}
+
+// record records the response time since t if timed, else it only counts the
+// query. If statement i has a label, it's recorded in the label stats, too.
+func (c *Client) record(trxNo, i int, eventType byte, timed bool, t time.Time) {
+	s := c.Stats[trxNo]
+	var l *stats.Trx
+	if c.Labels != nil {
+		l = c.Labels[i]
+	}
+	if !timed {
+		s.Count(eventType)
+		if l != nil {
+			l.Count(eventType)
+		}
+		return
+	}
+	d := c.responseTime(t)
+	if c.Interval > 0 {
+		s.RecordCorrected(eventType, d, c.Interval)
+		if l != nil {
+			l.RecordCorrected(eventType, d, c.Interval)
+		}
+	} else {
+		s.Record(eventType, d)
+		if l != nil {
+			l.Record(eventType, d)
+		}
+	}
+}
+
+// recordTrx records the time of trx file trxNo since t, excluding idle time.
+// Unlike queries, every trx file is timed unless c.Measure is MeasureNone.
+func (c *Client) recordTrx(trxNo int, t time.Time, idle time.Duration) {
+	if c.Measure == MeasureNone {
+		c.Stats[trxNo].Count(stats.TRX_FILE)
+		return
+	}
+	d := c.Clock().Sub(t) - idle
+	if c.Nanoseconds {
+		c.Stats[trxNo].Record(stats.TRX_FILE, d.Nanoseconds())
+	} else {
+		c.Stats[trxNo].Record(stats.TRX_FILE, d.Microseconds())
+	}
+}
+
+// refundTPS refunds the TPS allowance taken by BEGIN because the MySQL trx
+// didn't commit (config.stage.workload.tps-refund).
+func (c *Client) refundTPS(trxNo int) {
+	c.TPSRefund()
+	if c.Stats[trxNo] != nil {
+		c.Stats[trxNo].Refund()
+	}
+}
+
+// responseTime returns the time since t in microseconds, or nanoseconds if
+// Nanoseconds is set (config.stats.precision: ns).
+func (c *Client) responseTime(t time.Time) int64 {
+	if c.Nanoseconds {
+		return c.Clock().Sub(t).Nanoseconds()
+	}
+	return c.Clock().Sub(t).Microseconds()
+}
+
+// logQuery logs 1 in QueryLog.Sample queries. It's called only if QueryLog is set.
+func (c *Client) logQuery(i int, t time.Time, err error) {
+	d := c.Clock().Sub(t)
+	c.qlogN += 1
+	if c.qlogN < c.QueryLog.Sample {
+		return
+	}
+	c.qlogN = 0
+	c.QueryLog.Log(c.RunLevel.ClientId(), c.Statements[i], c.values[i], d, err)
+}
+
+// trace exports 1 in Tracer.Sample queries as spans. It's called only if Tracer is set.
+func (c *Client) trace(i int, t time.Time, err error) {
+	d := c.Clock().Sub(t)
+	c.traceN += 1
+	if c.traceN < c.Tracer.Sample {
+		return
+	}
+	c.traceN = 0
+	c.Tracer.Span(c.RunLevel.ClientId(), i+1, c.Statements[i], t, d, err)
+}
+
+// DryRun prints n iterations of the client's statements with data values to w
+// without executing them. Init must be called first. Since statements are not
+// executed, saved columns and insert IDs have no values.
+func (c *Client) DryRun(w io.Writer, n uint) {
+	var rc data.RunCount
+	rc[data.CONN] = 1
+	rc[data.CLIENT] = c.RunLevel.Client
+	rc[data.CLIENT_GROUP] = c.RunLevel.ClientGroup
+	rc[data.EXEC_GROUP] = c.RunLevel.ExecGroup
+	rc[data.STAGE] = c.RunLevel.Stage
+
+	fmt.Fprintf(w, "-- %s\n", c.RunLevel.ClientId())
+	if c.Script != nil {
+		fmt.Fprintf(w, "-- script %s (queries not known until executed)\n", c.Script.Name)
+		return
+	}
+	for rc[data.ITER] < n {
+		rc[data.ITER] += 1
+		first, last := 0, len(c.Statements)
+		if c.Weights != nil {
+			t := c.pick()
+			first, last = c.trxStart[t], c.trxStart[t+1]
+		}
+		for i := first; i < last; i++ {
+			if c.Statements[i].Idle != 0 {
+				fmt.Fprintf(w, "-- sleep %s\n", c.Statements[i].Idle)
+				continue
+			}
+			if c.Data[i].TrxBoundary&trx.BEGIN != 0 {
+				rc[data.TRX] += 1
+				fmt.Fprintf(w, "-- iter %d trx %s\n", rc[data.ITER], c.Statements[i].Trx)
+			}
+			rc[data.STATEMENT] += 1
+			if c.Data[i].If != nil && !c.Statements[i].If.True(c.Data[i].If(rc)[0]) {
+				continue
+			}
+			d := 0
+			for _, f := range c.Data[i].Inputs {
+				d += copy(c.values[i][d:], f(rc))
+			}
+			fmt.Fprintln(w, Interpolate(c.Statements[i], c.values[i]))
+		}
+	}
+}
+
+// ER_PARSE_ERROR is the MySQL error code for an SQL syntax error.
+const ER_PARSE_ERROR = 1064
+
+// Explain executes EXPLAIN once for each statement with generated data values
+// and writes the query and its plan to w. It's used for --explain before the
+// stage runs. Only SELECT, INSERT, UPDATE, DELETE, and REPLACE are explained.
+// If analyze is true, SELECT statements use EXPLAIN ANALYZE, which executes the
+// query; writes are never executed. A syntax error is returned to fail fast,
+// but other errors are only written to w because, for example, a table might
+// not exist until an earlier stage creates it.
+func (c *Client) Explain(ctx context.Context, w io.Writer, analyze bool) error {
+	fmt.Fprintf(w, "-- %s\n", c.RunLevel.ClientId())
+	if c.Script != nil {
+		fmt.Fprintf(w, "-- script %s (queries not known until executed)\n\n", c.Script.Name)
+		return nil
+	}
+
+	conn, err := c.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if c.DefaultDb != "" {
+		if _, err := conn.ExecContext(ctx, "USE "+dbconn.QuoteIdent(c.DefaultDb)); err != nil {
+			fmt.Fprintf(w, "-- error: %s\n\n", err)
+			return nil
+		}
+	}
+	if c.Session != "" {
+		if _, err := conn.ExecContext(ctx, c.Session); err != nil {
+			return fmt.Errorf("session: %s: %s", c.Session, err)
+		}
+	}
+
+	var rc data.RunCount
+	rc[data.ITER] = 1
+	rc[data.CONN] = 1
+	rc[data.CLIENT] = c.RunLevel.Client
+	rc[data.CLIENT_GROUP] = c.RunLevel.ClientGroup
+	rc[data.EXEC_GROUP] = c.RunLevel.ExecGroup
+	rc[data.STAGE] = c.RunLevel.Stage
+
+	for i, s := range c.Statements {
+		if c.Data[i].TrxBoundary&trx.BEGIN != 0 {
+			rc[data.TRX] += 1
+		}
+		rc[data.STATEMENT] += 1
+		if s.Idle != 0 || s.DDL || (!s.ResultSet && !s.Write) {
+			continue
+		}
+		// Values from copies of the generators (Previews) so explaining doesn't
+		// change the values that clients generate when the stage runs
+		d := 0
+		for _, f := range c.Data[i].Previews {
+			d += copy(c.values[i][d:], f(rc))
+		}
+		query := Interpolate(s, c.values[i])
+		explain := "EXPLAIN "
+		if analyze && s.ResultSet {
+			explain = "EXPLAIN ANALYZE "
+		}
+		fmt.Fprintf(w, "-- trx %s statement %d\n%s\n", s.Trx, i+1, query)
+		rows, err := conn.QueryContext(ctx, explain+query)
+		if err != nil {
+			if myerr.MySQLErrorCode(err) == ER_PARSE_ERROR {
+				return fmt.Errorf("trx %s statement %d: %s (query: %s)", s.Trx, i+1, err, query)
+			}
+			fmt.Fprintf(w, "-- error: %s\n\n", err)
+			continue
+		}
+		err = writePlan(w, rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePlan writes the EXPLAIN rows to w: column names, then one row per line,
+// tab-separated. EXPLAIN ANALYZE returns one row and column: the plan tree.
+func writePlan(w io.Writer, rows *sql.Rows) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if len(cols) > 1 {
+		fmt.Fprintln(w, strings.Join(cols, "\t"))
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make([]string, len(vals))
+		for i := range vals {
+			if vals[i].Valid {
+				row[i] = vals[i].String
+			} else {
+				row[i] = "NULL"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	fmt.Fprintln(w)
+	return rows.Err()
+}
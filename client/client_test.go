@@ -3,7 +3,20 @@
 package client_test
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,6 +37,235 @@ var rl = finch.RunLevel{
 	Client:      1,
 }
 
+func Benchmark_Query(b *testing.B) {
+	// Statement assembly in the client hot path. To compare to fmt.Sprintf and
+	// confirm templates don't allocate (the query references the buffer):
+	// go test -bench=Query -benchmem
+	query := "UPDATE t SET c='%s', k=%d WHERE id BETWEEN %d AND %d"
+	values := []interface{}{"abcdefghijklmnopqrstuvwxyz", int64(50), int64(1000), uint64(1099)}
+
+	b.Run("template", func(b *testing.B) {
+		t := client.NewTemplate(query)
+		var buf []byte
+		for n := 0; n < b.N; n++ {
+			_, buf = t.Format(buf, values)
+		}
+		if a := testing.AllocsPerRun(100, func() { _, buf = t.Format(buf, values) }); a != 0 {
+			b.Errorf("got %.0f allocs per Format, expected 0", a)
+		}
+	})
+	b.Run("sprintf", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			_ = fmt.Sprintf(query, values...)
+		}
+	})
+
+	// /*!csv 1000 (...)*/ row batch
+	csv := &trx.CSV{Rows: 1000, Prefix: "INSERT INTO t VALUES ", Row: "(%d, '%s', %d)"}
+	rows := make([]string, csv.Rows)
+	batch := make([]interface{}, 0, 3*csv.Rows)
+	for i := range rows {
+		rows[i] = csv.Row
+		batch = append(batch, int64(i), "abcdefghijklmnopqrstuvwxyz", int64(i*2))
+	}
+	csvQuery := csv.Prefix + strings.Join(rows, ", ")
+	b.Run("csv-batch", func(b *testing.B) {
+		t := client.NewBatchTemplate(csv)
+		var buf []byte
+		for n := 0; n < b.N; n++ {
+			_, buf = t.Format(buf, batch)
+		}
+		if a := testing.AllocsPerRun(100, func() { _, buf = t.Format(buf, batch) }); a != 0 {
+			b.Errorf("got %.0f allocs per Format, expected 0", a)
+		}
+	})
+	b.Run("csv-sprintf", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			_ = fmt.Sprintf(csvQuery, batch...)
+		}
+	})
+}
+
+func Benchmark_Run(b *testing.B) {
+	// Client exec path (Client.Run) without MySQL: fakeDriver returns 1 row for
+	// every query and 1 row affected for every write, so this measures only
+	// Finch overhead per iteration (1 SELECT + 1 UPDATE):
+	// go test -bench=Run -benchmem
+	db := sql.OpenDB(fakeConnector{})
+	defer db.Close()
+
+	id := []interface{}{int64(1)}
+	idFunc := func(_ data.RunCount) []interface{} { return id }
+	c := &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		DoneChan: make(chan *client.Client, 1),
+		Statements: []*trx.Statement{
+			{
+				Query:     "SELECT c FROM t WHERE id=%d",
+				ResultSet: true,
+				Inputs:    []string{"@id"},
+			},
+			{
+				Query:  "UPDATE t SET c=c+1 WHERE id=%d",
+				Write:  true,
+				Inputs: []string{"@id"},
+			},
+		},
+		Data: []client.StatementData{
+			{
+				TrxBoundary: trx.BEGIN,
+				Inputs:      []data.ValueFunc{idFunc},
+			},
+			{
+				TrxBoundary: trx.END,
+				Inputs:      []data.ValueFunc{idFunc},
+			},
+		},
+		Stats: []*stats.Trx{stats.NewTrx("bench")},
+		Iter:  uint(b.N),
+	}
+	if err := c.Init(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	c.Run(context.Background())
+	b.StopTimer()
+	if ret := <-c.DoneChan; ret.Error.Err != nil {
+		b.Fatal(ret.Error.Err)
+	}
+}
+
+// fakeConnector is a database/sql/driver that doesn't connect to anything:
+// queries return 1 row (1 column) and writes return 1 row affected.
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct{ done bool }
+
+func (r *fakeRows) Columns() []string { return []string{"c"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func TestTemplate(t *testing.T) {
+	// Template must format exactly like fmt.Sprintf
+	tests := []struct {
+		query  string
+		values []interface{}
+	}{
+		{"SELECT 1", nil},
+		{"SELECT c FROM t WHERE id=%d", []interface{}{int64(5)}},
+		{"SELECT c FROM t WHERE id BETWEEN %d AND %d", []interface{}{int64(-1), uint64(10)}},
+		{"INSERT INTO t VALUES ('%s', '%v', %v)", []interface{}{"a'b", []byte("xyz"), nil}},
+		{"SELECT %v, %v, %d, %s WHERE c LIKE 'a%%'", []interface{}{1.5, true, 7, []byte("b")}},
+		{"SELECT %d, %s", []interface{}{"str", int64(3)}}, // wrong verbs
+	}
+	for _, test := range tests {
+		tmpl := client.NewTemplate(test.query)
+		if tmpl == nil {
+			t.Fatalf("NewTemplate(%s) returned nil", test.query)
+		}
+		if tmpl.Values() != len(test.values) {
+			t.Errorf("%s: got %d values, expected %d", test.query, tmpl.Values(), len(test.values))
+		}
+		got, _ := tmpl.Format(nil, test.values)
+		expect := fmt.Sprintf(test.query, test.values...)
+		if got != expect {
+			t.Errorf("got '%s', expected '%s'", got, expect)
+		}
+	}
+
+	// Unsupported verbs
+	for _, query := range []string{"SELECT %5d", "SELECT %x", "SELECT 100%"} {
+		if tmpl := client.NewTemplate(query); tmpl != nil {
+			t.Errorf("NewTemplate(%s) returned a template, expected nil", query)
+		}
+	}
+
+	// Row batch (/*!csv 3 (...)*/) must format like the expanded query
+	csv := &trx.CSV{
+		Rows:   3,
+		Prefix: "INSERT INTO t VALUES ",
+		Row:    "(%d, '%s')",
+		Suffix: " ON DUPLICATE KEY UPDATE c=%d",
+	}
+	tmpl := client.NewBatchTemplate(csv)
+	if tmpl == nil {
+		t.Fatal("NewBatchTemplate returned nil")
+	}
+	values := []interface{}{int64(1), "a", int64(2), "b", int64(3), "c", int64(9)}
+	if tmpl.Values() != len(values) {
+		t.Errorf("got %d values, expected %d", tmpl.Values(), len(values))
+	}
+	got, _ := tmpl.Format(nil, values)
+	expect := "INSERT INTO t VALUES (1, 'a'), (2, 'b'), (3, 'c') ON DUPLICATE KEY UPDATE c=9"
+	if got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+}
+
+func TestCoarseClock(t *testing.T) {
+	c := client.NewCoarseClock(time.Millisecond)
+	t0 := c.Now()
+	if t1 := c.Now(); !t1.Equal(t0) {
+		t.Errorf("clock changed before Start: %s != %s", t1, t0)
+	}
+	c.Start()
+	time.Sleep(20 * time.Millisecond)
+	d := c.Now().Sub(t0)
+	c.Stop()
+	if d < 5*time.Millisecond || d > time.Second {
+		t.Errorf("clock advanced %s in 20ms, expected about 20ms", d)
+	}
+	t1 := c.Now()
+	time.Sleep(5 * time.Millisecond)
+	if t2 := c.Now(); !t2.Equal(t1) {
+		t.Errorf("clock changed after Stop: %s != %s", t2, t1)
+	}
+}
+
+func TestSetSession(t *testing.T) {
+	if got := client.SetSession(nil); got != "" {
+		t.Errorf("got '%s', expected empty string for no variables", got)
+	}
+	got := client.SetSession(map[string]string{
+		"transaction_isolation":    "READ-COMMITTED",
+		"innodb_lock_wait_timeout": "5",
+		"sql_mode":                 "",
+		"optimizer_switch":         "'index_merge=off'",
+		"long_query_time":          "default",
+		"init_connect":             "it's",
+	})
+	expect := "SET SESSION init_connect='it''s', innodb_lock_wait_timeout=5, long_query_time=default, optimizer_switch='index_merge=off', sql_mode='', transaction_isolation='READ-COMMITTED'"
+	if got != expect {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expect)
+	}
+}
+
 func TestClient_SELECT_1(t *testing.T) {
 	if test.Build {
 		t.Skip("GitHub Actions build")
@@ -110,7 +352,7 @@ func TestClient_Write(t *testing.T) {
 		return vals
 	}
 	// Receives last insertt ID (1 as well since it's first row)
-	col := data.NewColumn(nil)
+	col, _ := data.NewColumn(nil)
 
 	doneChan := make(chan *client.Client, 1)
 
@@ -165,3 +407,424 @@ func TestClient_Write(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestClient_TrxFile(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	_, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Trx file time is from the first to the last statement, excluding idle
+	s := stats.NewTrx("trx1")
+	doneChan := make(chan *client.Client, 1)
+	c := &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		Iter:     1,
+		DoneChan: doneChan,
+		Statements: []*trx.Statement{
+			{Query: "SELECT 1", ResultSet: true},
+			{Idle: 50 * time.Millisecond},
+			{Query: "SELECT 2", ResultSet: true},
+		},
+		Data: []client.StatementData{
+			{TrxBoundary: trx.BEGIN},
+			{},
+			{TrxBoundary: trx.END},
+		},
+		Stats: []*stats.Trx{s},
+	}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Run(context.Background())
+
+	select {
+	case ret := <-doneChan:
+		if ret.Error.Err != nil {
+			t.Errorf("Client error: %v", ret.Error.Err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client timeout after 2s")
+	}
+
+	got := s.Swap()
+	if got.N[stats.TRX_FILE] != 1 {
+		t.Errorf("got %d trx files, expected 1", got.N[stats.TRX_FILE])
+	}
+	if got.N[stats.TOTAL] != 2 {
+		t.Errorf("got %d queries, expected 2", got.N[stats.TOTAL])
+	}
+	if got.Max[stats.TRX_FILE] >= 50000 {
+		t.Errorf("trx file time %d us includes 50ms idle time", got.Max[stats.TRX_FILE])
+	}
+}
+
+func TestClient_Warnings(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	_, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Only the statement with -- warnings is checked, so the second statement
+	// warning isn't counted
+	s := stats.NewTrx("trx1")
+	doneChan := make(chan *client.Client, 1)
+	c := &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		Iter:     1,
+		DoneChan: doneChan,
+		Statements: []*trx.Statement{
+			{Query: "SELECT CAST('abc' AS SIGNED)", ResultSet: true, Warnings: true},
+			{Query: "SELECT CAST('xyz' AS SIGNED)", ResultSet: true},
+		},
+		Data: []client.StatementData{
+			{TrxBoundary: trx.BEGIN},
+			{TrxBoundary: trx.END},
+		},
+		Stats: []*stats.Trx{s},
+	}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Run(context.Background())
+
+	select {
+	case ret := <-doneChan:
+		if ret.Error.Err != nil {
+			t.Errorf("Client error: %v", ret.Error.Err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client timeout after 2s")
+	}
+
+	got := s.Swap()
+	if got.MySQLWarnings != 1 {
+		t.Errorf("got %d MySQL warnings, expected 1", got.MySQLWarnings)
+	}
+}
+
+func TestLoadScript(t *testing.T) {
+	s, err := client.LoadScript("../test/script/select.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "select.lua" {
+		t.Errorf("got name %s, expected select.lua", s.Name)
+	}
+
+	file := filepath.Join(t.TempDir(), "bad.lua")
+	if err := os.WriteFile(file, []byte("function event(\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.LoadScript(file); err == nil {
+		t.Error("no error loading script with syntax error, expected one")
+	}
+}
+
+func TestClient_Script(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	_, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	script, err := client.LoadScript("../test/script/select.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stats.NewTrx(script.Name)
+	doneChan := make(chan *client.Client, 1)
+	c := &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		Iter:     3,
+		DoneChan: doneChan,
+		Script:   script,
+		Stats:    []*stats.Trx{s},
+	}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Run(context.Background())
+
+	select {
+	case ret := <-doneChan:
+		if ret.Error.Err != nil {
+			t.Errorf("Client error: %v", ret.Error.Err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client timeout after 2s")
+	}
+
+	// Each event is recorded like a trx file: BEGIN, SELECT, COMMIT
+	got := s.Swap()
+	if got.N[stats.TRX_FILE] != 3 {
+		t.Errorf("got %d events, expected 3", got.N[stats.TRX_FILE])
+	}
+	if got.N[stats.READ] != 3 || got.N[stats.COMMIT] != 3 {
+		t.Errorf("got %d reads and %d commits, expected 3 and 3", got.N[stats.READ], got.N[stats.COMMIT])
+	}
+
+	// done() writes the number of events
+	n, err := test.OneRow(db, "SELECT n FROM finch.script WHERE id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != "3" {
+		t.Errorf("done wrote %s events, expected 3", n)
+	}
+}
+
+func TestClient_Explain(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	_, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		Statements: []*trx.Statement{
+			{Trx: "t.sql", Query: "BEGIN", Begin: true},
+			{Trx: "t.sql", Query: "SELECT 1", ResultSet: true},
+			{Trx: "t.sql", Query: "COMMIT", Commit: true},
+		},
+		Data: []client.StatementData{
+			{TrxBoundary: trx.BEGIN},
+			{},
+			{TrxBoundary: trx.END},
+		},
+		Stats: []*stats.Trx{nil},
+	}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := c.Explain(context.Background(), &buf, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "-- trx t.sql statement 2\nSELECT 1\n") {
+		t.Errorf("SELECT not explained: %s", out)
+	}
+	if strings.Contains(out, "BEGIN") || strings.Contains(out, "COMMIT") {
+		t.Errorf("BEGIN or COMMIT explained: %s", out)
+	}
+
+	// Syntax error is returned
+	c.Statements[1] = &trx.Statement{Trx: "t.sql", Query: "SELEC 1", ResultSet: true}
+	if err := c.Explain(context.Background(), &buf, false); err == nil {
+		t.Errorf("no error on syntax error")
+	}
+}
+
+func TestClient_ScriptError(t *testing.T) {
+	// Script clients have no statements, so the error query is the last query
+	// executed by the script, not a statement (which used to panic)
+	c := &client.Client{Script: &client.Script{Name: "error.lua"}}
+	if q := c.ErrorQuery(); q != "" {
+		t.Errorf("got error query '%s', expected ''", q)
+	}
+
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	_, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	script, err := client.LoadScript("../test/script/error.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneChan := make(chan *client.Client, 1)
+	c = &client.Client{
+		DB:       db,
+		RunLevel: rl,
+		Iter:     1,
+		DoneChan: doneChan,
+		Script:   script,
+		Stats:    []*stats.Trx{nil},
+	}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Run(context.Background())
+
+	var ret *client.Client
+	select {
+	case ret = <-doneChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client timeout after 2s")
+	}
+	if ret.Error.Err == nil {
+		t.Fatal("no client error, expected script error")
+	}
+	if !strings.Contains(ret.Error.Err.Error(), "boom") {
+		t.Errorf("got error '%s', expected 'boom' (unsafe library available?)", ret.Error.Err)
+	}
+	if q := ret.ErrorQuery(); q != "SELECT 1" {
+		t.Errorf("got error query '%s', expected 'SELECT 1'", q)
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query.log")
+	qlog, err := client.NewQueryLog(file, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := &trx.Statement{
+		Trx:     "001.sql",
+		Query:   "SELECT c FROM t WHERE id=? AND s=?",
+		Prepare: true,
+	}
+	qlog.Log("1(s)/e1(dml1)/g1/c1", stmt, []interface{}{5, "it's"}, 150*time.Microsecond, nil)
+	qlog.Log("1(s)/e1(dml1)/g1/c1", &trx.Statement{Trx: "002.sql", Query: "DELETE FROM t WHERE id=%d"}, []interface{}{7}, 2*time.Millisecond, errors.New("oops"))
+	if err := qlog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	qlog.Log("1(s)/e1(dml1)/g1/c1", stmt, []interface{}{1, "x"}, 0, nil) // closed: not logged
+	if err := qlog.Close(); err != nil {
+		t.Error(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := []client.QueryLogLine{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line client.QueryLogLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		line.Ts = "" // don't care
+		got = append(got, line)
+	}
+	expect := []client.QueryLogLine{
+		{
+			Client: "1(s)/e1(dml1)/g1/c1",
+			Trx:    "001.sql",
+			Query:  "SELECT c FROM t WHERE id=5 AND s='it''s'",
+			Time:   150,
+		},
+		{
+			Client: "1(s)/e1(dml1)/g1/c1",
+			Trx:    "002.sql",
+			Query:  "DELETE FROM t WHERE id=7",
+			Time:   2000,
+			Error:  "oops",
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	type span struct {
+		Name       string `json:"name"`
+		Attributes []struct {
+			Key   string `json:"key"`
+			Value struct {
+				StringValue string `json:"stringValue"`
+				IntValue    string `json:"intValue"`
+			} `json:"value"`
+		} `json:"attributes"`
+		Status *struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	type request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []span `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	gotSpans := []span{}
+	mux := &sync.Mutex{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mux.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				gotSpans = append(gotSpans, ss.Spans...)
+			}
+		}
+		mux.Unlock()
+	}))
+	defer ts.Close()
+
+	tracer := client.NewTracer(ts.URL, 1)
+	tracer.Start()
+	tracer.Span("c1", 1, &trx.Statement{Trx: "001.sql", Query: "SELECT c FROM t WHERE id=%d"}, time.Now(), time.Millisecond, nil)
+	tracer.Span("c1", 2, &trx.Statement{Trx: "001.sql", Query: "UPDATE t SET c=1"}, time.Now(), time.Millisecond, errors.New("oops"))
+	tracer.Close()
+	tracer.Close() // safe to call twice
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(gotSpans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(gotSpans))
+	}
+	if gotSpans[0].Name != "SELECT" || gotSpans[1].Name != "UPDATE" {
+		t.Errorf("got span names %s, %s; expected SELECT, UPDATE", gotSpans[0].Name, gotSpans[1].Name)
+	}
+	attrs := map[string]string{}
+	for _, a := range gotSpans[0].Attributes {
+		attrs[a.Key] = a.Value.StringValue + a.Value.IntValue
+	}
+	expect := map[string]string{
+		"db.system":       "mysql",
+		"db.statement":    "SELECT c FROM t WHERE id=%d",
+		"finch.client":    "c1",
+		"finch.trx":       "001.sql",
+		"finch.statement": "1",
+	}
+	if diff := deep.Equal(attrs, expect); diff != nil {
+		t.Error(diff)
+	}
+	if gotSpans[0].Status != nil {
+		t.Errorf("span 1 has status, expected none")
+	}
+	if gotSpans[1].Status == nil || gotSpans[1].Status.Code != 2 {
+		t.Errorf("span 2 status %+v, expected code 2 (error)", gotSpans[1].Status)
+	}
+}
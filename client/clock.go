@@ -0,0 +1,58 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CoarseClock is a monotonic clock updated by a ticker (config.stats.clock:
+// coarse). Reading it is an atomic load, which is cheaper than time.Now, but its
+// resolution is the tick, so response times are multiples of the tick. It's only
+// useful for very high QPS benchmarks where time.Now overhead is measurable.
+type CoarseClock struct {
+	base     time.Time // has monotonic reading
+	ns       int64     // since base (atomic)
+	tick     time.Duration
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func NewCoarseClock(tick time.Duration) *CoarseClock {
+	return &CoarseClock{
+		base:     time.Now(),
+		tick:     tick,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// Start starts updating the clock every tick until Stop is called.
+func (c *CoarseClock) Start() {
+	go func() {
+		defer close(c.doneChan)
+		ticker := time.NewTicker(c.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				atomic.StoreInt64(&c.ns, int64(time.Since(c.base)))
+			case <-c.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops updating the clock and waits for the ticker to stop, so the clock
+// doesn't change after Stop returns.
+func (c *CoarseClock) Stop() {
+	close(c.stopChan)
+	<-c.doneChan
+}
+
+// Now returns the time of the last tick. It's safe to call from multiple clients.
+func (c *CoarseClock) Now() time.Time {
+	return c.base.Add(time.Duration(atomic.LoadInt64(&c.ns)))
+}
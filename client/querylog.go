@@ -0,0 +1,124 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/finch/trx"
+)
+
+// QueryLog writes a sample of executed queries to a file as JSON lines: the
+// final SQL (with data values), response time, and error, if any. It's enabled
+// per client group (config.stage.workload.query-log), and all clients in the
+// group (or groups that use the same file) share the same QueryLog.
+type QueryLog struct {
+	Sample uint // log 1 in Sample queries per client
+	// --
+	mux  *sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// QueryLogLine is one line (JSON object) in the query log file.
+type QueryLogLine struct {
+	Ts     string `json:"ts"`
+	Client string `json:"client"`
+	Trx    string `json:"trx"`
+	Query  string `json:"query"`
+	Time   int64  `json:"us"` // microseconds
+	Error  string `json:"error,omitempty"`
+}
+
+// NewQueryLog creates a QueryLog that writes to file. If the file exists, it's
+// appended to.
+func NewQueryLog(file string, sample uint) (*QueryLog, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if sample == 0 {
+		sample = 1
+	}
+	return &QueryLog{
+		Sample: sample,
+		mux:    &sync.Mutex{},
+		file:   f,
+		buf:    bufio.NewWriter(f),
+	}, nil
+}
+
+// Log writes one query to the log. It's safe to call from multiple clients.
+func (l *QueryLog) Log(clientId string, stmt *trx.Statement, values []interface{}, d time.Duration, err error) {
+	line := QueryLogLine{
+		Ts:     time.Now().UTC().Format(time.RFC3339Nano),
+		Client: clientId,
+		Trx:    stmt.Trx,
+		Query:  Interpolate(stmt, values),
+		Time:   d.Microseconds(),
+	}
+	if err != nil {
+		line.Error = err.Error()
+	}
+	bytes, _ := json.Marshal(line)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.file == nil {
+		return // closed
+	}
+	l.buf.Write(bytes)
+	l.buf.WriteByte('\n')
+}
+
+// Close flushes and closes the query log file. It's safe to call more than once.
+func (l *QueryLog) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.buf.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+// Interpolate returns the final SQL statement with data values. Prepared
+// statements have ? placeholders, else the query has fmt verbs that the
+// client replaces with values.
+func Interpolate(stmt *trx.Statement, values []interface{}) string {
+	if !stmt.Prepare {
+		return fmt.Sprintf(stmt.Query, values...)
+	}
+	var sb strings.Builder
+	q := stmt.Query
+	for _, v := range values {
+		n := strings.IndexByte(q, '?')
+		if n < 0 {
+			break
+		}
+		sb.WriteString(q[:n])
+		switch v := v.(type) {
+		case string:
+			sb.WriteString("'" + strings.ReplaceAll(v, "'", "''") + "'")
+		case []byte:
+			sb.WriteString("'" + strings.ReplaceAll(string(v), "'", "''") + "'")
+		case nil:
+			sb.WriteString("NULL")
+		default:
+			fmt.Fprintf(&sb, "%v", v)
+		}
+		q = q[n+1:]
+	}
+	sb.WriteString(q)
+	return sb.String()
+}
@@ -0,0 +1,493 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	myerr "github.com/go-mysql/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/square/finch/data"
+	"github.com/square/finch/stats"
+	"github.com/square/finch/trx"
+)
+
+// Script is a compiled Lua script (config.stage.workload.script) that clients
+// execute instead of trx files. The script must define a global event function
+// that Client.Run calls once per iteration. It can define init and done functions
+// that are called once per client after connecting and after the last iteration.
+// A script uses the global finch module to execute queries, make data generators,
+// and record stats:
+//
+//	finch.query(sql, ...)      -- SELECT: returns rows, row[1] or row.col
+//	finch.exec(sql, ...)       -- other: returns rows affected, insert ID
+//	finch.generator(name, {})  -- returns a function that returns values
+//	finch.sleep(seconds)       -- idle time, excluded from event time
+//	finch.record(type, seconds) -- record a response time: read, write, commit, total
+//	finch.client               -- client ID, like "1(stage)/e1/g1/c1"
+//
+// Query arguments replace ? placeholders. On query error, the client records
+// the error and raises a Lua error: a table with code (MySQL error code) and
+// message. If the script doesn't catch it (pcall), the client handles the error
+// like trx files (config.mysql.errors), then starts the next iteration.
+type Script struct {
+	Name  string // file base name, used for stats
+	proto *lua.FunctionProto
+}
+
+// LoadScript loads and compiles a Lua script file. The compiled script is
+// shared by clients; each client runs it in its own Lua state.
+func LoadScript(file string) (*Script, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(f, file)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, file)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{Name: filepath.Base(file), proto: proto}, nil
+}
+
+// scriptState is the state of a client running a script.
+type scriptState struct {
+	c      *Client
+	ctx    context.Context
+	rc     *data.RunCount
+	inTrx  bool          // MySQL trx active (BEGIN without COMMIT or ROLLBACK)
+	refund bool          // BEGIN took a TPS allowance (c.TPSRefund)
+	idle   time.Duration // finch.sleep time in current event
+	err    error         // last query error
+	errObj *lua.LTable   // Lua error raised for err
+	nGen   uint          // generators made, for data keys
+}
+
+// newState returns a Lua state with only the base, table, string, and math
+// libraries. Remote compute instances run scripts sent by the server, so the
+// os, io, and package libraries, and base functions that load files or
+// modules, are not available.
+func newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, fn := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(fn, lua.LNil)
+	}
+	return L
+}
+
+// runScript runs c.Script until an iteration limit is reached or ctx is done.
+// It's called by Run after connecting. Each iteration calls the script event
+// function, which is recorded like a trx file (stats.TRX_FILE) in c.Stats[0].
+func (c *Client) runScript(ctx context.Context, rc *data.RunCount) error {
+	L := newState()
+	defer L.Close()
+	L.SetContext(ctx)
+	s := &scriptState{c: c, ctx: ctx, rc: rc}
+	L.SetGlobal("finch", s.module(L))
+
+	if err := L.CallByParam(lua.P{Fn: L.NewFunctionFromProto(c.Script.proto), Protect: true}); err != nil {
+		return s.error(err)
+	}
+	event, ok := L.GetGlobal("event").(*lua.LFunction)
+	if !ok {
+		return fmt.Errorf("script %s: event function not defined", c.Script.Name)
+	}
+	if fn, ok := L.GetGlobal("init").(*lua.LFunction); ok {
+		if err := L.CallByParam(lua.P{Fn: fn, Protect: true}); err != nil {
+			return s.error(err)
+		}
+	}
+
+	for {
+		arrival, ok := c.nextIter(ctx, rc)
+		if !ok {
+			break
+		}
+		rc[data.TRX] += 1
+		if c.Arrivals != nil && c.Stats[0] != nil {
+			c.Stats[0].Queue(time.Since(arrival).Microseconds())
+		}
+		s.err = nil
+		s.idle = 0
+		t := c.Clock()
+		err := L.CallByParam(lua.P{Fn: event, Protect: true}, lua.LNumber(rc[data.ITER]))
+		if err == nil {
+			if c.Stats[0] != nil {
+				c.recordTrx(0, t, s.idle)
+			}
+			continue
+		}
+		if apiErr, ok := err.(*lua.ApiError); !ok || s.err == nil || apiErr.Object != s.errObj || ctx.Err() != nil {
+			return s.error(err) // Lua error or runtime elapsed
+		}
+		// Query error not caught by the script: recover like trx files
+		if s.refund {
+			c.refundTPS(0)
+			s.refund = false
+		}
+		if err = c.Connect(ctx, s.err, -1, s.inTrx); err != nil {
+			return err
+		}
+		s.inTrx = false // rolled back or disconnected
+		rc[data.CONN] += 1
+	}
+
+	if fn, ok := L.GetGlobal("done").(*lua.LFunction); ok && ctx.Err() == nil {
+		if err := L.CallByParam(lua.P{Fn: fn, Protect: true}); err != nil {
+			return s.error(err)
+		}
+	}
+	return nil
+}
+
+// error returns a Lua error from the script as a client error, or ctx.Err() if
+// the error is due to ctx done (runtime elapsed or CTRL-C).
+func (s *scriptState) error(err error) error {
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		if s.err != nil && apiErr.Object == s.errObj {
+			return fmt.Errorf("script %s: %s (query: %s)", s.c.Script.Name, s.err, s.c.scriptQuery)
+		}
+		return fmt.Errorf("script %s: %s", s.c.Script.Name, apiErr.Object.String())
+	}
+	return fmt.Errorf("script %s: %s", s.c.Script.Name, err)
+}
+
+// module returns the finch module table: the API for the script.
+func (s *scriptState) module(L *lua.LState) *lua.LTable {
+	m := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"query":     s.query,
+		"exec":      s.exec,
+		"generator": s.generator,
+		"sleep":     s.sleep,
+		"record":    s.record,
+	})
+	m.RawSetString("client", lua.LString(s.c.RunLevel.ClientId()))
+	return m
+}
+
+// wait waits for the same limits as statements in trx files: TPS on BEGIN,
+// throttle, load pattern, and QPS.
+func (s *scriptState) wait(st trx.Statement) {
+	c := s.c
+	if c.TPS != nil && st.Begin {
+		<-c.TPS
+		s.refund = c.TPSRefund != nil
+	}
+	if c.Throttle != nil {
+		c.Throttle.Wait()
+	}
+	for _, p := range c.Pattern {
+		p.Wait()
+	}
+	if c.QPS != nil {
+		<-c.QPS
+	}
+}
+
+// query is finch.query(sql, ...): execute a query that returns rows, like SELECT.
+func (s *scriptState) query(L *lua.LState) int {
+	c := s.c
+	q, args := scriptArgs(L)
+	s.wait(trx.Type(q))
+	c.scriptQuery = q
+
+	var t time.Time
+	timed := c.measure()
+	if timed {
+		t = c.Clock()
+	}
+	if c.InFlight != nil {
+		c.InFlight.Store(1) // until rows closed
+	}
+	rows, err := c.conn.QueryContext(s.ctx, q, args...)
+	if c.Stats[0] != nil {
+		c.record(0, 0, stats.READ, timed, t)
+	}
+	if err != nil {
+		return s.raise(L, err)
+	}
+	tbl, nRows, err := scriptRows(L, rows)
+	if c.InFlight != nil {
+		c.InFlight.Store(0)
+	}
+	if err != nil {
+		return s.raise(L, err)
+	}
+	if nRows > 0 && c.Stats[0] != nil {
+		c.Stats[0].RowsRead(nRows)
+	}
+	L.Push(tbl)
+	return 1
+}
+
+// exec is finch.exec(sql, ...): execute a query that doesn't return rows, like
+// INSERT or BEGIN. It returns rows affected and the insert ID.
+func (s *scriptState) exec(L *lua.LState) int {
+	c := s.c
+	q, args := scriptArgs(L)
+	st := trx.Type(q)
+	s.wait(st)
+	c.scriptQuery = q
+
+	var t time.Time
+	timed := c.measure()
+	if timed {
+		t = c.Clock()
+	}
+	if c.InFlight != nil {
+		c.InFlight.Store(1)
+	}
+	res, err := c.conn.ExecContext(s.ctx, q, args...)
+	if c.InFlight != nil {
+		c.InFlight.Store(0)
+	}
+	if c.Stats[0] != nil {
+		switch {
+		case st.Write:
+			c.record(0, 0, stats.WRITE, timed, t)
+		case st.Commit:
+			c.record(0, 0, stats.COMMIT, timed, t)
+		default:
+			c.record(0, 0, stats.TOTAL, timed, t)
+		}
+	}
+	if err != nil {
+		return s.raise(L, err)
+	}
+
+	// Track MySQL trx state for error handling and TPS refunds
+	if st.Begin {
+		s.inTrx = true
+	} else if st.Commit || st.Rollback || st.DDL {
+		if s.refund && st.Rollback {
+			c.refundTPS(0)
+		}
+		s.refund = false
+		s.inTrx = false
+	}
+
+	n, _ := res.RowsAffected()
+	if st.Write && c.Stats[0] != nil {
+		c.Stats[0].RowsAffected(uint64(n))
+	}
+	id, _ := res.LastInsertId()
+	L.Push(lua.LNumber(n))
+	L.Push(lua.LNumber(id))
+	return 2
+}
+
+// raise records the query error and raises it as a Lua error: a table with the
+// MySQL error code and message.
+func (s *scriptState) raise(L *lua.LState, err error) int {
+	c := s.c
+	if c.InFlight != nil {
+		c.InFlight.Store(0)
+	}
+	if c.Stats[0] != nil && s.ctx.Err() == nil {
+		if isTimeout(err) {
+			c.Stats[0].Timeout()
+		} else {
+			c.Stats[0].Error(myerr.MySQLErrorCode(err))
+		}
+	}
+	s.err = err
+	s.errObj = L.NewTable()
+	s.errObj.RawSetString("code", lua.LNumber(myerr.MySQLErrorCode(err)))
+	s.errObj.RawSetString("message", lua.LString(err.Error()))
+	L.Error(s.errObj, 0)
+	return 0
+}
+
+// generator is finch.generator(name, params): make a data generator, like a data
+// key in stage.trx.data. It returns a function that returns the generator values.
+// Each client has its own generators (like client scope); the values don't
+// change with other scopes.
+func (s *scriptState) generator(L *lua.LState) int {
+	name := L.CheckString(1)
+	params := map[string]string{}
+	if tbl := L.OptTable(2, nil); tbl != nil {
+		tbl.ForEach(func(k, v lua.LValue) {
+			params[k.String()] = v.String()
+		})
+	}
+	s.nGen += 1
+	dataKey := fmt.Sprintf("@%s/%s/%d", s.c.Script.Name, s.c.RunLevel.ClientId(), s.nGen) // unique for --seed
+	g, err := data.Make(name, dataKey, params)
+	if err != nil {
+		L.RaiseError("generator %s: %s", name, err)
+		return 0
+	}
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		vals := g.Values(*s.rc)
+		for _, v := range vals {
+			L.Push(luaValue(v))
+		}
+		return len(vals)
+	}))
+	return 1
+}
+
+// sleep is finch.sleep(seconds): idle time like -- idle in a trx file, excluded
+// from the event time.
+func (s *scriptState) sleep(L *lua.LState) int {
+	d := time.Duration(float64(L.CheckNumber(1)) * float64(time.Second))
+	t0 := time.Now()
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+	case <-s.ctx.Done():
+		timer.Stop()
+	}
+	s.idle += time.Since(t0) // less than d if ctx cancelled
+	return 0
+}
+
+// record is finch.record(type, seconds): record a response time measured by the
+// script.
+func (s *scriptState) record(L *lua.LState) int {
+	var eventType byte
+	switch t := L.CheckString(1); t {
+	case "read":
+		eventType = stats.READ
+	case "write":
+		eventType = stats.WRITE
+	case "commit":
+		eventType = stats.COMMIT
+	case "total":
+		eventType = stats.TOTAL
+	default:
+		L.ArgError(1, "invalid type: "+t+" (valid: read, write, commit, total)")
+		return 0
+	}
+	if s.c.Stats[0] == nil {
+		return 0
+	}
+	d := time.Duration(float64(L.CheckNumber(2)) * float64(time.Second))
+	if s.c.Nanoseconds {
+		s.c.Stats[0].Record(eventType, d.Nanoseconds())
+	} else {
+		s.c.Stats[0].Record(eventType, d.Microseconds())
+	}
+	return 0
+}
+
+// scriptArgs returns the query and its arguments from finch.query or finch.exec.
+func scriptArgs(L *lua.LState) (string, []interface{}) {
+	q := L.CheckString(1)
+	args := make([]interface{}, L.GetTop()-1)
+	for i := range args {
+		args[i] = goValue(L.Get(i + 2))
+	}
+	return q, args
+}
+
+// scriptRows returns all rows as a Lua table of rows, and the number of rows.
+// Each row is indexed by column number and name.
+func scriptRows(L *lua.LState, rows *sql.Rows) (*lua.LTable, uint64, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, 0, err
+	}
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	tbl := L.NewTable()
+	var n uint64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, n, err
+		}
+		row := L.NewTable()
+		for i, v := range vals {
+			lv := luaValue(v)
+			row.RawSetInt(i+1, lv)
+			row.RawSetString(cols[i], lv)
+		}
+		tbl.Append(row)
+		n++
+	}
+	return tbl, n, rows.Err()
+}
+
+// luaValue returns a Go value (from MySQL or a data generator) as a Lua value.
+func luaValue(v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case []byte:
+		return lua.LString(v)
+	case string:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case time.Time:
+		return lua.LString(v.Format("2006-01-02 15:04:05.999999"))
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// goValue returns a Lua value as a Go value for a query argument. Integral
+// numbers are int64 so they're not sent to MySQL as floats.
+func goValue(lv lua.LValue) interface{} {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return f
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	}
+	if lv == lua.LNil {
+		return nil
+	}
+	return lv.String()
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetSession returns a SET SESSION statement for the system variables
+// (config.workload.session), or an empty string if there are none. Variables
+// are sorted by name so the statement is deterministic. Numeric values and
+// DEFAULT are used as-is; other values are quoted as strings (unless already
+// quoted), so values like READ-COMMITTED don't need quotes in the config.
+func SetSession(vars map[string]string) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	set := make([]string, len(names))
+	for i, name := range names {
+		set[i] = name + "=" + sessionValue(vars[name])
+	}
+	return "SET SESSION " + strings.Join(set, ", ")
+}
+
+func sessionValue(v string) string {
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	if strings.EqualFold(v, "DEFAULT") {
+		return v
+	}
+	if len(v) > 1 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v
+	}
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
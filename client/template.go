@@ -0,0 +1,172 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/square/finch/trx"
+)
+
+// Template is a precompiled statement query for fast formatting in the client
+// hot path. Non-prepared queries are fmt formats with one verb per value, like
+// "SELECT c FROM t WHERE id=%d" (see trx.Statement.Query). Template splits the
+// query into literal segments at each verb once, then Append writes segments and
+// values to a reused buffer with strconv, which is much faster than fmt.Sprintf
+// and doesn't allocate for common value types. Format returns the query from the
+// buffer without copying it, so it doesn't allocate once the buffer has grown.
+//
+// Only the verbs that data generators use (%d, %s, %v) without flags or width
+// are supported. NewTemplate returns nil for other formats, and the client uses
+// fmt.Sprintf.
+//
+// A row batch template (NewBatchTemplate) compiles only one row of a /*!csv N*/
+// statement and appends it N times, so the template size doesn't grow with N.
+type Template struct {
+	literals []string // len(verbs)+1
+	verbs    []byte   // d, s, or v
+	static   string   // query if no verbs
+
+	// Row batch: literals and verbs are one row
+	rows   int
+	prefix *Template
+	suffix *Template
+}
+
+// NewTemplate compiles a query format, or returns nil if the format has an
+// unsupported verb.
+func NewTemplate(format string) *Template {
+	t := &Template{}
+	lit := make([]byte, 0, len(format))
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			lit = append(lit, format[i])
+			continue
+		}
+		if i+1 == len(format) {
+			return nil // trailing %
+		}
+		i++
+		switch format[i] {
+		case '%':
+			lit = append(lit, '%')
+		case 'd', 's', 'v':
+			t.literals = append(t.literals, string(lit))
+			t.verbs = append(t.verbs, format[i])
+			lit = lit[:0]
+		default:
+			return nil
+		}
+	}
+	t.literals = append(t.literals, string(lit))
+	if len(t.verbs) == 0 {
+		t.static = t.literals[0]
+	}
+	return t
+}
+
+// NewBatchTemplate compiles a /*!csv N*/ row batch, or returns nil if the
+// prefix, row, or suffix has an unsupported verb.
+func NewBatchTemplate(csv *trx.CSV) *Template {
+	t := NewTemplate(csv.Row)
+	if t == nil || csv.Rows == 0 {
+		return nil
+	}
+	t.static = ""
+	t.rows = int(csv.Rows)
+	if t.prefix = NewTemplate(csv.Prefix); t.prefix == nil {
+		return nil
+	}
+	if t.suffix = NewTemplate(csv.Suffix); t.suffix == nil {
+		return nil
+	}
+	return t
+}
+
+// Values returns the number of values the template formats.
+func (t *Template) Values() int {
+	if t.rows > 0 {
+		return t.prefix.Values() + t.rows*len(t.verbs) + t.suffix.Values()
+	}
+	return len(t.verbs)
+}
+
+// Append appends the query with the given values to b and returns the extended
+// buffer, like fmt.Appendf. The number of values must equal Values.
+func (t *Template) Append(b []byte, values []interface{}) []byte {
+	if t.rows > 0 {
+		return t.appendRows(b, values)
+	}
+	return t.appendRow(b, values)
+}
+
+func (t *Template) appendRow(b []byte, values []interface{}) []byte {
+	for i, v := range values {
+		b = append(b, t.literals[i]...)
+		b = appendValue(b, t.verbs[i], v)
+	}
+	return append(b, t.literals[len(t.literals)-1]...)
+}
+
+// appendRows appends prefix, rows, and suffix of a row batch.
+func (t *Template) appendRows(b []byte, values []interface{}) []byte {
+	n := t.prefix.Values()
+	b = t.prefix.Append(b, values[:n])
+	k := len(t.verbs)
+	for r := 0; r < t.rows; r++ {
+		if r > 0 {
+			b = append(b, ", "...)
+		}
+		b = t.appendRow(b, values[n:n+k])
+		n += k
+	}
+	return t.suffix.Append(b, values[n:])
+}
+
+// Format returns the query with the given values using b as the buffer. It's
+// equivalent to fmt.Sprintf(query, values...). The returned buffer should be
+// reused for the next call. The query string references the buffer (it's not
+// a copy), so it's valid only until the next call that reuses the buffer: the
+// caller must not keep it, and the client only sends it to MySQL.
+func (t *Template) Format(b []byte, values []interface{}) (string, []byte) {
+	if len(t.verbs) == 0 && t.rows == 0 {
+		return t.static, b
+	}
+	b = t.Append(b[:0], values)
+	if len(b) == 0 {
+		return "", b
+	}
+	return unsafe.String(unsafe.SliceData(b), len(b)), b
+}
+
+func appendValue(b []byte, verb byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if verb != 's' {
+			return strconv.AppendInt(b, v, 10)
+		}
+	case uint64:
+		if verb != 's' {
+			return strconv.AppendUint(b, v, 10)
+		}
+	case int:
+		if verb != 's' {
+			return strconv.AppendInt(b, int64(v), 10)
+		}
+	case uint:
+		if verb != 's' {
+			return strconv.AppendUint(b, uint64(v), 10)
+		}
+	case string:
+		if verb != 'd' {
+			return append(b, v...)
+		}
+	case []byte:
+		if verb == 's' {
+			return append(b, v...)
+		}
+	}
+	return fmt.Appendf(b, "%"+string(verb), v) // all other types and verbs
+}
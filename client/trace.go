@@ -0,0 +1,258 @@
+// Copyright 2024 Block, Inc.
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	myerr "github.com/go-mysql/errors"
+
+	"github.com/square/finch"
+	"github.com/square/finch/stats"
+	"github.com/square/finch/trx"
+)
+
+var (
+	// TraceBatchSize is the max number of spans per export request.
+	TraceBatchSize = 512
+
+	// TraceFlushInterval is how often buffered spans are exported.
+	TraceFlushInterval = time.Second
+)
+
+// Tracer exports a sample of executed statements as OpenTelemetry spans to an
+// OTLP/HTTP endpoint using JSON encoding, like http://localhost:4318/v1/traces.
+// It's enabled per client group (config.stage.workload.trace), and all clients
+// in the group (or groups that use the same endpoint) share the same Tracer.
+//
+// Spans are batched and exported in the background. If the buffer is full,
+// spans are dropped so that tracing never blocks clients.
+//
+// The run ID and tags (--run-id and --tag) are resource attributes finch.run_id
+// and finch.tag.KEY.
+type Tracer struct {
+	Sample uint // trace 1 in Sample statements per client
+	// --
+	url      string
+	spans    chan otlpSpan
+	stopChan chan struct{}
+	doneChan chan struct{}
+	start    *sync.Once
+	once     *sync.Once
+	started  bool
+	client   *http.Client
+}
+
+// NewTracer creates a Tracer that exports spans to url. Call Start to start
+// exporting, and Close to export remaining spans and stop. It doesn't start a
+// goroutine, so a Tracer for a stage that's prepared but never run doesn't leak.
+func NewTracer(url string, sample uint) *Tracer {
+	if sample == 0 {
+		sample = 1
+	}
+	t := &Tracer{
+		Sample:   sample,
+		url:      url,
+		spans:    make(chan otlpSpan, TraceBatchSize*4),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		start:    &sync.Once{},
+		once:     &sync.Once{},
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	return t
+}
+
+// Start starts exporting spans in the background. It's safe to call more than
+// once; only the first call starts exporting.
+func (t *Tracer) Start() {
+	t.start.Do(func() {
+		t.started = true
+		go t.export()
+	})
+}
+
+// Span records one statement as a span: statement number stmtNo (1-indexed)
+// executed by the client from t0 for duration d. If err is not nil, the span
+// status is error with the MySQL error code. It's safe to call from multiple
+// clients.
+func (t *Tracer) Span(clientId string, stmtNo int, stmt *trx.Statement, t0 time.Time, d time.Duration, err error) {
+	span := otlpSpan{
+		TraceId:   randomId(16),
+		SpanId:    randomId(8),
+		Name:      strings.ToUpper(reFirstWord.FindString(stmt.Query)),
+		Kind:      3, // SPAN_KIND_CLIENT
+		StartTime: strconv.FormatInt(t0.UnixNano(), 10),
+		EndTime:   strconv.FormatInt(t0.Add(d).UnixNano(), 10),
+		Attributes: []otlpAttr{
+			strAttr("db.system", "mysql"),
+			strAttr("db.statement", stmt.Query),
+			strAttr("finch.client", clientId),
+			strAttr("finch.trx", stmt.Trx),
+			intAttr("finch.statement", int64(stmtNo)),
+		},
+	}
+	if err != nil {
+		span.Attributes = append(span.Attributes, intAttr("db.mysql.error_code", int64(myerr.MySQLErrorCode(err))))
+		span.Status = &otlpStatus{Code: 2, Message: err.Error()} // STATUS_CODE_ERROR
+	}
+	select {
+	case t.spans <- span:
+	default: // buffer full, drop span
+	}
+}
+
+// Close exports remaining spans and stops the Tracer. It's safe to call more
+// than once.
+func (t *Tracer) Close() {
+	t.start.Do(func() {}) // not started: nothing to export or stop
+	if !t.started {
+		return
+	}
+	t.once.Do(func() { close(t.stopChan) })
+	select {
+	case <-t.doneChan:
+	case <-time.After(5 * time.Second):
+		log.Printf("Timeout exporting trace spans to %s", t.url)
+	}
+}
+
+func (t *Tracer) export() {
+	defer close(t.doneChan)
+	batch := make([]otlpSpan, 0, TraceBatchSize)
+	ticker := time.NewTicker(TraceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < TraceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stopChan:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			t.send(batch)
+			return
+		}
+		t.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (t *Tracer) send(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	attrs := []otlpAttr{strAttr("service.name", "finch")}
+	if stats.RunId != "" {
+		attrs = append(attrs, strAttr("finch.run_id", stats.RunId))
+	}
+	for k, v := range stats.Tags {
+		attrs = append(attrs, strAttr("finch.tag."+k, v))
+	}
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{Attributes: attrs},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "finch", Version: finch.VERSION},
+						Spans: batch,
+					},
+				},
+			},
+		},
+	}
+	body, _ := json.Marshal(req)
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error exporting %d trace spans to %s: %s", len(batch), t.url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Error exporting %d trace spans to %s: HTTP status %s", len(batch), t.url, resp.Status)
+	}
+}
+
+var reFirstWord = regexp.MustCompile(`^\w+`)
+
+func randomId(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// --------------------------------------------------------------------------
+// OTLP/JSON (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding)
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceId    string      `json:"traceId"`
+	SpanId     string      `json:"spanId"`
+	Name       string      `json:"name"`
+	Kind       int         `json:"kind"`
+	StartTime  string      `json:"startTimeUnixNano"`
+	EndTime    string      `json:"endTimeUnixNano"`
+	Attributes []otlpAttr  `json:"attributes"`
+	Status     *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 as string
+}
+
+func strAttr(k, v string) otlpAttr {
+	return otlpAttr{Key: k, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttr(k string, v int64) otlpAttr {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttr{Key: k, Value: otlpValue{IntValue: &s}}
+}
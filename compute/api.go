@@ -1,6 +1,11 @@
 package compute
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +13,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +23,8 @@ import (
 
 	"github.com/square/finch"
 	"github.com/square/finch/config"
+	"github.com/square/finch/limit"
+	"github.com/square/finch/proto"
 	"github.com/square/finch/stats"
 )
 
@@ -23,6 +33,9 @@ type API struct {
 	httpServer *http.Server
 	stage      *stageMeta // current stage
 	prev       map[string]string
+	token      string             // compute.token
+	ui         *dashboard         // nil unless compute.ui
+	marker     func(string) error // Server.Mark for POST /mark
 }
 
 const (
@@ -38,22 +51,107 @@ type stageMeta struct {
 	nRemotes uint
 	bootChan chan ack         // 1. <-client after booting stage
 	runChan  chan struct{}    // 2. server closes to signal clients to run
+	stopChan chan struct{}    // closed when done is set to stop clients early
 	doneChan chan ack         // 3. <-client after running stage
 	stats    *stats.Collector // receives stats from clients while running
+	arbiter  *limit.Arbiter   // shared limits leased by all instances
+	started  time.Time        // when server signaled instances to run
 	booted   bool
 	done     bool
 	clients  map[string]*client
+	names    map[string]bool // compute.remotes, nil if any remote can join
+	// Remotes that stopped sending heartbeats while running. They can rejoin
+	// (and are removed from gone) if they return before the stage is done.
+	gone       map[string]*client
+	rejoinChan chan string // <-client rejoined
 }
 
 type client struct {
-	name  string
-	stage *stageMeta
-	state byte
+	name     string
+	stage    *stageMeta
+	state    byte
+	lastSeen time.Time // last request from client (heartbeat)
+	health   Health    // last reported health (GET /ping)
 }
 
-func NewAPI(addr string) *API {
+// Health is a remote instance health reported in heartbeats (GET /ping).
+type Health struct {
+	Clients int     `json:"clients"` // clients running
+	Errors  int     `json:"errors"`  // clients stopped on error
+	CPU     float64 `json:"cpu"`     // percent of all CPUs
+}
+
+// Instance is one remote instance in the roster (GET /instances).
+type Instance struct {
+	Name     string  `json:"name"`
+	State    string  `json:"state"`
+	LastSeen float64 `json:"last-seen"` // seconds ago
+	Health   Health  `json:"health"`
+}
+
+var stateName = map[byte]string{
+	ready:    "ready",
+	booting:  "booting",
+	runnable: "runnable",
+	running:  "running",
+}
+
+// heartbeatTimeout is how long a remote instance can go without any request
+// (heartbeat, stats, etc.) before the server marks it lost. Remotes send a
+// heartbeat every second while running.
+const heartbeatTimeout = 10 * time.Second
+
+// roster returns the remote instances assigned to the stage. The caller must
+// not hold the stage lock.
+func (m *stageMeta) roster() []Instance {
+	m.Lock()
+	defer m.Unlock()
+	r := make([]Instance, 0, len(m.clients))
+	for _, rc := range m.clients {
+		r = append(r, Instance{
+			Name:     rc.name,
+			State:    stateName[rc.state],
+			LastSeen: time.Since(rc.lastSeen).Seconds(),
+			Health:   rc.health,
+		})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r
+}
+
+// lost removes and returns the names of running instances that haven't been
+// seen (sent any request) in the heartbeat timeout.
+func (m *stageMeta) lost() []string {
+	m.Lock()
+	defer m.Unlock()
+	var names []string
+	for name, rc := range m.clients {
+		if rc.state != running || time.Since(rc.lastSeen) < heartbeatTimeout {
+			continue
+		}
+		names = append(names, name)
+		delete(m.clients, name)
+		m.gone[name] = rc
+	}
+	return names
+}
+
+// stillGone returns the names of lost instances that did not rejoin.
+func (m *stageMeta) stillGone() []string {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]string, 0, len(m.gone))
+	for name := range m.gone {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewAPI(addr string, cfg config.Compute) *API {
 	a := &API{
 		Mutex: &sync.Mutex{},
+		token: cfg.Token,
 	}
 
 	// HTTP server that client instances calls
@@ -61,12 +159,34 @@ func NewAPI(addr string) *API {
 	mux.HandleFunc("/boot", a.boot)
 	mux.HandleFunc("/file", a.file)
 	mux.HandleFunc("/run", a.run)
+	mux.HandleFunc("/control", a.control)
 	mux.HandleFunc("/stats", a.stats)
 	mux.HandleFunc("/ping", a.ping)
+	mux.HandleFunc("/lease", a.lease)
+	mux.HandleFunc("/block", a.block)
+	mux.HandleFunc("/instances", a.instances)
+	mux.HandleFunc("/mark", a.mark)
+	if cfg.UI {
+		a.ui = newDashboard()
+		mux.HandleFunc("/ui", a.uiPage)
+		mux.HandleFunc("/ui/data", a.uiData)
+		scheme := "http"
+		if cfg.TLS.Set() {
+			scheme = "https"
+		}
+		log.Printf("Web dashboard: %s://%s/ui", scheme, addr)
+	}
 	a.httpServer = &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
+	if cfg.TLS.Set() {
+		tlsConfig, err := serverTLS(cfg.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		a.httpServer.TLSConfig = tlsConfig
+	}
 
 	// Make sure we can bind to addr:port. ListenAndServe will return an error
 	// but it's run in a goroutine so that error will occur async to the boot,
@@ -79,7 +199,13 @@ func NewAPI(addr string) *API {
 	}
 	ln.Close()
 	go func() {
-		if err := a.httpServer.ListenAndServe(); err != nil {
+		var err error
+		if a.httpServer.TLSConfig != nil {
+			err = a.httpServer.ListenAndServeTLS("", "") // cert and key in TLSConfig
+		} else {
+			err = a.httpServer.ListenAndServe()
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 		log.Println("Listening on", addr)
@@ -87,6 +213,21 @@ func NewAPI(addr string) *API {
 	return a
 }
 
+// serverTLS returns the TLS config for the API from compute.tls. If compute.tls.ca
+// is set, remote instances must present a cert signed by it (mTLS).
+func serverTLS(c config.TLS) (*tls.Config, error) {
+	tlsConfig, err := c.LoadTLS("")
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.RootCAs != nil {
+		tlsConfig.ClientCAs = tlsConfig.RootCAs
+		tlsConfig.RootCAs = nil
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
 // ServeHTTP implements the http.HandlerFunc interface.
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.httpServer.Handler.ServeHTTP(w, r)
@@ -113,7 +254,10 @@ func (a *API) Stage(newStage *stageMeta) error {
 	finch.Debug("stop old stage %s (%s)", oldStage.cfg.Name, oldStage.cfg.Id)
 	oldStage.Lock()
 	oldStage.done = true
-	if oldStage.cfg.Test {
+	close(oldStage.stopChan) // push stop to clients on the control stream
+	if oldStage.started.IsZero() {
+		// Never signaled to run (boot --test or before hook error): close to
+		// release remotes waiting on GET /run, which reset because done=true
 		close(oldStage.runChan)
 	}
 	oldStage.Unlock()
@@ -183,9 +327,18 @@ func (a *API) boot(w http.ResponseWriter, r *http.Request) {
 				goto RETRY // stage is full
 			}
 
+			// Is the stage for specific (named) remotes? If yes, and this isn't
+			// one of them, wait for the next stage which might be for this remote.
+			if stage.names != nil && !stage.names[rc.name] {
+				stage.Unlock()
+				a.Unlock()
+				goto RETRY // stage not for this remote
+			}
+
 			// Stage is ready and there's a space for this client
 			stage.clients[rc.name] = rc
 			rc.stage = stage
+			rc.lastSeen = time.Now()
 			rc.state = booting // advance client state
 
 			// Unwind locks before sending stage config via HTTP in case net is slow
@@ -240,7 +393,8 @@ func (a *API) file(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse file ref 'stage=...&i=...' from URL
+	// Parse file ref 'stage=...' and one of 'i=...' (trx file), 'aux=...'
+	// (auxiliary file), or 'base=1' (_all.yaml) from URL
 	q := r.URL.Query()
 	finch.Debug("file params %+v", q)
 	vals, ok := q["stage"]
@@ -253,41 +407,118 @@ func (a *API) file(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vals, ok = q["i"]
-	if !ok {
-		http.Error(w, "missing i param in URL query: i=N", http.StatusBadRequest)
+	s := rc.stage.cfg // shortcut
+	var file string
+	switch {
+	case q.Has("base"):
+		// Base config file is optional, so it's ok if it doesn't exist
+		file = filepath.Join(filepath.Dir(s.File), "_all.yaml")
+		if s.File == "" || !config.FileExists(file) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case q.Has("aux"):
+		aux := auxFiles(&s)
+		i, err := fileIndex(q, "aux", len(aux))
+		if err != nil {
+			http.Error(w, err.Error()+" for stage "+s.Name, http.StatusBadRequest)
+			return
+		}
+		file = *aux[i]
+		if file == "" {
+			http.Error(w, fmt.Sprintf("aux file %d not set for stage %s", i, s.Name), http.StatusBadRequest)
+			return
+		}
+	default:
+		i, err := fileIndex(q, "i", len(s.Trx))
+		if err != nil {
+			http.Error(w, err.Error()+" for stage "+s.Name, http.StatusBadRequest)
+			return
+		}
+		file = s.Trx[i].File
+	}
+
+	log.Printf("Sending file %s to %s...", file, rc.name)
+
+	// Read file and send it to the client instance
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		sendFile(&gzipResponseWriter{ResponseWriter: w, w: gz}, bytes)
+	} else {
+		sendFile(w, bytes)
+	}
+	log.Printf("Sent file %s to %s", file, rc.name)
+}
+
+// sendFile writes the file bytes and the SHA-256 checksum header that the client
+// uses to verify the file.
+func sendFile(w http.ResponseWriter, bytes []byte) {
+	sum := sha256.Sum256(bytes)
+	w.Header().Set(proto.ChecksumHeader, hex.EncodeToString(sum[:]))
+	w.Write(bytes)
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.w.Write(b)
+}
+
+// fileIndex returns the value of param, which must be a valid index in range [0, n).
+func fileIndex(q url.Values, param string, n int) (int, error) {
+	vals, ok := q[param]
+	if !ok {
+		return 0, fmt.Errorf("missing %s param in URL query: %s=N", param, param)
+	}
 	if len(vals) == 0 {
-		http.Error(w, "i param has no value, expected file number", http.StatusBadRequest)
-		return
+		return 0, fmt.Errorf("%s param has no value, expected file number", param)
 	}
 	i, err := strconv.Atoi(clean(vals[0]))
 	if err != nil {
-		http.Error(w, "i param is not an integer", http.StatusBadRequest)
-		return
+		return 0, fmt.Errorf("%s param is not an integer", param)
 	}
 	if i < 0 {
-		http.Error(w, "i param is negative", http.StatusBadRequest)
-		return
+		return 0, fmt.Errorf("%s param is negative", param)
 	}
-	s := rc.stage.cfg // shortcut
-	if i > len(s.Trx)-1 {
-		http.Error(w, "i param out of range for stage "+s.Name, http.StatusBadRequest)
-		return
+	if i > n-1 {
+		return 0, fmt.Errorf("%s param out of range", param)
 	}
+	return i, nil
+}
 
-	log.Printf("Sending file %s to %s...", s.Trx[i].File, rc.name)
-
-	// Read file and send it to the client instance
-	bytes, err := ioutil.ReadFile(s.Trx[i].File)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// auxFiles returns pointers to stage config values that are auxiliary files:
+// files other than trx files that remotes need to boot the stage. The order
+// is fixed because the client and server reference aux files by index. Scripts
+// (config.stage.workload.script) are last, including remote workload overrides,
+// so cfg must not have a remote override applied (config.Stage.Remote).
+func auxFiles(cfg *config.Stage) []*string {
+	files := []*string{
+		&cfg.MySQL.PasswordFile,
+		&cfg.MySQL.MyCnf,
+		&cfg.MySQL.TLS.CA,
+		&cfg.MySQL.TLS.Cert,
+		&cfg.MySQL.TLS.Key,
+	}
+	for i := range cfg.Workload {
+		files = append(files, &cfg.Workload[i].Script)
+	}
+	for i := range cfg.Compute.Remotes {
+		for j := range cfg.Compute.Remotes[i].Workload {
+			files = append(files, &cfg.Compute.Remotes[i].Workload[j].Script)
+		}
 	}
-
-	w.Write(bytes)
-	log.Printf("Sent file %s to %s", s.Trx[i].File, rc.name)
+	return files
 }
 
 func (a *API) run(w http.ResponseWriter, r *http.Request) {
@@ -342,8 +573,14 @@ func (a *API) run(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 
 		rc.stage.Lock()
+		_, assigned := rc.stage.clients[rc.name]
 		delete(rc.stage.clients, rc.name)
 		rc.stage.Unlock()
+		if !assigned {
+			// Server marked client lost (and stopped waiting for it) after
+			// a.client returned rc but before we got here
+			return
+		}
 
 		// Tell server client completed stage
 		var clientErr error
@@ -355,6 +592,95 @@ func (a *API) run(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// control streams control events to a booted client (GET /control) so it doesn't
+// poll: run when the server signals clients to run (like GET /run), stop when
+// the stage is stopped early (like 205 Reset Content on GET /ping), and ping
+// every second to keep the connection alive. Events are newline-delimited JSON
+// (proto.Event). The stream ends after stop or when the client closes it.
+// Clients that don't stream (or lose the stream) poll GET /run and GET /ping.
+// It's only server push for run and stop signals. Out of scope: streaming stats
+// (clients send each interval with POST /stats so it's acked, retried, and
+// spooled to disk if the server is not reachable), other requests on the stream,
+// and gRPC or WebSocket transport.
+func (a *API) control(w http.ResponseWriter, r *http.Request) {
+	rc, get, ok := a.client(w, r, false)
+	if !ok {
+		return // client() wrote error response
+	}
+	if !get {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if rc.state != runnable && rc.state != running {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	send := func(event string) bool {
+		if err := enc.Encode(proto.Event{Event: event}); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	if !send(proto.EventPing) {
+		return
+	}
+
+	stage := rc.stage // copy ptr
+	runChan := stage.runChan
+	if rc.state == running {
+		runChan = nil // reconnected stream while running
+	}
+	ping := time.NewTicker(time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-runChan:
+			runChan = nil
+			stage.Lock()
+			done := stage.done
+			stage.Unlock()
+			if done {
+				continue // stopChan closed, too
+			}
+			if !send(proto.EventRun) {
+				log.Printf("Lost client %s on stage %s, but it will return\n", rc.name, stage.cfg.Name)
+				return
+			}
+			log.Printf("Started client %s on stage %s\n", rc.name, stage.cfg.Name)
+			rc.state = running // advance client state
+		case <-stage.stopChan:
+			if rc.state != running {
+				// Stopped before running (like boot --test): the client won't
+				// POST /run, so remove it like GET /run does
+				stage.Lock()
+				delete(stage.clients, rc.name)
+				stage.Unlock()
+			}
+			send(proto.EventStop)
+			return
+		case <-ping.C:
+			if !send(proto.EventPing) {
+				return
+			}
+			stage.Lock()
+			rc.lastSeen = time.Now() // stream is a heartbeat, too
+			stage.Unlock()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
 func (a *API) stats(w http.ResponseWriter, r *http.Request) {
 	rc, _, ok := a.client(w, r, false)
 	if !ok {
@@ -393,7 +719,15 @@ func (a *API) ping(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return // client() wrote error response
 	}
+	// Heartbeat with instance health 'clients=...&errors=...&cpu=...'
+	q := r.URL.Query()
+	var h Health
+	h.Clients, _ = strconv.Atoi(q.Get("clients"))
+	h.Errors, _ = strconv.Atoi(q.Get("errors"))
+	h.CPU, _ = strconv.ParseFloat(q.Get("cpu"), 64)
+
 	rc.stage.Lock()
+	rc.health = h
 	done := rc.stage.done
 	rc.stage.Unlock()
 	if done {
@@ -404,8 +738,122 @@ func (a *API) ping(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK) // keep running
 }
 
+func (a *API) lease(w http.ResponseWriter, r *http.Request) {
+	rc, _, ok := a.client(w, r, false)
+	if !ok {
+		return // client() wrote error response
+	}
+
+	// Limits are leased only while running
+	if rc.state != running {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// Parse lease 'key=...&max=...&n=...' from URL
+	q := r.URL.Query()
+	key := clean(q.Get("key"))
+	if key == "" {
+		http.Error(w, "missing key param in URL query: ?key=...", http.StatusBadRequest)
+		return
+	}
+	max, err := strconv.ParseUint(q.Get("max"), 10, 64)
+	if err != nil {
+		http.Error(w, "max param is not an integer", http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.ParseUint(q.Get("n"), 10, 64)
+	if err != nil {
+		http.Error(w, "n param is not an integer", http.StatusBadRequest)
+		return
+	}
+
+	var leased uint64
+	if rc.stage.arbiter != nil {
+		leased = rc.stage.arbiter.Lease(r.Context(), key, max, n)
+	}
+	finch.Debug("%s leased %s: %d", rc.name, key, leased)
+	w.Write([]byte(strconv.FormatUint(leased, 10)))
+}
+
+func (a *API) block(w http.ResponseWriter, r *http.Request) {
+	rc, _, ok := a.client(w, r, false)
+	if !ok {
+		return // client() wrote error response
+	}
+
+	// Blocks are leased only while running
+	if rc.state != running {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	key := clean(r.URL.Query().Get("key"))
+	if key == "" {
+		http.Error(w, "missing key param in URL query: ?key=...", http.StatusBadRequest)
+		return
+	}
+	if rc.stage.arbiter == nil {
+		http.Error(w, "no arbiter for stage", http.StatusPreconditionFailed)
+		return
+	}
+	b, _ := rc.stage.arbiter.Block(key)
+	finch.Debug("%s leased block %s: %d", rc.name, key, b)
+	w.Write([]byte(strconv.FormatUint(b, 10)))
+}
+
+func (a *API) instances(w http.ResponseWriter, r *http.Request) {
+	if !a.auth(w, r) {
+		return
+	}
+	a.Lock()
+	stage := a.stage
+	a.Unlock()
+	roster := []Instance{}
+	if stage != nil {
+		roster = stage.roster()
+	}
+	json.NewEncoder(w).Encode(roster)
+}
+
+// mark records a named marker in the stats of the running stage: POST /mark?name=...
+func (a *API) mark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.auth(w, r) {
+		return
+	}
+	name := clean(r.URL.Query().Get("name"))
+	if name == "" {
+		http.Error(w, "missing name param in URL query: ?name=...", http.StatusBadRequest)
+		return
+	}
+	if a.marker == nil {
+		http.Error(w, "marks not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := a.marker(name); err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // --------------------------------------------------------------------------
 
+// auth checks the shared token (compute.token), if set. If the request is not
+// authorized, it writes the error response and returns false.
+func (a *API) auth(w http.ResponseWriter, r *http.Request) bool {
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(proto.TokenHeader)), []byte(a.token)) != 1 {
+		log.Printf("Unauthorized request from %s (invalid or missing token)", clean(r.RemoteAddr))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func (a *API) client(w http.ResponseWriter, r *http.Request, boot bool) (*client, bool, bool) {
 	finch.Debug("%v", r)
 
@@ -420,6 +868,10 @@ func (a *API) client(w http.ResponseWriter, r *http.Request, boot bool) (*client
 		return nil, false, false
 	}
 
+	if !a.auth(w, r) {
+		return nil, false, false
+	}
+
 	// ?name=...
 	q := r.URL.Query()
 	if len(q) == 0 {
@@ -462,6 +914,20 @@ func (a *API) client(w http.ResponseWriter, r *http.Request, boot bool) (*client
 
 	// Is instance assigned to the current stage?
 	rc, ok := a.stage.clients[name]
+	if !ok && a.stage.gone[name] != nil && a.stage.cfg.Id == sid {
+		// Instance was lost (no heartbeat) while running, but it's back and
+		// kept running, so let it rejoin the stage, or reset it if stage is done
+		if a.stage.done {
+			w.WriteHeader(http.StatusResetContent) // reset
+			return nil, false, false
+		}
+		rc = a.stage.gone[name]
+		delete(a.stage.gone, name)
+		a.stage.clients[name] = rc
+		log.Printf("Remote %s rejoined stage %s", name, a.stage.cfg.Name)
+		a.stage.rejoinChan <- name // buffered: at most 1 per lost remote, never blocks
+		ok = true
+	}
 	if !ok {
 
 		// Instance not assigned to the stage, but that's ok if it's trying
@@ -493,6 +959,9 @@ func (a *API) client(w http.ResponseWriter, r *http.Request, boot bool) (*client
 		return nil, false, false
 	}
 
+	// Any request is a heartbeat
+	rc.lastSeen = time.Now()
+
 	return rc, get, true // success
 }
 
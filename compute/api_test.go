@@ -0,0 +1,161 @@
+package compute_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/compute"
+	"github.com/square/finch/config"
+	"github.com/square/finch/proto"
+)
+
+func TestAPI_Token(t *testing.T) {
+	a := compute.NewAPI("127.0.0.1:0", config.Compute{Token: "secret"})
+
+	// No token: 401
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/ping?name=test&stage-id=", nil)
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: got status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Wrong token: 401
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/ping?name=test&stage-id=", nil)
+	r.Header.Set(proto.TokenHeader, "wrong")
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Correct token: 410 because there's no stage, but the request is authorized
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/ping?name=test&stage-id=", nil)
+	r.Header.Set(proto.TokenHeader, "secret")
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusGone {
+		t.Errorf("valid token: got status %d, expected %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestAPI_Mark(t *testing.T) {
+	a := compute.NewAPI("127.0.0.1:0", config.Compute{})
+
+	for _, c := range []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "/mark?name=failover", http.StatusMethodNotAllowed},
+		{"POST", "/mark", http.StatusBadRequest},
+		{"POST", "/mark?name=failover", http.StatusNotImplemented}, // no server
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.url, nil)
+		a.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s %s: got status %d, expected %d", c.method, c.url, w.Code, c.code)
+		}
+	}
+
+	// Server without a running stage can't record a mark
+	s := compute.NewServer("local", "", config.Compute{}, false)
+	if err := s.Mark("failover"); err == nil {
+		t.Error("Mark returned nil error, expected error because no stage is running")
+	}
+}
+
+func TestAPI_Instances(t *testing.T) {
+	a := compute.NewAPI("127.0.0.1:0", config.Compute{})
+
+	// No stage: empty roster
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/instances", nil)
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, expected %d", w.Code, http.StatusOK)
+	}
+	var got []compute.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d instances, expected 0: %+v", len(got), got)
+	}
+}
+
+func TestAPI_UI(t *testing.T) {
+	a := compute.NewAPI("127.0.0.1:0", config.Compute{UI: true, Token: "secret"})
+
+	// Page doesn't require token because it has no data
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/ui", nil)
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /ui: got status %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	// Data requires token
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/ui/data", nil)
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /ui/data without token: got status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/ui/data", nil)
+	r.Header.Set(proto.TokenHeader, "secret")
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /ui/data: got status %d, expected %d", w.Code, http.StatusOK)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["instances"]; !ok {
+		t.Errorf("no instances in response: %v", got)
+	}
+}
+
+func TestAPI_Control(t *testing.T) {
+	a := compute.NewAPI("127.0.0.1:0", config.Compute{})
+
+	// No stage: 410 Gone, so the client falls back to polling
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	c := proto.NewClient("test", srv.URL)
+	if _, err := c.Events(context.Background(), "/control"); err == nil {
+		t.Error("no error opening control stream without a stage, expected one")
+	}
+
+	// Events are newline-delimited JSON pushed by the server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		for _, e := range []string{proto.EventPing, proto.EventRun, proto.EventStop} {
+			enc.Encode(proto.Event{Event: e})
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+	c = proto.NewClient("test", srv.URL)
+	events, err := c.Events(context.Background(), "/control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for e := range events {
+		got = append(got, e)
+	}
+	expect := []string{proto.EventPing, proto.EventRun, proto.EventStop}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
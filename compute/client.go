@@ -4,13 +4,20 @@ package compute
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/square/finch"
@@ -33,7 +40,7 @@ type Client struct {
 }
 
 func NewClient(name, addr string) *Client {
-	if !strings.HasPrefix(addr, "http://") {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
 		addr = "http://" + addr
 	}
 
@@ -46,6 +53,34 @@ func NewClient(name, addr string) *Client {
 	}
 }
 
+// Auth sets TLS and the shared token for all requests to the server at addr,
+// which must match compute.tls and compute.token on the server. It must be
+// called before NewClient. If TLS is set, it returns addr with an https://
+// prefix.
+func Auth(addr string, tlsCfg config.TLS, token string) (string, error) {
+	var tlsConfig *tls.Config
+	if tlsCfg.Set() {
+		if err := tlsCfg.Validate(); err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(addr, "https://") {
+			addr = "https://" + strings.TrimPrefix(addr, "http://")
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", err
+		}
+		tlsConfig, err = tlsCfg.LoadTLS(u.Hostname())
+		if err != nil {
+			return "", err
+		}
+	}
+	if tlsConfig != nil || token != "" {
+		finch.MakeHTTPClient = proto.MakeHTTPClient(tlsConfig, token)
+	}
+	return addr, nil
+}
+
 func (c *Client) Run(ctxFinch context.Context) error {
 	//for {
 	c.gds.Reset() // keep data from globally-scoped generators; delete the rest
@@ -77,7 +112,9 @@ func (c *Client) run(ctxFinch context.Context) error {
 	stageName := cfg.Name
 	c.client.StageId = cfg.Id
 	defer func() { c.client.StageId = "" }()
-	fmt.Printf("#\n# %s (%s)\n#\n", stageName, cfg.Id)
+	if stats.Output != stats.OUTPUT_JSON { // STDOUT is only JSON
+		fmt.Printf("#\n# %s (%s)\n#\n", stageName, cfg.Id)
+	}
 
 	// ----------------------------------------------------------------------
 	// Fetch all stage and trx files from server, put in local temp dir
@@ -89,9 +126,10 @@ func (c *Client) run(ctxFinch context.Context) error {
 		defer os.RemoveAll(tmpdir)
 	}
 	finch.Debug("tmp dir: %s", tmpdir)
-	if err := c.getTrxFiles(ctxFinch, cfg, tmpdir); err != nil {
+	if err := c.getFiles(ctxFinch, &cfg, tmpdir); err != nil {
 		return err
 	}
+	cfg = cfg.Remote(c.name) // apply workload override for this remote, if any (after getFiles: auxFiles)
 
 	// ------------------------------------------------------------------
 	// Local boot and ack
@@ -105,6 +143,7 @@ func (c *Client) run(ctxFinch context.Context) error {
 		"server":   c.addr,
 		"client":   c.name,
 		"stage-id": c.client.StageId,
+		"spool":    cfg.Stats.Spool,
 	}
 	stats, err := stats.NewCollector(cfg.Stats, c.name, 1)
 	if err != nil {
@@ -112,7 +151,7 @@ func (c *Client) run(ctxFinch context.Context) error {
 	}
 
 	log.Printf("[%s] Booting", stageName)
-	local := stage.New(cfg, c.gds, stats)
+	local := stage.New(cfg, c.gds, stats, c.lease, c.block)
 	if err := local.Prepare(ctxFinch); err != nil {
 		log.Printf("[%s] Boot error, notifying server: %s", stageName, err)
 		c.client.Send(ctxFinch, "/boot", err.Error(), proto.R{500 * time.Millisecond, 100 * time.Millisecond, 3}) // don't care if this fails
@@ -128,14 +167,21 @@ func (c *Client) run(ctxFinch context.Context) error {
 
 	// ----------------------------------------------------------------------
 	// Wait for run signal. This might be a little while if server is for
-	// other remote instances.
+	// other remote instances. The server pushes run and stop signals on the
+	// control stream, if it can be opened, else the client polls.
 	log.Printf("[%s] Waiting for run signal", stageName)
-	resp, _, err := c.client.Get(ctxFinch, "/run", nil, proto.R{60 * time.Second, 100 * time.Millisecond, 3})
+	ctxControl, cancelControl := context.WithCancel(ctxFinch)
+	defer cancelControl()
+	events, err := c.client.Events(ctxControl, "/control")
+	if err != nil {
+		finch.Debug("no control stream, polling: %s", err)
+	}
+	run, err := c.waitRun(ctxFinch, events)
 	if err != nil {
 		log.Printf("[%s] Timeout waiting for run signal after successful boot, giving up (is the server offline?)", stageName)
 		return err
 	}
-	if resp.StatusCode == http.StatusResetContent {
+	if !run {
 		log.Printf("[%s] Boot test successful", stageName)
 		return nil
 	}
@@ -146,9 +192,22 @@ func (c *Client) run(ctxFinch context.Context) error {
 	doneChan := make(chan struct{})
 	defer close(doneChan)
 	lostServer := false
-	stageDone := false
+	var stageDone atomic.Bool // server stopped stage: control stream or heartbeat
+	if events != nil {
+		go func() {
+			for e := range events {
+				if e == proto.EventStop && !stageDone.Swap(true) {
+					log.Printf("[%s] Server stopped stage", stageName)
+					cancelRun()
+					return
+				}
+			}
+		}()
+	}
 	go func() {
 		defer cancelRun()
+		cpu := newCPUUsage()
+		var lostAt time.Time // when contact with server was lost
 		for {
 			time.Sleep(1 * time.Second)
 			select {
@@ -157,22 +216,42 @@ func (c *Client) run(ctxFinch context.Context) error {
 				return
 			default:
 			}
-			resp, _, err := c.client.Get(ctxFinch, "/ping", nil, proto.R{500 * time.Millisecond, 100 * time.Millisecond, 5})
+			// Heartbeat with instance health
+			running, errors := local.Health()
+			health := [][]string{
+				{"clients", strconv.Itoa(running)},
+				{"errors", strconv.Itoa(errors)},
+				{"cpu", strconv.FormatFloat(cpu.percent(), 'f', 1, 64)},
+			}
+			resp, _, err := c.client.Get(ctxFinch, "/ping", health, proto.R{500 * time.Millisecond, 100 * time.Millisecond, 5})
 			if err != nil {
-				log.Printf("[%s] Lost contact with server while running, aborting", stageName)
-				lostServer = true
-				return
+				// Keep running (stats are buffered to disk) and rejoin the server
+				// when it's reachable again, unless it's been too long
+				if lostAt.IsZero() {
+					lostAt = time.Now()
+					log.Printf("[%s] Lost contact with server while running; still running and buffering stats until server returns (timeout %s)", stageName, rejoinTimeout)
+				} else if time.Since(lostAt) > rejoinTimeout {
+					log.Printf("[%s] Lost contact with server for %s, aborting", stageName, rejoinTimeout)
+					lostServer = true
+					return
+				}
+				continue
+			}
+			if !lostAt.IsZero() {
+				log.Printf("[%s] Rejoined server after %s", stageName, time.Since(lostAt).Round(time.Second))
+				lostAt = time.Time{}
 			}
 			if resp.StatusCode == http.StatusResetContent {
-				stageDone = true
-				log.Printf("[%s] Server stopped stage", stageName)
+				if !stageDone.Swap(true) {
+					log.Printf("[%s] Server stopped stage", stageName)
+				}
 				return
 			}
 		}
 	}()
 
 	local.Run(ctxRun)
-	log.Printf("[%s] Run stopped: %v (lost server:%v stage stopped:%v); sending done signal to server (5s timeout)", stageName, err, lostServer, stageDone)
+	log.Printf("[%s] Run stopped: %v (lost server:%v stage stopped:%v); sending done signal to server (5s timeout)", stageName, err, lostServer, stageDone.Load())
 
 	// Run ack; ok if this fails because we're done, nothing left to sync with server
 	ctxDone, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -184,7 +263,99 @@ func (c *Client) run(ctxFinch context.Context) error {
 	return nil
 }
 
-func (c *Client) getTrxFiles(ctxFinch context.Context, cfg config.Stage, tmpdir string) error {
+// waitRun waits for the run signal from the server: the run event on the control
+// stream (events), or GET /run if there's no stream or it ends before the run
+// signal. It returns false if the stage was stopped before running, which is
+// normal for boot --test.
+func (c *Client) waitRun(ctx context.Context, events <-chan string) (bool, error) {
+	if events != nil {
+		for e := range events {
+			switch e {
+			case proto.EventRun:
+				return true, nil
+			case proto.EventStop:
+				return false, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		log.Printf("Lost control stream waiting for run signal, polling")
+	}
+	resp, _, err := c.client.Get(ctx, "/run", nil, proto.R{60 * time.Second, 100 * time.Millisecond, 3})
+	if err != nil {
+		return false, err
+	}
+	return resp.StatusCode != http.StatusResetContent, nil
+}
+
+// rejoinTimeout is how long a remote keeps running after losing contact with
+// the server. If the server returns before the timeout, the remote rejoins the
+// stage and sends stats buffered while the server was unreachable.
+const rejoinTimeout = 5 * time.Minute
+
+// cpuUsage measures process CPU usage between calls to percent.
+type cpuUsage struct {
+	cpu  time.Duration
+	wall time.Time
+}
+
+func newCPUUsage() *cpuUsage {
+	return &cpuUsage{
+		cpu:  cpuTime(),
+		wall: time.Now(),
+	}
+}
+
+// percent returns CPU usage (percent of all CPUs) since the last call.
+func (u *cpuUsage) percent() float64 {
+	cpu, wall := cpuTime(), time.Now()
+	d := wall.Sub(u.wall)
+	p := 0.0
+	if d > 0 {
+		p = 100 * float64(cpu-u.cpu) / float64(d) / float64(runtime.NumCPU())
+	}
+	u.cpu, u.wall = cpu, wall
+	return p
+}
+
+// lease implements a limit.LeaseFunc by leasing from the server. If the server
+// doesn't respond or ctx is done, it returns zero (no more) because the local
+// instance cannot know if the aggregate limit has been reached.
+func (c *Client) lease(ctx context.Context, key string, max, n uint64) uint64 {
+	params := [][]string{
+		{"key", key},
+		{"max", strconv.FormatUint(max, 10)},
+		{"n", strconv.FormatUint(n, 10)},
+	}
+	_, body, err := c.client.Get(ctx, "/lease", params, proto.R{1 * time.Second, 100 * time.Millisecond, 3})
+	if err != nil {
+		log.Printf("Error leasing %s from server, stopping: %s", key, err)
+		return 0
+	}
+	leased, err := strconv.ParseUint(string(body), 10, 64)
+	if err != nil {
+		log.Printf("Invalid lease %s from server, stopping: %s", key, err)
+		return 0
+	}
+	return leased
+}
+
+// block implements a limit.BlockFunc by leasing from the server. Unlike lease,
+// there's no safe default, so it returns an error if the server doesn't respond.
+func (c *Client) block(key string) (uint64, error) {
+	_, body, err := c.client.Get(context.Background(), "/block", [][]string{{"key", key}}, proto.R{1 * time.Second, 100 * time.Millisecond, 3})
+	if err != nil {
+		log.Printf("Error leasing block %s from server: %s", key, err)
+		return 0, err
+	}
+	return strconv.ParseUint(string(body), 10, 64)
+}
+
+// getFiles fetches trx files, auxiliary files, and the base config file (_all.yaml),
+// if any, from the server, puts them in tmpdir, and changes cfg to reference the
+// local trx and auxiliary files.
+func (c *Client) getFiles(ctxFinch context.Context, cfg *config.Stage, tmpdir string) error {
 	trx := cfg.Trx
 	for i := range trx {
 		if config.FileExists(trx[i].File) {
@@ -196,25 +367,74 @@ func (c *Client) getTrxFiles(ctxFinch context.Context, cfg config.Stage, tmpdir
 			{"stage", cfg.Name},
 			{"i", fmt.Sprintf("%d", i)},
 		}
-		resp, body, err := c.client.Get(ctxFinch, "/file", ref, proto.R{5 * time.Second, 100 * time.Millisecond, 3})
+		filename, err := c.getFile(ctxFinch, ref, filepath.Base(trx[i].File), tmpdir)
 		if err != nil {
-			return err // Get retries so error is final
+			return err
 		}
-		finch.Debug("%+v", resp)
+		trx[i].File = filename
+	}
 
-		filename := filepath.Join(tmpdir, filepath.Base(trx[i].File))
-		f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0440)
-		if err != nil {
-			return err
+	// Auxiliary files like mysql.password-file and mysql.tls.ca are fetched
+	// only if they don't exist locally, like trx files
+	for i, file := range auxFiles(cfg) {
+		if *file == "" || config.FileExists(*file) {
+			continue
 		}
-		if _, err := f.Write(body); err != nil {
-			return err
+		log.Printf("Fetching stage %s file %s...", cfg.Name, *file)
+		ref := [][]string{
+			{"stage", cfg.Name},
+			{"aux", fmt.Sprintf("%d", i)},
 		}
-		if err := f.Close(); err != nil {
+		filename, err := c.getFile(ctxFinch, ref, fmt.Sprintf("aux%d-%s", i, filepath.Base(*file)), tmpdir)
+		if err != nil {
 			return err
 		}
-		finch.Debug("wrote %s", filename)
-		trx[i].File = filename
+		*file = filename
+	}
+
+	// The server already applied the base config file (_all.yaml) to cfg,
+	// but fetch it (if any) so tmpdir has all the files that define the stage
+	ref := [][]string{
+		{"stage", cfg.Name},
+		{"base", "1"},
+	}
+	if _, err := c.getFile(ctxFinch, ref, "_all.yaml", tmpdir); err != nil {
+		return err
 	}
 	return nil
 }
+
+// getFile fetches one file from the server, verifies its checksum, and writes it
+// to tmpdir as name. It returns the full path to the file, or an empty string if
+// the server doesn't have the file (204 No Content), which happens only for the
+// optional base config file. The server gzips the file, but the HTTP client
+// transparently decompresses it.
+func (c *Client) getFile(ctxFinch context.Context, ref [][]string, name, tmpdir string) (string, error) {
+	resp, body, err := c.client.Get(ctxFinch, "/file", ref, proto.R{5 * time.Second, 100 * time.Millisecond, 3})
+	if err != nil {
+		return "", err // Get retries so error is final
+	}
+	finch.Debug("%+v", resp)
+	if resp.StatusCode == http.StatusNoContent {
+		return "", nil
+	}
+
+	sum := sha256.Sum256(body)
+	if got, expect := hex.EncodeToString(sum[:]), resp.Header.Get(proto.ChecksumHeader); got != expect {
+		return "", fmt.Errorf("file %s checksum mismatch: got %s, expected %s (file corrupted in transfer)", name, got, expect)
+	}
+
+	filename := filepath.Join(tmpdir, name)
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0440)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(body); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	finch.Debug("wrote %s", filename)
+	return filename, nil
+}
@@ -0,0 +1,12 @@
+// Copyright 2024 Block, Inc.
+
+//go:build !unix
+
+package compute
+
+import "time"
+
+// cpuTime is not supported on this platform, so heartbeats report 0% CPU.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -8,13 +8,16 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/xid"
 
 	"github.com/square/finch"
 	"github.com/square/finch/config"
 	"github.com/square/finch/data"
+	"github.com/square/finch/limit"
 	"github.com/square/finch/stage"
 	"github.com/square/finch/stats"
 )
@@ -26,8 +29,13 @@ type Server struct {
 	name string // defaults to "local"
 	test bool
 	// --
-	gds *data.Scope // global data scope
-	cfg config.Stage
+	gds     *data.Scope    // global data scope
+	arbiter *limit.Arbiter // key blocks for the whole run; see stageMeta.arbiter
+	cfg     config.Stage
+
+	mux       *sync.Mutex
+	running   *stageMeta       // current stage while running, for Mark
+	reporters []stats.Reporter // AddReporter
 }
 
 type ack struct {
@@ -35,18 +43,63 @@ type ack struct {
 	err  error
 }
 
-func NewServer(name, addr string, test bool) *Server {
+func NewServer(name, addr string, cfg config.Compute, test bool) *Server {
 	s := &Server{
-		name: name,
-		test: test,
-		gds:  data.NewScope(), // global data
+		name:    name,
+		test:    test,
+		gds:     data.NewScope(), // global data
+		arbiter: limit.NewArbiter(),
+		mux:     &sync.Mutex{},
 	}
 	if addr != "" {
-		s.api = NewAPI(finch.WithPort(addr, finch.DEFAULT_SERVER_PORT))
+		s.api = NewAPI(finch.WithPort(addr, finch.DEFAULT_SERVER_PORT), cfg)
+		s.api.marker = s.Mark
 	}
 	return s
 }
 
+// Mark records a named marker, like "failover started", in the stats of the
+// running stage so external events can be aligned with stats intervals. It's
+// reported like a scheduled event (config.stage.events). It's called on SIGUSR1
+// and POST /mark, and it returns an error if no stage is running or stats are
+// disabled.
+func (s *Server) Mark(name string) error {
+	s.mux.Lock()
+	m := s.running
+	s.mux.Unlock()
+	if m == nil {
+		return fmt.Errorf("no stage running")
+	}
+	if m.stats == nil {
+		return fmt.Errorf("stats disabled in stage %s", m.cfg.Name)
+	}
+	now := time.Now()
+	m.stats.Event(stats.Event{
+		Time:    now,
+		Runtime: now.Sub(m.started).Seconds(),
+		Event:   "mark: " + name,
+	})
+	log.Printf("[%s] mark: %s", m.cfg.Name, name)
+	return nil
+}
+
+// AddReporter adds a stats reporter to every stage that has stats enabled, in
+// addition to the reporters configured by the stage. --matrix uses it to summarize
+// each run. It must be called before Run.
+func (s *Server) AddReporter(r stats.Reporter) {
+	s.reporters = append(s.reporters, r)
+}
+
+// RemoveReporter removes a reporter added by AddReporter.
+func (s *Server) RemoveReporter(r stats.Reporter) {
+	for i := range s.reporters {
+		if s.reporters[i] == r {
+			s.reporters = append(s.reporters[:i], s.reporters[i+1:]...)
+			return
+		}
+	}
+}
+
 func (s *Server) Run(ctxFinch context.Context, stages []config.Stage) error {
 	for _, cfg := range stages {
 		// cd dir of config file so relative file paths in config work
@@ -66,6 +119,22 @@ func (s *Server) Run(ctxFinch context.Context, stages []config.Stage) error {
 	return nil
 }
 
+// DryRun prints n iterations of every client's statements in all stages
+// without connecting to MySQL (--dry-run). Only the local instance is used.
+func (s *Server) DryRun(stages []config.Stage, n uint) error {
+	for _, cfg := range stages {
+		if err := os.Chdir(filepath.Dir(cfg.File)); err != nil {
+			return err
+		}
+		fmt.Printf("#\n# %s\n#\n", cfg.Name)
+		s.gds.Reset()
+		if err := stage.New(cfg, s.gds, nil, nil, nil).DryRun(os.Stdout, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Run runs all the stages on all the instances (local and remote).
 func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 	var err error
@@ -76,11 +145,15 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 	if cfg.Compute.DisableLocal {
 		nRemotes += 1 // no local, so all instances are remote
 	}
-	if nRemotes == 0 {
-		fmt.Printf("#\n# %s\n#\n", stageName)
-	} else {
+	if nRemotes > 0 {
 		cfg.Id = xid.New().String() // unique stage ID for remotes
-		fmt.Printf("#\n# %s (%s)\n#\n", stageName, cfg.Id)
+	}
+	if stats.Output != stats.OUTPUT_JSON { // STDOUT is only JSON
+		if nRemotes == 0 {
+			fmt.Printf("#\n# %s\n#\n", stageName)
+		} else {
+			fmt.Printf("#\n# %s (%s)\n#\n", stageName, cfg.Id)
+		}
 	}
 
 	m := &stageMeta{
@@ -89,15 +162,58 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 		nRemotes: nRemotes,
 		bootChan: make(chan ack, nInstances),
 		runChan:  make(chan struct{}),
+		stopChan: make(chan struct{}),
 		doneChan: make(chan ack, nInstances),
 		clients:  map[string]*client{},
+		// --
+		gone:       map[string]*client{},
+		rejoinChan: make(chan string, nInstances),
+	}
+	if len(cfg.Compute.Remotes) > 0 {
+		m.names = map[string]bool{}
+		for _, r := range cfg.Compute.Remotes {
+			m.names[r.Name] = true
+		}
+	}
+
+	// Remotes lease shared limits (like row limits) and key blocks from the
+	// server, and so does the local instance, so the aggregate limit is respected
+	// and keys don't collide. Key blocks are leased from the same arbiter in every
+	// stage, even local-only stages, so they don't restart at block 0.
+	s.arbiter = s.arbiter.NextStage()
+	m.arbiter = s.arbiter
+	block := m.arbiter.Block
+	var lease limit.LeaseFunc
+	if nRemotes > 0 {
+		lease = m.arbiter.Lease
 	}
 
 	if !config.True(cfg.Stats.Disable) {
+		// The mysql reporter records the stage name, which reporters don't
+		// otherwise know
+		if opts, ok := cfg.Stats.Report["mysql"]; ok && opts["stage"] == "" {
+			opts["stage"] = stageName
+		}
+		// --output json prints the summary to STDOUT even if the stdout
+		// reporter isn't configured
+		if _, ok := cfg.Stats.Report["stdout"]; !ok && stats.Output == stats.OUTPUT_JSON {
+			cfg.Stats.Report["stdout"] = map[string]string{}
+		}
 		m.stats, err = stats.NewCollector(cfg.Stats, s.name, nInstances)
 		if err != nil {
 			return err
 		}
+		for _, r := range s.reporters {
+			m.stats.AddReporter(r)
+		}
+	}
+
+	// Web dashboard shows the current stage and its stats (if enabled)
+	if s.api != nil && s.api.ui != nil {
+		s.api.ui.reset(m)
+		if m.stats != nil {
+			m.stats.AddReporter(s.api.ui)
+		}
 	}
 
 	s.gds.Reset() // keep data global and stage data, delete the rest
@@ -107,7 +223,7 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 	// exact same config.
 	var local *stage.Stage
 	if !cfg.Compute.DisableLocal {
-		local = stage.New(cfg, s.gds, m.stats)
+		local = stage.New(cfg, s.gds, m.stats, lease, block)
 		if err := local.Prepare(ctxFinch); err != nil {
 			return err
 		}
@@ -125,7 +241,11 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 	// this will be instant because local already booted and acked above.
 	// But with remotes, this might take a few milliseconds over the network.
 	if nInstances > 1 {
-		log.Printf("Waiting for %d instances to boot...", nInstances)
+		if m.names != nil {
+			log.Printf("Waiting for %d instances to boot (remotes: %s)...", nInstances, remoteNames(cfg.Compute.Remotes))
+		} else {
+			log.Printf("Waiting for %d instances to boot...", nInstances)
+		}
 	}
 	booted := uint(0)
 	for booted < nInstances {
@@ -157,9 +277,27 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 	// Run stage
 	// ----------------------------------------------------------------------
 
+	// Before hooks run once on the server, after all instances have booted
+	if err := stage.RunHooks(ctxFinch, cfg, "before", cfg.Before); err != nil {
+		if s.api != nil && nRemotes > 0 {
+			s.api.Stage(nil) // stop remotes waiting to run
+		}
+		return err
+	}
+
 	finch.Debug("run %s", stageName)
+	m.started = time.Now()
 	close(m.runChan) // signal remotes to run
 
+	s.mux.Lock()
+	s.running = m
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		s.running = nil
+		s.mux.Unlock()
+	}()
+
 	if local != nil { // start local instance
 		go func() {
 			local.Run(ctxFinch)
@@ -167,10 +305,38 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 		}()
 	}
 
-	// Wait for instances to finish running
+	// Scheduled events run on the server until done or the stage is done
+	ctxEvents, cancelEvents := context.WithCancel(ctxFinch)
+	eventsDone := make(chan struct{})
+	go func() {
+		stage.RunEvents(ctxEvents, cfg, m.stats)
+		close(eventsDone)
+	}()
+
+	// Wait for instances to finish running. While waiting, check heartbeats
+	// from remotes and print the roster periodically.
+	var heartbeat <-chan time.Time
+	var rosterTicker <-chan time.Time
+	if nRemotes > 0 {
+		hb := time.NewTicker(time.Second)
+		defer hb.Stop()
+		heartbeat = hb.C
+		rt := time.NewTicker(rosterFreq)
+		defer rt.Stop()
+		rosterTicker = rt.C
+	}
 	running := booted
 	for running > 0 {
 		select {
+		case <-heartbeat:
+			for _, name := range m.lost() {
+				running -= 1
+				log.Printf("Remote %s lost: no heartbeat for %s, not waiting for it to complete stage %s unless it rejoins", name, heartbeatTimeout, stageName)
+			}
+		case <-m.rejoinChan:
+			running += 1 // wait for it again
+		case <-rosterTicker:
+			printRoster(m.roster())
 		case ack := <-m.doneChan:
 			running -= 1
 			if ack.err != nil {
@@ -190,5 +356,40 @@ func (s *Server) run(ctxFinch context.Context, cfg config.Stage) error {
 		}
 	}
 
+	cancelEvents()
+	<-eventsDone
+
+	if gone := m.stillGone(); len(gone) > 0 {
+		log.Printf("%d of %d instances lost during stage %s: %s", len(gone), nInstances, stageName, strings.Join(gone, ", "))
+	}
+
+	// After hooks, unless Finch was terminated (CTRL-C)
+	if ctxFinch.Err() == nil {
+		if err := stage.RunHooks(ctxFinch, cfg, "after", cfg.After); err != nil {
+			return err
+		}
+	} else if len(cfg.After) > 0 {
+		log.Printf("[%s] Finch terminated, not running after hooks", stageName)
+	}
+
 	return nil
 }
+
+func remoteNames(remotes []config.Remote) string {
+	names := make([]string, len(remotes))
+	for i := range remotes {
+		names[i] = remotes[i].Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// rosterFreq is how often the server prints the roster of remote instances
+// while running.
+const rosterFreq = 30 * time.Second
+
+func printRoster(roster []Instance) {
+	for _, in := range roster {
+		log.Printf("Remote %s: %s, %d clients, %d errors, %.1f%% CPU, last seen %.1fs ago",
+			in.Name, in.State, in.Health.Clients, in.Health.Errors, in.Health.CPU, in.LastSeen)
+	}
+}
@@ -27,12 +27,13 @@ func TestServer_Run1Stage(t *testing.T) {
 		[]string{},
 		dsn,
 		"", // default db
+		"", // no profile
 	)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	s := compute.NewServer("local", "", false)
+	s := compute.NewServer("local", "", config.Compute{}, false)
 
 	err = s.Run(context.Background(), stages)
 	if err != nil {
@@ -0,0 +1,145 @@
+// Copyright 2024 Block, Inc.
+
+package compute
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/square/finch/stats"
+)
+
+//go:embed ui.html
+var uiHTML []byte
+
+// uiPoints is the max number of stats intervals (per instance) that the
+// dashboard keeps for sparklines.
+const uiPoints = 120
+
+// dashboard is a stats.Reporter that keeps recent interval stats for the web
+// dashboard (compute.ui). There's only one dashboard: the server resets it for
+// each stage.
+type dashboard struct {
+	*sync.Mutex
+	stage  *stageMeta
+	points map[string][]uiPoint // keyed on instance hostname and uiCombined
+	ns     bool                 // stats.precision ns: convert P99 to microseconds
+}
+
+var _ stats.Reporter = &dashboard{}
+
+// uiPoint is one stats interval for one instance.
+type uiPoint struct {
+	Interval uint    `json:"interval"`
+	Runtime  float64 `json:"runtime"`
+	Clients  uint    `json:"clients"`
+	QPS      float64 `json:"QPS"`
+	P99      uint64  `json:"P99"` // microseconds, regardless of stats.precision
+	Errors   uint64  `json:"errors"`
+}
+
+// uiData is the response to GET /ui/data.
+type uiData struct {
+	Stage     string               `json:"stage"`
+	StageId   string               `json:"stage-id"`
+	Instances []Instance           `json:"instances"` // remotes
+	Stats     map[string][]uiPoint `json:"stats"`
+}
+
+const uiCombined = "(combined)"
+
+func newDashboard() *dashboard {
+	return &dashboard{
+		Mutex:  &sync.Mutex{},
+		points: map[string][]uiPoint{},
+	}
+}
+
+// reset sets the current stage and discards stats from the previous stage.
+func (d *dashboard) reset(m *stageMeta) {
+	d.Lock()
+	d.stage = m
+	d.points = map[string][]uiPoint{}
+	d.ns = m.cfg.Stats.Precision == "ns"
+	d.Unlock()
+}
+
+// Report implements stats.Reporter.
+func (d *dashboard) Report(from []stats.Instance) {
+	d.Lock()
+	defer d.Unlock()
+	for i := range from {
+		d.add(from[i].Hostname, from[i], from[i].Total)
+	}
+	if len(from) > 1 {
+		all := stats.NewInstance(uiCombined)
+		all.Combine(from)
+		d.add(uiCombined, all, all.Total)
+	}
+}
+
+func (d *dashboard) add(name string, in stats.Instance, s *stats.Stats) {
+	p := uiPoint{
+		Interval: in.Interval,
+		Runtime:  in.Runtime,
+		Clients:  in.Clients,
+		P99:      s.Percentiles(stats.TOTAL, []float64{99})[0],
+	}
+	if d.ns {
+		p.P99 /= 1000
+	}
+	if in.Seconds > 0 {
+		p.QPS = float64(s.N[stats.TOTAL]) / in.Seconds
+	}
+	for _, n := range s.Errors {
+		p.Errors += n
+	}
+	points := append(d.points[name], p)
+	if len(points) > uiPoints {
+		points = points[len(points)-uiPoints:]
+	}
+	d.points[name] = points
+}
+
+// Stop implements stats.Reporter. The dashboard keeps the stats of the last
+// stage until the next stage resets it.
+func (d *dashboard) Stop() {
+}
+
+func (d *dashboard) data() uiData {
+	d.Lock()
+	m := d.stage
+	data := uiData{
+		Stats: make(map[string][]uiPoint, len(d.points)),
+	}
+	for name, points := range d.points {
+		data.Stats[name] = append([]uiPoint{}, points...)
+	}
+	d.Unlock()
+	if m != nil {
+		data.Stage = m.cfg.Name
+		data.StageId = m.cfg.Id
+		data.Instances = m.roster()
+	} else {
+		data.Instances = []Instance{}
+	}
+	return data
+}
+
+// uiPage serves the dashboard page, which has no data: it fetches data from
+// /ui/data. If compute.token is set, open the page with ?token=... and the
+// page sends the token to /ui/data.
+func (a *API) uiPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiHTML)
+}
+
+func (a *API) uiData(w http.ResponseWriter, r *http.Request) {
+	if !a.auth(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.ui.data())
+}
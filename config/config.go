@@ -5,7 +5,6 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -33,7 +32,11 @@ type stageFile struct {
 	Stage Stage `yaml:"stage"`
 }
 
-func Load(stageFiles []string, kvparams []string, dsn, db string) ([]Stage, error) {
+// Load loads, applies, and validates the stage files and their base file
+// (_all.yaml), if any. If profile is set (--profile), the profile in every base
+// file overwrites the base config, so every stage file dir must have a base file
+// with the profile.
+func Load(stageFiles []string, kvparams []string, dsn, db, profile string) ([]Stage, error) {
 	var err error
 	base := map[string]Base{}
 	stages := []Stage{}
@@ -48,14 +51,9 @@ func Load(stageFiles []string, kvparams []string, dsn, db string) ([]Stage, erro
 		os.Chdir(cwd)
 	}()
 
-	params := map[string]string{}
-	for _, kv := range kvparams {
-		f := strings.SplitN(kv, "=", 2)
-		if len(f) != 2 {
-			log.Printf("Ignoring invalid --param %s: split into %d fields, expected 2\n", kv, len(f))
-			continue
-		}
-		params[f[0]] = f[1]
+	params, err := cmdlineParams(kvparams)
+	if err != nil {
+		return nil, err
 	}
 
 	for n, fileName := range stageFiles {
@@ -74,12 +72,25 @@ func Load(stageFiles []string, kvparams []string, dsn, db string) ([]Stage, erro
 					if err := yaml.UnmarshalStrict(bytes, &newb); err != nil {
 						return nil, fmt.Errorf("cannot decode YAML in %s: %s", fileName, err)
 					}
+					newb.Params = paramValues(newb.ParamSpecs)
+					for name, p := range newb.Profiles {
+						p.Params = paramValues(p.ParamSpecs)
+						newb.Profiles[name] = p
+					}
+					if profile != "" {
+						if err := newb.Profile(profile); err != nil {
+							return nil, fmt.Errorf("%s: %s", baseFile, err)
+						}
+					}
 					base[dir] = newb
 					b = newb
 					finch.Debug("base: %+v", b)
 				}
 			} else {
 				finch.Debug("base: none in %s", dir)
+				if profile != "" {
+					return nil, fmt.Errorf("--profile %s: no _all.yaml in %s", profile, dir)
+				}
 			}
 
 			// --param foo=bar on command line overrides .params in stage files
@@ -111,13 +122,27 @@ func Load(stageFiles []string, kvparams []string, dsn, db string) ([]Stage, erro
 		if err := yaml.UnmarshalStrict(bytes, f); err != nil {
 			return nil, fmt.Errorf("cannot decode YAML in %s: %s", fileName, err)
 		}
+		f.Stage.Params = paramValues(f.Stage.ParamSpecs)
 
 		// Set stage with defaults (base)
 		f.Stage.With(b)
 
+		// --param overrides stage file params, too
+		if len(params) > 0 && f.Stage.Params == nil {
+			f.Stage.Params = map[string]string{}
+		}
+		for k, v := range params {
+			f.Stage.Params[k] = v
+		}
+
 		// --dsn and --database on command line override config files
 		f.Stage.CommandLine(dsn, db)
 
+		// Data keys that reference a template, before vars so templates can use them
+		if err := f.Stage.Templates(); err != nil {
+			return nil, fmt.Errorf("in %s: %s", fileName, err)
+		}
+
 		// interpolate $vars -> values (see Vars func below)
 		if err := f.Stage.Vars(); err != nil {
 			return nil, fmt.Errorf("in %s: %s", fileName, err)
@@ -171,6 +196,81 @@ func ValidFreq(freq, config string) error {
 	return nil
 }
 
+// ParseCPUSet parses a Linux CPU list like "0-3,8" (config.stage.cpu-set) and
+// returns the CPU numbers, like [0 1 2 3 8].
+func ParseCPUSet(s string) ([]int, error) {
+	cpus := []int{}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		first, last, isRange := strings.Cut(r, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil || lo < 0 {
+			return nil, fmt.Errorf("invalid CPU %s in %s: must be N or N-M where N, M >= 0", r, s)
+		}
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(last)
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid CPU range %s in %s: must be N-M where M >= N", r, s)
+			}
+		}
+		for n := lo; n <= hi; n++ {
+			cpus = append(cpus, n)
+		}
+	}
+	return cpus, nil
+}
+
+// ParseIterDelay parses config.workload.iter-delay, which is a duration like
+// "50ms" or a duration with jitter like "50ms jitter 20ms". An empty string
+// returns zero delay and jitter.
+func ParseIterDelay(s string) (delay, jitter time.Duration, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	f := strings.Fields(s)
+	if len(f) != 1 && (len(f) != 3 || f[1] != "jitter") {
+		return 0, 0, fmt.Errorf("invalid iter-delay: %s: must be D or D jitter J, like 50ms jitter 20ms", s)
+	}
+	delay, err = time.ParseDuration(f[0])
+	if err != nil || delay <= 0 {
+		return 0, 0, fmt.Errorf("invalid iter-delay: %s: delay must be a duration greater than zero", s)
+	}
+	if len(f) == 3 {
+		jitter, err = time.ParseDuration(f[2])
+		if err != nil || jitter < 0 || jitter > delay {
+			return 0, 0, fmt.Errorf("invalid iter-delay: %s: jitter must be a duration between zero and the delay", s)
+		}
+	}
+	return delay, jitter, nil
+}
+
+// ParseRate parses a QPS or TPS rate limit, which is an integer like "1000" or
+// an integer with a burst size like "1000 burst 200". An empty string or zero
+// returns zero (no limit). Burst is zero if not set, which means 1: a steady
+// rate without bursts.
+func ParseRate(s string) (perSecond, burst uint, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	f := strings.Fields(s)
+	if len(f) != 1 && (len(f) != 3 || f[1] != "burst") {
+		return 0, 0, fmt.Errorf("invalid rate: %s: must be N or N burst B, like 1000 burst 200", s)
+	}
+	n, err := strconv.ParseUint(f[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid rate: %s: '%s' is not an integer: %s", s, f[0], err)
+	}
+	if len(f) == 3 {
+		b, err := strconv.ParseUint(f[2], 10, 32)
+		if err != nil || b == 0 {
+			return 0, 0, fmt.Errorf("invalid rate: %s: burst must be an integer greater than zero", s)
+		}
+		burst = uint(b)
+	}
+	return uint(n), burst, nil
+}
+
 // True returns true if b is non-nil and true.
 // This is convenience function related to *bool files in config structs,
 // which is required for knowing when a bool config is explicitily set
@@ -197,11 +297,14 @@ var varRE = []*regexp.Regexp{
 	regexp.MustCompile(`\${([^}]+)}`),  // ${param.foo} for "hello${param.foo}bar"
 	regexp.MustCompile(`\$([^\s"']+)`), // $param.foo for standalone value
 }
-var reHumanNumber = regexp.MustCompile(`([\d,]*\d+(?i:[MKGBI]*))`) // 1M or 1,000,000 -> 1000000
+var reHumanNumber = regexp.MustCompile(`([\d,]*\d+(?i:[MKGBI]*))\b`) // 1M or 1,000,000 -> 1000000, but not 500ms
 var reAllDigits = regexp.MustCompile(`^\d+$`)
+var reSessionVar = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`) // MySQL system variable name
 
 // Vars changes $params.foo and $FOO to param values and environment variable
-// values, respectively, and human numbers to integers (1k -> 1000).
+// values, respectively, and human numbers to integers (1k -> 1000). If numbers
+// is true and the result is an arithmetic expression, like "$params.rows * 10",
+// it's evaluated (see evalExpr).
 // "${var}" is also valid but YAML requires string quotes around {}.
 func Vars(s string, params map[string]string, numbers bool) (string, error) {
 	for _, r := range varRE {
@@ -221,6 +324,9 @@ func Vars(s string, params map[string]string, numbers bool) (string, error) {
 				if !ok {
 					return "", fmt.Errorf("%s not defined (is it spelled correctly?)", p)
 				}
+				if numbers && isExpr(val) {
+					val = "(" + val + ")" // param not evaluated yet, keep precedence
+				}
 				rep = append(rep, v[0], val)
 				finch.Debug("param: %s -> %v (user-defined)", s, rep)
 			case strings.HasPrefix(p, "sys."):
@@ -250,9 +356,6 @@ func Vars(s string, params map[string]string, numbers bool) (string, error) {
 
 	// Look for human numbers like 1k and 1,000
 	m := reHumanNumber.FindAllStringSubmatch(s, -1)
-	if len(m) == 0 {
-		return s, nil // no human numbers
-	}
 	rep := []string{}
 	for i := range m {
 		// To keep the regex simple, reHumanNumber also matches ints like 1000,
@@ -266,12 +369,21 @@ func Vars(s string, params map[string]string, numbers bool) (string, error) {
 		}
 		rep = append(rep, m[i][0], strconv.FormatUint(d, 10))
 	}
-	if len(rep) == 0 { // all machine numbers (see comment above)
+	if len(rep) > 0 { // else no human numbers or all machine numbers (see comment above)
+		finch.Debug("var: %s -> %v", s, rep)
+		s = strings.NewReplacer(rep...).Replace(s)
+	}
+
+	// Evaluate arithmetic like "$params.rows * 10" after interpolation
+	if !isExpr(s) {
 		return s, nil
 	}
-	finch.Debug("var: %s -> %v", s, rep)
-	r := strings.NewReplacer(rep...)
-	return r.Replace(s), nil
+	v, err := evalExpr(s)
+	if err != nil {
+		return "", err
+	}
+	finch.Debug("expr: %s -> %s", s, v)
+	return v, nil
 }
 
 // setBool sets c to the value of b if c is nil (not set). Pointers are required
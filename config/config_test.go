@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
@@ -29,6 +30,7 @@ func TestVars(t *testing.T) {
 		"foo": "bar",
 		"n":   "100",
 		"a-b": "val",
+		"e":   "20 + 2",
 	}
 
 	home := os.Getenv("HOME")
@@ -50,6 +52,17 @@ func TestVars(t *testing.T) {
 		{"rows: 1,000", "rows: 1000", true},
 		{"size: 1GiB", "size: 1073741824", true},
 		{"(1, 2, 'foo')", "(1, 2, 'foo')", true},
+		{"idle 500ms", "idle 500ms", true}, // duration, not 500M
+		// numbers=true: arithmetic expressions
+		{"$params.n * 10", "1000", true},
+		{"${params.n} / 3", "33", true}, // integer division
+		{"$params.n / 8.0", "12.5", true},
+		{"($params.n + 20) * 2 - 1k", "-760", true},
+		{"$params.n % 7", "2", true},
+		{"$params.e * 2", "44", true}, // param expression evaluated first
+		{"2024-01-01", "2024-01-01", true},
+		{"-5", "-5", true},
+		{"$params.n * 10", "100 * 10", false},
 		// numbers=false
 		{"db.abd6b.us-east-1.rds.amazonaws.com", "db.abd6b.us-east-1.rds.amazonaws.com", false},
 	}
@@ -66,8 +79,16 @@ func TestVars(t *testing.T) {
 	}
 }
 
+func TestVars_InvalidExpr(t *testing.T) {
+	for _, s := range []string{"10 / 0", "10 % 0", "(1 + 2", "1 + 2)", "1 + * 2", "1.2.3 + 1"} {
+		if _, err := config.Vars(s, nil, true); err == nil {
+			t.Errorf("no error for '%s', expected one", s)
+		}
+	}
+}
+
 func TestLoadWithBase(t *testing.T) {
-	stages, err := config.Load([]string{"../test/config/b1/stage.yaml"}, nil, "", "")
+	stages, err := config.Load([]string{"../test/config/b1/stage.yaml"}, nil, "", "", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -85,6 +106,9 @@ func TestLoadWithBase(t *testing.T) {
 		Params: map[string]string{
 			"foo": "test",
 		},
+		ParamSpecs: map[string]config.ParamSpec{
+			"foo": {Default: "test"},
+		},
 		Stats: config.Stats{
 			Freq: "0s",
 			Report: map[string]map[string]string{
@@ -104,3 +128,448 @@ func TestLoadWithBase(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestValidate_ComputeRemotes(t *testing.T) {
+	c := config.Compute{
+		Remotes: []config.Remote{{Name: "dc1-a"}, {Name: "dc1-b"}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Instances != "3" {
+		t.Errorf("got instances %s, expected 3 (2 remotes + local)", c.Instances)
+	}
+
+	c = config.Compute{
+		DisableLocal: true,
+		Instances:    "3",
+		Remotes:      []config.Remote{{Name: "dc1-a"}, {Name: "dc1-b"}},
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("instances 3 with 2 remotes and disable-local returned err=nil, expected validation error")
+	}
+
+	c = config.Compute{
+		Remotes: []config.Remote{{Name: "dc1-a"}, {Name: "dc1-a"}},
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("duplicate remote names returned err=nil, expected validation error")
+	}
+}
+
+func TestStage_Remote(t *testing.T) {
+	c := config.Stage{
+		Workload: []config.ClientGroup{{Trx: []string{"read"}}},
+		Compute: config.Compute{
+			Remotes: []config.Remote{
+				{Name: "a", Workload: []config.ClientGroup{{Trx: []string{"write"}, Clients: "4"}}},
+				{Name: "b"},
+			},
+		},
+	}
+
+	got := c.Remote("a")
+	if diff := deep.Equal(got.Workload, c.Compute.Remotes[0].Workload); diff != nil {
+		t.Errorf("remote a: %v", diff)
+	}
+
+	// No override for b or unknown remote c: stage workload
+	for _, name := range []string{"b", "c"} {
+		got = c.Remote(name)
+		if diff := deep.Equal(got.Workload, c.Workload); diff != nil {
+			t.Errorf("remote %s: %v", name, diff)
+		}
+	}
+}
+
+func TestMySQL_ReaderConfig(t *testing.T) {
+	c := config.MySQL{
+		Db:       "test",
+		Hostname: "writer",
+		Username: "finch",
+		Reader: &config.MySQL{
+			Hostname: "reader",
+		},
+	}
+	got := c.ReaderConfig()
+	expect := &config.MySQL{
+		Db:       "test",
+		Hostname: "reader",
+		Username: "finch",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	c.Reader = nil
+	if got := c.ReaderConfig(); got != nil {
+		t.Errorf("got %+v, expected nil when reader not set", got)
+	}
+}
+
+func TestMySQL_Validate(t *testing.T) {
+	c := config.MySQL{TimeoutConnect: "5s", TimeoutRead: "0", TimeoutWrite: "1m"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid timeouts returned an error: %s", err)
+	}
+	for _, c := range []config.MySQL{
+		{TimeoutConnect: "5"},
+		{TimeoutRead: "-1s"},
+		{Reader: &config.MySQL{TimeoutWrite: "x"}},
+		{Params: map[string]string{"a=b": "c"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("no error for invalid %+v, expected one", c)
+		}
+	}
+}
+
+func TestParseCPUSet(t *testing.T) {
+	got, err := config.ParseCPUSet("0-3, 8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, []int{0, 1, 2, 3, 8}); diff != nil {
+		t.Error(diff)
+	}
+	for _, s := range []string{"", "a", "-1", "3-1", "1-"} {
+		if _, err := config.ParseCPUSet(s); err == nil {
+			t.Errorf("ParseCPUSet(%s): got nil error, expected an error", s)
+		}
+	}
+}
+
+func TestParseIterDelay(t *testing.T) {
+	d, j, err := config.ParseIterDelay("50ms jitter 20ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 50*time.Millisecond || j != 20*time.Millisecond {
+		t.Errorf("got delay %s jitter %s, expected 50ms jitter 20ms", d, j)
+	}
+	d, j, err = config.ParseIterDelay("1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != time.Second || j != 0 {
+		t.Errorf("got delay %s jitter %s, expected 1s jitter 0s", d, j)
+	}
+	for _, s := range []string{"0s", "50", "50ms jitter", "50ms jit 20ms", "50ms jitter 1s", "50ms jitter -1ms"} {
+		if _, _, err := config.ParseIterDelay(s); err == nil {
+			t.Errorf("ParseIterDelay(%s): got nil error, expected an error", s)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	n, burst, err := config.ParseRate("1000 burst 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1000 || burst != 200 {
+		t.Errorf("got %d burst %d, expected 1000 burst 200", n, burst)
+	}
+	n, burst, err = config.ParseRate("500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 500 || burst != 0 {
+		t.Errorf("got %d burst %d, expected 500 burst 0", n, burst)
+	}
+	for _, s := range []string{"1.5", "-1", "1000 burst", "1000 bust 200", "1000 burst 0", "1000 burst x"} {
+		if _, _, err := config.ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%s): got nil error, expected an error", s)
+		}
+	}
+}
+
+func TestLoad_DataTemplates(t *testing.T) {
+	stages, err := config.Load([]string{"../test/config/t1/stage.yaml"}, nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 1 {
+		t.Fatalf("got %d stages, expected 1", len(stages))
+	}
+	expect := map[string]config.Data{
+		"id": {
+			Generator: "int",
+			Scope:     "client",
+			Params:    map[string]string{"min": "1", "max": "1000"}, // $params.max from base
+			Template:  "id",
+		},
+		"k": {
+			Generator: "int",
+			Params:    map[string]string{"min": "1", "max": "10"}, // data key overwrites template
+			Template:  "id",
+		},
+		"c": {
+			Generator: "str-fill-az",
+			Params:    map[string]string{"len": "20"}, // stage template overwrites base
+			Template:  "name",
+		},
+	}
+	if diff := deep.Equal(stages[0].Trx[0].Data, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// YAML alias (*c) to an anchor (&c) in the same file
+	if diff := deep.Equal(stages[0].Trx[1].Data["c"], expect["c"]); diff != nil {
+		t.Error(diff)
+	}
+
+	// Unknown template is an error
+	s := config.Stage{
+		Trx: []config.Trx{
+			{File: "trx.sql", Data: map[string]config.Data{"id": {Template: "nope"}}},
+		},
+	}
+	if err := s.Templates(); err == nil {
+		t.Error("no error for unknown template, expected one")
+	}
+}
+
+func TestLoad_Profile(t *testing.T) {
+	file := "../test/config/p1/stage.yaml"
+
+	// No profile: base config
+	stages, err := config.Load([]string{file}, nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stages[0].MySQL.Hostname != "localhost" || stages[0].Params["rows"] != "1000" {
+		t.Errorf("got hostname %s and rows %s, expected localhost and 1000", stages[0].MySQL.Hostname, stages[0].Params["rows"])
+	}
+
+	// Profile overwrites base values it sets, and --param overwrites profile
+	stages, err = config.Load([]string{file}, []string{"db=bench"}, "", "", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"rows": "1000000", "db": "bench"}
+	if diff := deep.Equal(stages[0].Params, expect); diff != nil {
+		t.Error(diff)
+	}
+	if stages[0].MySQL.Hostname != "staging.db" || stages[0].MySQL.Username != "finch" {
+		t.Errorf("got hostname %s and username %s, expected staging.db and finch", stages[0].MySQL.Hostname, stages[0].MySQL.Username)
+	}
+
+	// Profile must be defined
+	if _, err := config.Load([]string{file}, nil, "", "", "prod"); err == nil {
+		t.Error("no error for undefined profile, expected one")
+	}
+	if _, err := config.Load([]string{"../test/config/t1/stage.yaml"}, nil, "", "", "staging"); err == nil {
+		t.Error("no error for profile not in _all.yaml, expected one")
+	}
+}
+
+func TestLoad_ParamTypes(t *testing.T) {
+	file := "../test/config/pt1/stage.yaml"
+
+	stages, err := config.Load([]string{file}, nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"rows": "1000000", "table": "t2", "warmup": "5s"}
+	if diff := deep.Equal(stages[0].Params, expect); diff != nil {
+		t.Error(diff)
+	}
+	if stages[0].ParamSpecs["rows"].Help != "Number of rows to insert" {
+		t.Errorf("got rows help '%s', expected base help", stages[0].ParamSpecs["rows"].Help)
+	}
+
+	// --param value must be valid for the param type
+	stages, err = config.Load([]string{file}, []string{"rows=2k"}, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stages[0].Params["rows"] != "2000" {
+		t.Errorf("got rows %s, expected 2000", stages[0].Params["rows"])
+	}
+	if _, err := config.Load([]string{file}, []string{"rows=many"}, "", "", ""); err == nil {
+		t.Error("no error for invalid int param, expected one")
+	}
+	if _, err := config.Load([]string{file}, []string{"warmup=soon"}, "", "", ""); err == nil {
+		t.Error("no error for invalid duration param, expected one")
+	}
+}
+
+func TestLoad_ParamFiles(t *testing.T) {
+	file := "../test/config/pt1/stage.yaml"
+
+	// Files and inline JSON in command line order: later values overwrite earlier ones
+	kvparams := []string{
+		"@../test/config/pt1/params.json",
+		"@../test/config/pt1/params.yaml",
+		`{"warmup": "2s"}`,
+		"table=t5",
+	}
+	stages, err := config.Load([]string{file}, kvparams, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"rows": "2000", "table": "t5", "warmup": "2s"}
+	if diff := deep.Equal(stages[0].Params, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, p := range []string{"@../test/config/pt1/nonexistent.json", `{"rows": [1, 2]}`, `{"rows": `} {
+		if _, err := config.Load([]string{file}, []string{p}, "", "", ""); err == nil {
+			t.Errorf("no error for --param %s, expected one", p)
+		}
+	}
+}
+
+func TestLoad_ExecMode(t *testing.T) {
+	file := "../test/config/em1/stage.yaml"
+
+	for _, mode := range []string{"sequential", "concurrent"} {
+		stages, err := config.Load([]string{file}, []string{"mode=" + mode}, "", "", "")
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		if stages[0].ExecMode != mode {
+			t.Errorf("got exec-mode %s, expected %s", stages[0].ExecMode, mode)
+		}
+	}
+	if _, err := config.Load([]string{file}, []string{"mode=parallel"}, "", "", ""); err == nil {
+		t.Error("no error for invalid exec-mode, expected one")
+	}
+
+	// start-after (30s) must be less than stage runtime
+	if _, err := config.Load([]string{file}, []string{"mode=concurrent", "runtime=30s"}, "", "", ""); err == nil {
+		t.Error("no error for start-after not before stage runtime, expected one")
+	}
+}
+
+func TestStats_SLO(t *testing.T) {
+	c := config.Stats{SLO: []string{"1ms", "5ms", "20ms"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid stats.slo returned an error: %s", err)
+	}
+	for _, slo := range [][]string{{"1ms", "bad"}, {"5ms", "1ms"}, {"0s"}} {
+		c := config.Stats{SLO: slo}
+		if err := c.Validate(); err == nil {
+			t.Errorf("no error for invalid stats.slo %v, expected one", slo)
+		}
+	}
+}
+
+func TestStats_Final(t *testing.T) {
+	c := config.Stats{FinalTimeout: "10s", FinalPolicy: config.FINAL_WAIT_FOREVER}
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid stats.final-timeout and final-policy returned an error: %s", err)
+	}
+	for _, c := range []config.Stats{
+		{FinalTimeout: "0"},
+		{FinalTimeout: "-1s"},
+		{FinalPolicy: "partial"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("no error for invalid %+v, expected one", c)
+		}
+	}
+}
+
+func TestThrottle_Validate(t *testing.T) {
+	c := config.Throttle{Query: "SELECT 1", Max: "10"}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Resume != "10" || c.Freq != "1s" {
+		t.Errorf("got resume %s, freq %s; expected defaults 10, 1s", c.Resume, c.Freq)
+	}
+	for _, c := range []config.Throttle{
+		{Max: "10"},                                  // no query
+		{Query: "SELECT 1"},                          // no max
+		{Query: "SELECT 1", Max: "ten"},              // max not a number
+		{Query: "SELECT 1", Max: "10", Resume: "20"}, // resume > max
+		{Query: "SELECT 1", Max: "10", QPS: "-1"},    // invalid qps
+		{Query: "SELECT 1", Max: "10", Freq: "fast"}, // invalid freq
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("no error for invalid throttle %+v, expected one", c)
+		}
+	}
+}
+
+func TestPattern_Validate(t *testing.T) {
+	c := config.Pattern{Sine: "60s"}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Min != "10" {
+		t.Errorf("got min %s, expected default 10", c.Min)
+	}
+	c = config.Pattern{On: "30s", Off: "30s"}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []config.Pattern{
+		{},                              // nothing set
+		{On: "30s"},                     // no off
+		{Off: "30s"},                    // no on
+		{On: "30s", Off: "0s"},          // off not > 0
+		{Sine: "slow"},                  // invalid sine
+		{On: "1s", Off: "1s", Min: "5"}, // min without sine
+		{Sine: "60s", Min: "101"},       // min > 100
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("no error for invalid pattern %+v, expected one", c)
+		}
+	}
+}
+
+func TestClientGroup_Script(t *testing.T) {
+	c := config.ClientGroup{Script: "../test/script/select.lua"}
+	if err := c.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []config.ClientGroup{
+		{Script: "../test/script/select.lua", Trx: []string{"001.sql"}},   // script and trx
+		{Script: "../test/script/select.lua", WrapTrx: true},              // wrap-trx
+		{Script: "../test/script/select.lua", QueryLog: "/tmp/query.log"}, // query-log
+		{Script: "../test/script/nonexistent.lua"},                        // no file
+	} {
+		if err := c.Validate(nil); err == nil {
+			t.Errorf("no error for invalid script client group %+v, expected one", c)
+		}
+	}
+}
+
+func TestClientGroup_Db(t *testing.T) {
+	c := config.ClientGroup{Db: "finch_{client-group}_{client}"}
+	if err := c.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []string{"finch_{clients}", "finch_{client", "finch_{}"} {
+		c := config.ClientGroup{Db: db}
+		if err := c.Validate(nil); err == nil {
+			t.Errorf("no error for invalid db %s, expected one", db)
+		}
+	}
+	my := config.MySQL{Db: "finch_{client}"}
+	if err := my.Validate(); err == nil {
+		t.Errorf("no error for mysql.db template, expected one")
+	}
+}
+
+func TestStage_QueryLogSample(t *testing.T) {
+	// Client groups that log to the same file must use the same sample rate
+	c := config.Stage{
+		Name: "test",
+		Trx:  []config.Trx{{Name: "001.sql", File: "../test/trx/001.sql"}},
+		Workload: []config.ClientGroup{
+			{QueryLog: "/tmp/query.log", QueryLogSample: "10"},
+			{QueryLog: "/tmp/query.log"}, // default 1000
+		},
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("no error for query-log-sample mismatch, expected one")
+	}
+
+	c.Workload[1].QueryLogSample = "10"
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
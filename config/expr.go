@@ -0,0 +1,189 @@
+// Copyright 2024 Block, Inc.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A value is an arithmetic expression, like "100 * 10" or "(4 / 2) + 1", if it
+// has only numbers, operators, and parentheses, and at least one operator with
+// whitespace on both sides, so values like "2024-01-01" and "-5" are not.
+var (
+	reExprChars = regexp.MustCompile(`^[\d.+\-*/%()\s]+$`)
+	reExprOp    = regexp.MustCompile(`\s[+\-*/%]\s`)
+)
+
+// isExpr returns true if s is an arithmetic expression.
+func isExpr(s string) bool {
+	return reExprChars.MatchString(s) && reExprOp.MatchString(s)
+}
+
+// evalExpr evaluates an arithmetic expression: + - * / % and parentheses with
+// the usual precedence. If all numbers are integers, the result is an integer
+// and division is integer division (truncated), so "$sys.CPU_CORES / 2" is a
+// valid number of clients. Else the result is a float.
+func evalExpr(s string) (string, error) {
+	e := &expr{s: s}
+	v, err := e.sum()
+	if err != nil {
+		return "", fmt.Errorf("invalid expression: %s: %s", s, err)
+	}
+	e.space()
+	if e.p < len(e.s) {
+		return "", fmt.Errorf("invalid expression: %s: unexpected %q", s, e.s[e.p:])
+	}
+	if v.isInt {
+		return strconv.FormatInt(v.i, 10), nil
+	}
+	return strconv.FormatFloat(v.f, 'f', -1, 64), nil
+}
+
+// exprVal is an integer or a float value in an expression.
+type exprVal struct {
+	i     int64
+	f     float64
+	isInt bool
+}
+
+func (v exprVal) float() float64 {
+	if v.isInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// expr is a recursive descent parser for evalExpr:
+//
+//	sum     = product {("+" | "-") product}
+//	product = unary {("*" | "/" | "%") unary}
+//	unary   = ["-"] (number | "(" sum ")")
+type expr struct {
+	s string
+	p int // current position in s
+}
+
+func (e *expr) space() {
+	for e.p < len(e.s) && (e.s[e.p] == ' ' || e.s[e.p] == '\t') {
+		e.p++
+	}
+}
+
+func (e *expr) sum() (exprVal, error) {
+	v, err := e.product()
+	if err != nil {
+		return v, err
+	}
+	for {
+		e.space()
+		if e.p == len(e.s) || (e.s[e.p] != '+' && e.s[e.p] != '-') {
+			return v, nil
+		}
+		op := e.s[e.p]
+		e.p++
+		r, err := e.product()
+		if err != nil {
+			return v, err
+		}
+		if v.isInt && r.isInt {
+			if op == '+' {
+				v.i += r.i
+			} else {
+				v.i -= r.i
+			}
+			continue
+		}
+		if op == '+' {
+			v = exprVal{f: v.float() + r.float()}
+		} else {
+			v = exprVal{f: v.float() - r.float()}
+		}
+	}
+}
+
+func (e *expr) product() (exprVal, error) {
+	v, err := e.unary()
+	if err != nil {
+		return v, err
+	}
+	for {
+		e.space()
+		if e.p == len(e.s) || !strings.ContainsRune("*/%", rune(e.s[e.p])) {
+			return v, nil
+		}
+		op := e.s[e.p]
+		e.p++
+		r, err := e.unary()
+		if err != nil {
+			return v, err
+		}
+		if (op == '/' || op == '%') && r.float() == 0 {
+			return v, fmt.Errorf("division by zero")
+		}
+		if v.isInt && r.isInt {
+			switch op {
+			case '*':
+				v.i *= r.i
+			case '/':
+				v.i /= r.i
+			case '%':
+				v.i %= r.i
+			}
+			continue
+		}
+		switch op {
+		case '*':
+			v = exprVal{f: v.float() * r.float()}
+		case '/':
+			v = exprVal{f: v.float() / r.float()}
+		case '%':
+			v = exprVal{f: math.Mod(v.float(), r.float())}
+		}
+	}
+}
+
+func (e *expr) unary() (exprVal, error) {
+	e.space()
+	if e.p == len(e.s) {
+		return exprVal{}, fmt.Errorf("missing number at end")
+	}
+	if e.s[e.p] == '-' {
+		e.p++
+		v, err := e.unary()
+		v.i, v.f = -v.i, -v.f
+		return v, err
+	}
+	if e.s[e.p] == '(' {
+		e.p++
+		v, err := e.sum()
+		if err != nil {
+			return v, err
+		}
+		e.space()
+		if e.p == len(e.s) || e.s[e.p] != ')' {
+			return v, fmt.Errorf("missing )")
+		}
+		e.p++
+		return v, nil
+	}
+	start := e.p
+	for e.p < len(e.s) && (e.s[e.p] >= '0' && e.s[e.p] <= '9' || e.s[e.p] == '.') {
+		e.p++
+	}
+	n := e.s[start:e.p]
+	if n == "" {
+		return exprVal{}, fmt.Errorf("unexpected %q", e.s[e.p:])
+	}
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return exprVal{i: i, isInt: true}, nil
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return exprVal{}, fmt.Errorf("invalid number: %s", n)
+	}
+	return exprVal{f: f}, nil
+}
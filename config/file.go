@@ -8,6 +8,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/square/finch"
 )
@@ -15,9 +20,85 @@ import (
 // Base represents a base config file: _all.yaml. If it exists, it applies to
 // all stage config files in the directory.
 type Base struct {
-	MySQL  MySQL             `yaml:"mysql,omitempty"`
-	Params map[string]string `yaml:"params,omitempty"`
-	Stats  Stats             `yaml:"stats,omitempty"`
+	Data       BaseData             `yaml:"data,omitempty"`
+	MySQL      MySQL                `yaml:"mysql,omitempty"`
+	Params     map[string]string    `yaml:"-"` // values of ParamSpecs
+	ParamSpecs map[string]ParamSpec `yaml:"params,omitempty"`
+	Profiles   map[string]Base      `yaml:"profiles,omitempty"` // --profile
+	Stats      Stats                `yaml:"stats,omitempty"`
+}
+
+// BaseData is base data config (config.base.data): data templates shared by all
+// stages in the same dir.
+type BaseData struct {
+	Templates map[string]Data `yaml:"templates,omitempty"`
+}
+
+// Profile applies the named profile (config.base.profiles, --profile) to the base
+// config. Values set in the profile overwrite base values: mysql and stats values
+// by field, params and data templates by name, and stats reporters all at once.
+func (c *Base) Profile(name string) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %s not defined (profiles: %s)", name, strings.Join(names, ", "))
+	}
+	finch.Debug("profile %s: %+v", name, p)
+
+	p.MySQL.With(c.MySQL)
+	c.MySQL = p.MySQL
+
+	if len(p.ParamSpecs) > 0 {
+		if c.ParamSpecs == nil {
+			c.ParamSpecs = map[string]ParamSpec{}
+		}
+		if c.Params == nil {
+			c.Params = map[string]string{}
+		}
+		for k, v := range p.ParamSpecs {
+			c.ParamSpecs[k] = v
+			c.Params[k] = v.Default
+		}
+	}
+
+	if len(p.Data.Templates) > 0 {
+		if c.Data.Templates == nil {
+			c.Data.Templates = map[string]Data{}
+		}
+		for k, v := range p.Data.Templates {
+			c.Data.Templates[k] = v
+		}
+	}
+
+	c.Stats.Disable = setBool(p.Stats.Disable, c.Stats.Disable)
+	for _, s := range []struct{ dst, src *string }{
+		{&c.Stats.Aggregate, &p.Stats.Aggregate},
+		{&c.Stats.Buffer, &p.Stats.Buffer},
+		{&c.Stats.Clock, &p.Stats.Clock},
+		{&c.Stats.ClockTick, &p.Stats.ClockTick},
+		{&c.Stats.FinalPolicy, &p.Stats.FinalPolicy},
+		{&c.Stats.FinalTimeout, &p.Stats.FinalTimeout},
+		{&c.Stats.Freq, &p.Stats.Freq},
+		{&c.Stats.Precision, &p.Stats.Precision},
+		{&c.Stats.Spool, &p.Stats.Spool},
+	} {
+		if *s.src != "" {
+			*s.dst = *s.src
+		}
+	}
+	if len(p.Stats.Report) > 0 {
+		c.Stats.Report = p.Stats.Report
+	}
+	if len(p.Stats.SLO) > 0 {
+		c.Stats.SLO = p.Stats.SLO
+	}
+
+	c.Profiles = nil // applied
+	return nil
 }
 
 func (c *Base) Validate() error {
@@ -30,24 +111,40 @@ func (c *Base) Validate() error {
 	return nil
 }
 
+// Execution modes (config.stage.exec-mode)
+const (
+	EXEC_SEQUENTIAL = "sequential" // default: exec groups run one after another
+	EXEC_CONCURRENT = "concurrent" // all exec groups run at the same time, except DDL
+)
+
 // Stage represents one stage config file. The stage config overwrites any base
 // config (_all.yaml).
 type Stage struct {
-	Compute  Compute           `yaml:"compute,omitempty"`
-	Disable  bool              `yaml:"disable"`
-	File     string            `yaml:"-"`
-	Id       string            `yaml:"-"`
-	Name     string            `yaml:"name"`
-	MySQL    MySQL             `yaml:"mysql,omitempty"`
-	N        uint              `yaml:"-"`
-	Params   map[string]string `yaml:"params,omitempty"`
-	QPS      string            `yaml:"qps,omitempty"` // uint
-	Runtime  string            `yaml:"runtime,omitempty"`
-	Stats    Stats             `yaml:"stats,omitempty"`
-	TPS      string            `yaml:"tps,omitempty"` // uint
-	Test     bool              `yaml:"-"`
-	Trx      []Trx             `yaml:"trx,omitempty"`
-	Workload []ClientGroup     `yaml:"workload,omitempty"`
+	After      []Hook               `yaml:"after,omitempty"`
+	Before     []Hook               `yaml:"before,omitempty"`
+	Compute    Compute              `yaml:"compute,omitempty"`
+	CPUSet     string               `yaml:"cpu-set,omitempty"` // Linux CPU list like "0-3,8"
+	Data       StageData            `yaml:"data,omitempty"`
+	Disable    bool                 `yaml:"disable"`
+	Events     []Event              `yaml:"events,omitempty"`
+	ExecMode   string               `yaml:"exec-mode,omitempty"` // sequential|concurrent
+	File       string               `yaml:"-"`
+	GOMAXPROCS string               `yaml:"gomaxprocs,omitempty"` // uint
+	Id         string               `yaml:"-"`
+	Name       string               `yaml:"name"`
+	MySQL      MySQL                `yaml:"mysql,omitempty"`
+	N          uint                 `yaml:"-"`
+	Params     map[string]string    `yaml:"-"` // values of ParamSpecs, after With and --param
+	ParamSpecs map[string]ParamSpec `yaml:"params,omitempty"`
+	Pattern    *Pattern             `yaml:"pattern,omitempty"`
+	QPS        string               `yaml:"qps,omitempty"` // N or N burst B
+	Runtime    string               `yaml:"runtime,omitempty"`
+	Stats      Stats                `yaml:"stats,omitempty"`
+	TPS        string               `yaml:"tps,omitempty"` // N or N burst B
+	Test       bool                 `yaml:"-"`
+	Throttle   *Throttle            `yaml:"throttle,omitempty"`
+	Trx        []Trx                `yaml:"trx,omitempty"`
+	Workload   []ClientGroup        `yaml:"workload,omitempty"`
 }
 
 func (c *Stage) With(b Base) {
@@ -63,12 +160,55 @@ func (c *Stage) With(b Base) {
 			}
 		}
 	}
+	if len(b.ParamSpecs) > 0 {
+		if c.ParamSpecs == nil {
+			c.ParamSpecs = map[string]ParamSpec{}
+		}
+		for k, p := range b.ParamSpecs {
+			s, ok := c.ParamSpecs[k]
+			if !ok {
+				c.ParamSpecs[k] = p
+				continue
+			}
+			// Stage file can set only the value of a typed base param
+			if s.Type == "" {
+				s.Type = p.Type
+			}
+			if s.Help == "" {
+				s.Help = p.Help
+			}
+			c.ParamSpecs[k] = s
+		}
+	}
+
+	// Data templates: stage templates overwrite base templates with the same name
+	if len(b.Data.Templates) > 0 {
+		if c.Data.Templates == nil {
+			c.Data.Templates = map[string]Data{}
+		}
+		for name, t := range b.Data.Templates {
+			if _, ok := c.Data.Templates[name]; !ok {
+				c.Data.Templates[name] = t
+			}
+		}
+	}
 
 	c.MySQL.With(b.MySQL)
 
 	// Stats has a map, so copy in all fields manually
 	c.Stats.Disable = setBool(c.Stats.Disable, b.Stats.Disable)
+	c.Stats.Aggregate = b.Stats.Aggregate
+	c.Stats.Buffer = b.Stats.Buffer
+	c.Stats.Clock = b.Stats.Clock
+	c.Stats.ClockTick = b.Stats.ClockTick
+	c.Stats.FinalPolicy = b.Stats.FinalPolicy
+	c.Stats.FinalTimeout = b.Stats.FinalTimeout
 	c.Stats.Freq = b.Stats.Freq
+	c.Stats.Precision = b.Stats.Precision
+	c.Stats.Spool = b.Stats.Spool
+	if len(b.Stats.SLO) > 0 {
+		c.Stats.SLO = append([]string{}, b.Stats.SLO...)
+	}
 	if len(b.Stats.Report) > 0 {
 		c.Stats.Report = map[string]map[string]string{}
 		for r := range b.Stats.Report {
@@ -81,6 +221,40 @@ func (c *Stage) With(b Base) {
 
 }
 
+// Templates applies data templates (config.stage.data.templates) to trx data
+// keys that reference one (config.stage.trx[].data.template). The data key
+// overwrites the template: generator and scope if set, and params by name.
+// It's called before Vars so template params can use $params.
+func (c *Stage) Templates() error {
+	for i := range c.Trx {
+		for dataKey, d := range c.Trx[i].Data {
+			if d.Template == "" {
+				continue
+			}
+			t, ok := c.Data.Templates[d.Template]
+			if !ok {
+				return fmt.Errorf("trx[%d].data[%s].template: %s not defined in data.templates", i, dataKey, d.Template)
+			}
+			if d.Generator == "" {
+				d.Generator = t.Generator
+			}
+			if d.Scope == "" {
+				d.Scope = t.Scope
+			}
+			params := make(map[string]string, len(t.Params)+len(d.Params))
+			for k, v := range t.Params {
+				params[k] = v
+			}
+			for k, v := range d.Params {
+				params[k] = v
+			}
+			d.Params = params
+			c.Trx[i].Data[dataKey] = d
+		}
+	}
+	return nil
+}
+
 func (c *Stage) CommandLine(dsn, db string) {
 	if dsn != "" {
 		if c.MySQL.DSN != "" {
@@ -114,6 +288,9 @@ func (c *Stage) Vars() error {
 			return fmt.Errorf("in params: %s", err)
 		}
 	}
+	if err := validParams(c.ParamSpecs, c.Params); err != nil {
+		return err
+	}
 
 	// Interpolate $params.var in other config vars
 	c.Name, err = Vars(c.Name, c.Params, false)
@@ -124,6 +301,10 @@ func (c *Stage) Vars() error {
 	if err != nil {
 		return err
 	}
+	c.ExecMode, err = Vars(c.ExecMode, c.Params, false)
+	if err != nil {
+		return err
+	}
 	c.QPS, err = Vars(c.QPS, c.Params, true)
 	if err != nil {
 		return err
@@ -132,6 +313,47 @@ func (c *Stage) Vars() error {
 	if err != nil {
 		return err
 	}
+	c.GOMAXPROCS, err = Vars(c.GOMAXPROCS, c.Params, true)
+	if err != nil {
+		return err
+	}
+	c.CPUSet, err = Vars(c.CPUSet, c.Params, false)
+	if err != nil {
+		return err
+	}
+	for i := range c.Before {
+		if err := c.Before[i].Vars(c.Params); err != nil {
+			return fmt.Errorf("in before[%d]: %s", i, err)
+		}
+	}
+	for i := range c.After {
+		if err := c.After[i].Vars(c.Params); err != nil {
+			return fmt.Errorf("in after[%d]: %s", i, err)
+		}
+	}
+	for i := range c.Events {
+		if err := c.Events[i].Vars(c.Params); err != nil {
+			return fmt.Errorf("in events[%d]: %s", i, err)
+		}
+	}
+	if c.Throttle != nil {
+		if err := c.Throttle.Vars(c.Params); err != nil {
+			return fmt.Errorf("in throttle: %s", err)
+		}
+	}
+	if c.Pattern != nil {
+		if err := c.Pattern.Vars(c.Params); err != nil {
+			return fmt.Errorf("in pattern: %s", err)
+		}
+	}
+	c.Data.Persist, err = Vars(c.Data.Persist, c.Params, false)
+	if err != nil {
+		return fmt.Errorf("in data.persist: %s", err)
+	}
+	c.Data.UnknownParams, err = Vars(c.Data.UnknownParams, c.Params, false)
+	if err != nil {
+		return fmt.Errorf("in data.unknown-params: %s", err)
+	}
 	if err := c.Compute.Vars(c.Params); err != nil {
 		return fmt.Errorf("in compute: %s", err)
 	}
@@ -163,7 +385,7 @@ func (c *Stage) Validate() error {
 		c.Name = filepath.Base(c.File)
 	}
 
-	if len(c.Trx) == 0 {
+	if len(c.Trx) == 0 && !c.HasScript() {
 		return fmt.Errorf("stage %s has zero trx files and is not disabled; specify at least 1 trx file or %s.disable = true", c.Name, c.Name)
 	}
 
@@ -179,6 +401,27 @@ func (c *Stage) Validate() error {
 		if c.Trx[i].Name == "" {
 			c.Trx[i].Name = filepath.Base(c.Trx[i].File)
 		}
+		if r := c.Trx[i].Replay; r != nil {
+			switch r.Format {
+			case "":
+				r.Format = "general"
+			case "general", "slow":
+			default:
+				return fmt.Errorf("trx[%d].replay.format: invalid value: %s (valid: general, slow)", i, r.Format)
+			}
+			if len(c.Trx[i].Data) > 0 {
+				return fmt.Errorf("trx[%d]: data not allowed with replay", i)
+			}
+			if len(c.Workload) > 0 {
+				return fmt.Errorf("trx[%d]: workload must be empty (auto-assigned) with replay", i)
+			}
+			if c.Trx[i].Weight != "" {
+				return fmt.Errorf("trx[%d]: weight not allowed with replay", i)
+			}
+		}
+		if err := parseInt(c.Trx[i].Weight); err != nil {
+			return fmt.Errorf("trx[%d].weight: '%s' is not an integer: %s", i, c.Trx[i].Weight, err)
+		}
 
 		for dataKey, data := range c.Trx[i].Data {
 			if data.Generator == "" {
@@ -212,35 +455,157 @@ func (c *Stage) Validate() error {
 		}
 	}
 
-	// Workload
+	switch c.Data.UnknownParams {
+	case "", "error", "warn":
+	default:
+		return fmt.Errorf("invalid data.unknown-params: %s: valid values: error, warn", c.Data.UnknownParams)
+	}
+
+	// Workload, and per-remote workload overrides
+	if err := validWorkload(c.Name, c.Name+".workload", c.Workload, c.Trx); err != nil {
+		return err
+	}
+	for i := range c.Compute.Remotes {
+		if len(c.Compute.Remotes[i].Workload) == 0 {
+			continue
+		}
+		path := fmt.Sprintf("%s.compute.remotes[%d].workload", c.Name, i)
+		if err := validWorkload(c.Name, path, c.Compute.Remotes[i].Workload, c.Trx); err != nil {
+			return err
+		}
+	}
+
+	// Runtime
+	if err := ValidFreq(c.Runtime, "workload"); err != nil {
+		return err
+	}
+	switch c.ExecMode {
+	case "", EXEC_SEQUENTIAL, EXEC_CONCURRENT:
+	default:
+		return fmt.Errorf("invalid exec-mode: %s: valid values: %s, %s", c.ExecMode, EXEC_SEQUENTIAL, EXEC_CONCURRENT)
+	}
+	if c.Runtime != "" {
+		rt, _ := time.ParseDuration(c.Runtime) // validated above
+		for i := range c.Workload {
+			if c.Workload[i].StartAfter == "" {
+				continue
+			}
+			d, _ := time.ParseDuration(c.Workload[i].StartAfter) // validated in validWorkload
+			if d >= rt {
+				return fmt.Errorf("workload[%d]: start-after %s is not before stage runtime %s", i, c.Workload[i].StartAfter, c.Runtime)
+			}
+		}
+	}
+
+	if _, _, err := ParseRate(c.QPS); err != nil {
+		return fmt.Errorf("qps: %s", err)
+	}
+	if _, _, err := ParseRate(c.TPS); err != nil {
+		return fmt.Errorf("tps: %s", err)
+	}
+
+	if err := parseInt(c.GOMAXPROCS); err != nil {
+		return fmt.Errorf("gomaxprocs: '%s' is not an integer: %s", c.GOMAXPROCS, err)
+	}
+	if c.CPUSet != "" {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("cpu-set: only supported on Linux")
+		}
+		if _, err := ParseCPUSet(c.CPUSet); err != nil {
+			return fmt.Errorf("cpu-set: %s", err)
+		}
+	}
+
+	for i := range c.Before {
+		if err := c.Before[i].Validate(); err != nil {
+			return fmt.Errorf("before[%d]: %s", i, err)
+		}
+	}
+	for i := range c.After {
+		if err := c.After[i].Validate(); err != nil {
+			return fmt.Errorf("after[%d]: %s", i, err)
+		}
+	}
+	for i := range c.Events {
+		if err := c.Events[i].Validate(c.Runtime); err != nil {
+			return fmt.Errorf("events[%d]: %s", i, err)
+		}
+	}
+	if c.Throttle != nil {
+		if err := c.Throttle.Validate(); err != nil {
+			return fmt.Errorf("throttle: %s", err)
+		}
+		if c.Throttle.Reader && c.MySQL.Reader == nil {
+			return fmt.Errorf("throttle: reader is true but mysql.reader is not set")
+		}
+	}
+	if c.Pattern != nil {
+		if err := c.Pattern.Validate(); err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+		if c.Pattern.Sine != "" && !hasRate(c.QPS, c.TPS) {
+			return fmt.Errorf("pattern: sine requires qps or tps")
+		}
+	}
+
+	if err := c.MySQL.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Compute.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Stats.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validWorkload validates workload at path in stage (name).
+func validWorkload(stage, path string, workload []ClientGroup, trx []Trx) error {
 	names := map[string]int{}
+	queryLogs := map[string]int{} // file => first client group
 	withTrx := map[int]int{}
 	withoutTrx := map[int]int{}
-	for i := range c.Workload {
-		if err := c.Workload[i].Validate(c.Trx); err != nil {
+	for i := range workload {
+		if err := workload[i].Validate(trx); err != nil {
 			return err
 		}
 
-		if c.Workload[i].Group != "" {
-			if last, ok := names[c.Workload[i].Group]; !ok {
-				names[c.Workload[i].Group] = i
+		// Client groups that log to the same file share one query log, which
+		// has one sample rate
+		if file := workload[i].QueryLog; file != "" {
+			if first, ok := queryLogs[file]; !ok {
+				queryLogs[file] = i
+			} else if workload[first].QueryLogSample != workload[i].QueryLogSample {
+				return fmt.Errorf("%s[%d].query-log-sample: %s does not match query-log-sample %s of %s[%d], which logs to the same file %s", path, i, workload[i].QueryLogSample, workload[first].QueryLogSample, path, first, file)
+			}
+		}
+
+		if workload[i].Group != "" {
+			if last, ok := names[workload[i].Group]; !ok {
+				names[workload[i].Group] = i
 			} else {
 				if last != i-1 {
-					return fmt.Errorf("duplicate or non-consecutive execution group name: %s: first at %s.workload[%d], then at %s.workoad[%d]; unique group names must consecutive", c.Workload[i].Group, c.Name, last, c.Name, i)
+					return fmt.Errorf("duplicate or non-consecutive execution group name: %s: first at %s[%d], then at %s[%d]; unique group names must consecutive", workload[i].Group, path, last, path, i)
 				}
 			}
 		}
 
-		if len(c.Workload[i].Trx) > 0 {
+		if workload[i].Script != "" {
+			withTrx[i] = i // explicit assignment, like trx
+		} else if len(workload[i].Trx) > 0 {
 			withTrx[i] = i
 		TRX:
-			for j, trxName := range c.Workload[i].Trx {
-				for k := range c.Trx {
-					if trxName == c.Trx[k].Name {
+			for j, trxName := range workload[i].Trx {
+				for k := range trx {
+					if trxName == trx[k].Name {
 						continue TRX
 					}
 				}
-				return fmt.Errorf("%s.workload[%d].trx[%d]: '%s' not defined in %s.trx", c.Name, i, j, trxName, c.Name)
+				return fmt.Errorf("%s[%d].trx[%d]: '%s' not defined in %s.trx", path, i, j, trxName, stage)
 			}
 		} else {
 			withoutTrx[i] = i
@@ -248,68 +613,387 @@ func (c *Stage) Validate() error {
 	}
 
 	if len(withTrx) > 0 && len(withoutTrx) > 0 {
-		return fmt.Errorf("%s.workload has mixed trx assignments", c.Name)
+		return fmt.Errorf("%s has mixed trx assignments", path)
 	}
+	return nil
+}
 
-	// Runtime
-	if err := ValidFreq(c.Runtime, "workload"); err != nil {
+// HasScript returns true if a client group in the workload, or a remote workload
+// override, has a script (config.stage.workload.script), which doesn't require
+// trx files.
+func (c Stage) HasScript() bool {
+	workloads := [][]ClientGroup{c.Workload}
+	for i := range c.Compute.Remotes {
+		workloads = append(workloads, c.Compute.Remotes[i].Workload)
+	}
+	for _, w := range workloads {
+		for i := range w {
+			if w[i].Script != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Remote returns the stage config for the named remote instance. If the remote
+// is in compute.remotes with a workload, its workload replaces stage.workload.
+// Otherwise, the stage config is returned as-is.
+func (c Stage) Remote(name string) Stage {
+	for _, r := range c.Compute.Remotes {
+		if r.Name != name || len(r.Workload) == 0 {
+			continue
+		}
+		c.Workload = r.Workload
+		break
+	}
+	return c
+}
+
+// --------------------------------------------------------------------------
+
+type Compute struct {
+	DisableLocal bool     `yaml:"disable-local,omitempty"`
+	Instances    string   `yaml:"instances,omitempty"` // uint
+	Remotes      []Remote `yaml:"remotes,omitempty"`
+	TLS          TLS      `yaml:"tls,omitempty"`
+	Token        string   `yaml:"token,omitempty" json:"-"` // not sent to remotes
+	UI           bool     `yaml:"ui,omitempty"`
+}
+
+// Remote is a named remote compute instance. If any are specified, the stage is
+// assigned only to remotes with these names (--name on the remote). If Workload
+// is set, it overrides stage.workload on the remote.
+type Remote struct {
+	Name     string        `yaml:"name"`
+	Workload []ClientGroup `yaml:"workload,omitempty"`
+}
+
+func (c *Compute) Vars(params map[string]string) error {
+	var err error
+	c.Instances, err = Vars(c.Instances, params, true)
+	if err != nil {
 		return err
 	}
+	if err := c.TLS.Vars(params); err != nil {
+		return err
+	}
+	c.Token, err = Vars(c.Token, params, false)
+	if err != nil {
+		return err
+	}
+	for i := range c.Remotes {
+		c.Remotes[i].Name, err = Vars(c.Remotes[i].Name, params, false)
+		if err != nil {
+			return err
+		}
+		for j := range c.Remotes[i].Workload {
+			if err := c.Remotes[i].Workload[j].Vars(params); err != nil {
+				return fmt.Errorf("in remotes[%d].workload: %s", i, err)
+			}
+		}
+	}
+	return nil
+}
 
-	if err := parseInt(c.QPS); err != nil {
-		return fmt.Errorf("tps: '%s' is not an integer: %s", c.QPS, err)
+func (c *Compute) Validate() error {
+	if err := parseInt(c.Instances); err != nil {
+		return fmt.Errorf("instances: '%s' is not an integer: %s", c.Instances, err)
+	}
+	if len(c.Remotes) > 0 {
+		// Named remotes determine the number of instances: remotes + local
+		n := len(c.Remotes)
+		if !c.DisableLocal {
+			n += 1
+		}
+		if c.Instances != "" && c.Instances != fmt.Sprintf("%d", n) {
+			return fmt.Errorf("instances: %s does not match %d remotes (%d instances with local)", c.Instances, len(c.Remotes), n)
+		}
+		c.Instances = fmt.Sprintf("%d", n)
+		names := map[string]bool{}
+		for i, r := range c.Remotes {
+			if r.Name == "" {
+				return fmt.Errorf("remotes[%d]: name is required", i)
+			}
+			if names[r.Name] {
+				return fmt.Errorf("remotes[%d]: duplicate name: %s", i, r.Name)
+			}
+			names[r.Name] = true
+		}
+	}
+	if c.Instances == "" {
+		c.Instances = "1"
 	}
-	if err := parseInt(c.TPS); err != nil {
-		return fmt.Errorf("tps: '%s' is not an integer: %s", c.TPS, err)
+	if c.TLS.Set() {
+		// The server needs its own cert and key; ca is optional (mTLS)
+		if c.TLS.Cert == "" || c.TLS.Key == "" {
+			return fmt.Errorf("compute.tls: cert and key are required")
+		}
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("compute.%s", err)
+		}
 	}
+	return nil
+}
 
-	if err := c.MySQL.Validate(); err != nil {
+// --------------------------------------------------------------------------
+
+// Hook is a shell command or SQL run before or after a stage (config.stage.before
+// and config.stage.after). Only one of Exec, SQL, or SQLFile is set.
+type Hook struct {
+	Exec    string `yaml:"exec,omitempty"`     // shell command (sh -c)
+	SQL     string `yaml:"sql,omitempty"`      // statements separated by ;
+	SQLFile string `yaml:"sql-file,omitempty"` // file of statements separated by ;
+	Output  string `yaml:"output,omitempty"`   // append output to file, else log
+	Timeout string `yaml:"timeout,omitempty"`  // duration
+}
+
+func (c *Hook) Validate() error {
+	n := 0
+	for _, s := range []string{c.Exec, c.SQL, c.SQLFile} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one of exec, sql, or sql-file must be set")
+	}
+	if c.SQLFile != "" && !FileExists(c.SQLFile) {
+		return fmt.Errorf("sql-file %s does not exist", c.SQLFile)
+	}
+	if err := ValidFreq(c.Timeout, "timeout"); err != nil {
 		return err
 	}
+	return nil
+}
 
-	if err := c.Compute.Validate(); err != nil {
+// Vars interpolates all fields except Exec, which the shell interpolates (like
+// $HOME and $(pidof mysqld)).
+func (c *Hook) Vars(params map[string]string) error {
+	var err error
+	c.SQL, err = Vars(c.SQL, params, false)
+	if err != nil {
 		return err
 	}
-
-	if err := c.Stats.Validate(); err != nil {
+	c.SQLFile, err = Vars(c.SQLFile, params, false)
+	if err != nil {
+		return err
+	}
+	c.Output, err = Vars(c.Output, params, false)
+	if err != nil {
+		return err
+	}
+	c.Timeout, err = Vars(c.Timeout, params, false)
+	if err != nil {
 		return err
 	}
-
 	return nil
 }
 
-// --------------------------------------------------------------------------
+// Throttle is config.stage.throttle: an adaptive limit that periodically runs
+// a query that returns a MySQL metric, like replica lag or threads running, and
+// throttles clients while the metric exceeds a threshold.
+type Throttle struct {
+	Query  string `yaml:"query"`            // returns the metric in first row
+	Column string `yaml:"column,omitempty"` // metric column; default first column
+	Reader bool   `yaml:"reader,omitempty"` // run query on mysql.reader
+	Max    string `yaml:"max"`              // throttle when metric > max
+	Resume string `yaml:"resume,omitempty"` // resume when metric <= resume; default max
+	QPS    string `yaml:"qps,omitempty"`    // uint QPS while throttled; default 0 (pause)
+	Freq   string `yaml:"freq,omitempty"`   // how often to run query; default 1s
+}
 
-type Compute struct {
-	DisableLocal bool   `yaml:"disable-local,omitempty"`
-	Instances    string `yaml:"instances,omitempty"` // uint
+func (c *Throttle) Validate() error {
+	if c.Query == "" {
+		return fmt.Errorf("query must be set")
+	}
+	if c.Max == "" {
+		return fmt.Errorf("max must be set")
+	}
+	max, err := strconv.ParseFloat(c.Max, 64)
+	if err != nil {
+		return fmt.Errorf("max: '%s' is not a number: %s", c.Max, err)
+	}
+	if c.Resume == "" {
+		c.Resume = c.Max
+	}
+	resume, err := strconv.ParseFloat(c.Resume, 64)
+	if err != nil {
+		return fmt.Errorf("resume: '%s' is not a number: %s", c.Resume, err)
+	}
+	if resume > max {
+		return fmt.Errorf("resume %s is greater than max %s", c.Resume, c.Max)
+	}
+	if err := parseInt(c.QPS); err != nil {
+		return fmt.Errorf("qps: '%s' is not an integer: %s", c.QPS, err)
+	}
+	if c.Freq == "" {
+		c.Freq = "1s"
+	}
+	return ValidFreq(c.Freq, "stage.throttle.freq")
 }
 
-func (c *Compute) Vars(params map[string]string) error {
+func (c *Throttle) Vars(params map[string]string) error {
 	var err error
-	c.Instances, err = Vars(c.Instances, params, true)
+	c.Query, err = Vars(c.Query, params, false)
+	if err != nil {
+		return err
+	}
+	c.Column, err = Vars(c.Column, params, false)
+	if err != nil {
+		return err
+	}
+	c.Max, err = Vars(c.Max, params, true)
+	if err != nil {
+		return err
+	}
+	c.Resume, err = Vars(c.Resume, params, true)
+	if err != nil {
+		return err
+	}
+	c.QPS, err = Vars(c.QPS, params, true)
+	if err != nil {
+		return err
+	}
+	c.Freq, err = Vars(c.Freq, params, false)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Compute) Validate() error {
-	if err := parseInt(c.Instances); err != nil {
-		return fmt.Errorf("instances: '%s' is not an integer: %s", c.Instances, err)
+// Pattern is a load pattern (config.stage.pattern and config.stage.workload.pattern)
+// that varies load over time: clients run for on, pause for off, and repeat; and
+// QPS and TPS limits follow a sine wave with period sine from min to 100 percent
+// of the limits.
+type Pattern struct {
+	On   string `yaml:"on,omitempty"`   // run clients for duration, then off
+	Off  string `yaml:"off,omitempty"`  // pause clients for duration, then on
+	Sine string `yaml:"sine,omitempty"` // period of sine wave of QPS and TPS limits
+	Min  string `yaml:"min,omitempty"`  // percent of limits at sine wave trough; default 10
+}
+
+func (c *Pattern) Validate() error {
+	if (c.On == "") != (c.Off == "") {
+		return fmt.Errorf("on and off must be set together")
 	}
-	if c.Instances == "" {
-		c.Instances = "1"
+	if c.On == "" && c.Sine == "" {
+		return fmt.Errorf("on and off, or sine must be set")
+	}
+	for _, d := range []struct{ name, val string }{{"on", c.On}, {"off", c.Off}, {"sine", c.Sine}} {
+		if d.val == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.val); err != nil || v <= 0 {
+			return fmt.Errorf("%s: '%s' is not a duration greater than zero", d.name, d.val)
+		}
+	}
+	if c.Min != "" && c.Sine == "" {
+		return fmt.Errorf("min is only valid with sine")
+	}
+	if c.Min == "" && c.Sine != "" {
+		c.Min = "10"
+	}
+	if err := parseInt(c.Min); err != nil {
+		return fmt.Errorf("min: '%s' is not an integer: %s", c.Min, err)
+	}
+	if finch.Uint(c.Min) > 100 {
+		return fmt.Errorf("min: %s is greater than 100 (percent)", c.Min)
+	}
+	return nil
+}
+
+// hasRate returns true if any rate (ParseRate) is greater than zero. The rates
+// must be valid.
+func hasRate(rates ...string) bool {
+	for _, s := range rates {
+		if n, _, _ := ParseRate(s); n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Pattern) Vars(params map[string]string) error {
+	var err error
+	c.On, err = Vars(c.On, params, false)
+	if err != nil {
+		return err
+	}
+	c.Off, err = Vars(c.Off, params, false)
+	if err != nil {
+		return err
+	}
+	c.Sine, err = Vars(c.Sine, params, false)
+	if err != nil {
+		return err
+	}
+	c.Min, err = Vars(c.Min, params, true)
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
+// StageData is stage-level data config (config.stage.data), as opposed to trx
+// data keys (config.stage.trx[].data).
+type StageData struct {
+	Persist       string          `yaml:"persist,omitempty"`        // JSON file to save and restore generator state
+	Keys          []string        `yaml:"keys,omitempty"`           // data keys to persist; default all
+	UnknownParams string          `yaml:"unknown-params,omitempty"` // error (default) or warn
+	Templates     map[string]Data `yaml:"templates,omitempty"`      // data key configs referenced by trx[].data[].template
+}
+
+// Event is a Hook run at a time during a stage (config.stage.events), like
+// fault injection. At is the duration after the stage starts running clients.
+type Event struct {
+	At   string `yaml:"at"`
+	Hook `yaml:",inline"`
+}
+
+// Validate validates the event. If the stage runtime is set, the event must
+// be scheduled before the stage ends.
+func (c *Event) Validate(stageRuntime string) error {
+	if c.At == "" {
+		return fmt.Errorf("at must be set")
+	}
+	if err := ValidFreq(c.At, "at"); err != nil {
+		return err
+	}
+	if stageRuntime != "" {
+		at, _ := time.ParseDuration(c.At)
+		rt, _ := time.ParseDuration(stageRuntime) // already validated
+		if at >= rt {
+			return fmt.Errorf("at %s is not before stage runtime %s", c.At, stageRuntime)
+		}
+	}
+	return c.Hook.Validate()
+}
+
+func (c *Event) Vars(params map[string]string) error {
+	var err error
+	c.At, err = Vars(c.At, params, false)
+	if err != nil {
+		return err
+	}
+	return c.Hook.Vars(params)
+}
+
 // --------------------------------------------------------------------------
 
 type Trx struct {
-	Name string
-	File string
-	Data map[string]Data
+	Name   string
+	File   string
+	Data   map[string]Data
+	Replay *Replay `yaml:"replay,omitempty"`
+	Weight string  `yaml:"weight,omitempty"` // clients execute 1 trx per iter chosen by weight
+}
+
+// Replay makes Trx.File a MySQL log to replay instead of a trx file. Each
+// connection in the log is loaded as a separate trx executed by its own client.
+type Replay struct {
+	Format string `yaml:"format"` // general or slow
+	Timing bool   `yaml:"timing"` // sleep between queries like the original connection
 }
 
 func (c *Trx) Vars(params map[string]string) error {
@@ -322,6 +1006,16 @@ func (c *Trx) Vars(params map[string]string) error {
 	if err != nil {
 		return err
 	}
+	c.Weight, err = Vars(c.Weight, params, true)
+	if err != nil {
+		return err
+	}
+	if c.Replay != nil {
+		c.Replay.Format, err = Vars(c.Replay.Format, params, false)
+		if err != nil {
+			return err
+		}
+	}
 	for k := range c.Data {
 		d := c.Data[k]
 		if err := d.Vars(params); err != nil {
@@ -336,7 +1030,8 @@ type Data struct {
 	Name      string            `yaml:"name"`      // @id
 	Generator string            `yaml:"generator"` // data.Generator type
 	Scope     string            `yaml:"scope"`
-	Params    map[string]string `yaml:"params"` // Generator-specific params
+	Params    map[string]string `yaml:"params"`             // Generator-specific params
+	Template  string            `yaml:"template,omitempty"` // stage.data.templates name, if any
 }
 
 func (c *Data) Vars(params map[string]string) error {
@@ -364,22 +1059,45 @@ func (c *Data) Vars(params map[string]string) error {
 
 // --------------------------------------------------------------------------
 
+// Client group default database templates (config.stage.workload.db)
+const (
+	DB_CLIENT       = "{client}"       // client number in client group
+	DB_CLIENT_GROUP = "{client-group}" // client group number
+)
+
 type ClientGroup struct {
-	Clients       string   `yaml:"clients,omitempty"` // uint
-	Db            string   `yaml:"db,omitempty"`
-	DisableStats  bool     `yaml:"disable-stats,omitempty"`
-	Iter          string   `yaml:"iter,omitempty"`            // uint
-	IterClients   string   `yaml:"iter-clients,omitempty"`    // uint
-	IterExecGroup string   `yaml:"iter-exec-group,omitempty"` // uint
-	Group         string   `yaml:"group,omitempty"`
-	QPS           string   `yaml:"qps,omitempty"`            // uint
-	QPSClients    string   `yaml:"qps-clients,omitempty"`    // uint
-	QPSExecGroup  string   `yaml:"qps-exec-group,omitempty"` // uint
-	Runtime       string   `yaml:"runtime,omitempty"`
-	TPS           string   `yaml:"tps,omitempty"`
-	TPSClients    string   `yaml:"tps-clients,omitempty"`
-	TPSExecGroup  string   `yaml:"tps-exec-group,omitempty"`
-	Trx           []string `yaml:"trx,omitempty"`
+	ArrivalRate    string            `yaml:"arrival-rate,omitempty"` // uint
+	Clients        string            `yaml:"clients,omitempty"`      // uint
+	CorrectLatency bool              `yaml:"correct-latency,omitempty"`
+	Db             string            `yaml:"db,omitempty"`
+	DisableStats   bool              `yaml:"disable-stats,omitempty"`
+	Iter           string            `yaml:"iter,omitempty"`            // uint
+	IterClients    string            `yaml:"iter-clients,omitempty"`    // uint
+	IterExecGroup  string            `yaml:"iter-exec-group,omitempty"` // uint
+	IterDelay      string            `yaml:"iter-delay,omitempty"`      // D or D jitter J
+	IterGlobal     string            `yaml:"iter-global,omitempty"`     // uint
+	Group          string            `yaml:"group,omitempty"`
+	Measure        string            `yaml:"measure,omitempty"`        // all|sampled|none
+	MeasureSample  string            `yaml:"measure-sample,omitempty"` // uint
+	Pattern        *Pattern          `yaml:"pattern,omitempty"`
+	QPS            string            `yaml:"qps,omitempty"`            // N or N burst B
+	QPSClients     string            `yaml:"qps-clients,omitempty"`    // N or N burst B
+	QPSExecGroup   string            `yaml:"qps-exec-group,omitempty"` // N or N burst B
+	QueryLog       string            `yaml:"query-log,omitempty"`
+	QueryLogSample string            `yaml:"query-log-sample,omitempty"` // uint
+	Runtime        string            `yaml:"runtime,omitempty"`
+	Script         string            `yaml:"script,omitempty"`         // Lua file executed instead of trx
+	Session        map[string]string `yaml:"session,omitempty"`        // SET SESSION variables
+	StartAfter     string            `yaml:"start-after,omitempty"`    // exec group start after stage start
+	TPS            string            `yaml:"tps,omitempty"`            // N or N burst B
+	TPSClients     string            `yaml:"tps-clients,omitempty"`    // N or N burst B
+	TPSExecGroup   string            `yaml:"tps-exec-group,omitempty"` // N or N burst B
+	TPSRefund      bool              `yaml:"tps-refund,omitempty"`     // refund TPS allowance if MySQL trx doesn't commit
+	Trace          string            `yaml:"trace,omitempty"`          // OTLP/HTTP URL
+	TraceSample    string            `yaml:"trace-sample,omitempty"`   // uint
+	Transport      string            `yaml:"transport,omitempty"`      // socket|tcp|tls
+	Trx            []string          `yaml:"trx,omitempty"`
+	WrapTrx        bool              `yaml:"wrap-trx,omitempty"` // BEGIN and COMMIT each trx
 }
 
 func (c *ClientGroup) Validate(w []Trx) error {
@@ -389,6 +1107,9 @@ func (c *ClientGroup) Validate(w []Trx) error {
 	if c.Clients == "" {
 		c.Clients = "1"
 	}
+	if db := strings.NewReplacer(DB_CLIENT, "", DB_CLIENT_GROUP, "").Replace(c.Db); strings.ContainsAny(db, "{}") {
+		return fmt.Errorf("invalid db: %s: valid templates: %s, %s", c.Db, DB_CLIENT, DB_CLIENT_GROUP)
+	}
 
 	if err := parseInt(c.Iter); err != nil {
 		return fmt.Errorf("iter: '%s' is not an integer: %s", c.Iter, err)
@@ -399,35 +1120,127 @@ func (c *ClientGroup) Validate(w []Trx) error {
 	if err := parseInt(c.IterExecGroup); err != nil {
 		return fmt.Errorf("iter-exec-group: '%s' is not an integer: %s", c.IterExecGroup, err)
 	}
+	if err := parseInt(c.IterGlobal); err != nil {
+		return fmt.Errorf("iter-global: '%s' is not an integer: %s", c.IterGlobal, err)
+	}
+	if _, _, err := ParseIterDelay(c.IterDelay); err != nil {
+		return err
+	}
 
-	if err := parseInt(c.QPS); err != nil {
-		return fmt.Errorf("iter: '%s' is not an integer: %s", c.QPS, err)
+	if err := parseInt(c.ArrivalRate); err != nil {
+		return fmt.Errorf("arrival-rate: '%s' is not an integer: %s", c.ArrivalRate, err)
 	}
-	if err := parseInt(c.QPSClients); err != nil {
-		return fmt.Errorf("iter-clients: '%s' is not an integer: %s", c.QPSClients, err)
+	if finch.Uint(c.ArrivalRate) > 1e9 {
+		return fmt.Errorf("arrival-rate: %s is greater than the max 1,000,000,000 (1 arrival per nanosecond)", c.ArrivalRate)
 	}
-	if err := parseInt(c.QPSExecGroup); err != nil {
-		return fmt.Errorf("iter-exec-group: '%s' is not an integer: %s", c.QPSExecGroup, err)
+	if finch.Uint(c.ArrivalRate) > 0 && c.IterDelay != "" {
+		return fmt.Errorf("arrival-rate and iter-delay are mutually exclusive: arrival-rate is open-loop, iter-delay is closed-loop think time")
 	}
 
-	if err := parseInt(c.TPS); err != nil {
-		return fmt.Errorf("tps: '%s' is not an integer: %s", c.TPS, err)
+	if _, _, err := ParseRate(c.QPS); err != nil {
+		return fmt.Errorf("qps: %s", err)
+	}
+	if _, _, err := ParseRate(c.QPSClients); err != nil {
+		return fmt.Errorf("qps-clients: %s", err)
+	}
+	if _, _, err := ParseRate(c.QPSExecGroup); err != nil {
+		return fmt.Errorf("qps-exec-group: %s", err)
+	}
+
+	if _, _, err := ParseRate(c.TPS); err != nil {
+		return fmt.Errorf("tps: %s", err)
+	}
+	if _, _, err := ParseRate(c.TPSClients); err != nil {
+		return fmt.Errorf("tps-clients: %s", err)
+	}
+	if _, _, err := ParseRate(c.TPSExecGroup); err != nil {
+		return fmt.Errorf("tps-exec-group: %s", err)
 	}
-	if err := parseInt(c.TPSClients); err != nil {
-		return fmt.Errorf("tps-clients: '%s' is not an integer: %s", c.TPSClients, err)
+
+	if c.Script != "" {
+		if len(c.Trx) > 0 {
+			return fmt.Errorf("script and trx are mutually exclusive")
+		}
+		if c.WrapTrx {
+			return fmt.Errorf("wrap-trx is not supported with script; the script executes BEGIN and COMMIT")
+		}
+		if c.QueryLog != "" || c.Trace != "" {
+			return fmt.Errorf("query-log and trace are not supported with script")
+		}
+		if !FileExists(c.Script) {
+			return fmt.Errorf("script file %s does not exist", c.Script)
+		}
 	}
-	if err := parseInt(c.TPSExecGroup); err != nil {
-		return fmt.Errorf("tps-exec-group: '%s' is not an integer: %s", c.TPSExecGroup, err)
+
+	if c.Pattern != nil {
+		if err := c.Pattern.Validate(); err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+		if c.Pattern.Sine != "" && !hasRate(c.QPS, c.QPSClients, c.QPSExecGroup, c.TPS, c.TPSClients, c.TPSExecGroup) {
+			return fmt.Errorf("pattern: sine requires a QPS or TPS limit: qps, qps-clients, qps-exec-group, tps, tps-clients, or tps-exec-group")
+		}
 	}
 
 	if err := ValidFreq(c.Runtime, "workload.runtime"); err != nil {
 		return err
 	}
+	if err := ValidFreq(c.StartAfter, "workload.start-after"); err != nil {
+		return err
+	}
+
+	if err := parseInt(c.MeasureSample); err != nil {
+		return fmt.Errorf("measure-sample: '%s' is not an integer: %s", c.MeasureSample, err)
+	}
+	switch c.Measure {
+	case "", "all", "none":
+	case "sampled":
+		if finch.Uint(c.MeasureSample) == 0 {
+			c.MeasureSample = "100"
+		}
+	default:
+		return fmt.Errorf("measure: invalid value: %s (valid: all, sampled, none)", c.Measure)
+	}
+
+	if err := parseInt(c.QueryLogSample); err != nil {
+		return fmt.Errorf("query-log-sample: '%s' is not an integer: %s", c.QueryLogSample, err)
+	}
+	if c.QueryLog != "" && finch.Uint(c.QueryLogSample) == 0 {
+		c.QueryLogSample = "1000"
+	}
+
+	for name := range c.Session {
+		if !reSessionVar.MatchString(name) {
+			return fmt.Errorf("session: invalid variable name: '%s'", name)
+		}
+	}
+
+	switch c.Transport {
+	case "", "socket", "tcp", "tls":
+	default:
+		return fmt.Errorf("transport: invalid value: %s (valid: socket, tcp, tls)", c.Transport)
+	}
+
+	if err := parseInt(c.TraceSample); err != nil {
+		return fmt.Errorf("trace-sample: '%s' is not an integer: %s", c.TraceSample, err)
+	}
+	if c.Trace != "" {
+		if !strings.HasPrefix(c.Trace, "http://") && !strings.HasPrefix(c.Trace, "https://") {
+			return fmt.Errorf("trace: '%s' is not an http:// or https:// URL", c.Trace)
+		}
+		if finch.Uint(c.TraceSample) == 0 {
+			c.TraceSample = "1000"
+		}
+	}
 	return nil
 }
 
 func (c *ClientGroup) Vars(params map[string]string) error {
 	var err error
+	if c.Pattern != nil {
+		if err := c.Pattern.Vars(params); err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+	}
 	c.Db, err = Vars(c.Db, params, false)
 	if err != nil {
 		return err
@@ -436,6 +1249,18 @@ func (c *ClientGroup) Vars(params map[string]string) error {
 	if err != nil {
 		return err
 	}
+	c.ArrivalRate, err = Vars(c.ArrivalRate, params, true)
+	if err != nil {
+		return err
+	}
+	c.IterGlobal, err = Vars(c.IterGlobal, params, true)
+	if err != nil {
+		return err
+	}
+	c.IterDelay, err = Vars(c.IterDelay, params, false)
+	if err != nil {
+		return err
+	}
 	c.QPS, err = Vars(c.QPS, params, true)
 	if err != nil {
 		return err
@@ -464,10 +1289,52 @@ func (c *ClientGroup) Vars(params map[string]string) error {
 	if err != nil {
 		return err
 	}
+	c.StartAfter, err = Vars(c.StartAfter, params, false)
+	if err != nil {
+		return err
+	}
+	c.Script, err = Vars(c.Script, params, false)
+	if err != nil {
+		return err
+	}
 	c.Group, err = Vars(c.Group, params, false)
 	if err != nil {
 		return err
 	}
+	c.Measure, err = Vars(c.Measure, params, false)
+	if err != nil {
+		return err
+	}
+	c.MeasureSample, err = Vars(c.MeasureSample, params, true)
+	if err != nil {
+		return err
+	}
+	c.QueryLog, err = Vars(c.QueryLog, params, false)
+	if err != nil {
+		return err
+	}
+	c.QueryLogSample, err = Vars(c.QueryLogSample, params, true)
+	if err != nil {
+		return err
+	}
+	c.Trace, err = Vars(c.Trace, params, false)
+	if err != nil {
+		return err
+	}
+	c.TraceSample, err = Vars(c.TraceSample, params, true)
+	if err != nil {
+		return err
+	}
+	c.Transport, err = Vars(c.Transport, params, false)
+	if err != nil {
+		return err
+	}
+	for name := range c.Session {
+		c.Session[name], err = Vars(c.Session[name], params, false)
+		if err != nil {
+			return err
+		}
+	}
 	for i := range c.Trx {
 		c.Trx[i], err = Vars(c.Trx[i], params, false)
 		if err != nil {
@@ -480,18 +1347,28 @@ func (c *ClientGroup) Vars(params map[string]string) error {
 // --------------------------------------------------------------------------
 
 type MySQL struct {
-	Db             string `yaml:"db,omitempty"`
-	DSN            string `yaml:"dsn,omitempty"`
-	Hostname       string `yaml:"hostname,omitempty"`
-	MyCnf          string `yaml:"mycnf,omitempty"`
-	Password       string `yaml:"password,omitempty"`
-	PasswordFile   string `yaml:"password-file,omitempty"`
-	Socket         string `yaml:"socket,omitempty"`
-	TimeoutConnect string `yaml:"timeout-connect,omitempty"`
-	TLS            TLS    `yaml:"tls,omitempty"`
-	Username       string `yaml:"username,omitempty"`
-
+	Db             string            `yaml:"db,omitempty"`
+	DSN            string            `yaml:"dsn,omitempty"`
+	Hostname       string            `yaml:"hostname,omitempty"`
+	MyCnf          string            `yaml:"mycnf,omitempty"`
+	Params         map[string]string `yaml:"params,omitempty"` // DSN params like readTimeout
+	Password       string            `yaml:"password,omitempty"`
+	PasswordFile   string            `yaml:"password-file,omitempty"`
+	Socket         string            `yaml:"socket,omitempty"`
+	TimeoutConnect string            `yaml:"timeout-connect,omitempty"`
+	TimeoutRead    string            `yaml:"timeout-read,omitempty"`
+	TimeoutWrite   string            `yaml:"timeout-write,omitempty"`
+	TLS            TLS               `yaml:"tls,omitempty"`
+	Username       string            `yaml:"username,omitempty"`
+
+	CreateDb       *bool `yaml:"create-db,omitempty"` // CREATE DATABASE IF NOT EXISTS db in stage.Prepare
 	DisableAutoTLS *bool `yaml:"disable-auto-tls,omitempty"`
+	Warnings       *bool `yaml:"warnings,omitempty"` // SHOW WARNINGS after every statement, like -- warnings
+
+	// Reader is an optional second endpoint (usually a replica) for statements
+	// with the -- reader modifier. Values not set are inherited from the writer
+	// (see ReaderConfig).
+	Reader *MySQL `yaml:"reader,omitempty"`
 }
 
 // With returns the MySQL config c with defaults from def. It's called in
@@ -510,6 +1387,16 @@ func (c *MySQL) With(def MySQL) {
 	if c.MyCnf == "" && def.MyCnf != "" {
 		c.MyCnf = def.MyCnf
 	}
+	if len(def.Params) > 0 {
+		params := make(map[string]string, len(def.Params)+len(c.Params))
+		for k, v := range def.Params {
+			params[k] = v
+		}
+		for k, v := range c.Params { // c overrides def
+			params[k] = v
+		}
+		c.Params = params
+	}
 	if c.Password == "" && def.Password != "" {
 		c.Password = def.Password
 	}
@@ -522,11 +1409,43 @@ func (c *MySQL) With(def MySQL) {
 	if c.TimeoutConnect == "" && def.TimeoutConnect != "" {
 		c.TimeoutConnect = def.TimeoutConnect
 	}
+	if c.TimeoutRead == "" && def.TimeoutRead != "" {
+		c.TimeoutRead = def.TimeoutRead
+	}
+	if c.TimeoutWrite == "" && def.TimeoutWrite != "" {
+		c.TimeoutWrite = def.TimeoutWrite
+	}
 	if c.Username == "" && def.Username != "" {
 		c.Username = def.Username
 	}
+	c.CreateDb = setBool(c.CreateDb, def.CreateDb)
 	c.DisableAutoTLS = setBool(c.DisableAutoTLS, def.DisableAutoTLS)
+	c.Warnings = setBool(c.Warnings, def.Warnings)
 	c.TLS.With(def.TLS)
+	if c.Reader == nil && def.Reader != nil {
+		r := *def.Reader
+		c.Reader = &r
+	}
+}
+
+// ReaderConfig returns the config for the reader endpoint (mysql.reader), or
+// nil if not set. Reader values not set are inherited from the writer except
+// dsn, hostname, and socket: if the reader sets any one of those, the other two
+// are not inherited because they specify the writer.
+func (c MySQL) ReaderConfig() *MySQL {
+	if c.Reader == nil {
+		return nil
+	}
+	r := *c.Reader
+	def := c
+	def.Reader = nil
+	if r.DSN != "" || r.Hostname != "" || r.Socket != "" {
+		def.DSN = ""
+		def.Hostname = ""
+		def.Socket = ""
+	}
+	r.With(def)
+	return &r
 }
 
 func (c *MySQL) Vars(params map[string]string) error {
@@ -559,13 +1478,60 @@ func (c *MySQL) Vars(params map[string]string) error {
 	if err != nil {
 		return err
 	}
+	c.TimeoutRead, err = Vars(c.TimeoutRead, params, false)
+	if err != nil {
+		return err
+	}
+	c.TimeoutWrite, err = Vars(c.TimeoutWrite, params, false)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Params {
+		c.Params[k], err = Vars(v, params, false)
+		if err != nil {
+			return err
+		}
+	}
 	if err := c.TLS.Vars(params); err != nil {
 		return err
 	}
+	if c.Reader != nil {
+		if err := c.Reader.Vars(params); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (c *MySQL) Validate() error {
+	if c.Reader != nil && c.Reader.Reader != nil {
+		return fmt.Errorf("mysql.reader.reader is not allowed")
+	}
+	if strings.ContainsAny(c.Db, "{}") {
+		return fmt.Errorf("invalid mysql.db: %s: templates like %s are valid only in stage.workload.db", c.Db, DB_CLIENT)
+	}
+	for k := range c.Params {
+		if k == "" || strings.ContainsAny(k, "=&?") {
+			return fmt.Errorf("invalid mysql.params key: '%s'", k)
+		}
+	}
+	for _, t := range []struct{ name, val string }{
+		{"timeout-connect", c.TimeoutConnect},
+		{"timeout-read", c.TimeoutRead},
+		{"timeout-write", c.TimeoutWrite},
+	} {
+		if t.val == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(t.val); err != nil || d < 0 {
+			return fmt.Errorf("invalid mysql.%s: %s: must be a duration >= 0", t.name, t.val)
+		}
+	}
+	if c.Reader != nil {
+		if err := c.Reader.Validate(); err != nil {
+			return fmt.Errorf("reader: %s", err)
+		}
+	}
 	return nil
 }
 
@@ -707,13 +1673,35 @@ func (c TLS) LoadTLS(server string) (*tls.Config, error) {
 
 // --------------------------------------------------------------------------
 
+// Final report policies (config.stats.final-policy)
+const (
+	FINAL_WAIT_FOREVER  = "wait-forever"  // wait for final stats from all instances
+	FINAL_FORCE_PARTIAL = "force-partial" // default: report incomplete intervals on timeout
+	FINAL_DROP          = "drop"          // discard incomplete intervals on timeout
+)
+
 type Stats struct {
-	Disable *bool                        `yaml:"disable"`
-	Freq    string                       `yaml:"freq,omitempty"`
-	Report  map[string]map[string]string `yaml:"report,omitempty"`
+	Aggregate    string                       `yaml:"aggregate,omitempty"` // client|trx
+	Buffer       string                       `yaml:"buffer,omitempty"`    // uint
+	Clock        string                       `yaml:"clock,omitempty"`     // system|coarse
+	ClockTick    string                       `yaml:"clock-tick,omitempty"`
+	Disable      *bool                        `yaml:"disable"`
+	FinalPolicy  string                       `yaml:"final-policy,omitempty"`  // FINAL_ const
+	FinalTimeout string                       `yaml:"final-timeout,omitempty"` // duration
+	Freq         string                       `yaml:"freq,omitempty"`
+	Precision    string                       `yaml:"precision,omitempty"` // us|ns
+	Report       map[string]map[string]string `yaml:"report,omitempty"`
+	SLO          []string                     `yaml:"slo,omitempty"`   // response time thresholds
+	Spool        string                       `yaml:"spool,omitempty"` // uint: max intervals remotes buffer while server unreachable
 }
 
 func (c *Stats) Validate() error {
+	if err := parseInt(c.Buffer); err != nil {
+		return fmt.Errorf("stats.buffer: '%s' is not an integer: %s", c.Buffer, err)
+	}
+	if err := parseInt(c.Spool); err != nil {
+		return fmt.Errorf("stats.spool: '%s' is not an integer: %s", c.Spool, err)
+	}
 	if c.Freq == "" {
 		c.Freq = "0s" // one report for the entire runtime
 	} else {
@@ -721,6 +1709,47 @@ func (c *Stats) Validate() error {
 			return err
 		}
 	}
+	switch c.Clock {
+	case "", "system": // default
+	case "coarse":
+		if c.ClockTick == "" {
+			c.ClockTick = "100us"
+		}
+		if err := ValidFreq(c.ClockTick, "stats.clock-tick"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid stats.clock: %s: valid values: system, coarse", c.Clock)
+	}
+	if err := ValidFreq(c.FinalTimeout, "stats.final-timeout"); err != nil {
+		return err
+	}
+	switch c.FinalPolicy {
+	case "", FINAL_WAIT_FOREVER, FINAL_FORCE_PARTIAL, FINAL_DROP: // default force-partial
+	default:
+		return fmt.Errorf("invalid stats.final-policy: %s: valid values: %s, %s, %s", c.FinalPolicy, FINAL_WAIT_FOREVER, FINAL_FORCE_PARTIAL, FINAL_DROP)
+	}
+	switch c.Aggregate {
+	case "", "client", "trx": // default client
+	default:
+		return fmt.Errorf("invalid stats.aggregate: %s: valid values: client, trx", c.Aggregate)
+	}
+	switch c.Precision {
+	case "", "us", "ns": // default us
+	default:
+		return fmt.Errorf("invalid stats.precision: %s: valid values: us, ns", c.Precision)
+	}
+	var prev time.Duration
+	for _, s := range c.SLO {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid stats.slo: %s: %s", s, err)
+		}
+		if d <= prev {
+			return fmt.Errorf("invalid stats.slo: %s: thresholds must be greater than zero and in ascending order", s)
+		}
+		prev = d
+	}
 	if len(c.Report) == 0 {
 		c.Report = map[string]map[string]string{
 			"stdout": {"each-instance": "true"},
@@ -740,10 +1769,48 @@ func (c *Stats) Validate() error {
 
 func (c *Stats) Vars(params map[string]string) error {
 	var err error
+	c.Buffer, err = Vars(c.Buffer, params, true)
+	if err != nil {
+		return err
+	}
+	c.Spool, err = Vars(c.Spool, params, true)
+	if err != nil {
+		return err
+	}
 	c.Freq, err = Vars(c.Freq, params, false)
 	if err != nil {
 		return err
 	}
+	c.FinalTimeout, err = Vars(c.FinalTimeout, params, false)
+	if err != nil {
+		return err
+	}
+	c.FinalPolicy, err = Vars(c.FinalPolicy, params, false)
+	if err != nil {
+		return err
+	}
+	c.Clock, err = Vars(c.Clock, params, false)
+	if err != nil {
+		return err
+	}
+	c.ClockTick, err = Vars(c.ClockTick, params, false)
+	if err != nil {
+		return err
+	}
+	c.Precision, err = Vars(c.Precision, params, false)
+	if err != nil {
+		return err
+	}
+	c.Aggregate, err = Vars(c.Aggregate, params, false)
+	if err != nil {
+		return err
+	}
+	for i := range c.SLO {
+		c.SLO[i], err = Vars(c.SLO[i], params, false)
+		if err != nil {
+			return err
+		}
+	}
 	for _, r := range c.Report {
 		for k, v := range r {
 			r[k], err = Vars(v, params, false)
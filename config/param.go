@@ -0,0 +1,152 @@
+// Copyright 2024 Block, Inc.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ParamSpec is a param (config.stage.params) with an optional type, default
+// value, and help. A param can be only a value, like "rows: 1000", which is a
+// string param with that default value, or it can be a spec:
+//
+//	params:
+//	  rows:
+//	    type: int
+//	    default: 1M
+//	    help: "Number of rows to insert"
+//
+// The value is the default unless overridden by a stage file (if the param is
+// in _all.yaml) or --param. Typed values are validated after interpolation, so
+// human numbers like 1M are valid ints.
+type ParamSpec struct {
+	Type    string `yaml:"type,omitempty"` // string (default), int, float, bool, duration
+	Default string `yaml:"default,omitempty"`
+	Help    string `yaml:"help,omitempty"`
+}
+
+var paramTypes = []string{"string", "int", "float", "bool", "duration"}
+
+// UnmarshalYAML decodes a param value ("rows: 1000") or a spec.
+func (p *ParamSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		p.Default = s
+		return nil
+	}
+	type spec ParamSpec // without UnmarshalYAML
+	var v spec
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*p = ParamSpec(v)
+	return nil
+}
+
+// paramValues returns the default values of the param specs, or nil if none.
+func paramValues(specs map[string]ParamSpec) map[string]string {
+	if len(specs) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(specs))
+	for k, p := range specs {
+		params[k] = p.Default
+	}
+	return params
+}
+
+// validParams validates the param spec types and the param values of a typed
+// param after interpolation.
+func validParams(specs map[string]ParamSpec, params map[string]string) error {
+	for _, k := range ParamNames(specs) {
+		p := specs[k]
+		v := params[k]
+		var err error
+		switch p.Type {
+		case "", "string":
+		case "int":
+			_, err = strconv.ParseInt(v, 10, 64)
+		case "float":
+			_, err = strconv.ParseFloat(v, 64)
+		case "bool":
+			_, err = strconv.ParseBool(v)
+		case "duration":
+			_, err = time.ParseDuration(v)
+		default:
+			return fmt.Errorf("params.%s.type: invalid type: %s (valid: %s)", k, p.Type, strings.Join(paramTypes, ", "))
+		}
+		if err != nil {
+			return fmt.Errorf("params.%s: invalid %s value: %s", k, p.Type, v)
+		}
+	}
+	return nil
+}
+
+// ParamNames returns the param names sorted.
+func ParamNames(specs map[string]ParamSpec) []string {
+	names := make([]string, 0, len(specs))
+	for k := range specs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cmdlineParams returns the --param values in command line order, so later
+// values overwrite earlier ones. A --param is key=value, @file (a JSON or YAML
+// file of key: value), or an inline JSON map like {"rows": 1000}.
+func cmdlineParams(kvparams []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, kv := range kvparams {
+		switch {
+		case strings.HasPrefix(kv, "@"):
+			bytes, err := os.ReadFile(kv[1:])
+			if err != nil {
+				return nil, fmt.Errorf("--param %s: %s", kv, err)
+			}
+			if err := mapParams(bytes, params); err != nil {
+				return nil, fmt.Errorf("--param %s: %s", kv, err)
+			}
+		case strings.HasPrefix(strings.TrimSpace(kv), "{"):
+			if err := mapParams([]byte(kv), params); err != nil {
+				return nil, fmt.Errorf("--param %s: %s", kv, err)
+			}
+		default:
+			f := strings.SplitN(kv, "=", 2)
+			if len(f) != 2 {
+				log.Printf("Ignoring invalid --param %s: split into %d fields, expected 2\n", kv, len(f))
+				continue
+			}
+			params[f[0]] = f[1]
+		}
+	}
+	return params, nil
+}
+
+// mapParams decodes a JSON or YAML map of params (JSON is valid YAML) into params.
+// Values must be scalars: strings, numbers, or bools.
+func mapParams(bytes []byte, params map[string]string) error {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(bytes, &m); err != nil {
+		return fmt.Errorf("cannot decode JSON or YAML map: %s", err)
+	}
+	for k, v := range m {
+		switch v.(type) {
+		case nil:
+			params[k] = ""
+		case map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("param %s: value must be a string, number, or bool", k)
+		default:
+			params[k] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
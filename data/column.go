@@ -5,14 +5,22 @@ package data
 import (
 	"bytes"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
 
 	"github.com/square/finch"
 )
 
 // Column is a special Generator that is used to save (Scan) values from rows
-// or insert ID, then return those values (Value) to other statements.
+// or insert ID, then return those values (Value) to other statements. If param
+// pool is set, saved values are also added to that key pool for pool generators.
+// It's safe for concurrent use because a copy scoped greater than client (like
+// stage) is shared by clients.
 type Column struct {
+	mux        sync.Mutex
 	quoteValue bool
+	pool       *KeyPool
 	val        interface{}
 	bytes      *bytes.Buffer
 	useBytes   bool
@@ -21,10 +29,31 @@ type Column struct {
 var _ Generator = &Column{}
 var _ sql.Scanner = &Column{}
 
-func NewColumn(params map[string]string) *Column {
-	return &Column{
+func NewColumn(params map[string]string) (*Column, error) {
+	g := &Column{
 		quoteValue: finch.Bool(params["quote-value"]),
 	}
+	if params["pool-size"] != "" && params["pool"] == "" {
+		return nil, fmt.Errorf("invalid column: pool-size requires pool")
+	}
+	if name := params["pool"]; name != "" {
+		p, err := Pool(name, params["pool-file"])
+		if err != nil {
+			return nil, err
+		}
+		if s := params["pool-size"]; s != "" {
+			max, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || max < 1 {
+				return nil, fmt.Errorf("invalid column: pool-size=%s: must be an integer >= 1", s)
+			}
+			if err := p.SetMax(max); err != nil {
+				return nil, err
+			}
+		}
+		p.use(true)
+		g.pool = p
+	}
+	return g, nil
 }
 
 func (g *Column) Name() string { return "column" }
@@ -39,12 +68,15 @@ func (g *Column) Format() (uint, string) {
 func (g *Column) Copy() Generator {
 	return &Column{
 		quoteValue: g.quoteValue,
+		pool:       g.pool,
 	}
 }
 
 func (g *Column) Scan(any interface{}) error {
 	// @todo column type won't change, so maybe sync.Once to set val or bytes
 	// will make this more efficient?
+	g.mux.Lock()
+	defer g.mux.Unlock()
 	switch any.(type) {
 	case []byte:
 		g.useBytes = true // is reference; copy bytes
@@ -57,10 +89,15 @@ func (g *Column) Scan(any interface{}) error {
 		g.useBytes = false // not reference; copy value
 		g.val = any
 	}
+	if g.pool != nil {
+		g.pool.addTo(any)
+	}
 	return nil
 }
 
 func (g *Column) Values(_ RunCount) []interface{} {
+	g.mux.Lock()
+	defer g.mux.Unlock()
 	if g.useBytes {
 		return []interface{}{g.bytes.String()}
 	}
@@ -0,0 +1,147 @@
+// Copyright 2024 Block, Inc.
+
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Derived implements the derived data generator: a value derived from the
+// current value of another data key (param from), so correlated columns like
+// (amount, fee) or (created_at, updated_at) are generated coherently:
+//
+//	@fee = @amount * mul + random integer in [add-min, add-max]
+//
+// The other data key is bound by Scope.Copy to the copy in the same scope as
+// the derived copy, or the same client view in multi client scopes, so both
+// values are from the same client and run count.
+type Derived struct {
+	from   string
+	mul    float64
+	addMin int64
+	addMax int64
+	src    *ScopedGenerator // from data key, set by bind
+	prng
+}
+
+var _ Generator = &Derived{}
+
+// deriver is implemented by generators that derive values from another data key.
+// Scope.Copy calls bind with the scoped copy of From. Nullable implements it to
+// bind the real generator, if it's a deriver.
+type deriver interface {
+	From() string
+	bind(*ScopedGenerator)
+}
+
+func NewDerived(params map[string]string) (*Derived, error) {
+	g := &Derived{
+		from: params["from"],
+		mul:  1,
+		prng: newPRNG(),
+	}
+	if g.from == "" {
+		return nil, fmt.Errorf("from required")
+	}
+	if !strings.HasPrefix(g.from, "@") {
+		g.from = "@" + g.from
+	}
+	if s, ok := params["mul"]; ok {
+		var err error
+		g.mul, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mul=%s: %s", s, err)
+		}
+	}
+	if err := int64From(params, "add-min", &g.addMin, false); err != nil {
+		return nil, err
+	}
+	g.addMax = g.addMin
+	if err := int64From(params, "add-max", &g.addMax, false); err != nil {
+		return nil, err
+	}
+	if g.addMin > g.addMax {
+		return nil, fmt.Errorf("invalid derived: add-min (%d) > add-max (%d)", g.addMin, g.addMax)
+	}
+	return g, nil
+}
+
+func (g *Derived) Name() string               { return "derived" }
+func (g *Derived) Format() (uint, string)     { return 1, "%v" }
+func (g *Derived) Scan(any interface{}) error { return nil }
+
+// From returns the data key (@d) from which values are derived.
+func (g *Derived) From() string { return g.from }
+
+func (g *Derived) bind(src *ScopedGenerator) { g.src = src }
+
+// Copy returns a copy bound to the same data key copy, if any. That's not correct
+// for another client or scope, so Scope.Copy rebinds every copy and client view
+// (see ScopedGenerator.forClient) to the from key copy for the same client.
+func (g *Derived) Copy() Generator {
+	c := *g
+	c.prng = g.prng.copy()
+	return &c
+}
+
+// Values returns an int64 if the from value is an integer and mul is 1, else
+// a float64. Values that aren't numbers (or NULL) are returned as is.
+func (g *Derived) Values(rc RunCount) []interface{} {
+	var add int64
+	if g.addMax > g.addMin {
+		add = g.addMin + g.r.Int63n(g.addMax-g.addMin+1)
+	} else {
+		add = g.addMin
+	}
+	v := g.src.Values(rc)[0]
+	if nv, ok := v.(Value); ok {
+		if nv.v == nil {
+			return []interface{}{nv} // NULL from Nullable
+		}
+		v = nv.v
+	}
+	var i int64
+	var f float64
+	isInt := true
+	switch n := v.(type) {
+	case int64:
+		i = n
+	case uint64:
+		i = int64(n)
+	case int:
+		i = int64(n)
+	case uint:
+		i = int64(n)
+	case float64:
+		f, isInt = n, false
+	case []byte:
+		return g.parse(string(n), add, v)
+	case string:
+		return g.parse(n, add, v)
+	default:
+		return []interface{}{v}
+	}
+	if isInt && g.mul == 1 {
+		return []interface{}{i + add}
+	}
+	if isInt {
+		f = float64(i)
+	}
+	return []interface{}{f*g.mul + float64(add)}
+}
+
+// parse derives a value from a string number, like a column value.
+func (g *Derived) parse(s string, add int64, v interface{}) []interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if g.mul == 1 {
+			return []interface{}{i + add}
+		}
+		return []interface{}{float64(i)*g.mul + float64(add)}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return []interface{}{f*g.mul + float64(add)}
+	}
+	return []interface{}{v}
+}
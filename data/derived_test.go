@@ -0,0 +1,95 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch"
+	"github.com/square/finch/data"
+)
+
+func TestDerived(t *testing.T) {
+	a, _ := data.NewAutoInc(map[string]string{"start": "100"})
+	b, err := data.Make("derived", "@b", map[string]string{"from": "a", "add-min": "1", "add-max": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := data.Make("derived", "@c", map[string]string{"from": "@a", "mul": "0.5"})
+
+	scope := data.NewScope()
+	for name, g := range map[string]data.Generator{"@a": a, "@b": b, "@c": c} {
+		scope.Keys[name] = data.Key{
+			Name:      name,
+			Scope:     finch.SCOPE_STATEMENT,
+			Column:    -1,
+			Generator: g,
+		}
+	}
+
+	r := finch.RunLevel{Stage: 1, ExecGroup: 1, ClientGroup: 1, Client: 1, Trx: 1, Query: 1}
+	gb := scope.Copy("@b", r) // before @a to test that @a is copied and bound
+	ga := scope.Copy("@a", r)
+	gc := scope.Copy("@c", r)
+
+	rc := data.RunCount{}
+	for i := 1; i <= 3; i++ {
+		rc[data.STATEMENT] = uint(i)
+		vb := gb.Values(rc)[0].(int64) // derived first
+		va := ga.Values(rc)[0].(uint64)
+		vc := gc.Values(rc)[0].(float64)
+		if va != uint64(100+i) {
+			t.Errorf("@a = %d, expected %d", va, 100+i)
+		}
+		if vb < int64(va)+1 || vb > int64(va)+5 {
+			t.Errorf("@b = %d, expected @a (%d) + [1, 5]", vb, va)
+		}
+		if diff := deep.Equal(vc, float64(va)/2); diff != nil {
+			t.Errorf("@c: %v", diff)
+		}
+	}
+
+	if _, err := data.Make("derived", "@b", map[string]string{"from": "a", "add-min": "5", "add-max": "1"}); err == nil {
+		t.Error("no error for add-min > add-max, expected an error")
+	}
+}
+
+func TestDerived_MultiClient(t *testing.T) {
+	// In a multi client scope (client-group), each client has its own view of
+	// @a and @b, and @b must be derived from the same client's @a
+	a, _ := data.Make("int", "@a", map[string]string{"max": "1000000000"})
+	b, _ := data.Make("derived", "@b", map[string]string{"from": "@a"})
+	scope := data.NewScope()
+	for name, g := range map[string]data.Generator{"@a": a, "@b": b} {
+		scope.Keys[name] = data.Key{
+			Name:      name,
+			Scope:     finch.SCOPE_CLIENT_GROUP,
+			Column:    -1,
+			Generator: g,
+		}
+	}
+
+	r := finch.RunLevel{Stage: 1, ExecGroup: 1, ClientGroup: 1, Client: 1, Trx: 1, Query: 1}
+	ga1 := scope.Copy("@a", r)
+	gb1 := scope.Copy("@b", r)
+	r.Client = 2
+	ga2 := scope.Copy("@a", r)
+	gb2 := scope.Copy("@b", r)
+
+	rc1 := data.RunCount{}
+	rc2 := data.RunCount{}
+	for i := uint(1); i <= 3; i++ {
+		rc1[data.ITER] = i
+		rc2[data.ITER] = i
+		va1 := ga1.Values(rc1)[0].(int64)
+		va2 := ga2.Values(rc2)[0].(int64)
+		if vb := gb1.Values(rc1)[0].(int64); vb != va1 {
+			t.Errorf("iter %d: client 1 @b = %d, expected client 1 @a = %d (client 2 @a = %d)", i, vb, va1, va2)
+		}
+		if vb := gb2.Values(rc2)[0].(int64); vb != va2 {
+			t.Errorf("iter %d: client 2 @b = %d, expected client 2 @a = %d (client 1 @a = %d)", i, vb, va2, va1)
+		}
+	}
+}
@@ -4,8 +4,13 @@ package data
 
 import (
 	"fmt"
+	"hash/fnv"
+	"log"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/square/finch"
@@ -13,6 +18,31 @@ import (
 
 type ValueFunc func(RunCount) []interface{}
 
+// Seed is the --seed value, if Seeded. Random data generators are seeded from it
+// (or their seed param) so two runs generate the same values: see prng.
+var (
+	Seed   int64
+	Seeded bool
+)
+
+// UnknownParams is config.stage.data.unknown-params: what Make does when a
+// built-in generator has unknown params: return an error ("error" or ""), log a
+// warning ("warn"), or nothing ("ignore", for lint, which reports them itself).
+// It's set for each stage by Stage.Prepare before the trx files are loaded.
+var UnknownParams = "error"
+
+// ValueError is the panic value of a generator that cannot generate a value,
+// like auto-inc when a key block lease fails. Generator.Values doesn't return
+// an error, so the generator panics instead, and Client.Run recovers it and
+// stops the client with Err.
+type ValueError struct {
+	Err error
+}
+
+func (e ValueError) Error() string {
+	return e.Err.Error()
+}
+
 // Generator generates data values for a data key (@d).
 type Generator interface {
 	Format() (uint, string)
@@ -23,7 +53,6 @@ type Generator interface {
 }
 
 func init() {
-	rand.Seed(time.Now().UnixNano())
 	/*
 		Generator names here must match factory.Make switch cases below
 	*/
@@ -32,6 +61,8 @@ func init() {
 	Register("int-gaps", f)
 	Register("int-range", f)
 	Register("int-range-seq", f)
+	Register("int-hot", f)
+	Register("int-partition", f)
 	Register("auto-inc", f)
 	// String
 	Register("str-fill-az", f)
@@ -40,6 +71,30 @@ func init() {
 	Register("client-id", f)
 	// Column
 	Register("column", f)
+	Register("pool", f)
+	Register("derived", f)
+	// MySQL
+	Register("seq-table", f)
+}
+
+// Params are the valid params for each built-in generator. Make returns an error
+// for unknown params (see WarnUnknownParams), and it's used to lint stage files
+// (see lint.Files). Generators registered by other factories are not checked.
+var Params = map[string][]string{
+	"int":           {"min", "max", "dist", "mean", "stddev", "h", "lambda", "seed"},
+	"int-gaps":      {"min", "max", "p", "seed"},
+	"int-range":     {"min", "max", "size", "seed"},
+	"int-range-seq": {"begin", "end", "size"},
+	"int-hot":       {"min", "max", "window", "step", "interval", "p", "seed"},
+	"int-partition": {"min", "max", "clients", "client-groups", "order", "seed"},
+	"auto-inc":      {"start", "step", "block"},
+	"str-fill-az":   {"len", "seed"},
+	"xid":           {},
+	"client-id":     {"ids"},
+	"column":        {"quote-value", "pool", "pool-file", "pool-size"},
+	"pool":          {"name", "file", "order", "seed"},
+	"derived":       {"from", "mul", "add-min", "add-max", "seed"},
+	"seq-table":     {"table", "column", "where", "block", "lock"},
 }
 
 // Factory makes data generators from day keys (@d).
@@ -65,6 +120,10 @@ func (f factory) Make(name, dataKey string, params map[string]string) (Generator
 		g, err = NewIntRange(params)
 	case "int-range-seq":
 		g, err = NewIntRangeSeq(params)
+	case "int-hot":
+		g, err = NewIntHot(params)
+	case "int-partition":
+		g, err = NewIntPartition(params)
 	case "auto-inc":
 		g, err = NewAutoInc(params)
 	// String
@@ -77,13 +136,37 @@ func (f factory) Make(name, dataKey string, params map[string]string) (Generator
 		g, err = NewClientId(params)
 	// Column
 	case "column":
-		g = NewColumn(params)
+		g, err = NewColumn(params)
+	case "pool":
+		g, err = NewPoolKey(params)
+	case "derived":
+		g, err = NewDerived(params)
+	// MySQL
+	case "seq-table":
+		g, err = NewSeqTable(params)
 	default:
 		err = fmt.Errorf("built-in data factory cannot make %s data generator", name)
 	}
 	if err != nil {
 		return nil, err
 	}
+
+	// Blocks are leased by data key, which is the same on all compute instances
+	if ai, ok := g.(*AutoInc); ok {
+		ai.key = dataKey
+	}
+
+	// Seed random generators if --seed or seed param
+	if s, ok := g.(seeder); ok {
+		seed, seeded, err := seedFor(dataKey, params)
+		if err != nil {
+			return nil, err
+		}
+		if seeded {
+			finch.Debug("%s %s seed %d", name, dataKey, seed)
+			s.setSeed(seed)
+		}
+	}
 	return g, nil
 }
 
@@ -110,7 +193,70 @@ func Make(name, dataKey string, params map[string]string) (Generator, error) {
 	if !have {
 		return nil, fmt.Errorf("data.Generator %s not registered", name)
 	}
-	return f.Make(name, dataKey, params)
+	if err := checkParams(name, dataKey, params); err != nil {
+		switch UnknownParams {
+		case "warn":
+			log.Printf("WARNING: %s", err)
+		case "ignore":
+		default:
+			return nil, err
+		}
+	}
+	null, params, err := nullParam(params)
+	if err != nil {
+		return nil, err
+	}
+	g, err := f.Make(name, dataKey, params)
+	if err != nil || null == 0 {
+		return g, err
+	}
+	seed, _, err := seedFor(dataKey+"/null", params)
+	if err != nil {
+		return nil, err
+	}
+	return NewNullable(g, null, seed), nil
+}
+
+// checkParams returns an error if params has a param that isn't valid for the
+// built-in generator (Params) or all generators (CommonParams), like "maximum"
+// instead of "max". The error lists all unknown params and the valid params.
+func checkParams(name, dataKey string, params map[string]string) error {
+	valid, ok := Params[name]
+	if !ok {
+		return nil // not built-in
+	}
+	unknown := []string{}
+	for p := range params {
+		if !contains(valid, p) && !CommonParam(p, params) {
+			unknown = append(unknown, p)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	all := append(append([]string{}, valid...), CommonParams...)
+	return fmt.Errorf("data key %s: unknown %s generator params: %s (valid: %s)",
+		dataKey, name, strings.Join(unknown, ", "), strings.Join(all, ", "))
+}
+
+// CommonParam returns true if p is valid for all generators given all params:
+// one of CommonParams, or seed with null because it seeds the NULL values.
+func CommonParam(p string, params map[string]string) bool {
+	if p == "seed" {
+		_, null := params["null"]
+		return null
+	}
+	return contains(CommonParams, p)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func int64From(params map[string]string, key string, n *int64, required bool) error {
@@ -128,3 +274,61 @@ func int64From(params map[string]string, key string, n *int64, required bool) er
 	*n = i
 	return nil
 }
+
+// seedFor returns the seed for a random data generator: the seed param, if set,
+// else --seed mixed with the data key so that each @d has a different stream of
+// values. It returns false if neither is set.
+func seedFor(dataKey string, params map[string]string) (int64, bool, error) {
+	if _, ok := params["seed"]; ok {
+		var seed int64
+		err := int64From(params, "seed", &seed, true)
+		return seed, err == nil, err
+	}
+	if !Seeded {
+		return 0, false, nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(dataKey))
+	return Seed ^ int64(h.Sum64()), true, nil
+}
+
+// seeder is implemented by random data generators that embed prng.
+type seeder interface {
+	setSeed(int64)
+}
+
+// prng is the pseudo-random number generator embedded in random data generators.
+// Each copy of the generator has its own PRNG (not the global math/rand, which
+// has a lock that every client would contend on) seeded with the base seed plus
+// the copy number. The base seed is random unless set by --seed or the seed param.
+// Since copies are made in the same order every run (workload.Allocator.Clients),
+// with a set seed each client gets the same values every run.
+type prng struct {
+	seed   int64
+	copyNo *int64     // shared by all copies of the same original generator
+	r      *rand.Rand `deep:"-"`
+}
+
+// newPRNG returns a randomly seeded prng. Make reseeds it if --seed or the seed
+// param is set.
+func newPRNG() prng {
+	p := prng{}
+	p.setSeed(time.Now().UnixNano())
+	return p
+}
+
+func (p *prng) setSeed(seed int64) {
+	p.seed = seed
+	p.copyNo = new(int64)
+	p.r = rand.New(rand.NewSource(seed))
+}
+
+// copy returns the PRNG for the next copy of the generator.
+func (p prng) copy() prng {
+	n := atomic.AddInt64(p.copyNo, 1)
+	return prng{
+		seed:   p.seed,
+		copyNo: p.copyNo,
+		r:      rand.New(rand.NewSource(p.seed + n)),
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"testing"
+
+	"github.com/square/finch/data"
+)
+
+func TestMake_UnknownParams(t *testing.T) {
+	// Misspelled param is an error that reports the data key and param
+	_, err := data.Make("int", "@id", map[string]string{"min": "1", "maximum": "10", "sed": "1"})
+	if err == nil {
+		t.Fatal("no error for unknown params, expected one")
+	}
+	expect := "data key @id: unknown int generator params: maximum, sed (valid: min, max, dist, mean, stddev, h, lambda, seed, null)"
+	if err.Error() != expect {
+		t.Errorf("got error '%s', expected '%s'", err, expect)
+	}
+
+	// Common params are valid for all generators, and seed is valid with null
+	if _, err := data.Make("auto-inc", "@id", map[string]string{"null": "0.1", "seed": "1"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := data.Make("auto-inc", "@id", map[string]string{"seed": "1"}); err == nil {
+		t.Error("no error for auto-inc seed without null, expected one")
+	}
+
+	// data.unknown-params: warn
+	prev := data.UnknownParams
+	data.UnknownParams = "warn"
+	defer func() { data.UnknownParams = prev }()
+	if _, err := data.Make("int", "@id", map[string]string{"maximum": "10"}); err != nil {
+		t.Errorf("got error with unknown-params=warn, expected only a warning: %s", err)
+	}
+}
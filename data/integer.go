@@ -5,11 +5,11 @@ package data
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/square/finch"
 )
@@ -18,9 +18,12 @@ import (
 type Int struct {
 	min    int64
 	max    int64
-	dist   byte    // normal|uniform
+	dist   byte    // normal|uniform|pareto|exponential
 	mean   float64 // dist=normal
 	stddev float64 // dist=normal
+	power  float64 // dist=pareto: log(h)/log(1-h)
+	lambda float64 // dist=exponential
+	prng
 }
 
 var _ Generator = &Int{}
@@ -28,6 +31,8 @@ var _ Generator = &Int{}
 const (
 	dist_uniform byte = iota
 	dist_normal
+	dist_pareto
+	dist_exponential
 )
 
 func NewInt(params map[string]string) (*Int, error) {
@@ -35,6 +40,7 @@ func NewInt(params map[string]string) (*Int, error) {
 		min:  1,
 		max:  finch.ROWS,
 		dist: dist_uniform,
+		prng: newPRNG(),
 	}
 
 	if err := int64From(params, "min", &g.min, false); err != nil {
@@ -66,12 +72,40 @@ func NewInt(params map[string]string) (*Int, error) {
 		} else {
 			g.stddev = (float64(g.max) - float64(g.min)) / 8.0
 		}
+	case "pareto":
+		// Same as sysbench --rand-type=pareto --rand-pareto-h
+		g.dist = dist_pareto
+		h := 0.2
+		if s, ok := params["h"]; ok {
+			var err error
+			h, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid h=%s: %s", s, err)
+			}
+			if h <= 0 || h >= 1 {
+				return nil, fmt.Errorf("invalid h=%s: must be > 0 and < 1", s)
+			}
+		}
+		g.power = math.Log(h) / math.Log(1-h)
+	case "exponential":
+		g.dist = dist_exponential
+		g.lambda = 5.0
+		if s, ok := params["lambda"]; ok {
+			var err error
+			g.lambda, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid lambda=%s: %s", s, err)
+			}
+			if g.lambda <= 0 {
+				return nil, fmt.Errorf("invalid lambda=%s: must be > 0", s)
+			}
+		}
 	case "uniform":
 		g.dist = dist_uniform
 	default:
 		g.dist = dist_uniform
 	}
-	finch.Debug("rand int [%d, %d] dist %d (uni %d, norm %d)", g.min, g.max, g.dist, dist_uniform, dist_normal)
+	finch.Debug("rand int [%d, %d] dist %d (uni %d, norm %d, pareto %d, exp %d)", g.min, g.max, g.dist, dist_uniform, dist_normal, dist_pareto, dist_exponential)
 	return g, nil
 }
 
@@ -81,22 +115,39 @@ func (g *Int) Scan(any interface{}) error { return nil }
 
 func (g *Int) Copy() Generator {
 	c := *g
+	c.prng = g.prng.copy()
 	return &c
 }
 
 func (g *Int) Values(_ RunCount) []interface{} {
 	switch g.dist {
 	case dist_normal:
-		v := int64(math.Floor(rand.NormFloat64()*g.stddev + g.mean))
+		v := int64(math.Floor(g.r.NormFloat64()*g.stddev + g.mean))
 		if v < g.min || v > g.max {
-			v = int64(math.Floor(rand.NormFloat64()*g.stddev + g.mean))
+			v = int64(math.Floor(g.r.NormFloat64()*g.stddev + g.mean))
 			if v < g.min || v > g.max {
 				return []interface{}{int64(g.mean)}
 			}
 		}
 		return []interface{}{v}
+	case dist_pareto:
+		// Skewed toward min: 1-h of values are in the first h of the range (80/20)
+		v := g.min + int64(float64(g.max-g.min+1)*math.Pow(g.r.Float64(), g.power))
+		if v > g.max {
+			v = g.max
+		}
+		return []interface{}{v}
+	case dist_exponential:
+		// Inverse CDF of exponential distribution truncated to [0, 1), scaled to
+		// [min, max]: skewed toward min, more so as lambda increases
+		x := -math.Log(1-g.r.Float64()*(1-math.Exp(-g.lambda))) / g.lambda
+		v := g.min + int64(float64(g.max-g.min+1)*x)
+		if v > g.max {
+			v = g.max
+		}
+		return []interface{}{v}
 	default: // uniform
-		v := rand.Int63n(g.max)
+		v := g.r.Int63n(g.max)
 		if v < g.min {
 			v = g.min
 		}
@@ -112,6 +163,7 @@ type IntGaps struct {
 	input_max    int64
 	output_start float64
 	slope        float64
+	prng
 }
 
 var _ Generator = &IntGaps{}
@@ -145,6 +197,7 @@ func NewIntGaps(params map[string]string) (*IntGaps, error) {
 		input_max:    input_max,
 		output_start: float64(min),
 		slope:        float64(max-min) / float64(input_max-1),
+		prng:         newPRNG(),
 	}
 	finch.Debug("1..%d -> %d..%d (%d%% of %d) gap: %d records", input_max, min, max, p, size, int(g.slope))
 	return g, nil
@@ -156,11 +209,118 @@ func (g *IntGaps) Scan(any interface{}) error { return nil }
 
 func (g *IntGaps) Copy() Generator {
 	c, _ := NewIntGaps(g.params)
+	c.prng = g.prng.copy()
 	return c
 }
 
 func (g *IntGaps) Values(_ RunCount) []interface{} {
-	return []interface{}{int64(g.output_start + float64(rand.Int63n(g.input_max))*g.slope)}
+	return []interface{}{int64(g.output_start + float64(g.r.Int63n(g.input_max))*g.slope)}
+}
+
+// --------------------------------------------------------------------------
+
+// IntHot implements the int-hot data generator: random values from a hot window
+// of keys that advances over time, modeling time-correlated access like recent
+// rows being hot. The window starts at min and advances by step keys every
+// interval, wrapping around to min after max. p percent of values are from the
+// window; the rest are from the whole range [min, max].
+type IntHot struct {
+	min      int64
+	max      int64
+	window   int64
+	step     int64
+	interval time.Duration
+	p        int64
+	start    *int64 // UnixNano of first value, shared by all copies (atomic)
+	prng
+}
+
+var _ Generator = &IntHot{}
+
+func NewIntHot(params map[string]string) (*IntHot, error) {
+	g := &IntHot{
+		min:      1,
+		max:      finch.ROWS,
+		window:   1000,
+		interval: time.Second,
+		p:        100,
+		start:    new(int64),
+		prng:     newPRNG(),
+	}
+	if err := int64From(params, "min", &g.min, false); err != nil {
+		return nil, err
+	}
+	if err := int64From(params, "max", &g.max, false); err != nil {
+		return nil, err
+	}
+	if err := int64From(params, "window", &g.window, false); err != nil {
+		return nil, err
+	}
+	g.step = g.window
+	if err := int64From(params, "step", &g.step, false); err != nil {
+		return nil, err
+	}
+	if err := int64From(params, "p", &g.p, false); err != nil {
+		return nil, err
+	}
+	if s, ok := params["interval"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval=%s: %s", s, err)
+		}
+		g.interval = d
+	}
+	switch {
+	case g.min > g.max:
+		return nil, fmt.Errorf("invalid int-hot: min (%d) > max (%d)", g.min, g.max)
+	case g.window < 1 || g.window > g.max-g.min+1:
+		return nil, fmt.Errorf("invalid int-hot: window (%d) must be >= 1 and <= max - min + 1 (%d)", g.window, g.max-g.min+1)
+	case g.step < 0:
+		return nil, fmt.Errorf("invalid int-hot: step (%d) must be >= 0", g.step)
+	case g.interval <= 0:
+		return nil, fmt.Errorf("invalid int-hot: interval (%s) must be > 0", g.interval)
+	case g.p < 1 || g.p > 100:
+		return nil, fmt.Errorf("invalid int-hot p: %d, must be between 1 to 100 (inclusive)", g.p)
+	}
+	finch.Debug("int-hot [%d, %d] window %d step %d every %s (p %d%%)", g.min, g.max, g.window, g.step, g.interval, g.p)
+	return g, nil
+}
+
+func (g *IntHot) Name() string               { return "int-hot" }
+func (g *IntHot) Format() (uint, string)     { return 1, "%d" }
+func (g *IntHot) Scan(any interface{}) error { return nil }
+
+func (g *IntHot) Copy() Generator {
+	c := *g
+	c.prng = g.prng.copy()
+	return &c
+}
+
+// Window returns the first key of the hot window after elapsed time. The window
+// is [first, first+window-1], wrapping around to min after max.
+func (g *IntHot) Window(elapsed time.Duration) int64 {
+	n := g.max - g.min + 1
+	advanced := (int64(elapsed/g.interval) * g.step) % n
+	return g.min + advanced
+}
+
+func (g *IntHot) Values(_ RunCount) []interface{} {
+	now := time.Now().UnixNano()
+	atomic.CompareAndSwapInt64(g.start, 0, now) // first value starts the clock
+	elapsed := now - atomic.LoadInt64(g.start)
+	if elapsed < 0 {
+		elapsed = 0 // another copy started the clock after now
+	}
+	n := g.max - g.min + 1
+	if g.p < 100 && g.r.Int63n(100) >= g.p {
+		return []interface{}{g.min + g.r.Int63n(n)} // cold: whole range
+	}
+	first := g.Window(time.Duration(elapsed))
+	v := first + g.r.Int63n(g.window)
+	if v > g.max {
+		v -= n // wrap around
+	}
+	return []interface{}{v}
 }
 
 // --------------------------------------------------------------------------
@@ -172,6 +332,7 @@ type IntRange struct {
 	min    int64
 	max    int64
 	v      []int64
+	prng
 }
 
 var _ Generator = &IntRange{}
@@ -183,6 +344,7 @@ func NewIntRange(params map[string]string) (*IntRange, error) {
 		size:   100,
 		v:      []int64{0, 0},
 		params: params,
+		prng:   newPRNG(),
 	}
 	if err := int64From(params, "size", &g.size, false); err != nil {
 		return nil, err
@@ -208,6 +370,7 @@ func (g *IntRange) Scan(any interface{}) error { return nil }
 
 func (g *IntRange) Copy() Generator {
 	gCopy, _ := NewIntRange(g.params)
+	gCopy.prng = g.prng.copy()
 	return gCopy
 }
 
@@ -215,7 +378,7 @@ func (g *IntRange) Values(_ RunCount) []interface{} {
 	// MySQL BETWEEN is closed interval [min, max], so if random min (lower)
 	// is 10 and size is 3, then 10+3=13 but that's 4 values: 10, 11, 12, 13.
 	// So we -1 to make BETWEEEN 10 AND 12, which is 3 values.
-	lower := g.min + rand.Int63n(g.max-g.min)
+	lower := g.min + g.r.Int63n(g.max-g.min)
 	upper := lower + g.size - 1
 	if upper > g.max {
 		upper = g.max
@@ -271,9 +434,22 @@ func (g *IntRangeSeq) Scan(any interface{}) error { return nil }
 
 func (g *IntRangeSeq) Copy() Generator {
 	c, _ := NewIntRangeSeq(g.params)
+	c.n = g.n // restored position (LoadState), else begin
 	return c
 }
 
+func (g *IntRangeSeq) position() int64 {
+	g.Lock()
+	defer g.Unlock()
+	return g.n
+}
+
+func (g *IntRangeSeq) setPosition(n int64) {
+	g.Lock()
+	g.n = n
+	g.Unlock()
+}
+
 func (g *IntRangeSeq) Values(_ RunCount) []interface{} {
 	g.Lock()
 	if g.n > g.end {
@@ -290,10 +466,167 @@ func (g *IntRangeSeq) Values(_ RunCount) []interface{} {
 
 // --------------------------------------------------------------------------
 
-// AutoInc implements the auto-inc data generator.
+// IntPartition implements the int-partition data generator. [min, max] is split
+// into one disjoint sub-range (partition) per client, and each client generates
+// values only in its partition, so clients never generate the same values.
+// The workload allocator sets the partitions (SetPartitions): one per client
+// that uses the data key, in all exec groups. Else, the partition is determined
+// by the client and client group number in the RunCount: (CLIENT_GROUP-1)*clients
+// + (CLIENT-1).
+type IntPartition struct {
+	min     int64
+	max     int64
+	clients int64             // per client group (param), or zero
+	n       int64             // partitions: clients * client-groups, or clients using the key
+	size    int64             // values per partition, except last has remainder
+	seq     bool              // order=seq, else random
+	pos     []int64           // order=seq: next value in each partition (atomic)
+	first   map[[2]uint]int64 // SetPartitions: first partition of exec group, client group
+	prng
+}
+
+var _ Generator = &IntPartition{}
+
+func NewIntPartition(params map[string]string) (*IntPartition, error) {
+	g := &IntPartition{
+		min:  1,
+		max:  finch.ROWS,
+		prng: newPRNG(),
+	}
+	if err := int64From(params, "min", &g.min, false); err != nil {
+		return nil, err
+	}
+	if err := int64From(params, "max", &g.max, false); err != nil {
+		return nil, err
+	}
+	var groups int64 = 1
+	if err := int64From(params, "clients", &g.clients, false); err != nil {
+		return nil, err
+	}
+	if err := int64From(params, "client-groups", &groups, false); err != nil {
+		return nil, err
+	}
+	if _, ok := params["clients"]; ok && g.clients < 1 {
+		return nil, fmt.Errorf("invalid int-partition: clients (%d) must be >= 1", g.clients)
+	}
+	if groups < 1 {
+		return nil, fmt.Errorf("invalid int-partition: client-groups (%d) must be >= 1", groups)
+	}
+	if g.min > g.max {
+		return nil, fmt.Errorf("invalid int-partition: min (%d) > max (%d)", g.min, g.max)
+	}
+	switch strings.ToLower(params["order"]) {
+	case "", "random":
+	case "seq":
+		g.seq = true
+	default:
+		return nil, fmt.Errorf("invalid int-partition: order=%s: valid values: random, seq", params["order"])
+	}
+	n := g.clients * groups
+	if n == 0 {
+		n = 1 // until SetPartitions
+	}
+	if err := g.partition(n); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// partition splits [min, max] into n partitions.
+func (g *IntPartition) partition(n int64) error {
+	size := (g.max - g.min + 1) / n
+	if size < 1 {
+		return fmt.Errorf("invalid int-partition: %d partitions > max (%d) - min (%d) + 1", n, g.max, g.min)
+	}
+	g.n = n
+	g.size = size
+	if g.seq {
+		g.pos = make([]int64, g.n)
+	}
+	finch.Debug("int-partition [%d, %d] %d partitions of %d", g.min, g.max, g.n, g.size)
+	return nil
+}
+
+// SetPartitions sets one partition per client that uses the generator. first
+// is the first partition of each client group, keyed on exec group and client
+// group number (both from 1), and n is the total number of clients. The workload
+// allocator calls it before copying the generator for clients, so partitions are
+// unique across exec groups, which number client groups from 1. If the clients
+// param is set, it must be enough partitions for n clients.
+func (g *IntPartition) SetPartitions(first map[[2]uint]int64, n int64) error {
+	if g.clients > 0 {
+		if g.n < n {
+			return fmt.Errorf("int-partition: clients * client-groups (%d) < %d clients that use the data key; remove the clients param or increase it", g.n, n)
+		}
+		n = g.n // more partitions than clients is ok
+	}
+	if n < 1 {
+		return nil // not used
+	}
+	if err := g.partition(n); err != nil {
+		return err
+	}
+	g.first = first
+	return nil
+}
+
+func (g *IntPartition) Name() string               { return "int-partition" }
+func (g *IntPartition) Format() (uint, string)     { return 1, "%d" }
+func (g *IntPartition) Scan(any interface{}) error { return nil }
+
+func (g *IntPartition) Copy() Generator {
+	c := *g
+	c.prng = g.prng.copy()
+	if g.seq {
+		c.pos = make([]int64, g.n)
+	}
+	return &c
+}
+
+// Partition returns the partition number of the client in the RunCount and the
+// partition range [lower, upper]. Without SetPartitions, clients beyond the
+// number of partitions wrap around: partition p mod n.
+func (g *IntPartition) Partition(rc RunCount) (p, lower, upper int64) {
+	if rc[CLIENT] > 0 {
+		p = int64(rc[CLIENT]) - 1
+	}
+	if g.first != nil {
+		p += g.first[[2]uint{rc[EXEC_GROUP], rc[CLIENT_GROUP]}]
+	} else if rc[CLIENT_GROUP] > 1 {
+		p += (int64(rc[CLIENT_GROUP]) - 1) * g.clients
+	}
+	p %= g.n
+	lower = g.min + p*g.size
+	upper = lower + g.size - 1
+	if p == g.n-1 {
+		upper = g.max // last partition has the remainder
+	}
+	return p, lower, upper
+}
+
+func (g *IntPartition) Values(rc RunCount) []interface{} {
+	p, lower, upper := g.Partition(rc)
+	if g.seq {
+		i := atomic.AddInt64(&g.pos[p], 1) - 1
+		return []interface{}{lower + i%(upper-lower+1)}
+	}
+	return []interface{}{lower + g.r.Int63n(upper-lower+1)}
+}
+
+// --------------------------------------------------------------------------
+
+// AutoInc implements the auto-inc data generator. If param block is set, values
+// are generated in blocks of that many values leased from a BlockFunc, so all
+// copies of the generator on all compute instances generate unique values.
 type AutoInc struct {
 	i    uint64
 	step uint64
+	// With block:
+	start uint64
+	block uint64      // values per block
+	key   string      // lease key: data key (@d)
+	n     uint64      // values left in current block
+	mu    *sync.Mutex // guards i and n
 }
 
 var _ Generator = &AutoInc{}
@@ -310,6 +643,7 @@ func NewAutoInc(params map[string]string) (*AutoInc, error) {
 			return nil, fmt.Errorf("invalid start=%s: %s", s, err)
 		}
 		g.i = i
+		g.start = i
 	}
 	s, ok = params["step"]
 	if ok {
@@ -319,6 +653,15 @@ func NewAutoInc(params map[string]string) (*AutoInc, error) {
 		}
 		g.step = i
 	}
+	s, ok = params["block"]
+	if ok {
+		i, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block=%s: %s", s, err)
+		}
+		g.block = i
+		g.mu = &sync.Mutex{}
+	}
 	return g, nil
 }
 
@@ -327,12 +670,82 @@ func (g *AutoInc) Format() (uint, string)     { return 1, "%d" }
 func (g *AutoInc) Scan(any interface{}) error { return nil }
 
 func (g *AutoInc) Copy() Generator {
-	return &AutoInc{
-		i:    g.i,
-		step: g.step,
+	c := &AutoInc{
+		i:     uint64(g.position()),
+		step:  g.step,
+		start: g.start,
+		block: g.block,
+		key:   g.key,
+	}
+	if g.block > 0 {
+		c.mu = &sync.Mutex{} // lease its own blocks
+	}
+	return c
+}
+
+func (g *AutoInc) position() int64 {
+	if g.mu != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
 	}
+	return int64(atomic.LoadUint64(&g.i))
+}
+
+// setPosition sets the last value generated. With blocks, it's also the start
+// of block 0 so blocks leased after restoring state don't reuse values.
+func (g *AutoInc) setPosition(i int64) {
+	if g.mu != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.start = uint64(i)
+	}
+	atomic.StoreUint64(&g.i, uint64(i))
 }
 
 func (g *AutoInc) Values(_ RunCount) []interface{} {
-	return []interface{}{atomic.AddUint64(&g.i, g.step)}
+	if g.block == 0 {
+		return []interface{}{atomic.AddUint64(&g.i, g.step)}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.n == 0 {
+		b, err := leaseBlock(g.key)
+		if err != nil {
+			// Stop the client: values in another block might collide with values
+			// generated by another instance
+			panic(ValueError{fmt.Errorf("auto-inc %s: block lease error: %s", g.key, err)})
+		}
+		g.i = g.start + b*g.block*g.step
+		g.n = g.block
+	}
+	g.n--
+	g.i += g.step
+	return []interface{}{g.i}
+}
+
+// --------------------------------------------------------------------------
+
+var (
+	blockFunc func(key string) (uint64, error)
+	blockMux  = &sync.Mutex{}
+)
+
+// SetBlockFunc sets the function that leases blocks of values for generators
+// with param block, like auto-inc. It's a limit.BlockFunc set by each stage:
+// with multiple compute instances, it leases from the server so the blocks are
+// unique across all instances.
+func SetBlockFunc(f func(key string) (uint64, error)) {
+	blockMux.Lock()
+	blockFunc = f
+	blockMux.Unlock()
+}
+
+func leaseBlock(key string) (uint64, error) {
+	blockMux.Lock()
+	f := blockFunc
+	blockMux.Unlock()
+	if f == nil {
+		return 0, fmt.Errorf("no block lease function")
+	}
+	return f(key)
 }
@@ -3,15 +3,32 @@
 package data_test
 
 import (
+	"fmt"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
 	"github.com/square/finch"
 	"github.com/square/finch/data"
+	"github.com/square/finch/limit"
 )
 
+func Benchmark_Int(b *testing.B) {
+	// Each goroutine (client) has its own copy and PRNG, so this should scale
+	// with -cpu 1,2,4,8 (no global math/rand lock):
+	// go test -bench=Int -cpu 1,2,4,8
+	g, _ := data.NewInt(map[string]string{})
+	r := data.RunCount{}
+	b.RunParallel(func(pb *testing.PB) {
+		c := g.Copy()
+		for pb.Next() {
+			c.Values(r)
+		}
+	})
+}
+
 func TestInteger_Int(t *testing.T) {
 	finch.Debugging = true
 	g, _ := data.NewInt(map[string]string{
@@ -46,6 +63,78 @@ func TestInteger_Int(t *testing.T) {
 	deep.CompareUnexportedFields = false
 }
 
+func TestInteger_IntSkewed(t *testing.T) {
+	// Pareto h=0.2: 80% of values in first 20% of range. Exponential lambda=5:
+	// 1-e^-1 (63%) of values in first 20% of range.
+	for _, c := range []struct {
+		params map[string]string
+		p      float64
+	}{
+		{map[string]string{"dist": "pareto"}, 0.80},
+		{map[string]string{"dist": "exponential"}, 0.63},
+	} {
+		c.params["max"] = "1000"
+		c.params["seed"] = "1"
+		g, err := data.Make("int", "@d", c.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := data.RunCount{}
+		n := 0
+		for i := 0; i < 10000; i++ {
+			v := g.Values(r)[0].(int64)
+			if v < 1 || v > 1000 {
+				t.Fatalf("%s: got %d, expected value in [1, 1000]", c.params["dist"], v)
+			}
+			if v <= 200 {
+				n++
+			}
+		}
+		if p := float64(n) / 10000; p < c.p-0.02 || p > c.p+0.02 {
+			t.Errorf("%s: %.2f of values <= 200, expected %.2f", c.params["dist"], p, c.p)
+		}
+	}
+
+	if _, err := data.NewInt(map[string]string{"dist": "pareto", "h": "1"}); err == nil {
+		t.Error("no error for pareto h=1, expected an error")
+	}
+}
+
+func TestInteger_IntHot(t *testing.T) {
+	// [1, 100] with hot window of 10 keys advancing 30 keys every second
+	g, err := data.NewIntHot(map[string]string{"max": "100", "window": "10", "step": "30", "interval": "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		elapsed time.Duration
+		first   int64
+	}{
+		{0, 1},
+		{999 * time.Millisecond, 1},
+		{time.Second, 31},
+		{3 * time.Second, 91}, // [91, 100]
+		{4 * time.Second, 21}, // 121 wraps around to 21
+	} {
+		if got := g.Window(c.elapsed); got != c.first {
+			t.Errorf("elapsed %s: got window at %d, expected %d", c.elapsed, got, c.first)
+		}
+	}
+
+	// All values are in the first window until it advances
+	r := data.RunCount{}
+	for i := 0; i < 1000; i++ {
+		v := g.Values(r)[0].(int64)
+		if v < 1 || v > 10 {
+			t.Fatalf("got %d, expected value in hot window [1, 10]", v)
+		}
+	}
+
+	if _, err := data.NewIntHot(map[string]string{"max": "100", "window": "101"}); err == nil {
+		t.Error("no error for window > range, expected an error")
+	}
+}
+
 func TestInteger_AutoInc(t *testing.T) {
 	g, _ := data.NewAutoInc(nil)
 	r := data.RunCount{}
@@ -101,6 +190,124 @@ func TestInteger_AutoInc(t *testing.T) {
 	}
 }
 
+func TestInteger_AutoIncBlock(t *testing.T) {
+	// Two generators (like on two compute instances) leasing blocks of 3 values
+	// from one arbiter don't generate the same values
+	data.SetBlockFunc(limit.NewArbiter().Block)
+	defer data.SetBlockFunc(nil)
+
+	params := map[string]string{"start": "10", "block": "3"}
+	g1, err := data.Make("auto-inc", "@id", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, _ := data.Make("auto-inc", "@id", params)
+	r := data.RunCount{}
+
+	got1 := []uint64{}
+	got2 := []uint64{}
+	for i := 0; i < 4; i++ {
+		got1 = append(got1, g1.Values(r)[0].(uint64))
+		got2 = append(got2, g2.Values(r)[0].(uint64))
+	}
+	// g1: block 0 [11-13], block 2 [17-19]
+	// g2: block 1 [14-16], block 3 [20-22]
+	if diff := deep.Equal(got1, []uint64{11, 12, 13, 17}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(got2, []uint64{14, 15, 16, 20}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestInteger_AutoIncBlockError(t *testing.T) {
+	// Lease error panics with a data.ValueError so the client stops rather than
+	// generate values that might collide
+	data.SetBlockFunc(func(key string) (uint64, error) { return 0, fmt.Errorf("server gone") })
+	defer data.SetBlockFunc(nil)
+
+	g, err := data.Make("auto-inc", "@id", map[string]string{"block": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		r := recover()
+		if _, ok := r.(data.ValueError); !ok {
+			t.Errorf("recovered %#v, expected data.ValueError", r)
+		}
+	}()
+	g.Values(data.RunCount{})
+	t.Error("Values did not panic")
+}
+
+func TestInteger_IntPartition(t *testing.T) {
+	// [1, 10] split into 3 partitions (clients): [1, 3], [4, 6], [7, 10]
+	g, err := data.NewIntPartition(map[string]string{"min": "1", "max": "10", "clients": "3", "order": "seq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]int64{
+		{1, 2, 3, 1},
+		{4, 5, 6, 4},
+		{7, 8, 9, 10},
+	}
+	for c := range expect {
+		r := data.RunCount{}
+		r[data.CLIENT] = uint(c + 1)
+		r[data.CLIENT_GROUP] = 1
+		got := []int64{}
+		for i := 0; i < 4; i++ {
+			got = append(got, g.Values(r)[0].(int64))
+		}
+		if diff := deep.Equal(got, expect[c]); diff != nil {
+			t.Errorf("client %d: %v", c+1, diff)
+		}
+	}
+
+	// Random values stay in the client's partition, including client groups:
+	// client 2 in client group 2 is partition 4 of 4 (2*2): [76, 100]
+	g, _ = data.NewIntPartition(map[string]string{"min": "1", "max": "100", "clients": "2", "client-groups": "2"})
+	r := data.RunCount{}
+	r[data.CLIENT] = 2
+	r[data.CLIENT_GROUP] = 2
+	for i := 0; i < 1000; i++ {
+		v := g.Values(r)[0].(int64)
+		if v < 76 || v > 100 {
+			t.Fatalf("got %d, expected value in [76, 100]", v)
+		}
+	}
+
+	// More partitions than values is an error
+	if _, err := data.NewIntPartition(map[string]string{"min": "1", "max": "2", "clients": "3"}); err == nil {
+		t.Error("no error with 3 partitions of [1, 2], expected an error")
+	}
+
+	// Partitions set by the allocator: 2 clients in client group 1 of exec groups
+	// 1 and 2, which number client groups from 1, so client 2 in exec group 2 is
+	// partition 4 of 4: [76, 100]
+	g, _ = data.NewIntPartition(map[string]string{"min": "1", "max": "100"})
+	first := map[[2]uint]int64{{1, 1}: 0, {2, 1}: 2}
+	if err := g.SetPartitions(first, 4); err != nil {
+		t.Fatal(err)
+	}
+	r = data.RunCount{}
+	r[data.CLIENT] = 2
+	r[data.CLIENT_GROUP] = 1
+	r[data.EXEC_GROUP] = 2
+	for i := 0; i < 1000; i++ {
+		v := g.Values(r)[0].(int64)
+		if v < 76 || v > 100 {
+			t.Fatalf("got %d, expected value in [76, 100]", v)
+		}
+	}
+
+	// Param clients less than the clients that use it is an error
+	g, _ = data.NewIntPartition(map[string]string{"min": "1", "max": "100", "clients": "2"})
+	if err := g.SetPartitions(first, 4); err == nil {
+		t.Error("no error with clients=2 for 4 clients, expected an error")
+	}
+}
+
 func TestInteger_IntRange(t *testing.T) {
 	// Default is [1, 100000] with size 100
 	g, _ := data.NewIntRange(map[string]string{})
@@ -214,3 +421,42 @@ func TestInteger_IntGaps(t *testing.T) {
 		t.Errorf("got %d unique values, expected 19, 20, or 21 (20%% of 100)", len(v))
 	}
 }
+
+func TestInteger_Seed(t *testing.T) {
+	values := func(g data.Generator) []int64 {
+		v := make([]int64, 10)
+		for i := range v {
+			v[i] = g.Values(data.RunCount{})[0].(int64)
+		}
+		return v
+	}
+
+	// Same seed param = same values for the same copy number
+	g1, err := data.Make("int", "@id", map[string]string{"seed": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, _ := data.Make("int", "@id", map[string]string{"seed": "42"})
+	c1a, c1b := g1.Copy(), g1.Copy()
+	c2a := g2.Copy()
+	if diff := deep.Equal(values(c1a), values(c2a)); diff != nil {
+		t.Errorf("copy 1 values differ with the same seed: %v", diff)
+	}
+	if deep.Equal(values(c1a), values(c1b)) == nil {
+		t.Errorf("copy 1 and copy 2 have the same values, expected different streams")
+	}
+
+	// --seed: same data key = same values, different data key = different values
+	data.Seed, data.Seeded = 1, true
+	defer func() { data.Seed, data.Seeded = 0, false }()
+	g1, _ = data.Make("int", "@id", map[string]string{})
+	g2, _ = data.Make("int", "@id", map[string]string{})
+	g3, _ := data.Make("int", "@k", map[string]string{})
+	v1, v2, v3 := values(g1.Copy()), values(g2.Copy()), values(g3.Copy())
+	if diff := deep.Equal(v1, v2); diff != nil {
+		t.Errorf("--seed values differ for the same data key: %v", diff)
+	}
+	if deep.Equal(v1, v3) == nil {
+		t.Errorf("--seed values are the same for different data keys, expected different streams")
+	}
+}
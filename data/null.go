@@ -0,0 +1,194 @@
+// Copyright 2024 Block, Inc.
+
+package data
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CommonParams are params handled by Make for all generators, not by the
+// generators, so they're valid for every generator (see Params).
+var CommonParams = []string{"null"}
+
+// Nullable wraps a Generator to return SQL NULL with probability p, set by the
+// common param null (0.0 to 1.0). Values are returned as Value so NULL is
+// formatted as NULL (not quoted) in non-prepared statements, and non-NULL values
+// are formatted like the real generator format, like '%s'.
+//
+// Nullable implements seeder but doesn't embed prng: multi client scopes give
+// each client its own Nullable (see forClient), but copy the real generator only
+// if it's random, else clients share it like generators that aren't wrapped.
+// Its PRNG is guarded by a mutex because Copy and forClient can be called on
+// a Nullable that clients share.
+type Nullable struct {
+	g      Generator
+	p      float64
+	format string // real generator format
+	null   []interface{}
+	mu     *sync.Mutex
+	r      *rand.Rand
+}
+
+var (
+	_ Generator = &Nullable{}
+	_ seeder    = &Nullable{}
+)
+
+// NewNullable wraps g to return NULL with probability p. The PRNG is seeded
+// with seed, or randomly if seed is zero.
+func NewNullable(g Generator, p float64, seed int64) *Nullable {
+	n, format := g.Format()
+	null := make([]interface{}, n)
+	for i := range null {
+		null[i] = Value{}
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Nullable{
+		g:      g,
+		p:      p,
+		format: format,
+		null:   null,
+		mu:     &sync.Mutex{},
+		r:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (g *Nullable) Name() string { return g.g.Name() }
+
+// Format returns %v because Value formats itself like the real generator format.
+func (g *Nullable) Format() (uint, string) {
+	n, _ := g.g.Format()
+	return n, "%v"
+}
+
+func (g *Nullable) Scan(any interface{}) error { return g.g.Scan(any) }
+
+// From implements deriver if the real generator is a deriver, else it returns "".
+func (g *Nullable) From() string {
+	if d, ok := g.g.(deriver); ok {
+		return d.From()
+	}
+	return ""
+}
+
+func (g *Nullable) bind(src *ScopedGenerator) {
+	if d, ok := g.g.(deriver); ok {
+		d.bind(src)
+	}
+}
+
+func (g *Nullable) Copy() Generator {
+	return NewNullable(g.g.Copy(), g.p, g.nextSeed())
+}
+
+// forClient returns a Nullable for one client view of a multi client scope
+// (ScopedGenerator.forClient). It has its own PRNG and its own copy of the real
+// generator if that's random (seeder), else it shares the real generator.
+func (g *Nullable) forClient() *Nullable {
+	gen := g.g
+	if _, ok := gen.(seeder); ok {
+		gen = gen.Copy()
+	}
+	return NewNullable(gen, g.p, g.nextSeed())
+}
+
+// setSeed seeds the real generator, if it's random, and the Nullable PRNG.
+func (g *Nullable) setSeed(seed int64) {
+	if s, ok := g.g.(seeder); ok {
+		s.setSeed(seed)
+	}
+	g.mu.Lock()
+	g.r = rand.New(rand.NewSource(seed ^ nullSeed)) // not seed: NULLs uncorrelated with values
+	g.mu.Unlock()
+}
+
+// nullSeed is XOR'ed with a seed to seed the Nullable PRNG differently than the
+// real generator.
+const nullSeed = 0x6e756c6c // "null"
+
+// nextSeed returns the seed for the next copy, so copies are seeded in order
+// like prng.copy.
+func (g *Nullable) nextSeed() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.r.Int63()
+}
+
+func (g *Nullable) Values(rc RunCount) []interface{} {
+	vals := g.g.Values(rc) // always called so real generator state advances
+	g.mu.Lock()
+	null := g.r.Float64() < g.p
+	g.mu.Unlock()
+	if null {
+		return g.null
+	}
+	out := make([]interface{}, len(vals)) // new slice: vals can be reused by g.g
+	for i := range vals {
+		out[i] = Value{v: vals[i], format: g.format}
+	}
+	return out
+}
+
+// nullParam returns the null param value and removes it from params so it isn't
+// passed to the real generator. It returns zero if the param isn't set.
+func nullParam(params map[string]string) (float64, map[string]string, error) {
+	s, ok := params["null"]
+	if !ok {
+		return 0, params, nil
+	}
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, nil, fmt.Errorf("invalid null=%s: must be a number between 0 and 1 (inclusive)", s)
+	}
+	rest := make(map[string]string, len(params)-1)
+	for k, v := range params {
+		if k != "null" {
+			rest[k] = v
+		}
+	}
+	return p, rest, nil
+}
+
+// --------------------------------------------------------------------------
+
+// Value is a data value from a Nullable generator. The zero Value is SQL NULL.
+// It implements fmt.Formatter for non-prepared statements and driver.Valuer
+// for prepared statements.
+type Value struct {
+	v      interface{}
+	format string
+}
+
+// Format formats NULL or the value with the real generator format.
+func (v Value) Format(f fmt.State, _ rune) {
+	if v.v == nil {
+		io.WriteString(f, "NULL")
+		return
+	}
+	fmt.Fprintf(f, v.format, v.v)
+}
+
+// Value returns nil (NULL) or the value as a driver.Value.
+func (v Value) Value() (driver.Value, error) {
+	switch n := v.v.(type) {
+	case uint64:
+		if n > math.MaxInt64 {
+			return strconv.FormatUint(n, 10), nil
+		}
+		return int64(n), nil
+	case int:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	}
+	return v.v, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/square/finch"
+	"github.com/square/finch/data"
+)
+
+func TestNullable(t *testing.T) {
+	r := data.RunCount{}
+
+	// null=0 returns the real generator
+	g, err := data.Make("auto-inc", "@id", map[string]string{"null": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.(*data.AutoInc); !ok {
+		t.Errorf("got %T, expected *data.AutoInc", g)
+	}
+
+	// null=1 returns only NULL, formatted as NULL in queries and nil for prepared
+	g, err = data.Make("str-fill-az", "@c", map[string]string{"null": "1", "len": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, f := g.Format(); f != "%v" {
+		t.Errorf("got format %s, expected %%v", f)
+	}
+	v := g.Values(r)[0]
+	if s := fmt.Sprintf("%v", v); s != "NULL" {
+		t.Errorf("got %s, expected NULL", s)
+	}
+	if dv, _ := v.(driver.Valuer).Value(); dv != nil {
+		t.Errorf("got driver value %v, expected nil", dv)
+	}
+
+	// null=0.5: about half the values are NULL; others are formatted like the
+	// real generator format
+	g, _ = data.Make("auto-inc", "@id", map[string]string{"null": "0.5", "seed": "1"})
+	nulls := 0
+	for i := 1; i <= 1000; i++ {
+		v := g.Values(r)[0]
+		s := fmt.Sprintf("%v", v)
+		if s == "NULL" {
+			nulls++
+			continue
+		}
+		if s != fmt.Sprintf("%d", i) {
+			t.Fatalf("got %s, expected %d", s, i)
+		}
+		if dv, _ := v.(driver.Valuer).Value(); dv != int64(i) {
+			t.Fatalf("got driver value %v (%T), expected int64 %d", dv, dv, i)
+		}
+	}
+	if nulls < 400 || nulls > 600 {
+		t.Errorf("got %d NULL of 1000, expected about 500", nulls)
+	}
+
+	if _, err := data.Make("int", "@d", map[string]string{"null": "2"}); err == nil {
+		t.Error("no error for null=2, expected an error")
+	}
+}
+
+func TestNullable_MultiClient(t *testing.T) {
+	// Clients in a multi client scope (client-group) run concurrently, so each
+	// must have its own Nullable and copy of the random real generator (int).
+	// Run with -race to detect if they share either.
+	g, err := data.Make("int", "@d", map[string]string{"null": "0.5", "max": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := data.NewScope()
+	scope.Keys["@d"] = data.Key{
+		Name:      "@d",
+		Scope:     finch.SCOPE_CLIENT_GROUP,
+		Trx:       "test-trx",
+		Statement: 1,
+		Column:    -1,
+		Generator: g,
+	}
+	rl := finch.RunLevel{Stage: 1, ExecGroup: 1, ClientGroup: 1, Client: 1, Trx: 1, Query: 1}
+	c1 := scope.Copy("@d", rl)
+	rl.Client = 2
+	c2 := scope.Copy("@d", rl)
+	if c1 == c2 {
+		t.Fatal("client 1 and 2 got the same view, expected different views")
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range []*data.ScopedGenerator{c1, c2} {
+		wg.Add(1)
+		go func(c *data.ScopedGenerator) {
+			defer wg.Done()
+			rc := data.RunCount{}
+			for i := uint(1); i <= 1000; i++ {
+				rc[data.ITER] = i
+				c.Values(rc)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
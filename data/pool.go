@@ -0,0 +1,350 @@
+// Copyright 2024 Block, Inc.
+
+package data
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/square/finch"
+)
+
+// KeyPool is a named set of integer keys, like the insert IDs of a parent table,
+// that's shared by all stages in the same Finch run so the keys can be reused
+// as foreign keys when loading a child table. Column generators with param pool
+// add keys (Add); pool generators return random keys (Random).
+//
+// By default, keys are kept in memory. If the pool has a file, keys are written
+// to the file (8-byte little endian integers) and read randomly from the file,
+// so large key sets don't use memory and the keys persist across Finch runs.
+// In memory, the pool can be bounded (SetMax): when full, new keys replace the
+// oldest keys, so it's a working set of the last keys added.
+type KeyPool struct {
+	name string
+	file string
+	// --
+	mu      *sync.RWMutex
+	keys    []int64       // in memory if file == ""
+	max     int64         // max keys in memory, or 0 if unbounded
+	next    int64         // next key to replace if max keys
+	f       *os.File      // keys on disk if file != ""
+	w       *bufio.Writer // buffered writes to f
+	n       int64         // number of keys
+	flushed int64         // number of keys in f readable with ReadAt (atomic)
+	read    bool          // pool generator made in current stage (CheckPools)
+	filled  bool          // column generator with pool made in current stage
+}
+
+var pools = map[string]*KeyPool{}
+var poolsMux = &sync.Mutex{}
+
+// Pool returns the key pool by name, creating it on first use. If file is set,
+// the pool keys are stored in the file, and keys already in the file (from a
+// previous Finch run) are reused. All generators using the same pool must
+// use the same file.
+func Pool(name, file string) (*KeyPool, error) {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+	if file != "" {
+		// Relative to the stage file dir, which differs between stages
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("key pool %s: %s", name, err)
+		}
+		file = abs
+	}
+	if p, ok := pools[name]; ok {
+		if p.file != file {
+			return nil, fmt.Errorf("key pool %s file %s does not match file %s of previous generator", name, file, p.file)
+		}
+		return p, nil
+	}
+	p := &KeyPool{
+		name: name,
+		file: file,
+		mu:   &sync.RWMutex{},
+	}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("key pool %s: %s", name, err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("key pool %s: %s", name, err)
+		}
+		p.n = fi.Size() / 8 // ignore partial key, if any
+		if _, err := f.Seek(p.n*8, 0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("key pool %s: %s", name, err)
+		}
+		p.f = f
+		p.w = bufio.NewWriterSize(f, 64*1024)
+		p.flushed = p.n
+		finch.Debug("key pool %s: %d keys in %s", name, p.n, file)
+	}
+	pools[name] = p
+	return p, nil
+}
+
+// SyncPools writes buffered keys of all key pools to their files.
+func SyncPools() {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+	for _, p := range pools {
+		p.mu.Lock()
+		p.flush()
+		p.mu.Unlock()
+	}
+}
+
+// ClosePools writes buffered keys of all key pools to their files and closes
+// the files. It's called when a stage finishes. Key pools with a file are
+// removed, so the next stage that uses one opens the file again and reads the
+// keys saved by this stage. In-memory key pools are kept for the next stage.
+func ClosePools() {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+	for name, p := range pools {
+		p.mu.Lock()
+		p.read = false
+		p.filled = false
+		if p.f != nil {
+			p.flush()
+			if err := p.f.Close(); err != nil {
+				log.Printf("Error closing key pool %s file %s: %s", name, p.file, err)
+			}
+			delete(pools, name)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// CheckPools returns an error if a pool generator uses a key pool that's empty
+// and not filled by a column generator in the current stage, because the pool
+// generator would return only key 0. It's called after loading the trx files
+// (and data.persist state) of a stage.
+func CheckPools() error {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names) // first error is the same every run
+	for _, name := range names {
+		p := pools[name]
+		p.mu.RLock()
+		empty := p.read && !p.filled && p.n == 0
+		p.mu.RUnlock()
+		if empty {
+			if p.file != "" {
+				return fmt.Errorf("key pool %s is empty: no keys in file %s, and no column generator with pool=%s in this stage", name, p.file, name)
+			}
+			return fmt.Errorf("key pool %s is empty: no column generator with pool=%s in this or a previous stage", name, name)
+		}
+	}
+	return nil
+}
+
+// use records that a pool generator (read) or column generator (fill) uses the
+// pool in the current stage (CheckPools).
+func (p *KeyPool) use(fill bool) {
+	p.mu.Lock()
+	if fill {
+		p.filled = true
+	} else {
+		p.read = true
+	}
+	p.mu.Unlock()
+}
+
+// SetMax bounds the number of keys in memory. It returns an error if the pool
+// has a file or a different max. It's set by column generators with param
+// pool-size.
+func (p *KeyPool) SetMax(max int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file != "" {
+		return fmt.Errorf("key pool %s: size not allowed with file %s", p.name, p.file)
+	}
+	if p.max != 0 && p.max != max {
+		return fmt.Errorf("key pool %s size %d does not match size %d of previous generator", p.name, max, p.max)
+	}
+	p.max = max
+	return nil
+}
+
+// Add adds a key to the pool. If the pool has max keys, the key replaces the
+// oldest key.
+func (p *KeyPool) Add(key int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		if p.max > 0 && p.n == p.max {
+			p.keys[p.next] = key
+			p.next = (p.next + 1) % p.max
+			return
+		}
+		p.n++
+		p.keys = append(p.keys, key)
+		return
+	}
+	p.n++
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(key))
+	if p.w.Available() < len(b) {
+		p.flush()
+	}
+	p.w.Write(b[:])
+}
+
+// Len returns the number of keys in the pool.
+func (p *KeyPool) Len() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.n
+}
+
+// Random returns a random key from the pool using the random number generator
+// (0 <= rand(n) < n). It returns false if the pool is empty.
+func (p *KeyPool) Random(rand func(n int64) int64) (int64, bool) {
+	return p.get(rand)
+}
+
+// At returns key i modulo the number of keys, so incrementing i returns every
+// key in order (oldest first), then the first key again. It returns false if the
+// pool is empty.
+func (p *KeyPool) At(i int64) (int64, bool) {
+	// If the pool has max keys, the oldest key is at next (replaced next), not 0.
+	// pick is called with the read lock held, and next is always 0 with a file.
+	return p.get(func(n int64) int64 { return (p.next + i%n) % n })
+}
+
+// get returns the key at index pick(n) where n is the number of keys readable
+// and 0 <= pick(n) < n. It returns false if the pool is empty.
+func (p *KeyPool) get(pick func(n int64) int64) (int64, bool) {
+	if p.f == nil {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		if p.n == 0 {
+			return 0, false
+		}
+		return p.keys[pick(p.n)], true
+	}
+
+	// Keys on disk: read only keys flushed to the file, unless there are none
+	// yet because the pool is being filled in the same stage
+	n := atomic.LoadInt64(&p.flushed)
+	if n == 0 {
+		p.mu.Lock()
+		p.flush()
+		p.mu.Unlock()
+		if n = atomic.LoadInt64(&p.flushed); n == 0 {
+			return 0, false
+		}
+	}
+	var b [8]byte
+	if _, err := p.f.ReadAt(b[:], pick(n)*8); err != nil {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), true
+}
+
+// flush writes buffered keys to the file. The caller must hold the write lock.
+func (p *KeyPool) flush() {
+	if p.w == nil {
+		return
+	}
+	if err := p.w.Flush(); err != nil {
+		finch.Debug("key pool %s: flush: %s", p.name, err)
+		return
+	}
+	atomic.StoreInt64(&p.flushed, p.n)
+}
+
+// addTo adds a scanned column value or insert ID to the pool. Values that
+// aren't integers are ignored.
+func (p *KeyPool) addTo(v interface{}) {
+	switch k := v.(type) {
+	case int64:
+		p.Add(k)
+	case []byte:
+		if i, err := strconv.ParseInt(string(k), 10, 64); err == nil {
+			p.Add(i)
+		}
+	case uint64:
+		p.Add(int64(k))
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// PoolKey implements the pool data generator: a random key from a key pool
+// filled by column generators with the same pool param, or every key in order
+// if param order=seq.
+type PoolKey struct {
+	pool *KeyPool
+	seq  bool  // order=seq
+	pos  int64 // next key if seq
+	prng
+}
+
+var _ Generator = &PoolKey{}
+
+func NewPoolKey(params map[string]string) (*PoolKey, error) {
+	name := params["name"]
+	if name == "" {
+		return nil, fmt.Errorf("name required")
+	}
+	p, err := Pool(name, params["file"])
+	if err != nil {
+		return nil, err
+	}
+	p.use(false)
+	g := &PoolKey{pool: p, prng: newPRNG()}
+	switch strings.ToLower(params["order"]) {
+	case "", "random":
+	case "seq":
+		g.seq = true
+	default:
+		return nil, fmt.Errorf("invalid pool: order=%s: valid values: random, seq", params["order"])
+	}
+	return g, nil
+}
+
+func (g *PoolKey) Name() string               { return "pool" }
+func (g *PoolKey) Format() (uint, string)     { return 1, "%d" }
+func (g *PoolKey) Scan(any interface{}) error { return nil }
+
+func (g *PoolKey) Copy() Generator {
+	c := *g
+	c.pos = 0
+	c.prng = g.prng.copy()
+	return &c
+}
+
+// Values returns a random key from the pool, or the next key if order=seq.
+// CheckPools ensures the pool has keys or is filled in the same stage, so it
+// returns 0 only if the pool is filled in the same stage but has no keys yet.
+func (g *PoolKey) Values(_ RunCount) []interface{} {
+	if g.seq {
+		k, ok := g.pool.At(g.pos)
+		if ok {
+			g.pos++
+		}
+		return []interface{}{k}
+	}
+	k, _ := g.pool.Random(g.r.Int63n)
+	return []interface{}{k}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/data"
+)
+
+func TestPool_Memory(t *testing.T) {
+	// Parent: column saves insert IDs and column values to the pool
+	col, err := data.Make("column", "@id", map[string]string{"pool": "test-mem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	col = col.Copy() // copies add to the same pool
+	col.Scan(int64(10))
+	col.Scan([]byte("20"))
+	col.Scan([]byte("not-an-int")) // ignored
+
+	// Child: pool returns only saved keys
+	g, err := data.Make("pool", "@fk", map[string]string{"name": "test-mem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = g.Copy()
+	seen := map[int64]bool{}
+	for i := 0; i < 100; i++ {
+		v := g.Values(data.RunCount{})[0].(int64)
+		if v != 10 && v != 20 {
+			t.Fatalf("got key %d, expected 10 or 20", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("got keys %v, expected 10 and 20", seen)
+	}
+
+	// Empty pool is an error, unless a column generator fills it in the same stage
+	if _, err := data.Make("pool", "@fk", map[string]string{"name": "test-empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.CheckPools(); err == nil {
+		t.Error("no error for empty pool, expected error")
+	}
+	if _, err := data.Make("column", "@id", map[string]string{"pool": "test-empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.CheckPools(); err != nil {
+		t.Errorf("error for empty pool filled in same stage: %s", err)
+	}
+	data.ClosePools() // stage done
+
+	// Pool name is required
+	if _, err := data.Make("pool", "@fk", map[string]string{}); err == nil {
+		t.Error("no error without name param, expected error")
+	}
+}
+
+func TestPool_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	p, err := data.Pool("test-file", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 1000; i++ {
+		p.Add(i)
+	}
+	data.SyncPools()
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1000*8 {
+		t.Errorf("file size %d, expected 8000 (1000 keys)", fi.Size())
+	}
+	if p.Len() != 1000 {
+		t.Errorf("got %d keys, expected 1000", p.Len())
+	}
+
+	// Read first and last key on disk
+	for _, n := range []int64{0, 999} {
+		k, ok := p.Random(func(int64) int64 { return n })
+		if !ok || k != n+1 {
+			t.Errorf("got key %d (%t) at %d, expected %d", k, ok, n, n+1)
+		}
+	}
+
+	// Same pool, different file is an error
+	if _, err := data.Pool("test-file", file+"2"); err == nil {
+		t.Error("no error for different file, expected error")
+	}
+
+	// Stage done: file closed, and the next stage opens it again with the keys
+	data.ClosePools()
+	p2, err := data.Pool("test-file", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2 == p {
+		t.Error("got same pool after ClosePools, expected pool opened again")
+	}
+	if p2.Len() != 1000 {
+		t.Errorf("got %d keys after ClosePools, expected 1000", p2.Len())
+	}
+	data.ClosePools()
+}
+
+func TestPool_SizeSeq(t *testing.T) {
+	// Bounded pool: like save-columns scanning every row of a SELECT, the last
+	// 3 keys are the working set
+	col, err := data.Make("column", "@id", map[string]string{"pool": "test-size", "pool-size": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []int64{1, 2, 3, 4, 5} {
+		col.Scan(k)
+	}
+	p, err := data.Pool("test-size", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Len(); n != 3 {
+		t.Errorf("pool has %d keys, expected 3", n)
+	}
+
+	// Sequential reuse: every key in order (oldest first), then again
+	g, err := data.Make("pool", "@fk", map[string]string{"name": "test-size", "order": "seq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = g.Copy()
+	got := []int64{}
+	for i := 0; i < 6; i++ {
+		got = append(got, g.Values(data.RunCount{})[0].(int64))
+	}
+	expect := []int64{3, 4, 5, 3, 4, 5}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Errors: different size for same pool, size with file, size without pool,
+	// invalid order
+	invalid := []struct {
+		gen    string
+		params map[string]string
+	}{
+		{"column", map[string]string{"pool": "test-size", "pool-size": "10"}},
+		{"column", map[string]string{"pool": "test-size-file", "pool-file": filepath.Join(t.TempDir(), "keys"), "pool-size": "10"}},
+		{"column", map[string]string{"pool-size": "10"}},
+		{"column", map[string]string{"pool": "test-size", "pool-size": "0"}},
+		{"pool", map[string]string{"name": "test-size", "order": "backwards"}},
+	}
+	for _, test := range invalid {
+		if _, err := data.Make(test.gen, "@d", test.params); err == nil {
+			t.Errorf("no error for %s %v, expected error", test.gen, test.params)
+		}
+	}
+}
@@ -4,7 +4,6 @@ package data
 
 import (
 	"fmt"
-	"sync"
 
 	"github.com/square/finch"
 )
@@ -63,6 +62,9 @@ type Scope struct {
 	CopiedAt  map[string]finch.RunLevel   // that created ^
 	CopyCount map[string]uint             `deep:"-"`
 	noop      *ScopedGenerator
+	clientOf  map[string]*ScopedGenerator // current client view of multi-client CopyOf
+	clientAt  map[string]finch.RunLevel   // that created ^
+	copies    map[string][]Generator      // all copies of @d, for SaveState
 }
 
 func NewScope() *Scope {
@@ -71,6 +73,9 @@ func NewScope() *Scope {
 		CopyOf:    map[string]*ScopedGenerator{},
 		CopiedAt:  map[string]finch.RunLevel{},
 		CopyCount: map[string]uint{},
+		clientOf:  map[string]*ScopedGenerator{},
+		clientAt:  map[string]finch.RunLevel{},
+		copies:    map[string][]Generator{},
 	}
 }
 
@@ -116,10 +121,36 @@ func (s *Scope) Copy(keyName string, rl finch.RunLevel) *ScopedGenerator {
 			DataKey:  keyName,
 			CopyNo:   s.CopyCount[keyName],
 		}
-		s.CopyOf[keyName] = NewScopedGenerator(id, k.Generator.Copy())
+		g := k.Generator.Copy()
+		if d, ok := g.(deriver); ok && d.From() != "" {
+			d.bind(s.Copy(d.From(), rl)) // copy of from key in same scope
+		}
+		if _, ok := g.(positioner); ok {
+			s.copies[keyName] = append(s.copies[keyName], g)
+		}
+		s.CopyOf[keyName] = NewScopedGenerator(id, g)
 		s.CopiedAt[k.Name] = rl
 	}
-	return s.CopyOf[keyName]
+
+	// Multi client scopes: each client gets its own view of the shared copy so
+	// clients don't contend on a lock. Clients are allocated one by one, so the
+	// view changes when the client changes.
+	sg := s.CopyOf[keyName]
+	if !sg.multiClient {
+		return sg
+	}
+	view, ok := s.clientOf[keyName]
+	if !ok || view.shared != sg || s.clientAt[keyName].ClientId() != rl.ClientId() {
+		view = sg.forClient()
+		s.clientOf[keyName] = view
+		s.clientAt[keyName] = rl
+		// Bind a derived view to the same client's view of the from key, not
+		// the view bound to the shared copy above (the first client)
+		if d, ok := view.g.(deriver); ok && d.From() != "" {
+			d.bind(s.Copy(d.From(), rl))
+		}
+	}
+	return view
 }
 
 func (s *Scope) Reset() {
@@ -131,6 +162,9 @@ func (s *Scope) Reset() {
 		delete(s.Keys, keyName)
 		delete(s.CopyOf, keyName)
 		delete(s.CopiedAt, keyName)
+		delete(s.clientOf, keyName)
+		delete(s.clientAt, keyName)
+		delete(s.copies, keyName)
 	}
 }
 
@@ -144,17 +178,21 @@ func (s *Scope) Reset() {
 // case) or ScopedGenerator.Call to each @d input--the Client doesn't know or
 // care which--to handle either scoped value generator or explicit calls like
 // @d().
+//
+// Multi client scopes (client-group, exec-group, workload) share one real
+// Generator, but each client calls its own view (forClient) that has the client's
+// last value, so there's no lock or map shared by clients.
 type ScopedGenerator struct {
-	id           Id                     // identify this copy of the real Generator for debugging
-	g            Generator              // real Generator:
-	sno          byte                   //   scope number in RunCount (if singleClient == true)
-	last         RunCount               //   last time value was generated
-	vals         []interface{}          //   last value
-	singleClient bool                   // Single client scopes (typical): STATEMENT, TRX, ITER, CILENT
-	oneTime      bool                   // One time scopes: STAGE and GLOBAL
-	cgMux        *sync.RWMutex          // Multi client: client-group, exec-group, workload
-	cgIter       map[uint]uint          //   last client iter
-	cgVals       map[uint][]interface{} //   last client value
+	id           Id               // identify this copy of the real Generator for debugging
+	g            Generator        // real Generator:
+	sno          byte             //   scope number in RunCount (if singleClient == true)
+	last         RunCount         //   last time value was generated
+	vals         []interface{}    //   last value
+	singleClient bool             // Single client scopes (typical): STATEMENT, TRX, ITER, CILENT
+	oneTime      bool             // One time scopes: STAGE and GLOBAL
+	multiClient  bool             // Multi client: client-group, exec-group, workload
+	shared       *ScopedGenerator // multi client generator of this client view
+	column       bool             // Column scoped greater than trx: always last saved value
 }
 
 var _ Generator = &ScopedGenerator{}
@@ -173,10 +211,8 @@ func NewScopedGenerator(id Id, g Generator) *ScopedGenerator {
 		s.singleClient = true
 		s.sno = byte(n) // these match, see finch.runlevelNumber comment
 	} else if n <= finch.RunLevelNumber(finch.SCOPE_WORKLOAD) {
-		// Multi client scopes: iter = each <client, iter>
-		s.cgMux = &sync.RWMutex{}
-		s.cgIter = map[uint]uint{}
-		s.cgVals = map[uint][]interface{}{}
+		// Multi client scopes: iter = each <client, iter>, see forClient
+		s.multiClient = true
 	} else if n <= finch.RunLevelNumber(finch.SCOPE_GLOBAL) {
 		// One time scopes
 		s.oneTime = true
@@ -184,6 +220,13 @@ func NewScopedGenerator(id Id, g Generator) *ScopedGenerator {
 		panic("invalid scope: " + id.Scope)
 	}
 
+	// A saved column scoped greater than trx is used in other trx files, so the
+	// scope determines which copy they share, not when the value changes: it
+	// changes every time a statement saves it (Column.Scan)
+	if _, ok := g.(*Column); ok && id.Scope != finch.SCOPE_VALUE && n > finch.RunLevelNumber(finch.SCOPE_TRX) {
+		s.column = true
+	}
+
 	return s
 }
 
@@ -196,20 +239,35 @@ func (s *ScopedGenerator) Copy() Generator {
 	panic("cannot copy ScopedGenerator") // only real Generator is copied
 }
 
+// forClient returns a view of a multi client scoped generator for one client.
+// The view generates a new value when the client iter changes, like a single
+// client iter scope, from the shared real Generator. Random generators (prng)
+// aren't safe to share, but they have no other state, so the view has its own
+// copy, which is equivalent. A Nullable is always per client because it has its
+// own PRNG, but it copies the real generator only if that's random.
+func (s *ScopedGenerator) forClient() *ScopedGenerator {
+	g := s.g
+	switch n := g.(type) {
+	case *Nullable:
+		g = n.forClient()
+	case seeder:
+		g = g.Copy()
+	}
+	return &ScopedGenerator{
+		id:           s.id,
+		g:            g,
+		sno:          ITER,
+		singleClient: true,
+		shared:       s,
+		column:       s.column,
+	}
+}
+
 func (s *ScopedGenerator) Call(cnt RunCount) []interface{} {
 	/*
 		This func called in performance critical path: Client.Run.
 		Don't debug or call anything slow/superfluous.
 	*/
-	if s.cgMux != nil { // multi client
-		clientNo := cnt[CLIENT]
-		v := s.g.Values(cnt)
-		s.cgMux.Lock()
-		s.cgIter[clientNo] = cnt[ITER]
-		s.cgVals[clientNo] = v
-		s.cgMux.Unlock()
-		return v
-	}
 	s.last[s.sno] = cnt[s.sno] // save last run counter value
 	s.vals = s.g.Values(cnt)   // generate new data value
 	return s.vals
@@ -221,6 +279,11 @@ func (s *ScopedGenerator) Values(cnt RunCount) []interface{} {
 		Don't debug or call anything slow/superfluous.
 	*/
 
+	// Saved column used across trx: last saved value
+	if s.column {
+		return s.g.Values(cnt)
+	}
+
 	// Typical case: single client scopes
 	// Generate a new data value (g.g.Values) when the run counter
 	// for this scope has incremented (is greater than last value)
@@ -231,20 +294,6 @@ func (s *ScopedGenerator) Values(cnt RunCount) []interface{} {
 		return s.vals // old value (scope hasn't changed)
 	}
 
-	// Multi client scopes: CLIENT_GROUP, EXEC_GROUP, WORKLOAD
-	if s.cgMux != nil {
-		clientNo := cnt[CLIENT]
-		s.cgMux.RLock()
-		prevIter, ok := s.cgIter[clientNo]
-		if !ok || cnt[ITER] > prevIter {
-			s.cgMux.RUnlock()
-			return s.Call(cnt)
-		}
-		v := s.cgVals[clientNo]
-		s.cgMux.RUnlock()
-		return v
-	}
-
 	// One time scopes: STAGE and GLOBAL
 	if s.oneTime {
 		// @todo guard with mux
@@ -258,6 +307,13 @@ func (s *ScopedGenerator) Values(cnt RunCount) []interface{} {
 	return s.g.Values(cnt)
 }
 
+// Preview returns a value from a copy of the real Generator, so the real
+// Generator's state (like an auto-inc counter) doesn't change. It's used for
+// --explain before the stage runs, not in the critical path.
+func (s *ScopedGenerator) Preview(cnt RunCount) []interface{} {
+	return s.g.Copy().Values(cnt)
+}
+
 // RunCount counts execution (or changes) at each level in the order defined
 // by the const below: STATEMENT and up the run levels. Each Client maintains
 // a RunCount that is used by ScopedGenerator.Values to determine when it's
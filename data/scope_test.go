@@ -145,3 +145,124 @@ func TestScope_PREV(t *testing.T) {
 		t.Errorf("got Generator for @PREV, expected nil: %+v", g2)
 	}
 }
+
+func TestScope_ClientGroup(t *testing.T) {
+	keyName := "@d"
+	g, _ := data.NewAutoInc(nil)
+
+	r := finch.RunLevel{
+		Stage:       1,
+		ExecGroup:   1,
+		ClientGroup: 1,
+		Client:      1,
+		Trx:         1,
+		Query:       1,
+	}
+
+	scope := data.NewScope()
+	scope.Keys[keyName] = data.Key{
+		Name:      keyName,
+		Scope:     finch.SCOPE_CLIENT_GROUP,
+		Trx:       "test-trx",
+		Statement: 1,
+		Column:    -1,
+		Generator: g,
+	}
+
+	// Each client gets its own view of the same copy: same for all statements
+	// of one client, different for another client
+	c1a := scope.Copy(keyName, r)
+	r.Query += 1
+	c1b := scope.Copy(keyName, r)
+	if c1a != c1b {
+		t.Errorf("client 1 got different generators, expected same")
+	}
+	r.Client = 2
+	r.Query = 1
+	c2 := scope.Copy(keyName, r)
+	if c2 == c1a {
+		t.Errorf("client 2 got same generator as client 1, expected different view")
+	}
+	if c2.Id().CopyNo != 1 {
+		t.Errorf("client 2 got copy %d, expected 1 (shared by client group)", c2.Id().CopyNo)
+	}
+
+	// Clients share the real generator (auto-inc) but have their own value per iter
+	rc1 := data.RunCount{}
+	rc1[data.ITER] = 1
+	rc2 := data.RunCount{}
+	rc2[data.ITER] = 1
+	got := []interface{}{
+		c1a.Values(rc1)[0], // 1
+		c1b.Values(rc1)[0], // 1: same client and iter
+		c2.Values(rc2)[0],  // 2
+	}
+	rc1[data.ITER] = 2
+	got = append(got, c1a.Values(rc1)[0]) // 3: next iter
+	expect := []interface{}{uint64(1), uint64(1), uint64(2), uint64(3)}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestScope_ColumnClient(t *testing.T) {
+	keyName := "@id"
+	g, _ := data.NewColumn(nil)
+
+	r := finch.RunLevel{
+		Stage:       1,
+		ExecGroup:   1,
+		ClientGroup: 1,
+		Client:      1,
+		Trx:         1,
+		Query:       1,
+	}
+
+	scope := data.NewScope()
+	scope.Keys[keyName] = data.Key{
+		Name:      keyName,
+		Scope:     finch.SCOPE_CLIENT,
+		Trx:       "save.sql",
+		Statement: 1,
+		Column:    0,
+		Generator: g,
+	}
+
+	// Saved in trx 1 (output), used in trx 2 (input): same copy
+	out := scope.Copy(keyName, r)
+	r.Trx = 2
+	in := scope.Copy(keyName, r)
+	if out != in {
+		t.Fatalf("trx 2 got different generator, expected same copy for client")
+	}
+
+	// Input is always the last saved value, even though the client scope
+	// hasn't changed
+	rc := data.RunCount{}
+	var got []interface{}
+	for _, v := range []int64{10, 20} {
+		rc[data.TRX] += 1
+		out.Scan(v)
+		rc[data.TRX] += 1
+		got = append(got, in.Values(rc)[0])
+	}
+	expect := []interface{}{int64(10), int64(20)}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestScope_Preview(t *testing.T) {
+	// Preview (--explain) returns a value without changing the generator state,
+	// so the first value when the stage runs is still the first value
+	g, _ := data.NewAutoInc(nil)
+	sg := data.NewScopedGenerator(data.Id{Scope: finch.SCOPE_STATEMENT}, g)
+	var rc data.RunCount
+	rc[data.STATEMENT] = 1
+	if diff := deep.Equal(sg.Preview(rc), []interface{}{uint64(1)}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(sg.Values(rc), []interface{}{uint64(1)}); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 Block, Inc.
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/finch"
+	"github.com/square/finch/dbconn"
+)
+
+// SeqTable implements the seq-table data generator: IDs allocated in blocks from
+// a MySQL sequence table, like applications that use a table instead of
+// AUTO_INCREMENT. The sequence column is the last ID allocated. To allocate a
+// block, the generator adds block to the column and returns the IDs in between,
+// so allocating blocks contends on the sequence row like the application.
+//
+// With lock=for-update (default), a block is allocated in a transaction:
+//
+//	SELECT col FROM table WHERE ... FOR UPDATE
+//	UPDATE table SET col = col + block WHERE ...
+//
+// With lock=atomic, a block is allocated with one statement:
+//
+//	UPDATE table SET col = LAST_INSERT_ID(col + block) WHERE ...
+//	SELECT LAST_INSERT_ID()
+type SeqTable struct {
+	db *seqDB // shared by all copies
+	// --
+	mu *sync.Mutex
+	id int64 // last ID returned
+	n  int64 // IDs left in current block
+}
+
+var _ Generator = &SeqTable{}
+
+// seqDB is the connection pool and queries shared by all copies of the generator.
+// The pool is made on first use, not when the generator is made, so it's not made
+// for --test or --lint.
+type seqDB struct {
+	table  string
+	column string
+	where  string
+	atomic bool
+	block  int64
+	// --
+	once   *sync.Once
+	db     *sql.DB
+	err    error
+	mu     *sync.Mutex
+	failed error // first allocate error, returned by all later calls
+}
+
+// seqDBs are the seqDB with an open connection pool, closed by CloseSeqTables.
+var seqDBs = []*seqDB{}
+var seqDBsMux = &sync.Mutex{}
+
+// CloseSeqTables closes the connection pools of all seq-table generators. It's
+// called when a stage finishes, after its clients are done.
+func CloseSeqTables() {
+	seqDBsMux.Lock()
+	defer seqDBsMux.Unlock()
+	for _, s := range seqDBs {
+		if err := s.db.Close(); err != nil {
+			log.Printf("Error closing seq-table %s connection pool: %s", s.table, err)
+		}
+	}
+	seqDBs = []*seqDB{}
+}
+
+func NewSeqTable(params map[string]string) (*SeqTable, error) {
+	s := &seqDB{
+		table:  params["table"],
+		column: params["column"],
+		where:  params["where"],
+		block:  1,
+		once:   &sync.Once{},
+		mu:     &sync.Mutex{},
+	}
+	if s.table == "" {
+		return nil, fmt.Errorf("table required")
+	}
+	if s.column == "" {
+		s.column = "id"
+	}
+	if err := int64From(params, "block", &s.block, false); err != nil {
+		return nil, err
+	}
+	if s.block < 1 {
+		return nil, fmt.Errorf("invalid block=%d: must be >= 1", s.block)
+	}
+	switch strings.ToLower(params["lock"]) {
+	case "", "for-update":
+	case "atomic":
+		s.atomic = true
+	default:
+		return nil, fmt.Errorf("invalid lock=%s: valid values: for-update, atomic", params["lock"])
+	}
+	return &SeqTable{
+		db: s,
+		mu: &sync.Mutex{},
+	}, nil
+}
+
+func (g *SeqTable) Name() string               { return "seq-table" }
+func (g *SeqTable) Format() (uint, string)     { return 1, "%d" }
+func (g *SeqTable) Scan(any interface{}) error { return nil }
+
+// Copy returns a copy that allocates its own blocks from the same table.
+func (g *SeqTable) Copy() Generator {
+	return &SeqTable{
+		db: g.db,
+		mu: &sync.Mutex{},
+	}
+}
+
+// Values returns the next ID in the current block, allocating a new block when
+// the current one is used up. If allocating a block fails, it panics with
+// ValueError to stop the client because there's no valid ID to return.
+func (g *SeqTable) Values(_ RunCount) []interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.n == 0 {
+		last, err := g.db.allocate()
+		if err != nil {
+			panic(ValueError{fmt.Errorf("seq-table %s: block allocation error: %s", g.db.table, err)})
+		}
+		g.id, g.n = last-g.db.block, g.db.block
+	}
+	g.n--
+	g.id++
+	return []interface{}{g.id}
+}
+
+// allocate allocates a block and returns the last ID in the block. After an
+// error, it returns the same error without querying again, so other copies
+// stop their clients right away instead of each waiting for the timeout.
+func (s *seqDB) allocate() (int64, error) {
+	s.once.Do(func() {
+		s.db, _, s.err = dbconn.Make()
+		if s.err == nil {
+			seqDBsMux.Lock()
+			seqDBs = append(seqDBs, s)
+			seqDBsMux.Unlock()
+		}
+	})
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.mu.Lock()
+	err := s.failed
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	last, err := s.query()
+	if err != nil {
+		s.mu.Lock()
+		if s.failed == nil {
+			s.failed = err
+		}
+		s.mu.Unlock()
+	}
+	return last, err
+}
+
+// query runs the queries to allocate a block and returns the last ID in the block.
+func (s *seqDB) query() (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	where := ""
+	if s.where != "" {
+		where = " WHERE " + s.where
+	}
+	block := strconv.FormatInt(s.block, 10)
+
+	if s.atomic {
+		// LAST_INSERT_ID is per-connection, so both must use the same conn
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		q := "UPDATE " + s.table + " SET " + s.column + " = LAST_INSERT_ID(" + s.column + " + " + block + ")" + where
+		res, err := conn.ExecContext(ctx, q)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", q, err)
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return 0, fmt.Errorf("%s: %d rows affected, expected 1", q, n)
+		}
+		var last int64
+		if err := conn.QueryRowContext(ctx, "SELECT LAST_INSERT_ID()").Scan(&last); err != nil {
+			return 0, err
+		}
+		finch.Debug("seq-table %s: allocated %d", s.table, last)
+		return last, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // no-op after commit
+	q := "SELECT " + s.column + " FROM " + s.table + where + " FOR UPDATE"
+	var cur int64
+	if err := tx.QueryRowContext(ctx, q).Scan(&cur); err != nil {
+		return 0, fmt.Errorf("%s: %s", q, err)
+	}
+	q = "UPDATE " + s.table + " SET " + s.column + " = " + s.column + " + " + block + where
+	if _, err := tx.ExecContext(ctx, q); err != nil {
+		return 0, fmt.Errorf("%s: %s", q, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	finch.Debug("seq-table %s: allocated %d", s.table, cur+s.block)
+	return cur + s.block, nil
+}
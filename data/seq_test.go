@@ -0,0 +1,71 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/config"
+	"github.com/square/finch/data"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/test"
+)
+
+func TestSeqTable(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	dsn, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = test.Exec(db, []string{
+		"CREATE DATABASE IF NOT EXISTS finch",
+		"DROP TABLE IF EXISTS finch.seq",
+		"CREATE TABLE finch.seq (name varchar(20) NOT NULL PRIMARY KEY, id bigint NOT NULL)",
+		"INSERT INTO finch.seq VALUES ('a', 0), ('b', 100)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS finch.seq")
+	dbconn.SetConfig(config.MySQL{DSN: dsn})
+	defer data.CloseSeqTables()
+
+	for _, lock := range []string{"for-update", "atomic"} {
+		g, err := data.NewSeqTable(map[string]string{
+			"table": "finch.seq",
+			"where": "name='b'",
+			"block": "2",
+			"lock":  lock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := g.Copy()
+		r := data.RunCount{}
+
+		// g allocates [101, 102], c allocates [103, 104], g allocates [105, 106]
+		got := []interface{}{}
+		got = append(got, g.Values(r)[0], g.Values(r)[0], c.Values(r)[0], g.Values(r)[0])
+		expect := []interface{}{int64(101), int64(102), int64(103), int64(105)}
+		if diff := deep.Equal(got, expect); diff != nil {
+			t.Errorf("lock=%s: %v", lock, diff)
+		}
+
+		// Reset for next lock method
+		if _, err := db.Exec("UPDATE finch.seq SET id=100 WHERE name='b'"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sequence a isn't changed
+	id, _ := test.OneRow(db, "SELECT id FROM finch.seq WHERE name='a'")
+	if id != "0" {
+		t.Errorf("sequence a id = %s, expected 0", id)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Block, Inc.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/square/finch"
+)
+
+// State is generator state saved and restored by config.stage.data.persist so
+// a benchmark can be split across stages or Finch runs without key collisions.
+type State struct {
+	Keys  map[string]KeyState `json:"keys"`            // keyed on data key (@d)
+	Pools map[string][]int64  `json:"pools,omitempty"` // in-memory key pools
+}
+
+// KeyState is the state of one data key: the position of its generator, like
+// the auto-inc counter or the next int-range-seq range. With multiple copies of
+// the generator (like client scoped), it's the highest position of all copies.
+type KeyState struct {
+	Generator string `json:"generator"`
+	Position  int64  `json:"position"`
+}
+
+// positioner is implemented by generators with state that can be saved and
+// restored: auto-inc and int-range-seq.
+type positioner interface {
+	position() int64
+	setPosition(int64)
+}
+
+// SaveState writes the state of the data keys to file. If keys is empty, all
+// data keys with a stateful generator are saved. In-memory key pools are saved,
+// too; key pools with a file are already saved in their file.
+func (s *Scope) SaveState(file string, keys []string) error {
+	state := State{
+		Keys:  map[string]KeyState{},
+		Pools: map[string][]int64{},
+	}
+	for _, name := range s.stateKeys(keys) {
+		var pos int64
+		var saved bool
+		for _, g := range s.copies[name] {
+			if p, ok := g.(positioner); ok && (!saved || p.position() > pos) {
+				pos = p.position()
+				saved = true
+			}
+		}
+		if !saved {
+			continue // not used (no copies)
+		}
+		state.Keys[name] = KeyState{
+			Generator: s.Keys[name].Generator.Name(),
+			Position:  pos,
+		}
+	}
+
+	poolsMux.Lock()
+	for name, p := range pools {
+		if p.f != nil {
+			continue
+		}
+		p.mu.RLock()
+		state.Pools[name] = append([]int64{}, p.keys...)
+		p.mu.RUnlock()
+	}
+	poolsMux.Unlock()
+
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, bytes, 0644)
+}
+
+// LoadState restores the state of the data keys from file, if it exists. It
+// must be called after trx.Load and before the data keys are copied (scoped),
+// and it only restores data keys that haven't been copied yet, like global
+// data keys copied in a previous stage. If keys is empty, all data keys in
+// the file are restored. In-memory key pools are restored if they're empty.
+func (s *Scope) LoadState(file string, keys []string) error {
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // first run
+		}
+		return err
+	}
+	var state State
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+
+	for _, name := range s.stateKeys(keys) {
+		ks, ok := state.Keys[name]
+		if !ok || len(s.copies[name]) > 0 {
+			continue
+		}
+		k := s.Keys[name]
+		if ks.Generator != k.Generator.Name() {
+			return fmt.Errorf("%s: data key %s generator %s does not match saved generator %s", file, name, k.Generator.Name(), ks.Generator)
+		}
+		k.Generator.(positioner).setPosition(ks.Position)
+		finch.Debug("%s: restored position %d", name, ks.Position)
+	}
+
+	for name, keys := range state.Pools {
+		p, err := Pool(name, "")
+		if err != nil {
+			continue // key pool with a file in this run
+		}
+		if p.Len() > 0 {
+			continue
+		}
+		for _, k := range keys {
+			p.Add(k)
+		}
+	}
+	return nil
+}
+
+// stateKeys returns the data keys with a stateful generator, sorted, and
+// filtered by keys (with or without @) if not empty.
+func (s *Scope) stateKeys(keys []string) []string {
+	only := map[string]bool{}
+	for _, k := range keys {
+		only["@"+strings.TrimPrefix(k, "@")] = true
+	}
+	names := []string{}
+	for name, k := range s.Keys {
+		if _, ok := k.Generator.(positioner); !ok {
+			continue
+		}
+		if len(only) > 0 && !only[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Block, Inc.
+
+package data_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch"
+	"github.com/square/finch/data"
+)
+
+func TestScope_State(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+
+	newScope := func() *data.Scope {
+		scope := data.NewScope()
+		id, _ := data.NewAutoInc(nil)
+		seq, _ := data.NewIntRangeSeq(map[string]string{"begin": "1", "end": "1000", "size": "10"})
+		scope.Keys["@id"] = data.Key{Name: "@id", Scope: finch.SCOPE_CLIENT, Column: -1, Generator: id}
+		scope.Keys["@r"] = data.Key{Name: "@r", Scope: finch.SCOPE_STAGE, Column: -1, Generator: seq}
+		return scope
+	}
+
+	// First run: 2 clients, client 1 generates 3 IDs and client 2 generates 5
+	scope := newScope()
+	r := finch.RunLevel{Stage: 1, ExecGroup: 1, ClientGroup: 1, Client: 1, Trx: 1, Query: 1}
+	for c, n := range []int{3, 5} {
+		r.Client = uint(c + 1)
+		id := scope.Copy("@id", r)
+		for i := 0; i < n; i++ {
+			id.Call(data.RunCount{})
+		}
+	}
+	scope.Copy("@r", r).Call(data.RunCount{}) // [1, 10]
+	scope.Copy("@r", r).Call(data.RunCount{}) // [11, 20]
+	if err := scope.SaveState(file, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run continues after the highest ID and next range
+	scope = newScope()
+	if err := scope.LoadState(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Client = 1
+	got := []interface{}{
+		scope.Copy("@id", r).Call(data.RunCount{})[0],
+		scope.Copy("@r", r).Call(data.RunCount{})[0],
+	}
+	expect := []interface{}{uint64(6), int64(21)}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Only selected keys are restored
+	scope = newScope()
+	if err := scope.LoadState(file, []string{"r"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := scope.Copy("@id", r).Call(data.RunCount{})[0]; v != uint64(1) {
+		t.Errorf("got @id %v, expected 1 (not restored)", v)
+	}
+
+	// No file is not an error (first run)
+	os.Remove(file)
+	if err := newScope().LoadState(file, nil); err != nil {
+		t.Error(err)
+	}
+}
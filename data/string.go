@@ -4,15 +4,13 @@ package data
 
 import (
 	"fmt"
-	"math/rand"
 	"strings"
-	"time"
 )
 
 // StrFillAz implemnts the str-fill-az data generator.
 type StrFillAz struct {
 	len int64
-	src rand.Source
+	prng
 }
 
 var _ Generator = &StrFillAz{}
@@ -27,8 +25,8 @@ const (
 
 func NewStrFillAz(params map[string]string) (*StrFillAz, error) {
 	g := &StrFillAz{
-		len: 100,
-		src: rand.NewSource(time.Now().UnixNano()),
+		len:  100,
+		prng: newPRNG(),
 	}
 	if err := int64From(params, "len", &g.len, false); err != nil {
 		return nil, err
@@ -45,18 +43,18 @@ func (g *StrFillAz) Scan(any interface{}) error { return nil }
 
 func (g *StrFillAz) Copy() Generator {
 	return &StrFillAz{
-		len: g.len,
-		src: rand.NewSource(time.Now().UnixNano()),
+		len:  g.len,
+		prng: g.prng.copy(),
 	}
 }
 
 func (g *StrFillAz) Values(_ RunCount) []interface{} {
 	sb := strings.Builder{}
 	sb.Grow(int(g.len))
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := g.len-1, g.src.Int63(), letterIdxMax; i >= 0; {
+	// A r.Int63() generates 63 random bits, enough for letterIdxMax characters!
+	for i, cache, remain := g.len-1, g.r.Int63(), letterIdxMax; i >= 0; {
 		if remain == 0 {
-			cache, remain = g.src.Int63(), letterIdxMax
+			cache, remain = g.r.Int63(), letterIdxMax
 		}
 		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
 			sb.WriteByte(letterBytes[idx])
@@ -4,14 +4,19 @@
 package dbconn
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 
@@ -29,19 +34,113 @@ var rdsAddr = regexp.MustCompile(`rds\.amazonaws\.com(:\d+)?$`)
 // strip the port suffix before passing the hostname to LoadTLS.
 var portSuffix = regexp.MustCompile(`:\d+$`)
 
-var f = &factory{}
+var f = &factory{tls: "benchmark"}
+
+// r is the factory for the reader endpoint (mysql.reader), or nil if not set.
+var r *factory
+
+// t are the factories for client group transports (config.stage.workload.transport),
+// made on first use.
+var t = map[string]*factory{}
 
 type factory struct {
 	cfg config.MySQL
 	dsn string
+	tls string // name of registered TLS config, if any
 }
 
 func SetConfig(cfg config.MySQL) {
 	f.cfg = cfg
 	f.dsn = ""
+	r = nil
+	t = map[string]*factory{}
+	if rcfg := cfg.ReaderConfig(); rcfg != nil {
+		r = &factory{cfg: *rcfg, tls: "benchmark-reader"}
+	}
 }
 
 func Make() (*sql.DB, string, error) {
+	return f.make()
+}
+
+// MakeConfig is like Make but for the given MySQL config instead of the config
+// set by SetConfig, which is not changed. It's used by stage hooks, which run
+// on the server concurrently with other stages being set up.
+func MakeConfig(cfg config.MySQL) (*sql.DB, string, error) {
+	return (&factory{cfg: cfg, tls: "benchmark-hook"}).make()
+}
+
+// MakeReader is like Make but for the reader endpoint (mysql.reader). It returns
+// a nil *sql.DB if the reader is not set.
+func MakeReader() (*sql.DB, string, error) {
+	if r == nil {
+		return nil, "", nil
+	}
+	return r.make()
+}
+
+// MakeTransport is like Make but connects over a specific transport for a client
+// group (config.stage.workload.transport) to compare transports in one stage:
+//
+//	socket	Unix socket (mysql.socket) without TLS
+//	tcp	TCP (mysql.hostname) without TLS
+//	tls	TCP (mysql.hostname) with TLS (mysql.tls or Amazon RDS auto TLS)
+//
+// All other MySQL config, like username, is the same. If transport is "", it's
+// the same as Make. Transports are not supported with mysql.dsn (or --dsn).
+func MakeTransport(transport string) (*sql.DB, string, error) {
+	if transport == "" {
+		return f.make()
+	}
+	tf, ok := t[transport]
+	if !ok {
+		cfg, err := transportConfig(f.cfg, transport)
+		if err != nil {
+			return nil, "", err
+		}
+		tf = &factory{cfg: cfg, tls: "benchmark-" + transport}
+		t[transport] = tf
+	}
+	return tf.make()
+}
+
+// transportConfig returns a copy of cfg modified for the transport.
+func transportConfig(cfg config.MySQL, transport string) (config.MySQL, error) {
+	if cfg.DSN != "" {
+		return cfg, fmt.Errorf("transport %s not supported with mysql.dsn or --dsn; set mysql.hostname, mysql.socket, and so forth instead", transport)
+	}
+	// Apply my.cnf first because it can set the socket and TLS
+	if cfg.MyCnf != "" {
+		def, err := ParseMyCnf(cfg.MyCnf)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.With(def)
+		cfg.MyCnf = ""
+	}
+	off := true
+	switch transport {
+	case "socket":
+		if cfg.Socket == "" {
+			return cfg, fmt.Errorf("transport socket requires mysql.socket")
+		}
+		cfg.TLS.Disable = &off
+	case "tcp":
+		cfg.Socket = ""
+		cfg.TLS.Disable = &off
+		cfg.DisableAutoTLS = &off
+	case "tls":
+		cfg.Socket = ""
+		if !cfg.TLS.Set() && (!rdsAddr.MatchString(cfg.Hostname) || config.True(cfg.DisableAutoTLS)) {
+			return cfg, fmt.Errorf("transport tls requires mysql.tls or an Amazon RDS hostname")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid transport: %s (valid: socket, tcp, tls)", transport)
+	}
+	return cfg, nil
+}
+
+func (f *factory) make() (*sql.DB, string, error) {
 	// Parse MySQL params and set DSN on first call. There's only 1 DSN for
 	// all clients, so this only needs to be done once.
 	if f.dsn == "" {
@@ -60,10 +159,51 @@ func Make() (*sql.DB, string, error) {
 	return db, RedactedDSN(f.dsn), nil
 }
 
+// CreateDb creates the default database, if set and it doesn't exist
+// (config.mysql.create-db). The default database is mysql.db or the database in
+// mysql.dsn. It returns the database name, or "" if there's no default database.
+func CreateDb(ctx context.Context) (string, error) {
+	if f.dsn == "" {
+		if err := f.setDSN(); err != nil {
+			return "", err
+		}
+	}
+	my, err := mysql.ParseDSN(f.dsn)
+	if err != nil {
+		return "", err
+	}
+	if my.DBName == "" {
+		return "", nil
+	}
+	dbName := my.DBName
+	my.DBName = "" // connect without the db because it might not exist
+	db, err := sql.Open("mysql", my.FormatDSN())
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	finch.Debug("create db %s", dbName)
+	_, err = db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+QuoteIdent(dbName))
+	return dbName, err
+}
+
+// QuoteIdent returns the identifier, like a database name, quoted in backticks
+// with backticks in the name escaped (doubled).
+func QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
 func (f *factory) setDSN() error {
 	// --dsn or mysql.dsn (in that order) overrides all
 	if f.cfg.DSN != "" {
 		f.dsn = f.cfg.DSN
+		// The DSN is used as-is, so config that's applied to the DSN is ignored
+		if len(f.cfg.Params) > 0 {
+			log.Printf("mysql.params ignored because mysql.dsn or --dsn is set; set params in the DSN instead")
+		}
+		if f.cfg.TimeoutConnect != "" || f.cfg.TimeoutRead != "" || f.cfg.TimeoutWrite != "" {
+			log.Printf("mysql.timeout-connect, timeout-read, and timeout-write ignored because mysql.dsn or --dsn is set; set DSN params timeout, readTimeout, and writeTimeout instead")
+		}
 		return nil
 	}
 
@@ -120,8 +260,8 @@ func (f *factory) setDSN() error {
 		return err
 	}
 	if tlsConfig != nil {
-		mysql.RegisterTLSConfig("benchmark", tlsConfig)
-		params = append(params, "tls=benchmark")
+		mysql.RegisterTLSConfig(f.tls, tlsConfig)
+		params = append(params, "tls="+f.tls)
 		finch.Debug("TLS enabled")
 	}
 
@@ -157,6 +297,47 @@ func (f *factory) setDSN() error {
 		cred += ":" + password
 	}
 
+	// ----------------------------------------------------------------------
+	// Timeouts
+
+	// Connect timeout defaults to 10s, else the driver waits as long as the OS
+	// (minutes) for a server that's down, like during a failover. Read and write
+	// timeouts are off by default because they limit query response time.
+	timeoutConnect := f.cfg.TimeoutConnect
+	if timeoutConnect == "" {
+		timeoutConnect = DEFAULT_TIMEOUT_CONNECT
+	}
+	for _, t := range []struct{ param, val string }{
+		{"timeout", timeoutConnect},
+		{"readTimeout", f.cfg.TimeoutRead},
+		{"writeTimeout", f.cfg.TimeoutWrite},
+	} {
+		if d, _ := time.ParseDuration(t.val); d > 0 { // already validated
+			params = append(params, t.param+"="+d.String())
+		}
+	}
+
+	// ----------------------------------------------------------------------
+	// DSN params (mysql.params), which override params set above
+
+	if len(f.cfg.Params) > 0 {
+		keys := make([]string, 0, len(f.cfg.Params))
+		for k := range f.cfg.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // deterministic DSN
+		for _, k := range keys {
+			for i := range params {
+				if strings.HasPrefix(params[i], k+"=") {
+					finch.Debug("mysql.params %s overrides %s", k, params[i])
+					params = append(params[:i], params[i+1:]...)
+					break
+				}
+			}
+			params = append(params, k+"="+url.QueryEscape(f.cfg.Params[k]))
+		}
+	}
+
 	// ----------------------------------------------------------------------
 	// Set DSN
 
@@ -168,6 +349,9 @@ func (f *factory) setDSN() error {
 	return nil
 }
 
+// DEFAULT_TIMEOUT_CONNECT is the default mysql.timeout-connect.
+const DEFAULT_TIMEOUT_CONNECT = "10s"
+
 const (
 	default_mysql_socket  = "/tmp/mysql.sock"
 	default_distro_socket = "/var/lib/mysql/mysql.sock"
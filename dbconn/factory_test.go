@@ -3,8 +3,10 @@
 package dbconn_test
 
 import (
+	"context"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 
@@ -90,3 +92,158 @@ func TestMake_Config(t *testing.T) {
 		t.Errorf("SELECT @@version: got %s, expected 8.0.34", got)
 	}
 }
+
+func TestMakeTransport(t *testing.T) {
+	cfg := config.MySQL{
+		Hostname: "db1",
+		Socket:   "/tmp/mysql.sock",
+	}
+	dbconn.SetConfig(cfg)
+
+	_, dsn, err := dbconn.MakeTransport("socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "@unix(/tmp/mysql.sock)/") {
+		t.Errorf("socket: got dsn %s, expected unix(/tmp/mysql.sock)", dsn)
+	}
+
+	_, dsn, err = dbconn.MakeTransport("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "@tcp(db1:3306)/") || strings.Contains(dsn, "tls=") {
+		t.Errorf("tcp: got dsn %s, expected tcp(db1:3306) without tls", dsn)
+	}
+
+	if _, _, err = dbconn.MakeTransport("tls"); err == nil {
+		t.Error("tls: got nil error without mysql.tls, expected an error")
+	}
+
+	// Default transport is still socket because it's set
+	_, dsn, err = dbconn.MakeTransport("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "@unix(/tmp/mysql.sock)/") {
+		t.Errorf("default: got dsn %s, expected unix(/tmp/mysql.sock)", dsn)
+	}
+
+	dbconn.SetConfig(config.MySQL{DSN: "finch:pass@tcp(db1)/"})
+	if _, _, err = dbconn.MakeTransport("tcp"); err == nil {
+		t.Error("got nil error with mysql.dsn, expected an error")
+	}
+}
+
+func TestMake_Params(t *testing.T) {
+	cfg := config.MySQL{
+		Hostname: "db1",
+		Params: map[string]string{
+			"interpolateParams": "true",
+			"readTimeout":       "5s",
+			"parseTime":         "false",    // overrides default parseTime=true
+			"time_zone":         "'+00:00'", // system var, escaped
+		},
+	}
+	dbconn.SetConfig(cfg)
+
+	_, dsn, err := dbconn.Make()
+	if err != nil {
+		t.Fatal(err)
+	}
+	my, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("%s: %s", dsn, err)
+	}
+	if !my.InterpolateParams {
+		t.Errorf("interpolateParams not set: %s", dsn)
+	}
+	if my.ReadTimeout != 5*time.Second {
+		t.Errorf("got readTimeout %s, expected 5s: %s", my.ReadTimeout, dsn)
+	}
+	if my.ParseTime {
+		t.Errorf("parseTime=true, expected false (overridden): %s", dsn)
+	}
+	if my.Params["time_zone"] != "'+00:00'" {
+		t.Errorf("got time_zone %s, expected '+00:00': %s", my.Params["time_zone"], dsn)
+	}
+}
+
+func TestMake_Timeouts(t *testing.T) {
+	// Default connect timeout, no read or write timeout
+	dbconn.SetConfig(config.MySQL{Hostname: "db1"})
+	_, dsn, err := dbconn.Make()
+	if err != nil {
+		t.Fatal(err)
+	}
+	my, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("%s: %s", dsn, err)
+	}
+	if my.Timeout != 10*time.Second || my.ReadTimeout != 0 || my.WriteTimeout != 0 {
+		t.Errorf("got timeouts %s, %s, %s; expected 10s, 0, 0: %s", my.Timeout, my.ReadTimeout, my.WriteTimeout, dsn)
+	}
+
+	dbconn.SetConfig(config.MySQL{
+		Hostname:       "db1",
+		TimeoutConnect: "2s",
+		TimeoutRead:    "30s",
+		TimeoutWrite:   "1m",
+	})
+	_, dsn, err = dbconn.Make()
+	if err != nil {
+		t.Fatal(err)
+	}
+	my, err = mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("%s: %s", dsn, err)
+	}
+	if my.Timeout != 2*time.Second || my.ReadTimeout != 30*time.Second || my.WriteTimeout != time.Minute {
+		t.Errorf("got timeouts %s, %s, %s; expected 2s, 30s, 1m: %s", my.Timeout, my.ReadTimeout, my.WriteTimeout, dsn)
+	}
+
+	// 0 disables the connect timeout
+	dbconn.SetConfig(config.MySQL{Hostname: "db1", TimeoutConnect: "0"})
+	_, dsn, err = dbconn.Make()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if my, _ = mysql.ParseDSN(dsn); my.Timeout != 0 {
+		t.Errorf("got timeout %s, expected 0: %s", my.Timeout, dsn)
+	}
+}
+
+func TestCreateDb(t *testing.T) {
+	if test.Build {
+		t.Skip("GitHub Actions build")
+	}
+
+	dsn, db, err := test.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Exec("DROP DATABASE IF EXISTS finch_create_db")
+	defer db.Exec("DROP DATABASE IF EXISTS finch_create_db")
+
+	dbconn.SetConfig(config.MySQL{DSN: strings.Replace(dsn, "/?", "/finch_create_db?", 1)})
+	got, err := dbconn.CreateDb(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "finch_create_db" {
+		t.Errorf("got db %s, expected finch_create_db", got)
+	}
+	n, err := test.OneRow(db, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name='finch_create_db'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != "1" {
+		t.Errorf("database finch_create_db not created")
+	}
+
+	// Database exists, so no error (IF NOT EXISTS)
+	if _, err := dbconn.CreateDb(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
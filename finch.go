@@ -22,7 +22,8 @@ const (
 
 	DEFAULT_SERVER_PORT = "33075"
 
-	COPY_NUMBER = `/*!copy-number*/`
+	COPY_NUMBER   = `/*!copy-number*/`
+	CLIENT_NUMBER = `/*!client-number*/`
 
 	NOOP_COLUMN = "_"
 
@@ -127,9 +128,14 @@ func RunLevelIsValid(s string) bool {
 }
 
 var (
-	CPUProfile io.Writer // --cpu-profile FILE
-	Debugging  = false
-	debugLog   = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
+	CPUProfile      io.Writer // --cpu-profile FILE
+	MemProfile      string    // --mem-profile FILE
+	BlockProfile    string    // --block-profile FILE
+	ResourceSummary bool      // --resource-summary
+	Explain         io.Writer // --explain FILE
+	ExplainAnalyze  bool      // --explain-analyze
+	Debugging       = false
+	debugLog        = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
 )
 
 func Debug(msg string, v ...interface{}) {
@@ -178,10 +184,21 @@ func Uint(s string) uint {
 	return uint(i)
 }
 
+// SystemParams are the built-in params ($sys.CPU_CORES) set at startup. TOTAL_RAM
+// (bytes) is not set if it can't be detected on the platform.
 var SystemParams = map[string]string{}
 
 func init() {
 	SystemParams["CPU_CORES"] = strconv.Itoa(runtime.NumCPU())
+	SystemParams["NUM_NUMA_NODES"] = strconv.Itoa(numNUMANodes())
+	SystemParams["GOOS"] = runtime.GOOS
+	SystemParams["GOARCH"] = runtime.GOARCH
+	if n := totalRAM(); n > 0 {
+		SystemParams["TOTAL_RAM"] = strconv.FormatUint(n, 10)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		SystemParams["HOSTNAME"] = hostname
+	}
 }
 
 const (
@@ -202,6 +219,7 @@ var MySQLErrorHandling = map[uint16]byte{
 	1290: Erollback | Econtinue, // read-only (server is running with the --read-only option so it cannot execute this statement)
 	1317: Econtinue,             // query killed (Query execution was interrupted)
 	1836: Erollback | Econtinue, // read-only (Running in read-only mode)
+	3024: Erollback | Econtinue, // MAX_EXECUTION_TIME exceeded (-- timeout server)
 }
 
 var ModifyDB func(*sql.DB, RunLevel)
@@ -1,6 +1,8 @@
 package finch_test
 
 import (
+	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/square/finch"
@@ -80,3 +82,19 @@ func TestRunLevelGreaterThan(t *testing.T) {
 		t.Errorf("Client changed but got false for ITER")
 	}
 }
+
+func TestSystemParams(t *testing.T) {
+	if finch.SystemParams["GOOS"] != runtime.GOOS || finch.SystemParams["GOARCH"] != runtime.GOARCH {
+		t.Errorf("got GOOS %s GOARCH %s, expected %s %s", finch.SystemParams["GOOS"], finch.SystemParams["GOARCH"], runtime.GOOS, runtime.GOARCH)
+	}
+	for _, k := range []string{"CPU_CORES", "NUM_NUMA_NODES"} {
+		if n, err := strconv.Atoi(finch.SystemParams[k]); err != nil || n < 1 {
+			t.Errorf("got %s=%s, expected an integer >= 1", k, finch.SystemParams[k])
+		}
+	}
+	if runtime.GOOS == "linux" {
+		if n, err := strconv.ParseUint(finch.SystemParams["TOTAL_RAM"], 10, 64); err != nil || n == 0 {
+			t.Errorf("got TOTAL_RAM=%s, expected bytes > 0", finch.SystemParams["TOTAL_RAM"])
+		}
+	}
+}
@@ -10,8 +10,10 @@ require (
 	github.com/go-sql-driver/mysql v1.7.1
 	github.com/go-test/deep v1.0.8
 	github.com/rs/xid v1.4.0
+	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/time v0.3.0
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,147 @@
+// Copyright 2024 Block, Inc.
+
+package limit
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// MaxArrivalQueue is the maximum number of seconds of arrivals (at the configured
+// rate) that Arrivals queues when no client is free. Arrivals beyond the queue
+// are dropped and counted (Pacing) to bound memory when MySQL can't keep up.
+var MaxArrivalQueue uint = 60
+
+// MaxArrivalQueueSize is the maximum number of arrivals that Arrivals queues
+// regardless of rate, so memory is bounded at high rates, too: 1M arrivals
+// is about 24 MB. It's less than MaxArrivalQueue seconds of arrivals at rates
+// greater than about 16,666/s.
+var MaxArrivalQueueSize uint = 1000000
+
+// Arrivals is an open-loop arrival schedule (config.workload.arrival-rate): it
+// schedules iterations at a fixed rate whether or not previous iterations have
+// completed. Each arrival is the intended start time of an iteration. If a
+// client is free, it receives the arrival immediately (Next); else the arrival
+// is queued and Arrivals signals an unconnected client to connect (Spawn), so
+// connections are made as needed. A client receiving an arrival later than its
+// intended start time records the difference as queueing delay.
+//
+// Unlike Rate, which clients wait on (closed-loop), Arrivals does not wait on
+// clients, so load doesn't drop when MySQL is slow. Response times still don't
+// include queueing delay, which clients record separately.
+type Arrivals struct {
+	perSecond uint
+	c         chan time.Time
+	spawn     chan bool
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+	allowed   uint64 // atomic
+	dropped   uint64 // atomic
+}
+
+func NewArrivals(perSecond uint) *Arrivals {
+	if perSecond == 0 {
+		return nil
+	}
+	finch.Debug("new arrivals: %d/s", perSecond)
+	return &Arrivals{
+		perSecond: perSecond,
+		c:         make(chan time.Time), // unbuffered: only free clients receive
+		spawn:     make(chan bool),
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+}
+
+// Next returns the channel on which clients receive arrivals.
+func (a *Arrivals) Next() <-chan time.Time {
+	return a.c
+}
+
+// Spawn returns the channel on which unconnected clients are signaled to
+// connect because arrivals are queued.
+func (a *Arrivals) Spawn() <-chan bool {
+	return a.spawn
+}
+
+// Pacing returns the number of arrivals scheduled and the number dropped
+// because the queue was full. See Rate.Pacing.
+func (a *Arrivals) Pacing() (allowed, dropped uint64) {
+	return atomic.LoadUint64(&a.allowed), atomic.LoadUint64(&a.dropped)
+}
+
+// Start starts scheduling arrivals until Stop is called. It's called when the
+// stage starts clients, not when Arrivals is created, so arrivals don't queue
+// while the stage is preparing.
+func (a *Arrivals) Start() {
+	go a.run()
+}
+
+// Stop stops scheduling arrivals and waits for the scheduler to stop.
+func (a *Arrivals) Stop() {
+	close(a.stopChan)
+	<-a.doneChan
+}
+
+func (a *Arrivals) run() {
+	defer close(a.doneChan)
+	interval := time.Second / time.Duration(a.perSecond)
+	if interval < 1 {
+		interval = 1 // > 1e9/s (rejected by config validation) would be 0: infinite loop
+	}
+	maxQueue := int(a.perSecond * MaxArrivalQueue)
+	if maxQueue > int(MaxArrivalQueueSize) {
+		maxQueue = int(MaxArrivalQueueSize)
+	}
+	queue := []time.Time{}
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		// Arrivals due at the last tick or sent to a client
+		var c chan time.Time
+		var head time.Time
+		if len(queue) > 0 {
+			c = a.c
+			head = queue[0]
+		}
+		select {
+		case now := <-timer.C:
+			for !next.After(now) {
+				atomic.AddUint64(&a.allowed, 1)
+				if len(queue) < maxQueue {
+					queue = append(queue, next)
+				} else {
+					atomic.AddUint64(&a.dropped, 1)
+				}
+				next = next.Add(interval)
+			}
+			timer.Reset(time.Until(next))
+		case c <- head:
+			queue = queue[1:]
+			continue
+		case <-a.stopChan:
+			return
+		}
+
+		// Hand off arrivals to free clients, if any, then signal an unconnected
+		// client if arrivals are still queued
+		for len(queue) > 0 {
+			select {
+			case a.c <- queue[0]:
+				queue = queue[1:]
+				continue
+			default:
+			}
+			break
+		}
+		if len(queue) > 0 {
+			select {
+			case a.spawn <- true:
+			default:
+			}
+		}
+	}
+}
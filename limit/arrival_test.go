@@ -0,0 +1,80 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+func TestArrivals(t *testing.T) {
+	if limit.NewArrivals(0) != nil {
+		t.Error("NewArrivals(0) returned non-nil, expected nil for no arrival rate")
+	}
+
+	a := limit.NewArrivals(1000) // 1 arrival/ms
+	t0 := time.Now()
+	a.Start()
+
+	// No client is receiving arrivals, so they queue and Arrivals signals an
+	// unconnected client to connect
+	select {
+	case <-a.Spawn():
+	case <-time.After(time.Second):
+		t.Fatal("no spawn signal after 1s, expected spawn when arrivals are queued")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// First arrival is the intended start time, so it's queued about 20ms
+	var arrival time.Time
+	select {
+	case arrival = <-a.Next():
+	case <-time.After(time.Second):
+		t.Fatal("no arrival after 1s")
+	}
+	if d := time.Since(arrival); d < 15*time.Millisecond {
+		t.Errorf("first arrival queued %s, expected about 20ms", d)
+	}
+	if arrival.Before(t0) {
+		t.Errorf("first arrival %s before start %s", arrival, t0)
+	}
+
+	// Queued arrivals are received in order
+	next := <-a.Next()
+	if !next.After(arrival) {
+		t.Errorf("second arrival %s not after first %s", next, arrival)
+	}
+	a.Stop()
+
+	allowed, dropped := a.Pacing()
+	if allowed < 15 || dropped != 0 {
+		t.Errorf("got %d allowed and %d dropped, expected >= 15 allowed and 0 dropped", allowed, dropped)
+	}
+
+	// Queue full: all arrivals dropped
+	limit.MaxArrivalQueue = 0
+	defer func() { limit.MaxArrivalQueue = 60 }()
+	a = limit.NewArrivals(1000)
+	a.Start()
+	time.Sleep(10 * time.Millisecond)
+	a.Stop()
+	allowed, dropped = a.Pacing()
+	if allowed == 0 || dropped != allowed {
+		t.Errorf("got %d allowed and %d dropped, expected all arrivals dropped", allowed, dropped)
+	}
+
+	// Queue size limit: all arrivals dropped even though 60s are allowed
+	limit.MaxArrivalQueue = 60
+	limit.MaxArrivalQueueSize = 0
+	defer func() { limit.MaxArrivalQueueSize = 1000000 }()
+	a = limit.NewArrivals(1000)
+	a.Start()
+	time.Sleep(10 * time.Millisecond)
+	a.Stop()
+	allowed, dropped = a.Pacing()
+	if allowed == 0 || dropped != allowed {
+		t.Errorf("got %d allowed and %d dropped, expected all arrivals dropped by queue size", allowed, dropped)
+	}
+}
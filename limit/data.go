@@ -15,7 +15,40 @@ import (
 
 type Data interface {
 	Affected(n int64)
-	More(*sql.Conn) bool
+	More(context.Context, *sql.Conn) bool
+	Progress() []Progress
+}
+
+// Progress is a snapshot of how close a limit (Progressor) is to its max. It's
+// reported in stats (stats.Instance.Progress) so progress from remote instances
+// is visible on the server, too.
+type Progress struct {
+	Limit   string  // "rows", "table-size db.tbl", "database-size db", "runtime", or "iter GROUP"
+	Unit    string  // "rows", "bytes", "seconds", or "iterations"
+	N       uint64  // rows, bytes, seconds, or iterations so far
+	Max     uint64  // limit
+	Percent float64 // N / Max * 100
+	Rate    float64 // N per second
+	ETA     float64 // seconds until Max at Rate, or 0 if unknown
+}
+
+func newProgress(limit, unit string, n, max uint64, d time.Duration, delta uint64) Progress {
+	p := Progress{
+		Limit: limit,
+		Unit:  unit,
+		N:     n,
+		Max:   max,
+	}
+	if max > 0 {
+		p.Percent = float64(n) / float64(max) * 100
+	}
+	if d > 0 {
+		p.Rate = float64(delta) / d.Seconds()
+	}
+	if p.Rate > 0 && n < max {
+		p.ETA = float64(max-n) / p.Rate
+	}
+	return p
 }
 
 // --------------------------------------------------------------------------
@@ -55,19 +88,21 @@ func (lm or) Affected(n int64) {
 	lm.b.Affected(n)
 }
 
-func (lm or) More(conn *sql.Conn) bool {
-	return lm.a.More(conn) && lm.b.More(conn)
+func (lm or) More(ctx context.Context, conn *sql.Conn) bool {
+	return lm.a.More(ctx, conn) && lm.b.More(ctx, conn)
+}
+
+func (lm or) Progress() []Progress {
+	return append(lm.a.Progress(), lm.b.Progress()...)
 }
 
 // --------------------------------------------------------------------------
 
 type Rows struct {
-	max int64
-	n   int64
-	p   float64 // = size / max * 100
-	r   uint    // report p every r%
-	t   time.Time
-	pn  int64
+	max    int64
+	n      int64
+	offset int64
+	t      time.Time // first call to More
 	*sync.Mutex
 }
 
@@ -78,11 +113,10 @@ func NewRows(max, offset int64) *Rows {
 		return nil
 	}
 	lm := &Rows{
-		max:   max,
-		Mutex: &sync.Mutex{},
-		r:     5,
-		n:     offset,
-		pn:    offset,
+		max:    max,
+		Mutex:  &sync.Mutex{},
+		n:      offset,
+		offset: offset,
 	}
 	return lm
 }
@@ -90,22 +124,10 @@ func NewRows(max, offset int64) *Rows {
 func (lm *Rows) Affected(n int64) {
 	lm.Lock()
 	lm.n += n
-	// Report progress every r%
-	p := float64(lm.n) / float64(lm.max) * 100
-	if p-lm.p > float64(lm.r) {
-		d := time.Now().Sub(lm.t)
-		rate := float64(lm.n-lm.pn) / d.Seconds()
-		eta := time.Duration(float64(lm.max-lm.n)/rate) * time.Second
-		log.Printf("%s / %s = %.1f%% in %s: %s rows/s (ETA %s)\n",
-			humanize.Comma(lm.n), humanize.Comma(lm.max), p, d.Round(time.Second), humanize.Comma(int64(rate)), eta)
-		lm.p = p
-		lm.t = time.Now()
-		lm.pn = lm.n
-	}
 	lm.Unlock()
 }
 
-func (lm *Rows) More(_ *sql.Conn) bool {
+func (lm *Rows) More(_ context.Context, _ *sql.Conn) bool {
 	lm.Lock()
 	if lm.t.IsZero() {
 		lm.t = time.Now()
@@ -115,23 +137,36 @@ func (lm *Rows) More(_ *sql.Conn) bool {
 	return more
 }
 
+func (lm *Rows) Progress() []Progress {
+	lm.Lock()
+	defer lm.Unlock()
+	var d time.Duration
+	if !lm.t.IsZero() {
+		d = time.Now().Sub(lm.t)
+	}
+	return []Progress{newProgress("rows", "rows", uint64(lm.n), uint64(lm.max), d, uint64(lm.n-lm.offset))}
+}
+
 // --------------------------------------------------------------------------
 
 type SizeFunc func(*sql.Conn) (uint64, error)
 
 type Size struct {
-	max     uint64 // 200000000, converted from maxStr
-	maxStr  string // 200MB, exactly as specified by user
-	db      string // database-size: DB maxStr
-	tbl     string // table-size: TABLE maxStr
-	query   string
-	analyze string
-	n       uint    // calls to More
-	m       uint    // how often to check stats: n % m
-	p       float64 // = size / max * 100
-	r       uint    // report p every r%
-	t       time.Time
-	bytes   uint64
+	max      uint64 // 200000000, converted from maxStr
+	maxStr   string // 200MB, exactly as specified by user
+	db       string // database-size: DB maxStr
+	tbl      string // table-size: TABLE maxStr
+	query    string
+	analyze  string
+	n        uint      // calls to More
+	m        uint      // how often to check stats: n % m
+	t0       time.Time // when bytes0 was sampled
+	t        time.Time // when bytes was sampled
+	bytes    uint64    // last size
+	bytes0   uint64    // first size, to calculate rate
+	sampled  bool      // bytes0 and t0 are set
+	checking bool      // a client is checking the size (without the lock)
+	done     bool      // size reached max
 	*sync.Mutex
 }
 
@@ -146,17 +181,14 @@ func NewSize(max uint64, maxStr string, db, tbl string) *Size {
 	// But if max size is small, <=1G, that will probably be written very quickly,
 	// so check every 3rd call to avoid surpassing the max by too much.
 	var m uint = 5
-	var r uint = 5
 	if max <= 1073741824 { // 1G
 		m = 3
-		r = 10
 	}
 	if max >= 107374182400 { // 100 GB
 		m = 1000
-		r = 2
 	}
 
-	finch.Debug("limit size db %s tbl %s = %d bytes (m=%d r=%d)", db, tbl, max, m, r)
+	finch.Debug("limit size db %s tbl %s = %d bytes (m=%d)", db, tbl, max, m)
 	lm := &Size{
 		db:     db,
 		tbl:    tbl,
@@ -164,7 +196,6 @@ func NewSize(max uint64, maxStr string, db, tbl string) *Size {
 		maxStr: maxStr,
 		Mutex:  &sync.Mutex{},
 		m:      m,
-		r:      r,
 	}
 	return lm
 }
@@ -172,27 +203,30 @@ func NewSize(max uint64, maxStr string, db, tbl string) *Size {
 func (lm *Size) Affected(n int64) {
 }
 
-func (lm *Size) More(conn *sql.Conn) bool {
-	lm.Lock()
-	defer lm.Unlock()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (lm *Size) More(ctx context.Context, conn *sql.Conn) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Set queries on first call
+	lm.Lock()
+	if lm.done {
+		lm.Unlock()
+		return false
+	}
+
+	// Set queries on first call. Other clients wait because they need the queries.
 	if lm.query == "" {
 		lm.query = "SELECT COALESCE(data_length + index_length, 0) AS bytes FROM information_schema.TABLES WHERE "
 		if lm.db != "" {
-			log.Printf("Database size limit: %s %s (progress report every %d%%)", lm.db, lm.maxStr, lm.r)
+			log.Printf("Database size limit: %s %s", lm.db, lm.maxStr)
 			lm.query += "table_schema='" + lm.db + "'"
 
 			var tbls []string
 			rows, err := conn.QueryContext(ctx, "SHOW FULL TABLES")
 			if err != nil {
+				lm.Unlock()
 				log.Printf("Error running SHOW FULL TABLES: %s", err)
 				return false
 			}
-			rows.Close()
 			for rows.Next() {
 				var name, base string
 				err = rows.Scan(&name, &base)
@@ -204,11 +238,13 @@ func (lm *Size) More(conn *sql.Conn) bool {
 				}
 				tbls = append(tbls, name)
 			}
+			rows.Close()
 			lm.analyze = "ANALYZE TABLE " + strings.Join(tbls, ", ")
 		} else {
-			log.Printf("Table size limit: %s %s (progress report every %d%%)", lm.tbl, lm.maxStr, lm.r)
+			log.Printf("Table size limit: %s %s", lm.tbl, lm.maxStr)
 			err := conn.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&lm.db)
 			if err != nil {
+				lm.Unlock()
 				log.Printf("Error getting current database: %s", err)
 				return false
 			}
@@ -217,41 +253,66 @@ func (lm *Size) More(conn *sql.Conn) bool {
 		}
 		finch.Debug(lm.query)
 		finch.Debug(lm.analyze)
-
-		lm.t = time.Now()
 	}
 
-	// Every few calls, run ANALYZE TABLE to update the stats, then fech latest size
+	// Every few calls, run ANALYZE TABLE to update the stats, then fech latest size.
+	// Only one client checks at a time, and it checks without the lock so other
+	// clients aren't blocked by the queries; they presume there's more to load.
 	lm.n++
-	if lm.n%lm.m != 0 {
+	if lm.n%lm.m != 0 || lm.checking {
+		lm.Unlock()
 		return true // not time to check; presume there's more to load
 	}
+	lm.checking = true
+	analyze, query := lm.analyze, lm.query
+	lm.Unlock()
 
-	if _, err := conn.ExecContext(ctx, lm.analyze); err != nil {
+	var bytes uint64
+	_, err := conn.ExecContext(ctx, analyze)
+	if err != nil {
 		log.Printf("Error running ANALYZE TABLE: %s", err)
-		return false
+	} else if err = conn.QueryRowContext(ctx, query).Scan(&bytes); err != nil {
+		log.Printf("Error query data size: %s", err)
 	}
+	t := time.Now()
 
-	// Get database/table size in bytes
-	var bytes uint64
-	err := conn.QueryRowContext(ctx, lm.query).Scan(&bytes)
+	lm.Lock()
+	defer lm.Unlock()
+	lm.checking = false
 	if err != nil {
-		log.Printf("Error query data size: %s", err)
 		return false
 	}
 
-	// Report progress every r%
-	p := float64(bytes) / float64(lm.max) * 100
-	if p-lm.p > float64(lm.r) {
-		d := time.Now().Sub(lm.t)
-		rate := float64(bytes-lm.bytes) / d.Seconds()
-		eta := time.Duration(float64(lm.max-bytes)/rate) * time.Second
-		log.Printf("%s / %s = %.1f%% in %s: %s/s (ETA %s)\n",
-			humanize.Bytes(bytes), lm.maxStr, p, d.Round(time.Second), humanize.Bytes(uint64(rate)), eta)
-		lm.p = p
-		lm.t = time.Now()
-		lm.bytes = bytes
+	// Save size for Progress, which is reported in stats. The rate is the
+	// growth between the first and last samples, so time is measured at the
+	// same moments, not from when the queries were built.
+	if !lm.sampled {
+		lm.bytes0, lm.t0 = bytes, t
+		lm.sampled = true
+	}
+	lm.bytes, lm.t = bytes, t
+	finch.Debug("%s / %s", humanize.Bytes(bytes), lm.maxStr)
+
+	if bytes >= lm.max {
+		lm.done = true
 	}
+	return !lm.done
+}
 
-	return bytes < lm.max
+func (lm *Size) Progress() []Progress {
+	lm.Lock()
+	defer lm.Unlock()
+	var d time.Duration
+	if lm.sampled {
+		d = lm.t.Sub(lm.t0)
+	}
+	name := "table-size " + lm.tbl
+	if lm.tbl == "" {
+		name = "database-size " + lm.db
+	}
+	var delta uint64
+	if lm.bytes > lm.bytes0 {
+		delta = lm.bytes - lm.bytes0
+	}
+	return []Progress{newProgress(name, "bytes", lm.bytes, lm.max, d, delta)}
 }
@@ -1,6 +1,7 @@
 package limit_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/square/finch/limit"
@@ -13,18 +14,44 @@ func TestOr(t *testing.T) {
 	dl := limit.Or(r1, r2)
 
 	// We can pass nil for the *sql.DB because Rows doesn't use it for More
-	if dl.More(nil) == false {
+	if dl.More(context.Background(), nil) == false {
 		t.Error("More false, expected true before anything called")
 	}
 
 	r1.Affected(1) // 1/100
 	r2.Affected(1) // 1/50
-	if dl.More(nil) != true {
+	if dl.More(context.Background(), nil) != true {
 		t.Error("More false, expected true before either limit reached")
 	}
 
 	r2.Affected(49) // 50/50
-	if dl.More(nil) != false {
+	if dl.More(context.Background(), nil) != false {
 		t.Error("More true, expected false when one limit reached")
 	}
 }
+
+func TestRows_Progress(t *testing.T) {
+	lm := limit.NewRows(100, 0)
+	lm.More(context.Background(), nil) // start timer
+	lm.Affected(25)
+
+	p := lm.Progress()
+	if len(p) != 1 {
+		t.Fatalf("got %d progress, expected 1", len(p))
+	}
+	if p[0].Limit != "rows" || p[0].Unit != "rows" {
+		t.Errorf("got limit %s unit %s, expected rows rows", p[0].Limit, p[0].Unit)
+	}
+	if p[0].N != 25 || p[0].Max != 100 {
+		t.Errorf("got N=%d Max=%d, expected 25 and 100", p[0].N, p[0].Max)
+	}
+	if p[0].Percent != 25.0 {
+		t.Errorf("got %f percent, expected 25.0", p[0].Percent)
+	}
+
+	// Or combines progress from both limits
+	dl := limit.Or(lm, limit.NewRows(50, 0))
+	if n := len(dl.Progress()); n != 2 {
+		t.Errorf("got %d progress from Or, expected 2", n)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 Block, Inc.
+
+package limit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// LeaseFunc leases up to n of max for the given key. It returns the number
+// leased, which is less than n when max is almost reached, and zero when max
+// has been reached. On the server, it's Arbiter.Lease; on remote compute
+// instances, it's a call to the server API, which returns zero if ctx is done.
+type LeaseFunc func(ctx context.Context, key string, max, n uint64) uint64
+
+// BlockFunc leases the next block of keys for the given key. It returns the
+// block number: 0, 1, 2, and so on. Every block number is leased only once,
+// so generators on different compute instances that use the block number to
+// compute a key range never generate the same keys. On the server, it's
+// Arbiter.Block; on remote compute instances, it's a call to the server API.
+type BlockFunc func(key string) (uint64, error)
+
+// Arbiter arbitrates limits shared by all compute instances (local and remote).
+// The server creates one Arbiter per run, and all instances lease from it so
+// the aggregate limit is respected. Leases are per stage (see NextStage), but
+// key blocks are per run so later stages don't reuse keys from earlier stages.
+type Arbiter struct {
+	*sync.Mutex
+	leased map[string]uint64
+	blocks map[string]uint64
+}
+
+func NewArbiter() *Arbiter {
+	return &Arbiter{
+		Mutex:  &sync.Mutex{},
+		leased: map[string]uint64{},
+		blocks: map[string]uint64{},
+	}
+}
+
+// NextStage returns an Arbiter for the next stage: leases are reset, but key
+// blocks continue from where the previous stage stopped.
+func (a *Arbiter) NextStage() *Arbiter {
+	return &Arbiter{
+		Mutex:  a.Mutex,
+		leased: map[string]uint64{},
+		blocks: a.blocks,
+	}
+}
+
+// Lease implements a LeaseFunc.
+func (a *Arbiter) Lease(_ context.Context, key string, max, n uint64) uint64 {
+	a.Lock()
+	defer a.Unlock()
+	leased := a.leased[key]
+	if leased >= max {
+		return 0
+	}
+	if leased+n > max {
+		n = max - leased
+	}
+	a.leased[key] = leased + n
+	finch.Debug("lease %s: %d (%d/%d)", key, n, leased+n, max)
+	return n
+}
+
+// Block implements a BlockFunc.
+func (a *Arbiter) Block(key string) (uint64, error) {
+	a.Lock()
+	defer a.Unlock()
+	b := a.blocks[key]
+	a.blocks[key] = b + 1
+	finch.Debug("block %s: %d", key, b)
+	return b, nil
+}
+
+// Share returns lm with row limits, if any, shared by all compute instances.
+// Size limits are not changed because they measure the actual database or table
+// size, which is the same for all instances.
+func Share(lm Data, key string, lease LeaseFunc) Data {
+	switch v := lm.(type) {
+	case *Rows:
+		return NewSharedRows(v, key, lease)
+	case or:
+		return Or(Share(v.a, key, lease), Share(v.b, key, lease))
+	}
+	return lm
+}
+
+// --------------------------------------------------------------------------
+
+// SharedRows is a Rows limit shared by all compute instances. Instead of counting
+// only rows affected by local clients, it leases batches of rows from the server
+// (by calling a LeaseFunc). When the server has no more rows to lease, the limit
+// is reached.
+//
+// Only one client leases at a time, and it leases without the lock because the
+// LeaseFunc can be a slow HTTP call. Other clients that need rows wait for the
+// lease (cond) because they cannot write rows that aren't leased.
+type SharedRows struct {
+	key     string
+	max     uint64 // max - offset of original Rows
+	batch   uint64 // rows to lease
+	lease   LeaseFunc
+	leased  uint64 // total rows leased by this instance
+	n       uint64 // rows affected by this instance
+	done    bool   // no more rows to lease
+	leasing bool   // a client is leasing (without the lock)
+	cond    *sync.Cond
+	t       time.Time
+	*sync.Mutex
+}
+
+var _ Data = &SharedRows{}
+
+func NewSharedRows(lm *Rows, key string, lease LeaseFunc) *SharedRows {
+	max := uint64(lm.max - lm.offset)
+	batch := max / 100 // 1% of rows per lease
+	if batch == 0 {
+		batch = 1
+	}
+	mu := &sync.Mutex{}
+	return &SharedRows{
+		key:   key,
+		max:   max,
+		batch: batch,
+		lease: lease,
+		cond:  sync.NewCond(mu),
+		Mutex: mu,
+	}
+}
+
+func (lm *SharedRows) Affected(n int64) {
+	lm.Lock()
+	lm.n += uint64(n)
+	lm.Unlock()
+}
+
+func (lm *SharedRows) More(ctx context.Context, _ *sql.Conn) bool {
+	lm.Lock()
+	defer lm.Unlock()
+	if lm.t.IsZero() {
+		lm.t = time.Now()
+	}
+	for {
+		if lm.n < lm.leased {
+			return true // have rows from last lease
+		}
+		if lm.done {
+			return false
+		}
+		if !lm.leasing {
+			break
+		}
+		lm.cond.Wait() // another client is leasing
+	}
+	lm.leasing = true
+	lm.Unlock()
+	n := lm.lease(ctx, lm.key, lm.max, lm.batch)
+	lm.Lock()
+	lm.leasing = false
+	lm.cond.Broadcast()
+	if n == 0 {
+		lm.done = true
+		return false
+	}
+	lm.leased += n
+	return true
+}
+
+func (lm *SharedRows) Progress() []Progress {
+	lm.Lock()
+	defer lm.Unlock()
+	var d time.Duration
+	if !lm.t.IsZero() {
+		d = time.Now().Sub(lm.t)
+	}
+	return []Progress{newProgress("rows (shared)", "rows", lm.n, lm.max, d, lm.n)}
+}
+
+// --------------------------------------------------------------------------
+
+// SharedIter is an iteration limit shared by all compute instances. Like
+// SharedRows, it leases batches of iterations from the server. Clients call
+// More once per iteration; when it returns false, the aggregate limit has
+// been reached. Like SharedRows, only one client leases at a time, without
+// the lock.
+type SharedIter struct {
+	key     string
+	max     uint64
+	batch   uint64
+	lease   LeaseFunc
+	avail   uint64 // iterations leased but not yet run
+	done    bool   // no more iterations to lease
+	leasing bool   // a client is leasing (without the lock)
+	cond    *sync.Cond
+	*sync.Mutex
+}
+
+func NewSharedIter(key string, max uint64, lease LeaseFunc) *SharedIter {
+	if max == 0 {
+		return nil
+	}
+	batch := max / 100 // 1% of iterations per lease
+	if batch == 0 {
+		batch = 1
+	}
+	mu := &sync.Mutex{}
+	return &SharedIter{
+		key:   key,
+		max:   max,
+		batch: batch,
+		lease: lease,
+		cond:  sync.NewCond(mu),
+		Mutex: mu,
+	}
+}
+
+// More returns true if the caller can run one more iteration. ctx is passed
+// to the LeaseFunc, so the caller doesn't wait for a lease after ctx is done.
+func (lm *SharedIter) More(ctx context.Context) bool {
+	lm.Lock()
+	defer lm.Unlock()
+	for lm.avail == 0 {
+		if lm.done {
+			return false
+		}
+		if !lm.leasing {
+			lm.leasing = true
+			lm.Unlock()
+			n := lm.lease(ctx, lm.key, lm.max, lm.batch)
+			lm.Lock()
+			lm.leasing = false
+			lm.cond.Broadcast()
+			if n == 0 {
+				lm.done = true
+				return false
+			}
+			lm.avail += n
+			break
+		}
+		lm.cond.Wait() // another client is leasing
+	}
+	lm.avail--
+	return true
+}
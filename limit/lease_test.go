@@ -0,0 +1,133 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+func TestSharedRows(t *testing.T) {
+	// Two instances sharing 10 rows through one arbiter
+	a := limit.NewArbiter()
+	i1 := limit.Share(limit.NewRows(10, 0), "t/0", a.Lease)
+	i2 := limit.Share(limit.NewRows(10, 0), "t/0", a.Lease)
+
+	n := 0
+	for i1.More(context.Background(), nil) || i2.More(context.Background(), nil) {
+		if i1.More(context.Background(), nil) {
+			i1.Affected(1)
+			n++
+		}
+		if i2.More(context.Background(), nil) {
+			i2.Affected(1)
+			n++
+		}
+		if n > 20 {
+			t.Fatal("shared limit not reached after 20 rows")
+		}
+	}
+	if n != 10 {
+		t.Errorf("inserted %d rows, expected 10", n)
+	}
+
+	// Arbiter should not lease more
+	if got := a.Lease(context.Background(), "t/0", 10, 1); got != 0 {
+		t.Errorf("leased %d after limit reached, expected 0", got)
+	}
+}
+
+func TestSharedIter(t *testing.T) {
+	a := limit.NewArbiter()
+	i1 := limit.NewSharedIter("iter/1", 250, a.Lease)
+	i2 := limit.NewSharedIter("iter/1", 250, a.Lease)
+
+	n := 0
+	for {
+		m1 := i1.More(context.Background())
+		m2 := i2.More(context.Background())
+		if m1 {
+			n++
+		}
+		if m2 {
+			n++
+		}
+		if !m1 && !m2 {
+			break
+		}
+	}
+	if n != 250 {
+		t.Errorf("ran %d iterations, expected 250", n)
+	}
+}
+
+func TestSharedRows_SlowLease(t *testing.T) {
+	// Lease blocks until released, like a slow server
+	release := make(chan struct{})
+	calls := 0
+	lease := func(ctx context.Context, key string, max, n uint64) uint64 {
+		calls++
+		<-release
+		return n
+	}
+	lm := limit.NewSharedRows(limit.NewRows(100, 0), "t/0", lease)
+
+	// Two clients need rows: one leases, the other waits for that lease
+	more := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() { more <- lm.More(context.Background(), nil) }()
+	}
+
+	// The lock isn't held during the lease, so other calls don't block
+	done := make(chan struct{})
+	go func() {
+		lm.Affected(0)
+		lm.Progress()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Affected and Progress blocked while leasing")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if !<-more {
+			t.Errorf("More returned false, expected true")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("lease called %d times, expected 1", calls)
+	}
+}
+
+func TestArbiter_Block(t *testing.T) {
+	a := limit.NewArbiter()
+	for i := uint64(0); i < 3; i++ {
+		b, err := a.Block("@id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != i {
+			t.Errorf("got block %d, expected %d", b, i)
+		}
+	}
+	// Blocks are leased per key
+	if b, _ := a.Block("@other"); b != 0 {
+		t.Errorf("got block %d for new key, expected 0", b)
+	}
+
+	// Next stage: leases are reset, but blocks continue
+	a.Lease(context.Background(), "k", 10, 10)
+	a = a.NextStage()
+	if n := a.Lease(context.Background(), "k", 10, 10); n != 10 {
+		t.Errorf("leased %d in next stage, expected 10", n)
+	}
+	if b, _ := a.Block("@id"); b != 3 {
+		t.Errorf("got block %d in next stage, expected 3", b)
+	}
+}
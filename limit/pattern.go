@@ -0,0 +1,129 @@
+// Copyright 2024 Block, Inc.
+
+package limit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// PatternFreq is how often a Pattern changes the load.
+var PatternFreq = 100 * time.Millisecond
+
+// Pattern is a load pattern (config.stage.pattern or config.stage.workload.pattern)
+// that varies load over time. With on and off durations, clients run for on,
+// pause for off, and repeat. With a sine period, rates (QPS and TPS limits) follow
+// a sine wave between min percent and 100% of their configured rate. Both can be
+// used together: the sine wave continues while clients are paused.
+type Pattern struct {
+	on, off  time.Duration
+	sine     time.Duration
+	min      float64   // sine wave trough, 0 to 1
+	rates    []Rate    // adjusted by sine wave
+	gate     *Throttle // pauses clients during off, or nil if no on/off
+	stopChan chan struct{}
+	stopOnce sync.Once
+	start    sync.Once
+}
+
+// NewPattern returns a load pattern that's not started. On and off must be both
+// zero (no on/off) or both greater than zero. If sine is zero, rates are not
+// adjusted. Min is the percent of rates at the sine wave trough.
+func NewPattern(on, off, sine time.Duration, min uint, rates []Rate) *Pattern {
+	finch.Debug("new pattern: on %s off %s sine %s min %d%%", on, off, sine, min)
+	p := &Pattern{
+		on:       on,
+		off:      off,
+		sine:     sine,
+		min:      float64(min) / 100,
+		stopChan: make(chan struct{}),
+	}
+	if on > 0 && off > 0 {
+		p.gate = NewThrottle(0) // pause while off
+	}
+	if sine > 0 {
+		for _, r := range rates {
+			if r != nil {
+				p.rates = append(p.rates, r)
+			}
+		}
+	}
+	return p
+}
+
+// Gate returns the throttle that clients wait on before each query, or nil if
+// the pattern doesn't pause clients (no on/off).
+func (p *Pattern) Gate() *Throttle {
+	return p.gate
+}
+
+// Start starts the pattern. It's called when clients start, and only the first
+// call starts the pattern. The pattern stops when ctx is done or Stop is called
+// so paused clients aren't blocked when they should stop.
+func (p *Pattern) Start(ctx context.Context) {
+	p.start.Do(func() { go p.run(ctx) })
+}
+
+// Stop stops the pattern, unblocks paused clients, and restores rates.
+func (p *Pattern) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+		if p.gate != nil {
+			p.gate.Stop()
+		}
+	})
+}
+
+// Percent returns the percent of load d after the pattern starts: 0 while off,
+// else the sine wave percent of rates, or 100 if no sine wave. The sine wave
+// starts midway between min and 100% and increases.
+func (p *Pattern) Percent(d time.Duration) byte {
+	if p.gate != nil && d%(p.on+p.off) >= p.on {
+		return 0
+	}
+	if p.sine == 0 {
+		return 100
+	}
+	f := p.min + (1-p.min)*(1+math.Sin(2*math.Pi*float64(d)/float64(p.sine)))/2
+	pct := byte(math.Round(f * 100))
+	if pct < 1 {
+		pct = 1
+	}
+	return pct
+}
+
+func (p *Pattern) run(ctx context.Context) {
+	defer func() {
+		for _, r := range p.rates {
+			r.Adjust(100)
+		}
+	}()
+	start := time.Now()
+	ticker := time.NewTicker(PatternFreq)
+	defer ticker.Stop()
+	var last byte = 100
+	for {
+		pct := p.Percent(time.Since(start))
+		if p.gate != nil {
+			p.gate.Set(pct == 0)
+		}
+		if pct > 0 && pct != last {
+			for _, r := range p.rates {
+				r.Adjust(pct)
+			}
+			last = pct
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			p.Stop()
+			return
+		case <-p.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+func TestPattern_Percent(t *testing.T) {
+	// On/off: 0 while off
+	p := limit.NewPattern(30*time.Second, 10*time.Second, 0, 0, nil)
+	defer p.Stop()
+	for _, tc := range []struct {
+		d   time.Duration
+		pct byte
+	}{
+		{0, 100},
+		{29 * time.Second, 100},
+		{30 * time.Second, 0},
+		{39 * time.Second, 0},
+		{40 * time.Second, 100},
+	} {
+		if got := p.Percent(tc.d); got != tc.pct {
+			t.Errorf("on/off at %s: got %d%%, expected %d%%", tc.d, got, tc.pct)
+		}
+	}
+
+	// Sine: from min 20% to 100%, starting midway (60%) and increasing
+	p = limit.NewPattern(0, 0, 60*time.Second, 20, nil)
+	defer p.Stop()
+	for _, tc := range []struct {
+		d   time.Duration
+		pct byte
+	}{
+		{0, 60},
+		{15 * time.Second, 100},
+		{30 * time.Second, 60},
+		{45 * time.Second, 20},
+		{60 * time.Second, 60},
+	} {
+		if got := p.Percent(tc.d); got != tc.pct {
+			t.Errorf("sine at %s: got %d%%, expected %d%%", tc.d, got, tc.pct)
+		}
+	}
+	if p.Gate() != nil {
+		t.Error("Gate not nil without on/off, expected nil")
+	}
+}
+
+func TestPattern_OnOff(t *testing.T) {
+	defer func(d time.Duration) { limit.PatternFreq = d }(limit.PatternFreq)
+	limit.PatternFreq = 5 * time.Millisecond
+
+	// On for 100ms, then off for 200ms: paused while off
+	p := limit.NewPattern(100*time.Millisecond, 200*time.Millisecond, 0, 0, nil)
+	p.Start(context.Background())
+	defer p.Stop()
+	if !waited(p.Gate(), 50*time.Millisecond) {
+		t.Error("paused while on")
+	}
+
+	time.Sleep(150 * time.Millisecond) // off at 100ms
+	if waited(p.Gate(), 50*time.Millisecond) {
+		t.Error("allowed while off")
+	}
+
+	// Stop unblocks clients
+	p.Stop()
+	if !waited(p.Gate(), time.Second) {
+		t.Error("blocked after Stop")
+	}
+}
+
+func TestPattern_Sine(t *testing.T) {
+	defer func(d time.Duration) { limit.PatternFreq = d }(limit.PatternFreq)
+	limit.PatternFreq = 5 * time.Millisecond
+
+	r := limit.NewRate(1000, 0)
+	defer r.Stop()
+	p := limit.NewPattern(0, 0, time.Hour, 10, []limit.Rate{r, nil})
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	time.Sleep(20 * time.Millisecond)
+	if pct, _ := r.Current(); pct != 55 { // midway between 10% and 100%
+		t.Errorf("rate at %d%%, expected 55%%", pct)
+	}
+
+	// Stopped (ctx done): rate restored
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	if pct, s := r.Current(); pct != 100 {
+		t.Errorf("rate at %d%% (%s) after stop, expected 100%%", pct, s)
+	}
+}
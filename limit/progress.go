@@ -0,0 +1,122 @@
+// Copyright 2024 Block, Inc.
+
+package limit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progressor is implemented by limits that report progress in stats: Data limits,
+// Runtime, and Iter.
+type Progressor interface {
+	Progress() []Progress
+}
+
+// --------------------------------------------------------------------------
+
+// Runtime reports progress of the stage runtime limit (config.stage.runtime).
+// It doesn't limit anything; the stage runtime is a context deadline.
+type Runtime struct {
+	max time.Duration
+	t   time.Time // Start
+	*sync.Mutex
+}
+
+var _ Progressor = &Runtime{}
+
+func NewRuntime(max time.Duration) *Runtime {
+	if max == 0 {
+		return nil
+	}
+	return &Runtime{
+		max:   max,
+		Mutex: &sync.Mutex{},
+	}
+}
+
+// Start starts the runtime. Progress is not reported until Start is called.
+func (lm *Runtime) Start() {
+	lm.Lock()
+	lm.t = time.Now()
+	lm.Unlock()
+}
+
+// Progress reports seconds elapsed of max.
+func (lm *Runtime) Progress() []Progress {
+	lm.Lock()
+	defer lm.Unlock()
+	if lm.t.IsZero() {
+		return nil
+	}
+	d := time.Now().Sub(lm.t)
+	if d > lm.max {
+		d = lm.max
+	}
+	n := uint64(d.Seconds())
+	p := newProgress("runtime", "seconds", n, uint64(lm.max.Seconds()), d, n)
+	p.ETA = (lm.max - d).Seconds() // exact, not estimated from rate
+	return []Progress{p}
+}
+
+// --------------------------------------------------------------------------
+
+// Iter reports progress of iteration limits (config.stage.workload.iter,
+// iter-clients, and iter-exec-group) for an execution group: iterations run by
+// all clients in the execution group of the total allowed. It doesn't limit
+// anything; clients enforce the limits.
+type Iter struct {
+	name string
+	max  uint64
+	n    uint64 // atomic
+	// --
+	t    time.Time // Start
+	done bool      // Stop
+	*sync.Mutex
+}
+
+var _ Progressor = &Iter{}
+
+func NewIter(name string, max uint64) *Iter {
+	if max == 0 {
+		return nil
+	}
+	return &Iter{
+		name:  name,
+		max:   max,
+		Mutex: &sync.Mutex{},
+	}
+}
+
+// Start starts the execution group. Progress is reported from Start to Stop.
+func (lm *Iter) Start() {
+	lm.Lock()
+	lm.t = time.Now()
+	lm.Unlock()
+}
+
+// Stop stops reporting progress when the execution group is done.
+func (lm *Iter) Stop() {
+	lm.Lock()
+	lm.done = true
+	lm.Unlock()
+}
+
+// Inc counts one iteration. Clients call it once per iteration.
+func (lm *Iter) Inc() {
+	atomic.AddUint64(&lm.n, 1)
+}
+
+func (lm *Iter) Progress() []Progress {
+	lm.Lock()
+	defer lm.Unlock()
+	if lm.t.IsZero() || lm.done {
+		return nil
+	}
+	n := atomic.LoadUint64(&lm.n)
+	if n > lm.max {
+		n = lm.max
+	}
+	return []Progress{newProgress(lm.name, "iterations", n, lm.max, time.Now().Sub(lm.t), n)}
+}
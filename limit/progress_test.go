@@ -0,0 +1,69 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+func TestIter(t *testing.T) {
+	if lm := limit.NewIter("iter g1", 0); lm != nil {
+		t.Errorf("got non-nil Iter for max=0, expected nil")
+	}
+
+	lm := limit.NewIter("iter g1", 10)
+	if p := lm.Progress(); len(p) != 0 {
+		t.Errorf("got progress before Start, expected none: %+v", p)
+	}
+
+	lm.Start()
+	for i := 0; i < 5; i++ {
+		lm.Inc()
+	}
+	time.Sleep(10 * time.Millisecond) // rate > 0
+	p := lm.Progress()
+	if len(p) != 1 {
+		t.Fatalf("got %d progress, expected 1", len(p))
+	}
+	if p[0].Limit != "iter g1" || p[0].Unit != "iterations" || p[0].N != 5 || p[0].Max != 10 || p[0].Percent != 50.0 {
+		t.Errorf("wrong progress: %+v", p[0])
+	}
+	if p[0].ETA <= 0 {
+		t.Errorf("got ETA %f, expected > 0", p[0].ETA)
+	}
+
+	// Clients that stop on the limit don't count more than max
+	for i := 0; i < 10; i++ {
+		lm.Inc()
+	}
+	p = lm.Progress()
+	if len(p) != 1 || p[0].N != 10 || p[0].Percent != 100.0 || p[0].ETA != 0 {
+		t.Errorf("wrong progress: %+v", p)
+	}
+
+	lm.Stop()
+	if p := lm.Progress(); len(p) != 0 {
+		t.Errorf("got progress after Stop, expected none: %+v", p)
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	lm := limit.NewRuntime(10 * time.Second)
+	if p := lm.Progress(); len(p) != 0 {
+		t.Errorf("got progress before Start, expected none: %+v", p)
+	}
+	lm.Start()
+	p := lm.Progress()
+	if len(p) != 1 {
+		t.Fatalf("got %d progress, expected 1", len(p))
+	}
+	if p[0].Limit != "runtime" || p[0].Unit != "seconds" || p[0].N != 0 || p[0].Max != 10 {
+		t.Errorf("wrong progress: %+v", p[0])
+	}
+	if p[0].ETA <= 9.0 || p[0].ETA > 10.0 {
+		t.Errorf("got ETA %f, expected 9-10s", p[0].ETA)
+	}
+}
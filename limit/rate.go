@@ -5,6 +5,7 @@ package limit
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	gorate "golang.org/x/time/rate"
 
@@ -16,6 +17,17 @@ type Rate interface {
 	Current() (byte, string)
 	Allow() <-chan bool
 	Stop()
+
+	// Pacing returns the number of allowances the rate limiter has made and
+	// the number dropped because no client was waiting for one. A client that
+	// is always busy never waits, so if many allowances are dropped, clients are
+	// behind schedule: they can't execute at the configured rate.
+	Pacing() (allowed, dropped uint64)
+
+	// Refund returns one allowance to the rate limiter because it wasn't used,
+	// like a TPS allowance for a MySQL trx that rolled back instead of committing.
+	// The refunded allowance is made again without waiting on the rate.
+	Refund()
 }
 
 type rate struct {
@@ -23,29 +35,55 @@ type rate struct {
 	n        uint
 	rl       *gorate.Limiter
 	stopChan chan struct{}
+	allowed  uint64 // atomic
+	dropped  uint64 // atomic
+	refunds  int64  // atomic
+	p        uint32 // atomic: percent of n (Adjust)
 }
 
 var _ Rate = &rate{}
 
-func NewRate(perSecond uint) Rate {
+// NewRate makes a token bucket rate limiter that allows perSecond executions,
+// or returns nil if perSecond is zero (no limit). The bucket holds burst
+// allowances (minimum 1), which accumulate while clients are busy, so up to
+// burst clients can execute at once when they're ready. The bucket starts full.
+// With burst 1, the rate is steady.
+func NewRate(perSecond, burst uint) Rate {
 	if perSecond == 0 {
 		return nil
 	}
-	finch.Debug("new rate: %d/s", perSecond)
+	if burst == 0 {
+		burst = 1
+	}
+	finch.Debug("new rate: %d/s burst %d", perSecond, burst)
 	lm := &rate{
+		n:        perSecond,
+		p:        100,
 		rl:       gorate.NewLimiter(gorate.Limit(perSecond), 1),
-		c:        make(chan bool, 1),
+		c:        make(chan bool, burst), // bucket
 		stopChan: make(chan struct{}),
 	}
+	for i := uint(1); i < burst; i++ { // +1 from rl
+		lm.c <- true
+		lm.allowed++
+	}
 	go lm.run()
 	return lm
 }
 
+// Adjust sets the rate to p percent (minimum 1) of the configured rate, like
+// for a load pattern (Pattern).
 func (lm *rate) Adjust(p byte) {
+	if p == 0 {
+		p = 1 // zero rate would stop run; use Throttle to pause
+	}
+	atomic.StoreUint32(&lm.p, uint32(p))
+	lm.rl.SetLimit(gorate.Limit(float64(lm.n) * float64(p) / 100))
 }
 
 func (lm *rate) Current() (p byte, s string) {
-	return 0, ""
+	p = byte(atomic.LoadUint32(&lm.p))
+	return p, fmt.Sprintf("%d%% of %d/s", p, lm.n)
 }
 
 func (lm *rate) Stop() {
@@ -55,20 +93,42 @@ func (lm *rate) Allow() <-chan bool {
 	return lm.c
 }
 
+func (lm *rate) Pacing() (allowed, dropped uint64) {
+	return atomic.LoadUint64(&lm.allowed), atomic.LoadUint64(&lm.dropped)
+}
+
+func (lm *rate) Refund() {
+	atomic.AddInt64(&lm.refunds, 1)
+}
+
 func (lm *rate) run() {
 	var err error
 	for {
+		// Refunded allowances are owed to clients, so they don't wait on the
+		// rate and they aren't dropped: wait for a client to take it
+		if atomic.LoadInt64(&lm.refunds) > 0 {
+			atomic.AddInt64(&lm.refunds, -1) // only run decrements
+			atomic.AddUint64(&lm.allowed, 1)
+			select {
+			case lm.c <- true:
+			case <-lm.stopChan:
+				return
+			}
+			continue
+		}
 		err = lm.rl.Wait(context.Background())
 		if err != nil {
 			// burst limit exceeded?
 			continue
 		}
+		atomic.AddUint64(&lm.allowed, 1)
 		select {
 		case lm.c <- true:
 		case <-lm.stopChan:
 			return
 		default:
 			// dropped
+			atomic.AddUint64(&lm.dropped, 1)
 		}
 	}
 }
@@ -76,10 +136,14 @@ func (lm *rate) run() {
 // --------------------------------------------------------------------------
 
 type and struct {
-	c chan bool
-	n uint
-	a Rate
-	b Rate
+	c       chan bool
+	n       uint
+	a       Rate
+	b       Rate
+	refund  chan struct{} // wakes run on Refund
+	allowed uint64        // atomic
+	dropped uint64        // atomic
+	refunds int64         // atomic
 }
 
 var _ Rate = &and{}
@@ -98,14 +162,24 @@ func And(a, b Rate) Rate {
 		return a
 	}
 	lm := &and{
-		a: a,
-		b: b,
-		c: make(chan bool, 1),
+		a:      a,
+		b:      b,
+		c:      make(chan bool, burst(a, b)),
+		refund: make(chan struct{}, 1),
 	}
 	go lm.run()
 	return lm
 }
 
+// burst returns the lower burst size of a and b, which is the capacity of
+// their channels: the size of their token buckets.
+func burst(a, b Rate) int {
+	if cap(a.Allow()) < cap(b.Allow()) {
+		return cap(a.Allow())
+	}
+	return cap(b.Allow())
+}
+
 func (lm *and) Allow() <-chan bool {
 	return lm.c
 }
@@ -113,6 +187,22 @@ func (lm *and) Allow() <-chan bool {
 func (lm *and) N(_ uint) {
 }
 
+func (lm *and) Pacing() (allowed, dropped uint64) {
+	return atomic.LoadUint64(&lm.allowed), atomic.LoadUint64(&lm.dropped)
+}
+
+// Refund owes the allowance to clients of this limiter, not to a and b: the
+// allowance is made by run without waiting on a and b, and it's not dropped.
+// Refunding a and b instead would make allowances that run can drop when its
+// channel is full, which is usual for a closed-loop client that just rolled back.
+func (lm *and) Refund() {
+	atomic.AddInt64(&lm.refunds, 1)
+	select {
+	case lm.refund <- struct{}{}:
+	default: // run already signaled
+	}
+}
+
 func (lm *and) Adjust(p byte) {
 	lm.a.Adjust(p)
 	lm.b.Adjust(p)
@@ -136,17 +226,39 @@ func (lm *and) run() {
 	a := false
 	b := false
 	for {
+		// Refunded allowances are owed to clients, like rate.run: wait for a
+		// client to take it
+		if atomic.LoadInt64(&lm.refunds) > 0 {
+			atomic.AddInt64(&lm.refunds, -1) // only run decrements
+			atomic.AddUint64(&lm.allowed, 1)
+			lm.c <- true
+			continue
+		}
+
+		// Wait only for the rate that hasn't allowed yet so the other keeps its
+		// allowances (burst) until both allow
+		ac, bc := lm.a.Allow(), lm.b.Allow()
+		if a {
+			ac = nil
+		}
+		if b {
+			bc = nil
+		}
 		select {
-		case <-lm.a.Allow():
+		case <-ac:
 			a = true
-		case <-lm.b.Allow():
+		case <-bc:
 			b = true
+		case <-lm.refund:
+			continue // a and b keep their allowance (if any) until next loop
 		}
 		if a && b {
+			atomic.AddUint64(&lm.allowed, 1)
 			select {
 			case lm.c <- true:
 			default:
 				// dropped
+				atomic.AddUint64(&lm.dropped, 1)
 			}
 			a = false
 			b = false
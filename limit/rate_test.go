@@ -0,0 +1,107 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+func TestRate_Refund(t *testing.T) {
+	lm := limit.NewRate(10, 0) // 1 allowance every 100ms
+	defer lm.Stop()
+
+	// First allowance is immediate (burst 1), then the rate waits 100ms for the
+	// next. Refunding the first makes one more allowance without waiting, so
+	// the next 3 allowances take ~200ms instead of ~300ms.
+	<-lm.Allow()
+	lm.Refund()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-lm.Allow():
+		case <-time.After(time.Second):
+			t.Fatalf("not allowed after 1s on allowance %d", i+1)
+		}
+	}
+	d := time.Since(start)
+	if d < 150*time.Millisecond || d > 280*time.Millisecond {
+		t.Errorf("3 allowances took %s, expected ~200ms with 1 refund", d)
+	}
+
+	allowed, dropped := lm.Pacing()
+	if allowed < 4 {
+		t.Errorf("allowed %d, expected at least 4", allowed)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped %d, expected 0", dropped)
+	}
+}
+
+func TestAnd_Refund(t *testing.T) {
+	// Stacked limits, like stage and client group TPS: 1 allowance every 100ms
+	lm := limit.And(limit.NewRate(10, 0), limit.NewRate(10, 0))
+	defer lm.Stop()
+
+	// Take the first allowance and wait for the next to be buffered, like a
+	// closed-loop client that rolled back. The refund isn't dropped even though
+	// the channel is full: it's made when the buffered allowance is taken.
+	<-lm.Allow()
+	time.Sleep(150 * time.Millisecond)
+	lm.Refund()
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-lm.Allow():
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("allowance %d not immediate, expected buffered and refunded allowances", i+1)
+		}
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("2 allowances took %s, expected no wait", d)
+	}
+
+	allowed, dropped := lm.Pacing()
+	if allowed < 3 {
+		t.Errorf("allowed %d, expected at least 3", allowed)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped %d, expected 0", dropped)
+	}
+}
+
+func TestRate_Burst(t *testing.T) {
+	lm := limit.NewRate(10, 5) // 1 allowance every 100ms, bucket of 5
+	defer lm.Stop()
+
+	// Bucket starts full: 5 allowances without waiting, then the 6th waits ~100ms
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		select {
+		case <-lm.Allow():
+		case <-time.After(time.Second):
+			t.Fatalf("not allowed after 1s on allowance %d", i+1)
+		}
+		if i == 4 {
+			if d := time.Since(start); d > 50*time.Millisecond {
+				t.Errorf("5 allowances took %s, expected no wait with burst 5", d)
+			}
+		}
+	}
+	if d := time.Since(start); d < 70*time.Millisecond {
+		t.Errorf("6 allowances took %s, expected ~100ms after burst", d)
+	}
+
+	// Allowances accumulate while clients are busy: after ~300ms, 3 allowances
+	// without waiting
+	time.Sleep(320 * time.Millisecond)
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		<-lm.Allow()
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("3 allowances took %s after idle, expected no wait", d)
+	}
+}
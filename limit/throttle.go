@@ -0,0 +1,88 @@
+// Copyright 2024 Block, Inc.
+
+package limit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	gorate "golang.org/x/time/rate"
+
+	"github.com/square/finch"
+)
+
+// Throttle is an adaptive limit (config.stage.throttle) that clients check
+// before each query. It doesn't limit clients until throttled, then it allows
+// qps queries per second, or none if qps is 0 (pause), until unthrottled. The
+// caller decides when to throttle; see stage.Throttle.
+//
+// It's a gate, not a token goroutine: while unthrottled, Wait is one atomic load.
+type Throttle struct {
+	*sync.Mutex
+	rl        *gorate.Limiter // qps while throttled, or nil to pause
+	throttled atomic.Bool
+	gate      chan struct{} // closed when unthrottled or stopped
+	stopped   bool
+	ctx       context.Context // cancelled by Stop to unblock rl.Wait
+	cancel    context.CancelFunc
+}
+
+// NewThrottle returns an unthrottled Throttle that allows qps queries per
+// second when throttled, or none if qps is 0.
+func NewThrottle(qps uint) *Throttle {
+	finch.Debug("new throttle: %d/s", qps)
+	lm := &Throttle{
+		Mutex: &sync.Mutex{},
+	}
+	lm.ctx, lm.cancel = context.WithCancel(context.Background())
+	if qps > 0 {
+		lm.rl = gorate.NewLimiter(gorate.Limit(qps), 1)
+	}
+	return lm
+}
+
+// Wait returns immediately if not throttled, else it waits until allowed by
+// the throttled qps, or until unthrottled or stopped if paused (qps 0).
+func (lm *Throttle) Wait() {
+	for lm.throttled.Load() {
+		if lm.rl != nil {
+			lm.rl.Wait(lm.ctx) // error only if stopped
+			return
+		}
+		lm.Lock()
+		gate := lm.gate
+		lm.Unlock()
+		<-gate
+	}
+}
+
+// Set throttles (true) or unthrottles (false) clients. It's ignored after Stop.
+func (lm *Throttle) Set(throttled bool) {
+	lm.Lock()
+	defer lm.Unlock()
+	if lm.stopped || lm.throttled.Load() == throttled {
+		return
+	}
+	if throttled {
+		lm.gate = make(chan struct{}) // before throttled so Wait never gets nil
+		lm.throttled.Store(true)
+	} else {
+		lm.throttled.Store(false)
+		close(lm.gate)
+	}
+}
+
+// Throttled returns true if clients are throttled.
+func (lm *Throttle) Throttled() bool {
+	return lm.throttled.Load()
+}
+
+// Stop stops the throttle and unblocks all clients.
+func (lm *Throttle) Stop() {
+	lm.Set(false)
+	lm.Lock()
+	lm.stopped = true
+	lm.Unlock()
+	lm.cancel()
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Block, Inc.
+
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/finch/limit"
+)
+
+// waited returns true if lm.Wait returns within d.
+func waited(lm *limit.Throttle, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		lm.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestThrottle_Pause(t *testing.T) {
+	lm := limit.NewThrottle(0) // pause when throttled
+	defer lm.Stop()
+
+	// Not throttled: no limit
+	for i := 0; i < 100; i++ {
+		if !waited(lm, time.Second) {
+			t.Fatalf("not allowed after 1s on query %d, expected no limit when not throttled", i+1)
+		}
+	}
+
+	// Throttled with qps 0: paused until unthrottled
+	lm.Set(true)
+	if !lm.Throttled() {
+		t.Error("Throttled() false after Set(true)")
+	}
+	if waited(lm, 100*time.Millisecond) {
+		t.Error("allowed query while paused")
+	}
+
+	lm.Set(false)
+	if !waited(lm, time.Second) {
+		t.Fatal("not allowed after 1s, expected resume when unthrottled")
+	}
+
+	// Stop unblocks clients waiting while throttled
+	lm.Set(true)
+	done := make(chan struct{})
+	go func() {
+		lm.Wait()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	lm.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait blocked 1s after Stop")
+	}
+
+	// Set is ignored after Stop
+	lm.Set(true)
+	if lm.Throttled() {
+		t.Error("Throttled() true after Stop")
+	}
+}
+
+func TestThrottle_QPS(t *testing.T) {
+	lm := limit.NewThrottle(100) // 1 query/10ms when throttled
+	defer lm.Stop()
+	lm.Set(true)
+
+	n := 0
+	timeout := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(timeout) {
+		lm.Wait()
+		n++
+	}
+	if n < 10 || n > 30 {
+		t.Errorf("allowed %d queries in 200ms at 100 QPS, expected about 20", n)
+	}
+}
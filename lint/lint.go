@@ -0,0 +1,265 @@
+// Copyright 2024 Block, Inc.
+
+// Package lint checks stage files for problems beyond config validation, like
+// unknown data generator params and unused data keys. It does not connect to
+// MySQL. It's used for --lint.
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/square/finch/config"
+	"github.com/square/finch/data"
+	"github.com/square/finch/trx"
+)
+
+// Problem is an error or warning in a stage or trx file. Errors are the same
+// errors that Finch returns when loading the stage. Warnings are valid config
+// that's probably a mistake.
+type Problem struct {
+	File  string
+	Line  int // 0 if unknown
+	Error bool
+	Msg   string
+}
+
+func (p Problem) String() string {
+	level := "warning"
+	if p.Error {
+		level = "error"
+	}
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, level, p.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, level, p.Msg)
+}
+
+// Files lints the stage files with the given --param key=value params. It
+// returns all problems in all files, in file order.
+func Files(stageFiles []string, kvparams []string) []Problem {
+	problems := []Problem{}
+	for _, file := range stageFiles {
+		problems = append(problems, stage(file, kvparams)...)
+	}
+	return problems
+}
+
+func stage(file string, kvparams []string) []Problem {
+	// Load and validate the stage config like Finch does to run it. If this
+	// fails, there's no config to lint.
+	stages, err := config.Load([]string{file}, kvparams, "", "", "")
+	if err != nil {
+		return []Problem{{File: file, Error: true, Msg: err.Error()}}
+	}
+	cfg := stages[0]
+	if cfg.Disable {
+		return nil
+	}
+
+	// Parse the file again as YAML nodes to report line numbers
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		return []Problem{{File: file, Error: true, Msg: err.Error()}}
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(bytes, &root); err != nil {
+		return []Problem{{File: file, Error: true, Msg: err.Error()}}
+	}
+
+	l := &linter{
+		file: file,
+		dir:  filepath.Dir(cfg.File),
+		root: &root,
+	}
+	l.trx(cfg)
+	l.workload(cfg)
+	l.load(cfg)
+	return l.problems
+}
+
+type linter struct {
+	file     string
+	dir      string
+	root     *yaml.Node
+	problems []Problem
+}
+
+func (l *linter) warn(line int, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{File: l.file, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// trx checks data keys in all trx files: unknown generator params, unused keys,
+// and keys configured differently in different trx files.
+func (l *linter) trx(cfg config.Stage) {
+	type first struct {
+		trx  string
+		data config.Data
+	}
+	seen := map[string]first{}
+	for i, t := range cfg.Trx {
+		used := l.dataKeys(t.File)
+		dataKeys := make([]string, 0, len(t.Data))
+		for k := range t.Data {
+			dataKeys = append(dataKeys, k)
+		}
+		sort.Strings(dataKeys)
+		for _, dataKey := range dataKeys {
+			d := t.Data[dataKey]
+			path := []interface{}{"stage", "trx", i, "data", dataKey}
+
+			if valid, ok := data.Params[d.Generator]; ok {
+				for _, p := range sortedKeys(d.Params) {
+					if !contains(valid, p) && !data.CommonParam(p, d.Params) {
+						l.warn(line(l.root, append(path, "params", p)...), "trx %s data key %s: unknown %s generator param: %s (valid: %s)",
+							t.Name, dataKey, d.Generator, p, strings.Join(valid, ", "))
+					}
+				}
+			}
+
+			if used != nil && !used[dataKey] {
+				l.warn(line(l.root, path...), "trx %s data key %s is not used in %s", t.Name, dataKey, t.File)
+			}
+
+			// Data keys are shared by all trx files, so only the first config is used
+			if f, ok := seen[dataKey]; !ok {
+				seen[dataKey] = first{trx: t.Name, data: d}
+			} else if f.data.Generator != d.Generator || !sameParams(f.data.Params, d.Params) {
+				l.warn(line(l.root, path...), "trx %s data key %s is configured differently in trx %s; only the first config (trx %s) is used",
+					t.Name, dataKey, f.trx, f.trx)
+			}
+		}
+	}
+}
+
+// workload checks that every trx is assigned to a client group, if the workload
+// assigns trx explicitly.
+func (l *linter) workload(cfg config.Stage) {
+	if len(cfg.Workload) == 0 || (len(cfg.Workload[0].Trx) == 0 && cfg.Workload[0].Script == "") {
+		return // auto-assigned or all trx
+	}
+	assigned := map[string]bool{}
+	for _, cg := range cfg.Workload {
+		for _, name := range cg.Trx {
+			assigned[name] = true
+		}
+	}
+	for _, r := range cfg.Compute.Remotes {
+		for _, cg := range r.Workload {
+			for _, name := range cg.Trx {
+				assigned[name] = true
+			}
+		}
+	}
+	for i, t := range cfg.Trx {
+		if !assigned[t.Name] {
+			l.warn(line(l.root, "stage", "trx", i), "trx %s is not assigned to any client group in workload", t.Name)
+		}
+	}
+}
+
+// load loads the trx files like Finch does to run the stage, which returns
+// errors in trx files (with line numbers) and data generator params.
+func (l *linter) load(cfg config.Stage) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(l.dir); err != nil {
+		return
+	}
+	defer data.ClosePools() // trx.Load opens key pool files
+	// Unknown generator params are warnings (above), not errors
+	prevUnknownParams := data.UnknownParams
+	data.UnknownParams = "ignore"
+	defer func() { data.UnknownParams = prevUnknownParams }()
+	if _, err := trx.Load(cfg.Trx, data.NewScope(), cfg.Params); err != nil {
+		l.problems = append(l.problems, Problem{File: l.file, Error: true, Msg: err.Error()})
+	}
+}
+
+// dataKeys returns the data keys (without @) used in a trx file, or nil if the
+// file cannot be read.
+func (l *linter) dataKeys(file string) map[string]bool {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(l.dir, file)
+	}
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	keys := map[string]bool{}
+	for _, k := range trx.DataKeyPattern.FindAllString(string(bytes), -1) {
+		keys[strings.Trim(k, "@"+trx.EXPLICIT_CALL_SUFFIX)] = true
+	}
+	return keys
+}
+
+// line returns the line number of the YAML node at path: mapping keys (string)
+// and sequence indexes (int). If the full path isn't found, it returns the line
+// of the deepest node found.
+func line(n *yaml.Node, path ...interface{}) int {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	ln := n.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			if n.Kind != yaml.MappingNode {
+				return ln
+			}
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					ln = n.Content[i].Line
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if n.Kind != yaml.SequenceNode || p >= len(n.Content) {
+				return ln
+			}
+			next = n.Content[p]
+			ln = next.Line
+		}
+		if next == nil {
+			return ln
+		}
+		n = next
+	}
+	return ln
+}
+
+func sameParams(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true // nil and empty are the same
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 Block, Inc.
+
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/lint"
+)
+
+func TestFiles(t *testing.T) {
+	file := "../test/lint/stage.yaml"
+	got := lint.Files([]string{file}, nil)
+	expect := []lint.Problem{
+		{File: file, Line: 9, Msg: "trx read.sql data key id: unknown int generator param: maxx (valid: min, max, dist, mean, stddev, h, lambda, seed)"},
+		{File: file, Line: 10, Msg: "trx read.sql data key k is not used in read.sql"},
+		{File: file, Line: 14, Msg: "trx write.sql data key id is configured differently in trx read.sql; only the first config (trx read.sql) is used"},
+		{File: file, Line: 18, Msg: "trx unused.sql is not assigned to any client group in workload"},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+		for _, p := range got {
+			t.Log(p)
+		}
+	}
+
+	// Invalid stage file is an error without line number
+	got = lint.Files([]string{"../test/lint/nonexistent.yaml"}, nil)
+	if len(got) != 1 || !got[0].Error {
+		t.Errorf("got %+v, expected 1 error", got)
+	}
+}
@@ -5,8 +5,10 @@ package proto
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -19,6 +21,29 @@ import (
 
 var ErrFailed = errors.New("request failed after attempts, or context cancelled")
 
+var ErrUnauthorized = errors.New("server rejected request: invalid or missing token (--token)")
+
+// TokenHeader is the HTTP header for the shared token: compute.token on the
+// server, and --token on remote instances.
+const TokenHeader = "X-Finch-Token"
+
+// ChecksumHeader is the HTTP header for the SHA-256 checksum (hex) of a file
+// sent by the server.
+const ChecksumHeader = "X-Finch-Sha256"
+
+// Control events that the server pushes to remotes on the control stream
+// (GET /control), so remotes don't poll for them.
+const (
+	EventRun  = "run"  // run the booted stage
+	EventStop = "stop" // stage stopped (done or stopped early)
+	EventPing = "ping" // keep-alive every second
+)
+
+// Event is one control event on the control stream: newline-delimited JSON.
+type Event struct {
+	Event string `json:"event"`
+}
+
 type R struct {
 	Timeout time.Duration
 	Wait    time.Duration
@@ -87,6 +112,10 @@ func (c *Client) request(ctx context.Context, method string, endpoint string, pa
 			return resp, body, nil // success
 		case http.StatusResetContent:
 			return resp, nil, nil // reset
+		case http.StatusNoContent:
+			return resp, nil, nil // success, nothing to return
+		case http.StatusUnauthorized:
+			return nil, nil, ErrUnauthorized // retrying won't help
 		default:
 			goto RETRY
 		}
@@ -104,6 +133,52 @@ func (c *Client) request(ctx context.Context, method string, endpoint string, pa
 	return nil, nil, ErrFailed
 }
 
+// Events opens a stream of control events from the server (see Event). It
+// returns a channel of event names that's closed when the stream ends: when the
+// server ends it, on network error, or when ctx is done. It doesn't retry: if the
+// server doesn't have the endpoint or the stream ends, the caller should fall
+// back to polling.
+func (c *Client) Events(ctx context.Context, endpoint string) (<-chan string, error) {
+	url := c.URL(endpoint, nil)
+	finch.Debug("GET %s (stream)", url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, ErrUnauthorized
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	events := make(chan string, 1)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var e Event
+			if err := dec.Decode(&e); err != nil {
+				finch.Debug("%s stream ended: %s", endpoint, err)
+				return
+			}
+			select {
+			case events <- e.Event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
 func (c *Client) URL(path string, params [][]string) string {
 	// Every request requires 'name=...' to tell server this client's name.
 	// It's not a hostname, just a user-defined name for the remote compute instance.
@@ -117,3 +192,31 @@ func (c *Client) URL(path string, params [][]string) string {
 	u += "&" + strings.Join(escaped, "&")
 	return u
 }
+
+// MakeHTTPClient returns a func for finch.MakeHTTPClient that makes clients
+// that use TLS (if tlsConfig is not nil) and send the shared token (if not
+// empty) on every request to the server.
+func MakeHTTPClient(tlsConfig *tls.Config, token string) func() *http.Client {
+	return func() *http.Client {
+		tr := &http.Transport{
+			MaxIdleConns:    1,
+			IdleConnTimeout: 1 * time.Hour,
+			TLSClientConfig: tlsConfig,
+		}
+		if token == "" {
+			return &http.Client{Transport: tr}
+		}
+		return &http.Client{Transport: tokenTransport{token: token, rt: tr}}
+	}
+}
+
+type tokenTransport struct {
+	token string
+	rt    http.RoundTripper
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context()) // RoundTripper must not modify request
+	req.Header.Set(TokenHeader, t.token)
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Block, Inc.
+
+// Package replay parses MySQL general and slow query logs into sessions
+// (connections) of queries. The trx package uses it to load a log as trx to
+// replay (config.stage.trx[].replay).
+package replay
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	GENERAL = "general" // general query log
+	SLOW    = "slow"    // slow query log
+)
+
+// Query is one query from a log.
+type Query struct {
+	Ts  time.Time // when MySQL logged the query (zero if unknown)
+	SQL string
+}
+
+// Session is all queries from one connection, in log order.
+type Session struct {
+	Id      string // connection (thread) ID
+	Queries []Query
+}
+
+// maxLine is the max line length in a log. Queries can be very long, like
+// multi-row inserts.
+const maxLine = 64 * 1024 * 1024
+
+// Load parses the log file in the given format (GENERAL or SLOW).
+func Load(file, format string) ([]Session, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var s []Session
+	switch format {
+	case GENERAL:
+		s, err = ParseGeneral(f)
+	case SLOW:
+		s, err = ParseSlow(f)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s (valid: %s, %s)", format, GENERAL, SLOW)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("%s: no queries in %s log", file, format)
+	}
+	return s, nil
+}
+
+// sessions keeps sessions in order of first query.
+type sessions struct {
+	order []string
+	byId  map[string]*Session
+}
+
+func (s *sessions) add(id string, q Query) {
+	q.SQL = strings.TrimSpace(q.SQL)
+	if q.SQL == "" {
+		return
+	}
+	if s.byId == nil {
+		s.byId = map[string]*Session{}
+	}
+	sess, ok := s.byId[id]
+	if !ok {
+		sess = &Session{Id: id}
+		s.byId[id] = sess
+		s.order = append(s.order, id)
+	}
+	sess.Queries = append(sess.Queries, q)
+}
+
+func (s *sessions) list() []Session {
+	list := make([]Session, len(s.order))
+	for i, id := range s.order {
+		list[i] = *s.byId[id]
+	}
+	return list
+}
+
+// 2024-01-01T00:00:00.123456Z	   12 Query	SELECT 1
+var reGeneral = regexp.MustCompile(`^(\d{4}-\d\d-\d\dT[\d:.]+(?:Z|[+-]\d\d:\d\d))\s+(\d+)\s([A-Za-z ]+?)\t(.*)$`)
+
+// ParseGeneral parses a general query log. Only Query and Execute commands are
+// replayed, and Init DB is replayed as USE. A line that doesn't begin a new
+// entry continues the query of the previous entry (multi-line query).
+func ParseGeneral(r io.Reader) ([]Session, error) {
+	var s sessions
+	var id string
+	var q *Query
+	flush := func() {
+		if q != nil {
+			s.add(id, *q)
+			q = nil
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := reGeneral.FindStringSubmatch(line)
+		if m == nil {
+			if q != nil {
+				q.SQL += "\n" + line // multi-line query
+			}
+			continue // or header line
+		}
+		flush()
+		ts, err := time.Parse(time.RFC3339Nano, m[1])
+		if err != nil {
+			return nil, err
+		}
+		id = m[2]
+		switch m[3] {
+		case "Query", "Execute":
+			q = &Query{Ts: ts, SQL: m[4]}
+		case "Init DB":
+			q = &Query{Ts: ts, SQL: "USE `" + m[4] + "`"}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return s.list(), nil
+}
+
+var reSlowId = regexp.MustCompile(`Id:\s*(\d+)`)
+
+// ParseSlow parses a slow query log. Each entry has a "# Time:" and
+// "# User@Host: ... Id: N" header followed by one or more statements
+// terminated by ";". The "SET timestamp=N" statement in each entry is ignored.
+func ParseSlow(r io.Reader) ([]Session, error) {
+	var s sessions
+	var id string
+	var ts time.Time
+	sql := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if sql == "" {
+			switch {
+			case strings.HasPrefix(line, "# Time:"):
+				t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(strings.TrimPrefix(line, "# Time:")))
+				if err != nil {
+					return nil, err
+				}
+				ts = t
+				continue
+			case strings.HasPrefix(line, "# User@Host:"):
+				if m := reSlowId.FindStringSubmatch(line); m != nil {
+					id = m[1]
+				}
+				continue
+			case strings.HasPrefix(line, "#"), strings.TrimSpace(line) == "":
+				continue
+			case id == "":
+				continue // header lines before first entry
+			}
+		}
+		sql += line
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			sql += "\n" // multi-line query
+			continue
+		}
+		stmt := strings.TrimSuffix(strings.TrimSpace(sql), ";")
+		sql = ""
+		if strings.HasPrefix(strings.ToUpper(stmt), "SET TIMESTAMP=") {
+			continue
+		}
+		s.add(id, Query{Ts: ts, SQL: stmt})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s.list(), nil
+}
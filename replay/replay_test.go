@@ -0,0 +1,71 @@
+// Copyright 2024 Block, Inc.
+
+package replay_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/replay"
+)
+
+func ts(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestLoad_General(t *testing.T) {
+	got, err := replay.Load("../test/replay/general.log", replay.GENERAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []replay.Session{
+		{
+			Id: "10",
+			Queries: []replay.Query{
+				{Ts: ts("2024-01-01T00:00:00.000000Z"), SQL: "USE `test`"},
+				{Ts: ts("2024-01-01T00:00:00.100000Z"), SQL: "SELECT c FROM t WHERE id=1"},
+				{Ts: ts("2024-01-01T00:00:00.300000Z"), SQL: "SELECT c\nFROM t\nWHERE id=3"},
+			},
+		},
+		{
+			Id: "11",
+			Queries: []replay.Query{
+				{Ts: ts("2024-01-01T00:00:00.150000Z"), SQL: "UPDATE t SET c='50%' WHERE id=2"},
+			},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoad_Slow(t *testing.T) {
+	got, err := replay.Load("../test/replay/slow.log", replay.SLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []replay.Session{
+		{
+			Id: "10",
+			Queries: []replay.Query{
+				{Ts: ts("2024-01-01T00:00:00.100000Z"), SQL: "use test"},
+				{Ts: ts("2024-01-01T00:00:00.100000Z"), SQL: "SELECT c FROM t WHERE id=1"},
+			},
+		},
+		{
+			Id: "11",
+			Queries: []replay.Query{
+				{Ts: ts("2024-01-01T00:00:00.150000Z"), SQL: "UPDATE t SET c='x'\nWHERE id=2"},
+			},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Block, Inc.
+
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// Digest is a statement digest from performance_schema.events_statements_summary_by_digest.
+type Digest struct {
+	Digest string // DIGEST (hash)
+	Text   string // DIGEST_TEXT: normalized statement with ? for values
+	Sample string // QUERY_SAMPLE_TEXT: real statement, used if Text has value lists (...)
+	Count  uint64 // COUNT_STAR during sample period
+}
+
+// Digests samples statement digests for database db: it reads COUNT_STAR for
+// every digest, waits for the sample period, then reads again. Count is the
+// difference, so it's the frequency of the statement while sampled. If sample
+// is zero, Count is the total since the digest table was last truncated.
+//
+// Only DML statements (SELECT, INSERT, UPDATE, DELETE, REPLACE) are returned,
+// sorted by Count (descending), and digests that did not execute during the
+// sample period are not returned.
+func Digests(ctx context.Context, conn *sql.DB, db string, sample time.Duration) ([]Digest, error) {
+	before := map[string]uint64{}
+	if sample > 0 {
+		first, err := digests(ctx, conn, db)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range first {
+			before[d.Digest] = d.Count
+		}
+		select {
+		case <-time.After(sample):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	last, err := digests(ctx, conn, db)
+	if err != nil {
+		return nil, err
+	}
+	all := []Digest{}
+	for _, d := range last {
+		if n := before[d.Digest]; n <= d.Count { // 0 if new digest
+			d.Count -= n
+		} // else digest table truncated (or digest evicted) while sampling: count since then
+		if d.Count == 0 || !isDML(d.Text) {
+			continue
+		}
+		all = append(all, d)
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("no DML statement digests for database %s in performance_schema.events_statements_summary_by_digest", db)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Count > all[j].Count })
+	return all, nil
+}
+
+func digests(ctx context.Context, conn *sql.DB, db string) ([]Digest, error) {
+	q := "SELECT DIGEST, DIGEST_TEXT, COALESCE(QUERY_SAMPLE_TEXT, ''), COUNT_STAR" +
+		" FROM performance_schema.events_statements_summary_by_digest" +
+		" WHERE SCHEMA_NAME=? AND DIGEST IS NOT NULL"
+	finch.Debug(q)
+	rows, err := conn.QueryContext(ctx, q, db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Digest{}
+	for rows.Next() {
+		var d Digest
+		if err := rows.Scan(&d.Digest, &d.Text, &d.Sample, &d.Count); err != nil {
+			return nil, err
+		}
+		all = append(all, d)
+	}
+	return all, rows.Err()
+}
+
+func isDML(query string) bool {
+	switch strings.ToUpper(reFirstWord.FindString(query)) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE":
+		return true
+	}
+	return false
+}
+
+var reFirstWord = regexp.MustCompile(`^\s*\w+`)
+
+// --------------------------------------------------------------------------
+
+const (
+	// DefaultTop is the default max number of digests written by WriteDigests.
+	DefaultTop = 20
+
+	// DefaultClients is the number of clients in the generated stage (params.clients).
+	DefaultClients = 16
+)
+
+// WriteDigests writes a stage that models the digests to dir:
+//
+//	_all.yaml        params.clients
+//	capture.yaml     stage with one client group that executes one trx per digest
+//	trx/digest-N.sql
+//
+// Only the top digests (by Count) are written. Each trx is weighted by its digest
+// Count, so clients execute digests in the same proportions as sampled. Digest
+// values (?) become data keys using the int generator. If the digest has value
+// lists (...), the query sample is used as-is because the number of values isn't
+// known. Digests that are truncated (...) are skipped.
+//
+// Existing files are not overwritten; it returns an error instead. It returns
+// the list of files written.
+func WriteDigests(dir string, all []Digest, top int) ([]string, error) {
+	files := map[string]string{}
+	trx := []trxFile{}
+	total := uint64(0)
+	for _, d := range all {
+		if len(trx) == top {
+			break
+		}
+		t, ok := digestTrx(d, len(trx)+1)
+		if !ok {
+			finch.Debug("skip digest %s: %s", d.Digest, d.Text)
+			continue
+		}
+		trx = append(trx, t)
+		total += d.Count
+		files[t.file] = t.sql
+	}
+	if len(trx) == 0 {
+		return nil, fmt.Errorf("no digests to write (all truncated or value lists without query samples)")
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by finch --capture\n")
+	b.WriteString("stage:\n")
+	b.WriteString("  name: capture\n")
+	b.WriteString("  runtime: 60s\n")
+	b.WriteString("  workload:\n")
+	b.WriteString("    - clients: \"$params.clients\"\n")
+	for i := range trx {
+		trx[i].comment += fmt.Sprintf(" (%.1f%%)", float64(trx[i].weight)/float64(total)*100)
+	}
+	trxYAML(&b, trx)
+	files["capture.yaml"] = b.String()
+	files["_all.yaml"] = fmt.Sprintf("# Generated by finch --capture\nparams:\n  clients: \"%d\"\n", DefaultClients)
+
+	order := []string{"_all.yaml", "capture.yaml"}
+	for _, t := range trx {
+		order = append(order, t.file)
+	}
+	return writeFiles(dir, order, files)
+}
+
+var (
+	reValueList   = regexp.MustCompile(`\(\.\.\.\)( /\* , \.\.\. \*/)?`)
+	rePlaceholder = regexp.MustCompile(`\?`)
+)
+
+// digestTrx returns the trx file for digest d, which is the nth trx file.
+func digestTrx(d Digest, n int) (trxFile, bool) {
+	t := trxFile{
+		file:    fmt.Sprintf("trx/digest-%02d.sql", n),
+		comment: fmt.Sprintf("digest %s: %d executions", d.Digest, d.Count),
+		weight:  d.Count,
+	}
+	if strings.HasSuffix(d.Text, "...") {
+		return t, false // truncated
+	}
+	query := d.Text
+	if reValueList.MatchString(query) {
+		if d.Sample == "" || strings.HasSuffix(d.Sample, "...") {
+			return t, false
+		}
+		query = d.Sample
+	} else {
+		// Data keys are shared by all trx files in a stage, so prefix them with
+		// the trx number to keep values separate
+		i := 0
+		query = rePlaceholder.ReplaceAllStringFunc(query, func(string) string {
+			i++
+			k := dataKey{name: fmt.Sprintf("d%02d_%d", n, i), generator: "int"}
+			t.keys = append(t.keys, k)
+			return "@" + k.name
+		})
+	}
+	// Client formats every query (fmt.Sprintf), so a literal % must be %%
+	t.sql = strings.ReplaceAll(query, "%", "%%") + "\n"
+	return t, true
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Block, Inc.
+
+package schema_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/lint"
+	"github.com/square/finch/schema"
+)
+
+func TestWriteDigests(t *testing.T) {
+	digests := []schema.Digest{
+		{
+			Digest: "aaa",
+			Text:   "SELECT `c` FROM `t` WHERE `id` = ?",
+			Count:  300,
+		},
+		{
+			Digest: "bbb",
+			Text:   "SELECT `c` FROM `t` WHERE `c` LIKE ? ...", // truncated
+			Count:  200,
+		},
+		{
+			Digest: "ccc",
+			Text:   "INSERT INTO `t` ( `id` , `c` ) VALUES (...)",
+			Sample: "INSERT INTO t (id, c) VALUES (1, '100%')",
+			Count:  80,
+		},
+		{
+			Digest: "ddd",
+			Text:   "UPDATE `t` SET `c` = ? WHERE `id` = ?",
+			Count:  20,
+		},
+	}
+
+	dir := t.TempDir()
+	files, err := schema.WriteDigests(dir, digests, schema.DefaultTop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFiles := []string{"_all.yaml", "capture.yaml", "trx/digest-01.sql", "trx/digest-02.sql", "trx/digest-03.sql"}
+	for i := range expectFiles {
+		expectFiles[i] = filepath.Join(dir, expectFiles[i])
+	}
+	if diff := deep.Equal(files, expectFiles); diff != nil {
+		t.Error(diff)
+	}
+
+	expectSQL := map[string]string{
+		"trx/digest-01.sql": "SELECT `c` FROM `t` WHERE `id` = @d01_1\n",
+		"trx/digest-02.sql": "INSERT INTO t (id, c) VALUES (1, '100%%')\n",
+		"trx/digest-03.sql": "UPDATE `t` SET `c` = @d03_1 WHERE `id` = @d03_2\n",
+	}
+	for name, expect := range expectSQL {
+		bytes, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes) != expect {
+			t.Errorf("%s: got %q, expected %q", name, string(bytes), expect)
+		}
+	}
+
+	// Trx are weighted by count: 300/400, 80/400, and 20/400
+	bytes, err := os.ReadFile(filepath.Join(dir, "capture.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"    - clients: \"$params.clients\"\n",
+		"# digest aaa: 300 executions (75.0%)\n    - file: trx/digest-01.sql\n      weight: 300\n",
+		"# digest ccc: 80 executions (20.0%)\n    - file: trx/digest-02.sql\n      weight: 80\n",
+		"# digest ddd: 20 executions (5.0%)\n    - file: trx/digest-03.sql\n      weight: 20\n",
+	} {
+		if !strings.Contains(string(bytes), expect) {
+			t.Errorf("capture.yaml does not contain %q:\n%s", expect, bytes)
+		}
+	}
+
+	// Generated stage file is valid and lint clean
+	for _, p := range lint.Files([]string{filepath.Join(dir, "capture.yaml")}, nil) {
+		t.Error(p)
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2024 Block, Inc.
+
+// Package schema inspects existing tables and writes starter stage and trx
+// files for them (--init). Data generators are inferred from column types.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/square/finch"
+)
+
+// Column is a column from information_schema.columns.
+type Column struct {
+	Name      string
+	DataType  string // DATA_TYPE: int, varchar, etc.
+	MaxLen    int64  // CHARACTER_MAXIMUM_LENGTH, or 0
+	Unsigned  bool
+	Primary   bool // part of primary key
+	AutoInc   bool
+	Generated bool // virtual or stored generated column
+}
+
+// Table is a table and its columns in ordinal order.
+type Table struct {
+	Db      string
+	Name    string
+	Columns []Column
+	// --
+	prefix string // data key prefix when writing multiple tables
+}
+
+// PrimaryKey returns the primary key columns, or nil if the table doesn't have one.
+func (t Table) PrimaryKey() []Column {
+	var pk []Column
+	for _, c := range t.Columns {
+		if c.Primary {
+			pk = append(pk, c)
+		}
+	}
+	return pk
+}
+
+// Inspect returns the tables in database db. If tables is not empty, only those
+// tables are returned.
+func Inspect(ctx context.Context, conn *sql.DB, db string, tables []string) ([]Table, error) {
+	q := "SELECT TABLE_NAME, COLUMN_NAME, DATA_TYPE, COALESCE(CHARACTER_MAXIMUM_LENGTH, 0), COLUMN_TYPE, COLUMN_KEY, EXTRA" +
+		" FROM information_schema.columns WHERE TABLE_SCHEMA=?"
+	args := []interface{}{db}
+	if len(tables) > 0 {
+		q += " AND TABLE_NAME IN (" + strings.TrimSuffix(strings.Repeat("?,", len(tables)), ",") + ")"
+		for _, t := range tables {
+			args = append(args, t)
+		}
+	}
+	q += " ORDER BY TABLE_NAME, ORDINAL_POSITION"
+	finch.Debug(q)
+
+	rows, err := conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := []Table{}
+	for rows.Next() {
+		var tbl, colType, key, extra string
+		var c Column
+		if err := rows.Scan(&tbl, &c.Name, &c.DataType, &c.MaxLen, &colType, &key, &extra); err != nil {
+			return nil, err
+		}
+		c.DataType = strings.ToLower(c.DataType)
+		c.Unsigned = strings.Contains(colType, "unsigned")
+		c.Primary = key == "PRI"
+		extra = strings.ToLower(extra)
+		c.AutoInc = strings.Contains(extra, "auto_increment")
+		c.Generated = strings.Contains(extra, "generated")
+		if len(all) == 0 || all[len(all)-1].Name != tbl {
+			all = append(all, Table{Db: db, Name: tbl})
+		}
+		all[len(all)-1].Columns = append(all[len(all)-1].Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("no tables found in database %s", db)
+	}
+	return all, nil
+}
+
+// --------------------------------------------------------------------------
+
+// DefaultRows is the default value of the rows param in the generated _all.yaml.
+const DefaultRows = "100,000"
+
+// Write writes starter stage and trx files for the tables to dir:
+//
+//	_all.yaml           params.rows
+//	setup.yaml          stage to insert $params.rows rows in each table
+//	benchmark.yaml      stage to select and update rows by primary key
+//	trx/TABLE-insert.sql
+//	trx/TABLE-select.sql (if table has primary key)
+//	trx/TABLE-update.sql (if table has primary key and a column to update)
+//
+// Existing files are not overwritten; it returns an error instead. It returns
+// the list of files written.
+func Write(dir string, tables []Table) ([]string, error) {
+	files := map[string]string{} // file name -> content
+	var setup, bench []trxFile
+	for _, t := range tables {
+		// Data keys are shared by all trx files in a stage, so prefix them with
+		// the table name to keep columns with the same name separate
+		if len(tables) > 1 {
+			t.prefix = t.Name + "_"
+		}
+		ins := insert(t)
+		setup = append(setup, ins)
+		files[ins.file] = ins.sql
+		if sel, ok := selectByPK(t); ok {
+			bench = append(bench, sel)
+			files[sel.file] = sel.sql
+		}
+		if upd, ok := updateByPK(t); ok {
+			bench = append(bench, upd)
+			files[upd.file] = upd.sql
+		}
+	}
+
+	files["_all.yaml"] = fmt.Sprintf("# Generated by finch --init\nparams:\n  rows: \"%s\"\n", DefaultRows)
+	files["setup.yaml"] = stageYAML("setup", "", setup, true)
+	if len(bench) > 0 {
+		files["benchmark.yaml"] = stageYAML("benchmark", "60s", bench, false)
+	}
+
+	// Check all files first to write all or nothing
+	order := make([]string, 0, len(files))
+	for _, name := range []string{"_all.yaml", "setup.yaml", "benchmark.yaml"} {
+		if _, ok := files[name]; ok {
+			order = append(order, name)
+		}
+	}
+	for _, t := range append(setup, bench...) {
+		order = append(order, t.file)
+	}
+	return writeFiles(dir, order, files)
+}
+
+// writeFiles writes files (name -> content) to dir in the given order, or none
+// if any file exists. Names are relative to dir and can be in trx/.
+func writeFiles(dir string, order []string, files map[string]string) ([]string, error) {
+	for _, name := range order {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return nil, fmt.Errorf("%s exists; not overwriting files", filepath.Join(dir, name))
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "trx"), 0755); err != nil {
+		return nil, err
+	}
+	written := make([]string, 0, len(order))
+	for _, name := range order {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(files[name]), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, file)
+	}
+	return written, nil
+}
+
+// trxFile is a generated trx file and the data keys it uses.
+type trxFile struct {
+	file    string // trx/TABLE-insert.sql
+	sql     string
+	keys    []dataKey
+	comment string // YAML comment before trx in stage file, if any
+	weight  uint64 // trx weight, if not zero
+}
+
+type dataKey struct {
+	name      string // without @
+	generator string
+	params    [][2]string // ordered key-value pairs
+}
+
+var reKey = regexp.MustCompile(`[^\w-]`)
+
+// key returns the data key name for column c in table t (without @).
+func key(t Table, c Column) string {
+	return reKey.ReplaceAllString(t.prefix+c.Name, "_")
+}
+
+func insert(t Table) trxFile {
+	cols := []string{}
+	vals := []string{}
+	keys := []dataKey{}
+	for _, c := range t.Columns {
+		if c.AutoInc || c.Generated {
+			continue
+		}
+		cols = append(cols, "`"+c.Name+"`")
+		if k, ok := generator(t, c, true); ok {
+			vals = append(vals, "@"+k.name)
+			keys = append(keys, k)
+			continue
+		}
+		vals = append(vals, literal(c))
+	}
+	return trxFile{
+		file: "trx/" + t.Name + "-insert.sql",
+		sql: fmt.Sprintf("-- rows: $params.rows\nINSERT INTO `%s`.`%s` (%s) VALUES (%s)\n",
+			t.Db, t.Name, strings.Join(cols, ", "), strings.Join(vals, ", ")),
+		keys: keys,
+	}
+}
+
+func selectByPK(t Table) (trxFile, bool) {
+	pk := t.PrimaryKey()
+	if len(pk) == 0 {
+		return trxFile{}, false
+	}
+	where, keys, ok := wherePK(t, pk)
+	if !ok {
+		return trxFile{}, false
+	}
+	cols := make([]string, len(t.Columns))
+	for i := range t.Columns {
+		cols[i] = "`" + t.Columns[i].Name + "`"
+	}
+	return trxFile{
+		file: "trx/" + t.Name + "-select.sql",
+		sql:  fmt.Sprintf("SELECT %s FROM `%s`.`%s` WHERE %s\n", strings.Join(cols, ", "), t.Db, t.Name, where),
+		keys: keys,
+	}, true
+}
+
+func updateByPK(t Table) (trxFile, bool) {
+	pk := t.PrimaryKey()
+	if len(pk) == 0 {
+		return trxFile{}, false
+	}
+	where, keys, ok := wherePK(t, pk)
+	if !ok {
+		return trxFile{}, false
+	}
+	for _, c := range t.Columns {
+		if c.Primary || c.AutoInc || c.Generated {
+			continue
+		}
+		k, ok := generator(t, c, false)
+		if !ok {
+			continue
+		}
+		return trxFile{
+			file: "trx/" + t.Name + "-update.sql",
+			sql:  fmt.Sprintf("UPDATE `%s`.`%s` SET `%s`=@%s WHERE %s\n", t.Db, t.Name, c.Name, k.name, where),
+			keys: append([]dataKey{k}, keys...),
+		}, true
+	}
+	return trxFile{}, false
+}
+
+// wherePK returns "pk1=@pk1 AND pk2=@pk2" and the data keys for the primary
+// key columns. Primary key values are random integers up to $params.rows, so
+// it returns false if any primary key column is not an integer.
+func wherePK(t Table, pk []Column) (string, []dataKey, bool) {
+	conds := make([]string, len(pk))
+	keys := make([]dataKey, len(pk))
+	for i, c := range pk {
+		if !isInt(c.DataType) {
+			return "", nil, false
+		}
+		keys[i] = dataKey{name: key(t, c), generator: "int", params: [][2]string{{"max", "$params.rows"}}}
+		conds[i] = fmt.Sprintf("`%s`=@%s", c.Name, keys[i].name)
+	}
+	return strings.Join(conds, " AND "), keys, true
+}
+
+// generator returns the data key for column c inferred from its data type, or
+// false if there isn't a data generator for the type (see literal).
+func generator(t Table, c Column, insert bool) (dataKey, bool) {
+	k := dataKey{name: key(t, c)}
+	switch {
+	case isInt(c.DataType):
+		if c.Primary && insert {
+			k.generator = "auto-inc" // unique values
+			return k, true
+		}
+		k.generator = "int"
+		if c.Primary {
+			k.params = [][2]string{{"max", "$params.rows"}}
+		} else if max := intMax(c); max != "" {
+			k.params = [][2]string{{"max", max}}
+		}
+	case c.DataType == "decimal" || c.DataType == "float" || c.DataType == "double":
+		k.generator = "int"
+		k.params = [][2]string{{"max", "100000"}}
+	case strings.HasSuffix(c.DataType, "char") || strings.HasSuffix(c.DataType, "text") ||
+		strings.HasSuffix(c.DataType, "binary") || strings.HasSuffix(c.DataType, "blob"):
+		n := c.MaxLen
+		if n <= 0 || n > 100 {
+			n = 100
+		}
+		k.generator = "str-fill-az"
+		k.params = [][2]string{{"len", fmt.Sprintf("%d", n)}}
+	default:
+		return k, false
+	}
+	return k, true
+}
+
+// literal returns a SQL value for column c when there's no data generator for
+// its type.
+func literal(c Column) string {
+	switch c.DataType {
+	case "date", "datetime", "timestamp":
+		return "NOW()"
+	case "time":
+		return "CURTIME()"
+	case "year":
+		return "YEAR(NOW())"
+	}
+	return "DEFAULT"
+}
+
+func isInt(dataType string) bool {
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// intMax returns the max value of small integer types, else "" (use the int
+// generator default).
+func intMax(c Column) string {
+	switch c.DataType {
+	case "tinyint":
+		if c.Unsigned {
+			return "255"
+		}
+		return "127"
+	case "smallint":
+		if c.Unsigned {
+			return "65535"
+		}
+		return "32767"
+	}
+	return ""
+}
+
+func stageYAML(name, runtime string, trx []trxFile, setup bool) string {
+	var b strings.Builder
+	b.WriteString("# Generated by finch --init\n")
+	b.WriteString("stage:\n")
+	fmt.Fprintf(&b, "  name: %s\n", name)
+	if runtime != "" {
+		fmt.Fprintf(&b, "  runtime: %s\n", runtime)
+	}
+	if setup {
+		b.WriteString("  stats:\n    disable: true\n")
+	}
+	trxYAML(&b, trx)
+	return b.String()
+}
+
+// trxYAML writes the stage.trx section.
+func trxYAML(b *strings.Builder, trx []trxFile) {
+	b.WriteString("  trx:\n")
+	for _, t := range trx {
+		if t.comment != "" {
+			fmt.Fprintf(b, "    # %s\n", t.comment)
+		}
+		fmt.Fprintf(b, "    - file: %s\n", t.file)
+		if t.weight > 0 {
+			fmt.Fprintf(b, "      weight: %d\n", t.weight)
+		}
+		if len(t.keys) == 0 {
+			continue
+		}
+		b.WriteString("      data:\n")
+		seen := map[string]bool{}
+		for _, k := range t.keys {
+			if seen[k.name] {
+				continue
+			}
+			seen[k.name] = true
+			fmt.Fprintf(b, "        %s:\n", k.name)
+			fmt.Fprintf(b, "          generator: %s\n", k.generator)
+			if len(k.params) == 0 {
+				continue
+			}
+			b.WriteString("          params:\n")
+			for _, p := range k.params {
+				fmt.Fprintf(b, "            %s: %s\n", p[0], p[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Block, Inc.
+
+package schema_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/lint"
+	"github.com/square/finch/schema"
+)
+
+func TestWrite(t *testing.T) {
+	tables := []schema.Table{
+		{
+			Db:   "test",
+			Name: "t1",
+			Columns: []schema.Column{
+				{Name: "id", DataType: "int", Unsigned: true, Primary: true, AutoInc: true},
+				{Name: "k", DataType: "tinyint"},
+				{Name: "c", DataType: "varchar", MaxLen: 20},
+				{Name: "ts", DataType: "timestamp"},
+			},
+		},
+		{
+			Db:   "test",
+			Name: "t2", // no primary key
+			Columns: []schema.Column{
+				{Name: "c", DataType: "text", MaxLen: 65535},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	files, err := schema.Write(dir, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFiles := []string{"_all.yaml", "setup.yaml", "benchmark.yaml", "trx/t1-insert.sql", "trx/t2-insert.sql", "trx/t1-select.sql", "trx/t1-update.sql"}
+	for i := range expectFiles {
+		expectFiles[i] = filepath.Join(dir, expectFiles[i])
+	}
+	if diff := deep.Equal(files, expectFiles); diff != nil {
+		t.Error(diff)
+	}
+
+	expectSQL := map[string]string{
+		"trx/t1-insert.sql": "-- rows: $params.rows\nINSERT INTO `test`.`t1` (`k`, `c`, `ts`) VALUES (@t1_k, @t1_c, NOW())\n",
+		"trx/t2-insert.sql": "-- rows: $params.rows\nINSERT INTO `test`.`t2` (`c`) VALUES (@t2_c)\n",
+		"trx/t1-select.sql": "SELECT `id`, `k`, `c`, `ts` FROM `test`.`t1` WHERE `id`=@t1_id\n",
+		"trx/t1-update.sql": "UPDATE `test`.`t1` SET `k`=@t1_k WHERE `id`=@t1_id\n",
+	}
+	for name, expect := range expectSQL {
+		bytes, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes) != expect {
+			t.Errorf("%s: got %q, expected %q", name, string(bytes), expect)
+		}
+	}
+
+	// Generated stage files are valid and lint clean
+	problems := lint.Files([]string{filepath.Join(dir, "setup.yaml"), filepath.Join(dir, "benchmark.yaml")}, nil)
+	for _, p := range problems {
+		t.Error(p)
+	}
+
+	// Doesn't overwrite existing files
+	if _, err := schema.Write(dir, tables); err == nil {
+		t.Error("no error writing files that exist, expected an error")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Block, Inc.
+
+//go:build linux
+
+package stage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// cpuMask is a Linux cpu_set_t for up to 1024 CPUs.
+type cpuMask [16]uint64
+
+// setAffinity pins all Finch threads to the CPUs (config.stage.cpu-set) and
+// returns a func to restore the previous affinity. Linux CPU affinity is per
+// thread, and new threads inherit the affinity of the thread that creates them,
+// so setting every current thread pins the whole process.
+func setAffinity(cpus []int) (func(), error) {
+	var prev cpuMask
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, 0, &prev); err != nil {
+		return nil, fmt.Errorf("sched_getaffinity: %s", err)
+	}
+	var mask cpuMask
+	for _, n := range cpus {
+		if n >= len(mask)*64 {
+			return nil, fmt.Errorf("CPU %d out of range: max %d", n, len(mask)*64-1)
+		}
+		mask[n/64] |= 1 << (uint(n) % 64)
+	}
+	if err := setAllThreads(&mask); err != nil {
+		setAllThreads(&prev)
+		return nil, err
+	}
+	return func() { setAllThreads(&prev) }, nil
+}
+
+func setAllThreads(mask *cpuMask) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, tid, mask); err != nil {
+			if err == syscall.ESRCH {
+				continue // thread exited
+			}
+			return fmt.Errorf("sched_setaffinity thread %d: %s", tid, err)
+		}
+	}
+	return nil
+}
+
+func schedAffinity(trap uintptr, tid int, mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(trap, uintptr(tid), unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
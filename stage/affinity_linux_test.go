@@ -0,0 +1,40 @@
+// Copyright 2024 Block, Inc.
+
+//go:build linux
+
+package stage
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSetAffinity(t *testing.T) {
+	var prev cpuMask
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, 0, &prev); err != nil {
+		t.Fatal(err)
+	}
+	if prev[0]&1 == 0 {
+		t.Skip("CPU 0 not available")
+	}
+
+	restore, err := setAffinity([]int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got cpuMask
+	schedAffinity(syscall.SYS_SCHED_GETAFFINITY, 0, &got)
+	if got != (cpuMask{1}) {
+		t.Errorf("got affinity %x, expected CPU 0 only", got[0])
+	}
+
+	restore()
+	schedAffinity(syscall.SYS_SCHED_GETAFFINITY, 0, &got)
+	if got != prev {
+		t.Errorf("got affinity %x after restore, expected %x", got[0], prev[0])
+	}
+
+	if _, err := setAffinity([]int{len(cpuMask{}) * 64}); err == nil {
+		t.Error("got nil error for CPU out of range, expected an error")
+	}
+}
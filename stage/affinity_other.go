@@ -0,0 +1,13 @@
+// Copyright 2024 Block, Inc.
+
+//go:build !linux
+
+package stage
+
+import "fmt"
+
+// setAffinity is not supported on this platform. config.Stage.Validate returns
+// an error if config.stage.cpu-set is set, so this shouldn't be called.
+func setAffinity(cpus []int) (func(), error) {
+	return nil, fmt.Errorf("cpu-set is only supported on Linux")
+}
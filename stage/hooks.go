@@ -0,0 +1,230 @@
+// Copyright 2024 Block, Inc.
+
+package stage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/finch/config"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/stats"
+)
+
+// RunHooks runs config.stage.before or config.stage.after hooks (when is "before"
+// or "after") in order. Hook output is appended to the hook output file, if set,
+// else it's logged. It returns the error of the first hook that fails, and the
+// remaining hooks are not run. It's called once per stage by the server, not by
+// each compute instance.
+func RunHooks(ctx context.Context, cfg config.Stage, when string, hooks []config.Hook) error {
+	for i := range hooks {
+		name := fmt.Sprintf("%s[%d]", when, i)
+		if err := runHook(ctx, cfg, name, hooks[i]); err != nil {
+			return fmt.Errorf("%s: %s: %s", name, hookString(hooks[i]), err)
+		}
+	}
+	return nil
+}
+
+// RunEvents runs config.stage.events at their scheduled time after the stage
+// starts running clients, which is when RunEvents is called. Events run
+// concurrently, so a long-running event doesn't delay the next. Each event is
+// reported to the stats collector, if not nil, so it's embedded in the stats
+// of the interval in which it ran. Unlike hooks, an event error doesn't stop
+// the stage; it's logged and reported. RunEvents returns when all events have
+// run or ctx is cancelled, which the caller does when the stage is done.
+func RunEvents(ctx context.Context, cfg config.Stage, c *stats.Collector) {
+	if len(cfg.Events) == 0 {
+		return
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range cfg.Events {
+		e := cfg.Events[i]
+		at, _ := time.ParseDuration(e.At) // already validated
+		name := fmt.Sprintf("events[%d]", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timer := time.NewTimer(at)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				log.Printf("[%s] %s: stage done before %s, not run: %s", cfg.Name, name, e.At, hookString(e.Hook))
+				return
+			}
+			t0 := time.Now()
+			err := runHook(ctx, cfg, name, e.Hook)
+			ev := stats.Event{
+				Time:    t0,
+				Runtime: t0.Sub(start).Seconds(),
+				Event:   name + ": " + hookString(e.Hook),
+			}
+			if err != nil {
+				ev.Error = err.Error()
+				log.Printf("[%s] %s: %s: error: %s", cfg.Name, name, hookString(e.Hook), err)
+			}
+			if c != nil {
+				c.Event(ev)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runHook runs one hook and writes or logs its output.
+func runHook(ctx context.Context, cfg config.Stage, name string, h config.Hook) error {
+	var ctxHook context.Context
+	var cancel context.CancelFunc
+	if h.Timeout != "" {
+		d, _ := time.ParseDuration(h.Timeout) // already validated
+		ctxHook, cancel = context.WithTimeout(ctx, d)
+	} else {
+		ctxHook, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var out []byte
+	var err error
+	t0 := time.Now()
+	switch {
+	case h.Exec != "":
+		cmd := exec.CommandContext(ctxHook, "sh", "-c", h.Exec)
+		cmd.WaitDelay = time.Second // don't wait on children of killed shell holding output
+		out, err = cmd.CombinedOutput()
+	case h.SQL != "":
+		out, err = runSQL(ctxHook, cfg, splitSQL(h.SQL))
+	case h.SQLFile != "":
+		var b []byte
+		b, err = os.ReadFile(h.SQLFile)
+		if err == nil {
+			out, err = runSQL(ctxHook, cfg, splitSQL(string(b)))
+		}
+	}
+	d := time.Since(t0)
+	log.Printf("[%s] %s: %s (%.3fs)", cfg.Name, name, hookString(h), d.Seconds())
+
+	if h.Output != "" {
+		if werr := writeHookOutput(h.Output, cfg.Name, name, h, t0, out); werr != nil {
+			log.Printf("[%s] %s: error writing output to %s: %s", cfg.Name, name, h.Output, werr)
+		}
+	} else if len(out) > 0 {
+		log.Printf("[%s] %s output:\n%s", cfg.Name, name, strings.TrimRight(string(out), "\n"))
+	}
+	return err
+}
+
+func hookString(h config.Hook) string {
+	switch {
+	case h.Exec != "":
+		return "exec " + h.Exec
+	case h.SQL != "":
+		return "sql " + h.SQL
+	}
+	return "sql-file " + h.SQLFile
+}
+
+// writeHookOutput appends hook output to file with a header line, so multiple
+// hooks and stages can write to the same file.
+func writeHookOutput(file, stageName, name string, h config.Hook, t0 time.Time, out []byte) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "-- %s %s %s: %s\n", t0.Format(time.RFC3339), stageName, name, hookString(h))
+	f.Write(out)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		f.Write([]byte("\n"))
+	}
+	return f.Close()
+}
+
+// runSQL executes the statements on one connection (so session variables set
+// by one statement apply to the next) and returns result sets like the mysql
+// CLI in batch mode: column names, then rows, tab-separated.
+func runSQL(ctx context.Context, cfg config.Stage, stmts []string) ([]byte, error) {
+	db, _, err := dbconn.MakeConfig(cfg.MySQL) // same MySQL config as Stage.Prepare
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var out bytes.Buffer
+	for _, q := range stmts {
+		rows, err := conn.QueryContext(ctx, q)
+		if err != nil {
+			return out.Bytes(), fmt.Errorf("%s: %s", q, err)
+		}
+		cols, _ := rows.Columns()
+		if len(cols) > 0 {
+			out.WriteString(strings.Join(cols, "\t") + "\n")
+		}
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		row := make([]string, len(cols))
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return out.Bytes(), fmt.Errorf("%s: %s", q, err)
+			}
+			for i := range vals {
+				if vals[i].Valid {
+					row[i] = vals[i].String
+				} else {
+					row[i] = "NULL"
+				}
+			}
+			out.WriteString(strings.Join(row, "\t") + "\n")
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return out.Bytes(), fmt.Errorf("%s: %s", q, err)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// splitSQL splits a SQL script into statements terminated by ; at the end of a
+// line. Comment lines (-- and #) and empty statements are removed.
+func splitSQL(s string) []string {
+	stmts := []string{}
+	var stmt []string
+	for _, line := range strings.Split(s, "\n") {
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "--") || strings.HasPrefix(t, "#") {
+			continue
+		}
+		end := strings.HasSuffix(t, ";")
+		if end {
+			t = strings.TrimSpace(strings.TrimSuffix(t, ";"))
+		}
+		if t != "" {
+			stmt = append(stmt, t)
+		}
+		if end && len(stmt) > 0 {
+			stmts = append(stmts, strings.Join(stmt, " "))
+			stmt = nil
+		}
+	}
+	if len(stmt) > 0 {
+		stmts = append(stmts, strings.Join(stmt, " "))
+	}
+	return stmts
+}
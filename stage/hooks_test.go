@@ -0,0 +1,107 @@
+// Copyright 2024 Block, Inc.
+
+package stage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/config"
+)
+
+func TestRunHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hooks.txt")
+	cfg := config.Stage{Name: "test"}
+	hooks := []config.Hook{
+		{Exec: "echo hello", Output: out},
+		{Exec: "exit 3", Output: out},
+		{Exec: "echo not run", Output: out},
+	}
+	err := RunHooks(context.Background(), cfg, "before", hooks)
+	if err == nil {
+		t.Fatal("no error, expected error from exit 3")
+	}
+	if !strings.HasPrefix(err.Error(), "before[1]: ") {
+		t.Errorf("error '%s' does not start with before[1]", err)
+	}
+
+	bytes, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(bytes)
+	if !strings.Contains(got, "test before[0]: exec echo hello\nhello\n") {
+		t.Errorf("before[0] output not in file:\n%s", got)
+	}
+	if !strings.Contains(got, "test before[1]: exec exit 3\n") {
+		t.Errorf("before[1] header not in file:\n%s", got)
+	}
+	if strings.Contains(got, "not run") {
+		t.Errorf("before[2] ran after before[1] failed:\n%s", got)
+	}
+
+	// Timeout kills hook
+	hooks = []config.Hook{{Exec: "sleep 5", Timeout: "100ms"}}
+	if err := RunHooks(context.Background(), cfg, "after", hooks); err == nil {
+		t.Error("no error, expected error from timeout")
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events.txt")
+	cfg := config.Stage{
+		Name: "test",
+		Events: []config.Event{
+			{At: "10ms", Hook: config.Hook{Exec: "echo event0", Output: out}},
+			{At: "1h", Hook: config.Hook{Exec: "echo event1", Output: out}},
+		},
+	}
+
+	// Stage done before events[1], so RunEvents returns after events[0] and
+	// when ctx is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	RunEvents(ctx, cfg, nil)
+	if d := time.Since(t0); d > time.Second {
+		t.Errorf("RunEvents returned after %s, expected about 200ms", d)
+	}
+
+	bytes, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(bytes)
+	if !strings.Contains(got, "event0") {
+		t.Errorf("events[0] output not in file:\n%s", got)
+	}
+	if strings.Contains(got, "event1") {
+		t.Errorf("events[1] ran, expected it not to run:\n%s", got)
+	}
+}
+
+func TestSplitSQL(t *testing.T) {
+	sql := `-- comment
+SET @a = 1;
+# comment
+SELECT c
+  FROM t
+ WHERE id = 1;
+
+SELECT 'a;b'`
+	got := splitSQL(sql)
+	expect := []string{
+		"SET @a = 1",
+		"SELECT c FROM t WHERE id = 1",
+		"SELECT 'a;b'",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Block, Inc.
+
+package stage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// blockProfileRate is the runtime.SetBlockProfileRate (ns) for --block-profile:
+// blocking events longer than 1μs are always recorded, shorter events are sampled.
+const blockProfileRate = 1000
+
+// resources measures Finch process resource usage while a stage runs for
+// --resource-summary. It's the load generator, not MySQL: if Finch uses all CPU
+// available to it, for example, then results might be limited by Finch.
+type resources struct {
+	wall       time.Time
+	cpu        time.Duration
+	numGC      uint32
+	pauseTotal time.Duration
+	goroutines int64 // peak (atomic)
+	stopChan   chan struct{}
+	doneChan   chan struct{}
+}
+
+// startResources starts measuring resource usage. Call stop to get the summary.
+func startResources() *resources {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	r := &resources{
+		wall:       time.Now(),
+		cpu:        cpuTime(),
+		numGC:      m.NumGC,
+		pauseTotal: time.Duration(m.PauseTotalNs),
+		stopChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+	}
+	go r.sample()
+	return r
+}
+
+// sample samples the number of goroutines every 100ms to find the peak.
+func (r *resources) sample() {
+	defer close(r.doneChan)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&r.goroutines) {
+			atomic.StoreInt64(&r.goroutines, n)
+		}
+		select {
+		case <-ticker.C:
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// stop stops measuring and returns the resource summary, like:
+//
+//	CPU 1.52s (76% of 2.00s, GOMAXPROCS 8), peak RSS 48 MB, peak goroutines 70, GC 12 cycles (3.1ms pause)
+func (r *resources) stop() string {
+	close(r.stopChan)
+	<-r.doneChan
+	wall := time.Since(r.wall)
+	cpu := cpuTime() - r.cpu
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var pct float64
+	if wall > 0 {
+		pct = float64(cpu) / float64(wall) * 100
+	}
+	rss := "n/a"
+	if n := maxRSS(); n > 0 {
+		rss = fmt.Sprintf("%d MB", n/(1024*1024))
+	}
+	return fmt.Sprintf("CPU %.2fs (%.0f%% of %.2fs, GOMAXPROCS %d), peak RSS %s, peak goroutines %d, GC %d cycles (%s pause)",
+		cpu.Seconds(), pct, wall.Seconds(), runtime.GOMAXPROCS(0), rss, atomic.LoadInt64(&r.goroutines),
+		m.NumGC-r.numGC, (time.Duration(m.PauseTotalNs) - r.pauseTotal).Round(time.Microsecond))
+}
+
+// writeProfiles writes --mem-profile and --block-profile, if set. Both profiles
+// are cumulative since Finch started, so the file is rewritten after each stage.
+func writeProfiles(stageName string) {
+	if finch.MemProfile != "" {
+		writeProfile(stageName, "allocs", finch.MemProfile)
+	}
+	if finch.BlockProfile != "" {
+		writeProfile(stageName, "block", finch.BlockProfile)
+	}
+}
+
+func writeProfile(stageName, profile, file string) {
+	f, err := os.Create(file)
+	if err != nil {
+		log.Printf("[%s] Error writing %s profile: %s", stageName, profile, err)
+		return
+	}
+	defer f.Close()
+	if err := pprof.Lookup(profile).WriteTo(f, 0); err != nil {
+		log.Printf("[%s] Error writing %s profile: %s", stageName, profile, err)
+	}
+}
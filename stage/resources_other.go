@@ -0,0 +1,17 @@
+// Copyright 2024 Block, Inc.
+
+//go:build !unix
+
+package stage
+
+import "time"
+
+// cpuTime is not supported on this platform, so the resource summary reports 0s CPU.
+func cpuTime() time.Duration {
+	return 0
+}
+
+// maxRSS is not supported on this platform, so the resource summary reports n/a.
+func maxRSS() int64 {
+	return 0
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Block, Inc.
+
+package stage
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+var (
+	// SaturationCPU is the fraction of available CPU (GOMAXPROCS or the number
+	// of CPUs, whichever is less) above which Finch is probably saturated if no
+	// QPS or TPS limit is limiting clients.
+	SaturationCPU = 0.90
+
+	// SaturationBehind is the fraction of unused rate limiter allowances above
+	// which clients are behind schedule: they can't execute at the configured
+	// QPS or TPS rate.
+	SaturationBehind = 0.10
+)
+
+// saturation detects when results might be limited by Finch (the load generator)
+// rather than MySQL. It's measured from the start to the end of Stage.Run.
+type saturation struct {
+	wall time.Time
+	cpu  time.Duration
+}
+
+func startSaturation() saturation {
+	return saturation{
+		wall: time.Now(),
+		cpu:  cpuTime(),
+	}
+}
+
+// check returns a warning if Finch might be saturated, else "". Finch is
+// saturated if it used more than SaturationCPU and clients aren't rate limited,
+// or clients are behind schedule by more than SaturationBehind. In the latter case,
+// Finch might not be the bottleneck (MySQL can be too slow to keep up with the
+// configured rate), so the warning includes CPU usage to tell the difference.
+func (sat saturation) check(s *Stage) string {
+	cores := runtime.GOMAXPROCS(0)
+	if n := runtime.NumCPU(); n < cores {
+		cores = n
+	}
+	wall := time.Since(sat.wall)
+	if wall <= 0 {
+		return ""
+	}
+	cpu := float64(cpuTime()-sat.cpu) / float64(wall) / float64(cores)
+
+	// Clients in a client group can have a QPS and a TPS limit (and limits are
+	// combined with exec group and stage limits). The limit that's not reached
+	// drops allowances because clients are waiting for the other limit, so the
+	// client group is behind schedule only if all its limits are behind.
+	limited := false
+	behind := 0.0 // max of all client groups
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			if s.execGroups[egNo][cgNo].Arrivals != nil {
+				limited = true // open-loop: queueing delay shows when clients are behind
+			}
+			cgBehind := -1.0 // min of client group rates
+			for _, r := range s.execGroups[egNo][cgNo].Rates {
+				allowed, dropped := r.Pacing()
+				if allowed == 0 {
+					continue
+				}
+				limited = true
+				if b := float64(dropped) / float64(allowed); cgBehind < 0 || b < cgBehind {
+					cgBehind = b
+				}
+			}
+			if cgBehind > behind {
+				behind = cgBehind
+			}
+		}
+	}
+
+	switch {
+	case behind > SaturationBehind:
+		return fmt.Sprintf("Finch might be saturated: clients are behind QPS/TPS limits (%.0f%% of allowances unused), Finch CPU %.0f%% of %d cores",
+			behind*100, cpu*100, cores)
+	case !limited && cpu > SaturationCPU:
+		return fmt.Sprintf("Finch might be saturated: Finch CPU %.0f%% of %d cores with no QPS/TPS limits; results might be limited by Finch, not MySQL",
+			cpu*100, cores)
+	}
+	return ""
+}
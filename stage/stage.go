@@ -5,8 +5,12 @@ package stage
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"runtime"
 	"runtime/pprof"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/square/finch"
@@ -20,6 +24,10 @@ import (
 	"github.com/square/finch/workload"
 )
 
+// localBlocks leases key blocks when Stage.New is not given a BlockFunc. It's
+// one per process so blocks continue across stages.
+var localBlocks = limit.NewArbiter()
+
 // Stage allocates and runs a workload. It handles stats for the workload,
 // including reporting. A stage has a two-phase execute: Prepare to set up
 // everything, then Run to execute clients (which execute queries). Run is
@@ -28,64 +36,274 @@ type Stage struct {
 	cfg   config.Stage
 	gds   *data.Scope
 	stats *stats.Collector
+	lease limit.LeaseFunc // nil unless multiple compute instances
+	block limit.BlockFunc // nil for local-only (then localBlocks)
 	// --
+	clock      *client.CoarseClock      // config.stats.clock: coarse, else nil
+	throttle   *limit.Throttle          // config.stage.throttle, else nil
+	pattern    *limit.Pattern           // config.stage.pattern, else nil
+	runtime    *limit.Runtime           // config.stage.runtime progress, if stats
 	doneChan   chan *client.Client      // <-Client.Run()
 	execGroups [][]workload.ClientGroup // [n][Client]
+	running    int64                    // clients running (atomic)
+	errors     int64                    // clients stopped on error (atomic)
 }
 
-func New(cfg config.Stage, gds *data.Scope, stats *stats.Collector) *Stage {
+func New(cfg config.Stage, gds *data.Scope, stats *stats.Collector, lease limit.LeaseFunc, block limit.BlockFunc) *Stage {
 	return &Stage{
 		cfg:   cfg,
 		gds:   gds,
 		stats: stats,
+		lease: lease,
+		block: block,
 		// --
 		doneChan: make(chan *client.Client, 1),
 	}
 }
 
 func (s *Stage) Prepare(ctxFinch context.Context) error {
-	if len(s.cfg.Trx) == 0 {
+	if len(s.cfg.Trx) == 0 && !s.cfg.HasScript() {
 		panic("Stage.Prepare called with zero trx")
 	}
 
 	// Test connection to MySQL
 	dbconn.SetConfig(s.cfg.MySQL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if config.True(s.cfg.MySQL.CreateDb) {
+		dbName, err := dbconn.CreateDb(ctx)
+		if err != nil {
+			return fmt.Errorf("mysql.create-db: %s", err)
+		}
+		if dbName != "" {
+			log.Printf("Created database %s (if not exists)", dbName)
+		}
+	}
 	db, dsnRedacted, err := dbconn.Make()
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 	if err := db.PingContext(ctx); err != nil {
 		return fmt.Errorf("test connection to MySQL failed: %s: %s", dsnRedacted, err)
 	}
 	db.Close() // test conn
 	log.Printf("Connected to %s", dsnRedacted)
 
+	// Test connection to reader, if any (mysql.reader)
+	db, dsnRedacted, err = dbconn.MakeReader()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("test connection to MySQL reader failed: %s: %s", dsnRedacted, err)
+		}
+		db.Close() // test conn
+		log.Printf("Connected to reader %s", dsnRedacted)
+	}
+
+	// Test connection for each client group transport, if any (workload.transport)
+	tested := map[string]bool{}
+	for _, cg := range s.cfg.Workload {
+		if cg.Transport == "" || tested[cg.Transport] {
+			continue
+		}
+		tested[cg.Transport] = true
+		db, dsnRedacted, err = dbconn.MakeTransport(cg.Transport)
+		if err != nil {
+			return err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("test connection to MySQL over %s failed: %s: %s", cg.Transport, dsnRedacted, err)
+		}
+		db.Close() // test conn
+		log.Printf("Connected to %s over %s", dsnRedacted, cg.Transport)
+	}
+
+	if err := s.prepare(); err != nil {
+		return err
+	}
+
+	// Create client group default databases once, not on every client connect
+	if config.True(s.cfg.MySQL.CreateDb) {
+		if err := s.createDbs(ctxFinch); err != nil {
+			return fmt.Errorf("mysql.create-db: %s", err)
+		}
+	}
+
+	// --explain: EXPLAIN statements to fail fast on syntax errors, and
+	// write the plans before running the stage
+	if finch.Explain != nil {
+		return s.explain(ctxFinch, finch.Explain, finch.ExplainAnalyze)
+	}
+	return nil
+}
+
+// DryRun prepares the stage without connecting to MySQL, then prints n iterations
+// of every client's statements with generated data values to w. The statements
+// are not executed. It's used instead of Prepare and Run for --dry-run.
+func (s *Stage) DryRun(w io.Writer, n uint) error {
+	if len(s.cfg.Trx) == 0 && !s.cfg.HasScript() {
+		panic("Stage.DryRun called with zero trx")
+	}
+	dbconn.SetConfig(s.cfg.MySQL)
+	if err := s.prepare(); err != nil {
+		return err
+	}
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			for _, c := range s.execGroups[egNo][cgNo].Clients {
+				c.DryRun(w, n)
+			}
+		}
+	}
+	return nil
+}
+
+// explain writes EXPLAIN plans for the statements of the first client in each
+// client group to w. Clients in the same group execute the same statements, so
+// explaining every client would only repeat the same plans.
+func (s *Stage) explain(ctx context.Context, w io.Writer, analyze bool) error {
+	fmt.Fprintf(w, "#\n# %s\n#\n", s.cfg.Name)
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			clients := s.execGroups[egNo][cgNo].Clients
+			if len(clients) == 0 {
+				continue
+			}
+			if err := clients[0].Explain(ctx, w, analyze); err != nil {
+				return fmt.Errorf("--explain: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// createDbs creates the default database of every client (config.stage.workload.db),
+// if set and it doesn't exist. Clients in a client group can have different
+// databases (like "db{client}"), so each unique database is created once.
+func (s *Stage) createDbs(ctx context.Context) error {
+	dbs := map[string]bool{}
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			for _, c := range s.execGroups[egNo][cgNo].Clients {
+				if c.DefaultDb != "" {
+					dbs[c.DefaultDb] = true
+				}
+			}
+		}
+	}
+	if len(dbs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	db, _, err := dbconn.Make()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, name := range names {
+		finch.Debug("create db %s", name)
+		if _, err := db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+dbconn.QuoteIdent(name)); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	log.Printf("Created %d client databases (if not exist)", len(names))
+	return nil
+}
+
+func (s *Stage) prepare() error {
 	// Load and validate all config.stage.trx files. This makes and validates all
 	// data generators, too. Being valid means only that the Finch config/setup is
 	// valid, not the SQL statements because those aren't run yet, so MySQL might
 	// still return errors on Run.
 	finch.Debug("load trx")
+	data.UnknownParams = s.cfg.Data.UnknownParams
 	trxSet, err := trx.Load(s.cfg.Trx, s.gds, s.cfg.Params)
 	if err != nil {
 		return err
 	}
 
+	// Restore generator state saved by a previous stage or run, if any, before
+	// data keys are copied (scoped) when clients are allocated
+	if s.cfg.Data.Persist != "" {
+		if err := trxSet.Data.LoadState(s.cfg.Data.Persist, s.cfg.Data.Keys); err != nil {
+			return fmt.Errorf("data.persist: %s", err)
+		}
+	}
+
+	// Pool generators must have keys: from a previous stage or run, or from a
+	// column generator in this stage
+	if err := data.CheckPools(); err != nil {
+		return err
+	}
+
+	// With multiple compute instances, share data limits so the aggregate limit
+	// is respected. The key must be the same on all instances, which it is because
+	// all instances load the same trx files.
+	if s.lease != nil {
+		for _, trxName := range trxSet.Order {
+			for i, stmt := range trxSet.Statements[trxName] {
+				if stmt.Limit == nil {
+					continue
+				}
+				stmt.Limit = limit.Share(stmt.Limit, fmt.Sprintf("%s/%d", trxName, i), s.lease)
+			}
+		}
+	}
+
 	// Allocate the workload (config.stage.workload): execution groups, client groups,
 	// clients, and trx assigned to clients. This is done in two steps. First, Groups
 	// returns the execution groups. Second, Clients returns the ready-to-run clients
 	// for each exec group. Both steps are required but separated for testing because
 	// the second is complex.
 	finch.Debug("alloc clients")
+	lease := s.lease
+	if lease == nil {
+		lease = limit.NewArbiter().Lease // local only
+	}
+	block := s.block
+	if block == nil {
+		block = localBlocks.Block // local only, but per process so blocks don't restart
+	}
+	data.SetBlockFunc(block) // data generators with param block, like auto-inc
+
+	qps, qpsBurst, _ := config.ParseRate(s.cfg.QPS) // already validated
+	tps, tpsBurst, _ := config.ParseRate(s.cfg.TPS)
 	a := workload.Allocator{
-		Stage:     s.cfg.N,
-		StageName: s.cfg.Name,
-		TrxSet:    trxSet,
-		Workload:  s.cfg.Workload,
-		StageQPS:  limit.NewRate(finch.Uint(s.cfg.QPS)), // nil if config.stage.qps == 0
-		StageTPS:  limit.NewRate(finch.Uint(s.cfg.TPS)), // nil if config.stage.tps == 0
-		DoneChan:  s.doneChan,
+		Stage:             s.cfg.N,
+		StageName:         s.cfg.Name,
+		TrxSet:            trxSet,
+		Workload:          s.cfg.Workload,
+		StageQPS:          limit.NewRate(qps, qpsBurst), // nil if config.stage.qps == 0
+		StageTPS:          limit.NewRate(tps, tpsBurst), // nil if config.stage.tps == 0
+		StageQPSPerSecond: qps,
+		StageTPSPerSecond: tps,
+		DoneChan:          s.doneChan,
+		Lease:             lease,
+		Nanos:             s.cfg.Stats.Precision == "ns",
+		Warnings:          config.True(s.cfg.MySQL.Warnings),
+	}
+	if s.cfg.Stats.Aggregate == "trx" {
+		a.SharedStats = stats.NewShared(0) // GOMAXPROCS shards
+	}
+	if s.cfg.Throttle != nil {
+		s.throttle = limit.NewThrottle(finch.Uint(s.cfg.Throttle.QPS))
+		a.Throttle = s.throttle
+	}
+	if s.cfg.Pattern != nil {
+		s.pattern = workload.NewPattern(*s.cfg.Pattern, []limit.Rate{a.StageQPS, a.StageTPS})
+		a.Pattern = s.pattern
+	}
+	if s.cfg.Stats.Clock == "coarse" {
+		tick, _ := time.ParseDuration(s.cfg.Stats.ClockTick) // already validated
+		s.clock = client.NewCoarseClock(tick)
+		a.Clock = s.clock.Now
 	}
 	groups, err := a.Groups()
 	if err != nil {
@@ -106,12 +324,39 @@ func (s *Stage) Prepare(ctxFinch context.Context) error {
 					return err
 				}
 				if s.stats != nil {
-					s.stats.Watch(c.Stats)
+					s.stats.Watch(watchStats(c))
+					c.InFlight = s.stats.InFlight()
 				}
 			}
 		}
 	}
 
+	// Watch data limits to report progress in stats. Statements (and their
+	// limits) are shared by all clients, so watch each only once.
+	if s.stats != nil {
+		for _, trxName := range trxSet.Order {
+			for _, stmt := range trxSet.Statements[trxName] {
+				if stmt.Limit != nil {
+					s.stats.WatchLimit(stmt.Limit)
+				}
+			}
+		}
+
+		// Runtime and iteration progress, too. Client groups in the same exec
+		// group share the same iteration progress.
+		if s.cfg.Runtime != "" {
+			d, _ := time.ParseDuration(s.cfg.Runtime) // already validated
+			if s.runtime = limit.NewRuntime(d); s.runtime != nil {
+				s.stats.WatchLimit(s.runtime)
+			}
+		}
+		for egNo := range s.execGroups {
+			if it := s.execGroups[egNo][0].Iter; it != nil {
+				s.stats.WatchLimit(it)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -131,25 +376,114 @@ func (s *Stage) Run(ctxFinch context.Context) {
 		d, _ := time.ParseDuration(s.cfg.Runtime) // already validated
 		ctxStage, cancelStage = context.WithDeadline(ctxFinch, time.Now().Add(d))
 		defer cancelStage() // stage and all clients
+		if s.runtime != nil {
+			s.runtime.Start()
+		}
 		log.Printf("[%s] Running for %s", s.cfg.Name, s.cfg.Runtime)
 	} else {
 		ctxStage = ctxFinch
 		log.Printf("[%s] Running (no runtime limit)", s.cfg.Name)
 	}
 
+	// Isolate Finch from MySQL on the same host: limit Finch to N CPUs and/or
+	// pin it to a CPU set. Both are restored after the stage for the next stage.
+	if n := finch.Uint(s.cfg.GOMAXPROCS); n > 0 {
+		prev := runtime.GOMAXPROCS(int(n))
+		defer runtime.GOMAXPROCS(prev)
+		log.Printf("[%s] GOMAXPROCS %d (was %d)", s.cfg.Name, n, prev)
+	}
+	if s.cfg.CPUSet != "" {
+		cpus, _ := config.ParseCPUSet(s.cfg.CPUSet) // already validated
+		if restore, err := setAffinity(cpus); err != nil {
+			log.Printf("[%s] Error setting cpu-set %s: %s", s.cfg.Name, s.cfg.CPUSet, err)
+		} else {
+			defer restore()
+			log.Printf("[%s] CPU set %s", s.cfg.Name, s.cfg.CPUSet)
+		}
+	}
+
 	if s.stats != nil {
 		s.stats.Start()
 	}
 
+	// Start tracers, if any. A tracer can be shared by client groups, but Start
+	// is idempotent. They're closed (stopped) after clients, below.
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			if tracer := s.execGroups[egNo][cgNo].Tracer; tracer != nil {
+				tracer.Start()
+			}
+		}
+	}
+
 	if finch.CPUProfile != nil {
 		pprof.StartCPUProfile(finch.CPUProfile)
 	}
+	if finch.BlockProfile != "" {
+		runtime.SetBlockProfileRate(blockProfileRate)
+	}
+	sat := startSaturation()
+	var res *resources
+	if finch.ResourceSummary {
+		res = startResources()
+	}
+
+	if s.clock != nil {
+		s.clock.Start()
+		defer s.clock.Stop()
+	}
 
-	for egNo := range s.execGroups { // ------------------------------------- execution groups
+	// Execution groups run sequentially: each waits for the clients of previous
+	// execution groups to finish, unless it has a start-after delay, in which case
+	// it starts that long after the stage starts, even if previous execution groups
+	// are still running (overlapping execution groups). With exec-mode concurrent,
+	// all execution groups start at once (or after their start-after delay).
+	stageStart := time.Now()
+	ctxThrottle, cancelThrottle := context.WithCancel(ctxStage)
+	defer cancelThrottle()
+	if s.throttle != nil {
+		go runThrottle(ctxThrottle, s.cfg, s.throttle, s.stats)
+	}
+	if s.pattern != nil {
+		s.pattern.Start(ctxStage)
+		defer s.pattern.Stop()
+	}
+	concurrent := s.cfg.ExecMode == config.EXEC_CONCURRENT
+	egRunning := make([]int, len(s.execGroups)) // clients running per exec group
+	prevDDL := false
+	for _, egNo := range s.startOrder() { // ------------------------------------- execution groups
 		if ctxFinch.Err() != nil {
 			break
 		}
+		// DDL exec groups never run concurrently: they wait for previous exec groups,
+		// and the next exec group waits for them (like tables created for DML)
+		ddl := s.execGroups[egNo][0].DDL
+		if concurrent && (ddl || prevDDL) {
+			s.waitClients(ctxFinch, ctxStage, egRunning, nil)
+		}
+		prevDDL = ddl
+		if d := s.execGroups[egNo][0].StartAfter; d > 0 {
+			log.Printf("[%s] Execution group %d starts after %s", s.cfg.Name, egNo+1, d)
+			s.waitClients(ctxFinch, ctxStage, egRunning, time.After(time.Until(stageStart.Add(d))))
+			if ctxFinch.Err() != nil {
+				break
+			}
+		} else if !concurrent {
+			s.waitClients(ctxFinch, ctxStage, egRunning, nil)
+		}
 		nClients := 0
+		if it := s.execGroups[egNo][0].Iter; it != nil {
+			it.Start()
+		}
+		if s.stats != nil {
+			var target stats.Target
+			for _, cg := range s.execGroups[egNo] {
+				target.QPS += cg.TargetQPS
+				target.TPS += cg.TargetTPS
+			}
+			s.stats.SetTarget(s.execGroups[egNo][0].ExecGroup, target)
+			s.stats.StartExecGroup(s.execGroups[egNo][0].ExecGroup)
+		}
 		for cgNo := range s.execGroups[egNo] { // --------------------------- client groups
 			log.Printf("[%s] Execution group %d, client group %d, runnning %d clients", s.cfg.Name, egNo+1, cgNo+1, len(s.execGroups[egNo][cgNo].Clients))
 			nClients += len(s.execGroups[egNo][cgNo].Clients)
@@ -165,71 +499,231 @@ func (s *Stage) Run(ctxFinch context.Context) {
 				finch.Debug("%d/%d no limit", egNo, cgNo)
 				ctxClients = ctxStage
 			}
+			if a := s.execGroups[egNo][cgNo].Arrivals; a != nil {
+				a.Start()
+				defer a.Stop()
+			}
+			if p := s.execGroups[egNo][cgNo].Pattern; p != nil {
+				p.Start(ctxClients)
+				defer p.Stop()
+			}
+			atomic.AddInt64(&s.running, int64(len(s.execGroups[egNo][cgNo].Clients)))
 			for _, c := range s.execGroups[egNo][cgNo].Clients { // --------- clients
 				go c.Run(ctxClients)
 			}
-		} // start all clients, then...
+		} // start all clients, then wait for them before the next exec group
+		egRunning[egNo] = nClients
+	}
+	s.waitClients(ctxFinch, ctxStage, egRunning, nil) // all exec groups
+	cancelThrottle()
 
-		clientErrors := make([]*client.Client, 0, nClients)
-	CLIENTS:
-		for nClients > 0 { // wait for clients
-			select {
-			case c := <-s.doneChan:
-				finch.Debug("%s done: %v", c.RunLevel, c.Error)
-				nClients -= 1
-				if c.Error.Err != nil {
-					clientErrors = append(clientErrors, c)
+	if finch.CPUProfile != nil {
+		pprof.StopCPUProfile()
+	}
+	if w := sat.check(s); w != "" && ctxFinch.Err() == nil {
+		log.Printf("[%s] WARNING: %s", s.cfg.Name, w)
+		if s.stats != nil {
+			s.stats.Warn(w)
+		}
+	}
+	if finch.BlockProfile != "" {
+		runtime.SetBlockProfileRate(0)
+	}
+	writeProfiles(s.cfg.Name)
+	var summary string
+	if res != nil {
+		summary = res.stop()
+	}
+
+	// Flush and close query logs and tracers, if any. A log or tracer can be
+	// shared by client groups, but Close is idempotent.
+	for egNo := range s.execGroups {
+		for cgNo := range s.execGroups[egNo] {
+			if qlog := s.execGroups[egNo][cgNo].QueryLog; qlog != nil {
+				if err := qlog.Close(); err != nil {
+					log.Printf("[%s] Error closing query log: %s", s.cfg.Name, err)
 				}
-			case <-ctxStage.Done():
-				finch.Debug("stage runtime elapsed")
-				break CLIENTS
-			case <-ctxFinch.Done():
-				finch.Debug("finch terminated")
-				break CLIENTS
+			}
+			if tracer := s.execGroups[egNo][cgNo].Tracer; tracer != nil {
+				tracer.Close()
 			}
 		}
-		if nClients > 0 {
-			// spinWaitMs gives clients a _little_ time to finish when either
-			// context is cancelled. This must be done to avoid a data race in
-			// stats reporting: the CLIENTS loop finishes and stats are reported
-			// below while a client is still writing to those stats. (This is
-			// also due to fact that stats are lock-free.) So when a context is
-			// cancelled, start sleeping 1ms and decrementing spinWaitMs which
-			// lets this for loop continue (spin) but also timeout quickly.
-			finch.Debug("spin wait for %d clients", nClients)
-			spinWaitMs := 10
-			for spinWaitMs > 0 && nClients > 0 {
-				select {
-				case c := <-s.doneChan:
-					finch.Debug("%s done: %v", c.RunLevel, c.Error)
-					nClients -= 1
-					if c.Error.Err != nil {
-						clientErrors = append(clientErrors, c)
-					}
-				default:
-					time.Sleep(1 * time.Millisecond)
-					spinWaitMs -= 1
-				}
+	}
+
+	// Write keys saved to key pools (data.KeyPool) so later stages can read them,
+	// and close key pool files
+	data.ClosePools()
+
+	// Close seq-table connection pools (data.SeqTable)
+	data.CloseSeqTables()
+
+	if s.cfg.Data.Persist != "" {
+		if err := s.gds.SaveState(s.cfg.Data.Persist, s.cfg.Data.Keys); err != nil {
+			log.Printf("[%s] Error saving data.persist %s: %s", s.cfg.Name, s.cfg.Data.Persist, err)
+		}
+	}
+
+	if s.stats != nil {
+		if !s.stats.Stop(ctxFinch) {
+			if s.cfg.Stats.FinalPolicy == config.FINAL_DROP {
+				log.Printf("\n[%s] Timeout waiting for final statistics, incomplete intervals were dropped", s.cfg.Name)
+			} else {
+				log.Printf("\n[%s] Timeout waiting for final statistics, reported values are incomplete", s.cfg.Name)
+			}
+		}
+	}
+
+	if summary != "" {
+		log.Printf("[%s] Finch resources: %s", s.cfg.Name, summary)
+	}
+}
+
+// startOrder returns the order to start exec groups: config order, except with
+// exec-mode concurrent, exec groups between DDL exec groups are ordered by
+// start-after so each starts on its own timer, not after a later one.
+func (s *Stage) startOrder() []int {
+	order := make([]int, len(s.execGroups))
+	for i := range order {
+		order[i] = i
+	}
+	if s.cfg.ExecMode != config.EXEC_CONCURRENT {
+		return order
+	}
+	for i := 0; i < len(order); {
+		if s.execGroups[i][0].DDL {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(order) && !s.execGroups[j][0].DDL {
+			j++
+		}
+		eg := order[i:j]
+		sort.SliceStable(eg, func(a, b int) bool {
+			return s.execGroups[eg[a]][0].StartAfter < s.execGroups[eg[b]][0].StartAfter
+		})
+		i = j
+	}
+	return order
+}
+
+// waitClients waits for the running clients of all exec groups to finish, or
+// until the start channel receives if not nil (start-after). If the stage or
+// Finch context is cancelled, it gives running clients a little time to finish,
+// then it returns. It calls execGroupDone for each exec group when all of its
+// clients are done.
+func (s *Stage) waitClients(ctxFinch, ctxStage context.Context, egRunning []int, start <-chan time.Time) {
+	nClients := 0
+	for _, n := range egRunning {
+		nClients += n
+	}
+	clientErrors := []*client.Client{}
+	done := func(c *client.Client) {
+		finch.Debug("%s done: %v", c.RunLevel, c.Error)
+		nClients -= 1
+		s.clientDone(c)
+		if c.Error.Err != nil {
+			clientErrors = append(clientErrors, c)
+		}
+		egNo := c.RunLevel.ExecGroup - 1
+		if egRunning[egNo] -= 1; egRunning[egNo] == 0 {
+			s.execGroupDone(int(egNo))
+		}
+	}
+	cancelled := false
+CLIENTS:
+	for nClients > 0 || start != nil { // wait for clients (and start, if any)
+		select {
+		case c := <-s.doneChan:
+			done(c)
+		case <-start:
+			break CLIENTS
+		case <-ctxStage.Done():
+			finch.Debug("stage runtime elapsed")
+			cancelled = true
+			break CLIENTS
+		case <-ctxFinch.Done():
+			finch.Debug("finch terminated")
+			cancelled = true
+			break CLIENTS
+		}
+	}
+	if cancelled && nClients > 0 {
+		// spinWaitMs gives clients a _little_ time to finish when either
+		// context is cancelled. This must be done to avoid a data race in
+		// stats reporting: the CLIENTS loop finishes and stats are reported
+		// below while a client is still writing to those stats. (This is
+		// also due to fact that stats are lock-free.) So when a context is
+		// cancelled, start sleeping 1ms and decrementing spinWaitMs which
+		// lets this for loop continue (spin) but also timeout quickly.
+		finch.Debug("spin wait for %d clients", nClients)
+		spinWaitMs := 10
+		for spinWaitMs > 0 && nClients > 0 {
+			select {
+			case c := <-s.doneChan:
+				done(c)
+			default:
+				time.Sleep(1 * time.Millisecond)
+				spinWaitMs -= 1
 			}
 		}
 		if nClients > 0 {
 			log.Printf("[%s] WARNING: %d clients did not stop, statistics are not accurate", s.cfg.Name, nClients)
-		}
-		if len(clientErrors) > 0 {
-			log.Printf("%d client errors:\n", len(clientErrors))
-			for _, c := range clientErrors {
-				log.Printf("  %s: %s (%s)", c.RunLevel.ClientId(), c.Error.Err, c.Statements[c.Error.StatementNo].Query)
+			atomic.StoreInt64(&s.running, 0)
+			for egNo := range egRunning {
+				if egRunning[egNo] == 0 {
+					continue
+				}
+				egRunning[egNo] = 0 // don't wait for them again
+				s.execGroupDone(egNo)
 			}
 		}
 	}
-
-	if finch.CPUProfile != nil {
-		pprof.StopCPUProfile()
+	if len(clientErrors) > 0 {
+		log.Printf("%d client errors:\n", len(clientErrors))
+		for _, c := range clientErrors {
+			log.Printf("  %s: %s (%s)", c.RunLevel.ClientId(), c.Error.Err, c.ErrorQuery())
+		}
 	}
+}
 
+// execGroupDone stops iter progress and stats for the exec group when all its
+// clients are done.
+func (s *Stage) execGroupDone(egNo int) {
+	if it := s.execGroups[egNo][0].Iter; it != nil {
+		it.Stop()
+	}
 	if s.stats != nil {
-		if !s.stats.Stop(3*time.Second, ctxFinch.Err() != nil) {
-			log.Printf("\n[%s] Timeout waiting for final statistics, reported values are incomplete", s.cfg.Name)
+		s.stats.StopExecGroup(s.execGroups[egNo][0].ExecGroup)
+	}
+}
+
+func (s *Stage) clientDone(c *client.Client) {
+	atomic.AddInt64(&s.running, -1)
+	if c.Error.Err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// Health returns the number of clients running and the number of clients that
+// stopped on error. It's safe to call while the stage is running.
+func (s *Stage) Health() (running, errors int) {
+	return int(atomic.LoadInt64(&s.running)), int(atomic.LoadInt64(&s.errors))
+}
+
+// watchStats returns the client trx stats and its label stats (-- label), if
+// any, which are shared by statements with the same label.
+func watchStats(c *client.Client) []*stats.Trx {
+	if c.Labels == nil {
+		return c.Stats
+	}
+	all := append([]*stats.Trx{}, c.Stats...)
+	seen := map[*stats.Trx]bool{}
+	for _, l := range c.Labels {
+		if l != nil && !seen[l] {
+			all = append(all, l)
+			seen[l] = true
 		}
 	}
+	return all
 }
@@ -1,12 +1,22 @@
 package stage
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-test/deep"
+
+	"github.com/square/finch"
 	"github.com/square/finch/config"
 	"github.com/square/finch/data"
+	"github.com/square/finch/limit"
 	"github.com/square/finch/test"
+	"github.com/square/finch/workload"
 )
 
 func TestPreapre_NoWorkload(t *testing.T) {
@@ -40,7 +50,7 @@ func TestPreapre_NoWorkload(t *testing.T) {
 	}
 	gds := data.NewScope() // global data scope
 
-	s := New(cfg, gds, nil)
+	s := New(cfg, gds, nil, nil, nil)
 	err = s.Prepare(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -54,3 +64,238 @@ func TestPreapre_NoWorkload(t *testing.T) {
 		t.Fatalf("got %d clients, expected 1", len(s.execGroups[0]))
 	}
 }
+
+func TestDryRun(t *testing.T) {
+	// Doesn't need MySQL
+	cfg := config.Stage{
+		Name: "test",
+		Trx: []config.Trx{
+			{
+				Name: "001",
+				File: "../test/trx/001.sql",
+				Data: map[string]config.Data{
+					"id": {
+						Generator: "int",
+						Params:    map[string]string{"max": "1"},
+					},
+				},
+			},
+		},
+		Workload: []config.ClientGroup{
+			{Clients: "2"},
+		},
+	}
+
+	var buf bytes.Buffer
+	s := New(cfg, data.NewScope(), nil, nil, nil)
+	if err := s.DryRun(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expect := []string{
+		"-- 0(test)/e1(dml1)/g1/c1",
+		"-- iter 1 trx 001",
+		"select c from t where id=1",
+		"-- iter 2 trx 001",
+		"select c from t where id=1",
+		"-- 0(test)/e1(dml1)/g1/c2",
+		"-- iter 1 trx 001",
+		"select c from t where id=1",
+		"-- iter 2 trx 001",
+		"select c from t where id=1",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+		t.Log(buf.String())
+	}
+}
+
+func TestDryRun_Weights(t *testing.T) {
+	// Doesn't need MySQL
+	cfg := config.Stage{
+		Name: "test",
+		Trx: []config.Trx{
+			{
+				Name:   "001",
+				File:   "../test/trx/001.sql",
+				Weight: "99",
+				Data: map[string]config.Data{
+					"id": {
+						Generator: "int",
+						Params:    map[string]string{"max": "1"},
+					},
+				},
+			},
+			{
+				Name: "002", // no weight = 1
+				File: "../test/trx/002.sql",
+				Data: map[string]config.Data{
+					"d": {
+						Generator: "int",
+						Params:    map[string]string{"max": "1"},
+					},
+				},
+			},
+		},
+		Workload: []config.ClientGroup{
+			{Clients: "1"},
+		},
+	}
+
+	var buf bytes.Buffer
+	s := New(cfg, data.NewScope(), nil, nil, nil)
+	if err := s.DryRun(&buf, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each iter executes 1 trx chosen by weight: 99% 001 and 1% 002
+	n := map[string]int{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "-- iter ") {
+			n[line[strings.LastIndex(line, " ")+1:]]++
+		}
+	}
+	if n["001"]+n["002"] != 1000 {
+		t.Errorf("executed %d trx in 1000 iter, expected 1000: %v", n["001"]+n["002"], n)
+	}
+	if n["001"] < 900 {
+		t.Errorf("executed trx 001 %d times, expected > 900 (99%% weight): %v", n["001"], n)
+	}
+}
+
+func TestDryRun_If(t *testing.T) {
+	// Doesn't need MySQL
+	cfg := config.Stage{
+		Name: "test",
+		Trx: []config.Trx{
+			{
+				Name: "if",
+				File: "../test/trx/if.sql",
+				Data: map[string]config.Data{
+					"n": {
+						Generator: "auto-inc",
+					},
+				},
+			},
+		},
+		Workload: []config.ClientGroup{
+			{Clients: "1"},
+		},
+	}
+
+	var buf bytes.Buffer
+	s := New(cfg, data.NewScope(), nil, nil, nil)
+	if err := s.DryRun(&buf, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- if @n % 2 == 0: only even values of @n
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expect := []string{
+		"-- 0(test)/e1(dml1)/g1/c1",
+		"-- iter 1 trx if",
+		"-- iter 2 trx if",
+		"select c from t where id=2",
+		"-- iter 3 trx if",
+		"-- iter 4 trx if",
+		"select c from t where id=4",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+		t.Log(buf.String())
+	}
+}
+
+func TestResources(t *testing.T) {
+	r := startResources()
+	time.Sleep(150 * time.Millisecond)
+	got := r.stop()
+	for _, s := range []string{"CPU ", "GOMAXPROCS ", "peak RSS ", "peak goroutines ", "GC "} {
+		if !strings.Contains(got, s) {
+			t.Errorf("summary '%s' does not contain '%s'", got, s)
+		}
+	}
+
+	// Profiles are rewritten after each stage
+	file := filepath.Join(t.TempDir(), "mem.prof")
+	finch.MemProfile = file
+	defer func() { finch.MemProfile = "" }()
+	writeProfiles("test")
+	writeProfiles("test")
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() == 0 {
+		t.Errorf("mem profile %s is empty", file)
+	}
+}
+
+type pacing struct {
+	limit.Rate
+	allowed, dropped uint64
+}
+
+func (p pacing) Pacing() (uint64, uint64) { return p.allowed, p.dropped }
+
+func TestSaturation(t *testing.T) {
+	defer func(cpu float64) { SaturationCPU = cpu }(SaturationCPU)
+	SaturationCPU = 100 // never CPU saturated
+
+	// QPS limit (0% behind) is reached, so clients are waiting on it and not
+	// behind schedule even though they're 50% behind the TPS limit
+	s := &Stage{
+		execGroups: [][]workload.ClientGroup{
+			{
+				{Rates: []limit.Rate{pacing{allowed: 100, dropped: 50}, pacing{allowed: 100}}},
+			},
+		},
+	}
+	if got := startSaturation().check(s); got != "" {
+		t.Errorf("got warning '%s', expected none", got)
+	}
+
+	// Both limits behind: report the lesser (20%)
+	s.execGroups[0][0].Rates[1] = pacing{allowed: 100, dropped: 20}
+	got := startSaturation().check(s)
+	if !strings.Contains(got, "behind QPS/TPS limits (20% of allowances unused)") {
+		t.Errorf("got warning '%s', expected clients behind 20%%", got)
+	}
+
+	// No limits and CPU saturated
+	SaturationCPU = -1
+	s.execGroups[0][0].Rates = nil
+	got = startSaturation().check(s)
+	if !strings.Contains(got, "with no QPS/TPS limits") {
+		t.Errorf("got warning '%s', expected CPU saturation", got)
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	eg := func(startAfter time.Duration, ddl bool) []workload.ClientGroup {
+		return []workload.ClientGroup{{StartAfter: startAfter, DDL: ddl}}
+	}
+	s := &Stage{
+		execGroups: [][]workload.ClientGroup{
+			eg(0, true),
+			eg(10*time.Second, false),
+			eg(5*time.Second, false),
+			eg(0, false),
+			eg(0, true),
+			eg(2*time.Second, false),
+			eg(0, false),
+		},
+	}
+
+	// Sequential: config order
+	if diff := deep.Equal(s.startOrder(), []int{0, 1, 2, 3, 4, 5, 6}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Concurrent: ordered by start-after between DDL exec groups
+	s.cfg.ExecMode = config.EXEC_CONCURRENT
+	if diff := deep.Equal(s.startOrder(), []int{0, 3, 2, 1, 4, 6, 5}); diff != nil {
+		t.Error(diff)
+	}
+}
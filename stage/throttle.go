@@ -0,0 +1,137 @@
+// Copyright 2024 Block, Inc.
+
+package stage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/finch/config"
+	"github.com/square/finch/dbconn"
+	"github.com/square/finch/limit"
+	"github.com/square/finch/stats"
+)
+
+// runThrottle runs config.stage.throttle: every freq, it runs the query to get
+// the metric on MySQL or mysql.reader, and it throttles clients while the metric
+// is greater than max, until it's less than or equal to resume. If the query
+// fails or returns NULL, the error is logged and clients are throttled (fail
+// closed) until the metric is less than or equal to resume. Changes are reported
+// to the stats collector, if not nil, like events. It returns when ctx is
+// cancelled, which the caller does when the stage is done, and it stops lm so
+// clients waiting while throttled aren't blocked.
+func runThrottle(ctx context.Context, cfg config.Stage, lm *limit.Throttle, c *stats.Collector) {
+	defer lm.Stop()
+	t := cfg.Throttle
+	max, _ := strconv.ParseFloat(t.Max, 64)       // already validated
+	resume, _ := strconv.ParseFloat(t.Resume, 64) // already validated
+	freq, _ := time.ParseDuration(t.Freq)         // already validated
+
+	var db *sql.DB
+	var err error
+	if t.Reader {
+		db, _, err = dbconn.MakeReader() // Stage.Prepare set config
+	} else {
+		db, _, err = dbconn.Make()
+	}
+	if err != nil {
+		log.Printf("[%s] throttle: %s: not throttling", cfg.Name, err)
+		return
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	start := time.Now()
+	ticker := time.NewTicker(freq)
+	defer ticker.Stop()
+	for {
+		var msg string
+		v, err := throttleMetric(ctx, db, t.Query, t.Column)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[%s] throttle: %s", cfg.Name, err)
+			if !lm.Throttled() {
+				lm.Set(true)
+				msg = "throttle: metric error"
+			}
+		} else if !lm.Throttled() && v > max {
+			lm.Set(true)
+			msg = fmt.Sprintf("throttle: %s > max %s", fmtMetric(v), t.Max)
+		} else if lm.Throttled() && v <= resume {
+			lm.Set(false)
+			msg = fmt.Sprintf("resume: %s <= resume %s", fmtMetric(v), t.Resume)
+		}
+		if msg != "" {
+			log.Printf("[%s] %s", cfg.Name, msg)
+			if c != nil {
+				now := time.Now()
+				c.Event(stats.Event{Time: now, Runtime: now.Sub(start).Seconds(), Event: msg})
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// throttleMetric runs the query and returns the value of column (case-insensitive)
+// in the first row, or the first column if column is empty.
+func throttleMetric(ctx context.Context, db *sql.DB, query, column string) (float64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", query, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	if column != "" {
+		n = -1
+		for i := range cols {
+			if strings.EqualFold(cols[i], column) {
+				n = i
+				break
+			}
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("%s: no column %s in %v", query, column, cols)
+		}
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("%s: %s", query, err)
+		}
+		return 0, fmt.Errorf("%s: no rows", query)
+	}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return 0, fmt.Errorf("%s: %s", query, err)
+	}
+	if !vals[n].Valid {
+		return 0, fmt.Errorf("%s: %s is NULL", query, cols[n])
+	}
+	v, err := strconv.ParseFloat(vals[n].String, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s value '%s' is not a number", query, cols[n], vals[n].String)
+	}
+	return v, nil
+}
+
+func fmtMetric(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
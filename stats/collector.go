@@ -3,28 +3,99 @@
 package stats
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/square/finch"
 	"github.com/square/finch/config"
+	"github.com/square/finch/limit"
 )
 
 var Now func() time.Time = time.Now
 
+// RunId and Tags identify the benchmark run (--run-id and --tag) in all stats
+// and reports, so results from different runs can be told apart downstream.
+// They're set once on boot.
+var (
+	RunId string
+	Tags  map[string]string
+)
+
 // Instance stats are per trx and total (all trx) stats from all clients on a
 // local or report instance. N-many instances constitute an interval of N instance
 // stats. Collector.Recv waits for stats to complete each interval before reporting.
 type Instance struct {
-	Hostname string            // local or remote compute
-	Clients  uint              // number of clients
-	Interval uint              // interval number, monotonically incr
-	Seconds  float64           // of interval
-	Runtime  float64           // total elapsed seconds of benchmark
-	Total    *Stats            // all trx stats combined
-	Trx      map[string]*Stats // per trx stats
+	Hostname  string            // local or remote compute
+	Session   string            // random ID of the instance Collector (Recv dedupe)
+	Clients   uint              // number of clients
+	Interval  uint              // interval number, monotonically incr
+	Seconds   float64           // of interval
+	Runtime   float64           // total elapsed seconds of benchmark
+	Start     time.Time         // wall-clock start of interval
+	End       time.Time         // wall-clock end of interval
+	Total     *Stats            // all trx stats combined
+	Trx       map[string]*Stats // per trx stats
+	Progress  []limit.Progress  // data limit progress, if any
+	RunId     string            // --run-id, if any
+	Tags      map[string]string // --tag, if any
+	Warnings  []string          // load generator warnings, like saturation, if any
+	Events    []Event           // scheduled events that ran in the interval, if any
+	ExecGroup string            // exec groups that ran in the interval, like "dml1" or "oltp,batch"
+	SLO       []SLO             // config.stats.slo, if any
+	InFlight  InFlight          // clients executing a query
+	Target    Target            // QPS and TPS limits of clients, if rate limited
+
+	// ExecGroups are the stats of each exec group that ran in the interval, for
+	// exec group summaries. Trx and Total are the same as above, but only for
+	// clients in the exec group.
+	ExecGroups map[string]ExecGroupStats
+}
+
+// ExecGroupStats are one exec group's share of instance stats (Instance.ExecGroups).
+type ExecGroupStats struct {
+	Clients uint
+	Trx     map[string]*Stats
+	Target  Target
+}
+
+// SLO is a response time threshold (config.stats.slo). Max is the threshold in
+// the stats unit: microseconds, or nanoseconds if config.stats.precision is "ns".
+type SLO struct {
+	Name string // like "5ms"
+	Max  int64
+}
+
+// ParseSLO returns the response time thresholds in config.stats.slo, which is
+// already validated.
+func ParseSLO(cfg config.Stats) []SLO {
+	if len(cfg.SLO) == 0 {
+		return nil
+	}
+	slo := make([]SLO, len(cfg.SLO))
+	for i, s := range cfg.SLO {
+		d, _ := time.ParseDuration(s)
+		slo[i] = SLO{Name: s, Max: d.Microseconds()}
+		if cfg.Precision == "ns" {
+			slo[i].Max = d.Nanoseconds()
+		}
+	}
+	return slo
+}
+
+// Event is a scheduled event (config.stage.events) that ran during the stage,
+// reported in the interval in which it ran to correlate it with the stats.
+type Event struct {
+	Time    time.Time `json:"time"`            // when the event ran
+	Runtime float64   `json:"runtime"`         // elapsed seconds of stage when the event ran
+	Event   string    `json:"event"`           // like "events[0]: sql STOP REPLICA"
+	Error   string    `json:"error,omitempty"` // if the event failed
 }
 
 func NewInstance(hostname string) Instance {
@@ -32,6 +103,20 @@ func NewInstance(hostname string) Instance {
 		Hostname: hostname,
 		Total:    NewStats(),
 		Trx:      map[string]*Stats{},
+		RunId:    RunId,
+		Tags:     Tags,
+	}
+}
+
+// span extends the wall-clock interval to include start and end, if not zero.
+// Instance clocks aren't synchronized, so combined stats span the earliest
+// start and the latest end.
+func (in *Instance) span(start, end time.Time) {
+	if !start.IsZero() && (in.Start.IsZero() || start.Before(in.Start)) {
+		in.Start = start
+	}
+	if end.After(in.End) {
+		in.End = end
 	}
 }
 
@@ -42,10 +127,48 @@ func (in *Instance) Combine(from []Instance) {
 	in.Interval = from[0].Interval
 	in.Seconds = from[0].Seconds
 	in.Runtime = from[0].Runtime
+	in.Start = from[0].Start
+	in.End = from[0].End
 	in.Total.Copy(from[0].Total) // copy the first
-	for i := range from[1:] {    // combine the rest
+	in.Progress = append([]limit.Progress{}, from[0].Progress...)
+	in.Warnings = append([]string{}, from[0].Warnings...)
+	in.Events = append([]Event{}, from[0].Events...)
+	in.ExecGroup = strings.Join(execGroupNames(from), ",")
+	in.SLO = from[0].SLO
+	in.InFlight = from[0].InFlight
+	in.Target = from[0].Target
+	if in.RunId == "" && len(in.Tags) == 0 { // else keep local run ID and tags
+		in.RunId = from[0].RunId
+		in.Tags = from[0].Tags
+	}
+	for i := range from[1:] { // combine the rest
 		in.Total.Combine(from[1+i].Total)
 		in.Clients += from[1+i].Clients
+		in.Progress = append(in.Progress, from[1+i].Progress...)
+		in.Warnings = append(in.Warnings, from[1+i].Warnings...)
+		in.Events = append(in.Events, from[1+i].Events...)
+		in.InFlight.add(from[1+i].InFlight)
+		in.Target.add(from[1+i].Target)
+		in.span(from[1+i].Start, from[1+i].End)
+	}
+
+	// Combine per-trx stats, too, because trx names are the same on all instances
+	if in.Trx == nil {
+		in.Trx = map[string]*Stats{}
+	}
+	for name := range in.Trx {
+		in.Trx[name].Reset()
+	}
+	for i := range from {
+		for name, s := range from[i].Trx {
+			if s == nil {
+				continue
+			}
+			if _, ok := in.Trx[name]; !ok {
+				in.Trx[name] = NewStats()
+			}
+			in.Trx[name].Combine(s)
+		}
 	}
 }
 
@@ -54,10 +177,12 @@ func (in *Instance) Combine(from []Instance) {
 // Else, they're collected/reported once when the stage finishes and calls Stop.
 type Collector struct {
 	Freq       time.Duration
-	trx        [][]*Trx   // lock-free trx stats per client
-	stats      [][]*Stats // stats per trx (per client)
-	local      Instance   // local instance stats
-	nInstances uint       // number of instances in interval
+	trx        [][]*Trx           // lock-free trx stats per client (or shard, see Shared)
+	watched    map[*Trx]bool      // all in trx
+	stats      [][]*Stats         // stats per trx (per client)
+	limits     []limit.Progressor // limits to report progress
+	local      Instance           // local instance stats
+	nInstances uint               // number of instances in interval
 	stopChan   chan struct{}
 	doneChan   chan struct{}
 	start      time.Time // when Start was called, calculates Runtime
@@ -65,11 +190,42 @@ type Collector struct {
 	reporters  []Reporter
 	finalChan  chan struct{}
 
+	finalTimeout time.Duration // stats.final-timeout
+	finalPolicy  string        // stats.final-policy
+
 	*sync.Mutex
-	intervalNo uint       // current interval being filled
-	interval   []Instance // all Instance stats
-	n          uint       // index in interval
-	reported   time.Time  // when Report was last called
+	warnings   []string            // Warn, reported once in the next interval
+	events     []Event             // Event, reported in the interval in which they ran
+	intervalNo uint                // current interval being filled
+	pending    map[uint][]Instance // intervalNo => Instance stats not reported yet
+	buffer     uint                // max intervals pending after intervalNo
+	recv       map[string]uint     // last interval received from each remote instance session (Recv)
+	reported   time.Time           // when Report was last called
+
+	// Exec groups (StartExecGroup and StopExecGroup) for Instance.ExecGroup and
+	// exec group summaries
+	egRunning []string             // running now
+	egRan     []string             // ran in current interval
+	egPrev    []string             // in last reported interval
+	egOrder   []string             // all seen, in order
+	egSummary map[string]*Instance // summary of each exec group, until reported
+	egWait    []string             // finished before a second exec group was seen
+	egTarget  map[string]Target    // SetTarget
+	egClients map[string]uint      // clients watched per exec group
+
+	// Clients in flight (InFlight): one flag per client, and samples in the
+	// current interval
+	inFlight       []*atomic.Int32
+	inFlightMux    sync.Mutex
+	inFlightSample InFlight
+	inFlightStop   chan struct{} // closed in Stop to stop sampleInFlight
+}
+
+// newSession returns a random ID for Instance.Session.
+func newSession() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
 func NewCollector(cfg config.Stats, hostname string, nInstances uint) (*Collector, error) {
@@ -81,30 +237,77 @@ func NewCollector(cfg config.Stats, hostname string, nInstances uint) (*Collecto
 		return nil, err
 	}
 
+	var buffer uint
+	if cfg.Buffer != "" {
+		buffer = finch.Uint(cfg.Buffer) // already validated
+	}
+
+	finalTimeout := 3 * time.Second
+	if cfg.FinalTimeout != "" {
+		finalTimeout, _ = time.ParseDuration(cfg.FinalTimeout) // already validated
+	}
+	finalPolicy := cfg.FinalPolicy
+	if finalPolicy == "" {
+		finalPolicy = config.FINAL_FORCE_PARTIAL
+	}
+
+	local := NewInstance(hostname)
+	local.SLO = ParseSLO(cfg)
+	local.Session = newSession()
+
 	return &Collector{
-		Freq:       freq,
-		stopChan:   make(chan struct{}),
-		doneChan:   make(chan struct{}),
-		local:      NewInstance(hostname),
-		interval:   make([]Instance, nInstances),
-		nInstances: nInstances,
-		reporters:  reporters,
-		intervalNo: 1,
-		finalChan:  make(chan struct{}),
-		Mutex:      &sync.Mutex{},
+		Freq:         freq,
+		stopChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+		local:        local,
+		pending:      map[uint][]Instance{},
+		buffer:       buffer,
+		recv:         map[string]uint{},
+		watched:      map[*Trx]bool{},
+		nInstances:   nInstances,
+		reporters:    reporters,
+		intervalNo:   1,
+		finalChan:    make(chan struct{}),
+		inFlightStop: make(chan struct{}),
+		Mutex:        &sync.Mutex{},
+
+		finalTimeout: finalTimeout,
+		finalPolicy:  finalPolicy,
+		egSummary:    map[string]*Instance{},
+		egTarget:     map[string]Target{},
+		egClients:    map[string]uint{},
 	}, nil
 }
 
+// AddReporter adds a reporter that's not configured in stats.report, like the
+// compute server web dashboard. It must be called before Start.
+func (c *Collector) AddReporter(r Reporter) {
+	c.reporters = append(c.reporters, r)
+}
+
 // Watch all trx stats from one client. This must be called for each Client
-// because it determines what Collect collects.
+// because it determines what Collect collects. Shared trx stats (see Shared)
+// are watched once, but every client that uses them is counted.
 func (c *Collector) Watch(trx []*Trx) {
-	// Count non-nil client stats, return early if zero. This can happen with
-	// workload[].disable-status=true (stats disabled in client group).
+	// Non-nil client stats not already watched, return early if zero client
+	// stats. This can happen with workload[].disable-status=true (stats disabled
+	// in client group).
+	watch := make([]*Trx, 0, len(trx))
 	n := 0
+	execGroup := ""
 	for i := range trx {
-		if trx[i] != nil {
-			n++
+		if trx[i] == nil {
+			continue
 		}
+		n++
+		if trx[i].ExecGroup != "" {
+			execGroup = trx[i].ExecGroup
+		}
+		if c.watched[trx[i]] {
+			continue // shared
+		}
+		c.watched[trx[i]] = true
+		watch = append(watch, trx[i])
 	}
 	if n == 0 { // client stats disabled
 		return
@@ -112,19 +315,69 @@ func (c *Collector) Watch(trx []*Trx) {
 
 	// This client is watching at least 1 set of trx stats
 	c.local.Clients += 1
-	c.trx = append(c.trx, make([]*Trx, n))
-	c.stats = append(c.stats, make([]*Stats, n))
-	n = len(c.trx) - 1
-	for i := range trx {
-		if trx[i] == nil {
-			continue
+	if execGroup != "" {
+		c.egClients[execGroup] += 1
+	}
+	if len(watch) == 0 { // all shared and already watched
+		return
+	}
+	c.trx = append(c.trx, watch)
+	c.stats = append(c.stats, make([]*Stats, len(watch))) // fetch value later in report
+	for i := range watch {
+		if _, ok := c.local.Trx[watch[i].Name]; !ok {
+			c.local.Trx[watch[i].Name] = NewStats()
 		}
-		c.trx[n][i] = trx[i]
-		c.stats[n][i] = nil // fetch value later in report
-		if _, ok := c.local.Trx[trx[i].Name]; !ok {
-			c.local.Trx[trx[i].Name] = NewStats()
+	}
+}
+
+// WatchLimit watches a limit to report its progress. Data limits are
+// per-statement, so the caller must call this only once for each unique limit.
+func (c *Collector) WatchLimit(lm limit.Progressor) {
+	if lm == nil {
+		return
+	}
+	c.limits = append(c.limits, lm)
+}
+
+// Warn adds a warning to the next interval, which is the final interval when
+// called before Stop, like a warning that results might be limited by Finch
+// (saturation). Each warning is reported once.
+func (c *Collector) Warn(msg string) {
+	c.Lock()
+	c.warnings = append(c.warnings, msg)
+	c.Unlock()
+}
+
+// Event adds a scheduled event to be reported in the interval in which it ran,
+// which is the first interval with a runtime >= the event runtime.
+func (c *Collector) Event(e Event) {
+	c.Lock()
+	c.events = append(c.events, e)
+	c.Unlock()
+}
+
+// StartExecGroup records that the exec group started running. Stats intervals
+// are labeled with the exec groups that ran in the interval (Instance.ExecGroup).
+func (c *Collector) StartExecGroup(name string) {
+	c.Lock()
+	c.egRunning = append(c.egRunning, name)
+	if !contains(c.egRan, name) {
+		c.egRan = append(c.egRan, name)
+	}
+	c.Unlock()
+}
+
+// StopExecGroup records that the exec group stopped running. It's still in the
+// label of the current interval.
+func (c *Collector) StopExecGroup(name string) {
+	c.Lock()
+	for i := range c.egRunning {
+		if c.egRunning[i] == name {
+			c.egRunning = append(c.egRunning[:i], c.egRunning[i+1:]...)
+			break
 		}
 	}
+	c.Unlock()
 }
 
 // Start starts metrics collection. It's called only once immediately before
@@ -136,6 +389,9 @@ func (c *Collector) Start() {
 	now := Now()
 	c.start = now
 	c.last = now
+	if len(c.inFlight) > 0 {
+		go c.sampleInFlight()
+	}
 	if c.Freq == 0 {
 		return
 	}
@@ -160,7 +416,10 @@ func (c *Collector) Start() {
 // Stop stops metrics collection, waits for final stats, and prints the final report.
 // It's called once immediately after the stage finishes (in Stage.Run). It stops the
 // goroutine started in Start, if periodic stats are enabled (stats.freq > 0).
-func (c *Collector) Stop(timeout time.Duration, terminated bool) bool {
+// It waits for final stats as configured by stats.final-timeout and stats.final-policy,
+// except wait-forever is force-partial if ctxFinch is cancelled (CTRL-C), even while
+// waiting. It returns true if all final stats were received and reported.
+func (c *Collector) Stop(ctxFinch context.Context) bool {
 	/*
 		This func is necessarily complex because there's a race condition that
 		can't solved with basic synchronization because the problem is related
@@ -222,61 +481,38 @@ func (c *Collector) Stop(timeout time.Duration, terminated bool) bool {
 		the final report can be very delayed due to network delays. Before the last tick,
 		we just wait between ticks and report late. But on the last tick, we need to
 		shutdown as quickly as possible but also wait for the last metrics from the
-		remotes. So even after handling cases A-C, we have to loop without a timeout
-		until Report returns true, which means all stats received and reported.
+		remotes. So after handling cases A-C, waitFinal waits until no intervals are
+		pending (all stats received and reported), or until the final timeout, then
+		it applies the final policy to the intervals that are still pending.
+
+		The simple way to handle cases A-C is to check when Collect was last called:
+		the ticker goroutine has returned, so c.last is safe to read. If it was less
+		than half an interval ago, the final tick was received (cases A and C).
+		Else, the final tick was lost (case B), so collect the final interval now.
 	*/
+	close(c.inFlightStop) // clients are done, so stop sampling them
 
-	reported := false
-	var lastReported time.Duration
 	if c.Freq == 0 {
-		reported = c.Collect() // first/last/only collection
+		c.Collect() // first/last/only collection
 	} else {
 		close(c.stopChan) // stop goroutine in Start ^
 		<-c.doneChan      // wait for Start to return
-		if terminated {
-			//finch.Debug("terminated; not waiting for next report")
-			//reported = true
-			//goto STOP
+		last := Now().Sub(c.last)
+		finch.Debug("last collect: %s ago", last)
+		if c.local.Interval == 0 || last >= c.Freq/2 {
+			finch.Debug("last periodic collect")
+			c.Collect()
 		}
 	}
 
-	c.Lock()
-	lastReported = time.Now().Sub(c.reported)
-	c.Unlock()
-	finch.Debug("last report: %s ago", lastReported)
+	reported := c.waitFinal(ctxFinch)
 
-	if reported || lastReported < (c.Freq/2) {
-		finch.Debug("final report done")
-		reported = true
-	} else {
-		if c.Freq > 0 {
-			finch.Debug("last periodic collect")
-			reported = c.Collect()
-			if reported {
-				goto STOP
-			}
-		}
-
-		finch.Debug("waiting %s for final report...", timeout)
-		timeoutC := time.After(timeout)
-	WAIT:
-		for !reported {
-			select {
-			case <-timeoutC:
-				c.Lock()
-				reported = c.Report(true) // true=force
-				c.Unlock()
-				break WAIT
-			default:
-				time.Sleep(100 * time.Millisecond)
-			}
-			c.Lock()
-			reported = c.Report(false)
-			c.Unlock()
-		}
+	c.Lock()
+	for _, name := range c.egOrder { // exec groups that haven't been reported
+		c.reportExecGroup(name)
 	}
+	c.Unlock()
 
-STOP:
 	finch.Debug("stopping reporters")
 	for _, r := range c.reporters {
 		r.Stop()
@@ -287,6 +523,62 @@ STOP:
 	return reported
 }
 
+// waitFinal waits for final stats from all instances: until no intervals are
+// pending. If that doesn't happen within the final timeout, it applies the final
+// policy: wait-forever logs and keeps waiting, force-partial reports incomplete
+// intervals, and drop discards them. It returns true if no intervals were
+// incomplete.
+func (c *Collector) waitFinal(ctxFinch context.Context) bool {
+	policy := c.finalPolicy
+	var terminated <-chan struct{} // CTRL-C while waiting forever, else nil
+	if policy == config.FINAL_WAIT_FOREVER {
+		if ctxFinch.Err() != nil {
+			policy = config.FINAL_FORCE_PARTIAL // don't wait forever on CTRL-C
+		} else {
+			terminated = ctxFinch.Done()
+		}
+	}
+	finch.Debug("waiting %s for final report (policy %s)", c.finalTimeout, policy)
+	timeout := time.NewTimer(c.finalTimeout)
+	defer timeout.Stop()
+	for {
+		c.Lock()
+		if len(c.pending) == 0 {
+			c.Unlock()
+			finch.Debug("final report done")
+			return true
+		}
+		select {
+		case <-timeout.C:
+			switch policy {
+			case config.FINAL_WAIT_FOREVER:
+				log.Printf("Waiting for final stats interval %d: have %d of %d instances", c.intervalNo, len(c.pending[c.intervalNo]), c.nInstances)
+				timeout.Reset(c.finalTimeout)
+			case config.FINAL_DROP:
+				for len(c.pending) > 0 {
+					log.Printf("Dropped incomplete stats interval %d: have %d of %d instances", c.intervalNo, len(c.pending[c.intervalNo]), c.nInstances)
+					delete(c.pending, c.intervalNo)
+					c.intervalNo += 1
+				}
+				c.Unlock()
+				return false
+			default: // force-partial
+				c.Report(true) // true=force
+				c.Unlock()
+				return false
+			}
+		case <-terminated:
+			// Wait until the current final timeout, then force-partial
+			log.Printf("Terminated while waiting for final stats, reporting incomplete intervals at final-timeout")
+			policy = config.FINAL_FORCE_PARTIAL
+			terminated = nil
+		default:
+		}
+		c.Unlock()
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // Collect collects stats from all local clients. It's called periodically by
 // the goroutine in Start, or once by Stop if periodic stats aren't enabled.
 func (c *Collector) Collect() bool {
@@ -294,6 +586,8 @@ func (c *Collector) Collect() bool {
 	now := Now()
 	c.local.Interval += 1
 	c.local.Seconds = now.Sub(c.last).Seconds()
+	c.local.Start = c.last
+	c.local.End = now
 	c.last = now
 
 	// Update total runtime: calculated from c.start, not c.last
@@ -327,15 +621,78 @@ func (c *Collector) Collect() bool {
 
 			// Merge stats into our local copies
 			c.local.Trx[trxName].Combine(s)
-			c.local.Total.Combine(s)
+			if !c.trx[i][j].label {
+				c.local.Total.Combine(s) // labels are already in total via trx
+			}
+		}
+	}
+
+	// Limit progress, if any
+	if len(c.limits) > 0 {
+		c.local.Progress = make([]limit.Progress, 0, len(c.limits))
+		for _, lm := range c.limits {
+			c.local.Progress = append(c.local.Progress, lm.Progress()...)
 		}
 	}
 
+	// Copy local stats because c.local is reset on next Collect, but the interval
+	// might be pending (waiting for remote stats) until then
+	in := c.local
+	in.Total = NewStats()
+	in.Total.Copy(c.local.Total)
+	in.Trx = make(map[string]*Stats, len(c.local.Trx))
+	for name, s := range c.local.Trx {
+		in.Trx[name] = NewStats()
+		in.Trx[name].Copy(s)
+	}
+
+	c.inFlightMux.Lock()
+	in.InFlight = c.inFlightSample
+	c.inFlightSample = InFlight{}
+	c.inFlightMux.Unlock()
+
 	c.Lock()
 	defer c.Unlock()
-	c.interval[c.n] = c.local
-	c.n++
-	return c.Report(false)
+	in.Warnings = c.warnings // new since last interval
+	c.warnings = nil
+	in.ExecGroup = strings.Join(c.egRan, ",")
+	in.Target = Target{}
+	for _, name := range c.egRan {
+		in.Target.add(c.egTarget[name])
+	}
+	in.ExecGroups = c.execGroupStats()
+	c.egRan = append([]string{}, c.egRunning...) // still running in next interval
+	return c.add(in)
+}
+
+// execGroupStats returns the stats of each exec group that ran in the current
+// interval from the trx stats swapped by Collect. The caller must hold the lock.
+func (c *Collector) execGroupStats() map[string]ExecGroupStats {
+	if len(c.egRan) == 0 {
+		return nil
+	}
+	egs := make(map[string]ExecGroupStats, len(c.egRan))
+	for _, name := range c.egRan {
+		egs[name] = ExecGroupStats{
+			Clients: c.egClients[name],
+			Trx:     map[string]*Stats{},
+			Target:  c.egTarget[name],
+		}
+	}
+	for i := range c.trx {
+		for j := range c.trx[i] {
+			t := c.trx[i][j]
+			eg, ok := egs[t.ExecGroup]
+			if !ok || t.label {
+				continue
+			}
+			if _, ok := eg.Trx[t.Name]; !ok {
+				eg.Trx[t.Name] = NewStats()
+			}
+			eg.Trx[t.Name].Combine(c.stats[i][j])
+		}
+	}
+	return egs
 }
 
 // Recv receives stats from remote compute instances. It's called by
@@ -344,52 +701,210 @@ func (c *Collector) Recv(in Instance) {
 	finch.Debug("recv %+v", in)
 	c.Lock()
 	defer c.Unlock()
+	// Remote instances send intervals in order, but they retransmit stats when
+	// a send fails, including when the server received the stats but the
+	// response was lost, so ignore duplicates instead of counting them twice.
+	// Intervals are per session, so a remote with the same hostname that
+	// restarted (or another remote with the same hostname) is not a duplicate.
+	key := in.Hostname + " " + in.Session
+	if last, ok := c.recv[key]; ok && in.Interval <= last {
+		log.Printf("Duplicate stats from %s for interval %d (last %d), ignoring", in.Hostname, in.Interval, last)
+		return
+	}
+	c.recv[key] = in.Interval
+	c.add(in)
+}
 
+// add adds stats from one instance to its interval, which is aligned by the
+// interval number reported by the instance, not when the stats are received.
+// Then it reports complete intervals. The caller must hold the lock.
+func (c *Collector) add(in Instance) bool {
 	// Is the received interval in the past? This can happen for stats from remote
-	// instances if, for example, there's a really bad network delay. Since the old
-	// interval has already been reported, and we don't buffer or report intervals
-	// out of order, we just have to drop the old/delayed interval.
+	// instances if, for example, there's a really bad network delay. The interval
+	// has already been reported (incomplete), so report the late stats separately
+	// rather than discard them.
 	if in.Interval < c.intervalNo {
-		log.Printf("Discarding past stats: %+v", in)
-		return
+		log.Printf("Late stats from %s for interval %d (current interval %d); reporting separately", in.Hostname, in.Interval, c.intervalNo)
+		for _, r := range c.reporters {
+			r.Report([]Instance{in})
+		}
+		return false
 	}
 
-	// Reverse of above: is received interval in the future? If yes, then report
-	// the current interval because it must not have filled up (else it would have
-	// reported earlier). This can happen if stats from one or more remote instance
-	// are return  lost, so the interval doesn't complete. This will report a partial interval.
-	if in.Interval > c.intervalNo {
-		log.Printf("Received next stats interval (%d) before current interval (%d) complete; reporting incomplete current interval; next stats: %+v", in.Interval, c.intervalNo, in)
-		c.Report(true) // true=force
-		c.interval[0] = in
-		c.n = 1
-		return
+	// Buffer stats until the interval is complete (stats from all instances)
+	c.pending[in.Interval] = append(c.pending[in.Interval], in)
+
+	// Reverse of above: is the received interval too far in the future? If yes,
+	// stats from one or more instances for the current interval are very late
+	// or lost, so report incomplete intervals until the received interval is
+	// within the buffer (stats.buffer). With the default buffer (0), receiving
+	// the next interval reports the current interval incomplete.
+	for in.Interval > c.intervalNo+c.buffer {
+		log.Printf("Received stats interval %d before interval %d complete (buffer %d); reporting incomplete interval %d: have %d of %d instances",
+			in.Interval, c.intervalNo, c.buffer, c.intervalNo, len(c.pending[c.intervalNo]), c.nInstances)
+		c.reportInterval()
 	}
 
-	// Stats in current interval; buffer until we've received all stats
-	c.interval[c.n] = in
-	c.n += 1
-	c.Report(false)
+	return c.Report(false)
 }
 
-// Report reports stats when then current interval is completed: when there are
-// stats from all instances (local and remote). Until the interval is complete,
-// Report does nothing and returns false, unless force is true to force reporting
-// an incomplete interval, which happens when Stop(timeout) times out.
+// Report reports stats when intervals are completed: when there are stats from
+// all instances (local and remote). Complete intervals are reported in order.
+// Until the current interval is complete, Report does nothing and returns false,
+// unless force is true to force reporting incomplete intervals, which happens
+// when Stop times out waiting for final stats (stats.final-policy=force-partial).
+// It returns true if it reported at least one interval and no intervals are
+// pending.
 func (c *Collector) Report(force bool) bool {
-	if force {
-		finch.Debug("interval %d: forcing with %d of %d instances", c.intervalNo, c.n, c.nInstances)
-	} else if c.n < c.nInstances {
-		finch.Debug("interval %d: not complete: have %d of %d", c.intervalNo, c.n, c.nInstances)
-		return false // wait for more stats in this interval
-	} else {
-		finch.Debug("interval %d: complete", c.intervalNo)
+	reported := false
+	for len(c.pending) > 0 {
+		n := uint(len(c.pending[c.intervalNo]))
+		if n < c.nInstances {
+			if !force {
+				finch.Debug("interval %d: not complete: have %d of %d", c.intervalNo, n, c.nInstances)
+				break // wait for more stats in this interval
+			}
+			finch.Debug("interval %d: forcing with %d of %d instances", c.intervalNo, n, c.nInstances)
+		} else {
+			finch.Debug("interval %d: complete", c.intervalNo)
+		}
+		c.reportInterval()
+		reported = true
 	}
-	for _, r := range c.reporters {
-		r.Report(c.interval[0:c.n])
+	return reported && len(c.pending) == 0
+}
+
+// reportInterval reports the current interval, if there are any stats, and
+// advances to the next interval. The caller must hold the lock.
+func (c *Collector) reportInterval() {
+	if from := c.pending[c.intervalNo]; len(from) > 0 {
+		// Events are server-side, so they're added to the first instance only
+		var later []Event
+		for _, e := range c.events {
+			if e.Runtime <= from[0].Runtime {
+				from[0].Events = append(from[0].Events, e)
+			} else {
+				later = append(later, e)
+			}
+		}
+		c.events = later
+		for _, r := range c.reporters {
+			r.Report(from)
+		}
+		c.reported = time.Now()
+		c.execGroupBoundary(from)
 	}
-	c.reported = time.Now()
+	delete(c.pending, c.intervalNo)
 	c.intervalNo += 1
-	c.n = 0
-	return true // interval complete and reported
+}
+
+// execGroupBoundary adds the interval to the summary of each exec group that ran
+// in it, and reports the summary of exec groups that ran in the previous interval
+// but not this one (they finished). Only the exec group's stats are added
+// (Instance.ExecGroups), not the stats of other exec groups that ran at the
+// same time. The caller must hold the lock.
+func (c *Collector) execGroupBoundary(from []Instance) {
+	names := execGroupNames(from)
+	for _, name := range names {
+		egFrom := execGroupInstances(from, name)
+		if len(egFrom) == 0 {
+			continue // no stats for exec group (stats disabled)
+		}
+		sum, ok := c.egSummary[name]
+		if !ok {
+			in := NewInstance("exec group " + name)
+			in.ExecGroup = name
+			sum = &in
+			c.egSummary[name] = sum
+			c.egOrder = append(c.egOrder, name)
+		}
+		sum.summarize(egFrom)
+		sum.ExecGroup = name
+	}
+	if len(c.egOrder) > 1 && len(c.egWait) > 0 {
+		wait := c.egWait
+		c.egWait = nil
+		for _, name := range wait {
+			c.reportExecGroup(name)
+		}
+	}
+	for _, name := range c.egPrev {
+		if !contains(names, name) {
+			c.reportExecGroup(name)
+		}
+	}
+	c.egPrev = names
+}
+
+// reportExecGroup reports the summary of the exec group to reporters that
+// implement ExecGroupReporter, if the stage has more than one exec group (else
+// the summary is the same as the stage stats). If only one exec group has been
+// seen, reporting is deferred (egWait) until another exec group is seen, which
+// might be in a later interval, or until Stop, when it's not reported because
+// the stage had only one exec group. The caller must hold the lock.
+func (c *Collector) reportExecGroup(name string) {
+	sum, ok := c.egSummary[name]
+	if !ok {
+		return // already reported
+	}
+	if len(c.egOrder) < 2 {
+		if !contains(c.egWait, name) {
+			c.egWait = append(c.egWait, name)
+		}
+		return
+	}
+	delete(c.egSummary, name)
+	finch.Debug("exec group %s summary", name)
+	for _, r := range c.reporters {
+		if er, ok := r.(ExecGroupReporter); ok {
+			er.ReportExecGroup(*sum)
+		}
+	}
+}
+
+// execGroupInstances returns copies of the instances with only the stats of the
+// exec group, or nil if no instance has stats for it.
+func execGroupInstances(from []Instance, name string) []Instance {
+	var egFrom []Instance
+	for i := range from {
+		eg, ok := from[i].ExecGroups[name]
+		if !ok {
+			continue
+		}
+		in := from[i]
+		in.Clients = eg.Clients
+		in.Target = eg.Target
+		in.Trx = eg.Trx
+		in.Total = NewStats()
+		for _, s := range eg.Trx {
+			in.Total.Combine(s)
+		}
+		egFrom = append(egFrom, in)
+	}
+	return egFrom
+}
+
+// execGroupNames returns the unique exec group names of the instances in order.
+func execGroupNames(from []Instance) []string {
+	names := []string{}
+	for i := range from {
+		if from[i].ExecGroup == "" {
+			continue
+		}
+		for _, name := range strings.Split(from[i].ExecGroup, ",") {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
 }
@@ -3,6 +3,8 @@
 package stats_test
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,17 +54,17 @@ func TestCollector_1Client(t *testing.T) {
 
 	c.Start()
 	trx1.Record(stats.READ, 210)
-	c.Stop(1*time.Second, false)
+	c.Stop(context.Background())
 
 	if len(gotStats) == 0 {
 		t.Fatal("got zero stats, expected 1")
 	}
 
 	s1 := stats.NewStats()
-	// {READ, WRITE, COMMIT, TOTAL}
-	s1.N = []uint64{1, 0, 0, 1}
-	s1.Min = []int64{210, 0, 0, 210}
-	s1.Max = []int64{210, 0, 0, 210}
+	// {READ, WRITE, COMMIT, TOTAL, TRX_FILE}
+	s1.N = []uint64{1, 0, 0, 1, 0}
+	s1.Min = []int64{210, 0, 0, 210, 0}
+	s1.Max = []int64{210, 0, 0, 210, 0}
 	// bucket 67 [208.929613, 218.776162)
 	s1.Buckets[stats.READ][67] = 1
 	s1.Buckets[stats.TOTAL][67] = 1
@@ -70,10 +72,13 @@ func TestCollector_1Client(t *testing.T) {
 	expectStats := []stats.Instance{
 		{
 			Hostname: "local",
+			Session:  gotStats[0].Session, // random
 			Clients:  1,
 			Interval: 1,
 			Seconds:  5.0,
 			Runtime:  5.0,
+			Start:    times[0],
+			End:      times[1],
 			Total:    s1,
 			Trx:      map[string]*stats.Stats{"t1": s1},
 		},
@@ -133,17 +138,17 @@ func TestCollector_2Clients(t *testing.T) {
 	c2trx1.Record(stats.READ, 200)
 	c2trx1.Record(stats.READ, 222)
 
-	c.Stop(1*time.Second, false)
+	c.Stop(context.Background())
 
 	if len(gotStats) == 0 {
 		t.Fatal("got zero stats, expected 1")
 	}
 
 	s1 := stats.NewStats()
-	// {READ, WRITE, COMMIT, TOTAL}
-	s1.N = []uint64{4, 0, 0, 4}
-	s1.Min = []int64{100, 0, 0, 100}
-	s1.Max = []int64{222, 0, 0, 222}
+	// {READ, WRITE, COMMIT, TOTAL, TRX_FILE}
+	s1.N = []uint64{4, 0, 0, 4, 0}
+	s1.Min = []int64{100, 0, 0, 100, 0}
+	s1.Max = []int64{222, 0, 0, 222, 0}
 	// 50 [95.499259, 100.000000)
 	// 53 [109.647820, 114.815362)
 	// 66 [199.526231, 208.929613)
@@ -162,10 +167,13 @@ func TestCollector_2Clients(t *testing.T) {
 	expectStats := []stats.Instance{
 		{
 			Hostname: "local",
+			Session:  gotStats[0].Session, // random
 			Clients:  2,
 			Interval: 1,
 			Seconds:  5.0,
 			Runtime:  5.0,
+			Start:    times[0],
+			End:      times[1],
 			Total:    s1,
 			Trx:      map[string]*stats.Stats{"t1": s1},
 		},
@@ -180,10 +188,10 @@ func TestCollector_2Clients(t *testing.T) {
 
 func TestCollector_Combine(t *testing.T) {
 	s1 := stats.NewStats()
-	// {READ, WRITE, COMMIT, TOTAL}
-	s1.N = []uint64{4, 0, 0, 4}
-	s1.Min = []int64{100, 0, 0, 100}
-	s1.Max = []int64{222, 0, 0, 222}
+	// {READ, WRITE, COMMIT, TOTAL, TRX_FILE}
+	s1.N = []uint64{4, 0, 0, 4, 0}
+	s1.Min = []int64{100, 0, 0, 100, 0}
+	s1.Max = []int64{222, 0, 0, 222, 0}
 	s1.Buckets[stats.READ][50] = 1
 	s1.Buckets[stats.READ][53] = 1
 	s1.Buckets[stats.READ][66] = 1
@@ -210,10 +218,10 @@ func TestCollector_Combine(t *testing.T) {
 	}
 
 	s2 := stats.NewStats()
-	// {READ, WRITE, COMMIT, TOTAL}
-	s2.N = []uint64{1, 0, 0, 1}
-	s2.Min = []int64{210, 0, 0, 210}
-	s2.Max = []int64{210, 0, 0, 210}
+	// {READ, WRITE, COMMIT, TOTAL, TRX_FILE}
+	s2.N = []uint64{1, 0, 0, 1, 0}
+	s2.Min = []int64{210, 0, 0, 210, 0}
+	s2.Max = []int64{210, 0, 0, 210, 0}
 	s2.Buckets[stats.READ][67] = 1
 	s2.Buckets[stats.TOTAL][67] = 1
 	in2 := stats.Instance{
@@ -229,9 +237,9 @@ func TestCollector_Combine(t *testing.T) {
 	all.Combine([]stats.Instance{in1, in2})
 
 	expect := stats.NewStats()
-	expect.N = []uint64{5, 0, 0, 5}
-	expect.Min = []int64{100, 0, 0, 100}
-	expect.Max = []int64{222, 0, 0, 222}
+	expect.N = []uint64{5, 0, 0, 5, 0}
+	expect.Min = []int64{100, 0, 0, 100, 0}
+	expect.Max = []int64{222, 0, 0, 222, 0}
 	expect.Buckets[stats.READ][50] = 1
 	expect.Buckets[stats.READ][53] = 1
 	expect.Buckets[stats.READ][66] = 1
@@ -246,4 +254,521 @@ func TestCollector_Combine(t *testing.T) {
 	if diff := deep.Equal(all.Total, expect); diff != nil {
 		t.Error(diff)
 	}
+
+	// Both instances ran trx t1, so its stats are combined, too
+	if diff := deep.Equal(all.Trx, map[string]*stats.Stats{"t1": expect}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_Buffer(t *testing.T) {
+	var got [][]uint // interval numbers of each report
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			intervals := make([]uint, len(from))
+			for i := range from {
+				intervals[i] = from[i].Interval
+			}
+			got = append(got, intervals)
+		},
+	}
+	stats.Register("mock-buffer", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Buffer: "1",
+		Report: map[string]map[string]string{
+			"mock-buffer": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := func(host string, interval uint) stats.Instance {
+		in := stats.NewInstance(host)
+		in.Interval = interval
+		return in
+	}
+
+	// Interval 2 from remote a arrives before interval 1 from remote b.
+	// With buffer=1, interval 1 isn't reported incomplete.
+	c.Recv(in("a", 1))
+	c.Recv(in("a", 2))
+	if len(got) != 0 {
+		t.Fatalf("got %d reports, expected 0: %v", len(got), got)
+	}
+	c.Recv(in("b", 1))
+	c.Recv(in("b", 2))
+	expect := [][]uint{{1, 1}, {2, 2}}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Interval 5 is beyond the buffer (current interval 3 + 1), so interval 3
+	// is reported incomplete. Then interval 3 from b is late: reported separately.
+	got = nil
+	c.Recv(in("a", 3))
+	c.Recv(in("a", 5))
+	c.Recv(in("b", 3))
+	expect = [][]uint{{3}, {3}}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_Duplicate(t *testing.T) {
+	var got []uint // interval number of each report
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, from[0].Interval)
+		},
+	}
+	stats.Register("mock-duplicate", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-duplicate": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := func(interval uint) stats.Instance {
+		in := stats.NewInstance("a")
+		in.Session = "s1"
+		in.Interval = interval
+		return in
+	}
+
+	// Remote retransmits interval 1 (e.g. server received it but the response
+	// was lost), so the second one is ignored, not reported late
+	c.Recv(in(1))
+	c.Recv(in(1))
+	c.Recv(in(2))
+	if diff := deep.Equal(got, []uint{1, 2}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Same hostname but different session (remote restarted) is not a duplicate:
+	// interval 2 is reported late (separately), not ignored
+	s2 := in(2)
+	s2.Session = "s2"
+	c.Recv(s2)
+	if diff := deep.Equal(got, []uint{1, 2, 2}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_FinalPolicy(t *testing.T) {
+	var got []int // number of instances in each report
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, len(from))
+		},
+	}
+	stats.Register("mock-final-policy", r) // needs a unique reporter name
+
+	// Local instance + 1 remote that never sends its final stats
+	for _, tt := range []struct {
+		policy string
+		expect []int
+	}{
+		{"", []int{1}},                         // default force-partial
+		{config.FINAL_FORCE_PARTIAL, []int{1}}, // report incomplete interval
+		{config.FINAL_DROP, nil},               // discard incomplete interval
+		{config.FINAL_WAIT_FOREVER, []int{1}},  // terminated, so force-partial
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			got = nil
+			cfg := config.Stats{
+				FinalTimeout: "200ms",
+				FinalPolicy:  tt.policy,
+				Report: map[string]map[string]string{
+					"mock-final-policy": nil,
+				},
+			}
+			c, err := stats.NewCollector(cfg, "local", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Start()
+			ctx := context.Background()
+			if tt.policy == config.FINAL_WAIT_FOREVER {
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+				ctx = cancelled
+			}
+			t0 := time.Now()
+			if c.Stop(ctx) {
+				t.Errorf("Stop returned true, expected false (timeout)")
+			}
+			if d := time.Now().Sub(t0); d < 200*time.Millisecond {
+				t.Errorf("Stop returned after %s, expected >= 200ms final-timeout", d)
+			}
+			if diff := deep.Equal(got, tt.expect); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCollector_WaitForever(t *testing.T) {
+	var got []int // number of instances in each report
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, len(from))
+		},
+	}
+	stats.Register("mock-wait-forever", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		FinalTimeout: "100ms",
+		FinalPolicy:  config.FINAL_WAIT_FOREVER,
+		Report: map[string]map[string]string{
+			"mock-wait-forever": nil,
+		},
+	}
+	remote := stats.NewInstance("remote")
+	remote.Interval = 1
+
+	// Remote sends its final stats after several final timeouts: Stop waits
+	// and reports the complete interval
+	got = nil
+	c, err := stats.NewCollector(cfg, "local", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	time.AfterFunc(350*time.Millisecond, func() { c.Recv(remote) })
+	t0 := time.Now()
+	if !c.Stop(context.Background()) {
+		t.Errorf("Stop returned false, expected true (all stats received)")
+	}
+	if d := time.Now().Sub(t0); d < 350*time.Millisecond {
+		t.Errorf("Stop returned after %s, expected >= 350ms", d)
+	}
+	if diff := deep.Equal(got, []int{2}); diff != nil {
+		t.Error(diff)
+	}
+
+	// CTRL-C while waiting forever: force-partial at the final timeout
+	got = nil
+	c, err = stats.NewCollector(cfg, "local", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(250*time.Millisecond, cancel)
+	t0 = time.Now()
+	if c.Stop(ctx) {
+		t.Errorf("Stop returned true, expected false (terminated)")
+	}
+	if d := time.Now().Sub(t0); d < 250*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Stop returned after %s, expected 250ms to 2s", d)
+	}
+	if diff := deep.Equal(got, []int{1}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_Event(t *testing.T) {
+	var got [][]string // event names of each report
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			events := []string{}
+			for _, e := range from[0].Events {
+				events = append(events, e.Event)
+			}
+			got = append(got, events)
+		},
+	}
+	stats.Register("mock-event", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-event": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := func(interval uint, runtime float64) stats.Instance {
+		in := stats.NewInstance("a")
+		in.Interval = interval
+		in.Runtime = runtime
+		return in
+	}
+
+	// Events are reported in the first interval with runtime >= event runtime,
+	// regardless of when they're added
+	c.Event(stats.Event{Runtime: 12.0, Event: "e2"})
+	c.Event(stats.Event{Runtime: 3.0, Event: "e1"})
+	c.Recv(in(1, 10.0))
+	c.Recv(in(2, 20.0))
+	c.Recv(in(3, 30.0))
+	expect := [][]string{{"e1"}, {"e2"}, {}}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_Warn(t *testing.T) {
+	var got [][]string
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, from[0].Warnings)
+		},
+	}
+	stats.Register("mock-warn", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-warn": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Watch([]*stats.Trx{stats.NewTrx("t1")})
+
+	// A warning is reported once in the next interval, not every interval after
+	c.Start()
+	c.Collect() // 1
+	c.Warn("w1")
+	c.Collect() // 2: w1
+	c.Collect() // 3
+	c.Stop(context.Background())
+	if len(got) < 3 {
+		t.Fatalf("got %d intervals, expected at least 3", len(got))
+	}
+	expect := [][]string{nil, {"w1"}, nil}
+	if diff := deep.Equal(got[0:3], expect); diff != nil {
+		t.Error(diff)
+	}
+	for i := 3; i < len(got); i++ {
+		if len(got[i]) > 0 {
+			t.Errorf("interval %d: got warnings %v, expected none", i+1, got[i])
+		}
+	}
+}
+
+func TestCollector_Label(t *testing.T) {
+	var gotStats []stats.Instance
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			gotStats = make([]stats.Instance, len(from))
+			copy(gotStats, from)
+		},
+	}
+	stats.Register("mock-label", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-label": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two trx with one labeled statement each: label stats are reported like
+	// trx stats but not counted twice in total
+	trx1 := stats.NewTrx("t1")
+	trx2 := stats.NewTrx("t2")
+	label := stats.NewLabel("lookup")
+	c.Watch([]*stats.Trx{trx1, trx2, label})
+
+	c.Start()
+	trx1.Record(stats.READ, 100)
+	label.Record(stats.READ, 100)
+	trx2.Record(stats.READ, 200)
+	label.Record(stats.READ, 200)
+	c.Stop(context.Background())
+
+	if len(gotStats) != 1 {
+		t.Fatalf("got %d stats, expected 1", len(gotStats))
+	}
+	in := gotStats[0]
+	if n := in.Total.N[stats.READ]; n != 2 {
+		t.Errorf("total reads = %d, expected 2", n)
+	}
+	l, ok := in.Trx[stats.LABEL_PREFIX+"lookup"]
+	if !ok {
+		t.Fatalf("no label stats in %v", in.Trx)
+	}
+	if n := l.N[stats.READ]; n != 2 {
+		t.Errorf("label reads = %d, expected 2", n)
+	}
+}
+
+func TestCollector_ExecGroup(t *testing.T) {
+	var got []string    // exec group of each interval
+	var gotSum []string // exec group summaries
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, from[0].ExecGroup)
+		},
+		ExecGroupFunc: func(sum stats.Instance) {
+			gotSum = append(gotSum, sum.ExecGroup)
+			// Only the exec group's own stats, not the other exec group's stats
+			// in the overlapping interval 2
+			if sum.Total.N[stats.READ] != 2 {
+				t.Errorf("exec group %s summary reads = %d, expected 2", sum.ExecGroup, sum.Total.N[stats.READ])
+			}
+			if sum.Clients != 1 {
+				t.Errorf("exec group %s summary clients = %d, expected 1", sum.ExecGroup, sum.Clients)
+			}
+		},
+	}
+	stats.Register("mock-exec-group", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-exec-group": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trxA := stats.NewTrx("t1")
+	trxA.ExecGroup = "a"
+	trxB := stats.NewTrx("t2")
+	trxB.ExecGroup = "b"
+	c.Watch([]*stats.Trx{trxA})
+	c.Watch([]*stats.Trx{trxB})
+	c.Start()
+
+	// Interval is labeled with every exec group that ran in it, so a boundary
+	// interval has both
+	c.StartExecGroup("a")
+	trxA.Record(stats.READ, 100)
+	c.Collect() // 1: a
+	trxA.Record(stats.READ, 100)
+	c.StopExecGroup("a")
+	c.StartExecGroup("b")
+	trxB.Record(stats.READ, 100)
+	c.Collect() // 2: a,b
+	trxB.Record(stats.READ, 100)
+	c.Collect() // 3: b, so a finished
+	if diff := deep.Equal(gotSum, []string{"a"}); diff != nil {
+		t.Error(diff)
+	}
+	c.StopExecGroup("b")
+	c.Stop(context.Background())
+
+	if diff := deep.Equal(got[:3], []string{"a", "a,b", "b"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(gotSum, []string{"a", "b"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_ExecGroupGap(t *testing.T) {
+	// Exec group a finishes before b starts, so there's an interval without
+	// exec groups. The summary of a is reported when b is seen, not lost.
+	var gotSum []string
+	r := mock.StatsReporter{
+		ExecGroupFunc: func(sum stats.Instance) {
+			gotSum = append(gotSum, sum.ExecGroup)
+			if sum.Total.N[stats.READ] != 1 {
+				t.Errorf("exec group %s summary reads = %d, expected 1", sum.ExecGroup, sum.Total.N[stats.READ])
+			}
+		},
+	}
+	stats.Register("mock-exec-group-gap", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-exec-group-gap": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trxA := stats.NewTrx("t1")
+	trxA.ExecGroup = "a"
+	trxB := stats.NewTrx("t2")
+	trxB.ExecGroup = "b"
+	c.Watch([]*stats.Trx{trxA})
+	c.Watch([]*stats.Trx{trxB})
+	c.Start()
+
+	c.StartExecGroup("a")
+	trxA.Record(stats.READ, 100)
+	c.StopExecGroup("a")
+	c.Collect() // 1: a
+	c.Collect() // 2: none, so a finished, but it's the only exec group so far
+	if len(gotSum) != 0 {
+		t.Errorf("got exec group summaries %v before b, expected none", gotSum)
+	}
+	c.StartExecGroup("b")
+	trxB.Record(stats.READ, 100)
+	c.StopExecGroup("b")
+	c.Collect() // 3: b
+	if diff := deep.Equal(gotSum, []string{"a"}); diff != nil {
+		t.Error(diff)
+	}
+	c.Stop(context.Background())
+
+	if diff := deep.Equal(gotSum, []string{"a", "b"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollector_InFlight(t *testing.T) {
+	var got []stats.Instance
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, from...)
+		},
+	}
+	stats.Register("mock-in-flight", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-in-flight": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trx1 := stats.NewTrx("t1")
+	c.Watch([]*stats.Trx{trx1})
+	c.Watch([]*stats.Trx{stats.NewTrx("t1")})
+
+	// 2 clients: 1 always busy, 1 never busy
+	busy := c.InFlight()
+	c.InFlight()
+	busy.Store(1)
+
+	c.Start()
+	time.Sleep(10 * stats.InFlightSampleFreq)
+	c.Stop(context.Background())
+
+	if len(got) != 1 {
+		t.Fatalf("got %d intervals, expected 1", len(got))
+	}
+	f := got[0].InFlight
+	if f.Samples == 0 {
+		t.Fatal("zero in-flight samples")
+	}
+	if f.Min != 1 || f.Max != 1 || f.Avg != 1 {
+		t.Errorf("got in flight min %d, avg %f, max %d; expected 1, 1, 1", f.Min, f.Avg, f.Max)
+	}
+	line := stats.InFlightString(got[0])
+	if !strings.HasPrefix(line, "in flight: min 1, avg 1.0, max 1 of 2 clients") {
+		t.Errorf("got '%s'", line)
+	}
 }
@@ -8,13 +8,33 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/square/finch"
 )
 
-// CSV is a Reporter that prints stats to STDOUT. This is the default when
-// config.stats is not set.
+// CSV is a Reporter that writes stats to a CSV file.
+//
+//	stats:
+//	  report:
+//	    csv:
+//	      file:          "/tmp/finch.csv"
+//	      each-instance: false
+//	      each-trx:      false
+//	      percentiles:   "P999"
+//
+// On the server, stats from all compute instances are combined into one row per
+// interval. If each-instance is true, there's also one row per instance labeled
+// by the instance hostname in the compute column. If --run-id or --tag is set,
+// there are two more columns: run_id and tags ("k1=v1 k2=v2"). The start and end
+// columns are the wall-clock interval (RFC3339), followed by mysql_warnings.
+// Events, markers, and load generator warnings (Instance.Events and Warnings)
+// are not written: every row is an interval.
 type CSV struct {
-	file *os.File
-	p    []float64
+	file    *os.File
+	p       []float64
+	each    bool
+	eachTrx bool
+	run     bool
 }
 
 var _ Reporter = &CSV{}
@@ -47,11 +67,19 @@ func NewCSV(opts map[string]string) (*CSV, error) {
 		strings.Join(withPrefix(sP, "w_"), ","), // write
 		strings.Join(withPrefix(sP, "c_"), ","), // commit
 	)
+	fmt.Fprint(f, ",start,end,mysql_warnings")
+	run := RunId != "" || len(Tags) > 0
+	if run {
+		fmt.Fprint(f, ",run_id,tags")
+	}
 	fmt.Fprintln(f)
 
 	r := &CSV{
-		file: f,
-		p:    nP,
+		file:    f,
+		p:       nP,
+		each:    finch.Bool(opts["each-instance"]),
+		eachTrx: finch.Bool(opts["each-trx"]),
+		run:     run,
 	}
 	return r, nil
 }
@@ -69,6 +97,32 @@ func (r *CSV) Report(from []Instance) {
 		compute = fmt.Sprintf("%d combined", len(from))
 	}
 
+	if r.each && len(from) > 1 {
+		for i := range from {
+			r.write(from[i], from[i].Total, from[i].Clients, from[i].Hostname)
+		}
+	}
+
+	r.write(from[0], total, clients, compute)
+
+	if !r.eachTrx {
+		return
+	}
+	trx := map[string]*Stats{}
+	for i := range from {
+		for name, s := range from[i].Trx {
+			if _, ok := trx[name]; !ok {
+				trx[name] = NewStats()
+			}
+			trx[name].Combine(s)
+		}
+	}
+	for _, name := range trxNames(trx) {
+		r.write(from[0], trx[name], clients, compute+" "+name)
+	}
+}
+
+func (r *CSV) write(in Instance, total *Stats, clients uint, compute string) {
 	var errorCount uint64
 	for _, v := range total.Errors {
 		errorCount += v
@@ -77,31 +131,31 @@ func (r *CSV) Report(from []Instance) {
 	// Fill in the line with values except the P percentile values, which is done below
 	// because there's a variable number of them
 	line := fmt.Sprintf(Fmt,
-		from[0].Interval,
-		from[0].Seconds, // duration (of interval)
-		from[0].Runtime,
+		in.Interval,
+		in.Seconds, // duration (of interval)
+		in.Runtime,
 		clients,
 
 		// TOTAL
-		int64(float64(total.N[TOTAL])/from[0].Seconds), // QPS
+		int64(float64(total.N[TOTAL])/in.Seconds), // QPS
 		total.Min[TOTAL],
 		// P
 		total.Max[TOTAL],
 
 		// READ
-		int64(float64(total.N[READ])/from[0].Seconds),
+		int64(float64(total.N[READ])/in.Seconds),
 		total.Min[READ],
 		// P
 		total.Max[READ],
 
 		// WRITE
-		int64(float64(total.N[WRITE])/from[0].Seconds),
+		int64(float64(total.N[WRITE])/in.Seconds),
 		total.Min[WRITE],
 		// P
 		total.Max[WRITE],
 
 		// COMMIT
-		int64(float64(total.N[COMMIT])/from[0].Seconds), // TPS
+		int64(float64(total.N[COMMIT])/in.Seconds), // TPS
 		total.Min[COMMIT],
 		// P
 		total.Max[COMMIT],
@@ -118,6 +172,12 @@ func (r *CSV) Report(from []Instance) {
 	line = strings.Replace(line, "P", intsToString(total.Percentiles(WRITE, r.p), ",", false), 1)
 	line = strings.Replace(line, "P", intsToString(total.Percentiles(COMMIT, r.p), ",", false), 1)
 
+	line += "," + Timestamp(in.Start) + "," + Timestamp(in.End) + fmt.Sprintf(",%d", total.MySQLWarnings)
+
+	if r.run {
+		line += "," + in.RunId + "," + TagString(in.Tags)
+	}
+
 	fmt.Fprintln(r.file, line)
 }
 
@@ -0,0 +1,256 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// HLog is a Reporter that writes the response time histogram of each interval
+// in HdrHistogram interval log format (.hlog), which can be analyzed and plotted
+// with HdrHistogram tools like HistogramLogProcessor and HdrHistogram Plotter.
+//
+//	stats:
+//	  report:
+//	    hlog:
+//	      file:     "/tmp/finch.hlog"
+//	      each-trx: false
+//
+// Each interval is one line with the histogram of all queries (TOTAL). If each-trx
+// is true, there's also one line per trx tagged with the trx name (Tag=name).
+// Values are the recorded response times, in microseconds (or nanoseconds if
+// config.stats.precision is ns), including Interval_Max. On the server, stats
+// from all compute instances are combined into one histogram per interval.
+// Events and markers (Instance.Events) are written as comment lines before the
+// interval, which HdrHistogram tools ignore.
+type HLog struct {
+	file    *os.File
+	eachTrx bool
+	start   time.Time // StartTime in log header, set on first Report
+}
+
+var _ Reporter = &HLog{}
+
+func NewHLog(opts map[string]string) (*HLog, error) {
+	var f *os.File
+	var err error
+	fileName := opts["file"]
+	if fileName == "" {
+		// Use a random temp file
+		f, err = os.CreateTemp("", fmt.Sprintf("finch-benchmark-%s.hlog", strings.ReplaceAll(time.Now().Format(time.Stamp), " ", "_")))
+	} else {
+		f, err = os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("HdrHistogram log file: %s\n", f.Name())
+
+	r := &HLog{
+		file:    f,
+		eachTrx: finch.Bool(opts["each-trx"]),
+	}
+	return r, nil
+}
+
+func (r *HLog) Report(from []Instance) {
+	total := NewStats()
+	total.Copy(from[0].Total)
+	for i := range from[1:] {
+		total.Combine(from[1+i].Total)
+	}
+
+	// Runtime is elapsed seconds at the end of the interval, so the start of
+	// the benchmark is now minus runtime, and timestamps in the log are relative
+	// to the start
+	in := from[0]
+	if r.start.IsZero() {
+		r.start = time.Now().Add(-time.Duration(in.Runtime * float64(time.Second)))
+		fmt.Fprintf(r.file, "#[Logged with Finch %s]\n", finch.VERSION)
+		fmt.Fprintf(r.file, "#[Histogram log format version 1.3]\n")
+		fmt.Fprintf(r.file, "#[StartTime: %.3f (seconds since epoch), %s]\n",
+			float64(r.start.UnixMilli())/1000, r.start.Format(time.UnixDate))
+		fmt.Fprintln(r.file, `"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`)
+	}
+
+	for i := range from {
+		for _, e := range from[i].Events {
+			event := e.Event
+			if e.Error != "" {
+				event += ": error: " + e.Error
+			}
+			fmt.Fprintf(r.file, "#[Event at %.3f (%s): %s]\n", e.Runtime, e.Time.Format(time.RFC3339), strings.ReplaceAll(event, "\n", " "))
+		}
+	}
+
+	r.write("", in, total)
+
+	if !r.eachTrx {
+		return
+	}
+	trx := map[string]*Stats{}
+	for i := range from {
+		for name, s := range from[i].Trx {
+			if _, ok := trx[name]; !ok {
+				trx[name] = NewStats()
+			}
+			trx[name].Combine(s)
+		}
+	}
+	for _, name := range trxNames(trx) {
+		r.write(name, in, trx[name])
+	}
+}
+
+func (r *HLog) write(tag string, in Instance, s *Stats) {
+	if tag != "" {
+		// Tags cannot contain commas or spaces
+		fmt.Fprintf(r.file, "Tag=%s,", strings.NewReplacer(",", "_", " ", "_").Replace(tag))
+	}
+	fmt.Fprintf(r.file, "%.3f,%.3f,%.3f,%s\n",
+		in.Runtime-in.Seconds, // StartTimestamp
+		in.Seconds,            // Interval_Length
+		float64(s.Max[TOTAL]), // Interval_Max
+		HdrHistogram(s, TOTAL),
+	)
+}
+
+func (r *HLog) Stop() {
+	r.file.Close()
+}
+
+func (r *HLog) File() string {
+	return r.file.Name()
+}
+
+// --------------------------------------------------------------------------
+
+// HdrHistogram V2 compressed encoding. See "Histogram Encoding" in
+// https://github.com/HdrHistogram/HdrHistogram/blob/master/src/main/java/org/HdrHistogram/AbstractHistogram.java
+const (
+	hdrEncodingCookie           = 0x1c849303 | 0x10 // V2, max 9 bytes per LEB128 word
+	hdrCompressedEncodingCookie = 0x1c849304 | 0x10
+	hdrSignificantDigits        = 2 // 1% precision, finer than Finch buckets (4.7%)
+)
+
+// HdrHistogram returns the response time histogram of the event type as a base64
+// HdrHistogram (V2 compressed encoding). Finch buckets are converted to HdrHistogram
+// values by recording each bucket count at the midpoint of the bucket, clamped
+// to the min and max response time, so the precision is the same as Finch
+// percentiles.
+func HdrHistogram(s *Stats, eventType byte) string {
+	h := newHdr(s.Max[eventType])
+	for n, count := range s.Buckets[eventType] {
+		if count == 0 {
+			continue
+		}
+		v := int64(base / 2) // bucket 0: [0, base)
+		if n > 0 {
+			v = int64((base*math.Pow(factor, float64(n-1)) + base*math.Pow(factor, float64(n))) / 2)
+		}
+		if v < s.Min[eventType] {
+			v = s.Min[eventType]
+		}
+		if v > s.Max[eventType] {
+			v = s.Max[eventType]
+		}
+		h.counts[h.index(v)] += count
+	}
+	return base64.StdEncoding.EncodeToString(h.encode())
+}
+
+// hdr is the minimum of an HdrHistogram (lowest discernible value 1) needed to
+// encode counts: the counts array and value to index math.
+type hdr struct {
+	highest               int64
+	subBucketHalfCountMag int
+	subBucketHalfCount    int
+	subBucketMask         uint64
+	leadingZeroCountBase  int
+	counts                []uint64
+}
+
+func newHdr(highest int64) *hdr {
+	if highest < 2 {
+		highest = 2 // must be >= 2x lowest discernible value
+	}
+	largestSingleUnit := 2 * int64(math.Pow10(hdrSignificantDigits))
+	subBucketCountMag := int(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	h := &hdr{
+		highest:               highest,
+		subBucketHalfCountMag: subBucketCountMag - 1,
+		subBucketHalfCount:    1 << (subBucketCountMag - 1),
+		subBucketMask:         uint64(1<<subBucketCountMag) - 1,
+		leadingZeroCountBase:  64 - subBucketCountMag,
+	}
+	h.counts = make([]uint64, h.index(highest)+1)
+	return h
+}
+
+func (h *hdr) index(v int64) int {
+	bucketIndex := h.leadingZeroCountBase - bits.LeadingZeros64(uint64(v)|h.subBucketMask)
+	subBucketIndex := int(uint64(v) >> bucketIndex)
+	bucketBaseIndex := (bucketIndex + 1) << h.subBucketHalfCountMag
+	return bucketBaseIndex + subBucketIndex - h.subBucketHalfCount
+}
+
+func (h *hdr) encode() []byte {
+	// Counts: ZigZag LEB128 values, and a run of zero counts is encoded as the
+	// negative number of zeros
+	var payload []byte
+	for i := 0; i < len(h.counts); {
+		if h.counts[i] != 0 {
+			payload = binary.AppendUvarint(payload, zigzag(int64(h.counts[i])))
+			i++
+			continue
+		}
+		zeros := 1
+		for i+zeros < len(h.counts) && h.counts[i+zeros] == 0 {
+			zeros++
+		}
+		if zeros > 1 {
+			payload = binary.AppendUvarint(payload, zigzag(-int64(zeros)))
+		} else {
+			payload = binary.AppendUvarint(payload, 0)
+		}
+		i += zeros
+	}
+
+	// Uncompressed: 40 byte header, then payload
+	var u bytes.Buffer
+	binary.Write(&u, binary.BigEndian, int32(hdrEncodingCookie))
+	binary.Write(&u, binary.BigEndian, int32(len(payload)))
+	binary.Write(&u, binary.BigEndian, int32(0)) // normalizing index offset
+	binary.Write(&u, binary.BigEndian, int32(hdrSignificantDigits))
+	binary.Write(&u, binary.BigEndian, int64(1)) // lowest discernible value
+	binary.Write(&u, binary.BigEndian, h.highest)
+	binary.Write(&u, binary.BigEndian, float64(1.0)) // integer to double conversion ratio
+	u.Write(payload)
+
+	// Compressed: cookie, length, then zlib (deflate) of uncompressed
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(u.Bytes())
+	zw.Close()
+	var c bytes.Buffer
+	binary.Write(&c, binary.BigEndian, int32(hdrCompressedEncodingCookie))
+	binary.Write(&c, binary.BigEndian, int32(z.Len()))
+	c.Write(z.Bytes())
+	return c.Bytes()
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
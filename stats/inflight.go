@@ -0,0 +1,118 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// InFlightSampleFreq is how often the Collector samples how many clients are
+// executing a query (in flight).
+var InFlightSampleFreq = 10 * time.Millisecond
+
+// InFlight is the number of clients executing a query, sampled every
+// InFlightSampleFreq during an interval. If it's much less than the number of
+// clients, clients are waiting on something else, like a QPS or TPS limit,
+// think time, or Finch itself. If it's close to the number of clients, they're
+// waiting on MySQL.
+type InFlight struct {
+	Samples uint64  `json:"samples"`
+	Min     uint    `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     uint    `json:"max"`
+}
+
+// sample adds one sample of n clients in flight.
+func (f *InFlight) sample(n uint) {
+	if f.Samples == 0 || n < f.Min {
+		f.Min = n
+	}
+	if n > f.Max {
+		f.Max = n
+	}
+	f.Avg += (float64(n) - f.Avg) / float64(f.Samples+1)
+	f.Samples++
+}
+
+// add adds clients in flight on another instance in the same interval: the
+// instances run at the same time, so their values are summed. Samples are not
+// summed because each instance samples the same interval: samples weight the
+// interval in merge, and more instances don't make an interval longer.
+func (f *InFlight) add(g InFlight) {
+	if g.Samples == 0 {
+		return
+	}
+	if f.Samples == 0 {
+		*f = g
+		return
+	}
+	f.Min += g.Min
+	f.Avg += g.Avg
+	f.Max += g.Max
+	if g.Samples > f.Samples {
+		f.Samples = g.Samples
+	}
+}
+
+// merge merges clients in flight in another interval, like the summary of all
+// intervals: min and max of all, and average weighted by number of samples.
+func (f *InFlight) merge(g InFlight) {
+	if g.Samples == 0 {
+		return
+	}
+	if f.Samples == 0 {
+		*f = g
+		return
+	}
+	if g.Min < f.Min {
+		f.Min = g.Min
+	}
+	if g.Max > f.Max {
+		f.Max = g.Max
+	}
+	n := f.Samples + g.Samples
+	f.Avg = (f.Avg*float64(f.Samples) + g.Avg*float64(g.Samples)) / float64(n)
+	f.Samples = n
+}
+
+// InFlightString returns a line about clients in flight, or "" if there are no
+// samples.
+func InFlightString(in Instance) string {
+	f := in.InFlight
+	if f.Samples == 0 {
+		return ""
+	}
+	return fmt.Sprintf("in flight: min %d, avg %.1f, max %d of %d clients (%s)", f.Min, f.Avg, f.Max, in.Clients, in.Hostname)
+}
+
+// InFlight returns a new flag that one client sets to 1 while executing a query,
+// else 0. The Collector samples all flags to report clients in flight. It must
+// be called for each client before Start.
+func (c *Collector) InFlight() *atomic.Int32 {
+	f := &atomic.Int32{}
+	c.inFlight = append(c.inFlight, f)
+	return f
+}
+
+// sampleInFlight samples clients in flight until Stop closes inFlightStop. It's
+// started in Start if there are any in-flight flags.
+func (c *Collector) sampleInFlight() {
+	ticker := time.NewTicker(InFlightSampleFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var n uint
+			for _, f := range c.inFlight {
+				n += uint(f.Load())
+			}
+			c.inFlightMux.Lock()
+			c.inFlightSample.sample(n)
+			c.inFlightMux.Unlock()
+		case <-c.inFlightStop:
+			return
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// Influx is a Reporter that writes stats in InfluxDB line protocol to a file or
+// an HTTP write endpoint.
+//
+//	stats:
+//	  report:
+//	    influx:
+//	      file:          "/tmp/finch.lp"
+//	      url:           "http://localhost:8086/api/v2/write?org=o&bucket=b"
+//	      token:         ""
+//	      measurement:   "finch"
+//	      each-instance: false
+//	      each-trx:      false
+//	      percentiles:   "P999"
+//
+// If url is set, file is ignored. Like the CSV reporter, on the server stats from
+// all compute instances are combined. Tags are compute, trx (only per-trx
+// series), run_id (--run-id), and --tag tags. Fields have the same names as the
+// CSV columns. Events and markers (Instance.Events) are written as annotations:
+// measurement "<measurement>_events" with field event (and error, if any) at the
+// time of the event.
+type Influx struct {
+	file        *os.File
+	url         string
+	token       string
+	measurement string
+	sP          []string
+	p           []float64
+	each        bool
+	eachTrx     bool
+	client      *http.Client
+	buf         *bytes.Buffer
+}
+
+var _ Reporter = &Influx{}
+
+func NewInflux(opts map[string]string) (*Influx, error) {
+	sP, nP, err := ParsePercentiles(opts["percentiles"])
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Influx{
+		url:         opts["url"],
+		token:       opts["token"],
+		measurement: opts["measurement"],
+		sP:          sP,
+		p:           nP,
+		each:        finch.Bool(opts["each-instance"]),
+		eachTrx:     finch.Bool(opts["each-trx"]),
+		buf:         &bytes.Buffer{},
+	}
+	if r.measurement == "" {
+		r.measurement = "finch"
+	}
+
+	if r.url != "" {
+		if !strings.HasPrefix(r.url, "http://") && !strings.HasPrefix(r.url, "https://") {
+			return nil, fmt.Errorf("influx url '%s' is not an http:// or https:// URL", r.url)
+		}
+		r.client = &http.Client{Timeout: 5 * time.Second}
+		log.Printf("InfluxDB URL: %s\n", r.url)
+		return r, nil
+	}
+
+	fileName := opts["file"]
+	if fileName == "" {
+		// Use a random temp file
+		r.file, err = os.CreateTemp("", fmt.Sprintf("finch-benchmark-%s.lp", strings.ReplaceAll(time.Now().Format(time.Stamp), " ", "_")))
+	} else {
+		r.file, err = os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("InfluxDB line protocol file: %s\n", r.file.Name())
+	return r, nil
+}
+
+func (r *Influx) Report(from []Instance) {
+	ts := time.Now().UnixNano()
+	r.buf.Reset()
+
+	if r.each && len(from) > 1 {
+		for i := range from {
+			r.line(from[i], from[i].Total, from[i].Hostname, "", ts)
+		}
+	}
+
+	all := NewInstance("")
+	all.Combine(from)
+	compute := from[0].Hostname
+	if len(from) > 1 {
+		compute = fmt.Sprintf("%d combined", len(from))
+	}
+	r.line(all, all.Total, compute, "", ts)
+
+	if r.eachTrx {
+		for _, name := range trxNames(all.Trx) {
+			r.line(all, all.Trx[name], compute, name, ts)
+		}
+	}
+
+	for _, e := range all.Events {
+		r.event(all, e)
+	}
+
+	if r.client == nil {
+		if _, err := r.file.Write(r.buf.Bytes()); err != nil {
+			log.Printf("Error writing InfluxDB stats: %s", err)
+		}
+		return
+	}
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(r.buf.Bytes()))
+	if err != nil {
+		log.Printf("Error sending InfluxDB stats: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Token "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		log.Printf("Error sending InfluxDB stats: %s", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error sending InfluxDB stats: HTTP status %s", resp.Status)
+	}
+}
+
+// line writes one line of line protocol to the buffer:
+//
+//	finch,compute=local interval=1i,duration=20,...,errors=0i 1700000000000000000
+func (r *Influx) line(in Instance, s *Stats, compute, trx string, ts int64) {
+	b := r.buf
+	b.WriteString(influxEscape(r.measurement))
+	b.WriteString(",compute=" + influxEscape(compute))
+	if trx != "" {
+		b.WriteString(",trx=" + influxEscape(trx))
+	}
+	if in.RunId != "" {
+		b.WriteString(",run_id=" + influxEscape(in.RunId))
+	}
+	for _, k := range tagKeys(in.Tags) {
+		b.WriteString("," + influxEscape(k) + "=" + influxEscape(in.Tags[k]))
+	}
+	fmt.Fprintf(b, " interval=%di,duration=%g,runtime=%g,clients=%di", in.Interval, in.Seconds, in.Runtime, in.Clients)
+	for _, e := range []struct {
+		eventType byte
+		prefix    string
+		qps       string
+	}{
+		{TOTAL, "", "QPS"},
+		{READ, "r_", "r_QPS"},
+		{WRITE, "w_", "w_QPS"},
+		{COMMIT, "c_", "TPS"},
+	} {
+		var qps int64
+		if in.Seconds > 0 {
+			qps = int64(float64(s.N[e.eventType]) / in.Seconds)
+		}
+		fmt.Fprintf(b, ",%s=%di,%smin=%di", e.qps, qps, e.prefix, s.Min[e.eventType])
+		q := s.Percentiles(e.eventType, r.p)
+		for i := range q {
+			fmt.Fprintf(b, ",%s%s=%di", e.prefix, influxEscape(r.sP[i]), q[i])
+		}
+		fmt.Fprintf(b, ",%smax=%di", e.prefix, s.Max[e.eventType])
+	}
+	var errorCount uint64
+	for _, v := range s.Errors {
+		errorCount += v
+	}
+	fmt.Fprintf(b, ",errors=%di %d\n", errorCount, ts)
+}
+
+// event writes one event annotation to the buffer:
+//
+//	finch_events event="mark: failover started",runtime=120.1 1700000000000000000
+func (r *Influx) event(in Instance, e Event) {
+	b := r.buf
+	b.WriteString(influxEscape(r.measurement + "_events"))
+	if in.RunId != "" {
+		b.WriteString(",run_id=" + influxEscape(in.RunId))
+	}
+	for _, k := range tagKeys(in.Tags) {
+		b.WriteString("," + influxEscape(k) + "=" + influxEscape(in.Tags[k]))
+	}
+	fmt.Fprintf(b, " event=%s,runtime=%g", influxString(e.Event), e.Runtime)
+	if e.Error != "" {
+		b.WriteString(",error=" + influxString(e.Error))
+	}
+	fmt.Fprintf(b, " %d\n", e.Time.UnixNano())
+}
+
+// influxString returns a quoted string field value.
+func influxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(s) + `"`
+}
+
+// influxEscape escapes commas, spaces, and equal signs in measurement names,
+// tag keys and values, and field keys.
+func influxEscape(s string) string {
+	return influxEscaper.Replace(s)
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func (r *Influx) Stop() {
+	if r.file != nil {
+		r.file.Close()
+	}
+}
+
+func (r *Influx) File() string {
+	if r.file == nil {
+		return ""
+	}
+	return r.file.Name()
+}
@@ -0,0 +1,283 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/square/finch"
+)
+
+// JSON is a Reporter that writes stats to a file as JSON lines: one JSON object
+// per line for the combined stats each interval.
+//
+//	stats:
+//	  report:
+//	    json:
+//	      file:          "/tmp/finch.json"
+//	      each-instance: false
+//	      each-trx:      false
+//	      percentiles:   "P999"
+//
+// Like the CSV reporter, on the server stats from all compute instances are
+// combined. If each-instance is true, there's also one object per instance.
+type JSON struct {
+	file    *os.File
+	enc     *json.Encoder
+	sP      []string
+	p       []float64
+	each    bool
+	eachTrx bool
+}
+
+var (
+	_ Reporter          = &JSON{}
+	_ ExecGroupReporter = &JSON{}
+)
+
+// JSONStats are the stats for one event type (read, write, commit, total, or
+// trx file).
+type JSONStats struct {
+	QPS         int64             `json:"QPS"`
+	N           uint64            `json:"n"`
+	Min         int64             `json:"min"`
+	Percentiles map[string]uint64 `json:"percentiles"`
+	Max         int64             `json:"max"`
+}
+
+// JSONLine is one line (object) written by the JSON reporter.
+type JSONLine struct {
+	Interval      uint       `json:"interval"`
+	Duration      float64    `json:"duration"`
+	Runtime       float64    `json:"runtime"`
+	Start         string     `json:"start,omitempty"` // RFC3339
+	End           string     `json:"end,omitempty"`   // RFC3339
+	Clients       uint       `json:"clients"`
+	Compute       string     `json:"compute"`
+	Trx           string     `json:"trx,omitempty"`
+	ExecGroup     string     `json:"exec_group,omitempty"`
+	Total         JSONStats  `json:"total"`
+	Read          JSONStats  `json:"read"`
+	Write         JSONStats  `json:"write"`
+	Commit        JSONStats  `json:"commit"`
+	Errors        uint64     `json:"errors"`
+	Stale         *JSONStale `json:"stale,omitempty"`
+	StaleTimeouts uint64     `json:"stale_timeouts,omitempty"`
+	Queue         *JSONStale `json:"queue,omitempty"`
+
+	// Trx file response time (TRX_FILE), if any trx file finished
+	TrxFile *JSONStats `json:"trx_file,omitempty"`
+
+	// Queries within each SLO threshold (config.stats.slo), like "<=5ms", and
+	// greater than the last threshold, like ">20ms"
+	SLO map[string]JSONSLO `json:"slo,omitempty"`
+
+	// Clients executing a query (sampled)
+	InFlight *InFlight `json:"in_flight,omitempty"`
+
+	// QPS and TPS limits of clients, if rate limited, and TPS allowances
+	// refunded (config.stage.workload.tps-refund)
+	Target  *Target `json:"target,omitempty"`
+	Refunds uint64  `json:"refunds,omitempty"`
+
+	// Subset of Errors: lock contention
+	Deadlocks        uint64 `json:"deadlocks,omitempty"`
+	LockWaitTimeouts uint64 `json:"lock_wait_timeouts,omitempty"`
+
+	// Not included in Errors
+	Timeouts      uint64 `json:"timeouts,omitempty"`
+	MySQLWarnings uint64 `json:"mysql_warnings,omitempty"`
+
+	RowsRead     uint64 `json:"rows_read,omitempty"`
+	RowsAffected uint64 `json:"rows_affected,omitempty"`
+
+	// --run-id and --tag
+	RunId string            `json:"run_id,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+
+	// Load generator warnings, like saturation
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Scheduled events (config.stage.events) that ran in the interval
+	Events []Event `json:"events,omitempty"`
+}
+
+// JSONSLO is the number and percentage of queries for one SLO threshold.
+type JSONSLO struct {
+	N   uint64  `json:"n"`
+	Pct float64 `json:"pct"`
+}
+
+// JSONStale are read-your-writes violations (-- verify): count, and average and
+// max time waiting for the row to be visible (μs). It's also used for open-loop
+// queueing delay: count, and average and max time waiting for a free client.
+type JSONStale struct {
+	N   uint64 `json:"n"`
+	Avg int64  `json:"avg"`
+	Max int64  `json:"max"`
+}
+
+func NewJSON(opts map[string]string) (*JSON, error) {
+	var f *os.File
+	var err error
+	fileName := opts["file"]
+	if fileName == "" {
+		// Use a random temp file
+		f, err = os.CreateTemp("", fmt.Sprintf("finch-benchmark-%s.json", strings.ReplaceAll(time.Now().Format(time.Stamp), " ", "_")))
+	} else {
+		f, err = os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("JSON file: %s\n", f.Name())
+
+	sP, nP, err := ParsePercentiles(opts["percentiles"])
+	if err != nil {
+		return nil, err
+	}
+
+	r := &JSON{
+		file:    f,
+		enc:     json.NewEncoder(f),
+		sP:      sP,
+		p:       nP,
+		each:    finch.Bool(opts["each-instance"]),
+		eachTrx: finch.Bool(opts["each-trx"]),
+	}
+	return r, nil
+}
+
+func (r *JSON) Report(from []Instance) {
+	if r.each && len(from) > 1 {
+		for i := range from {
+			r.write(from[i], from[i].Total, from[i].Hostname, "")
+		}
+	}
+
+	all := NewInstance("")
+	all.Combine(from)
+	compute := from[0].Hostname
+	if len(from) > 1 {
+		compute = fmt.Sprintf("%d combined", len(from))
+	}
+	r.write(all, all.Total, compute, "")
+
+	if !r.eachTrx {
+		return
+	}
+	for _, name := range trxNames(all.Trx) {
+		r.write(all, all.Trx[name], compute, name)
+	}
+}
+
+// ReportExecGroup writes the exec group summary with compute "exec group NAME".
+func (r *JSON) ReportExecGroup(sum Instance) {
+	r.write(sum, sum.Total, sum.Hostname, "")
+	if !r.eachTrx {
+		return
+	}
+	for _, name := range trxNames(sum.Trx) {
+		r.write(sum, sum.Trx[name], sum.Hostname, name)
+	}
+}
+
+func (r *JSON) write(in Instance, s *Stats, compute, trx string) {
+	line := JSONLine{
+		Interval:  in.Interval,
+		Duration:  in.Seconds,
+		Runtime:   in.Runtime,
+		Start:     Timestamp(in.Start),
+		End:       Timestamp(in.End),
+		Clients:   in.Clients,
+		Compute:   compute,
+		Trx:       trx,
+		ExecGroup: in.ExecGroup,
+		Total:     r.stats(s, TOTAL, in.Seconds),
+		Read:      r.stats(s, READ, in.Seconds),
+		Write:     r.stats(s, WRITE, in.Seconds),
+		Commit:    r.stats(s, COMMIT, in.Seconds),
+		RunId:     in.RunId,
+		Tags:      in.Tags,
+		Warnings:  in.Warnings,
+		Events:    in.Events,
+	}
+	for _, v := range s.Errors {
+		line.Errors += v
+	}
+	line.Deadlocks = s.Errors[ER_LOCK_DEADLOCK]
+	line.LockWaitTimeouts = s.Errors[ER_LOCK_WAIT_TIMEOUT]
+	line.Timeouts = s.Timeouts
+	line.MySQLWarnings = s.MySQLWarnings
+	line.Refunds = s.Refunds
+	line.RowsRead = s.RowsRead
+	line.RowsAffected = s.RowsAffected
+	line.StaleTimeouts = s.StaleTimeouts
+	if s.Stale > 0 {
+		line.Stale = &JSONStale{
+			N:   s.Stale,
+			Avg: s.StaleTime / int64(s.Stale),
+			Max: s.StaleMax,
+		}
+	}
+	if s.Queued > 0 {
+		line.Queue = &JSONStale{
+			N:   s.Queued,
+			Avg: s.QueueTime / int64(s.Queued),
+			Max: s.QueueMax,
+		}
+	}
+	if s.N[TRX_FILE] > 0 {
+		js := r.stats(s, TRX_FILE, in.Seconds)
+		line.TrxFile = &js
+	}
+	if in.InFlight.Samples > 0 {
+		f := in.InFlight
+		line.InFlight = &f
+	}
+	if in.Target.QPS > 0 || in.Target.TPS > 0 {
+		t := in.Target
+		line.Target = &t
+	}
+	if n, timed := sloCounts(s, in.SLO); len(in.SLO) > 0 && timed > 0 {
+		line.SLO = make(map[string]JSONSLO, len(n))
+		for i := range in.SLO {
+			line.SLO["<="+in.SLO[i].Name] = JSONSLO{N: n[i], Pct: float64(n[i]) / float64(timed) * 100}
+		}
+		last := len(in.SLO)
+		line.SLO[">"+in.SLO[last-1].Name] = JSONSLO{N: n[last], Pct: float64(n[last]) / float64(timed) * 100}
+	}
+	if err := r.enc.Encode(line); err != nil {
+		log.Printf("Error writing JSON stats: %s", err)
+	}
+}
+
+func (r *JSON) stats(s *Stats, eventType byte, seconds float64) JSONStats {
+	js := JSONStats{
+		N:           s.N[eventType],
+		Min:         s.Min[eventType],
+		Max:         s.Max[eventType],
+		Percentiles: make(map[string]uint64, len(r.p)),
+	}
+	if seconds > 0 {
+		js.QPS = int64(float64(s.N[eventType]) / seconds)
+	}
+	q := s.Percentiles(eventType, r.p)
+	for i := range q {
+		js.Percentiles[r.sP[i]] = q[i]
+	}
+	return js
+}
+
+func (r *JSON) Stop() {
+	r.file.Close()
+}
+
+func (r *JSON) File() string {
+	return r.file.Name()
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/rs/xid"
+
+	"github.com/square/finch"
+)
+
+// DefaultBenchmarkId is the benchmark ID for the MySQL reporter if neither
+// benchmark-id nor --run-id is set. It's unique per run, so all stages in a run
+// have the same ID.
+var DefaultBenchmarkId = xid.New().String()
+
+// MySQL is a Reporter that inserts stats into a MySQL table: one row per interval
+// and, when stopped, one summary row (interval = 0) for the whole stage. The
+// results database is separate from the benchmark target, so results can be
+// queried across benchmark runs.
+//
+//	stats:
+//	  report:
+//	    mysql:
+//	      dsn:           "user:pass@tcp(results:3306)/finch"
+//	      table:         "results"
+//	      benchmark-id:  ""
+//	      each-instance: false
+//	      each-trx:      false
+//	      percentiles:   "P999"
+//
+// The table is created if it doesn't exist. The stage option is set by the
+// compute server (compute/server.go). If benchmark-id is not set, it defaults to
+// --run-id, if set. Tags (--tag) are stored as a JSON object in the tags column.
+// Events and markers (Instance.Events) are not stored: every row is an interval.
+type MySQL struct {
+	db          *sql.DB
+	table       string
+	benchmarkId string
+	stage       string
+	sP          []string
+	p           []float64
+	each        bool
+	eachTrx     bool
+	all         Instance // all intervals for the summary
+	reported    bool
+}
+
+var _ Reporter = &MySQL{}
+
+var reTableName = regexp.MustCompile(`^\w+(\.\w+)?$`)
+
+func NewMySQL(opts map[string]string) (*MySQL, error) {
+	if opts["dsn"] == "" {
+		return nil, fmt.Errorf("mysql reporter requires dsn")
+	}
+	table := opts["table"]
+	if table == "" {
+		table = "results"
+	}
+	if !reTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid mysql reporter table: %s: must be TABLE or DB.TABLE", table)
+	}
+	sP, nP, err := ParsePercentiles(opts["percentiles"])
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MySQL{
+		table:       table,
+		benchmarkId: opts["benchmark-id"],
+		stage:       opts["stage"],
+		sP:          sP,
+		p:           nP,
+		each:        finch.Bool(opts["each-instance"]),
+		eachTrx:     finch.Bool(opts["each-trx"]),
+		all:         NewInstance(""),
+	}
+	if r.benchmarkId == "" {
+		r.benchmarkId = RunId
+	}
+	if r.benchmarkId == "" {
+		r.benchmarkId = DefaultBenchmarkId
+	}
+
+	r.db, err = sql.Open("mysql", opts["dsn"])
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.db.ExecContext(ctx, r.createTable()); err != nil {
+		r.db.Close()
+		return nil, fmt.Errorf("mysql reporter: creating table %s: %s", table, err)
+	}
+	log.Printf("MySQL stats table %s: benchmark_id %s\n", table, r.benchmarkId)
+	return r, nil
+}
+
+func (r *MySQL) createTable() string {
+	return "CREATE TABLE IF NOT EXISTS " + r.table + " (" +
+		"id bigint unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY," +
+		"benchmark_id varchar(64) NOT NULL," +
+		"stage varchar(255) NOT NULL," +
+		"ts timestamp(6) NOT NULL," +
+		"`interval` int unsigned NOT NULL," + // 0 = summary
+		"duration double NOT NULL," +
+		"runtime double NOT NULL," +
+		"clients int unsigned NOT NULL," +
+		"compute varchar(255) NOT NULL," +
+		"trx varchar(255) NOT NULL DEFAULT ''," +
+		"qps bigint NOT NULL, min bigint NOT NULL, max bigint NOT NULL," +
+		"r_qps bigint NOT NULL, r_min bigint NOT NULL, r_max bigint NOT NULL," +
+		"w_qps bigint NOT NULL, w_min bigint NOT NULL, w_max bigint NOT NULL," +
+		"tps bigint NOT NULL, c_min bigint NOT NULL, c_max bigint NOT NULL," +
+		"percentiles json NOT NULL," +
+		"errors bigint unsigned NOT NULL," +
+		"tags json," +
+		"KEY (benchmark_id, stage, `interval`)" +
+		")"
+}
+
+func (r *MySQL) Report(from []Instance) {
+	r.reported = true
+	if r.each && len(from) > 1 {
+		for i := range from {
+			r.insert(from[i], from[i].Total, from[i].Hostname, "")
+		}
+	}
+
+	all := NewInstance("")
+	all.Combine(from)
+	compute := from[0].Hostname
+	if len(from) > 1 {
+		compute = fmt.Sprintf("%d combined", len(from))
+	}
+	r.insert(all, all.Total, compute, "")
+	if r.eachTrx {
+		for _, name := range trxNames(all.Trx) {
+			r.insert(all, all.Trx[name], compute, name)
+		}
+	}
+
+	// Accumulate for summary
+	r.all.Clients = all.Clients
+	r.all.Seconds += all.Seconds
+	r.all.Runtime = all.Runtime
+	r.all.Total.Combine(all.Total)
+	for name, s := range all.Trx {
+		if _, ok := r.all.Trx[name]; !ok {
+			r.all.Trx[name] = NewStats()
+		}
+		r.all.Trx[name].Combine(s)
+	}
+}
+
+func (r *MySQL) insert(in Instance, s *Stats, compute, trx string) {
+	p := map[string]uint64{}
+	cols := []interface{}{}
+	for _, e := range []struct {
+		eventType byte
+		prefix    string
+	}{
+		{TOTAL, ""}, {READ, "r_"}, {WRITE, "w_"}, {COMMIT, "c_"},
+	} {
+		var qps int64
+		if in.Seconds > 0 {
+			qps = int64(float64(s.N[e.eventType]) / in.Seconds)
+		}
+		cols = append(cols, qps, s.Min[e.eventType], s.Max[e.eventType])
+		q := s.Percentiles(e.eventType, r.p)
+		for i := range q {
+			p[e.prefix+r.sP[i]] = q[i]
+		}
+	}
+	pJSON, _ := json.Marshal(p)
+	var errorCount uint64
+	for _, v := range s.Errors {
+		errorCount += v
+	}
+	var tags interface{} // NULL
+	if len(in.Tags) > 0 {
+		tJSON, _ := json.Marshal(in.Tags)
+		tags = string(tJSON)
+	}
+
+	q := "INSERT INTO " + r.table + " (benchmark_id, stage, ts, `interval`, duration, runtime, clients, compute, trx," +
+		" qps, min, max, r_qps, r_min, r_max, w_qps, w_min, w_max, tps, c_min, c_max, percentiles, errors, tags)" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	args := append([]interface{}{r.benchmarkId, r.stage, time.Now(), in.Interval, in.Seconds, in.Runtime, in.Clients, compute, trx}, cols...)
+	args = append(args, string(pJSON), errorCount, tags)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.db.ExecContext(ctx, q, args...); err != nil {
+		log.Printf("Error inserting MySQL stats: %s", err)
+	}
+}
+
+// Stop inserts the summary row (interval = 0) and closes the connection.
+func (r *MySQL) Stop() {
+	if r.reported {
+		r.insert(r.all, r.all.Total, "summary", "")
+		if r.eachTrx {
+			for _, name := range trxNames(r.all.Trx) {
+				r.insert(r.all, r.all.Trx[name], "summary", name)
+			}
+		}
+	}
+	r.db.Close()
+}
@@ -4,8 +4,13 @@ package stats
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/square/finch"
@@ -15,24 +20,48 @@ import (
 // Server is a Reporter that sends stats to a remote compute instance (--server).
 // When running as a client, Finch uses and configures this reporter automatically
 // in compute/Remote.Boot.
+//
+// If the server is not reachable, stats are buffered (spooled) to disk and sent
+// when the server is reachable again (the remote rejoins the stage). The spool
+// is bounded by config.stats.spool intervals; when full, newer intervals are
+// dropped. Intervals are numbered, so the server ignores any that are sent twice.
 type Server struct {
-	server    string // for logging
-	client    *proto.Client
-	statsChan chan Instance
-	stopChan  chan struct{}
-	doneChan  chan struct{}
+	server   string // for logging
+	client   *proto.Client
+	queue    *statsQueue
+	stopChan chan struct{}
+	doneChan chan struct{}
+	buf      *diskBuffer
 }
 
 var _ Reporter = Server{}
 
+// DefaultSpool is the default max number of intervals that Server buffers to
+// disk while the server is not reachable: 10 minutes at the default 1s freq.
+const DefaultSpool = 600
+
 func NewServer(opts map[string]string) (Server, error) {
+	max := DefaultSpool
+	if opts["spool"] != "" {
+		n, err := strconv.Atoi(opts["spool"])
+		if err != nil || n < 0 {
+			return Server{}, fmt.Errorf("invalid spool: %s: must be an integer >= 0", opts["spool"])
+		}
+		if n > 0 {
+			max = n
+		}
+	}
 	r := Server{
-		server:    opts["server"], // for logging
-		client:    proto.NewClient(opts["client"], opts["server"]),
-		statsChan: make(chan Instance, 5),
-
+		server: opts["server"], // for logging
+		client: proto.NewClient(opts["client"], opts["server"]),
+		queue: &statsQueue{
+			mu:    &sync.Mutex{},
+			max:   max,
+			ready: make(chan struct{}, 1),
+		},
 		stopChan: make(chan struct{}),
 		doneChan: make(chan struct{}),
+		buf:      &diskBuffer{max: max},
 	}
 	r.client.StageId = opts["stage-id"] // from compute/client.run
 	go r.report()
@@ -45,37 +74,239 @@ func (r Server) Report(from []Instance) {
 	}
 
 	// The Collector calls this func at the configured frequency
-	// (config.stats.freq), and then we queue the stats via a channel
-	// to the report() goroutine that sends them. Async sending with
-	// the chan/goroutine allows us to handle intermittent network issues,
-	// i.e. don't block in this func, else it'll block Collector and
-	// mess up the timing of collecting the stats.
-	select {
-	case r.statsChan <- from[0]:
-	default:
-		log.Printf("Stats dropped because remote is not responding: %+v", from[0])
-	}
+	// (config.stats.freq), and then we queue the stats to the report()
+	// goroutine that sends them. Async sending with the queue/goroutine
+	// allows us to handle intermittent network issues, i.e. don't block
+	// in this func, else it'll block Collector and mess up the timing of
+	// collecting the stats. The queue holds up to config.stats.spool
+	// intervals because report() can take a few seconds per loop while
+	// the server is not reachable.
+	r.queue.add(from[0])
 }
 
 func (r Server) Stop() {
 	finch.Debug("stopping")
-	close(r.statsChan)
+	r.queue.close()
 	select {
 	case <-r.doneChan:
 		finch.Debug("remote stats done")
 	case <-time.After(5 * time.Second):
+		// Stop flushing the spool so report() logs how many intervals are lost
 		log.Println("Timeout sending last stats")
+		close(r.stopChan)
+		<-r.doneChan
 	}
 }
 
 func (r Server) report() {
 	defer close(r.doneChan)
-	for s := range r.statsChan {
-		err := r.client.Send(context.Background(), "/stats", s, proto.R{300 * time.Millisecond, 10 * time.Millisecond, 3})
-		if err != nil {
-			log.Printf("Failed to send stats: %s\n%+v\n", err, s)
+	defer r.buf.close()
+	for {
+		all, dropped, more := r.queue.take()
+		r.buf.dropped += dropped
+		for i := range all {
+			// Send buffered stats first, in order. If that fails, the server is
+			// still not reachable, so buffer these stats, too.
+			if r.buf.n > 0 && !r.flush() {
+				r.spill(all[i:])
+				break
+			}
+			if err := r.send(all[i]); err != nil {
+				log.Printf("Failed to send stats, buffering to disk until server is reachable: %s", err)
+				r.spill(all[i:])
+				break
+			}
+			finch.Debug("sent stats to %s", r.server)
+		}
+		if !more {
+			break
+		}
+	}
+	if r.buf.n > 0 && !r.flush() {
+		log.Printf("Lost %d intervals of stats buffered in %s because server is not reachable", r.buf.n, r.buf.file.Name())
+	}
+	if r.buf.dropped > 0 {
+		log.Printf("Lost %d intervals of stats dropped because spool was full", r.buf.dropped)
+	}
+}
+
+func (r Server) send(s Instance) error {
+	return r.client.Send(context.Background(), "/stats", s, proto.R{300 * time.Millisecond, 10 * time.Millisecond, 3})
+}
+
+// spill buffers stats to disk, in order.
+func (r Server) spill(all []Instance) {
+	for _, s := range all {
+		if r.buf.n >= r.buf.max {
+			if r.buf.dropped == 0 {
+				log.Printf("Stats spool full (%d intervals), dropping stats until server is reachable", r.buf.max)
+			}
+			r.buf.dropped++
 			continue
 		}
-		finch.Debug("sent stats to %s", r.server)
+		if err := r.buf.write(s); err != nil {
+			log.Printf("Stats dropped because writing to disk buffer failed: %s: %+v", err, s)
+		}
+	}
+}
+
+// flush sends all buffered stats. It returns false if the server is still not
+// reachable or Stop timed out, in which case stats not sent remain buffered.
+func (r Server) flush() bool {
+	all, err := r.buf.read()
+	if err != nil {
+		log.Printf("Error reading stats disk buffer, dropping %d intervals: %s", r.buf.n, err)
+		r.buf.reset()
+		return true
+	}
+	for i := range all {
+		err := r.stopped()
+		if err == nil {
+			err = r.send(all[i])
+		}
+		if err != nil {
+			finch.Debug("flush: %s", err)
+			// Keep what wasn't sent, in order
+			r.buf.reset()
+			r.spill(all[i:])
+			return false
+		}
+	}
+	log.Printf("Sent %d intervals of stats buffered while server was not reachable", len(all))
+	if r.buf.dropped > 0 {
+		log.Printf("Dropped %d intervals of stats because spool was full", r.buf.dropped)
+		r.buf.dropped = 0
+	}
+	r.buf.reset()
+	return true
+}
+
+// stopped returns an error if Stop timed out waiting for report() to finish.
+func (r Server) stopped() error {
+	select {
+	case <-r.stopChan:
+		return fmt.Errorf("stopped")
+	default:
+		return nil
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// statsQueue queues stats from Report to the Server.report goroutine. It's
+// bounded by max intervals, like the spool, so stats aren't dropped while the
+// spool has room, but memory is bounded if report() can't keep up.
+type statsQueue struct {
+	mu      *sync.Mutex
+	all     []Instance
+	max     int           // max len(all) (config.stats.spool)
+	dropped int           // intervals dropped because len(all) == max
+	closed  bool          // Stop called
+	ready   chan struct{} // signals report() that stats were added or closed
+}
+
+func (q *statsQueue) add(s Instance) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	if len(q.all) >= q.max {
+		if q.dropped == 0 {
+			log.Printf("Stats queue full (%d intervals), dropping stats until server is reachable", q.max)
+		}
+		q.dropped++
+	} else {
+		q.all = append(q.all, s)
+	}
+	q.signal()
+}
+
+func (q *statsQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.signal()
+}
+
+// signal wakes take without blocking. The caller must hold q.mu.
+func (q *statsQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// take waits for stats, then returns all queued stats and the number dropped
+// since the last call. It returns more=false after close, when the returned
+// stats are the last.
+func (q *statsQueue) take() (all []Instance, dropped int, more bool) {
+	<-q.ready
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	all, dropped = q.all, q.dropped
+	q.all, q.dropped = nil, 0
+	return all, dropped, !q.closed
+}
+
+// --------------------------------------------------------------------------
+
+// diskBuffer buffers stats as JSON lines in a temp file. It's used only by
+// the Server.report goroutine, so it's not safe for concurrent use.
+type diskBuffer struct {
+	file    *os.File
+	n       int // number of stats (intervals) in file
+	max     int // max n (config.stats.spool)
+	dropped int // intervals dropped because n == max
+}
+
+func (b *diskBuffer) write(s Instance) error {
+	if b.file == nil {
+		f, err := os.CreateTemp("", "finch-stats-*.json")
+		if err != nil {
+			return err
+		}
+		b.file = f
+		finch.Debug("stats disk buffer: %s", f.Name())
+	}
+	if err := json.NewEncoder(b.file).Encode(s); err != nil {
+		return err
+	}
+	b.n++
+	return nil
+}
+
+func (b *diskBuffer) read() ([]Instance, error) {
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	all := make([]Instance, 0, b.n)
+	dec := json.NewDecoder(b.file)
+	for dec.More() {
+		var s Instance
+		if err := dec.Decode(&s); err != nil {
+			return nil, err
+		}
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+func (b *diskBuffer) reset() {
+	b.n = 0
+	if b.file == nil {
+		return
+	}
+	b.file.Truncate(0)
+	b.file.Seek(0, io.SeekStart)
+}
+
+func (b *diskBuffer) close() {
+	if b.file == nil {
+		return
+	}
+	b.file.Close()
+	if b.n == 0 {
+		os.Remove(b.file.Name())
 	}
 }
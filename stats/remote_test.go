@@ -0,0 +1,172 @@
+// Copyright 2024 Block, Inc.
+
+package stats_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/stats"
+)
+
+func TestServer_BufferStats(t *testing.T) {
+	// Fake server that's not reachable (500) until up=true
+	var mux sync.Mutex
+	up := false
+	var got []uint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if !up {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var in stats.Instance
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		got = append(got, in.Interval)
+	}))
+	defer srv.Close()
+
+	r, err := stats.NewServer(map[string]string{
+		"server":   srv.URL,
+		"client":   "test",
+		"stage-id": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := stats.NewInstance("test")
+	in.Interval = 1
+	r.Report([]stats.Instance{in})
+	time.Sleep(500 * time.Millisecond) // wait for send to fail and buffer to disk
+
+	mux.Lock()
+	up = true
+	mux.Unlock()
+
+	in.Interval = 2
+	r.Report([]stats.Instance{in})
+	r.Stop()
+
+	// Buffered interval 1 is sent first, then 2
+	mux.Lock()
+	defer mux.Unlock()
+	if diff := deep.Equal(got, []uint{1, 2}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServer_Spool(t *testing.T) {
+	// Fake server that's not reachable (500) until up=true
+	var mux sync.Mutex
+	up := false
+	var got []uint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if !up {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var in stats.Instance
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		got = append(got, in.Interval)
+	}))
+	defer srv.Close()
+
+	r, err := stats.NewServer(map[string]string{
+		"server":   srv.URL,
+		"client":   "test",
+		"stage-id": "1",
+		"spool":    "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spool holds only 1 interval, so interval 2 is dropped
+	in := stats.NewInstance("test")
+	in.Interval = 1
+	r.Report([]stats.Instance{in})
+	time.Sleep(500 * time.Millisecond) // wait for send to fail and buffer to disk
+	in.Interval = 2
+	r.Report([]stats.Instance{in})
+	time.Sleep(500 * time.Millisecond)
+
+	mux.Lock()
+	up = true
+	mux.Unlock()
+
+	in.Interval = 3
+	r.Report([]stats.Instance{in})
+	r.Stop()
+
+	mux.Lock()
+	defer mux.Unlock()
+	if diff := deep.Equal(got, []uint{1, 3}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServer_NoHoles(t *testing.T) {
+	// Fake server that's not reachable (500) until up=true
+	var mux sync.Mutex
+	up := false
+	var got []uint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if !up {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var in stats.Instance
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		got = append(got, in.Interval)
+	}))
+	defer srv.Close()
+
+	r, err := stats.NewServer(map[string]string{
+		"server":   srv.URL,
+		"client":   "test",
+		"stage-id": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Report intervals faster than report() can try to send them while the
+	// server is down. None are dropped because the spool has room.
+	in := stats.NewInstance("test")
+	expect := []uint{}
+	for i := uint(1); i <= 20; i++ {
+		in.Interval = i
+		r.Report([]stats.Instance{in})
+		expect = append(expect, i)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	mux.Lock()
+	up = true
+	mux.Unlock()
+	r.Stop()
+
+	mux.Lock()
+	defer mux.Unlock()
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
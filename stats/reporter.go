@@ -4,14 +4,17 @@ package stats
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	h "github.com/dustin/go-humanize"
 
 	"github.com/square/finch"
 	"github.com/square/finch/config"
+	"github.com/square/finch/limit"
 )
 
 var Header = "interval,duration,runtime,clients,QPS,min,%s,max,r_QPS,r_min,%s,r_max,w_QPS,w_min,%s,w_max,TPS,c_min,%s,c_max,errors,compute"
@@ -25,6 +28,14 @@ type Reporter interface {
 	Stop()
 }
 
+// ExecGroupReporter is an optional Reporter interface to report the summary of
+// an execution group (all intervals in which it ran) when it finishes, if the
+// stage has more than one execution group. Intervals in which more than one
+// exec group ran are in the summary of each.
+type ExecGroupReporter interface {
+	ReportExecGroup(summary Instance)
+}
+
 type ReporterFactory interface {
 	Make(name string, opts map[string]string) (Reporter, error)
 }
@@ -64,6 +75,10 @@ func init() {
 	Register("stdout", f)
 	Register("server", f)
 	Register("csv", f)
+	Register("json", f)
+	Register("influx", f)
+	Register("mysql", f)
+	Register("hlog", f)
 }
 
 type repo struct {
@@ -88,6 +103,14 @@ func (f factory) Make(name string, opts map[string]string) (Reporter, error) {
 		return NewServer(opts)
 	case "csv":
 		return NewCSV(opts)
+	case "json":
+		return NewJSON(opts)
+	case "influx":
+		return NewInflux(opts)
+	case "mysql":
+		return NewMySQL(opts)
+	case "hlog":
+		return NewHLog(opts)
 	}
 	return nil, fmt.Errorf("reporter %s not registered", name)
 }
@@ -119,6 +142,247 @@ func ParsePercentiles(pCSV string) ([]string, []float64, error) {
 	return s, p, nil
 }
 
+// ProgressString returns limit progress as a human-readable string like
+// "rows: 500,000 / 1,000,000 = 50.0%: 10,000 rows/s (ETA 50s) (local)", or
+// "runtime: 30s / 1m0s = 50.0% (ETA 30s) (local)" for the stage runtime.
+func ProgressString(p limit.Progress, hostname string) string {
+	eta := "unknown"
+	if p.ETA > 0 {
+		eta = time.Duration(p.ETA * float64(time.Second)).Round(time.Second).String()
+	}
+	var n, max, rate string
+	switch p.Unit {
+	case "bytes":
+		n, max, rate = h.Bytes(p.N), h.Bytes(p.Max), h.Bytes(uint64(p.Rate))+"/s"
+	case "seconds":
+		n = (time.Duration(p.N) * time.Second).String()
+		max = (time.Duration(p.Max) * time.Second).String()
+		return fmt.Sprintf("%s: %s / %s = %.1f%% (ETA %s) (%s)", p.Limit, n, max, p.Percent, eta, hostname)
+	default:
+		n, max, rate = h.Comma(int64(p.N)), h.Comma(int64(p.Max)), h.Comma(int64(p.Rate))+" "+p.Unit+"/s"
+	}
+	return fmt.Sprintf("%s: %s / %s = %.1f%%: %s (ETA %s) (%s)", p.Limit, n, max, p.Percent, rate, eta, hostname)
+}
+
+// WarningString returns a load generator warning (Instance.Warnings) as a
+// prominent line like "*** WARNING: Finch might be saturated: ... (local)".
+func WarningString(w, hostname string) string {
+	return fmt.Sprintf("*** WARNING: %s (%s)", w, hostname)
+}
+
+// EventString returns a scheduled event (Instance.Events) as a line like
+// "--- EVENT at 120.0s (2024-...): events[0]: sql STOP REPLICA".
+func EventString(e Event) string {
+	line := fmt.Sprintf("--- EVENT at %.1fs (%s): %s", e.Runtime, e.Time.Format(time.RFC3339), e.Event)
+	if e.Error != "" {
+		line += ": error: " + e.Error
+	}
+	return line
+}
+
+// StaleString returns a line about read-your-writes violations (-- verify), or
+// "" if there weren't any.
+func StaleString(s *Stats, hostname string) string {
+	if s.Stale == 0 && s.StaleTimeouts == 0 {
+		return ""
+	}
+	if s.Stale == 0 {
+		return fmt.Sprintf("stale reads: 0, %s not visible before timeout (%s)", h.Comma(int64(s.StaleTimeouts)), hostname)
+	}
+	line := fmt.Sprintf("stale reads: %s, avg wait %s us, max wait %s us",
+		h.Comma(int64(s.Stale)), h.Comma(s.StaleTime/int64(s.Stale)), h.Comma(s.StaleMax))
+	if s.StaleTimeouts > 0 {
+		line += fmt.Sprintf(", %s not visible before timeout", h.Comma(int64(s.StaleTimeouts)))
+	}
+	return line + " (" + hostname + ")"
+}
+
+// QueueString returns a line about open-loop queueing delay (arrival-rate), or ""
+// if there weren't any arrivals.
+func QueueString(s *Stats, hostname string) string {
+	if s.Queued == 0 {
+		return ""
+	}
+	return fmt.Sprintf("queue: %s arrivals, avg wait %s us, max wait %s us (%s)",
+		h.Comma(int64(s.Queued)), h.Comma(s.QueueTime/int64(s.Queued)), h.Comma(s.QueueMax), hostname)
+}
+
+// MySQL error codes for lock contention, counted in Stats.Errors, and server-side
+// statement timeout (MAX_EXECUTION_TIME), counted in Stats.Timeouts.
+const (
+	ER_LOCK_WAIT_TIMEOUT = 1205
+	ER_LOCK_DEADLOCK     = 1213
+	ER_QUERY_TIMEOUT     = 3024
+)
+
+// LockString returns a line about lock contention errors (deadlocks and lock
+// wait timeouts), or "" if there weren't any.
+func LockString(s *Stats, hostname string) string {
+	deadlocks := s.Errors[ER_LOCK_DEADLOCK]
+	timeouts := s.Errors[ER_LOCK_WAIT_TIMEOUT]
+	if deadlocks == 0 && timeouts == 0 {
+		return ""
+	}
+	return fmt.Sprintf("lock errors: %s deadlocks, %s lock wait timeouts (%s)",
+		h.Comma(int64(deadlocks)), h.Comma(int64(timeouts)), hostname)
+}
+
+// TimeoutString returns a line about statement timeouts (-- timeout), or "" if
+// there weren't any.
+func TimeoutString(s *Stats, hostname string) string {
+	if s.Timeouts == 0 {
+		return ""
+	}
+	return fmt.Sprintf("timeouts: %s (%s)", h.Comma(int64(s.Timeouts)), hostname)
+}
+
+// MySQLWarningsString returns a line about MySQL warnings (-- warnings), or ""
+// if there weren't any.
+func MySQLWarningsString(s *Stats, hostname string) string {
+	if s.MySQLWarnings == 0 {
+		return ""
+	}
+	return fmt.Sprintf("mysql warnings: %s (%s)", h.Comma(int64(s.MySQLWarnings)), hostname)
+}
+
+// RowsString returns a line about rows read and affected in the interval of
+// the given seconds, or "" if there weren't any.
+func RowsString(s *Stats, seconds float64, hostname string) string {
+	if s.RowsRead == 0 && s.RowsAffected == 0 {
+		return ""
+	}
+	return fmt.Sprintf("rows: %s read (%s/s), %s affected (%s/s) (%s)",
+		h.Comma(int64(s.RowsRead)), h.Comma(int64(float64(s.RowsRead)/seconds)),
+		h.Comma(int64(s.RowsAffected)), h.Comma(int64(float64(s.RowsAffected)/seconds)),
+		hostname)
+}
+
+// TrxFileString returns a line about trx file response time (TRX_FILE) with
+// percentiles sP (p), or "" if no trx file finished.
+func TrxFileString(s *Stats, sP []string, p []float64, seconds float64, name string) string {
+	if s.N[TRX_FILE] == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("trx file: %s (%s/s)", h.Comma(int64(s.N[TRX_FILE])), h.Comma(int64(float64(s.N[TRX_FILE])/seconds)))
+	if s.timed(TRX_FILE) > 0 {
+		line += ", min " + h.Comma(s.Min[TRX_FILE])
+		q := s.Percentiles(TRX_FILE, p)
+		for i := range q {
+			line += fmt.Sprintf(", %s %s", sP[i], h.Comma(int64(q[i])))
+		}
+		line += ", max " + h.Comma(s.Max[TRX_FILE])
+	}
+	return line + " (" + name + ")"
+}
+
+// sloCounts returns the number of queries (TOTAL) within each SLO threshold
+// (cumulative), plus the number greater than the last threshold, and the total
+// number of timed queries.
+func sloCounts(s *Stats, slo []SLO) ([]uint64, uint64) {
+	timed := s.timed(TOTAL)
+	n := make([]uint64, len(slo)+1)
+	for i := range slo {
+		n[i] = s.Within(TOTAL, slo[i].Max)
+	}
+	if len(slo) > 0 {
+		n[len(slo)] = timed - n[len(slo)-1]
+	}
+	return n, timed
+}
+
+// SLOString returns a line with the number and percentage of queries within each
+// SLO threshold (config.stats.slo) and greater than the last threshold, or "" if
+// there are no thresholds or no timed queries.
+func SLOString(s *Stats, slo []SLO, hostname string) string {
+	if len(slo) == 0 {
+		return ""
+	}
+	n, timed := sloCounts(s, slo)
+	if timed == 0 {
+		return ""
+	}
+	line := "slo:"
+	for i := range n {
+		var name string
+		if i < len(slo) {
+			name = "<= " + slo[i].Name
+		} else {
+			name = "> " + slo[i-1].Name
+		}
+		if i > 0 {
+			line += ","
+		}
+		line += fmt.Sprintf(" %s %.2f%% (%s)", name, float64(n[i])/float64(timed)*100, h.Comma(int64(n[i])))
+	}
+	return line + " (" + hostname + ")"
+}
+
+// RunString returns a line with the run ID and tags (--run-id and --tag) of the
+// instance, or "" if neither is set.
+func RunString(in Instance) string {
+	if in.RunId == "" && len(in.Tags) == 0 {
+		return ""
+	}
+	s := "run:"
+	if in.RunId != "" {
+		s += " " + in.RunId
+	}
+	if len(in.Tags) > 0 {
+		s += " " + TagString(in.Tags)
+	}
+	return s + " (" + in.Hostname + ")"
+}
+
+// TimeString returns the wall-clock interval as a line like
+// "time: 2024-01-01T12:00:00Z to 2024-01-01T12:00:01Z (local)", or "" if
+// the interval isn't timestamped.
+func TimeString(in Instance) string {
+	if in.Start.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("time: %s to %s (%s)", Timestamp(in.Start), Timestamp(in.End), in.Hostname)
+}
+
+// Timestamp returns t in RFC3339 format, or "" if t is zero.
+func Timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// TagString returns tags as "k1=v1 k2=v2" sorted by key.
+func TagString(tags map[string]string) string {
+	keys := tagKeys(tags)
+	kv := make([]string, len(keys))
+	for i, k := range keys {
+		kv[i] = k + "=" + tags[k]
+	}
+	return strings.Join(kv, " ")
+}
+
+// tagKeys returns the tag keys sorted so reporters print tags in a consistent
+// order.
+func tagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// trxNames returns the trx names in trx sorted so reporters print trx stats
+// in a consistent order.
+func trxNames(trx map[string]*Stats) []string {
+	names := make([]string, 0, len(trx))
+	for name := range trx {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // intsToString returns []int{1,2,3} as "1,2,3" to replace P in Fmt.
 func intsToString(n []uint64, sep string, prettyPrint bool) string {
 	if len(n) == 0 {
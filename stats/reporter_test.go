@@ -3,8 +3,19 @@
 package stats_test
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
@@ -63,6 +74,8 @@ func TestCSV(t *testing.T) {
 			Interval: 1,
 			Seconds:  2.0,
 			Runtime:  2.0,
+			Start:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			End:      time.Date(2024, 1, 1, 12, 0, 2, 0, time.UTC),
 			Total:    s,
 			//Trx:      map[string]*stats.Stats{},
 		},
@@ -74,8 +87,8 @@ func TestCSV(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expect := `interval,duration,runtime,clients,QPS,min,P999,max,r_QPS,r_min,r_P999,r_max,w_QPS,w_min,w_P999,w_max,TPS,c_min,c_P999,c_max,errors,compute
-1,2.0,2.0,1,3,110,389,390,1,110,185,190,1,210,294,290,1,310,389,390,0,local
+	expect := `interval,duration,runtime,clients,QPS,min,P999,max,r_QPS,r_min,r_P999,r_max,w_QPS,w_min,w_P999,w_max,TPS,c_min,c_P999,c_max,errors,compute,start,end,mysql_warnings
+1,2.0,2.0,1,3,110,389,390,1,110,185,190,1,210,294,290,1,310,389,390,0,local,2024-01-01T12:00:00Z,2024-01-01T12:00:02Z,0
 `
 	if string(got) != expect {
 		t.Errorf("got:\n%s\nexpected:\n%s\n", string(got), expect)
@@ -86,3 +99,372 @@ func TestCSV(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestHLog(t *testing.T) {
+	r, err := stats.NewHLog(map[string]string{"each-trx": "yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := r.File()
+	t.Logf("stats file: %s", file)
+	defer os.Remove(file)
+
+	s := stats.NewStats()
+	s.Record(stats.READ, 110)
+	s.Record(stats.READ, 190)
+	s.Record(stats.WRITE, 2100)
+	s.Record(stats.COMMIT, 51000)
+	r.Report([]stats.Instance{
+		{
+			Hostname: "local",
+			Interval: 1,
+			Seconds:  2.0,
+			Runtime:  2.0,
+			Total:    s,
+			Trx:      map[string]*stats.Stats{"a b.sql": s},
+		},
+	})
+	r.Stop()
+
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, expected 6 (4 header, 2 histograms):\n%s", len(lines), got)
+	}
+	if !strings.HasPrefix(lines[2], "#[StartTime: ") {
+		t.Errorf("line 3 is '%s', expected StartTime", lines[2])
+	}
+	if !strings.HasPrefix(lines[4], "0.000,2.000,51000.000,") {
+		t.Errorf("line 5 is '%s', expected prefix 0.000,2.000,51000.000,", lines[4])
+	}
+	if !strings.HasPrefix(lines[5], "Tag=a_b.sql,0.000,2.000,51000.000,") {
+		t.Errorf("line 6 is '%s', expected prefix Tag=a_b.sql,", lines[5])
+	}
+
+	// Decode the histogram (V2 compressed) and check that the values (from
+	// HdrHistogram counts array indexes) are within 5% (Finch bucket size)
+	f := strings.Split(lines[4], ",")
+	b, err := base64.StdEncoding.DecodeString(f[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := binary.BigEndian.Uint32(b[0:4]); cookie != 0x1c849314 {
+		t.Errorf("compressed cookie %x, expected 1c849314", cookie)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := binary.BigEndian.Uint32(u[0:4]); cookie != 0x1c849313 {
+		t.Errorf("cookie %x, expected 1c849313", cookie)
+	}
+	if n := binary.BigEndian.Uint32(u[4:8]); int(n) != len(u)-40 {
+		t.Errorf("payload length %d, expected %d", n, len(u)-40)
+	}
+	if highest := binary.BigEndian.Uint64(u[24:32]); highest != 51000 {
+		t.Errorf("highest trackable value %d, expected 51000", highest)
+	}
+	values := []int64{}
+	p := bytes.NewReader(u[40:])
+	for i := 0; p.Len() > 0; {
+		zz, err := binary.ReadUvarint(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := int64(zz>>1) ^ -int64(zz&1) // un-zigzag
+		if v < 0 {
+			i += int(-v) // zeros
+			continue
+		}
+		if v > 0 {
+			// 2 significant digits: 128 half sub-buckets
+			bucket := (i >> 7) - 1
+			sub := int64(i&127) + 128
+			if bucket < 0 {
+				bucket = 0
+				sub -= 128
+			}
+			for ; v > 0; v-- {
+				values = append(values, sub<<bucket)
+			}
+		}
+		i++
+	}
+	expect := []int64{110, 190, 2100, 51000}
+	if len(values) != len(expect) {
+		t.Fatalf("got values %v, expected %v", values, expect)
+	}
+	for i := range expect {
+		if d := float64(values[i]-expect[i]) / float64(expect[i]); d < -0.05 || d > 0.05 {
+			t.Errorf("got value %d, expected %d +/- 5%%", values[i], expect[i])
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	r, err := stats.NewJSON(map[string]string{"each-instance": "yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := r.File()
+	t.Logf("stats file: %s", file)
+
+	s1 := stats.NewStats()
+	s1.Record(stats.READ, 110)
+	s2 := stats.NewStats()
+	s2.Record(stats.WRITE, 210)
+
+	from := []stats.Instance{
+		{
+			Hostname: "host1",
+			Clients:  1,
+			Interval: 1,
+			Seconds:  1.0,
+			Runtime:  1.0,
+			Start:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			End:      time.Date(2024, 1, 1, 12, 0, 1, 0, time.UTC),
+			Total:    s1,
+		},
+		{
+			Hostname: "host2",
+			Clients:  2,
+			Interval: 1,
+			Seconds:  1.0,
+			Runtime:  1.0,
+			Start:    time.Date(2024, 1, 1, 12, 0, 1, 0, time.UTC), // clock 1s ahead
+			End:      time.Date(2024, 1, 1, 12, 0, 2, 0, time.UTC),
+			Total:    s2,
+		},
+	}
+	r.Report(from)
+	r.Stop()
+
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []stats.JSONLine
+	dec := json.NewDecoder(strings.NewReader(string(bytes)))
+	for dec.More() {
+		var line stats.JSONLine
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, line)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, expected 3 (2 instances + combined): %s", len(got), string(bytes))
+	}
+	compute := []string{got[0].Compute, got[1].Compute, got[2].Compute}
+	if diff := deep.Equal(compute, []string{"host1", "host2", "2 combined"}); diff != nil {
+		t.Error(diff)
+	}
+	if got[2].Clients != 3 {
+		t.Errorf("combined clients = %d, expected 3", got[2].Clients)
+	}
+	if got[2].Read.N != 1 || got[2].Write.N != 1 || got[2].Total.N != 2 {
+		t.Errorf("wrong combined counts: %+v", got[2])
+	}
+	// Combined interval spans the earliest start and latest end
+	if got[2].Start != "2024-01-01T12:00:00Z" || got[2].End != "2024-01-01T12:00:02Z" {
+		t.Errorf("combined start %s, end %s; expected 2024-01-01T12:00:00Z, 2024-01-01T12:00:02Z", got[2].Start, got[2].End)
+	}
+
+	err = os.Remove(file)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInflux(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token abc" {
+			t.Errorf("got Authorization '%s', expected 'Token abc'", r.Header.Get("Authorization"))
+		}
+		bytes, _ := io.ReadAll(r.Body)
+		got = string(bytes)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	r, err := stats.NewInflux(map[string]string{"url": ts.URL, "token": "abc", "each-trx": "yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := stats.NewStats()
+	s.Record(stats.READ, 110)
+	s.Record(stats.READ, 190)
+	from := []stats.Instance{
+		{
+			Hostname: "local",
+			Clients:  1,
+			Interval: 1,
+			Seconds:  2.0,
+			Runtime:  2.0,
+			Total:    s,
+			Trx:      map[string]*stats.Stats{"001.sql": s},
+		},
+	}
+	r.Report(from)
+	r.Stop()
+
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2 (total + 1 trx): %s", len(lines), got)
+	}
+	// Remove timestamp
+	for i := range lines {
+		lines[i] = lines[i][:strings.LastIndex(lines[i], " ")]
+	}
+	fields := "interval=1i,duration=2,runtime=2,clients=1i,QPS=1i,min=110i,P999=185i,max=190i,r_QPS=1i,r_min=110i,r_P999=185i,r_max=190i,w_QPS=0i,w_min=0i,w_P999=0i,w_max=0i,TPS=0i,c_min=0i,c_P999=0i,c_max=0i,errors=0i"
+	expect := []string{
+		"finch,compute=local " + fields,
+		"finch,compute=local,trx=001.sql " + fields,
+	}
+	if diff := deep.Equal(lines, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestInflux_Event(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "finch.lp")
+	r, err := stats.NewInflux(map[string]string{"file": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := stats.NewInstance("local")
+	in.Interval = 1
+	in.Seconds = 1.0
+	in.Runtime = 1.0
+	in.Events = []stats.Event{
+		{Time: time.Unix(1700000000, 0), Runtime: 0.5, Event: `mark: "failover" started`},
+	}
+	r.Report([]stats.Instance{in})
+	r.Stop()
+
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2 (total + 1 event): %s", len(lines), bytes)
+	}
+	expect := `finch_events event="mark: \"failover\" started",runtime=0.5 1700000000000000000`
+	if lines[1] != expect {
+		t.Errorf("got event line:\n%s\nexpected:\n%s", lines[1], expect)
+	}
+}
+
+func TestMySQL_Options(t *testing.T) {
+	if _, err := stats.NewMySQL(map[string]string{}); err == nil {
+		t.Error("no error without dsn")
+	}
+	if _, err := stats.NewMySQL(map[string]string{"dsn": "finch@tcp(127.0.0.1)/finch", "table": "t; DROP TABLE t"}); err == nil {
+		t.Error("no error for invalid table name")
+	}
+}
+
+func TestRunString(t *testing.T) {
+	in := stats.NewInstance("local")
+	if got := stats.RunString(in); got != "" {
+		t.Errorf("got '%s', expected '' when --run-id and --tag not set", got)
+	}
+
+	stats.RunId = "r1"
+	stats.Tags = map[string]string{"mysql": "8.0", "env": "test"}
+	defer func() {
+		stats.RunId = ""
+		stats.Tags = nil
+	}()
+
+	// Local run ID and tags are kept when combining remote instances
+	all := stats.NewInstance("")
+	remote := stats.NewInstance("remote")
+	remote.RunId = "r2"
+	all.Combine([]stats.Instance{remote})
+	if all.RunId != "r1" {
+		t.Errorf("got run ID %s, expected r1", all.RunId)
+	}
+
+	expect := "run: r1 env=test mysql=8.0 (local)"
+	if got := stats.RunString(stats.NewInstance("local")); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+}
+
+func TestStdout_Summary(t *testing.T) {
+	// --output json: nothing printed each interval, then one summary line
+	stats.Output = stats.OUTPUT_JSON
+	defer func() { stats.Output = "" }()
+
+	stdout := os.Stdout
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = pw
+	r, err := stats.NewStdout(map[string]string{})
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint(1); i <= 2; i++ {
+		s := stats.NewStats()
+		s.Record(stats.READ, 110)
+		s.Record(stats.WRITE, 210)
+		r.Report([]stats.Instance{
+			{
+				Hostname: "local",
+				Clients:  2,
+				Interval: i,
+				Seconds:  1.0,
+				Runtime:  float64(i),
+				Total:    s,
+			},
+		})
+	}
+	r.Stop()
+	pw.Close()
+	bytes, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []stats.JSONLine
+	dec := json.NewDecoder(strings.NewReader(string(bytes)))
+	for dec.More() {
+		var line stats.JSONLine
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("%s: %s", err, string(bytes))
+		}
+		got = append(got, line)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d lines, expected 1 (summary): %s", len(got), string(bytes))
+	}
+	if got[0].Compute != "summary" {
+		t.Errorf("got compute %s, expected summary", got[0].Compute)
+	}
+	if got[0].Duration != 2.0 || got[0].Runtime != 2.0 || got[0].Clients != 2 {
+		t.Errorf("got duration %f, runtime %f, clients %d; expected 2.0, 2.0, 2", got[0].Duration, got[0].Runtime, got[0].Clients)
+	}
+	if got[0].Read.N != 2 || got[0].Write.N != 2 || got[0].Total.N != 4 {
+		t.Errorf("wrong summary counts: %+v", got[0])
+	}
+	if got[0].Total.QPS != 2 {
+		t.Errorf("got QPS %d, expected 2", got[0].Total.QPS)
+	}
+}
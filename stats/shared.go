@@ -0,0 +1,155 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Shared is trx stats shared by clients (config.stats.aggregate: trx). By default,
+// every client has its own Trx for every trx file, so stats memory grows with
+// trx files * clients. Shared has a fixed number of shards per trx file (and per
+// -- label), and clients are assigned to shards round-robin. Clients on the same
+// shard record to the same Trx with atomic operations. This trades per-client
+// stats for scalability: stats memory grows with trx files * shards.
+type Shared struct {
+	n    int
+	mux  sync.Mutex
+	trx  map[string][]*Trx
+	next map[string]int
+}
+
+// NewShared returns shared trx stats with n shards per trx file, or GOMAXPROCS
+// shards if n is zero.
+func NewShared(n int) *Shared {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return &Shared{
+		n:    n,
+		trx:  map[string][]*Trx{},
+		next: map[string]int{},
+	}
+}
+
+// Trx returns the next shard of stats for the trx file in the exec group. Call
+// it once per client. Clients in different exec groups don't share stats so
+// exec group summaries include only their own clients.
+func (s *Shared) Trx(execGroup, name string) *Trx {
+	return s.shard(execGroup, name, false)
+}
+
+// Label returns the next shard of stats for the -- label. Call it once per client.
+// Labels are not per exec group because they're not in exec group summaries.
+func (s *Shared) Label(name string) *Trx {
+	return s.shard("", LABEL_PREFIX+name, true)
+}
+
+func (s *Shared) shard(execGroup, name string, label bool) *Trx {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	key := execGroup + "/" + name
+	shards, ok := s.trx[key]
+	if !ok {
+		shards = make([]*Trx, s.n)
+		for i := range shards {
+			shards[i] = NewTrx(name)
+			shards[i].ExecGroup = execGroup
+			shards[i].label = label
+			shards[i].shared = &sync.Mutex{}
+			shards[i].a.unsetMin()
+		}
+		s.trx[key] = shards
+	}
+	i := s.next[key]
+	s.next[key] = (i + 1) % s.n
+	return shards[i]
+}
+
+// --------------------------------------------------------------------------
+// Atomic counterparts of Stats methods for shared Trx. Min is math.MaxInt64
+// when not set (unsetMin), not zero like Stats.Reset, because zero is a valid
+// min (for example, with stats.clock: coarse) and minAtomic can't check
+// N and Untimed atomically with Min. Trx.Swap sets it back to zero (setMin).
+
+// unsetMin sets Min to math.MaxInt64 for shared stats.
+func (s *Stats) unsetMin() {
+	for i := range s.Min {
+		s.Min[i] = math.MaxInt64
+	}
+}
+
+// setMin sets Min that's not set (math.MaxInt64) back to zero when shared
+// stats are swapped out for reporting.
+func (s *Stats) setMin() {
+	for i := range s.Min {
+		atomic.CompareAndSwapInt64(&s.Min[i], math.MaxInt64, 0)
+	}
+}
+
+func (s *Stats) recordAtomic(eventType byte, d int64) {
+	n := bucketNo(d)
+	atomic.AddUint64(&s.Buckets[eventType][n], 1)
+	minAtomic(&s.Min[eventType], d)
+	maxAtomic(&s.Max[eventType], d)
+	atomic.AddUint64(&s.N[eventType], 1)
+	if eventType < TOTAL {
+		atomic.AddUint64(&s.Buckets[TOTAL][n], 1)
+		minAtomic(&s.Min[TOTAL], d)
+		maxAtomic(&s.Max[TOTAL], d)
+		atomic.AddUint64(&s.N[TOTAL], 1)
+	}
+}
+
+func (s *Stats) recordCorrectedAtomic(eventType byte, d, interval int64) {
+	s.recordAtomic(eventType, d)
+	s.correct(eventType, d, interval, true)
+}
+
+func (s *Stats) countAtomic(eventType byte) {
+	atomic.AddUint64(&s.N[eventType], 1)
+	atomic.AddUint64(&s.Untimed[eventType], 1)
+	if eventType < TOTAL {
+		atomic.AddUint64(&s.N[TOTAL], 1)
+		atomic.AddUint64(&s.Untimed[TOTAL], 1)
+	}
+}
+
+func (s *Stats) recordStaleAtomic(d int64) {
+	atomic.AddUint64(&s.Stale, 1)
+	atomic.AddInt64(&s.StaleTime, d)
+	maxAtomic(&s.StaleMax, d)
+}
+
+func (s *Stats) recordQueueAtomic(d int64) {
+	atomic.AddUint64(&s.Queued, 1)
+	atomic.AddInt64(&s.QueueTime, d)
+	maxAtomic(&s.QueueMax, d)
+}
+
+func minAtomic(p *int64, v int64) {
+	for {
+		cur := atomic.LoadInt64(p)
+		if cur <= v {
+			return
+		}
+		if atomic.CompareAndSwapInt64(p, cur, v) {
+			return
+		}
+	}
+}
+
+func maxAtomic(p *int64, v int64) {
+	for {
+		cur := atomic.LoadInt64(p)
+		if cur >= v {
+			return
+		}
+		if atomic.CompareAndSwapInt64(p, cur, v) {
+			return
+		}
+	}
+}
@@ -4,21 +4,28 @@ package stats
 
 import (
 	"math"
+	"sync"
 	"sync/atomic"
 )
 
-var nEventTypes = 4 // number of event types:
+var nEventTypes = 5 // number of event types:
 
 const (
 	READ byte = iota
 	WRITE
 	COMMIT
 	TOTAL
+
+	// TRX_FILE is the response time of a trx file: from the start of its first
+	// statement to the end of its last statement, excluding idle time. It's not
+	// a query, so it's not included in TOTAL.
+	TRX_FILE
 )
 
 // Stats are lock-free basic statistics: query count (N), min and max response time,
 // and response time distribution and percentiles using the same histogram bucketes
-// as MySQL 8.0. All times are microseconds.
+// as MySQL 8.0. All times are microseconds, or nanoseconds if config.stats.precision
+// is "ns" (the client records the values, Stats doesn't know the unit).
 //
 // Finch calculates stats per-client and per-trx (Finch trx file, not MySQL trx).
 // If there are 8 clients running 2 trx, then there are 16 instances of Stats
@@ -28,7 +35,40 @@ type Stats struct {
 	Min     []int64           // response time (μs)
 	Max     []int64           // response time (μs)
 	N       []uint64          // number of events (queries)
+	Untimed []uint64          // number of events without a response time (Count)
+	Synth   []uint64          // number of corrected response times (RecordCorrected)
 	Errors  map[uint16]uint64 // count MySQL error codes
+
+	// Read-your-writes violations (-- verify): count, and total and max time
+	// waiting for the row to be visible (μs)
+	Stale     uint64
+	StaleTime int64
+	StaleMax  int64
+
+	// Read-your-writes violations where the row wasn't visible within the
+	// -- verify timeout (not included in Stale)
+	StaleTimeouts uint64
+
+	// Open-loop arrivals (config.workload.arrival-rate): count, and total and
+	// max time from intended start to actual start of the iteration (μs)
+	Queued    uint64
+	QueueTime int64
+	QueueMax  int64
+
+	// Statements that exceeded -- timeout, client or server side (not Errors)
+	Timeouts uint64
+
+	// MySQL warnings from SHOW WARNINGS (-- warnings or config.mysql.warnings)
+	MySQLWarnings uint64
+
+	// TPS allowances refunded because the MySQL trx didn't commit
+	// (config.stage.workload.tps-refund)
+	Refunds uint64
+
+	// Rows read by the client from SELECT statements, and rows affected by
+	// writes (INSERT, UPDATE, DELETE, REPLACE)
+	RowsRead     uint64
+	RowsAffected uint64
 }
 
 func NewStats() *Stats {
@@ -42,6 +82,8 @@ func NewStats() *Stats {
 		Min:     make([]int64, nEventTypes),
 		Max:     make([]int64, nEventTypes),
 		N:       make([]uint64, nEventTypes),
+		Untimed: make([]uint64, nEventTypes),
+		Synth:   make([]uint64, nEventTypes),
 		Errors:  map[uint16]uint64{},
 	}
 }
@@ -52,9 +94,8 @@ const base = 10.0                   // microseconds
 const factor = 1.0471285480508996   // 4.7% bucket size increments
 const logFactor = 0.046051701859881 // ln(factor)
 
-// Record records the duration of an event in microseconds.
-func (s *Stats) Record(eventType byte, d int64) {
-	// Calculate bucket number
+// bucketNo returns the histogram bucket for a response time.
+func bucketNo(d int64) uint {
 	bucket := math.Log(float64(d)/base) / logFactor
 	n := uint(bucket) + 1
 	if bucket < 0 {
@@ -63,10 +104,16 @@ func (s *Stats) Record(eventType byte, d int64) {
 	if n > n_buckets-1 {
 		n = n_buckets - 1
 	}
+	return n
+}
+
+// Record records the duration of an event in microseconds.
+func (s *Stats) Record(eventType byte, d int64) {
+	n := bucketNo(d)
 
 	// Record event types separately
 	s.Buckets[eventType][n] += 1
-	if d < s.Min[eventType] || s.N[eventType] == 0 {
+	if d < s.Min[eventType] || s.timed(eventType) == 0 {
 		s.Min[eventType] = d
 	}
 	if d > s.Max[eventType] {
@@ -74,11 +121,11 @@ func (s *Stats) Record(eventType byte, d int64) {
 	}
 	s.N[eventType]++
 
-	// Also record non-TOTAL events in the total stats. Since TOTAL events are
-	// recoded above, only do this for non-TOTAL events.
-	if eventType != TOTAL {
+	// Also record query events in the total stats. Since TOTAL events are
+	// recoded above, only do this for READ, WRITE, and COMMIT.
+	if eventType < TOTAL {
 		s.Buckets[TOTAL][n] += 1
-		if d < s.Min[TOTAL] || s.N[TOTAL] == 0 {
+		if d < s.Min[TOTAL] || s.timed(TOTAL) == 0 {
 			s.Min[TOTAL] = d
 		}
 		if d > s.Max[TOTAL] {
@@ -97,10 +144,118 @@ func (s *Stats) Reset() {
 		s.Min[i] = 0
 		s.Max[i] = 0
 		s.N[i] = 0
+		s.Untimed[i] = 0
+		s.Synth[i] = 0
 	}
 	for k := range s.Errors {
 		s.Errors[k] = 0
 	}
+	s.Stale = 0
+	s.StaleTime = 0
+	s.StaleMax = 0
+	s.StaleTimeouts = 0
+	s.Queued = 0
+	s.QueueTime = 0
+	s.QueueMax = 0
+	s.Timeouts = 0
+	s.MySQLWarnings = 0
+	s.Refunds = 0
+	s.RowsRead = 0
+	s.RowsAffected = 0
+}
+
+// RecordCorrected records the duration of an event like Record, then corrects
+// for coordinated omission like HdrHistogram: if d is greater than the expected
+// interval between events, the client was too slow to execute the events that
+// should have started during d, so it also records their response times (from
+// their intended start): d-interval, d-2*interval, and so on down to interval.
+// Corrected response times are included in min and percentiles (Synth) but not
+// in N, so they don't change QPS or TPS.
+func (s *Stats) RecordCorrected(eventType byte, d, interval int64) {
+	s.Record(eventType, d)
+	s.correct(eventType, d, interval, false)
+}
+
+// correct records the corrected response times for RecordCorrected. If shared
+// is true, it uses atomic operations (see Shared).
+func (s *Stats) correct(eventType byte, d, interval int64, shared bool) {
+	if interval <= 0 || d < 2*interval {
+		return
+	}
+	add := func(p *uint64, k uint64) { *p += k }
+	setMin := func(p *int64, v int64) {
+		if v < *p {
+			*p = v
+		}
+	}
+	if shared {
+		add = func(p *uint64, k uint64) { atomic.AddUint64(p, k) }
+		setMin = minAtomic
+	}
+	// Record all corrected response times in the same bucket at once, so this
+	// is fast even if d is much greater than interval (a stall)
+	var synth uint64
+	for v := d - interval; v >= interval; {
+		n := bucketNo(v)
+		lo := interval
+		if n > 0 {
+			if b := int64(math.Ceil(base * math.Pow(factor, float64(n-1)))); b > lo {
+				lo = b
+			}
+		}
+		k := (v-lo)/interval + 1 // values v, v-interval, ... >= lo in bucket n
+		add(&s.Buckets[eventType][n], uint64(k))
+		if eventType < TOTAL {
+			add(&s.Buckets[TOTAL][n], uint64(k))
+		}
+		synth += uint64(k)
+		v -= k * interval
+	}
+	min := d - int64(synth)*interval // smallest corrected response time
+	add(&s.Synth[eventType], synth)
+	setMin(&s.Min[eventType], min)
+	if eventType < TOTAL {
+		add(&s.Synth[TOTAL], synth)
+		setMin(&s.Min[TOTAL], min)
+	}
+}
+
+// Count counts an event without a response time, so it's not included in the
+// min, max, or percentiles. It's used when response time is not measured for
+// every query (config.stage.workload.measure).
+func (s *Stats) Count(eventType byte) {
+	s.N[eventType]++
+	s.Untimed[eventType]++
+	if eventType < TOTAL {
+		s.N[TOTAL]++
+		s.Untimed[TOTAL]++
+	}
+}
+
+// timed returns the number of response times, including corrected response
+// times (RecordCorrected).
+func (s *Stats) timed(eventType byte) uint64 {
+	return s.N[eventType] - s.Untimed[eventType] + s.Synth[eventType]
+}
+
+// RecordStale records a read-your-writes violation that waited d microseconds
+// for the row to be visible.
+func (s *Stats) RecordStale(d int64) {
+	s.Stale++
+	s.StaleTime += d
+	if d > s.StaleMax {
+		s.StaleMax = d
+	}
+}
+
+// RecordQueue records an open-loop arrival that waited d microseconds for a free
+// client (queueing delay).
+func (s *Stats) RecordQueue(d int64) {
+	s.Queued++
+	s.QueueTime += d
+	if d > s.QueueMax {
+		s.QueueMax = d
+	}
 }
 
 // Copy copies all stats from c, overwriting all values in s. Calling Reset before
@@ -111,10 +266,24 @@ func (s *Stats) Copy(c *Stats) {
 		s.Min[i] = c.Min[i]
 		s.Max[i] = c.Max[i]
 		s.N[i] = c.N[i]
+		s.Untimed[i] = c.Untimed[i]
+		s.Synth[i] = c.Synth[i]
 	}
 	for k, v := range c.Errors {
 		s.Errors[k] = v
 	}
+	s.Stale = c.Stale
+	s.StaleTime = c.StaleTime
+	s.StaleMax = c.StaleMax
+	s.StaleTimeouts = c.StaleTimeouts
+	s.Queued = c.Queued
+	s.QueueTime = c.QueueTime
+	s.QueueMax = c.QueueMax
+	s.Timeouts = c.Timeouts
+	s.MySQLWarnings = c.MySQLWarnings
+	s.Refunds = c.Refunds
+	s.RowsRead = c.RowsRead
+	s.RowsAffected = c.RowsAffected
 }
 
 // Combine combines all stats from c. All values in s are adjusted with respect
@@ -125,17 +294,46 @@ func (s *Stats) Combine(c *Stats) {
 		for j := range s.Buckets[i] {
 			s.Buckets[i][j] += c.Buckets[i][j]
 		}
-		if c.Min[i] < s.Min[i] || s.N[i] == 0 {
+		if c.timed(byte(i)) > 0 && (c.Min[i] < s.Min[i] || s.timed(byte(i)) == 0) {
 			s.Min[i] = c.Min[i]
 		}
 		if c.Max[i] > s.Max[i] {
 			s.Max[i] = c.Max[i]
 		}
 		s.N[i] += c.N[i]
+		s.Untimed[i] += c.Untimed[i]
+		s.Synth[i] += c.Synth[i]
 	}
 	for k, v := range c.Errors {
 		s.Errors[k] += v
 	}
+	s.Stale += c.Stale
+	s.StaleTime += c.StaleTime
+	s.StaleTimeouts += c.StaleTimeouts
+	if c.StaleMax > s.StaleMax {
+		s.StaleMax = c.StaleMax
+	}
+	s.Queued += c.Queued
+	s.QueueTime += c.QueueTime
+	if c.QueueMax > s.QueueMax {
+		s.QueueMax = c.QueueMax
+	}
+	s.Timeouts += c.Timeouts
+	s.MySQLWarnings += c.MySQLWarnings
+	s.Refunds += c.Refunds
+	s.RowsRead += c.RowsRead
+	s.RowsAffected += c.RowsAffected
+}
+
+// Within returns the number of timed events with a response time <= max. Like
+// percentiles, it's approximate: counts are per histogram bucket, so events up
+// to one bucket size (4.7%) greater than max can be included.
+func (s *Stats) Within(eventType byte, max int64) uint64 {
+	var n uint64
+	for i := uint(0); i <= bucketNo(max); i++ {
+		n += s.Buckets[eventType][i]
+	}
+	return n
 }
 
 func (s Stats) Percentiles(eventType byte, p []float64) (q []uint64) {
@@ -148,7 +346,7 @@ func (s Stats) Percentiles(eventType byte, p []float64) (q []uint64) {
 	j := 0                     // index in p[] and q[]
 	for i := range s.Buckets[eventType] {
 		n += s.Buckets[eventType][i]
-		f = float64(n) / float64(s.N[eventType]) * 100
+		f = float64(n) / float64(s.timed(eventType)) * 100
 		// i    f     p[j]   Bucket[i]
 		// ---  ----  -----  -----------------
 		// 100  94.1         500100 (500.1 ms)
@@ -187,12 +385,18 @@ func (s Stats) Percentiles(eventType byte, p []float64) (q []uint64) {
 // then it's returned to the Collector for reporting, and "b" is made active for
 // on-going stats recording by the Client. This is the other half of the lock-free
 // Stats design.
+//
+// If config.stats.aggregate is trx, clients share Trx (see Shared), so Trx records
+// with atomic operations, except errors which are guarded by the shared mutex.
 type Trx struct {
-	Name string
-	a    *Stats
-	b    *Stats
-	sp   atomic.Pointer[Stats]
-	onA  bool
+	Name      string
+	ExecGroup string // exec group of the client(s), for exec group summaries
+	a         *Stats
+	b         *Stats
+	sp        atomic.Pointer[Stats]
+	onA       bool
+	label     bool        // not a trx: statements with -- label (see NewLabel)
+	shared    *sync.Mutex // shared by clients (see Shared), else nil
 }
 
 func NewTrx(name string) *Trx {
@@ -207,29 +411,150 @@ func NewTrx(name string) *Trx {
 	return t
 }
 
+// LABEL_PREFIX prefixes the name of label stats (NewLabel) so they're reported
+// like trx stats but don't collide with trx names.
+const LABEL_PREFIX = "label:"
+
+// NewLabel returns stats for statements with the same -- label modifier, which
+// can be in different trx files. Label stats are reported like trx stats named
+// LABEL_PREFIX + name, but they're not included in total stats because the
+// statements are already counted in their trx stats.
+func NewLabel(name string) *Trx {
+	t := NewTrx(LABEL_PREFIX + name)
+	t.label = true
+	return t
+}
+
 func (t *Trx) Record(eventType byte, d int64) {
+	if t.shared != nil {
+		t.sp.Load().recordAtomic(eventType, d)
+		return
+	}
 	t.sp.Load().Record(eventType, d)
 }
 
+func (t *Trx) RecordCorrected(eventType byte, d, interval int64) {
+	if t.shared != nil {
+		t.sp.Load().recordCorrectedAtomic(eventType, d, interval)
+		return
+	}
+	t.sp.Load().RecordCorrected(eventType, d, interval)
+}
+
+func (t *Trx) Count(eventType byte) {
+	if t.shared != nil {
+		t.sp.Load().countAtomic(eventType)
+		return
+	}
+	t.sp.Load().Count(eventType)
+}
+
 func (t *Trx) Error(n uint16) {
+	if t.shared != nil {
+		t.shared.Lock()
+		t.sp.Load().Errors[n] += 1
+		t.shared.Unlock()
+		return
+	}
 	t.sp.Load().Errors[n] += 1
 }
 
+func (t *Trx) Stale(d int64) {
+	if t.shared != nil {
+		t.sp.Load().recordStaleAtomic(d)
+		return
+	}
+	t.sp.Load().RecordStale(d)
+}
+
+// StaleTimeout records a read-your-writes violation where the row wasn't visible
+// within the -- verify timeout.
+func (t *Trx) StaleTimeout() {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().StaleTimeouts, 1)
+		return
+	}
+	t.sp.Load().StaleTimeouts += 1
+}
+
+func (t *Trx) Queue(d int64) {
+	if t.shared != nil {
+		t.sp.Load().recordQueueAtomic(d)
+		return
+	}
+	t.sp.Load().RecordQueue(d)
+}
+
+func (t *Trx) Timeout() {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().Timeouts, 1)
+		return
+	}
+	t.sp.Load().Timeouts += 1
+}
+
+func (t *Trx) Refund() {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().Refunds, 1)
+		return
+	}
+	t.sp.Load().Refunds += 1
+}
+
+func (t *Trx) MySQLWarnings(n uint64) {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().MySQLWarnings, n)
+		return
+	}
+	t.sp.Load().MySQLWarnings += n
+}
+
+func (t *Trx) RowsRead(n uint64) {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().RowsRead, n)
+		return
+	}
+	t.sp.Load().RowsRead += n
+}
+
+func (t *Trx) RowsAffected(n uint64) {
+	if t.shared != nil {
+		atomic.AddUint64(&t.sp.Load().RowsAffected, n)
+		return
+	}
+	t.sp.Load().RowsAffected += n
+}
+
 func (t *Trx) Swap() *Stats {
+	if t.shared != nil {
+		// Errors is a map, so swap while no client is recording an error
+		t.shared.Lock()
+		defer t.shared.Unlock()
+	}
 	// on A; switch to B
 	if t.onA {
-		t.b.Reset()
-		t.sp.Store(t.b)
+		t.swap(t.b, t.a)
 		t.onA = false
 		return t.a
 	}
 	// on B; switch to A
-	t.a.Reset()
-	t.sp.Store(t.a)
+	t.swap(t.a, t.b)
 	t.onA = true
 	return t.b
 }
 
+// swap resets next and makes it active, and fixes min in prev if shared.
+func (t *Trx) swap(next, prev *Stats) {
+	next.Reset()
+	if t.shared != nil {
+		next.unsetMin()
+	}
+	t.sp.Store(next)
+	if t.shared != nil {
+		prev.setMin()
+	}
+}
+
 /*
   0 [0.000000, 10.000000)		10 us
   1 [10.000000, 10.471285)
@@ -3,7 +3,9 @@
 package stats_test
 
 import (
+	"context"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/square/finch"
 	"github.com/square/finch/config"
 	"github.com/square/finch/stats"
+	"github.com/square/finch/test/mock"
 )
 
 func Benchmark_Trx(b *testing.B) {
@@ -129,13 +132,104 @@ func TestTrxStats_MultiThreaded(t *testing.T) {
 	<-done
 
 	time.Sleep(100 * time.Millisecond)
-	c.Stop(1*time.Second, false)
+	c.Stop(context.Background())
 
 	//	if a == b {
 	//		t.Errorf("a == b, expected different pointers after first Swap")
 	//	}
 }
 
+func TestShared(t *testing.T) {
+	var got []stats.Instance
+	r := mock.StatsReporter{
+		ReportFunc: func(from []stats.Instance) {
+			got = append(got, from...)
+		},
+	}
+	stats.Register("mock-shared", r) // needs a unique reporter name
+
+	cfg := config.Stats{
+		Report: map[string]map[string]string{
+			"mock-shared": nil,
+		},
+	}
+	c, err := stats.NewCollector(cfg, "local", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 clients on 2 shards: clients 0, 2, 4, 6 on shard 0, and 1, 3, 5, 7 on
+	// shard 1
+	sh := stats.NewShared(2)
+	clients := make([]*stats.Trx, 8)
+	for i := range clients {
+		clients[i] = sh.Trx("dml1", "t1")
+		c.Watch([]*stats.Trx{clients[i]})
+	}
+	if clients[0] != clients[2] || clients[0] == clients[1] {
+		t.Errorf("clients not assigned to shards round-robin")
+	}
+
+	c.Start()
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(s *stats.Trx) {
+			defer wg.Done()
+			for n := int64(1); n <= 1000; n++ {
+				s.Record(stats.READ, n*10)
+			}
+			s.Error(1213)
+		}(clients[i])
+	}
+	wg.Wait()
+	c.Stop(context.Background())
+
+	if len(got) != 1 {
+		t.Fatalf("got %d reports, expected 1", len(got))
+	}
+	in := got[0]
+	if in.Clients != 8 {
+		t.Errorf("got %d clients, expected 8", in.Clients)
+	}
+	s := in.Trx["t1"]
+	if s.N[stats.READ] != 8000 || s.N[stats.TOTAL] != 8000 {
+		t.Errorf("got %d reads, %d total; expected 8000", s.N[stats.READ], s.N[stats.TOTAL])
+	}
+	if s.Min[stats.READ] != 10 || s.Max[stats.READ] != 10000 {
+		t.Errorf("got min %d, max %d; expected 10, 10000", s.Min[stats.READ], s.Max[stats.READ])
+	}
+	if s.Errors[1213] != 8 {
+		t.Errorf("got %d errors, expected 8", s.Errors[1213])
+	}
+}
+
+func TestShared_MinZero(t *testing.T) {
+	// Zero is a valid min (e.g. stats.clock: coarse), not "unset"
+	tr := stats.NewShared(1).Trx("dml1", "t1")
+	tr.Record(stats.READ, 0)
+	tr.Record(stats.READ, 5)
+	s := tr.Swap()
+	if s.Min[stats.READ] != 0 || s.Max[stats.READ] != 5 {
+		t.Errorf("got min %d, max %d; expected 0, 5", s.Min[stats.READ], s.Max[stats.READ])
+	}
+
+	// Nothing recorded: min is zero, not the unset value
+	tr.Count(stats.WRITE)
+	s = tr.Swap()
+	if s.Min[stats.READ] != 0 || s.Min[stats.WRITE] != 0 {
+		t.Errorf("got min %d read, %d write; expected 0, 0", s.Min[stats.READ], s.Min[stats.WRITE])
+	}
+
+	// Next interval: min is set by the first value
+	tr.Record(stats.READ, 7)
+	tr.Record(stats.READ, 9)
+	s = tr.Swap()
+	if s.Min[stats.READ] != 7 {
+		t.Errorf("got min %d, expected 7", s.Min[stats.READ])
+	}
+}
+
 func TestPecentiles_P9s(t *testing.T) {
 	v := [][]int64{
 		{125000, 1},  // 125 ms  -- 125892.541179 (205) -- P0.38
@@ -201,3 +295,227 @@ func TestPecentiles_P50(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestStale(t *testing.T) {
+	s1 := stats.NewStats()
+	s1.RecordStale(100)
+	s1.RecordStale(300)
+	s2 := stats.NewStats()
+	s2.RecordStale(500)
+	s1.Combine(s2)
+	if s1.Stale != 3 || s1.StaleTime != 900 || s1.StaleMax != 500 {
+		t.Errorf("got stale %d, time %d, max %d; expected 3, 900, 500", s1.Stale, s1.StaleTime, s1.StaleMax)
+	}
+	expect := "stale reads: 3, avg wait 300 us, max wait 500 us (local)"
+	if got := stats.StaleString(s1, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+	s2.Reset()
+	s2.StaleTimeouts = 2
+	s1.Combine(s2)
+	expect = "stale reads: 3, avg wait 300 us, max wait 500 us, 2 not visible before timeout (local)"
+	if got := stats.StaleString(s1, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+	s1.Reset()
+	if got := stats.StaleString(s1, "local"); got != "" {
+		t.Errorf("got '%s' after Reset, expected ''", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	s1 := stats.NewStats()
+	s1.RecordQueue(0)
+	s1.RecordQueue(200)
+	s2 := stats.NewStats()
+	s2.RecordQueue(1000)
+	s1.Combine(s2)
+	if s1.Queued != 3 || s1.QueueTime != 1200 || s1.QueueMax != 1000 {
+		t.Errorf("got queued %d, time %d, max %d; expected 3, 1200, 1000", s1.Queued, s1.QueueTime, s1.QueueMax)
+	}
+	expect := "queue: 3 arrivals, avg wait 400 us, max wait 1,000 us (local)"
+	if got := stats.QueueString(s1, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+	s1.Reset()
+	if got := stats.QueueString(s1, "local"); got != "" {
+		t.Errorf("got '%s' after Reset, expected ''", got)
+	}
+}
+
+func TestRecordCorrected(t *testing.T) {
+	// RecordCorrected records corrected response times by bucket, so it must
+	// have the same buckets as recording each one
+	d := int64(123457)
+	interval := int64(37)
+	got := stats.NewStats()
+	got.RecordCorrected(stats.READ, d, interval)
+	expect := stats.NewStats()
+	n := uint64(0)
+	for v := d; v >= interval; v -= interval {
+		expect.Record(stats.READ, v)
+		n++
+	}
+	if diff := deep.Equal(got.Buckets, expect.Buckets); diff != nil {
+		t.Error(diff)
+	}
+	if got.N[stats.READ] != 1 || got.N[stats.TOTAL] != 1 {
+		t.Errorf("got N %d read, %d total; expected 1 (corrected response times not counted)", got.N[stats.READ], got.N[stats.TOTAL])
+	}
+	if got.Synth[stats.READ] != n-1 || got.Synth[stats.TOTAL] != n-1 {
+		t.Errorf("got %d read, %d total corrected; expected %d", got.Synth[stats.READ], got.Synth[stats.TOTAL], n-1)
+	}
+	if got.Min[stats.READ] != expect.Min[stats.READ] || got.Max[stats.READ] != d {
+		t.Errorf("got min %d, max %d; expected %d, %d", got.Min[stats.READ], got.Max[stats.READ], expect.Min[stats.READ], d)
+	}
+	p := []float64{50, 99}
+	if diff := deep.Equal(got.Percentiles(stats.READ, p), expect.Percentiles(stats.READ, p)); diff != nil {
+		t.Error(diff)
+	}
+
+	// Response time less than 2x interval: nothing to correct
+	got.Reset()
+	got.RecordCorrected(stats.WRITE, 150, 100)
+	if got.Synth[stats.WRITE] != 0 || got.N[stats.WRITE] != 1 || got.Min[stats.WRITE] != 150 {
+		t.Errorf("got synth %d, N %d, min %d; expected 0, 1, 150", got.Synth[stats.WRITE], got.N[stats.WRITE], got.Min[stats.WRITE])
+	}
+}
+
+func TestCount(t *testing.T) {
+	// Counted but untimed events are in N but not min or percentiles
+	s1 := stats.NewStats()
+	s1.Count(stats.READ)
+	s1.Count(stats.READ)
+	s1.Record(stats.READ, 300)
+	s1.Count(stats.READ)
+	s2 := stats.NewStats()
+	s2.Count(stats.READ)
+	s2.Record(stats.READ, 200)
+	s1.Combine(s2)
+	if s1.N[stats.READ] != 6 || s1.N[stats.TOTAL] != 6 {
+		t.Errorf("got N read %d, total %d; expected 6, 6", s1.N[stats.READ], s1.N[stats.TOTAL])
+	}
+	if s1.Min[stats.READ] != 200 || s1.Max[stats.READ] != 300 {
+		t.Errorf("got min %d, max %d; expected 200, 300", s1.Min[stats.READ], s1.Max[stats.READ])
+	}
+	q := s1.Percentiles(stats.READ, []float64{50, 99})
+	if q[0] < 190 || q[0] > 210 || q[1] < 290 || q[1] > 310 {
+		t.Errorf("got P50 %d, P99 %d; expected about 200, 300", q[0], q[1])
+	}
+}
+
+func TestLockString(t *testing.T) {
+	s := stats.NewStats()
+	if got := stats.LockString(s, "local"); got != "" {
+		t.Errorf("got '%s' without errors, expected ''", got)
+	}
+	s.Errors[stats.ER_LOCK_DEADLOCK] = 3
+	s.Errors[stats.ER_LOCK_WAIT_TIMEOUT] = 1
+	s.Errors[1062] = 5 // duplicate key: not a lock error
+	expect := "lock errors: 3 deadlocks, 1 lock wait timeouts (local)"
+	if got := stats.LockString(s, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	s1 := stats.NewStats()
+	s1.Timeouts = 2
+	s2 := stats.NewStats()
+	s2.Timeouts = 1
+	s1.Combine(s2)
+	expect := "timeouts: 3 (local)"
+	if got := stats.TimeoutString(s1, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+	s1.Reset()
+	if got := stats.TimeoutString(s1, "local"); got != "" {
+		t.Errorf("got '%s' after Reset, expected ''", got)
+	}
+}
+
+func TestTargetString(t *testing.T) {
+	in := stats.NewInstance("local")
+	in.Seconds = 2
+	in.Total.N[stats.TOTAL] = 180
+	in.Total.N[stats.COMMIT] = 18
+	if got := stats.TargetString(in); got != "" {
+		t.Errorf("got '%s' without target, expected ''", got)
+	}
+
+	in.Target = stats.Target{QPS: 100, TPS: 10}
+	in.Total.Refunds = 2
+	expect := "target: QPS 90 of 100 (90.0%), TPS 9 of 10 (90.0%), 2 refunded (local)"
+	if got := stats.TargetString(in); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+
+	s := stats.NewStats()
+	s.Combine(in.Total)
+	if s.Refunds != 2 {
+		t.Errorf("got %d refunds after Combine, expected 2", s.Refunds)
+	}
+	s.Reset()
+	if s.Refunds != 0 {
+		t.Errorf("got %d refunds after Reset, expected 0", s.Refunds)
+	}
+}
+
+func TestRowsString(t *testing.T) {
+	s := stats.NewStats()
+	if got := stats.RowsString(s, 2, "local"); got != "" {
+		t.Errorf("got '%s' without rows, expected ''", got)
+	}
+	s.RowsRead = 3000
+	s.RowsAffected = 10
+	expect := "rows: 3,000 read (1,500/s), 10 affected (5/s) (local)"
+	if got := stats.RowsString(s, 2, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+}
+
+func TestTrxFile(t *testing.T) {
+	// Trx file response times aren't queries, so they're not in TOTAL
+	s := stats.NewStats()
+	s.Record(stats.READ, 100)
+	s.Record(stats.TRX_FILE, 500)
+	s.Record(stats.TRX_FILE, 1500)
+	if s.N[stats.TOTAL] != 1 || s.N[stats.TRX_FILE] != 2 {
+		t.Errorf("got N total %d, trx file %d; expected 1, 2", s.N[stats.TOTAL], s.N[stats.TRX_FILE])
+	}
+	if s.Max[stats.TOTAL] != 100 {
+		t.Errorf("got max total %d, expected 100", s.Max[stats.TOTAL])
+	}
+	if got := stats.TrxFileString(stats.NewStats(), []string{"P999"}, []float64{99.9}, 2, "local"); got != "" {
+		t.Errorf("got '%s' without trx files, expected ''", got)
+	}
+	expect := "trx file: 2 (1/s), min 500, P999 1,479, max 1,500 (local)"
+	if got := stats.TrxFileString(s, []string{"P999"}, []float64{99.9}, 2, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+}
+
+func TestSLOString(t *testing.T) {
+	cfg := config.Stats{SLO: []string{"1ms", "5ms"}}
+	slo := stats.ParseSLO(cfg)
+	expectSLO := []stats.SLO{{Name: "1ms", Max: 1000}, {Name: "5ms", Max: 5000}}
+	if diff := deep.Equal(slo, expectSLO); diff != nil {
+		t.Error(diff)
+	}
+
+	s := stats.NewStats()
+	if got := stats.SLOString(s, slo, "local"); got != "" {
+		t.Errorf("got '%s' without queries, expected ''", got)
+	}
+	for _, d := range []int64{100, 200, 900, 2000, 30000} {
+		s.Record(stats.READ, d)
+	}
+	s.Record(stats.TRX_FILE, 10) // not a query
+	expect := "slo: <= 1ms 60.00% (3), <= 5ms 80.00% (4), > 5ms 20.00% (1) (local)"
+	if got := stats.SLOString(s, slo, "local"); got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
+	if got := stats.SLOString(s, nil, "local"); got != "" {
+		t.Errorf("got '%s' without stats.slo, expected ''", got)
+	}
+}
@@ -3,6 +3,7 @@
 package stats
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -20,17 +21,45 @@ import (
 //	    stdout:
 //	      combined:      true
 //	      each-instance: false
+//	      each-trx:      false
 //	      percentiles:   "P999"
+//
+// With --quiet or --output json, nothing is printed each interval. Instead, all
+// intervals are summarized when the reporter is stopped, and the summary is
+// printed as a table (--quiet) or one JSON object per line (--output json) like
+// the JSON reporter but with compute "summary".
 type Stdout struct {
+	sP       []string
 	p        []float64
 	w        *tabwriter.Writer
 	header   string
 	all      *Instance
 	each     bool
 	combined bool
+	eachTrx  bool
+	summary  *Instance // all intervals if --quiet or --output json, else nil
+	reported bool      // at least one interval summarized
+	json     *JSON     // --output json, else nil
+	eg       string    // exec groups in last interval, to print when they change
 }
 
-var _ Reporter = &Stdout{}
+var (
+	_ Reporter          = &Stdout{}
+	_ ExecGroupReporter = &Stdout{}
+)
+
+// Quiet and Output are --quiet and --output. They're set once on boot and
+// change only what the stdout reporter prints (see Stdout).
+var (
+	Quiet  bool
+	Output string
+)
+
+// Output formats (--output)
+const (
+	OUTPUT_TEXT = "text" // default
+	OUTPUT_JSON = "json"
+)
 
 func NewStdout(opts map[string]string) (*Stdout, error) {
 	sP, nP, err := ParsePercentiles(opts["percentiles"])
@@ -46,11 +75,13 @@ func NewStdout(opts map[string]string) (*Stdout, error) {
 	)
 	header = strings.ReplaceAll(header, ",", "\t")
 	r := &Stdout{
+		sP:       sP,
 		p:        nP,
 		w:        tabwriter.NewWriter(os.Stdout, 1, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug),
 		header:   header,
 		each:     finch.Bool(opts["each-instance"]),
 		combined: finch.Bool(opts["combined"]),
+		eachTrx:  finch.Bool(opts["each-trx"]),
 	}
 
 	_, ok1 := opts["each-instance"]
@@ -63,13 +94,36 @@ func NewStdout(opts map[string]string) (*Stdout, error) {
 	if r.combined {
 		r.all = &Instance{
 			Total: NewStats(),
-			// We don't use Trx stats yet
+			Trx:   map[string]*Stats{},
+		}
+	}
+
+	if Quiet || Output == OUTPUT_JSON {
+		sum := NewInstance("summary")
+		r.summary = &sum
+		if Output == OUTPUT_JSON {
+			r.json = &JSON{
+				enc:     json.NewEncoder(os.Stdout),
+				sP:      sP,
+				p:       nP,
+				eachTrx: r.eachTrx,
+			}
 		}
 	}
 	return r, nil
 }
 
 func (r *Stdout) Report(from []Instance) {
+	if r.summary != nil {
+		r.summarize(from)
+		return
+	}
+	if eg := strings.Join(execGroupNames(from), ","); eg != r.eg {
+		if r.eg != "" { // delimit exec groups, but not the first
+			fmt.Printf("Execution group: %s (was %s)\n", eg, r.eg)
+		}
+		r.eg = eg
+	}
 	fmt.Fprintln(r.w, r.header)
 	if r.each {
 		for i := range from {
@@ -81,11 +135,81 @@ func (r *Stdout) Report(from []Instance) {
 		r.print(r.all)
 	}
 	r.w.Flush()
+	for i := range from {
+		if line := TimeString(from[i]); line != "" {
+			fmt.Println(line)
+		}
+		if line := RunString(from[i]); line != "" {
+			fmt.Println(line)
+		}
+		for _, p := range from[i].Progress {
+			fmt.Println(ProgressString(p, from[i].Hostname))
+		}
+		for _, w := range from[i].Warnings {
+			fmt.Println(WarningString(w, from[i].Hostname))
+		}
+		for _, e := range from[i].Events {
+			fmt.Println(EventString(e))
+		}
+		if line := StaleString(from[i].Total, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := QueueString(from[i].Total, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := LockString(from[i].Total, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := TimeoutString(from[i].Total, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := MySQLWarningsString(from[i].Total, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := RowsString(from[i].Total, from[i].Seconds, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := SLOString(from[i].Total, from[i].SLO, from[i].Hostname); line != "" {
+			fmt.Println(line)
+		}
+		if line := InFlightString(from[i]); line != "" {
+			fmt.Println(line)
+		}
+		if line := TargetString(from[i]); line != "" {
+			fmt.Println(line)
+		}
+		r.printTrxFile(&from[i])
+	}
 	fmt.Println()
 }
 
+// printTrxFile prints trx file response time (TRX_FILE) for all trx, and for
+// each trx if each-trx is true.
+func (r *Stdout) printTrxFile(in *Instance) {
+	if line := TrxFileString(in.Total, r.sP, r.p, in.Seconds, in.Hostname); line != "" {
+		fmt.Println(line)
+	}
+	if !r.eachTrx {
+		return
+	}
+	for _, name := range trxNames(in.Trx) {
+		if line := TrxFileString(in.Trx[name], r.sP, r.p, in.Seconds, in.Hostname+" "+name); line != "" {
+			fmt.Println(line)
+		}
+	}
+}
+
 func (r *Stdout) print(in *Instance) {
-	s := in.Total
+	r.printStats(in, in.Total, in.Hostname)
+	if !r.eachTrx {
+		return
+	}
+	for _, name := range trxNames(in.Trx) {
+		r.printStats(in, in.Trx[name], in.Hostname+" "+name)
+	}
+}
+
+func (r *Stdout) printStats(in *Instance, s *Stats, compute string) {
 	var errorCount uint64
 	for _, v := range s.Errors {
 		errorCount += v
@@ -122,7 +246,7 @@ func (r *Stdout) print(in *Instance) {
 
 		h.Comma(int64(errorCount)),
 
-		in.Hostname,
+		compute,
 	)
 
 	// Replace P in Fmt with the CSV percentile values
@@ -134,4 +258,66 @@ func (r *Stdout) print(in *Instance) {
 	fmt.Fprintf(r.w, line)
 }
 
-func (r *Stdout) Stop() {}
+// summarize accumulates one interval for the summary printed by Stop.
+func (r *Stdout) summarize(from []Instance) {
+	r.summary.summarize(from)
+	r.reported = true
+}
+
+// ReportExecGroup prints the exec group summary, or writes it as JSON if
+// --output json. It's not printed if --quiet.
+func (r *Stdout) ReportExecGroup(sum Instance) {
+	if r.json != nil {
+		r.json.ReportExecGroup(sum)
+		return
+	}
+	if r.summary != nil {
+		return
+	}
+	fmt.Printf("Execution group %s summary:\n", sum.ExecGroup)
+	fmt.Fprintln(r.w, r.header)
+	r.print(&sum)
+	r.w.Flush()
+	fmt.Println()
+}
+
+// Stop prints the summary if --quiet or --output json.
+func (r *Stdout) Stop() {
+	if r.summary == nil || !r.reported {
+		return
+	}
+	if r.json != nil {
+		r.json.Report([]Instance{*r.summary})
+		return
+	}
+	fmt.Fprintln(r.w, r.header)
+	r.print(r.summary)
+	r.w.Flush()
+	if line := TimeString(*r.summary); line != "" {
+		fmt.Println(line)
+	}
+	if line := RunString(*r.summary); line != "" {
+		fmt.Println(line)
+	}
+	in := r.summary
+	for _, w := range in.Warnings {
+		fmt.Println(WarningString(w, in.Hostname))
+	}
+	for _, line := range []string{
+		StaleString(in.Total, in.Hostname),
+		QueueString(in.Total, in.Hostname),
+		LockString(in.Total, in.Hostname),
+		TimeoutString(in.Total, in.Hostname),
+		MySQLWarningsString(in.Total, in.Hostname),
+		RowsString(in.Total, in.Seconds, in.Hostname),
+		SLOString(in.Total, in.SLO, in.Hostname),
+		InFlightString(*in),
+		TargetString(*in),
+	} {
+		if line != "" {
+			fmt.Println(line)
+		}
+	}
+	r.printTrxFile(in)
+	fmt.Println()
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"sync"
+)
+
+// Summary is a reporter that summarizes all intervals of all stages it reports,
+// like the stdout reporter with --quiet but without printing anything. It's not
+// configurable (not in config.stats.report); --matrix adds one to the server to
+// compare the results of each run.
+type Summary struct {
+	*sync.Mutex
+	in       Instance
+	reported bool
+}
+
+var _ Reporter = &Summary{}
+
+func NewSummary() *Summary {
+	return &Summary{
+		Mutex: &sync.Mutex{},
+		in:    NewInstance("summary"),
+	}
+}
+
+func (r *Summary) Report(from []Instance) {
+	r.Lock()
+	r.in.summarize(from)
+	r.reported = true
+	r.Unlock()
+}
+
+func (r *Summary) Stop() {}
+
+// Instance returns the summary of all intervals reported, and false if none
+// were reported.
+func (r *Summary) Instance() (Instance, bool) {
+	r.Lock()
+	defer r.Unlock()
+	return r.in, r.reported
+}
+
+// summarize accumulates one interval of instances in the summary instance.
+func (in *Instance) summarize(from []Instance) {
+	all := NewInstance("")
+	all.Combine(from)
+	in.Clients = all.Clients
+	in.Target.merge(all.Target, in.Seconds, all.Seconds)
+	in.Seconds += all.Seconds
+	in.Runtime = all.Runtime
+	in.span(all.Start, all.End)
+	in.Total.Combine(all.Total)
+	for name, s := range all.Trx {
+		if _, ok := in.Trx[name]; !ok {
+			in.Trx[name] = NewStats()
+		}
+		in.Trx[name].Combine(s)
+	}
+	in.Progress = all.Progress // latest
+	in.SLO = all.SLO
+	in.InFlight.merge(all.InFlight)
+	in.Warnings = append(in.Warnings, all.Warnings...)
+	in.Events = append(in.Events, all.Events...)
+}
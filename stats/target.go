@@ -0,0 +1,69 @@
+// Copyright 2024 Block, Inc.
+
+package stats
+
+import (
+	"fmt"
+	"strings"
+
+	h "github.com/dustin/go-humanize"
+)
+
+// Target is the rate that rate-limited clients are configured to execute: the
+// sum of each client's share of its lowest QPS and TPS limits, or zero if not
+// rate limited. Comparing it to the achieved rate shows whether clients kept up
+// with the limits: if not, the limits didn't determine the rate.
+type Target struct {
+	QPS float64 `json:"qps,omitempty"`
+	TPS float64 `json:"tps,omitempty"`
+}
+
+// add adds the target of another exec group or instance in the same interval:
+// they run at the same time, so their targets are summed.
+func (t *Target) add(g Target) {
+	t.QPS += g.QPS
+	t.TPS += g.TPS
+}
+
+// merge merges the target of another interval, like the summary of all
+// intervals: the average weighted by interval seconds.
+func (t *Target) merge(g Target, seconds, gSeconds float64) {
+	if seconds+gSeconds == 0 {
+		return
+	}
+	t.QPS = (t.QPS*seconds + g.QPS*gSeconds) / (seconds + gSeconds)
+	t.TPS = (t.TPS*seconds + g.TPS*gSeconds) / (seconds + gSeconds)
+}
+
+// SetTarget sets the target rate of the exec group. Intervals report the sum of
+// targets of exec groups that ran in the interval (Instance.Target). It must be
+// called before StartExecGroup.
+func (c *Collector) SetTarget(execGroup string, t Target) {
+	c.Lock()
+	c.egTarget[execGroup] = t
+	c.Unlock()
+}
+
+// TargetString returns a line about the achieved vs. target QPS and TPS, and
+// refunded TPS allowances (config.stage.workload.tps-refund), or "" if not rate
+// limited.
+func TargetString(in Instance) string {
+	t := in.Target
+	s := in.Total
+	if (t.QPS == 0 && t.TPS == 0 && s.Refunds == 0) || in.Seconds == 0 {
+		return ""
+	}
+	parts := []string{}
+	if t.QPS > 0 {
+		qps := float64(s.N[TOTAL]) / in.Seconds
+		parts = append(parts, fmt.Sprintf("QPS %s of %s (%.1f%%)", h.Comma(int64(qps)), h.Comma(int64(t.QPS)), qps/t.QPS*100))
+	}
+	if t.TPS > 0 {
+		tps := float64(s.N[COMMIT]) / in.Seconds
+		parts = append(parts, fmt.Sprintf("TPS %s of %s (%.1f%%)", h.Comma(int64(tps)), h.Comma(int64(t.TPS)), tps/t.TPS*100))
+	}
+	if s.Refunds > 0 {
+		parts = append(parts, fmt.Sprintf("%s refunded", h.Comma(int64(s.Refunds))))
+	}
+	return fmt.Sprintf("target: %s (%s)", strings.Join(parts, ", "), in.Hostname)
+}
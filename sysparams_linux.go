@@ -0,0 +1,46 @@
+// Copyright 2024 Block, Inc.
+
+//go:build linux
+
+package finch
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// totalRAM returns MemTotal from /proc/meminfo in bytes, or zero if unknown.
+func totalRAM() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// MemTotal:       16337804 kB
+		f := strings.Fields(s.Text())
+		if len(f) < 2 || f[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
+
+// numNUMANodes returns the number of NUMA nodes in /sys/devices/system/node,
+// or 1 if there aren't any (kernel without NUMA support).
+func numNUMANodes() int {
+	nodes, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if len(nodes) == 0 {
+		return 1
+	}
+	return len(nodes)
+}
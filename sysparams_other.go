@@ -0,0 +1,15 @@
+// Copyright 2024 Block, Inc.
+
+//go:build !linux
+
+package finch
+
+// totalRAM is not supported on this platform, so $sys.TOTAL_RAM is not defined.
+func totalRAM() uint64 {
+	return 0
+}
+
+// numNUMANodes returns 1 because NUMA nodes are only detected on Linux.
+func numNUMANodes() int {
+	return 1
+}
@@ -0,0 +1 @@
+SELECT c FROM t WHERE id=@id AND k=@k AND c=@c
@@ -0,0 +1 @@
+SELECT c FROM t WHERE id=@id
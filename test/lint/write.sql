@@ -0,0 +1 @@
+UPDATE t SET c=c+1 WHERE id=@id
@@ -5,8 +5,9 @@ import (
 )
 
 type StatsReporter struct {
-	ReportFunc func([]stats.Instance)
-	StopFunc   func()
+	ReportFunc    func([]stats.Instance)
+	StopFunc      func()
+	ExecGroupFunc func(stats.Instance)
 }
 
 func (r StatsReporter) Make(name string, opts map[string]string) (stats.Reporter, error) {
@@ -24,3 +25,9 @@ func (r StatsReporter) Stop() {
 		r.StopFunc()
 	}
 }
+
+func (r StatsReporter) ReportExecGroup(sum stats.Instance) {
+	if r.ExecGroupFunc != nil {
+		r.ExecGroupFunc(sum)
+	}
+}
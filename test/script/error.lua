@@ -0,0 +1,9 @@
+-- Lua error in event stops the client. The standard libraries that run
+-- commands or read files are not available.
+function event(iter)
+  if os ~= nil or io ~= nil or require ~= nil or module ~= nil or dofile ~= nil or loadfile ~= nil then
+    error("unsafe library available")
+  end
+  finch.query("SELECT 1")
+  error("boom")
+end
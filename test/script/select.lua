@@ -0,0 +1,23 @@
+-- Test script for client.Script: each event reads a random row number in a
+-- MySQL trx, and counts events in a row written by done.
+local n = finch.generator("int", {min = 1, max = 1000})
+local events = 0
+
+function init()
+  finch.exec("CREATE DATABASE IF NOT EXISTS finch")
+  finch.exec("CREATE TABLE IF NOT EXISTS finch.script (id INT PRIMARY KEY, n INT)")
+end
+
+function event(iter)
+  finch.exec("BEGIN")
+  local rows = finch.query("SELECT ? AS n, 'x' AS s", n())
+  if rows[1].n == nil or rows[1][2] ~= "x" then
+    error("bad row")
+  end
+  finch.exec("COMMIT")
+  events = events + 1
+end
+
+function done()
+  finch.exec("REPLACE INTO finch.script VALUES (?, ?)", 1, events)
+end
@@ -0,0 +1,5 @@
+INSERT INTO t_/*!client-number*/ VALUES (1)
+
+SELECT c FROM t_/*!shard 3*/ WHERE id=1
+
+SELECT c FROM t WHERE id=1
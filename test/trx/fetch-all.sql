@@ -0,0 +1,2 @@
+-- fetch-all
+SELECT c FROM t WHERE id BETWEEN 1 AND 100
@@ -0,0 +1,7 @@
+-- trx: isolation=repeatable read, retry=3, weight=5
+
+BEGIN
+
+select c from t where id=1
+
+COMMIT
@@ -0,0 +1,2 @@
+-- if @n % 2 == 0
+select c from t where id=@n
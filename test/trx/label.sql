@@ -0,0 +1,4 @@
+-- label point lookup
+SELECT c FROM t WHERE id=1
+
+SELECT c FROM t WHERE id=2
@@ -0,0 +1,6 @@
+-- save-insert-id: @id
+INSERT INTO t (c) VALUES (1)
+
+-- reader
+-- verify @id
+SELECT c FROM t WHERE id=@id
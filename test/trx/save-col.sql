@@ -0,0 +1,2 @@
+-- save-columns: @id
+SELECT id FROM t LIMIT 1
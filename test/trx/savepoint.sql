@@ -0,0 +1,10 @@
+BEGIN
+
+INSERT INTO t VALUES (1)
+
+-- savepoint sp1
+UPDATE t SET c=2 WHERE id=1
+
+ROLLBACK TO SAVEPOINT sp1
+
+ROLLBACK
@@ -0,0 +1,5 @@
+-- timeout 500ms
+SELECT c FROM t WHERE id=1
+
+-- timeout 2s server
+SELECT c FROM t WHERE id=2
@@ -0,0 +1 @@
+UPDATE t SET c=1 WHERE id = @id
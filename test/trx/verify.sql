@@ -0,0 +1,5 @@
+-- save-insert-id: @id
+INSERT INTO t (c) VALUES (1)
+
+-- verify @id 2s
+SELECT c FROM t WHERE id=@id
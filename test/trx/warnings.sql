@@ -0,0 +1,4 @@
+-- warnings
+INSERT INTO t (c) VALUES (1)
+
+SELECT c FROM t WHERE id=1
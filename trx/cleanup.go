@@ -0,0 +1,109 @@
+// Copyright 2024 Block, Inc.
+
+package trx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Object is a database or table created by a CREATE statement in a trx file.
+// Db is empty for a table if it's unqualified and there's no default database.
+type Object struct {
+	Type string // "DATABASE" or "TABLE"
+	Db   string
+	Name string // table name, or database name if Type is "DATABASE"
+
+	// IfNotExists is true if the object was created with IF NOT EXISTS, so it
+	// might have existed before the benchmark. These objects are not dropped.
+	IfNotExists bool
+}
+
+// ident matches an unquoted or backtick-quoted identifier, which can contain
+// dots and escaped (doubled) backticks.
+const ident = "(`(?:[^`]|``)+`|[\\w$]+)"
+
+var reCreate = regexp.MustCompile("(?is)^\\s*CREATE\\s+(TABLE|DATABASE|SCHEMA)\\s+(IF\\s+NOT\\s+EXISTS\\s+)?" + ident + "(?:\\s*\\.\\s*" + ident + ")?")
+var reUse = regexp.MustCompile("(?is)^\\s*USE\\s+" + ident)
+
+// Created returns the databases and tables created by CREATE statements in
+// the set (in trx and statement order) for finch --cleanup. Unqualified table
+// names are qualified with the database of the last USE statement, else db
+// (config.mysql.db). Temporary tables are ignored because they're dropped on
+// disconnect. Objects created with IF NOT EXISTS are returned with IfNotExists
+// true.
+func (s *Set) Created(db string) []Object {
+	objs := []Object{}
+	for _, trxName := range s.Order {
+		for _, stmt := range s.Statements[trxName] {
+			if m := reUse.FindStringSubmatch(stmt.Query); m != nil {
+				db = unquote(m[1])
+				continue
+			}
+			if !stmt.DDL {
+				continue
+			}
+			m := reCreate.FindStringSubmatch(stmt.Query)
+			if m == nil {
+				continue
+			}
+			typ := strings.ToUpper(m[1])
+			if typ == "SCHEMA" {
+				typ = "DATABASE"
+			}
+			o := Object{Type: typ, IfNotExists: m[2] != ""}
+			switch {
+			case typ == "DATABASE":
+				o.Name = unquote(m[3])
+			case m[4] != "":
+				o.Db = unquote(m[3])
+				o.Name = unquote(m[4])
+			default:
+				o.Db = db
+				o.Name = unquote(m[3])
+			}
+			objs = append(objs, o)
+		}
+	}
+	return objs
+}
+
+// DropStatements returns DROP ... IF EXISTS statements for the objects in
+// reverse order, so objects are dropped in the opposite order they were created.
+// Duplicates, tables in dropped databases, and objects created with IF NOT
+// EXISTS are skipped.
+func DropStatements(objs []Object) []string {
+	dbs := map[string]bool{}
+	for _, o := range objs {
+		if o.Type == "DATABASE" && !o.IfNotExists {
+			dbs[o.Name] = true
+		}
+	}
+	seen := map[Object]bool{}
+	drops := []string{}
+	for i := len(objs) - 1; i >= 0; i-- {
+		o := objs[i]
+		if o.IfNotExists || seen[o] || (o.Type == "TABLE" && dbs[o.Db]) {
+			continue
+		}
+		seen[o] = true
+		name := quote(o.Name)
+		if o.Type == "TABLE" && o.Db != "" {
+			name = quote(o.Db) + "." + name
+		}
+		drops = append(drops, "DROP "+o.Type+" IF EXISTS "+name)
+	}
+	return drops
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '`' || s[len(s)-1] != '`' {
+		return s
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+}
+
+func quote(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Block, Inc.
+
+package trx_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/finch/trx"
+)
+
+func TestCreated(t *testing.T) {
+	set := &trx.Set{
+		Order: []string{"schema.sql", "more.sql"},
+		Statements: map[string][]*trx.Statement{
+			"schema.sql": {
+				{Query: "CREATE DATABASE IF NOT EXISTS bench", DDL: true},
+				{Query: "USE bench"},
+				{Query: "CREATE TABLE t1 (id INT)", DDL: true},
+				{Query: "CREATE TEMPORARY TABLE tmp (id INT)", DDL: true},
+				{Query: "INSERT INTO t1 VALUES (1)", Write: true},
+			},
+			"more.sql": {
+				{Query: "create table if not exists `other`.`t2` (id int)", DDL: true},
+				{Query: "CREATE TABLE t3 (id INT)", DDL: true},
+				{Query: "CREATE INDEX idx ON t3 (id)", DDL: true},
+				{Query: "CREATE TABLE t3 (id INT)", DDL: true},
+				{Query: "CREATE TABLE `db`.`t.x` (id INT)", DDL: true},
+				{Query: "CREATE DATABASE `a.b`", DDL: true},
+				{Query: "CREATE TABLE `a.b`.t4 (id INT)", DDL: true},
+				{Query: "CREATE TABLE `x``y` (id INT)", DDL: true},
+			},
+		},
+	}
+	got := set.Created("test")
+	expect := []trx.Object{
+		{Type: "DATABASE", Name: "bench", IfNotExists: true},
+		{Type: "TABLE", Db: "bench", Name: "t1"},
+		{Type: "TABLE", Db: "other", Name: "t2", IfNotExists: true},
+		{Type: "TABLE", Db: "bench", Name: "t3"},
+		{Type: "TABLE", Db: "bench", Name: "t3"},
+		{Type: "TABLE", Db: "db", Name: "t.x"},
+		{Type: "DATABASE", Name: "a.b"},
+		{Type: "TABLE", Db: "a.b", Name: "t4"},
+		{Type: "TABLE", Db: "bench", Name: "x`y"},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Reverse order, no duplicates, t4 is dropped with database a.b, and
+	// IF NOT EXISTS objects (bench and t2) are not dropped
+	drops := trx.DropStatements(got)
+	expectDrops := []string{
+		"DROP TABLE IF EXISTS `bench`.`x``y`",
+		"DROP DATABASE IF EXISTS `a.b`",
+		"DROP TABLE IF EXISTS `db`.`t.x`",
+		"DROP TABLE IF EXISTS `bench`.`t3`",
+		"DROP TABLE IF EXISTS `bench`.`t1`",
+	}
+	if diff := deep.Equal(drops, expectDrops); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Block, Inc.
+
+package trx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Cond is a condition for executing a statement: "-- if @d OP N" or
+// "-- if @d % M OP N". The statement is executed only if the condition is true
+// for the current value of data key @d.
+type Cond struct {
+	Input string // data key
+	Mod   int64  // if > 0, compare @d % Mod
+	Op    string // ==, !=, <, <=, >, >=
+	Value int64
+}
+
+var reCond = regexp.MustCompile(`^(@[\w_-]+)\s*(?:%\s*(\d+)\s*)?(==|!=|<=|>=|<|>)\s*(-?\d+)$`)
+
+// ParseCond parses the condition of an "if" modifier, like "@d % 10 == 0".
+func ParseCond(s string) (*Cond, error) {
+	m := reCond.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid condition: '%s': expected '@d OP N' or '@d %% M OP N' where OP is ==, !=, <, <=, >, or >=", s)
+	}
+	c := &Cond{
+		Input: m[1],
+		Op:    m[3],
+	}
+	if m[2] != "" {
+		mod, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: '%s': %s", s, err)
+		}
+		if mod == 0 {
+			return nil, fmt.Errorf("invalid condition: '%s': modulo zero", s)
+		}
+		c.Mod = mod
+	}
+	v, err := strconv.ParseInt(m[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition: '%s': %s", s, err)
+	}
+	c.Value = v
+	return c, nil
+}
+
+// True returns true if the condition is true for data value v. If v is not
+// an integer, the condition is false.
+func (c *Cond) True(v interface{}) bool {
+	n, ok := toInt64(v)
+	if !ok {
+		return false
+	}
+	if c.Mod > 0 {
+		n %= c.Mod
+	}
+	switch c.Op {
+	case "==":
+		return n == c.Value
+	case "!=":
+		return n != c.Value
+	case "<":
+		return n < c.Value
+	case "<=":
+		return n <= c.Value
+	case ">":
+		return n > c.Value
+	case ">=":
+		return n >= c.Value
+	}
+	return false
+}
+
+func (c *Cond) String() string {
+	if c.Mod > 0 {
+		return fmt.Sprintf("%s %% %d %s %d", c.Input, c.Mod, c.Op, c.Value)
+	}
+	return fmt.Sprintf("%s %s %d", c.Input, c.Op, c.Value)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case uint:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case []byte: // saved column
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Block, Inc.
+
+package trx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Header is the trx header: a "-- trx: key=value, ..." modifier before the first
+// statement in a trx file that applies to the whole trx.
+type Header struct {
+	Isolation string // SET TRANSACTION ISOLATION LEVEL before trx
+	Retry     uint   // retry trx N times on error
+	Weight    uint   // same as config.Trx.Weight (stage file takes precedence)
+}
+
+var isolationLevels = []string{"READ UNCOMMITTED", "READ COMMITTED", "REPEATABLE READ", "SERIALIZABLE"}
+
+// ParseHeader parses the trx header without the "trx:" prefix, like
+// "isolation=REPEATABLE READ, retry=3, weight=5".
+func ParseHeader(s string) (Header, error) {
+	var h Header
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return h, fmt.Errorf("invalid trx header: '%s': expected key=value", kv)
+		}
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		switch k {
+		case "isolation":
+			v = strings.ToUpper(strings.Join(strings.Fields(v), " "))
+			valid := false
+			for _, l := range isolationLevels {
+				if v == l {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return h, fmt.Errorf("invalid trx header: isolation=%s: valid values: %s", v, strings.Join(isolationLevels, ", "))
+			}
+			h.Isolation = v
+		case "retry", "weight":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return h, fmt.Errorf("invalid trx header: %s=%s: %s", k, v, err)
+			}
+			if k == "retry" {
+				h.Retry = uint(n)
+			} else {
+				h.Weight = uint(n)
+			}
+		default:
+			return h, fmt.Errorf("invalid trx header: unknown key: %s (valid: isolation, retry, weight)", k)
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Block, Inc.
+
+package trx
+
+import (
+	"strings"
+
+	"github.com/square/finch"
+	"github.com/square/finch/config"
+	"github.com/square/finch/replay"
+)
+
+// loadReplay loads a MySQL log (config.Trx.Replay) into the set: each session
+// (connection) in the log is a trx named "NAME/ID" where NAME is the trx name
+// and ID is the connection ID. Queries are executed as-is: they're not parsed
+// for data keys or modifiers. If timing is enabled, an idle statement before
+// each query sleeps for the time between queries in the original session.
+func loadReplay(cfg config.Trx, set *Set) error {
+	finch.Debug("loading replay %s (%s)", cfg.File, cfg.Replay.Format)
+	sessions, err := replay.Load(cfg.File, cfg.Replay.Format)
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		name := cfg.Name + "/" + sess.Id
+		stmts := make([]*Statement, 0, len(sess.Queries))
+		hasDDL := false
+		for i, q := range sess.Queries {
+			// Idle between, never before first or after last, statement
+			// because the first and last statement mark the trx boundaries
+			if cfg.Replay.Timing && i > 0 && !q.Ts.IsZero() {
+				if d := q.Ts.Sub(sess.Queries[i-1].Ts); d > 0 {
+					stmts = append(stmts, &Statement{Trx: name, Idle: d})
+				}
+			}
+			s := &Statement{
+				Trx: name,
+				// Client always formats queries (fmt.Sprintf), so escape % in
+				// literal values like LIKE 'foo%'
+				Query: strings.ReplaceAll(q.SQL, "%", "%%"),
+			}
+			if setType(s, q.SQL) {
+				hasDDL = true
+			}
+			stmts = append(stmts, s)
+		}
+		set.Order = append(set.Order, name)
+		set.Statements[name] = stmts
+		set.Meta[name] = Meta{
+			DDL:    hasDDL,
+			Replay: true,
+		}
+		finch.Debug("replay %s: %d queries", name, len(sess.Queries))
+	}
+	return nil
+}
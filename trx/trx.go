@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -41,25 +42,49 @@ type Set struct {
 
 // Statement is one query in a transaction and all its read-only metadata.
 type Statement struct {
-	Trx          string
-	Query        string
-	ResultSet    bool
-	Prepare      bool
-	PrepareMulti int
-	Begin        bool
-	Commit       bool
-	Write        bool
-	DDL          bool
-	Idle         time.Duration
-	Inputs       []string // data keys (number of values)
-	Outputs      []string // data keys save-results|columns and save-insert-id
-	InsertId     string   // data key (special output)
-	Limit        limit.Data
-	Calls        []byte
+	Trx           string
+	Query         string
+	ResultSet     bool
+	Prepare       bool
+	PrepareMulti  int
+	Begin         bool
+	Commit        bool
+	Rollback      bool
+	Write         bool
+	DDL           bool
+	Idle          time.Duration
+	Inputs        []string // data keys (number of values)
+	Outputs       []string // data keys save-results|columns and save-insert-id
+	InsertId      string   // data key (special output)
+	Limit         limit.Data
+	Calls         []byte
+	If            *Cond         // -- if
+	Savepoint     string        // -- savepoint
+	Verify        string        // -- verify: data key that must return a row
+	VerifyTimeout time.Duration // -- verify: max wait for row to be visible
+	Reader        bool          // -- reader: execute on mysql.reader
+	Timeout       time.Duration // -- timeout: client-side context deadline
+	FetchAll      bool          // -- fetch-all: read and count all rows
+	Warnings      bool          // -- warnings: SHOW WARNINGS after executing
+	Label         string        // -- label: stats series across trx files
+	CSV           *CSV          // /*!csv N template*/ row batch
+}
+
+// CSV is a /*!csv N template*/ row batch. Statement.Query is Prefix, then Rows
+// copies of Row separated by ", ", then Suffix. The client uses this to format
+// the rows with one compiled row template instead of the whole expanded query.
+type CSV struct {
+	Rows   uint
+	Prefix string
+	Row    string
+	Suffix string
 }
 
 type Meta struct {
-	DDL bool
+	DDL    bool
+	Replay bool // trx is one connection from a replayed log (config.Trx.Replay)
+	Weight uint // config.Trx.Weight or Header.Weight, or 0 if not set
+	Retry  uint // Header.Retry
 }
 
 // Load loads all trx files and returns a Set representing all parsed trx.
@@ -75,16 +100,43 @@ func Load(trxFiles []config.Trx, scope *data.Scope, params map[string]string) (*
 		Data:       scope,
 		Meta:       map[string]Meta{},
 	}
+	colRefs := map[string]int{} // saved column refs in all trx files
 	for i := range trxFiles {
-		if err := NewFile(trxFiles[i], set, params).Load(); err != nil {
+		if trxFiles[i].Replay != nil {
+			if err := loadReplay(trxFiles[i], set); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file := NewFile(trxFiles[i], set, params)
+		if err := file.Load(); err != nil {
 			return nil, err
 		}
+		for col, refs := range file.colRefs {
+			colRefs[col] += refs
+		}
+	}
+
+	// Saved columns with a cross-trx scope can be used in later trx files, so
+	// File.Load doesn't check them; check them after loading all trx files
+	noRefs := []string{}
+	for col, refs := range colRefs {
+		if refs == 0 {
+			noRefs = append(noRefs, col)
+		}
+	}
+	if len(noRefs) > 0 {
+		sort.Strings(noRefs)
+		return nil, fmt.Errorf("saved columns not referenced in any trx file: %s", strings.Join(noRefs, ", "))
 	}
 	return set, nil
 }
 
 var ErrEOF = fmt.Errorf("EOF")
 
+// DefaultVerifyTimeout is the default max wait for a row to be visible (-- verify).
+const DefaultVerifyTimeout = 5 * time.Second
+
 type lineBuf struct {
 	n      uint
 	str    string
@@ -105,6 +157,7 @@ type File struct {
 	stmtNo  uint           // 1-indexed in file (not a line number; not an index into stmt)
 	stmts   []*Statement   // all statements in this file
 	hasDDL  bool           // true if any statement is DDL
+	header  *Header        // -- trx: header, if any
 }
 
 func NewFile(cfg config.Trx, set *Set, params map[string]string) *File {
@@ -148,24 +201,41 @@ func (f *File) Load() error {
 
 	noRefs := []string{}
 	for col, refs := range f.colRefs {
-		if refs > 0 {
-			continue
+		if refs > 0 || crossTrx(f.set.Data.Keys[col].Scope) {
+			continue // referenced, or can be referenced in a later trx file
 		}
 		noRefs = append(noRefs, col)
 	}
 	if len(noRefs) > 0 {
-		return fmt.Errorf("saved columns not referenced: %s", strings.Join(noRefs, ", "))
+		return fmt.Errorf("saved columns not referenced: %s (to use in another trx file, set data scope iter, client, or greater)", strings.Join(noRefs, ", "))
 	}
 
 	if err := scanner.Err(); err != nil {
 		log.Fatal(err) // shouldn't happen
 	}
 
+	meta := Meta{
+		DDL:    f.hasDDL,
+		Weight: finch.Uint(f.cfg.Weight),
+	}
+	if h := f.header; h != nil {
+		if h.Isolation != "" {
+			// Applies only to the next MySQL trx, so it's the first statement
+			s := &Statement{
+				Trx:   f.cfg.Name,
+				Query: "SET TRANSACTION ISOLATION LEVEL " + h.Isolation,
+			}
+			f.stmts = append([]*Statement{s}, f.stmts...)
+		}
+		if meta.Weight == 0 {
+			meta.Weight = h.Weight // stage file takes precedence
+		}
+		meta.Retry = h.Retry
+	}
+
 	f.set.Order = append(f.set.Order, f.cfg.Name)
 	f.set.Statements[f.cfg.Name] = f.stmts
-	f.set.Meta[f.cfg.Name] = Meta{
-		DDL: f.hasDDL,
-	}
+	f.set.Meta[f.cfg.Name] = meta
 
 	return nil
 }
@@ -184,6 +254,17 @@ func (f *File) line(line string) error {
 			if err != nil {
 				return fmt.Errorf("parsing modifier '%s' on line %d: %s", line, f.lb.n, err)
 			}
+			if strings.HasPrefix(mod, "trx:") {
+				if f.stmtNo > 0 || f.lb.str != "" || f.header != nil {
+					return fmt.Errorf("trx header on line %d must be before the first statement and not repeated", f.lb.n)
+				}
+				h, err := ParseHeader(strings.TrimPrefix(mod, "trx:"))
+				if err != nil {
+					return fmt.Errorf("line %d: %s", f.lb.n, err)
+				}
+				f.header = &h
+				return nil
+			}
 			f.lb.mods = append(f.lb.mods, mod)
 		} else {
 			f.lb.str += line + " "
@@ -216,7 +297,10 @@ func (f *File) line(line string) error {
 
 var reKeyVal = regexp.MustCompile(`([\w_-]+)(?:\:\s*(\w+))?`)
 var reCSV = regexp.MustCompile(`\/\*\!csv\s+(\d+)\s+(.+)\*\/`)
+var reShard = regexp.MustCompile(`\/\*\!shard\s+(\d+)\s*\*\/`)
 var reFirstWord = regexp.MustCompile(`^(\w+)`)
+var reRollbackTo = regexp.MustCompile(`(?i)^ROLLBACK\s+(?:WORK\s+)?TO\s`)
+var reSavepoint = regexp.MustCompile(`^\w+$`)
 
 func (f *File) statements() ([]*Statement, error) {
 	f.stmtNo++
@@ -231,20 +315,7 @@ func (f *File) statements() ([]*Statement, error) {
 	// Switches
 	// ----------------------------------------------------------------------
 
-	// @todo regexp to extract first word
-	com := strings.ToUpper(reFirstWord.FindString(query))
-	switch com {
-	case "SELECT":
-		s.ResultSet = true
-	case "BEGIN", "START":
-		s.Begin = true // used to rate limit trx per second (TPS) in client/client.go
-	case "COMMIT":
-		s.Commit = true // used to measure TPS rate in client/client.go
-	case "INSERT", "UPDATE", "DELETE", "REPLACE":
-		s.Write = true
-	case "ALTER", "CREATE", "DROP", "RENAME", "TRUNCATE":
-		finch.Debug("DDL")
-		s.DDL = true    // statement is DDL
+	if setType(s, query) {
 		f.hasDDL = true // trx has DDL
 	}
 
@@ -319,6 +390,79 @@ func (f *File) statements() ([]*Statement, error) {
 				}
 				s.Outputs = append(s.Outputs, dataKey)
 			}
+		case "if":
+			cond, err := ParseCond(strings.Join(m[1:], " "))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.generator(cond.Input); err != nil {
+				return nil, err
+			}
+			s.If = cond
+		case "savepoint":
+			s.Savepoint = "finch"
+			if len(m) > 1 {
+				if !reSavepoint.MatchString(m[1]) {
+					return nil, fmt.Errorf("invalid savepoint name: %s", m[1])
+				}
+				s.Savepoint = m[1]
+			}
+		case "verify":
+			if !s.ResultSet {
+				return nil, fmt.Errorf("verify only allowed on SELECT")
+			}
+			if len(m) < 2 || len(m) > 3 {
+				return nil, fmt.Errorf("invalid verify modifier: '%s': expected 'verify @d [TIMEOUT]'", mod)
+			}
+			s.Verify = m[1]
+			s.VerifyTimeout = DefaultVerifyTimeout
+			if len(m) == 3 {
+				d, err := time.ParseDuration(m[2])
+				if err != nil {
+					return nil, fmt.Errorf("invalid verify timeout: '%s': %s", mod, err)
+				}
+				s.VerifyTimeout = d
+			}
+		case "timeout":
+			if len(m) < 2 || len(m) > 3 || (len(m) == 3 && m[2] != "server" && m[2] != "client") {
+				return nil, fmt.Errorf("invalid timeout modifier: '%s': expected 'timeout DURATION [client|server]'", mod)
+			}
+			d, err := time.ParseDuration(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: '%s': %s", mod, err)
+			}
+			if d < time.Millisecond {
+				return nil, fmt.Errorf("invalid timeout: '%s': must be >= 1ms", mod)
+			}
+			if len(m) == 3 && m[2] == "server" {
+				// MAX_EXECUTION_TIME only applies to read-only SELECT
+				if !s.ResultSet {
+					return nil, fmt.Errorf("timeout server only allowed on SELECT")
+				}
+				query = query[:len("SELECT")] + fmt.Sprintf(" /*+ MAX_EXECUTION_TIME(%d) */", d.Milliseconds()) + query[len("SELECT"):]
+			} else {
+				s.Timeout = d
+			}
+		case "fetch-all":
+			if !s.ResultSet {
+				return nil, fmt.Errorf("fetch-all only allowed on SELECT")
+			}
+			s.FetchAll = true
+		case "warnings":
+			if s.Begin || s.Commit || s.Rollback {
+				return nil, fmt.Errorf("warnings not allowed on BEGIN, COMMIT, or ROLLBACK")
+			}
+			s.Warnings = true
+		case "label":
+			if len(m) < 2 {
+				return nil, fmt.Errorf("invalid label modifier: '%s': expected 'label NAME'", mod)
+			}
+			s.Label = strings.Join(m[1:], " ")
+		case "reader":
+			if s.Begin || s.Commit || s.Rollback || s.DDL {
+				return nil, fmt.Errorf("reader not allowed on BEGIN, COMMIT, ROLLBACK, or DDL")
+			}
+			s.Reader = true
 		case "copies":
 			n, err := strconv.Atoi(m[1])
 			if err != nil {
@@ -371,10 +515,19 @@ func (f *File) statements() ([]*Statement, error) {
 	// ----------------------------------------------------------------------
 	query = strings.ReplaceAll(query, finch.COPY_NUMBER, fmt.Sprintf("%d", f.lb.copyNo))
 
+	// /*!client-number*/ and /*!shard N*/ are replaced per client (ForClient)
+	// because statements are shared by all clients, so only validate N here
+	for _, m := range reShard.FindAllStringSubmatch(query, -1) {
+		if n, err := strconv.ParseUint(m[1], 10, 32); err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid %s: N must be an integer > 0", m[0])
+		}
+	}
+
 	// ----------------------------------------------------------------------
 	// Expand CSV /*!csv N template*/
 	// ----------------------------------------------------------------------
 	csvTemplate := ""
+	var csv *CSV
 	m := reCSV.FindStringSubmatch(query)
 	if len(m) > 0 {
 		n, err := strconv.ParseInt(m[1], 10, 32)
@@ -414,8 +567,14 @@ func (f *File) statements() ([]*Statement, error) {
 		for i := int64(0); i < n; i++ {
 			vals[i] = csvTemplateScoped
 		}
-		csv := strings.Join(vals, ", ")
-		query = reCSV.ReplaceAllLiteralString(query, csv)
+		loc := reCSV.FindStringIndex(query)
+		csv = &CSV{
+			Rows:   uint(n),
+			Prefix: query[:loc[0]],
+			Row:    csvTemplateScoped,
+			Suffix: query[loc[1]:],
+		}
+		query = reCSV.ReplaceAllLiteralString(query, strings.Join(vals, ", "))
 	}
 
 	// ----------------------------------------------------------------------
@@ -423,6 +582,15 @@ func (f *File) statements() ([]*Statement, error) {
 	// ----------------------------------------------------------------------
 	dataKeys := DataKeyPattern.FindAllString(query, -1)
 	finch.Debug("data keys: %v", dataKeys)
+	if s.Verify != "" {
+		used := false
+		for _, k := range dataKeys {
+			used = used || strings.TrimSuffix(k, EXPLICIT_CALL_SUFFIX) == s.Verify
+		}
+		if !used {
+			return nil, fmt.Errorf("verify data key %s not used in statement", s.Verify)
+		}
+	}
 	if len(dataKeys) == 0 {
 		s.Query = query
 		return []*Statement{s}, nil // no data key, return early
@@ -430,9 +598,10 @@ func (f *File) statements() ([]*Statement, error) {
 	s.Inputs = dataKeys
 
 	s.Calls = Calls(s.Inputs)
-	query = ExplicitCallPattern.ReplaceAllStringFunc(query, func(s string) string {
+	trimCalls := func(s string) string {
 		return strings.TrimSuffix(s, EXPLICIT_CALL_SUFFIX)
-	})
+	}
+	query = ExplicitCallPattern.ReplaceAllStringFunc(query, trimCalls)
 
 	dataFormats := map[string]string{} // keyed on data name
 	for i, name := range s.Inputs {
@@ -443,10 +612,7 @@ func (f *File) statements() ([]*Statement, error) {
 		var g data.Generator
 		var err error
 
-		if k, ok := f.set.Data.Keys[name]; ok && k.Column >= 0 {
-			f.colRefs[name]++
-			g = k.Generator
-		} else if name == "@PREV" {
+		if name == "@PREV" {
 			if i == 0 {
 				return nil, fmt.Errorf("no @PREV data generator")
 			}
@@ -459,38 +625,9 @@ func (f *File) statements() ([]*Statement, error) {
 				break
 			}
 		} else {
-			if k, ok = f.set.Data.Keys[name]; ok {
-				g = k.Generator
-			} else {
-				dataCfg, ok := f.cfg.Data[cfgKey(name)] // config.stage.trx[].data
-				if !ok {
-					return nil, fmt.Errorf("%s not configured: trx file uses %s but this data key is not configured in the stage file", name, name)
-				}
-				finch.Debug("make data generator: %s %s scope: %s", dataCfg.Generator, name, dataCfg.Scope)
-
-				if dataCfg.Scope == "" {
-					dataCfg.Scope = finch.SCOPE_STATEMENT
-					f.cfg.Data[name] = dataCfg
-				}
-
-				g, err = data.Make(
-					dataCfg.Generator, // e.g. "auto-inc"
-					name,              // @d
-					dataCfg.Params,    // trx[].data.params, generator-specific
-				)
-				if err != nil {
-					return nil, err
-				}
-				f.set.Data.Keys[name] = data.Key{
-					Name:      name,
-					Trx:       f.cfg.Name,
-					Line:      f.lb.n - 1,
-					Statement: f.stmtNo,
-					Column:    -1,
-					Scope:     dataCfg.Scope,
-					Generator: g,
-				}
-				finch.Debug("%#v", k)
+			g, err = f.generator(name)
+			if err != nil {
+				return nil, err
 			}
 		}
 
@@ -512,10 +649,145 @@ func (f *File) statements() ([]*Statement, error) {
 	r := strings.NewReplacer(replacements...)
 	s.Query = r.Replace(query)
 
+	// Same for CSV row batch parts so Prefix + Rows * Row + Suffix = Query
+	if csv != nil {
+		csv.Prefix = r.Replace(ExplicitCallPattern.ReplaceAllStringFunc(csv.Prefix, trimCalls))
+		csv.Row = r.Replace(ExplicitCallPattern.ReplaceAllStringFunc(csv.Row, trimCalls))
+		csv.Suffix = r.Replace(ExplicitCallPattern.ReplaceAllStringFunc(csv.Suffix, trimCalls))
+		s.CSV = csv
+	}
+
 	// Caller debug prints full Statement
 	return []*Statement{s}, nil
 }
 
+// generator returns the data generator for data key name (with @). If the data
+// key is new, it makes the generator from the stage file config.
+func (f *File) generator(name string) (data.Generator, error) {
+	if k, ok := f.set.Data.Keys[name]; ok {
+		if k.Column >= 0 {
+			if k.Trx != f.cfg.Name && !crossTrx(k.Scope) {
+				return nil, fmt.Errorf("saved column %s is trx scoped in trx file %s: set its data scope to iter, client, or greater to use it in another trx file", name, k.Trx)
+			}
+			f.colRefs[name]++ // saved column
+		}
+		return k.Generator, nil
+	}
+
+	dataCfg, ok := f.cfg.Data[cfgKey(name)] // config.stage.trx[].data
+	if !ok {
+		return nil, fmt.Errorf("%s not configured: trx file uses %s but this data key is not configured in the stage file", name, name)
+	}
+	if dataCfg.Generator == "column" {
+		return nil, fmt.Errorf("saved column %s used before it's saved: the statement (or trx file) that saves it must be first", name)
+	}
+	finch.Debug("make data generator: %s %s scope: %s", dataCfg.Generator, name, dataCfg.Scope)
+
+	if dataCfg.Scope == "" {
+		dataCfg.Scope = finch.SCOPE_STATEMENT
+		f.cfg.Data[name] = dataCfg
+	}
+
+	g, err := data.Make(
+		dataCfg.Generator, // e.g. "auto-inc"
+		name,              // @d
+		dataCfg.Params,    // trx[].data.params, generator-specific
+	)
+	if err != nil {
+		return nil, err
+	}
+	k := data.Key{
+		Name:      name,
+		Trx:       f.cfg.Name,
+		Line:      f.lb.n - 1,
+		Statement: f.stmtNo,
+		Column:    -1,
+		Scope:     dataCfg.Scope,
+		Generator: g,
+	}
+	f.set.Data.Keys[name] = k
+	finch.Debug("%#v", k)
+
+	// Derived data key: make the data key it's derived from, if it's not used
+	// in a trx file, because the derived generator is bound to its copies
+	if d, ok := g.(interface{ From() string }); ok && d.From() != "" {
+		from, err := f.generator(d.From())
+		if err != nil {
+			return nil, fmt.Errorf("%s derived from %s: %s", name, d.From(), err)
+		}
+		if fd, ok := from.(interface{ From() string }); ok && fd.From() != "" {
+			return nil, fmt.Errorf("%s derived from %s: cannot derive from another derived data key", name, d.From())
+		}
+	}
+	return g, nil
+}
+
+// ForClient returns the statement for client number clientNo (RunLevel.Client)
+// with /*!client-number*/ and /*!shard N*/ replaced. Statements are shared by
+// all clients, so it returns a copy if the query has either, else s.
+func (s *Statement) ForClient(clientNo uint) *Statement {
+	if !strings.Contains(s.Query, finch.CLIENT_NUMBER) && !reShard.MatchString(s.Query) {
+		return s
+	}
+	c := *s
+	c.Query = clientQuery(s.Query, clientNo)
+	if s.CSV != nil {
+		csv := *s.CSV
+		csv.Prefix = clientQuery(csv.Prefix, clientNo)
+		csv.Row = clientQuery(csv.Row, clientNo)
+		csv.Suffix = clientQuery(csv.Suffix, clientNo)
+		c.CSV = &csv
+	}
+	return &c
+}
+
+// clientQuery replaces /*!client-number*/ with clientNo and /*!shard N*/ with
+// the shard number 1..N for clientNo: clients 1..N are shards 1..N, client
+// N+1 is shard 1 again, and so on.
+func clientQuery(query string, clientNo uint) string {
+	query = strings.ReplaceAll(query, finch.CLIENT_NUMBER, strconv.FormatUint(uint64(clientNo), 10))
+	return reShard.ReplaceAllStringFunc(query, func(m string) string {
+		n, _ := strconv.ParseUint(reShard.FindStringSubmatch(m)[1], 10, 32) // validated in statements
+		shard := uint64(1)
+		if clientNo > 0 {
+			shard = (uint64(clientNo)-1)%n + 1
+		}
+		return strconv.FormatUint(shard, 10)
+	})
+}
+
+// Type returns a statement with only its type (ResultSet, Begin, etc.) set from
+// the first word of the query, like statements in trx files. It's used for
+// queries that aren't in trx files: queries executed by a client.Script.
+func Type(query string) Statement {
+	var s Statement
+	setType(&s, strings.TrimSpace(query))
+	return s
+}
+
+// setType sets the statement type (s.ResultSet, s.Begin, etc.) from the first
+// word of the query. It returns true if the statement is DDL.
+func setType(s *Statement, query string) bool {
+	com := strings.ToUpper(reFirstWord.FindString(query))
+	switch com {
+	case "SELECT":
+		s.ResultSet = true
+	case "BEGIN", "START":
+		s.Begin = true // used to rate limit trx per second (TPS) in client/client.go
+	case "COMMIT":
+		s.Commit = true // used to measure TPS rate in client/client.go
+	case "ROLLBACK":
+		// Not ROLLBACK TO SAVEPOINT, which doesn't end the MySQL trx
+		s.Rollback = !reRollbackTo.MatchString(query)
+	case "INSERT", "UPDATE", "DELETE", "REPLACE":
+		s.Write = true
+	case "ALTER", "CREATE", "DROP", "RENAME", "TRUNCATE":
+		finch.Debug("DDL")
+		s.DDL = true // statement is DDL
+	}
+	return s.DDL
+}
+
 func (f *File) column(colNo int, col string) (string, error) {
 	col = strings.TrimSpace(strings.TrimSuffix(col, ","))
 	finch.Debug("col %s %d", col, colNo)
@@ -552,6 +824,13 @@ func (f *File) column(colNo int, col string) (string, error) {
 		fmt.Printf("No data params for column %s (%s line %d), default to non-quoted value\n", col, f.cfg.Name, f.lb.n-1)
 	}
 
+	// Saved columns are trx scoped by default. Less than trx doesn't work
+	// because the statements that save and use the column get different copies.
+	if dataCfg.Scope != "" && (!finch.RunLevelIsValid(dataCfg.Scope) ||
+		finch.RunLevelNumber(dataCfg.Scope) < finch.RunLevelNumber(finch.SCOPE_TRX)) {
+		return "", fmt.Errorf("saved column %s: invalid scope: %s: must be trx (default), iter, client, client-group, exec-group, workload, stage, or global", col, dataCfg.Scope)
+	}
+
 	g, err := data.Make("column", col, dataCfg.Params)
 	if err != nil {
 		return "", err
@@ -570,6 +849,12 @@ func (f *File) column(colNo int, col string) (string, error) {
 	return col, nil
 }
 
+// crossTrx returns true if the data scope is greater than trx (iter, client,
+// and so on), which lets a saved column be used in other trx files.
+func crossTrx(scope string) bool {
+	return finch.RunLevelIsValid(scope) && finch.RunLevelNumber(scope) > finch.RunLevelNumber(finch.SCOPE_TRX)
+}
+
 func Calls(dataKeys []string) []byte {
 	calls := make([]byte, len(dataKeys))
 	for i, name := range dataKeys {
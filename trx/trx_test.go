@@ -4,6 +4,7 @@ package trx_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
@@ -387,6 +388,11 @@ func TestLoad_RowScopeCSV(t *testing.T) {
 					Inputs:    []string{"@d", "@d", "@d", "@d", "@d", "@d", "@d", "@d"},
 					Calls:     []byte{1, 0, 0, 0, 1, 0, 0, 0},
 					ResultSet: true,
+					CSV: &trx.CSV{
+						Rows:   2,
+						Prefix: "SELECT 1 -- ",
+						Row:    "(%d, %d %% 1000, '%d', '%d')",
+					},
 				},
 
 				{
@@ -395,6 +401,11 @@ func TestLoad_RowScopeCSV(t *testing.T) {
 					Inputs:    []string{"@d", "@d", "@d", "@d", "@d", "@d", "@d", "@d"},
 					Calls:     []byte{1, 0, 0, 0, 1, 0, 0, 0},
 					ResultSet: true,
+					CSV: &trx.CSV{
+						Rows:   2,
+						Prefix: "SELECT 1 -- ",
+						Row:    "(%d, %d %% 1000, '%d', '%d')",
+					},
 				},
 			},
 		},
@@ -470,3 +481,347 @@ func TestRowScope(t *testing.T) {
 		}
 	}
 }
+
+func TestLoad_Replay(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name:   "general.log",
+			File:   "../test/replay/general.log",
+			Replay: &config.Replay{Format: "general", Timing: true},
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectOrder := []string{"general.log/10", "general.log/11"}
+	if diff := deep.Equal(got.Order, expectOrder); diff != nil {
+		t.Error(diff)
+	}
+
+	expect := []*trx.Statement{
+		{Trx: "general.log/10", Query: "USE `test`"},
+		{Trx: "general.log/10", Idle: 100 * time.Millisecond},
+		{Trx: "general.log/10", Query: "SELECT c FROM t WHERE id=1", ResultSet: true},
+		{Trx: "general.log/10", Idle: 200 * time.Millisecond},
+		{Trx: "general.log/10", Query: "SELECT c\nFROM t\nWHERE id=3", ResultSet: true},
+	}
+	if diff := deep.Equal(got.Statements["general.log/10"], expect); diff != nil {
+		t.Error(diff)
+	}
+
+	expect = []*trx.Statement{
+		{Trx: "general.log/11", Query: "UPDATE t SET c='50%%' WHERE id=2", Write: true},
+	}
+	if diff := deep.Equal(got.Statements["general.log/11"], expect); diff != nil {
+		t.Error(diff)
+	}
+
+	if !got.Meta["general.log/10"].Replay {
+		t.Error("Meta.Replay = false, expected true")
+	}
+}
+
+func TestCond(t *testing.T) {
+	tests := []struct {
+		cond   string
+		v      interface{}
+		expect bool
+	}{
+		{"@d % 10 == 0", int64(20), true},
+		{"@d % 10 == 0", int64(21), false},
+		{"@d%3!=1", uint64(4), false},
+		{"@d < 5", int64(4), true},
+		{"@d >= 5", []byte("5"), true}, // saved column
+		{"@d > -1", "x", false},        // not an integer
+	}
+	for _, test := range tests {
+		c, err := trx.ParseCond(test.cond)
+		if err != nil {
+			t.Errorf("%s: %s", test.cond, err)
+			continue
+		}
+		if got := c.True(test.v); got != test.expect {
+			t.Errorf("%s with %v = %t, expected %t", test.cond, test.v, got, test.expect)
+		}
+	}
+
+	for _, bad := range []string{"@d", "@d = 1", "@d % 0 == 1", "d == 1"} {
+		if _, err := trx.ParseCond(bad); err == nil {
+			t.Errorf("%s: no error, expected an error", bad)
+		}
+	}
+}
+
+func TestLoad_Header(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "header.sql",
+			File: "../test/trx/header.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []*trx.Statement{
+		{Trx: "header.sql", Query: "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"},
+		{Trx: "header.sql", Query: "BEGIN", Begin: true},
+		{Trx: "header.sql", Query: "select c from t where id=1", ResultSet: true},
+		{Trx: "header.sql", Query: "COMMIT", Commit: true},
+	}
+	if diff := deep.Equal(got.Statements["header.sql"], expect); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(got.Meta["header.sql"], trx.Meta{Weight: 5, Retry: 3}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Weight in stage file takes precedence
+	trxList[0].Weight = "2"
+	got, err = trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta["header.sql"].Weight != 2 {
+		t.Errorf("weight %d, expected 2 (stage file)", got.Meta["header.sql"].Weight)
+	}
+}
+
+func TestLoad_Savepoint(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "savepoint.sql",
+			File: "../test/trx/savepoint.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []*trx.Statement{
+		{Trx: "savepoint.sql", Query: "BEGIN", Begin: true},
+		{Trx: "savepoint.sql", Query: "INSERT INTO t VALUES (1)", Write: true},
+		{Trx: "savepoint.sql", Query: "UPDATE t SET c=2 WHERE id=1", Write: true, Savepoint: "sp1"},
+		{Trx: "savepoint.sql", Query: "ROLLBACK TO SAVEPOINT sp1"}, // doesn't end MySQL trx
+		{Trx: "savepoint.sql", Query: "ROLLBACK", Rollback: true},
+	}
+	if diff := deep.Equal(got.Statements["savepoint.sql"], expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoad_Verify(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "verify.sql",
+			File: "../test/trx/verify.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := got.Statements["verify.sql"][1]
+	if s.Verify != "@id" {
+		t.Errorf("Verify = '%s', expected @id", s.Verify)
+	}
+	if s.VerifyTimeout != 2*time.Second {
+		t.Errorf("VerifyTimeout = %s, expected 2s", s.VerifyTimeout)
+	}
+}
+
+func TestLoad_Reader(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "reader.sql",
+			File: "../test/trx/reader.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := got.Statements["reader.sql"]
+	if stmts[0].Reader {
+		t.Errorf("INSERT Reader = true, expected false")
+	}
+	if !stmts[1].Reader {
+		t.Errorf("SELECT Reader = false, expected true")
+	}
+}
+
+func TestLoad_Label(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "label.sql",
+			File: "../test/trx/label.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*trx.Statement{
+		{Trx: "label.sql", Query: "SELECT c FROM t WHERE id=1", ResultSet: true, Label: "point lookup"},
+		{Trx: "label.sql", Query: "SELECT c FROM t WHERE id=2", ResultSet: true},
+	}
+	if diff := deep.Equal(got.Statements["label.sql"], expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoad_Timeout(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "timeout.sql",
+			File: "../test/trx/timeout.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*trx.Statement{
+		{Trx: "timeout.sql", Query: "SELECT c FROM t WHERE id=1", ResultSet: true, Timeout: 500 * time.Millisecond},
+		{Trx: "timeout.sql", Query: "SELECT /*+ MAX_EXECUTION_TIME(2000) */ c FROM t WHERE id=2", ResultSet: true},
+	}
+	if diff := deep.Equal(got.Statements["timeout.sql"], expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoad_Warnings(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "warnings.sql",
+			File: "../test/trx/warnings.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Statements["warnings.sql"][0]; !s.Warnings {
+		t.Errorf("Warnings = false, expected true")
+	}
+	if s := got.Statements["warnings.sql"][1]; s.Warnings {
+		t.Errorf("Warnings = true, expected false")
+	}
+}
+
+func TestLoad_FetchAll(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "fetch-all.sql",
+			File: "../test/trx/fetch-all.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Statements["fetch-all.sql"][0]; !s.FetchAll {
+		t.Errorf("FetchAll = false, expected true")
+	}
+}
+
+func TestStatement_ForClient(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "client-number.sql",
+			File: "../test/trx/client-number.sql",
+		},
+	}
+
+	got, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := got.Statements["client-number.sql"]
+	if len(stmts) != 3 {
+		t.Fatalf("got %d statements, expected 3", len(stmts))
+	}
+
+	expect := map[uint][]string{
+		1: {"INSERT INTO t_1 VALUES (1)", "SELECT c FROM t_1 WHERE id=1"},
+		3: {"INSERT INTO t_3 VALUES (1)", "SELECT c FROM t_3 WHERE id=1"},
+		4: {"INSERT INTO t_4 VALUES (1)", "SELECT c FROM t_1 WHERE id=1"},
+		8: {"INSERT INTO t_8 VALUES (1)", "SELECT c FROM t_2 WHERE id=1"},
+	}
+	for clientNo, queries := range expect {
+		for i := range queries {
+			s := stmts[i].ForClient(clientNo)
+			if s == stmts[i] {
+				t.Errorf("client %d stmt %d: ForClient returned shared statement, expected copy", clientNo, i)
+			}
+			if s.Query != queries[i] {
+				t.Errorf("client %d stmt %d: got '%s', expected '%s'", clientNo, i, s.Query, queries[i])
+			}
+		}
+	}
+
+	// Shared statement not modified, and returned as-is if no substitutions
+	if stmts[0].Query != "INSERT INTO t_/*!client-number*/ VALUES (1)" {
+		t.Errorf("shared statement modified: %s", stmts[0].Query)
+	}
+	if s := stmts[2].ForClient(1); s != stmts[2] {
+		t.Errorf("ForClient returned copy of statement without substitutions")
+	}
+}
+
+func TestLoad_SavedColumnScope(t *testing.T) {
+	trxList := func(scope string, files ...string) []config.Trx {
+		list := make([]config.Trx, len(files))
+		for i, file := range files {
+			list[i] = config.Trx{
+				Name: file,
+				File: "../test/trx/" + file,
+				Data: map[string]config.Data{
+					"id": {Generator: "column", Scope: scope},
+				},
+			}
+		}
+		return list
+	}
+
+	// Client scope: saved in one trx file, used in another
+	got, err := trx.Load(trxList(finch.SCOPE_CLIENT, "save-col.sql", "use-col.sql"), data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got.Statements["use-col.sql"][0].Inputs, []string{"@id"}); diff != nil {
+		t.Error(diff)
+	}
+	if k := got.Data.Keys["@id"]; k.Trx != "save-col.sql" || k.Scope != finch.SCOPE_CLIENT {
+		t.Errorf("@id saved in %s with scope %s, expected save-col.sql and client", k.Trx, k.Scope)
+	}
+
+	// Errors
+	tests := []struct {
+		scope string
+		files []string
+	}{
+		{"", []string{"save-col.sql", "use-col.sql"}},                    // trx scope (default)
+		{finch.SCOPE_STATEMENT, []string{"save-col.sql", "use-col.sql"}}, // less than trx
+		{finch.SCOPE_CLIENT, []string{"use-col.sql", "save-col.sql"}},    // used before saved
+		{finch.SCOPE_STAGE, []string{"save-col.sql"}},                    // not used
+	}
+	for _, test := range tests {
+		if _, err := trx.Load(trxList(test.scope, test.files...), data.NewScope(), p); err == nil {
+			t.Errorf("no error for scope '%s' and files %v", test.scope, test.files)
+		}
+	}
+}
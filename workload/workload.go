@@ -4,6 +4,8 @@ package workload
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/square/finch"
@@ -23,13 +25,22 @@ import (
 //
 // Allocator modifies Workload.
 type Allocator struct {
-	Stage     uint
-	StageName string
-	TrxSet    *trx.Set             // config.stage.trx
-	Workload  []config.ClientGroup // config.stage.workload
-	StageQPS  limit.Rate           // config.stage.qps
-	StageTPS  limit.Rate           // config.stage.tps
-	DoneChan  chan *client.Client  // Stage.doneChan
+	Stage             uint
+	StageName         string
+	TrxSet            *trx.Set             // config.stage.trx
+	Workload          []config.ClientGroup // config.stage.workload
+	StageQPS          limit.Rate           // config.stage.qps
+	StageTPS          limit.Rate           // config.stage.tps
+	StageQPSPerSecond uint                 // config.stage.qps for correct-latency
+	StageTPSPerSecond uint                 // config.stage.tps for correct-latency
+	DoneChan          chan *client.Client  // Stage.doneChan
+	Lease             limit.LeaseFunc      // shared limits: config.stage.workload.iter-global
+	Clock             func() time.Time     // config.stats.clock (nil = time.Now)
+	Nanos             bool                 // config.stats.precision: ns
+	SharedStats       *stats.Shared        // config.stats.aggregate: trx (nil = per-client stats)
+	Warnings          bool                 // config.mysql.warnings
+	Throttle          *limit.Throttle      // config.stage.throttle
+	Pattern           *limit.Pattern       // config.stage.pattern
 }
 
 // ClientGroup is a runnable group of clients created from a config.ClientGroup.
@@ -38,9 +49,20 @@ type Allocator struct {
 //
 //	[]config.ClientGroup -> Groups -> Clients -> [][]workload.ClientGroup
 type ClientGroup struct {
-	Runtime   time.Duration // used by Stage to create a single ctx for all clients in the group
-	DataLimit bool
-	Clients   []*client.Client
+	ExecGroup  string        // exec group name, like "dml1"
+	Runtime    time.Duration // used by Stage to create a single ctx for all clients in the group
+	DataLimit  bool
+	Clients    []*client.Client
+	QueryLog   *client.QueryLog // config.stage.workload.query-log, if set
+	Tracer     *client.Tracer   // config.stage.workload.trace, if set
+	Rates      []limit.Rate     // unique QPS and TPS limiters used by clients, if any
+	Arrivals   *limit.Arrivals  // config.stage.workload.arrival-rate, if set
+	Iter       *limit.Iter      // exec group iteration progress, if all client groups have an iter limit
+	StartAfter time.Duration    // exec group start after stage start (first client group), or zero to wait for previous exec groups
+	DDL        bool             // exec group has DDL (any client group), so it doesn't run concurrently
+	TargetQPS  float64          // sum of clients' share of QPS limits, if rate limited
+	TargetTPS  float64          // sum of clients' share of TPS limits, if rate limited
+	Pattern    *limit.Pattern   // config.stage.workload.pattern, if set
 }
 
 // Group is allocation call 1 of 2 that returns a key for Clients to access
@@ -58,7 +80,7 @@ func (a *Allocator) Groups() ([][]int, error) {
 	prevHasDDL := true
 	prev := ""
 	for i := range a.Workload {
-		if len(a.Workload[i].Trx) == 0 {
+		if len(a.Workload[i].Trx) == 0 && a.Workload[i].Script == "" {
 			finch.Debug("cg %d: all trx", i)
 			a.Workload[i].Trx = a.TrxSet.Order
 		}
@@ -115,7 +137,13 @@ func (a *Allocator) Groups() ([][]int, error) {
 func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, error) {
 	finch.Debug("clients %v with stats %t", groups, withStats)
 
+	if err := a.setPartitions(groups); err != nil {
+		return nil, err
+	}
+
 	clients := make([][]ClientGroup, len(groups))
+	queryLogs := map[string]*client.QueryLog{} // client groups can share a file
+	tracers := map[string]*client.Tracer{}     // and an endpoint
 	runlevel := finch.RunLevel{
 		Stage:         a.Stage,
 		StageName:     a.StageName,
@@ -128,55 +156,155 @@ func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, er
 		Query:         0,
 	}
 
+	// Number of clients in each exec group and the stage for correct-latency
+	egClientsN := make([]uint, len(groups))
+	stageClients := uint(0)
+	for egNo := range groups {
+		for _, egRefNo := range groups[egNo] {
+			egClientsN[egNo] += finch.Uint(a.Workload[egRefNo].Clients)
+		}
+		stageClients += egClientsN[egNo]
+	}
+
 	for egNo := range groups { // ---------------------------------- EXEC GROUP
 		runlevel.ExecGroup = uint(egNo + 1)
+		egClients := egClientsN[egNo]
 
 		cgFirst := a.Workload[groups[egNo][0]]
 		runlevel.ExecGroupName = cgFirst.Group
 
 		// Wherever you see finch.Uint, the string value (e.g. "100") has already been
 		// validated, so this func is just a shortcut to return uint rather than uint, erroor.
-		execGroupQPS := limit.And(a.StageQPS, limit.NewRate(finch.Uint(cgFirst.QPSExecGroup)))
-		execGroupTPS := limit.And(a.StageTPS, limit.NewRate(finch.Uint(cgFirst.TPSExecGroup)))
+		egQPS := newRate(cgFirst.QPSExecGroup)
+		egTPS := newRate(cgFirst.TPSExecGroup)
+		execGroupQPS := limit.And(a.StageQPS, egQPS)
+		execGroupTPS := limit.And(a.StageTPS, egTPS)
 
 		clients[egNo] = make([]ClientGroup, len(groups[egNo]))
 
 		var execGroupIterPtr uint32
 
+		// Iterations for the exec group on all compute instances, if set
+		var globalIter *limit.SharedIter
+		if n := finch.Uint(cgFirst.IterGlobal); n > 0 {
+			globalIter = limit.NewSharedIter(fmt.Sprintf("iter/%s", cgFirst.Group), uint64(n), a.Lease)
+		}
+
+		egDDL := false
+		for _, egRefNo := range groups[egNo] {
+			if a.hasDDL(a.Workload[egRefNo].Trx) {
+				egDDL = true
+				break
+			}
+		}
+
+		// Iteration progress for the exec group, if stats and iterations are
+		// limited (nil otherwise). Not for DDL, which is usually 1 iteration.
+		var iterProgress *limit.Iter
+		if withStats && !a.hasDDL(cgFirst.Trx) {
+			iterProgress = limit.NewIter("iter "+cgFirst.Group, a.iterMax(groups[egNo]))
+		}
+
 		for cgNo, egRefNo := range groups[egNo] { // ------------- CLIENT GROUP
 			finch.Debug("alloc %d/%d eg ref %d", egNo, cgNo, egRefNo)
 			runlevel.ClientGroup = uint(cgNo + 1)
 			cg := a.Workload[egRefNo]
 
-			clientsQPS := limit.And(execGroupQPS, limit.NewRate(finch.Uint(cg.QPSClients)))
-			clientsTPS := limit.And(execGroupTPS, limit.NewRate(finch.Uint(cg.TPSClients)))
+			cgQPS := newRate(cg.QPSClients)
+			cgTPS := newRate(cg.TPSClients)
+			clientsQPS := limit.And(execGroupQPS, cgQPS)
+			clientsTPS := limit.And(execGroupTPS, cgTPS)
+
+			// Rates of the client group (not shared with other client groups)
+			// that its load pattern adjusts, if any. Exec group rates are set
+			// on the first client group, so its pattern adjusts them, too.
+			var patternRates []limit.Rate
+			if cg.Pattern != nil {
+				patternRates = []limit.Rate{cgQPS, cgTPS}
+				if cgNo == 0 {
+					patternRates = append(patternRates, egQPS, egTPS)
+				}
+			}
 
 			nClients := finch.Uint(cg.Clients)
 			clients[egNo][cgNo].Clients = make([]*client.Client, nClients)
 			clients[egNo][cgNo].Runtime, _ = time.ParseDuration(cg.Runtime) // already validated
+			clients[egNo][cgNo].StartAfter, _ = time.ParseDuration(cgFirst.StartAfter)
+			clients[egNo][cgNo].DDL = egDDL
+			clients[egNo][cgNo].ExecGroup = cgFirst.Group
 
 			var clientsIterPtr uint32
 
-			db, _, err := dbconn.Make() // stage already validated connection
+			if cg.QueryLog != "" {
+				qlog, ok := queryLogs[cg.QueryLog]
+				if !ok {
+					var err error
+					qlog, err = client.NewQueryLog(cg.QueryLog, finch.Uint(cg.QueryLogSample))
+					if err != nil {
+						return nil, fmt.Errorf("query-log: %s", err)
+					}
+					queryLogs[cg.QueryLog] = qlog
+				}
+				clients[egNo][cgNo].QueryLog = qlog
+			}
+
+			if cg.Trace != "" {
+				tracer, ok := tracers[cg.Trace]
+				if !ok {
+					tracer = client.NewTracer(cg.Trace, finch.Uint(cg.TraceSample))
+					tracers[cg.Trace] = tracer
+				}
+				clients[egNo][cgNo].Tracer = tracer
+			}
+
+			db, _, err := dbconn.MakeTransport(cg.Transport) // stage already validated connection
 			if err != nil {
 				return nil, err
 			}
 			if finch.ModifyDB != nil {
 				finch.ModifyDB(db, runlevel)
 			}
+			reader, _, err := dbconn.MakeReader() // nil if mysql.reader not set
+			if err != nil {
+				return nil, err
+			}
+			if reader != nil && finch.ModifyDB != nil {
+				finch.ModifyDB(reader, runlevel)
+			}
+
+			clients[egNo][cgNo].Arrivals = limit.NewArrivals(finch.Uint(cg.ArrivalRate)) // nil if not set
+			clients[egNo][cgNo].Iter = iterProgress
+
+			// Script instead of trx (config.stage.workload.script): compiled once,
+			// run by each client in its own Lua state
+			var script *client.Script
+			if cg.Script != "" {
+				script, err = client.LoadScript(cg.Script)
+				if err != nil {
+					return nil, fmt.Errorf("script: %s", err)
+				}
+			}
 
 			for k := uint(0); k < nClients; k++ { // ------------------- CLIENT
 				runlevel.Client = k + 1
 				c := &client.Client{
-					RunLevel:  runlevel,
-					DB:        db,         // *sql.DB
-					DefaultDb: cg.Db,      // default database
-					DoneChan:  a.DoneChan, // <- *Client
-					Iter:      finch.Uint(cg.Iter),
-					Stats:     make([]*stats.Trx, len(cg.Trx)), // Client requires slice but values can be nil
+					RunLevel:    runlevel,
+					DB:          db,                        // *sql.DB
+					DefaultDb:   clientDb(cg.Db, runlevel), // default database
+					Warnings:    a.Warnings,
+					DoneChan:    a.DoneChan, // <- *Client
+					Iter:        finch.Uint(cg.Iter),
+					Stats:       make([]*stats.Trx, len(cg.Trx)), // Client requires slice but values can be nil
+					QueryLog:    clients[egNo][cgNo].QueryLog,
+					Tracer:      clients[egNo][cgNo].Tracer,
+					Clock:       a.Clock,
+					Nanoseconds: a.Nanos,
+					Session:     client.SetSession(cg.Session),
+					Arrivals:    clients[egNo][cgNo].Arrivals,
 				}
 
 				// Set combined limits, if any: iterations, QPS, TPS
+				c.IterDelay, c.IterJitter, _ = config.ParseIterDelay(cg.IterDelay) // validated
 				if n := finch.Uint(cg.IterClients); n > 0 {
 					c.IterClients = uint32(n)
 					c.IterClientsPtr = &clientsIterPtr
@@ -185,25 +313,58 @@ func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, er
 					c.IterExecGroup = uint32(n)
 					c.IterExecGroupPtr = &execGroupIterPtr
 				}
-				if qps := limit.And(clientsQPS, limit.NewRate(finch.Uint(cg.QPS))); qps != nil {
+				c.IterGlobal = globalIter
+				c.IterProgress = iterProgress
+				clientQPS := newRate(cg.QPS)
+				clientTPS := newRate(cg.TPS)
+				if cg.Pattern != nil {
+					patternRates = append(patternRates, clientQPS, clientTPS)
+				}
+				if qps := limit.And(clientsQPS, clientQPS); qps != nil {
 					c.QPS = qps.Allow()
+					clients[egNo][cgNo].addRate(qps)
 				}
-				if tps := limit.And(clientsTPS, limit.NewRate(finch.Uint(cg.TPS))); tps != nil {
+				if tps := limit.And(clientsTPS, clientTPS); tps != nil {
 					c.TPS = tps.Allow()
+					clients[egNo][cgNo].addRate(tps)
+					if cg.TPSRefund {
+						c.TPSRefund = tps.Refund
+					}
+				}
+				if a.Throttle != nil {
+					c.Throttle = a.Throttle
+				}
+				if a.Pattern != nil && a.Pattern.Gate() != nil {
+					c.Pattern = append(c.Pattern, a.Pattern.Gate())
 				}
 
 				// Copy statements from transactions assigned to this client,
 				// which can be a subset of all trx (config.stage.trx) and in
 				// a different order.
 				n := 0
-				for _, trxName := range cg.Trx {
+				wrap := make([]bool, len(cg.Trx))
+				for trxNo, trxName := range cg.Trx {
 					n += len(a.TrxSet.Statements[trxName])
+					if cg.WrapTrx && !a.explicitTrx(trxName) {
+						wrap[trxNo] = true
+						n += 2 // BEGIN and COMMIT
+					}
 				}
 				c.Statements = make([]*trx.Statement, n)
 				c.Data = make([]client.StatementData, n)
+				switch cg.Measure {
+				case "sampled":
+					c.Measure = client.MeasureSampled
+					c.MeasureSample = finch.Uint(cg.MeasureSample)
+				case "none":
+					c.Measure = client.MeasureNone
+				}
+				c.Weights = a.weights(cg.Trx)
+				c.Retry = a.retry(cg.Trx)
 				finch.Debug("%s", runlevel.ClientId())
 
 				calledDataKeys := map[string]bool{}
+				labels := map[string]*stats.Trx{} // -- label stats for this client
 				runlevel.Trx = 0
 				n = 0 // stmt number all trx
 
@@ -217,18 +378,36 @@ func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, er
 					// Stats for this trx if stage.stats=true and disable-status=false
 					// for this client group
 					if withStats && !cg.DisableStats {
-						c.Stats[trxNo] = stats.NewTrx(trxName)
+						c.Stats[trxNo] = a.trxStats(cgFirst.Group, trxName)
+					}
+
+					if wrap[trxNo] {
+						c.Statements[n] = &trx.Statement{Trx: trxName, Query: "BEGIN", Begin: true}
+						n++
 					}
 
 					for _, stmt := range a.TrxSet.Statements[trxName] { // STMT
 						runlevel.Query += 1
 						finch.Debug("--- %s", runlevel)
-						c.Statements[n] = stmt // *Statement pointer; don't modify
+						stmt = stmt.ForClient(runlevel.Client) // copy if /*!client-number*/ or /*!shard N*/
+						c.Statements[n] = stmt                 // *Statement pointer; don't modify
+
+						if stmt.Label != "" && c.Stats[trxNo] != nil {
+							if c.Labels == nil {
+								c.Labels = make([]*stats.Trx, len(c.Statements))
+							}
+							if _, ok := labels[stmt.Label]; !ok {
+								labels[stmt.Label] = a.labelStats(stmt.Label)
+							}
+							c.Labels[n] = labels[stmt.Label]
+						}
 
 						if len(stmt.Inputs) > 0 {
 							c.Data[n].Inputs = []data.ValueFunc{}
+							c.Data[n].Previews = []data.ValueFunc{}
 							for ino, dataKey := range stmt.Inputs {
 								if g := a.TrxSet.Data.Copy(dataKey, runlevel); g != nil {
+									c.Data[n].Previews = append(c.Data[n].Previews, g.Preview)
 									if stmt.Calls[ino] == 1 { // explicit call
 										c.Data[n].Inputs = append(c.Data[n].Inputs, g.Call)
 									} else { // call when scope changes, else copy
@@ -259,6 +438,13 @@ func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, er
 							}
 						}
 
+						if stmt.If != nil {
+							if g := a.TrxSet.Data.Copy(stmt.If.Input, runlevel); g != nil {
+								c.Data[n].If = g.Values
+								finch.Debug("    if %s: %s", stmt.If, g.Id().String())
+							}
+						}
+
 						if stmt.InsertId != "" {
 							g := a.TrxSet.Data.Copy(stmt.InsertId, runlevel)
 							c.Data[n].InsertId = g
@@ -270,26 +456,87 @@ func (a *Allocator) Clients(groups [][]int, withStats bool) ([][]ClientGroup, er
 							finch.Debug("    trx %s has data limit", trxName)
 						}
 
+						if stmt.Reader {
+							if reader == nil {
+								return nil, fmt.Errorf("trx %s uses -- reader but mysql.reader is not set", trxName)
+							}
+							c.Reader = reader
+						}
+
 						n++ // stmt number all trx
 					} // stmt
+
+					if wrap[trxNo] {
+						c.Statements[n] = &trx.Statement{Trx: trxName, Query: "COMMIT", Commit: true}
+						n++
+					}
 					c.Data[n-1].TrxBoundary |= trx.END // finch trx file, not MySQL trx
 				} // trx
 
 				if len(calledDataKeys) > 0 {
 				}
 
+				if script != nil {
+					c.Script = script
+					c.Stats = make([]*stats.Trx, 1) // event stats, like a trx file
+					if withStats && !cg.DisableStats {
+						c.Stats[0] = a.trxStats(cgFirst.Group, script.Name)
+					}
+				}
+
+				qps, tps := a.rates(cg, cgFirst, nClients, egClients, stageClients)
+				clients[egNo][cgNo].TargetQPS += qps
+				clients[egNo][cgNo].TargetTPS += tps
+				if cg.CorrectLatency {
+					c.Interval = a.interval(c, cg, cgFirst, nClients, egClients, stageClients)
+				}
+
 				clients[egNo][cgNo].Clients[k] = c
 			} // client
+
+			if cg.Pattern != nil {
+				p := NewPattern(*cg.Pattern, patternRates)
+				if p.Gate() != nil {
+					for _, c := range clients[egNo][cgNo].Clients {
+						c.Pattern = append(c.Pattern, p.Gate())
+					}
+				}
+				clients[egNo][cgNo].Pattern = p
+			}
 		} // client group
 	} // exec group
 
 	return clients, nil
 }
 
+// addRate adds the rate limiter to Rates if it's not already added. Clients in
+// the group can share a rate limiter (like qps-clients).
+func (cg *ClientGroup) addRate(r limit.Rate) {
+	for i := range cg.Rates {
+		if cg.Rates[i] == r {
+			return
+		}
+	}
+	cg.Rates = append(cg.Rates, r)
+}
+
 func (a *Allocator) AutoAssign() []config.ClientGroup {
 	cg := []config.ClientGroup{}
 	prevHasDDL := true
 	for _, trxName := range a.TrxSet.Order {
+		if a.TrxSet.Meta[trxName].Replay {
+			// Replayed connection: one client, once, and all connections in
+			// the same exec group so they run concurrently like the original
+			finch.Debug("auto: %s (replay)", trxName)
+			cg = append(cg, config.ClientGroup{
+				Group:   "replay",
+				Clients: "1",
+				Iter:    "1",
+				Trx:     []string{trxName},
+			})
+			prevHasDDL = true // next non-replay trx starts new client group
+			continue
+		}
 		if a.TrxSet.Meta[trxName].DDL {
 			// Trx with DDL, must exec alone
 			finch.Debug("auto: %s (DDL)", trxName)
@@ -319,6 +566,241 @@ func (a *Allocator) AutoAssign() []config.ClientGroup {
 	return cg
 }
 
+// clientDb returns the client group default database (config.stage.workload.db)
+// for one client: {client} and {client-group} are replaced by the client number
+// in the client group and the client group number, like "finch_{client}" ->
+// "finch_3", so each client (or client group) uses its own database.
+func clientDb(db string, rl finch.RunLevel) string {
+	if !strings.Contains(db, "{") {
+		return db
+	}
+	return strings.NewReplacer(
+		config.DB_CLIENT, strconv.FormatUint(uint64(rl.Client), 10),
+		config.DB_CLIENT_GROUP, strconv.FormatUint(uint64(rl.ClientGroup), 10),
+	).Replace(db)
+}
+
+// trxStats returns stats for one client executing the trx: new stats for the
+// client, or the next shard of shared stats if config.stats.aggregate is trx.
+func (a *Allocator) trxStats(execGroup, name string) *stats.Trx {
+	if a.SharedStats != nil {
+		return a.SharedStats.Trx(execGroup, name)
+	}
+	t := stats.NewTrx(name)
+	t.ExecGroup = execGroup
+	return t
+}
+
+// labelStats is like trxStats for -- label stats.
+func (a *Allocator) labelStats(name string) *stats.Trx {
+	if a.SharedStats != nil {
+		return a.SharedStats.Label(name)
+	}
+	return stats.NewLabel(name)
+}
+
+// weights returns the weight of each trx, or nil if no trx has a weight (execute
+// all trx each iteration). Trx without a weight have weight 1.
+func (a *Allocator) weights(trxNames []string) []uint {
+	weights := make([]uint, len(trxNames))
+	weighted := false
+	for i, trxName := range trxNames {
+		weights[i] = a.TrxSet.Meta[trxName].Weight
+		if weights[i] > 0 {
+			weighted = true
+		} else {
+			weights[i] = 1
+		}
+	}
+	if !weighted {
+		return nil
+	}
+	return weights
+}
+
+// retry returns the number of retries for each trx, or nil if no trx retries.
+func (a *Allocator) retry(trxNames []string) []uint {
+	retry := make([]uint, len(trxNames))
+	has := false
+	for i, trxName := range trxNames {
+		retry[i] = a.TrxSet.Meta[trxName].Retry
+		has = has || retry[i] > 0
+	}
+	if !has {
+		return nil
+	}
+	return retry
+}
+
+// iterMax returns the total number of iterations for the exec group (the client
+// group refs in eg), or 0 if unknown because a client group has no iteration
+// limit. iter-global is not counted because it's shared by all compute instances.
+func (a *Allocator) iterMax(eg []int) uint64 {
+	if n := finch.Uint(a.Workload[eg[0]].IterExecGroup); n > 0 {
+		return uint64(n)
+	}
+	var max uint64
+	for _, refNo := range eg {
+		cg := a.Workload[refNo]
+		n := uint64(finch.Uint(cg.Iter)) * uint64(finch.Uint(cg.Clients))
+		if c := uint64(finch.Uint(cg.IterClients)); c > 0 && (n == 0 || c < n) {
+			n = c
+		}
+		if n == 0 {
+			return 0
+		}
+		max += n
+	}
+	return max
+}
+
+// interval returns the expected interval between measured queries for client c
+// (config.workload.correct-latency), in microseconds or nanoseconds (Nanos), or
+// 0 if the client isn't rate limited. It's the inverse of the client's share of
+// the lowest QPS limit, or TPS limit times the number of queries per BEGIN if
+// lower, because clients sharing a limit (like qps-clients) are expected to
+// execute at an equal share of the rate.
+func (a *Allocator) interval(c *client.Client, cg, cgFirst config.ClientGroup, nClients, egClients, stageClients uint) int64 {
+	qps, tps := a.rates(cg, cgFirst, nClients, egClients, stageClients)
+	if tps > 0 {
+		nBegin := 0
+		for _, s := range c.Statements {
+			if s.Begin {
+				nBegin++
+			}
+		}
+		if nBegin > 0 { // TPS limits only BEGIN
+			qps = minRate(qps, tps*float64(len(c.Statements))/float64(nBegin))
+		}
+	}
+	if qps == 0 {
+		return 0
+	}
+	d := time.Duration(float64(time.Second) / qps)
+	if c.Measure == client.MeasureSampled {
+		d *= time.Duration(c.MeasureSample) // 1 in MeasureSample queries measured
+	}
+	if a.Nanos {
+		return d.Nanoseconds()
+	}
+	return d.Microseconds()
+}
+
+// rates returns one client's share of the lowest QPS and TPS limits, or 0 if
+// not limited. Clients sharing a limit (like qps-clients) are expected to execute
+// at an equal share of the rate.
+func (a *Allocator) rates(cg, cgFirst config.ClientGroup, nClients, egClients, stageClients uint) (qps, tps float64) {
+	qps = minRate(
+		perClient(perSecond(cg.QPS), 1),
+		perClient(perSecond(cg.QPSClients), nClients),
+		perClient(perSecond(cgFirst.QPSExecGroup), egClients),
+		perClient(a.StageQPSPerSecond, stageClients),
+	)
+	tps = minRate(
+		perClient(perSecond(cg.TPS), 1),
+		perClient(perSecond(cg.TPSClients), nClients),
+		perClient(perSecond(cgFirst.TPSExecGroup), egClients),
+		perClient(a.StageTPSPerSecond, stageClients),
+	)
+	return qps, tps
+}
+
+// NewPattern returns a load pattern for the config that adjusts the rates, if
+// any (config.stage.pattern or config.stage.workload.pattern).
+func NewPattern(cfg config.Pattern, rates []limit.Rate) *limit.Pattern {
+	on, _ := time.ParseDuration(cfg.On) // already validated
+	off, _ := time.ParseDuration(cfg.Off)
+	sine, _ := time.ParseDuration(cfg.Sine)
+	return limit.NewPattern(on, off, sine, finch.Uint(cfg.Min), rates)
+}
+
+// newRate returns a rate limiter for a QPS or TPS config value (config.ParseRate),
+// or nil if there's no limit.
+func newRate(s string) limit.Rate {
+	n, burst, _ := config.ParseRate(s) // already validated
+	return limit.NewRate(n, burst)
+}
+
+// perSecond returns the rate of a QPS or TPS config value without burst, or 0 if
+// there's no limit.
+func perSecond(s string) uint {
+	n, _, _ := config.ParseRate(s) // already validated
+	return n
+}
+
+// perClient returns one client's share of a rate limit shared by n clients,
+// or 0 if there's no limit.
+func perClient(perSecond, n uint) float64 {
+	if perSecond == 0 || n == 0 {
+		return 0
+	}
+	return float64(perSecond) / float64(n)
+}
+
+// minRate returns the lowest non-zero rate, or 0 if all are zero (no limits).
+func minRate(rates ...float64) float64 {
+	min := 0.0
+	for _, r := range rates {
+		if r > 0 && (min == 0 || r < min) {
+			min = r
+		}
+	}
+	return min
+}
+
+// explicitTrx returns true if the trx has BEGIN, COMMIT, ROLLBACK, or DDL,
+// in which case workload.wrap-trx doesn't wrap it.
+func (a *Allocator) explicitTrx(trxName string) bool {
+	for _, s := range a.TrxSet.Statements[trxName] {
+		if s.Begin || s.Commit || s.Rollback || s.DDL {
+			return true
+		}
+	}
+	return false
+}
+
+// setPartitions sets the partitions of int-partition data generators: one per
+// client in the client groups that use the data key, in all exec groups, so
+// clients never share a partition.
+func (a *Allocator) setPartitions(groups [][]int) error {
+	for name, k := range a.TrxSet.Data.Keys {
+		g, ok := k.Generator.(*data.IntPartition)
+		if !ok {
+			continue
+		}
+		first := map[[2]uint]int64{}
+		var n int64
+		for egNo := range groups {
+			for cgNo, egRefNo := range groups[egNo] {
+				cg := a.Workload[egRefNo]
+				if !a.usesKey(cg.Trx, name) {
+					continue
+				}
+				first[[2]uint{uint(egNo + 1), uint(cgNo + 1)}] = n
+				n += int64(finch.Uint(cg.Clients))
+			}
+		}
+		if err := g.SetPartitions(first, n); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// usesKey returns true if any statement in the trx has the data key as input.
+func (a *Allocator) usesKey(trxNames []string, dataKey string) bool {
+	for _, trxName := range trxNames {
+		for _, stmt := range a.TrxSet.Statements[trxName] {
+			for _, in := range stmt.Inputs {
+				if in == dataKey {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (a *Allocator) hasDDL(trxNames []string) bool {
 	for _, trxName := range trxNames {
 		if a.TrxSet.Meta[trxName].DDL {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
@@ -117,7 +118,8 @@ func TestGroups_SetupOne(t *testing.T) {
 	expectClients := [][]workload.ClientGroup{
 		{ // exec grp 0
 			{ // client grp 0
-				Runtime: 0,
+				ExecGroup: "dml1",
+				Runtime:   0,
 				Clients: []*client.Client{
 					{ // client 0
 						RunLevel: r,
@@ -239,7 +241,7 @@ func TestGroups_PartialAlloc(t *testing.T) {
 }
 
 func TestGroups_ClientGroups(t *testing.T) {
-	stage, err := config.Load([]string{"../test/run/scope/workload_cg_alloc.yaml"}, nil, "dsn", "db")
+	stage, err := config.Load([]string{"../test/run/scope/workload_cg_alloc.yaml"}, nil, "dsn", "db", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -247,9 +249,14 @@ func TestGroups_ClientGroups(t *testing.T) {
 		t.Fatalf("loaded %d stages, expected 1", len(stage))
 	}
 
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if err := os.Chdir("../test/run/scope/"); err != nil {
 		t.Fatal(err)
 	}
+	defer os.Chdir(wd)
 
 	scope := data.NewScope()
 	set, err := trx.Load(stage[0].Trx, scope, p)
@@ -305,3 +312,406 @@ func TestGroups_ClientGroups(t *testing.T) {
 		}
 	}
 }
+
+func TestClients_WrapTrx(t *testing.T) {
+	// copy-no.sql has two SELECT and no BEGIN, so it's wrapped; savepoint.sql
+	// has BEGIN and ROLLBACK, so it's not wrapped
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+		{Name: "savepoint.sql", File: "../test/trx/savepoint.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "wrap",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Clients: "1",
+				Trx:     []string{"copy-no.sql", "savepoint.sql"},
+				WrapTrx: true,
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || len(clients[0]) != 1 || len(clients[0][0].Clients) != 1 {
+		t.Fatalf("expected 1 client, got %#v", clients)
+	}
+	c := clients[0][0].Clients[0]
+
+	gotQueries := make([]string, len(c.Statements))
+	gotBoundary := make([]byte, len(c.Data))
+	for i := range c.Statements {
+		gotQueries[i] = c.Statements[i].Query
+		gotBoundary[i] = c.Data[i].TrxBoundary
+	}
+	expectQueries := []string{
+		"BEGIN",
+		"select c from t1 where id=1",
+		"select c from t2 where id=1",
+		"COMMIT",
+		"BEGIN",
+		"INSERT INTO t VALUES (1)",
+		"UPDATE t SET c=2 WHERE id=1",
+		"ROLLBACK TO SAVEPOINT sp1",
+		"ROLLBACK",
+	}
+	if diff := deep.Equal(gotQueries, expectQueries); diff != nil {
+		t.Error(diff)
+		t.Logf("got: %#v", gotQueries)
+	}
+	expectBoundary := []byte{trx.BEGIN, 0, 0, trx.END, trx.BEGIN, 0, 0, 0, trx.END}
+	if diff := deep.Equal(gotBoundary, expectBoundary); diff != nil {
+		t.Error(diff)
+	}
+	if !c.Statements[0].Begin || !c.Statements[3].Commit {
+		t.Errorf("wrapped BEGIN and COMMIT not set: %+v, %+v", c.Statements[0], c.Statements[3])
+	}
+}
+
+func TestClients_DbTemplate(t *testing.T) {
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "db",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Clients: "2",
+				Trx:     []string{"copy-no.sql"},
+				Db:      "finch_{client}",
+			},
+			{
+				Clients: "1",
+				Trx:     []string{"copy-no.sql"},
+				Db:      "g{client-group}_c{client}",
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, cg := range clients[0] {
+		for _, c := range cg.Clients {
+			got = append(got, c.DefaultDb)
+		}
+	}
+	if diff := deep.Equal(got, []string{"finch_1", "finch_2", "g2_c1"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestClients_CorrectLatency(t *testing.T) {
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 clients share 100 QPS, so each is expected to execute 50 QPS: 20ms
+	// between queries
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "correct",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Clients:        "2",
+				QPSClients:     "100",
+				CorrectLatency: true,
+				Trx:            []string{"copy-no.sql"},
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients[0][0].Clients {
+		if c.Interval != 20000 {
+			t.Errorf("%s: got interval %d, expected 20000 (μs)", c.RunLevel.ClientId(), c.Interval)
+		}
+	}
+
+	// Plus 10 TPS with wrap-trx (4 queries per BEGIN) is 40 QPS: 25ms
+	a.Workload[0].TPS = "10"
+	a.Workload[0].WrapTrx = true
+	clients, err = a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := clients[0][0].Clients[0]; c.Interval != 25000 {
+		t.Errorf("got interval %d, expected 25000 (μs)", c.Interval)
+	}
+
+	// No limits: nothing to correct
+	a.Workload[0].QPSClients = ""
+	a.Workload[0].TPS = ""
+	clients, err = a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := clients[0][0].Clients[0]; c.Interval != 0 {
+		t.Errorf("got interval %d, expected 0 without QPS or TPS limits", c.Interval)
+	}
+}
+
+func TestClients_Target(t *testing.T) {
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 clients share 100 QPS, and each has 10 TPS: target is the sum of each
+	// client's share
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "target",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Clients:    "2",
+				QPSClients: "100",
+				TPS:        "10",
+				WrapTrx:    true,
+				Trx:        []string{"copy-no.sql"},
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cg := clients[0][0]; cg.TargetQPS != 100 || cg.TargetTPS != 20 {
+		t.Errorf("got target QPS %f, TPS %f, expected 100 and 20", cg.TargetQPS, cg.TargetTPS)
+	}
+	if c := clients[0][0].Clients[0]; c.TPSRefund != nil {
+		t.Errorf("TPSRefund set, expected nil without tps-refund")
+	}
+
+	// tps-refund: clients refund TPS allowances to their limiter
+	a.Workload[0].TPSRefund = true
+	clients, err = a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := clients[0][0].Clients[0]; c.TPSRefund == nil {
+		t.Errorf("TPSRefund not set with tps-refund")
+	}
+}
+
+func TestClients_IterProgress(t *testing.T) {
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same exec group: 2 clients * 10 iter + 5 iter-clients = 25 iterations
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "iter",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Group:   "g",
+				Clients: "2",
+				Iter:    "10",
+				Trx:     []string{"copy-no.sql"},
+			},
+			{
+				Group:       "g",
+				Clients:     "3",
+				IterClients: "5",
+				Trx:         []string{"copy-no.sql"},
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || len(clients[0]) != 2 {
+		t.Fatalf("expected 1 exec group with 2 client groups, got %d", len(clients))
+	}
+	it := clients[0][0].Iter
+	if it == nil {
+		t.Fatal("exec group iteration progress is nil")
+	}
+	if clients[0][1].Iter != it || clients[0][1].Clients[0].IterProgress != it {
+		t.Error("client groups and clients in exec group do not share iteration progress")
+	}
+	it.Start()
+	if p := it.Progress(); len(p) != 1 || p[0].Max != 25 || p[0].Limit != "iter g" {
+		t.Errorf("wrong progress: %+v, expected max 25", p)
+	}
+
+	// One client group without an iteration limit: total unknown
+	a.Workload[1].IterClients = ""
+	clients, err = a.Clients(groups, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients[0][0].Iter != nil {
+		t.Errorf("got iteration progress, expected nil when a client group has no iter limit")
+	}
+}
+
+func TestClients_StartAfter(t *testing.T) {
+	trxList := []config.Trx{
+		{Name: "copy-no.sql", File: "../test/trx/copy-no.sql"},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exec group start-after is set by its first client group
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "start-after",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{
+				Group:   "oltp",
+				Clients: "1",
+				Trx:     []string{"copy-no.sql"},
+			},
+			{
+				Group:      "batch",
+				Clients:    "1",
+				StartAfter: "30s",
+				Trx:        []string{"copy-no.sql"},
+			},
+			{
+				Group:   "batch",
+				Clients: "1",
+				Trx:     []string{"copy-no.sql"},
+			},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || len(clients[1]) != 2 {
+		t.Fatalf("expected 2 exec groups, the second with 2 client groups, got %d", len(clients))
+	}
+	if clients[0][0].StartAfter != 0 {
+		t.Errorf("exec group 1 start-after %s, expected 0", clients[0][0].StartAfter)
+	}
+	if clients[1][0].StartAfter != 30*time.Second || clients[1][1].StartAfter != 30*time.Second {
+		t.Errorf("exec group 2 start-after %s and %s, expected 30s", clients[1][0].StartAfter, clients[1][1].StartAfter)
+	}
+}
+
+func TestClients_IntPartition(t *testing.T) {
+	trxList := []config.Trx{
+		{
+			Name: "001.sql",
+			File: "../test/trx/001.sql",
+			Data: map[string]config.Data{
+				"id": {
+					Generator: "int-partition",
+					Params:    map[string]string{"min": "1", "max": "100", "order": "seq"},
+				},
+			},
+		},
+	}
+	set, err := trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 exec groups with 1 client group of 2 clients each: 4 partitions of 25
+	// values, unique across exec groups although both number client groups from 1
+	a := workload.Allocator{
+		Stage:     1,
+		StageName: "int-partition",
+		TrxSet:    set,
+		Workload: []config.ClientGroup{
+			{Group: "a", Clients: "2", Trx: []string{"001.sql"}},
+			{Group: "b", Clients: "2", Trx: []string{"001.sql"}},
+		},
+	}
+	groups, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := a.Clients(groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int64{}
+	for egNo := range clients {
+		for _, c := range clients[egNo][0].Clients {
+			var rc data.RunCount
+			rc[data.STATEMENT] = 1
+			rc[data.CLIENT] = c.RunLevel.Client
+			rc[data.CLIENT_GROUP] = c.RunLevel.ClientGroup
+			rc[data.EXEC_GROUP] = c.RunLevel.ExecGroup
+			got = append(got, c.Data[0].Inputs[0](rc)[0].(int64))
+		}
+	}
+	if diff := deep.Equal(got, []int64{1, 26, 51, 76}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Param clients less than the 4 clients that use it is an error
+	trxList[0].Data["id"].Params["clients"] = "2"
+	set, err = trx.Load(trxList, data.NewScope(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.TrxSet = set
+	if _, err := a.Clients(groups, false); err == nil {
+		t.Error("no error with int-partition clients=2 for 4 clients, expected an error")
+	}
+}